@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/kmsplugin"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/version"
+)
+
+// runNodeAgent implements the node-agent subcommand: it runs on every control plane node, checks the
+// KMS plugins listening on the node and stores their health in the ConfigMap shared by all nodes.
+func runNodeAgent(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("node-agent", flag.ExitOnError)
+	kubeconfigPath := flags.String("kubeconfig", "", "Path to the kubeconfig file. Defaults to the in-cluster config, then to the standard kubeconfig loading rules")
+	healthNamespace := flags.String("namespace", "", "The namespace of the ConfigMap the plugin health is stored in")
+	node := flags.String("node-name", "", "The node the agent runs on. Defaults to $NODE_NAME")
+	sockets := flags.String("kms-plugin-socket", kmsplugin.DefaultSocket, "Comma-separated paths of the unix sockets of the KMS plugins of the node")
+	pluginTimeout := flags.Duration("kms-plugin-timeout", kmsplugin.DefaultTimeout, "The timeout of the Status call of each KMS plugin")
+	interval := flags.Duration("interval", time.Minute, "The interval between two checks of the plugins")
+	requestTimeout := flags.Duration("kube-request-timeout", 10*time.Second, "The timeout of each Kubernetes API call storing the plugin health")
+	selfCheck := flags.Bool("rbac-self-check", true, "Verify at startup that the agent has every RBAC permission it needs and fail fast otherwise")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *healthNamespace == "" {
+		return fmt.Errorf("--namespace is required")
+	}
+	if *node == "" {
+		*node = os.Getenv("NODE_NAME")
+	}
+	if *node == "" {
+		return fmt.Errorf("--node-name or $NODE_NAME is required")
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("Invalid --interval %s: must be positive", *interval)
+	}
+
+	checker, err := kmsplugin.NewChecker(kmsplugin.Config{Sockets: splitList(*sockets), Timeout: *pluginTimeout})
+	if err != nil {
+		return fmt.Errorf("Failed to create KMS plugin checker: %w", err)
+	}
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = *kubeconfigPath
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("Failed to load kubeconfig: %w", err)
+	}
+	config.UserAgent = version.UserAgent("node-agent")
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("Failed to create k8s client: %w", err)
+	}
+	if *selfCheck {
+		if err := rbac.Check(ctx, clientset, kmsplugin.RequiredPermissions(*healthNamespace)); err != nil {
+			return err
+		}
+	}
+	healthRecorder := kmsplugin.NewRecorder(clientset, kmsplugin.RecorderConfig{Namespace: *healthNamespace, NodeName: *node, RequestTimeout: *requestTimeout})
+
+	klog.InfoS("Starting KMS plugin node agent", "node", *node, "sockets", *sockets, "interval", *interval)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		checkPlugins(ctx, checker, healthRecorder)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkPlugins checks the plugins of the node once and stores their health. Failures are logged and
+// retried at the next check.
+func checkPlugins(ctx context.Context, checker *kmsplugin.Checker, healthRecorder *kmsplugin.Recorder) {
+	health := kmsplugin.NodeHealth{Plugins: checker.Check(ctx), CheckedAt: time.Now().UTC()}
+	for _, plugin := range health.Plugins {
+		if plugin.Healthy {
+			klog.V(2).InfoS("KMS plugin is healthy", "socket", plugin.Socket, "version", plugin.Version, "keyID", plugin.KeyID)
+			continue
+		}
+		klog.InfoS("KMS plugin is unhealthy", "socket", plugin.Socket, "healthz", plugin.Healthz, "error", plugin.Error)
+	}
+	if err := healthRecorder.Record(ctx, health); err != nil {
+		klog.ErrorS(err, "Failed to store KMS plugin health")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/bench"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// runBench implements the bench subcommand: it generates synthetic secrets, in memory or in a test
+// etcd, and measures the scan throughput and memory use of the analyzer.
+func runBench(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	secrets := flags.Int("secrets", 100000, "The number of synthetic secrets to generate")
+	unencryptedPercent := flags.Float64("unencrypted-percent", 1, "The percentage of secrets stored without encryption")
+	namespaces := flags.Int("namespaces", bench.DefaultNamespaces, "The number of namespaces the secrets are spread across")
+	valueSize := flags.Int("value-size", bench.DefaultValueSize, "The size of each secret value in bytes")
+	seed := flags.Int64("seed", 1, "The seed of the generated secrets")
+	runs := flags.Int("runs", 3, "The number of scans to measure")
+	pageSize := flags.Int64("etcd-page-size", 0, "The maximum number of keys read per request. 0 reads all secrets in a single request")
+	maxNames := flags.Int("max-secret-names", 0, "The maximum number of secret names kept per list, as in the reporter. 0 keeps every name")
+	prefix := flags.String("prefix", bench.DefaultPrefix, "The key prefix the secrets are generated under")
+	endpoint := flags.String("etcd-endpoint", "", "A test etcd to populate and scan. Empty scans an in-memory fixture")
+	clientCrt := flags.String("etcd-client-crt", "", "The etcd client certificate")
+	clientKey := flags.String("etcd-client-key", "", "The etcd client key")
+	clientCaCrt := flags.String("etcd-client-ca-crt", "", "The etcd client CA certificate")
+	populate := flags.Bool("populate", true, "Write the secrets to --etcd-endpoint before scanning. Disable to rescan a populated etcd")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	kvs := bench.GenerateFixture(bench.FixtureConfig{
+		Secrets:            *secrets,
+		UnencryptedPercent: *unencryptedPercent,
+		Prefix:             *prefix,
+		Namespaces:         *namespaces,
+		ValueSize:          *valueSize,
+		Seed:               *seed,
+	})
+
+	var source analyzer.Source
+	if *endpoint == "" {
+		source = etcd.NewMemoryClient(kvs)
+	} else {
+		client, err := etcd.CreateEtcdClient(*endpoint, *clientCrt, *clientKey, *clientCaCrt, etcd.ClientOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to create etcd client: %w", err)
+		}
+		defer client.Close()
+		if *populate {
+			if err := populateEtcd(ctx, client, kvs); err != nil {
+				return err
+			}
+		}
+		source = client
+	}
+	// Only the scan is measured, not the generated fixture
+	kvs = nil
+
+	matcher, err := utils.NewProviderNameMatcher("kmsprovider", "")
+	if err != nil {
+		return err
+	}
+	latest := bench.DefaultProviders[len(bench.DefaultProviders)-1]
+	seq, err := matcher.Seq(latest)
+	if err != nil {
+		return err
+	}
+	config := analyzer.Config{
+		Prefix:          *prefix,
+		PageSize:        *pageSize,
+		MaxSecretNames:  *maxNames,
+		ProviderMatcher: matcher,
+		LatestProvider:  analyzer.StaticProvider(analyzer.LatestProvider{Name: latest, Seq: seq}),
+	}
+
+	for i := 1; i <= *runs; i++ {
+		stats, err := bench.Run(ctx, source, config)
+		if err != nil {
+			return fmt.Errorf("Failed to scan: %w", err)
+		}
+		fmt.Printf("run %d: %s\n", i, stats)
+	}
+	return nil
+}
+
+// populateEtcd writes the fixture to etcd, which must accept writes.
+func populateEtcd(ctx context.Context, client etcd.EtcdClientOperator, kvs []*mvccpb.KeyValue) error {
+	putter, ok := client.(bench.Putter)
+	if !ok {
+		return fmt.Errorf("etcd client does not support writes")
+	}
+	klog.InfoS("Populating etcd", "secrets", len(kvs))
+	if err := bench.Populate(ctx, putter, kvs, analyzer.DefaultTimeout); err != nil {
+		return fmt.Errorf("Failed to populate etcd: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/doctor"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/kmsplugin"
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+	"github.com/lzhecheng/kms-reporter/pkg/teams"
+)
+
+// doctorEtcdTimeout bounds the etcd read of the doctor when --etcd-request-timeout is unset, like a
+// single page of a scan
+const doctorEtcdTimeout = 5 * time.Second
+
+// runDoctor implements the doctor subcommand: given the flags of the reporter, it checks everything a run
+// needs, from the etcd certificates to the writing of the report, and prints whether each check passed.
+func runDoctor(ctx context.Context, args []string) error {
+	sockets := flag.String("kms-plugin-socket", "", "Comma-separated paths of the unix sockets of the KMS plugins to check. Defaults to the unix socket endpoints of the KMS providers of the encryption configuration")
+	pluginTimeout := flag.Duration("kms-plugin-timeout", kmsplugin.DefaultTimeout, "The timeout of the Status call of each KMS plugin")
+	minCertValidity := flag.Duration("min-cert-validity", 30*24*time.Hour, "Fail the check of a certificate expiring within this duration")
+	klog.InitFlags(nil)
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+
+	discoveryMode, err := etcd.ParseDiscoveryMode(*etcdDiscovery)
+	if err != nil {
+		return err
+	}
+	reportNode, err := buildStaticPodMode(&discoveryMode)
+	if err != nil {
+		return fmt.Errorf("Failed to configure %s mode: %w", *deploymentMode, err)
+	}
+	shardConfig, err := buildShardConfig()
+	if err != nil {
+		return fmt.Errorf("Failed to configure sharding: %w", err)
+	}
+	_, extraResources, err := buildExtraPrefixes(shardConfig)
+	if err != nil {
+		return fmt.Errorf("Invalid --extra-etcd-prefixes: %w", err)
+	}
+	var teamList []teams.Team
+	if *teamsFile != "" {
+		if teamList, err = teams.ReadTeams(*teamsFile); err != nil {
+			return fmt.Errorf("Invalid --teams-file: %w", err)
+		}
+	}
+	clientOptions, err := etcdClientOptions()
+	if err != nil {
+		return err
+	}
+	etcdK8sClient, recorderK8sClient, err := createK8sClients()
+	if err != nil {
+		return fmt.Errorf("Failed to create k8s clients: %w", err)
+	}
+
+	var diagnosis doctor.Diagnosis
+	readerPermissions, recorderPermissions := requiredPermissions(buildServerConfig(), shardConfig, discoveryMode, reportNode, extraResources, teamList)
+	diagnosis.Check(ctx, "reader permissions", doctor.Permissions(etcdK8sClient, readerPermissions))
+	diagnosis.Check(ctx, "recorder permissions", doctor.Permissions(recorderK8sClient, recorderPermissions))
+
+	diagnoseEtcd(ctx, &diagnosis, etcdK8sClient, discoveryMode, clientOptions, *minCertValidity)
+
+	var encryptionConfig analyzer.EncryptionConfiguration
+	resource := analyzer.ResourceFromPrefix(analyzer.DefaultPrefix)
+	configFound := diagnosis.Check(ctx, "encryption configuration", doctor.EncryptionConfig(etcdK8sClient, *namespace, resource, *kubeRequestTimeout, &encryptionConfig))
+	pluginSockets := splitList(*sockets)
+	if len(pluginSockets) == 0 {
+		pluginSockets = doctor.KMSSockets(encryptionConfig, resource)
+	}
+	switch {
+	case len(pluginSockets) > 0:
+		checker, err := kmsplugin.NewChecker(kmsplugin.Config{Sockets: pluginSockets, Timeout: *pluginTimeout})
+		if err != nil {
+			return fmt.Errorf("Failed to create KMS plugin checker: %w", err)
+		}
+		diagnosis.Check(ctx, "KMS plugins", doctor.KMSPlugins(checker))
+	case !configFound:
+		diagnosis.Skip("KMS plugins", "no encryption configuration to find the plugins in, set --kms-plugin-socket")
+	default:
+		diagnosis.Skip("KMS plugins", "no KMS provider listening on a unix socket, set --kms-plugin-socket")
+	}
+
+	diagnosis.Check(ctx, "report", doctor.ReportWritable(recorderK8sClient, *namespace, recorder.ReportName(reportNode), *kubeRequestTimeout))
+
+	if err := diagnosis.Print(os.Stdout); err != nil {
+		return fmt.Errorf("Failed to print the diagnosis: %w", err)
+	}
+	if diagnosis.Failed() {
+		return errors.New("Some checks failed")
+	}
+	return nil
+}
+
+// diagnoseEtcd checks the etcd connection details, the client and CA certificates, and that etcd can be read.
+func diagnoseEtcd(ctx context.Context, diagnosis *doctor.Diagnosis, clientset kubernetes.Interface, mode etcd.DiscoveryMode, options etcd.ClientOptions, minCertValidity time.Duration) {
+	checks := []string{"etcd endpoints", "etcd client certificate", "etcd CA certificate", "etcd"}
+	if *etcdFixture != "" {
+		for _, name := range checks {
+			diagnosis.Skip(name, "--etcd-fixture is set")
+		}
+		return
+	}
+
+	var connection etcd.ConnectionConfig
+	found := diagnosis.Check(ctx, checks[0], func(ctx context.Context) (string, error) {
+		var err error
+		if connection, err = buildEtcdConnection(ctx, clientset, mode); err != nil {
+			return "", fmt.Errorf("failed to discover etcd: %w", err)
+		}
+		if len(connection.Endpoints) == 0 {
+			return "", errors.New("no endpoint, set --etcd-endpoint or --etcd-discovery")
+		}
+		return strings.Join(connection.Endpoints, ","), nil
+	})
+	if !found {
+		for _, name := range checks[1:] {
+			diagnosis.Skip(name, "no etcd endpoint")
+		}
+		return
+	}
+
+	diagnosis.Check(ctx, checks[1], doctor.Certificate(connection.CertFile, connection.KeyFile, minCertValidity))
+	if connection.CAFile == "" && options.InsecureSkipVerify {
+		diagnosis.Skip(checks[2], "--etcd-insecure-skip-tls-verify is set")
+	} else {
+		diagnosis.Check(ctx, checks[2], doctor.Certificate(connection.CAFile, "", minCertValidity))
+	}
+	diagnosis.Check(ctx, checks[3], func(ctx context.Context) (string, error) {
+		client, err := etcd.CreateEtcdClient(strings.Join(connection.Endpoints, ","), connection.CertFile, connection.KeyFile, connection.CAFile, options)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if err := client.Close(); err != nil {
+				klog.ErrorS(err, "Failed to close etcd client")
+			}
+		}()
+		prefix := secretsPrefix()
+		if prefix == "" {
+			prefix = analyzer.DefaultPrefix
+		}
+		timeout := *etcdRequestTimeout
+		if timeout == 0 {
+			timeout = doctorEtcdTimeout
+		}
+		return doctor.Etcd(client, prefix, timeout)(ctx)
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/export"
+)
+
+// runExport implements the export subcommand: it scans etcd once and writes the finding of every secret,
+// without writing anything to the cluster.
+func runExport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	scanFlags := addScanFlags(flags)
+	format := flags.String("format", export.FormatJSON, "The output format: \"json\", \"yaml\" or \"csv\"")
+	output := flags.String("output", "-", "The file the findings are written to. \"-\" writes to stdout")
+	prefix := flags.String("prefix", "", "The etcd prefix to scan. Defaults to the secrets under --etcd-key-root")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("Failed to create output file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	writer, err := export.NewWriter(*format, out)
+	if err != nil {
+		return err
+	}
+	client, analyzerConfig, err := scanFlags.build(false)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// The scan cannot be aborted from the callback, so only the first write error is kept
+	var writeErr error
+	if *prefix != "" {
+		analyzerConfig.Prefix = *prefix
+	}
+	// The names in the result are not needed, and bounding them classifies the secrets page by page
+	analyzerConfig.MaxSecretNames = 1
+	analyzerConfig.Findings = func(finding analyzer.Finding) {
+		if writeErr == nil {
+			writeErr = writer.Write(finding)
+		}
+	}
+	result, err := analyzer.New().Analyze(ctx, client, analyzerConfig)
+	if err != nil {
+		return fmt.Errorf("Failed to scan etcd: %w", err)
+	}
+	if writeErr != nil {
+		return fmt.Errorf("Failed to write findings: %w", writeErr)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("Failed to write findings: %w", err)
+	}
+	klog.InfoS("Exported findings", "secrets", result.Total(), "revision", result.Revision)
+	return nil
+}
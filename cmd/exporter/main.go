@@ -0,0 +1,107 @@
+// Command kms-reporter-exporter exposes the reports written by kms-reporter as Prometheus metrics. It
+// only reads the report ConfigMaps, so unlike the reporter it needs no etcd access and can run anywhere
+// in the cluster.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/exporter"
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+	"github.com/lzhecheng/kms-reporter/pkg/version"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+var (
+	kubeconfig         = flag.String("kubeconfig", "", "Path to the kubeconfig file. Defaults to the in-cluster config, then to the standard kubeconfig loading rules")
+	namespace          = flag.String("namespace", "", "The namespace the reports are stored in")
+	metricsBindAddress = flag.String("metrics-bind-address", ":8080", "The address the metrics endpoint binds to")
+	kubeRequestTimeout = flag.Duration("kube-request-timeout", 5*time.Second, "The timeout of the Kubernetes API call reading the reports on each scrape")
+	reportKeyNames     = flag.String("report-key-names", "", "The --report-key-names of the reporter writing the reports")
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		fmt.Println(version.Get())
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := runExporter(ctx); err != nil {
+		klog.ErrorS(err, "Failed to run kms-reporter-exporter")
+		os.Exit(1)
+	}
+}
+
+func runExporter(ctx context.Context) error {
+	klog.InitFlags(nil)
+	flag.Parse()
+	if *namespace == "" {
+		return fmt.Errorf("--namespace is required")
+	}
+	keyNames, err := recorder.ParseKeyNames(*reportKeyNames)
+	if err != nil {
+		return fmt.Errorf("Invalid --report-key-names: %w", err)
+	}
+	klog.InfoS("Starting kms-reporter-exporter", "version", version.Get().String(), "namespace", *namespace)
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = *kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("Failed to load kubeconfig: %w", err)
+	}
+	config.UserAgent = version.UserAgent("exporter")
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("Failed to create k8s client: %w", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		exporter.NewCollector(clientset, exporter.Config{Namespace: *namespace, RequestTimeout: *kubeRequestTimeout, KeyNames: keyNames}),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	server := &http.Server{Addr: *metricsBindAddress, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	errCh := make(chan error, 1)
+	go func() {
+		klog.InfoS("Metrics listener started", "address", server.Addr)
+		errCh <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("Metrics listener stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("Failed to shut down metrics listener: %w", err)
+	}
+	return nil
+}
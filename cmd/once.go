@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+	"github.com/lzhecheng/kms-reporter/pkg/runner"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// onceGracePeriod is the time left before --once-deadline to record the outcome of the run
+const onceGracePeriod = 15 * time.Second
+
+// onceContext returns ctx bounded by --once-deadline, for the whole process including its setup.
+func onceContext(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	switch {
+	case *onceDeadline == 0:
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	case *onceDeadline <= onceGracePeriod:
+		return nil, nil, fmt.Errorf("Invalid --once-deadline %s: must exceed %s", *onceDeadline, onceGracePeriod)
+	}
+	ctx, cancel := context.WithTimeout(ctx, *onceDeadline)
+	return ctx, cancel, nil
+}
+
+// onceRunTimeout returns the run timeout of a single run in ctx: --run-timeout, shortened so that the
+// run ends onceGracePeriod before the deadline of ctx, leaving time to record its outcome.
+func onceRunTimeout(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return *runTimeout
+	}
+	// A non-positive timeout would disable the limit
+	remaining := max(time.Until(deadline)-onceGracePeriod, time.Millisecond)
+	if *runTimeout > 0 && *runTimeout < remaining {
+		return *runTimeout
+	}
+	return remaining
+}
+
+// readReportSummary returns the summary of the report after a single run, or nil if it cannot be read.
+func readReportSummary(ctx context.Context, clientset kubernetes.Interface, reportNode string, keyNames recorder.KeyNames) *recorder.ReportSummary {
+	getCtx, cancel := utils.ContextWithTimeout(ctx, *kubeRequestTimeout)
+	defer cancel()
+	data, err := recorder.GetReport(getCtx, clientset, *namespace, reportNode)
+	if err == nil {
+		var summary recorder.ReportSummary
+		if summary, err = recorder.ParseReport(keyNames.Restore(data)); err == nil {
+			return &summary
+		}
+	}
+	klog.ErrorS(err, "Failed to read the report for the termination message")
+	return nil
+}
+
+// writeTerminationMessage writes the outcome of a single run, or of the setup that failed before it, to
+// --termination-message-path.
+func writeTerminationMessage(runErr error, report *recorder.ReportSummary) {
+	if *terminationMessagePath == "" {
+		return
+	}
+	code := 0
+	if runErr != nil && !errors.Is(runErr, runner.ErrSkipped) {
+		code = exitCode(runErr)
+	}
+	message := runner.NewTerminationMessage(runErr, code, time.Now(), report)
+	if err := runner.WriteTerminationMessage(*terminationMessagePath, message); err != nil {
+		klog.ErrorS(err, "Failed to write the termination message", "path", *terminationMessagePath)
+	}
+}
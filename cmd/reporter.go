@@ -2,51 +2,404 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	klog "k8s.io/klog/v2"
 
+	"github.com/lzhecheng/kms-reporter/pkg/alert"
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/attestation"
+	"github.com/lzhecheng/kms-reporter/pkg/audit"
 	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/events"
+	"github.com/lzhecheng/kms-reporter/pkg/history"
+	"github.com/lzhecheng/kms-reporter/pkg/hook"
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+	"github.com/lzhecheng/kms-reporter/pkg/notifier"
+	"github.com/lzhecheng/kms-reporter/pkg/plugin"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
 	"github.com/lzhecheng/kms-reporter/pkg/reader"
+	"github.com/lzhecheng/kms-reporter/pkg/recency"
 	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+	"github.com/lzhecheng/kms-reporter/pkg/remediation"
+	"github.com/lzhecheng/kms-reporter/pkg/retention"
+	"github.com/lzhecheng/kms-reporter/pkg/runlock"
+	"github.com/lzhecheng/kms-reporter/pkg/runner"
+	"github.com/lzhecheng/kms-reporter/pkg/server"
+	"github.com/lzhecheng/kms-reporter/pkg/shard"
+	"github.com/lzhecheng/kms-reporter/pkg/sinks"
+	"github.com/lzhecheng/kms-reporter/pkg/teams"
+	"github.com/lzhecheng/kms-reporter/pkg/transformation"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+	"github.com/lzhecheng/kms-reporter/pkg/version"
+	"github.com/lzhecheng/kms-reporter/pkg/webhook"
 )
 
 var (
-	etcdEndpoint    = flag.String("etcd-endpoint", "", "The etcd endpoint")
-	etcdClientCrt   = flag.String("etcd-client-crt", "", "The etcd client certificate")
-	etcdClientKey   = flag.String("etcd-client-key", "", "The etcd client key")
-	etcdClientCaCrt = flag.String("etcd-client-ca-crt", "", "The etcd client CA certificate")
-	namespace       = flag.String("namespace", "", "The namespace to store the secret encryption status")
-	kubeconfig      = flag.String("kubeconfig", "", "Path to the kubeconfig file to use for recorder (optional)")
-	kmsProviderName = flag.String("kms-provider-name", "kmsprovider", "The prefix of the KMS provider name in the encryption configuration")
+	etcdEndpoint       = flag.String("etcd-endpoint", "", "The etcd endpoint, or a comma-separated list of endpoints")
+	etcdDiscovery      = flag.String("etcd-discovery", "", "Discover the etcd endpoints and client certificates: \"apiserver\" reads the kube-apiserver pod spec, \"kubeadm\" uses the kubeadm defaults, \"auto\" tries both in that order. Explicitly set etcd flags take precedence. Empty disables discovery")
+	etcdClientCrt      = flag.String("etcd-client-crt", "", "The etcd client certificate")
+	etcdClientKey      = flag.String("etcd-client-key", "", "The etcd client key")
+	etcdClientCaCrt    = flag.String("etcd-client-ca-crt", "", "The etcd client CA certificate")
+	etcdTLSMinVersion  = flag.String("etcd-tls-min-version", "1.2", "The minimum TLS version negotiated with etcd, \"1.2\" or \"1.3\"")
+	etcdInsecure       = flag.Bool("etcd-insecure-skip-tls-verify", false, "Do not verify the etcd server certificate, and make --etcd-client-ca-crt optional. Insecure: only for disposable test clusters with self-signed or mismatched etcd certificates")
+	etcdCipherSuites   = flag.String("etcd-tls-cipher-suites", "", "Comma-separated TLS 1.2 cipher suites allowed with etcd, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Insecure suites are rejected. Empty allows the secure suites of Go")
+	etcdFixture        = flag.String("etcd-fixture", "", "Analyze the key-value pairs of a JSON or YAML fixture file, such as the output of \"etcdctl get --prefix -w json\", instead of connecting to etcd")
+	namespace          = flag.String("namespace", "", "The namespace to store the secret encryption status")
+	createNamespace    = flag.Bool("create-namespace", false, "Create --namespace, and the report namespaces of --teams-file, labeled app.kubernetes.io/managed-by=kms-reporter, when they do not exist. Requires create on namespaces")
+	kubeconfig         = flag.String("kubeconfig", "", "Path to the kubeconfig file to use for recorder (optional)")
+	readerKubeconfig   = flag.String("reader-kubeconfig", "", "Path to the kubeconfig file to use for the etcd reader (optional). Defaults to the in-cluster config, or outside a cluster to --kubeconfig and then $KUBECONFIG or ~/.kube/config")
+	deploymentMode     = flag.String("deployment-mode", deploymentModeDeployment, "How the reporter is deployed: \"deployment\" runs one reporter for the cluster, \"static-pod\" runs one per control plane node, as a static pod or a sidecar of kube-apiserver, reading the local etcd with the kubeadm defaults and writing a report per node")
+	nodeName           = flag.String("node-name", "", "The node the reporter runs on in static-pod mode. Defaults to $NODE_NAME, then to the hostname")
+	ownerDeployment    = flag.String("owner-deployment", "", "The Deployment in --namespace set as the owner of the report ConfigMap, so the report is garbage-collected with it. Empty leaves the report without an owner")
+	reportSigningKey   = flag.String("report-signing-key-file", "", "The file holding the HMAC key the report is signed with, e.g. a mounted Secret, so that changes made to the report by anyone else can be detected with the verify-report subcommand. Empty leaves the report unsigned")
+	patchReport        = flag.Bool("patch-report", false, "Write only the changed keys of an existing report ConfigMap with a JSON merge patch instead of updating the whole ConfigMap. Requires the patch verb instead of update on the report")
+	kmsProviderName    = flag.String("kms-provider-name", "kmsprovider", "The prefix of the KMS provider name in the encryption configuration")
+	providerComparison = flag.String("provider-comparison", string(analyzer.ComparisonSequence), "How secrets are compared against the latest provider: \"sequence\" compares the sequence parsed from provider names, \"name\" treats the first KMS provider as latest and compares names exactly")
+	kmsProviderRegex   = flag.String("kms-provider-regex", "", "Regex matching KMS provider names, with a named capture group \"seq\" for the ordering token (e.g. ^kms-provider-v2-(?P<seq>\\d{4})-(?P<seq>\\d{2})$). Overrides --kms-provider-name")
+	targetProviderName = flag.String("target-provider-name", "", "The KMS provider to compare secrets against instead of the latest provider of the encryption configuration, e.g. during a staged rollout whose configuration still lists the old provider first")
+	targetProviderSeq  = flag.Int("target-provider-seq", -1, "The sequence of the target provider in sequence comparison. Parsed from --target-provider-name when negative")
 
 	runInterval = flag.Duration("run-interval", 5*time.Minute, "The interval to run the reporter")
+	runTimeout  = flag.Duration("run-timeout", 0, "The maximum duration of a single read and record cycle. A run exceeding it is cancelled and reported as failed. 0 disables the limit")
+
+	once                   = flag.Bool("once", false, "Run once and exit, e.g. in a Kubernetes CronJob: the management endpoints are not served, the outcome is recorded in the report and the termination message, and a failed run exits with its exit code")
+	onceDeadline           = flag.Duration("once-deadline", 0, "With --once, the maximum duration of the whole process, setup included. The run is cut short 15s before it, to record its outcome. 0 disables the limit")
+	terminationMessagePath = flag.String("termination-message-path", runner.DefaultTerminationMessagePath, "With --once, the file the outcome and report summary of the run are written to as JSON, read by Kubernetes as the termination message of the container. Empty disables it")
+	runLock                = flag.Bool("run-lock", false, "Take a Lease in --namespace before every run and skip the run while another instance holds it, e.g. overlapping CronJob runs, so that their report writes never interleave")
+	runLockDuration        = flag.Duration("run-lock-duration", runlock.DefaultDuration, "With --run-lock, how long the lock of an instance that stopped renewing it, e.g. because it was killed, is held before another instance may take it")
+
+	metricsBindAddress   = flag.String("metrics-bind-address", ":8080", "The address the public metrics endpoint binds to. Set to empty to disable")
+	controlBindAddress   = flag.String("control-bind-address", "", "The address the authenticated control endpoints (/scan, /report) bind to. Empty disables them")
+	controlTLSCertFile   = flag.String("control-tls-cert-file", "", "The serving certificate of the control endpoints")
+	controlTLSKeyFile    = flag.String("control-tls-key-file", "", "The serving key of the control endpoints")
+	controlClientCAFile  = flag.String("control-client-ca-file", "", "The CA bundle used to verify client certificates (mTLS) on the control endpoints")
+	controlTokenAuth     = flag.Bool("control-token-auth", false, "Authenticate bearer tokens on the control endpoints with the TokenReview API")
+	controlTokenAudience = flag.String("control-token-audiences", "", "Comma-separated audiences requested when reviewing bearer tokens")
+	controlAllowedUsers  = flag.String("control-allowed-users", "", "Comma-separated users allowed to call the control endpoints, e.g. system:serviceaccount:ops:scanner or the common name of a client certificate")
+	controlAllowedGroups = flag.String("control-allowed-groups", "", "Comma-separated groups whose users are allowed to call the control endpoints, e.g. a Kubernetes group or the organization of a client certificate")
+
+	webhookBindAddress = flag.String("webhook-bind-address", "", "The address the validating admission webhook for updates of the encryption-provider-config ConfigMap binds to. Empty disables it")
+	webhookTLSCertFile = flag.String("webhook-tls-cert-file", "", "The serving certificate of the admission webhook")
+	webhookTLSKeyFile  = flag.String("webhook-tls-key-file", "", "The serving key of the admission webhook")
+	webhookDeny        = flag.Bool("webhook-deny", false, "Deny encryption configuration changes removing a provider that the latest report shows secrets still use, instead of admitting them with a warning")
+
+	otlpEndpoint    = flag.String("otlp-endpoint", metrics.OTLPEndpointFromEnv(), "The OTLP/HTTP endpoint metrics are pushed to, e.g. http://otel-collector:4318/v1/metrics. Defaults to the standard OTEL_EXPORTER_OTLP_METRICS_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT environment variables. Empty disables the export")
+	otlpInterval    = flag.Duration("otlp-interval", metrics.DefaultOTLPInterval, "The interval between two OTLP metric exports")
+	statsdAddress   = flag.String("statsd-address", "", "The UDP address of a StatsD server the summary of every report is sent to, e.g. localhost:8125. Empty disables StatsD")
+	statsdPrefix    = flag.String("statsd-prefix", metrics.DefaultStatsDPrefix, "The prefix of StatsD metric names")
+	statsdDogStatsD = flag.Bool("statsd-dogstatsd", false, "Send tags with the DogStatsD extension instead of appending them to StatsD metric names")
+	statsdTags      = flag.String("statsd-tags", "", "Comma-separated key:value tags added to every DogStatsD metric")
+
+	remoteWriteURL          = flag.String("remote-write-url", "", "The Prometheus remote-write URL the summary series of every report are written to, e.g. https://prometheus.example.com/api/v1/write. Empty disables remote-write")
+	remoteWriteUsername     = flag.String("remote-write-username", "", "The basic authentication username of the remote-write endpoint")
+	remoteWritePasswordFile = flag.String("remote-write-password-file", "", "The file holding the basic authentication password of the remote-write endpoint")
+	remoteWriteCAFile       = flag.String("remote-write-ca-file", "", "The CA bundle verifying the remote-write endpoint. Defaults to the system roots")
+	remoteWriteCertFile     = flag.String("remote-write-cert-file", "", "The client certificate presented to the remote-write endpoint")
+	remoteWriteKeyFile      = flag.String("remote-write-key-file", "", "The client key presented to the remote-write endpoint")
+	remoteWriteLabels       = flag.String("remote-write-labels", "", "Comma-separated name=value labels added to every remote-written series, e.g. cluster=prod-1")
+
+	notifierURL               = flag.String("notifier-url", "", "The URL notified when the alert starts or stops firing and when a rotation completes. Empty disables notifications")
+	notifierMethod            = flag.String("notifier-method", "POST", "The HTTP method of notifications")
+	notifierTemplateFile      = flag.String("notifier-template-file", "", "The file holding the Go template notifications are rendered from. Defaults to a JSON summary")
+	notifierContentType       = flag.String("notifier-content-type", "application/json", "The content type of notifications")
+	notifierHeaders           = flag.String("notifier-headers", "", "Comma-separated name=value headers added to every notification")
+	notifierAuthorizationFile = flag.String("notifier-authorization-file", "", "The file holding the Authorization header of notifications, e.g. Bearer <token>")
+	notificationSinks         = flag.Bool("notification-sinks", false, "Also send notifications to the sinks declared in NotificationSink resources in --namespace, listed at every notification")
+
+	statusHook        = flag.String("status-hook", "", "The executable run, with the report as JSON on its standard input, when the status of a report condition changes. Empty disables the hook")
+	statusHookTimeout = flag.Duration("status-hook-timeout", hook.DefaultTimeout, "The time after which a run of --status-hook is killed")
+
+	recorderPlugins       = flag.String("recorder-plugins", "", "Comma-separated executables run with every report and run status as JSON on their standard input, after the report ConfigMap is written, to record them in additional sinks")
+	recorderPluginTimeout = flag.Duration("recorder-plugin-timeout", plugin.DefaultTimeout, "The time after which a run of a --recorder-plugins executable is killed")
+
+	auditFile       = flag.String("audit-file", "", "The file audit records of every run and of every secret changing category are appended to as JSON lines. Empty disables the audit file")
+	auditWebhookURL = flag.String("audit-webhook-url", "", "The URL audit records are posted to as JSON lines. Empty disables the audit webhook")
+
+	attestationFile       = flag.String("attestation-file", "", "The file an in-toto attestation of the encryption summary of every run is appended to as a DSSE envelope per line (.intoto.jsonl). Empty disables the attestation file")
+	attestationWebhookURL = flag.String("attestation-webhook-url", "", "The URL the DSSE envelope of the attestation of every run is posted to. Empty disables the attestation webhook")
+	attestationSigningKey = flag.String("attestation-signing-key-file", "", "The file holding the PEM-encoded Ed25519, ECDSA or RSA private key attestations are signed with. Empty leaves them unsigned")
+	clusterName           = flag.String("cluster-name", "kubernetes", "The name of the cluster in the subject of attestations")
+
+	etcdMaxRequestsPerSecond = flag.Float64("etcd-max-requests-per-second", 0, "The maximum rate of etcd range requests, so that scans don't compete with the API server for etcd throughput. 0 disables the limit")
+	etcdMaxBytesPerSecond    = flag.Int64("etcd-max-bytes-per-second", 0, "The maximum rate of bytes read from etcd. A page larger than the limit delays the next request accordingly. 0 disables the limit")
+	etcdRetries              = flag.Int("etcd-retries", 3, "The number of times an etcd request failing with a transient error, e.g. a leader change or a timeout, is retried before the run fails. 0 disables retries")
+	etcdRetryBackoff         = flag.Duration("etcd-retry-backoff", 200*time.Millisecond, "The jittered wait before the first retry of an etcd request, doubling with every further retry")
+	etcdRetryMaxBackoff      = flag.Duration("etcd-retry-max-backoff", 5*time.Second, "The maximum wait between two attempts of an etcd request")
+	etcdBreakerFailures      = flag.Int("etcd-breaker-failures", 0, "The number of failed etcd requests within --etcd-breaker-window that opens the circuit breaker, skipping scans for --etcd-breaker-cool-down. 0 disables the breaker")
+	etcdBreakerWindow        = flag.Duration("etcd-breaker-window", 5*time.Minute, "The period failed etcd requests are counted over by the circuit breaker")
+	etcdBreakerCoolDown      = flag.Duration("etcd-breaker-cool-down", 10*time.Minute, "How long the etcd circuit breaker stays open before etcd is tried again")
+	compareEtcdMembers       = flag.Bool("compare-etcd-members", false, "After every scan, count the secrets on each etcd endpoint with serializable reads and compare their revisions, to flag members lagging behind the others. Requires at least 2 endpoints")
+	maxEtcdMemberLag         = flag.Int64("max-etcd-member-lag", etcd.DefaultMaxMemberLag, "The number of revisions an etcd member may be behind the most recent member before it is flagged as lagging")
+	etcdPageSize             = flag.Int64("etcd-page-size", 0, "The maximum number of keys read from etcd per request. 0 reads all secrets in a single request")
+	etcdAdaptivePageSize     = flag.Bool("etcd-adaptive-page-size", false, "Starting at --etcd-page-size, halve the page size after a page that took more than half of the etcd request timeout, or read a page that timed out again with half the size, and double it after a page that took less than a tenth of the timeout")
+	etcdMinPageSize          = flag.Int64("etcd-min-page-size", 0, "The smallest page size with --etcd-adaptive-page-size. 0 defaults to 100, or --etcd-page-size if smaller")
+	etcdMaxPageSize          = flag.Int64("etcd-max-page-size", 0, "The largest page size with --etcd-adaptive-page-size. 0 defaults to 10 times --etcd-page-size")
+	etcdMaxPageBytes         = flag.Int64("etcd-max-page-bytes", 0, "The size of the keys and values an adaptive page is bounded to with --etcd-adaptive-page-size, at the average size of the secrets read so far. 0 defaults to 16 MiB")
+	extraEtcdPrefixes        = flag.String("extra-etcd-prefixes", "", "Comma-separated additional etcd prefixes scanned after the secrets, e.g. /registry/configmaps,/registry/oauth.openshift.io/oauthaccesstokens, each recorded in its own report kms-reporter-<resource>. Not supported with sharding")
+	reportRetention          = flag.Duration("report-retention", 0, "Delete the reports and shard partial results no longer written, e.g. those of removed --extra-etcd-prefixes or teams, this long after a complete run first found them unused. Requires list, patch and delete on configmaps in --namespace and the report namespaces of --teams-file. 0 keeps them")
+	summaryOnlyAbove         = flag.Int("summary-only-above", 0, "Above this many secrets, write a summary-only report: per-namespace rollups and counts instead of the secret lists. 0 always writes the lists")
+	countsOnly               = flag.Bool("counts-only", false, "Never write or log a secret name: reports are summary-only, and the logs, notifications and audit trail only hold counts and per-namespace aggregates. Not supported with sharding")
+	reportRecipientsFile     = flag.String("report-recipients-file", "", "The file holding the age recipients (age1... public keys, one per line) the secret names of the report are encrypted to in the SECRET_LISTS key. The rest of the report is summary-only. Empty writes the names in plaintext. Not supported with sharding")
+	reportFormat             = flag.String("report-format", utils.FormatJSON, "The format of the structured values of the report, such as PROVIDER_COUNTS and CONDITIONS: json or yaml")
+	reportKeyNames           = flag.String("report-key-names", "", "Comma-separated KEY=name overrides of the names of the report keys, e.g. ENCRYPTED=encrypted_secrets,UNENCRYPTED=plaintext_secrets")
+	maxListedSecrets         = flag.Int("max-listed-secrets", 0, "The maximum number of secret names written in each list of the report. The secrets left out are counted per namespace in a rollup key. 0 writes every name")
+	maxSecretNames           = flag.Int("max-secret-names", 0, "The maximum number of secret names kept in each of the encrypted and unencrypted lists. Further secrets are only counted, and secrets are summarized page by page as they are read. 0 keeps every name")
+	checkRevisionSkew        = flag.Bool("check-revision-skew", true, "After a paginated scan, check which secrets were created, updated or deleted since the revision it was pinned to, and list them in the report")
+	largestSecrets           = flag.Int("largest-secrets", 0, "The number of secrets with the largest values listed in the report, to spot oversized secrets. 0 lists none")
+	oldestProviderSecrets    = flag.Int("oldest-provider-secrets", 0, "The number of encrypted secrets not on the latest provider with the lowest provider sequences, the longest unrotated, listed in the report. Ignored with --provider-comparison=name. 0 lists none")
+	parseErrorLogFirst       = flag.Int("parse-error-log-first", analyzer.DefaultParseErrorLogFirst, "The number of parse errors of each class, e.g. invalid keys, logged before the following ones are sampled. Every error is still counted in SCAN_STATS")
+	parseErrorLogEvery       = flag.Int("parse-error-log-every", analyzer.DefaultParseErrorLogEvery, "Log one in every this many parse errors of a class past --parse-error-log-first")
+	incrementalScan          = flag.Bool("incremental-scan", false, "Keep the secrets parsed by the previous run in memory and only read the values of secrets modified since then")
+	etcdKeyRoot              = flag.String("etcd-key-root", "", "The storage prefix of the API server (its --etcd-prefix), e.g. /cluster-a/registry, when it is not /registry. Secrets are scanned under <root>/secrets and --extra-etcd-prefixes must be under it. By default the root of every key is found by searching it for its resource")
+	kineCompat               = flag.Bool("kine-compat", false, "Scan a kine endpoint (the SQL-backed etcd shim used e.g. by k3s) instead of etcd: pages are not pinned to a revision and continue from the last key read")
+
+	etcdRequestTimeout = flag.Duration("etcd-request-timeout", 0, "The timeout of each etcd request. 0 scales it with the page size: 5s plus 5ms per key with --etcd-page-size, 2m for a single unpaginated request")
+	etcdDialTimeout    = flag.Duration("etcd-dial-timeout", etcd.DefaultDialTimeout, "The timeout of establishing the connection to etcd, separate from the timeout of requests")
+	etcdGRPCMaxRetries = flag.Uint("etcd-grpc-max-retries", 0, "The number of times the gRPC client of etcd retries a read failing with Unavailable, e.g. during a leader election, within --etcd-request-timeout before the request fails. 0 uses the etcd client default of 100")
+	etcdGRPCBackoff    = flag.Duration("etcd-grpc-backoff", 0, "The wait between two retries of the gRPC client of etcd. 0 uses the etcd client default of 25ms")
+	etcdGRPCJitter     = flag.Float64("etcd-grpc-backoff-jitter", 0, "The fraction, in [0, 1], of --etcd-grpc-backoff the wait between two retries of the gRPC client of etcd is randomized by. 0 uses the etcd client default of 0.1")
+	kubeRequestTimeout = flag.Duration("kube-request-timeout", 5*time.Second, "The timeout of each Kubernetes API call, such as reading the encryption configuration and writing the report")
+	kubeAPIQPS         = flag.Float64("kube-api-qps", 0, "The sustained rate of Kubernetes API calls of each of the reader and recorder clients, in requests per second. 0 uses the client-go default of 5")
+	kubeAPIBurst       = flag.Int("kube-api-burst", 0, "The number of Kubernetes API calls each of the reader and recorder clients can send at once above --kube-api-qps. 0 uses the client-go default of 10")
+
+	shardCount      = flag.Int("shard-count", 1, "The number of replicas the secret key space is split across. 1 disables sharding")
+	shardIndex      = flag.Int("shard-index", -1, "The shard scanned by this replica, in [0, shard-count). Defaults to the ordinal suffix of the hostname, as in a StatefulSet")
+	shardBoundaries = flag.String("shard-boundaries", "", "Comma-separated namespace prefixes separating the shards, in increasing order (shard-count - 1 entries). Defaults to splitting namespaces evenly by first character")
+	shardMaxAge     = flag.Duration("shard-max-age", 0, "The age after which the partial result of a shard is stale: shard 0 waits for a fresh one rather than merging it. 0 defaults to twice --run-interval, so that a shard missing one run is tolerated")
+	shardMode       = flag.String("shard-mode", string(shard.ModeRange), "How namespaces are assigned to shards: range, a contiguous range of namespaces per shard, or hash, the shard the hash of the namespace maps to. hash balances the shards whatever the namespace names, and is not supported with --incremental-scan or --kine-compat")
+
+	alertMaxUnencrypted      = flag.Int("alert-max-unencrypted", -1, "Alert when more secrets than this are unencrypted. Negative disables the check")
+	alertMinEncryptedPercent = flag.Float64("alert-min-encrypted-percent", 0, "Alert when a smaller percentage of secrets is encrypted. 0 disables the check")
+	alertConsecutiveRuns     = flag.Int("alert-consecutive-runs", 1, "The number of consecutive runs a threshold must be breached in before alerting")
+
+	remediationJobs          = flag.Bool("remediation-jobs", false, "Create a Job in every namespace holding secrets not encrypted by the latest provider, rewriting them with a namespace-scoped service account, and summarize the Jobs in the report")
+	remediationImage         = flag.String("remediation-image", "bitnami/kubectl:latest", "The image of the remediation Jobs, which must provide sh and kubectl")
+	remediationSA            = flag.String("remediation-service-account", remediation.DefaultServiceAccountName, "The service account of the remediation Jobs, which must exist in every namespace remediated and be allowed to list, get and update its secrets")
+	remediationMaxActiveJobs = flag.Int("remediation-max-active-jobs", 5, "The maximum number of remediation Jobs running at once. Further namespaces are remediated by later runs. 0 disables the limit")
+
+	unchangedSecretDays   = flag.Int("unchanged-secret-days", 0, "Track the first run each secret was observed with its current provider and key ID in the ConfigMap kms-reporter-history, and report the secrets unchanged for more than this many days. Not supported with sharding or --counts-only. 0 disables the tracking")
+	trackDeletedSecrets   = flag.Bool("track-deleted-secrets", false, "Keep the secrets observed by every run in the ConfigMap kms-reporter-history, as with --unchanged-secret-days, and report those deleted since the previous run in DELETED_SINCE_LAST_RUN. Not supported with sharding or --counts-only")
+	teamsFile             = flag.String("teams-file", "", "The JSON or YAML file defining teams, each selecting namespaces by name pattern or label selector. After every run, the findings of the namespaces of each team are written to the report kms-reporter-team-<name> in the reportNamespace of the team, alongside the full report. Not supported with sharding")
+	rewrittenSince        = flag.String("rewritten-since", "", "Flag the encrypted secrets last written before this date, e.g. the last key rotation, as a date (2025-01-31) or an RFC 3339 time. The last write is the latest of the creationTimestamp and managedFields times of the secret in the API. Requires list on secrets. Empty disables the check")
+	monitorTransformation = flag.Bool("monitor-transformation-errors", false, "On every run, read the envelope transformation counters from the API server metrics and report the writes and reads that failed to be encrypted or decrypted by the KMS provider since the previous run. Requires get on the /metrics non-resource URL")
+
+	rbacSelfCheck = flag.Bool("rbac-self-check", true, "Verify at startup that the reporter has every RBAC permission it needs and fail fast otherwise")
+)
+
+// Deployment modes accepted by --deployment-mode
+const (
+	deploymentModeDeployment = "deployment"
+	deploymentModeStaticPod  = "static-pod"
+)
+
+// Exit codes returned when setup fails, so wrappers can tell failure causes apart
+const (
+	exitCodeFailure                  = 1
+	exitCodeMissingPermissions       = 2
+	exitCodeEtcdUnavailable          = 3
+	exitCodeEncryptionConfigNotFound = 4
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		fmt.Println(version.Get())
+		return
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(ctx, os.Args[2:]); err != nil {
+			klog.ErrorS(err, "Failed to run benchmark")
+			os.Exit(exitCodeFailure)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "wait" {
+		if err := runWait(ctx, os.Args[2:]); err != nil {
+			klog.ErrorS(err, "Failed to wait for the rotation")
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "watch" {
+		if err := runWatch(ctx, os.Args[2:]); err != nil {
+			klog.ErrorS(err, "Failed to watch the rotation")
+			os.Exit(exitCodeFailure)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(ctx, os.Args[2:]); err != nil {
+			klog.ErrorS(err, "Failed to export findings")
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		if err := runSimulate(ctx, os.Args[2:]); err != nil {
+			klog.ErrorS(err, "Failed to simulate the encryption configuration")
+			os.Exit(exitCodeFailure)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "node-agent" {
+		if err := runNodeAgent(ctx, os.Args[2:]); err != nil {
+			klog.ErrorS(err, "Failed to run the node agent")
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-report" {
+		if err := runVerifyReport(ctx, os.Args[2:]); err != nil {
+			klog.ErrorS(err, "Failed to verify report")
+			os.Exit(exitCodeFailure)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		if err := runDoctor(ctx, os.Args[2:]); err != nil {
+			klog.ErrorS(err, "Failed to diagnose the environment")
+			os.Exit(exitCodeFailure)
+		}
+		return
+	}
 	if err := setupKmsReporter(ctx); err != nil {
 		klog.ErrorS(err, "Failed to setup kms-reporter")
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
-func setupKmsReporter(ctx context.Context) error {
+func setupKmsReporter(ctx context.Context) (err error) {
 	klog.InitFlags(nil)
 	flag.Parse()
 
-	etcdClientOperator, err := etcd.CreateEtcdClient(*etcdEndpoint, *etcdClientCrt, *etcdClientKey, *etcdClientCaCrt)
+	// The summary of the report after a single run, and the error of a single run that was skipped
+	var onceReport *recorder.ReportSummary
+	var onceSkipped error
+	if *once {
+		var cancel context.CancelFunc
+		if ctx, cancel, err = onceContext(ctx); err != nil {
+			return err
+		}
+		defer cancel()
+		defer func() {
+			runErr := err
+			if runErr == nil {
+				// A skipped run exits successfully, but is reported as skipped
+				runErr = onceSkipped
+			}
+			writeTerminationMessage(runErr, onceReport)
+		}()
+	}
+
+	klog.InfoS("Starting kms-reporter", "version", version.Get().String())
+
+	discoveryMode, err := etcd.ParseDiscoveryMode(*etcdDiscovery)
+	if err != nil {
+		return err
+	}
+	reportNode, err := buildStaticPodMode(&discoveryMode)
+	if err != nil {
+		return fmt.Errorf("Failed to configure %s mode: %w", *deploymentMode, err)
+	}
+	if *etcdFixture != "" {
+		// Nothing to discover without etcd
+		discoveryMode = etcd.DiscoveryNone
+	}
+
+	if *kubeAPIQPS < 0 || *kubeAPIBurst < 0 {
+		return fmt.Errorf("Invalid --kube-api-qps %g or --kube-api-burst %d: must not be negative", *kubeAPIQPS, *kubeAPIBurst)
+	}
+	// Create Kubernetes clients
+	etcdK8sClient, recorderK8sClient, err := createK8sClients()
+	if err != nil {
+		return fmt.Errorf("Failed to create k8s clients: %w", err)
+	}
+
+	serverConfig := buildServerConfig()
+
+	shardConfig, err := buildShardConfig()
+	if err != nil {
+		return fmt.Errorf("Failed to configure sharding: %w", err)
+	}
+	extraPrefixes, extraResources, err := buildExtraPrefixes(shardConfig)
+	if err != nil {
+		return fmt.Errorf("Invalid --extra-etcd-prefixes: %w", err)
+	}
+	if *countsOnly {
+		// The partial results of the shards are stored with the names
+		if shardConfig.Enabled() {
+			return fmt.Errorf("Invalid --counts-only: not supported with sharding")
+		}
+		utils.SetRedactNames(true)
+	}
+	if *reportRecipientsFile != "" && shardConfig.Enabled() {
+		return fmt.Errorf("Invalid --report-recipients-file: not supported with sharding")
+	}
+	// Hash shards scan every namespace separately
+	if shardConfig.Hashed() && (*incrementalScan || *kineCompat) {
+		return fmt.Errorf("Invalid --shard-mode %s: not supported with --incremental-scan or --kine-compat", shardConfig.Mode)
+	}
+	switch {
+	case *unchangedSecretDays < 0:
+		return fmt.Errorf("Invalid --unchanged-secret-days %d: must not be negative", *unchangedSecretDays)
+	// Every shard only observes its part of the secrets
+	case *unchangedSecretDays > 0 && shardConfig.Enabled():
+		return fmt.Errorf("Invalid --unchanged-secret-days: not supported with sharding")
+	// The history is stored with the names
+	case *unchangedSecretDays > 0 && *countsOnly:
+		return fmt.Errorf("Invalid --unchanged-secret-days: not supported with --counts-only")
+	}
+	if *trackDeletedSecrets && shardConfig.Enabled() {
+		return fmt.Errorf("Invalid --track-deleted-secrets: not supported with sharding")
+	}
+	if *trackDeletedSecrets && *countsOnly {
+		return fmt.Errorf("Invalid --track-deleted-secrets: not supported with --counts-only")
+	}
+	var teamList []teams.Team
+	if *teamsFile != "" {
+		// Every shard only observes its part of the secrets
+		if shardConfig.Enabled() {
+			return fmt.Errorf("Invalid --teams-file: not supported with sharding")
+		}
+		if teamList, err = teams.ReadTeams(*teamsFile); err != nil {
+			return fmt.Errorf("Invalid --teams-file: %w", err)
+		}
+	}
+	if *etcdAdaptivePageSize && *etcdPageSize <= 0 {
+		return fmt.Errorf("--etcd-adaptive-page-size requires --etcd-page-size")
+	}
+	if *etcdMaxPageBytes < 0 {
+		return fmt.Errorf("Invalid --etcd-max-page-bytes %d: must not be negative", *etcdMaxPageBytes)
+	}
+	if *etcdMinPageSize > 0 && *etcdMaxPageSize > 0 && *etcdMinPageSize > *etcdMaxPageSize {
+		return fmt.Errorf("Invalid --etcd-min-page-size %d: must not exceed --etcd-max-page-size %d", *etcdMinPageSize, *etcdMaxPageSize)
+	}
+
+	if *rbacSelfCheck {
+		if err := checkPermissions(ctx, etcdK8sClient, recorderK8sClient, serverConfig, shardConfig, discoveryMode, reportNode, extraResources, teamList); err != nil {
+			return fmt.Errorf("RBAC self-check failed: %w", err)
+		}
+		klog.Info("RBAC self-check passed")
+	}
+
+	etcdClientOperator, etcdConnection, err := createEtcdClient(ctx, etcdK8sClient, discoveryMode)
 	if err != nil {
-		return fmt.Errorf("Failed to create etcd client: %w", err)
+		return err
 	}
 	defer func() {
 		if err := etcdClientOperator.Close(); err != nil {
@@ -55,45 +408,764 @@ func setupKmsReporter(ctx context.Context) error {
 	}()
 	klog.Info("etcd client operator created")
 
-	klog.Info("Starting kms-reporter")
+	var members *etcd.MemberComparer
+	if *compareEtcdMembers {
+		members, err = createMemberComparer(etcdConnection)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := members.Close(); err != nil {
+				klog.ErrorS(err, "Failed to close etcd member clients")
+			}
+		}()
+	}
 
-	// Create Kubernetes clients
-	etcdK8sClient, recorderK8sClient, err := createK8sClients()
+	providerMatcher, err := utils.NewProviderNameMatcher(*kmsProviderName, *kmsProviderRegex)
 	if err != nil {
-		return fmt.Errorf("Failed to create k8s clients: %w", err)
+		return fmt.Errorf("Failed to create provider name matcher: %w", err)
+	}
+
+	comparison, err := analyzer.ParseComparisonMode(*providerComparison)
+	if err != nil {
+		return err
+	}
+
+	var targetProvider *analyzer.LatestProvider
+	if *targetProviderName != "" || *targetProviderSeq >= 0 {
+		target, err := analyzer.NewTargetProvider(*targetProviderName, *targetProviderSeq, providerMatcher, comparison)
+		if err != nil {
+			return fmt.Errorf("Invalid target provider: %w", err)
+		}
+		klog.InfoS("Comparing secrets against the target provider", "name", target.Name, "seq", target.Seq)
+		targetProvider = &target
+	}
+
+	thresholds := alert.Thresholds{
+		MaxUnencrypted:      *alertMaxUnencrypted,
+		MinEncryptedPercent: *alertMinEncryptedPercent,
+		ConsecutiveRuns:     *alertConsecutiveRuns,
+	}
+	if err := thresholds.Validate(); err != nil {
+		return fmt.Errorf("Invalid alert thresholds: %w", err)
+	}
+	var alerts *alert.Evaluator
+	if thresholds.Enabled() {
+		alerts = alert.NewEvaluator(thresholds)
+	}
+
+	reportMarshaller, err := utils.NewMarshaller(*reportFormat)
+	if err != nil {
+		return fmt.Errorf("Invalid --report-format: %w", err)
+	}
+	keyNames, err := recorder.ParseKeyNames(*reportKeyNames)
+	if err != nil {
+		return fmt.Errorf("Invalid --report-key-names: %w", err)
+	}
+	recorderConfig := recorder.Config{RequestTimeout: *kubeRequestTimeout, NodeName: reportNode, Patch: *patchReport, MaxListedSecrets: *maxListedSecrets, SummaryOnlyAbove: *summaryOnlyAbove, SigningKeyFile: *reportSigningKey, Marshaller: reportMarshaller, KeyNames: keyNames, CountsOnly: *countsOnly, CreateNamespace: *createNamespace}
+	if *reportRecipientsFile != "" {
+		if recorderConfig.Recipients, err = recorder.ReadRecipients(*reportRecipientsFile); err != nil {
+			return err
+		}
+	}
+	if *statusHook != "" {
+		if recorderConfig.Hook, err = hook.New(hook.Config{Command: []string{*statusHook}, Timeout: *statusHookTimeout}); err != nil {
+			return fmt.Errorf("Failed to create status hook: %w", err)
+		}
+	}
+	// Fail at startup rather than at the first write
+	if *reportSigningKey != "" {
+		if _, err := recorder.ReadSigningKey(*reportSigningKey); err != nil {
+			return err
+		}
+	}
+	if *createNamespace {
+		// The run lock, the history and the shards are stored in the namespace as well
+		createCtx, cancel := utils.ContextWithTimeout(ctx, *kubeRequestTimeout)
+		err := recorder.CreateNamespace(createCtx, recorderK8sClient, *namespace)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	if *ownerDeployment != "" {
+		ownerCtx, cancel := utils.ContextWithTimeout(ctx, *kubeRequestTimeout)
+		recorderConfig.Owner, err = recorder.DeploymentOwnerReference(ownerCtx, recorderK8sClient, *namespace, *ownerDeployment)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("Failed to resolve report owner: %w", err)
+		}
+	}
+
+	var statsd *metrics.StatsD
+	if *statsdAddress != "" {
+		statsd, err = metrics.NewStatsD(metrics.StatsDConfig{
+			Address:   *statsdAddress,
+			Prefix:    *statsdPrefix,
+			DogStatsD: *statsdDogStatsD,
+			Tags:      splitList(*statsdTags),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to create StatsD emitter: %w", err)
+		}
+		defer statsd.Close()
+	}
+
+	var remoteWriter *metrics.RemoteWriter
+	if *remoteWriteURL != "" {
+		remoteWriter, err = metrics.NewRemoteWriter(metrics.RemoteWriteConfig{
+			Endpoint:       *remoteWriteURL,
+			Username:       *remoteWriteUsername,
+			PasswordFile:   *remoteWritePasswordFile,
+			CAFile:         *remoteWriteCAFile,
+			CertFile:       *remoteWriteCertFile,
+			KeyFile:        *remoteWriteKeyFile,
+			ExternalLabels: parseLabels(*remoteWriteLabels),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to create remote-write client: %w", err)
+		}
+	}
+
+	var notify *notifier.Notifier
+	if *notifierURL != "" {
+		var notifierTemplate []byte
+		if *notifierTemplateFile != "" {
+			notifierTemplate, err = os.ReadFile(*notifierTemplateFile)
+			if err != nil {
+				return fmt.Errorf("Failed to read notifier template: %w", err)
+			}
+		}
+		notify, err = notifier.New(notifier.Config{
+			URL:               *notifierURL,
+			Method:            *notifierMethod,
+			Template:          string(notifierTemplate),
+			ContentType:       *notifierContentType,
+			Headers:           parseLabels(*notifierHeaders),
+			AuthorizationFile: *notifierAuthorizationFile,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to create notifier: %w", err)
+		}
+	}
+	var notifiers []notifier.Sender
+	if notify != nil {
+		notifiers = append(notifiers, notify)
+	}
+	if *notificationSinks {
+		notifiers = append(notifiers, sinks.NewSinks(recorderK8sClient.Discovery().RESTClient(), sinks.Config{Namespace: *namespace, RequestTimeout: *kubeRequestTimeout}))
+	}
+
+	var auditor *audit.Auditor
+	var auditSinks []audit.Sink
+	if *auditFile != "" {
+		auditFileSink, err := audit.NewFileSink(*auditFile)
+		if err != nil {
+			return fmt.Errorf("Failed to open audit file: %w", err)
+		}
+		defer auditFileSink.Close()
+		auditSinks = append(auditSinks, auditFileSink)
+	}
+	if *auditWebhookURL != "" {
+		auditSinks = append(auditSinks, audit.NewWebhookSink(*auditWebhookURL))
+	}
+	if len(auditSinks) > 0 {
+		auditor = audit.NewAuditor(audit.NewActor(reportNode), auditSinks...)
+	}
+
+	var attestor *attestation.Attestor
+	var attestationSinks []attestation.Sink
+	if *attestationFile != "" {
+		attestationFileSink, err := attestation.NewFileSink(*attestationFile)
+		if err != nil {
+			return fmt.Errorf("Failed to open attestation file: %w", err)
+		}
+		defer attestationFileSink.Close()
+		attestationSinks = append(attestationSinks, attestationFileSink)
+	}
+	if *attestationWebhookURL != "" {
+		attestationSinks = append(attestationSinks, attestation.NewWebhookSink(*attestationWebhookURL))
+	}
+	if len(attestationSinks) > 0 {
+		attestationConfig := attestation.Config{Cluster: *clusterName, Sinks: attestationSinks}
+		if *attestationSigningKey != "" {
+			if attestationConfig.Signer, err = attestation.ReadSigningKey(*attestationSigningKey); err != nil {
+				return err
+			}
+		}
+		attestor = attestation.NewAttestor(attestationConfig)
+	} else if *attestationSigningKey != "" {
+		return fmt.Errorf("--attestation-signing-key-file requires --attestation-file or --attestation-webhook-url")
+	}
+
+	var remediator *remediation.Remediator
+	if *remediationJobs {
+		remediator, err = remediation.NewRemediator(recorderK8sClient, remediation.Config{
+			Image:              *remediationImage,
+			ServiceAccountName: *remediationSA,
+			MaxActiveJobs:      *remediationMaxActiveJobs,
+			RequestTimeout:     *kubeRequestTimeout,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to create remediator: %w", err)
+		}
+	}
+
+	var transformationMonitor *transformation.Monitor
+	if *monitorTransformation {
+		transformationMonitor = transformation.NewMonitor(etcdK8sClient.Discovery().RESTClient(), transformation.Config{RequestTimeout: *kubeRequestTimeout})
+	}
+
+	var recencyChecker *recency.Checker
+	if *rewrittenSince != "" {
+		since, err := parseRewrittenSince(*rewrittenSince)
+		if err != nil {
+			return err
+		}
+		recencyChecker = recency.NewChecker(etcdK8sClient.CoreV1().RESTClient(), recency.Config{Since: since, RequestTimeout: *kubeRequestTimeout})
+	}
+
+	var historyTracker *history.Tracker
+	if *unchangedSecretDays > 0 || *trackDeletedSecrets {
+		store := history.NewConfigMapStore(recorderK8sClient, *namespace, history.ConfigMapName(recorder.ReportName(reportNode)), *kubeRequestTimeout)
+		historyTracker = history.NewTracker(store, history.Config{Days: *unchangedSecretDays})
+	}
+	var teamViews *teams.Views
+	if len(teamList) > 0 {
+		teamViews = teams.NewViews(etcdK8sClient, teams.Config{Teams: teamList, RequestTimeout: *kubeRequestTimeout})
+	}
+	var collector *retention.Collector
+	if *reportRetention > 0 {
+		collector = retention.NewCollector(recorderK8sClient, retentionConfig(shardConfig, reportNode, extraResources, teamList))
 	}
 
 	// Initialize operators
-	recorderOperator := recorder.NewRecorderOperator(recorderK8sClient)
-	etcdOperator := reader.NewReadOperator(etcdClientOperator, etcdK8sClient, recorderOperator, *kmsProviderName)
+	recorderOperator, err := buildRecorder(recorderK8sClient, recorderConfig)
+	if err != nil {
+		return err
+	}
+	var analyzerCache *analyzer.Cache
+	if *incrementalScan {
+		if *kineCompat {
+			klog.InfoS("Ignoring --incremental-scan with --kine-compat")
+		} else {
+			analyzerCache = analyzer.NewCache()
+		}
+	}
+	eventEmitter := events.NewKubeEmitter(recorderK8sClient, recorder.ReportObjectReference(*namespace, reportNode))
+	etcdOperator := reader.NewReadOperator(etcdClientOperator, etcdK8sClient, recorderOperator, reader.Config{
+		Analyzer: analyzer.Config{
+			Prefix:               secretsPrefix(),
+			KeyRoot:              *etcdKeyRoot,
+			PageSize:             *etcdPageSize,
+			AdaptivePageSize:     *etcdAdaptivePageSize,
+			MinPageSize:          *etcdMinPageSize,
+			MaxPageSize:          *etcdMaxPageSize,
+			MaxPageBytes:         *etcdMaxPageBytes,
+			Timeout:              *etcdRequestTimeout,
+			Kine:                 *kineCompat,
+			MaxSecretNames:       *maxSecretNames,
+			LargestSecrets:       *largestSecrets,
+			OldestSecrets:        *oldestProviderSecrets,
+			ParseErrorLogFirst:   *parseErrorLogFirst,
+			ParseErrorLogEvery:   *parseErrorLogEvery,
+			CheckRevisionSkew:    *checkRevisionSkew,
+			CountStaleNamespaces: *remediationJobs,
+			Cache:                analyzerCache,
+			ProviderMatcher:      providerMatcher,
+			Comparison:           comparison,
+		},
+		Shard:              shardConfig,
+		ExtraPrefixes:      extraPrefixes,
+		ShardStore:         shard.NewConfigMapStore(recorderK8sClient, *kubeRequestTimeout, shardPartialMaxAge()),
+		KubeRequestTimeout: *kubeRequestTimeout,
+		TargetProvider:     targetProvider,
+		Alerts:             alerts,
+		StatsD:             statsd,
+		RemoteWrite:        remoteWriter,
+		Events:             eventEmitter,
+		Notifier:           notifier.Join(notifiers...),
+		Audit:              auditor,
+		Attestor:           attestor,
+		Remediator:         remediator,
+		EtcdEndpoints:      etcdConnection.Endpoints,
+		Members:            members,
+		Transformation:     transformationMonitor,
+		Recency:            recencyChecker,
+		History:            historyTracker,
+		Teams:              teamViews,
+		CountsOnly:         *countsOnly,
+		Retention:          collector,
+	})
+
+	runnerConfig := runner.Config{
+		Namespace: *namespace,
+		Timeout:   onceRunTimeout(ctx),
+		Events:    eventEmitter,
+		Audit:     auditor,
+	}
+	// Only the replica writing the report records the run status in it
+	if !shardConfig.Enabled() || shardConfig.IsLeader() {
+		runnerConfig.Status = recorderOperator
+	}
+	if *runLock {
+		if runnerConfig.Lock, err = buildRunLock(recorderK8sClient, reportNode); err != nil {
+			return err
+		}
+	}
+	reporterRunner := runner.NewRunner(etcdOperator, runnerConfig)
 
-	// Run once at startup
-	if err := etcdOperator.Read(ctx, *namespace); err != nil {
-		klog.ErrorS(err, "Failed to read etcd")
+	mgmtServer, err := server.NewServer(serverConfig, reporterRunner, func(ctx context.Context) (map[string]string, error) {
+		data, err := recorder.GetReport(ctx, recorderK8sClient, *namespace, reportNode)
+		return keyNames.Restore(data), err
+	}, etcdK8sClient)
+	if err != nil {
+		return fmt.Errorf("Failed to create management server: %w", err)
+	}
+	// A single run serves nothing
+	if !*once {
+		if err := mgmtServer.Start(ctx); err != nil {
+			return fmt.Errorf("Failed to start management server: %w", err)
+		}
+	}
+	// The exporter pushes the final metric values on shutdown, which is waited for below
+	exporterCtx, stopExporter := context.WithCancel(ctx)
+	defer stopExporter()
+	exporterDone := make(chan struct{})
+	if *otlpEndpoint != "" {
+		exporter := &metrics.OTLPExporter{Endpoint: *otlpEndpoint, Headers: metrics.OTLPHeadersFromEnv(), Interval: *otlpInterval}
+		go func() {
+			defer close(exporterDone)
+			exporter.Run(exporterCtx)
+		}()
+	} else {
+		close(exporterDone)
 	}
 
-	ticker := time.NewTicker(*runInterval)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			klog.Info("Received termination signal, shutting down gracefully...")
+	if *once {
+		runErr := reporterRunner.RunOnce(ctx)
+		onceReport = readReportSummary(ctx, recorderK8sClient, reportNode, keyNames)
+		stopExporter()
+		<-exporterDone
+		if errors.Is(runErr, runner.ErrSkipped) {
+			klog.InfoS("Run skipped", "reason", runErr.Error())
+			onceSkipped = runErr
 			return nil
-		case <-ticker.C:
-			if err := etcdOperator.Read(ctx, *namespace); err != nil {
-				klog.ErrorS(err, "Failed to read etcd")
-			}
 		}
+		if runErr != nil {
+			return fmt.Errorf("Run failed: %w", runErr)
+		}
+		klog.Info("Run completed")
+		return nil
+	}
+	reporterRunner.Run(ctx, *runInterval)
+	<-exporterDone
+	return nil
+}
+
+// buildStaticPodMode validates --deployment-mode and returns the node whose report is written, which is
+// empty unless running in static-pod mode. In static-pod mode discovery defaults to the kubeadm defaults
+// for the local etcd member unless every etcd flag is set.
+func buildStaticPodMode(discoveryMode *etcd.DiscoveryMode) (string, error) {
+	switch *deploymentMode {
+	case deploymentModeDeployment:
+		return "", nil
+	case deploymentModeStaticPod:
+	default:
+		return "", fmt.Errorf("invalid deployment mode %q: must be %q or %q", *deploymentMode, deploymentModeDeployment, deploymentModeStaticPod)
+	}
+
+	if *shardCount > 1 {
+		return "", fmt.Errorf("sharding is not supported, every node reports on the whole cluster")
+	}
+	if *discoveryMode == etcd.DiscoveryNone && (*etcdEndpoint == "" || *etcdClientCrt == "" || *etcdClientKey == "" || *etcdClientCaCrt == "") {
+		*discoveryMode = etcd.DiscoveryKubeadm
+	}
+
+	node := *nodeName
+	if node == "" {
+		node = os.Getenv("NODE_NAME")
+	}
+	if node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("failed to get hostname: %w", err)
+		}
+		node = hostname
+	}
+	klog.InfoS("Running in static-pod mode", "node", node)
+	return node, nil
+}
+
+// createEtcdClient connects to etcd, or loads --etcd-fixture when set, and returns the connection
+// details it used, which are empty for a fixture
+func createEtcdClient(ctx context.Context, clientset kubernetes.Interface, mode etcd.DiscoveryMode) (etcd.EtcdClientOperator, etcd.ConnectionConfig, error) {
+	if *etcdFixture != "" {
+		client, err := etcd.NewFixtureClient(*etcdFixture)
+		if err != nil {
+			return nil, etcd.ConnectionConfig{}, fmt.Errorf("Failed to load etcd fixture: %w", err)
+		}
+		klog.InfoS("Analyzing etcd fixture instead of etcd", "path", *etcdFixture)
+		return client, etcd.ConnectionConfig{}, nil
+	}
+
+	etcdConnection, err := buildEtcdConnection(ctx, clientset, mode)
+	if err != nil {
+		return nil, etcd.ConnectionConfig{}, fmt.Errorf("Failed to discover etcd: %w", err)
+	}
+	clientOptions, err := etcdClientOptions()
+	if err != nil {
+		return nil, etcd.ConnectionConfig{}, err
+	}
+	client, err := etcd.CreateEtcdClient(strings.Join(etcdConnection.Endpoints, ","), etcdConnection.CertFile, etcdConnection.KeyFile, etcdConnection.CAFile, clientOptions)
+	if err != nil {
+		return nil, etcd.ConnectionConfig{}, fmt.Errorf("Failed to create etcd client: %w", err)
+	}
+	rateLimit := etcd.RateLimitConfig{RequestsPerSecond: *etcdMaxRequestsPerSecond, BytesPerSecond: *etcdMaxBytesPerSecond}
+	if rateLimit.Enabled() {
+		klog.InfoS("Rate limiting etcd reads", "requestsPerSecond", rateLimit.RequestsPerSecond, "bytesPerSecond", rateLimit.BytesPerSecond)
+		client = etcd.NewRateLimitedClient(client, rateLimit)
+	}
+	// Retries wait for the rate limits like any other request
+	retry := etcd.RetryConfig{Retries: *etcdRetries, InitialBackoff: *etcdRetryBackoff, MaxBackoff: *etcdRetryMaxBackoff}
+	if retry.Enabled() {
+		client = etcd.NewRetryClient(client, retry)
+	}
+	// The breaker wraps the rate limiter and the retries, so requests skipped by an open circuit
+	// consume no tokens and a request counts as a single failure once its retries are exhausted
+	breaker := etcd.BreakerConfig{Failures: *etcdBreakerFailures, Window: *etcdBreakerWindow, CoolDown: *etcdBreakerCoolDown}
+	if breaker.Enabled() {
+		client = etcd.NewBreakerClient(client, breaker)
+	}
+	return client, etcdConnection, nil
+}
+
+// createMemberComparer connects to every etcd endpoint separately, to compare the members
+func createMemberComparer(connection etcd.ConnectionConfig) (*etcd.MemberComparer, error) {
+	if *etcdFixture != "" {
+		return nil, fmt.Errorf("--compare-etcd-members is not supported with --etcd-fixture")
+	}
+	clientOptions, err := etcdClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	comparer, err := etcd.ConnectMembers(connection.Endpoints, connection.CertFile, connection.KeyFile, connection.CAFile, clientOptions, etcd.MemberComparerConfig{
+		MaxLag:         *maxEtcdMemberLag,
+		RequestTimeout: *etcdRequestTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to compare etcd members: %w", err)
+	}
+	klog.InfoS("Comparing etcd members after every scan", "endpoints", connection.Endpoints, "maxLag", *maxEtcdMemberLag)
+	return comparer, nil
+}
+
+// etcdClientOptions returns the etcd connection options set by flags
+func etcdClientOptions() (etcd.ClientOptions, error) {
+	minVersion, err := etcd.ParseTLSVersion(*etcdTLSMinVersion)
+	if err != nil {
+		return etcd.ClientOptions{}, fmt.Errorf("Invalid --etcd-tls-min-version: %w", err)
+	}
+	cipherSuites, err := etcd.ParseCipherSuites(splitList(*etcdCipherSuites))
+	if err != nil {
+		return etcd.ClientOptions{}, fmt.Errorf("Invalid --etcd-tls-cipher-suites: %w", err)
+	}
+	if len(cipherSuites) > 0 && minVersion == tls.VersionTLS13 {
+		klog.Warning("--etcd-tls-cipher-suites has no effect with TLS 1.3, whose cipher suites are not configurable")
+	}
+	if *etcdGRPCBackoff < 0 {
+		return etcd.ClientOptions{}, fmt.Errorf("Invalid --etcd-grpc-backoff %s: must not be negative", *etcdGRPCBackoff)
+	}
+	if *etcdGRPCJitter < 0 || *etcdGRPCJitter > 1 {
+		return etcd.ClientOptions{}, fmt.Errorf("Invalid --etcd-grpc-backoff-jitter %g: must be in [0, 1]", *etcdGRPCJitter)
+	}
+	if *etcdInsecure {
+		klog.Warning("INSECURE: --etcd-insecure-skip-tls-verify is set, the etcd server certificate is not verified. Never use it outside disposable test clusters")
+	}
+	return etcd.ClientOptions{
+		DialTimeout:        *etcdDialTimeout,
+		TLSMinVersion:      minVersion,
+		CipherSuites:       cipherSuites,
+		InsecureSkipVerify: *etcdInsecure,
+		GRPCMaxRetries:     *etcdGRPCMaxRetries,
+		GRPCBackoff:        *etcdGRPCBackoff,
+		GRPCBackoffJitter:  *etcdGRPCJitter,
+	}, nil
+}
+
+// buildEtcdConnection returns the etcd connection details from flags, filling in the unset ones by discovery if enabled
+func buildEtcdConnection(ctx context.Context, clientset kubernetes.Interface, mode etcd.DiscoveryMode) (etcd.ConnectionConfig, error) {
+	connection := etcd.ConnectionConfig{
+		Endpoints: splitList(*etcdEndpoint),
+		CertFile:  *etcdClientCrt,
+		KeyFile:   *etcdClientKey,
+		CAFile:    *etcdClientCaCrt,
+	}
+	if mode == etcd.DiscoveryNone {
+		return connection, nil
+	}
+
+	discoveryCtx, cancel := utils.ContextWithTimeout(ctx, *kubeRequestTimeout)
+	defer cancel()
+	discovered, err := etcd.Discover(discoveryCtx, clientset, mode)
+	if err != nil {
+		return etcd.ConnectionConfig{}, err
+	}
+	return discovered.Override(connection), nil
+}
+
+// buildServerConfig builds the configuration of the metrics, control and webhook servers from flags
+func buildServerConfig() server.Config {
+	return server.Config{
+		MetricsBindAddress: *metricsBindAddress,
+		ControlBindAddress: *controlBindAddress,
+		TLSCertFile:        *controlTLSCertFile,
+		TLSKeyFile:         *controlTLSKeyFile,
+		ClientCAFile:       *controlClientCAFile,
+		TokenAuth:          *controlTokenAuth,
+		TokenAudiences:     splitList(*controlTokenAudience),
+		AllowedUsers:       splitList(*controlAllowedUsers),
+		AllowedGroups:      splitList(*controlAllowedGroups),
+		WebhookBindAddress: *webhookBindAddress,
+		WebhookTLSCertFile: *webhookTLSCertFile,
+		WebhookTLSKeyFile:  *webhookTLSKeyFile,
+		Webhook:            webhook.Config{Namespace: *namespace, Deny: *webhookDeny},
+	}
+}
+
+// shardPartialMaxAge returns the age after which the partial result of a shard is stale
+func shardPartialMaxAge() time.Duration {
+	if *shardMaxAge > 0 {
+		return *shardMaxAge
+	}
+	return 2 * *runInterval
+}
+
+// buildShardConfig builds the shard configuration from flags, deriving the shard index from the hostname if unset
+func buildShardConfig() (shard.Config, error) {
+	config := shard.Config{
+		Index:      *shardIndex,
+		Count:      *shardCount,
+		Mode:       shard.Mode(*shardMode),
+		Boundaries: splitList(*shardBoundaries),
+	}
+	if config.Enabled() && config.Index < 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return shard.Config{}, fmt.Errorf("failed to get hostname: %w", err)
+		}
+		if config.Index, err = shard.IndexFromHostname(hostname); err != nil {
+			return shard.Config{}, err
+		}
+	}
+	if !config.Enabled() {
+		config.Index = 0
+	}
+	if err := config.Validate(); err != nil {
+		return shard.Config{}, err
+	}
+	if config.Enabled() {
+		klog.InfoS("Sharding enabled", "shard", config.Index, "shards", config.Count, "mode", config.Mode)
+	}
+	return config, nil
+}
+
+// buildExtraPrefixes returns the additional scan prefixes and their resources
+func buildExtraPrefixes(shardConfig shard.Config) (prefixes, resources []string, err error) {
+	prefixes = splitList(*extraEtcdPrefixes)
+	if len(prefixes) > 0 && shardConfig.Enabled() {
+		return nil, nil, fmt.Errorf("not supported with sharding")
+	}
+	root := analyzer.DefaultKeyRoot
+	if *etcdKeyRoot != "" {
+		root = strings.TrimSuffix(*etcdKeyRoot, "/")
+	}
+	seen := map[string]bool{analyzer.ResourceFromPrefix(analyzer.DefaultPrefix): true}
+	for _, prefix := range prefixes {
+		resource := analyzer.ResourceFromPrefix(prefix)
+		if !strings.HasPrefix(prefix, root+"/") || resource == "" {
+			return nil, nil, fmt.Errorf("prefix %q is not of the form %s/<resource> or %s/<group>/<resource>", prefix, root, root)
+		}
+		if seen[resource] {
+			return nil, nil, fmt.Errorf("resource %s is scanned twice", resource)
+		}
+		seen[resource] = true
+		resources = append(resources, resource)
+	}
+	return prefixes, resources, nil
+}
+
+// secretsPrefix returns the etcd prefix of the secrets under --etcd-key-root, or "" for the default
+func secretsPrefix() string {
+	if *etcdKeyRoot == "" {
+		return ""
+	}
+	return analyzer.KeyRootPrefix(*etcdKeyRoot, analyzer.ResourceFromPrefix(analyzer.DefaultPrefix))
+}
+
+// exitCode maps a setup error to the process exit code
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, rbac.ErrMissingPermissions):
+		return exitCodeMissingPermissions
+	case errors.Is(err, etcd.ErrEtcdUnavailable):
+		return exitCodeEtcdUnavailable
+	case errors.Is(err, reader.ErrEncryptionConfigNotFound):
+		return exitCodeEncryptionConfigNotFound
+	default:
+		return exitCodeFailure
+	}
+}
+
+// checkPermissions verifies the RBAC permissions of both Kubernetes clients, reporting
+// the missing permissions of each identity separately.
+func checkPermissions(ctx context.Context, etcdClient, recorderClient kubernetes.Interface, serverConfig server.Config, shardConfig shard.Config, discoveryMode etcd.DiscoveryMode, reportNode string, extraResources []string, teamList []teams.Team) error {
+	readerPermissions, recorderPermissions := requiredPermissions(serverConfig, shardConfig, discoveryMode, reportNode, extraResources, teamList)
+	if err := rbac.Check(ctx, etcdClient, readerPermissions); err != nil {
+		return fmt.Errorf("reader client: %w", err)
+	}
+	if err := rbac.Check(ctx, recorderClient, recorderPermissions); err != nil {
+		return fmt.Errorf("recorder client: %w", err)
+	}
+	return nil
+}
+
+// requiredPermissions lists the RBAC permissions the reader and the recorder clients need with the flags set.
+func requiredPermissions(serverConfig server.Config, shardConfig shard.Config, discoveryMode etcd.DiscoveryMode, reportNode string, extraResources []string, teamList []teams.Team) (readerPermissions, recorderPermissions []rbac.Permission) {
+	readerPermissions = append(reader.RequiredPermissions(*namespace), server.RequiredPermissions(serverConfig)...)
+	readerPermissions = append(readerPermissions, etcd.DiscoveryRequiredPermissions(discoveryMode)...)
+	if *rewrittenSince != "" {
+		readerPermissions = append(readerPermissions, recency.RequiredPermissions()...)
+	}
+	if *monitorTransformation {
+		readerPermissions = append(readerPermissions, transformation.RequiredPermissions()...)
+	}
+	readerPermissions = append(readerPermissions, teams.RequiredPermissions(teamList)...)
+	recorderPermissions = append(recorder.RequiredPermissions(*namespace, reportNode, *ownerDeployment, *patchReport, extraResources...), shard.RequiredPermissions(*namespace, shardConfig)...)
+	recorderPermissions = append(recorderPermissions, events.RequiredPermissions(*namespace)...)
+	if *createNamespace {
+		recorderPermissions = append(recorderPermissions, recorder.NamespaceRequiredPermissions()...)
+	}
+	if *remediationJobs {
+		recorderPermissions = append(recorderPermissions, remediation.RequiredPermissions()...)
+	}
+	if *unchangedSecretDays > 0 || *trackDeletedSecrets {
+		recorderPermissions = append(recorderPermissions, history.RequiredPermissions(*namespace, history.ConfigMapName(recorder.ReportName(reportNode)))...)
+	}
+	if *notificationSinks {
+		recorderPermissions = append(recorderPermissions, sinks.RequiredPermissions(*namespace)...)
+	}
+	if *runLock {
+		recorderPermissions = append(recorderPermissions, runlock.RequiredPermissions(*namespace, runlock.LeaseName(recorder.ReportName(reportNode)))...)
+	}
+	for _, team := range teamList {
+		recorderPermissions = append(recorderPermissions, recorder.TeamRequiredPermissions(team.ReportNamespace, reportNode, team.Name, *patchReport)...)
+	}
+	if *reportRetention > 0 && (!shardConfig.Enabled() || shardConfig.IsLeader()) {
+		recorderPermissions = append(recorderPermissions, retention.RequiredPermissions(retentionConfig(shardConfig, reportNode, extraResources, teamList))...)
+	}
+	return readerPermissions, recorderPermissions
+}
+
+// retentionConfig configures the collection of the reports and partial results no longer written with
+// the flags set, keeping those written on reportNode.
+func retentionConfig(shardConfig shard.Config, reportNode string, extraResources []string, teamList []teams.Team) retention.Config {
+	keep := map[string][]string{*namespace: append(shard.PartialConfigMapNames(shardConfig), recorder.ResourceReportName(reportNode, ""))}
+	for _, resource := range extraResources {
+		keep[*namespace] = append(keep[*namespace], recorder.ResourceReportName(reportNode, resource))
+	}
+	for _, team := range teamList {
+		keep[team.ReportNamespace] = append(keep[team.ReportNamespace], recorder.TeamReportName(reportNode, team.Name))
+	}
+	return retention.Config{
+		Namespace:      *namespace,
+		NodeName:       reportNode,
+		Keep:           keep,
+		Retention:      *reportRetention,
+		RequestTimeout: *kubeRequestTimeout,
+	}
+}
+
+// parseRewrittenSince parses --rewritten-since, a date or an RFC 3339 time.
+func parseRewrittenSince(value string) (time.Time, error) {
+	if since, err := time.Parse(time.DateOnly, value); err == nil {
+		return since, nil
+	}
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid --rewritten-since %q: must be a date, e.g. 2025-01-31, or an RFC 3339 time", value)
+	}
+	return since, nil
+}
+
+// buildRunLock builds the lock excluding concurrent runs writing the report of reportNode. Each process
+// holds it under its own identity, so that two pods with the same hostname never share it.
+func buildRunLock(clientset kubernetes.Interface, reportNode string) (*runlock.Lock, error) {
+	if *runLockDuration <= 0 {
+		return nil, fmt.Errorf("Invalid --run-lock-duration %s: must be positive", *runLockDuration)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get hostname: %w", err)
+	}
+	return runlock.NewLock(clientset, runlock.Config{
+		Namespace:      *namespace,
+		Name:           runlock.LeaseName(recorder.ReportName(reportNode)),
+		Identity:       hostname + "_" + string(uuid.NewUUID()),
+		Duration:       *runLockDuration,
+		RequestTimeout: *kubeRequestTimeout,
+	}), nil
+}
+
+// parseLabels parses a comma-separated list of name=value pairs, dropping entries without a name
+func parseLabels(value string) map[string]string {
+	labels := map[string]string{}
+	for _, pair := range splitList(value) {
+		name, labelValue, _ := strings.Cut(pair, "=")
+		if name = strings.TrimSpace(name); name != "" {
+			labels[name] = strings.TrimSpace(labelValue)
+		}
+	}
+	return labels
+}
+
+// buildRecorder returns the report ConfigMap recorder, followed by the --recorder-plugins. With
+// --counts-only or --report-recipients-file, the plugins get no secret name.
+func buildRecorder(clientset kubernetes.Interface, config recorder.Config) (recorder.RecorderOperator, error) {
+	recorders := []recorder.RecorderOperator{recorder.NewRecorderOperator(clientset, config)}
+	for _, command := range splitList(*recorderPlugins) {
+		pluginRecorder, err := plugin.New(plugin.Config{Command: []string{command}, Timeout: *recorderPluginTimeout})
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --recorder-plugins %s: %w", command, err)
+		}
+		if config.CountsOnly || len(config.Recipients) > 0 {
+			recorders = append(recorders, recorder.NewRedactingRecorder(pluginRecorder))
+			continue
+		}
+		recorders = append(recorders, pluginRecorder)
 	}
+	return recorder.NewMultiRecorder(recorders...), nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 // createK8sClients creates separate Kubernetes clients for etcd reader and recorder
 func createK8sClients() (etcdClient, recorderClient *kubernetes.Clientset, err error) {
-	// Always use in-cluster config for etcd reader
-	etcdConfig, err := rest.InClusterConfig()
+	etcdConfig, err := readerRestConfig()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create in-cluster config for etcd reader: %w", err)
+		return nil, nil, err
 	}
+	etcdConfig.UserAgent = version.UserAgent("reader")
+	setRateLimits(etcdConfig)
 	etcdClient, err = kubernetes.NewForConfig(etcdConfig)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create k8s client for etcd reader: %w", err)
@@ -108,10 +1180,12 @@ func createK8sClients() (etcdClient, recorderClient *kubernetes.Clientset, err e
 			return nil, nil, fmt.Errorf("failed to load kubeconfig for recorder: %w", err)
 		}
 	} else {
-		klog.Info("Using in-cluster config for recorder")
-		recorderConfig = etcdConfig
+		klog.Info("Using the etcd reader config for recorder")
+		recorderConfig = rest.CopyConfig(etcdConfig)
 	}
 
+	recorderConfig.UserAgent = version.UserAgent("recorder")
+	setRateLimits(recorderConfig)
 	recorderClient, err = kubernetes.NewForConfig(recorderConfig)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create k8s client for recorder: %w", err)
@@ -119,3 +1193,51 @@ func createK8sClients() (etcdClient, recorderClient *kubernetes.Clientset, err e
 
 	return etcdClient, recorderClient, nil
 }
+
+// setRateLimits applies --kube-api-qps and --kube-api-burst to the client config, keeping the
+// client-go defaults for the unset flags.
+func setRateLimits(config *rest.Config) {
+	if *kubeAPIQPS > 0 {
+		config.QPS = float32(*kubeAPIQPS)
+	}
+	if *kubeAPIBurst > 0 {
+		config.Burst = *kubeAPIBurst
+	}
+}
+
+// readerRestConfig returns the config of the etcd reader client: --reader-kubeconfig if set, otherwise
+// the in-cluster config. Outside a cluster it falls back to --kubeconfig, then to the default kubeconfig
+// loading rules, so the reporter can be run from a workstation for debugging.
+func readerRestConfig() (*rest.Config, error) {
+	path := *readerKubeconfig
+	// Static pods cannot use a service account, so both clients share --kubeconfig
+	if path == "" && *deploymentMode == deploymentModeStaticPod {
+		path = *kubeconfig
+	}
+	if path != "" {
+		klog.Infof("Using kubeconfig file for etcd reader: %s", path)
+		config, err := clientcmd.BuildConfigFromFlags("", path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig for etcd reader: %w", err)
+		}
+		return config, nil
+	}
+
+	config, err := rest.InClusterConfig()
+	if err == nil {
+		klog.Info("Using in-cluster config for etcd reader")
+		return config, nil
+	}
+	if !errors.Is(err, rest.ErrNotInCluster) {
+		return nil, fmt.Errorf("failed to create in-cluster config for etcd reader: %w", err)
+	}
+
+	klog.Info("Not running in a cluster, using kubeconfig for etcd reader")
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = *kubeconfig
+	config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig for etcd reader outside a cluster: %w", err)
+	}
+	return config, nil
+}
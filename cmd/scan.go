@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// scanFlags are the etcd and target provider flags of the subcommands scanning etcd directly.
+type scanFlags struct {
+	endpoint           *string
+	clientCrt          *string
+	clientKey          *string
+	clientCaCrt        *string
+	pageSize           *int64
+	adaptivePageSize   *bool
+	keyRoot            *string
+	providerName       *string
+	providerRegex      *string
+	providerComparison *string
+	targetName         *string
+	targetSeq          *int
+}
+
+// addScanFlags registers the scan flags on flags.
+func addScanFlags(flags *flag.FlagSet) *scanFlags {
+	return &scanFlags{
+		endpoint:           flags.String("etcd-endpoint", "", "The etcd endpoint, or a comma-separated list of endpoints"),
+		clientCrt:          flags.String("etcd-client-crt", "", "The etcd client certificate"),
+		clientKey:          flags.String("etcd-client-key", "", "The etcd client key"),
+		clientCaCrt:        flags.String("etcd-client-ca-crt", "", "The etcd client CA certificate"),
+		pageSize:           flags.Int64("etcd-page-size", 0, "The maximum number of keys read from etcd per request. 0 reads all secrets in a single request"),
+		keyRoot:            flags.String("etcd-key-root", "", "The storage prefix of the API server (its --etcd-prefix), e.g. /registry, when it is not /registry. Secrets are scanned under <root>/secrets"),
+		adaptivePageSize:   flags.Bool("etcd-adaptive-page-size", false, "Adapt the page size to the time pages take and the size of the secrets read, starting at --etcd-page-size"),
+		providerName:       flags.String("kms-provider-name", "kmsprovider", "The prefix of the KMS provider name in the encryption configuration"),
+		providerRegex:      flags.String("kms-provider-regex", "", "Regex matching KMS provider names, with a named capture group \"seq\" for the ordering token. Overrides --kms-provider-name"),
+		providerComparison: flags.String("provider-comparison", string(analyzer.ComparisonSequence), "How secrets are compared against the target provider: \"sequence\" or \"name\""),
+		targetName:         flags.String("target-provider-name", "", "The target KMS provider secrets are compared against"),
+		targetSeq:          flags.Int("target-seq", -1, "The sequence of the target provider in sequence comparison. Parsed from --target-provider-name when negative"),
+	}
+}
+
+// build validates the flags and returns the etcd client and the analyzer configuration comparing
+// secrets against the target provider, which is only optional when requireTarget is false. The client
+// must be closed.
+func (f *scanFlags) build(requireTarget bool) (etcd.EtcdClientOperator, analyzer.Config, error) {
+	if *f.endpoint == "" {
+		return nil, analyzer.Config{}, fmt.Errorf("--etcd-endpoint is required")
+	}
+	hasTarget := *f.targetName != "" || *f.targetSeq >= 0
+	if requireTarget && !hasTarget {
+		return nil, analyzer.Config{}, fmt.Errorf("--target-seq or --target-provider-name is required")
+	}
+	matcher, err := utils.NewProviderNameMatcher(*f.providerName, *f.providerRegex)
+	if err != nil {
+		return nil, analyzer.Config{}, fmt.Errorf("Failed to create provider name matcher: %w", err)
+	}
+	comparison, err := analyzer.ParseComparisonMode(*f.providerComparison)
+	if err != nil {
+		return nil, analyzer.Config{}, err
+	}
+	var target analyzer.LatestProvider
+	if hasTarget {
+		if target, err = analyzer.NewTargetProvider(*f.targetName, *f.targetSeq, matcher, comparison); err != nil {
+			return nil, analyzer.Config{}, fmt.Errorf("Invalid target provider: %w", err)
+		}
+	}
+
+	client, err := etcd.CreateEtcdClient(*f.endpoint, *f.clientCrt, *f.clientKey, *f.clientCaCrt, etcd.ClientOptions{})
+	if err != nil {
+		return nil, analyzer.Config{}, fmt.Errorf("Failed to create etcd client: %w", err)
+	}
+	config := analyzer.Config{
+		KeyRoot:          *f.keyRoot,
+		PageSize:         *f.pageSize,
+		AdaptivePageSize: *f.adaptivePageSize,
+		ProviderMatcher:  matcher,
+		Comparison:       comparison,
+		LatestProvider:   analyzer.StaticProvider(target),
+	}
+	if *f.keyRoot != "" {
+		config.Prefix = analyzer.KeyRootPrefix(*f.keyRoot, analyzer.ResourceFromPrefix(analyzer.DefaultPrefix))
+	}
+	return client, config, nil
+}
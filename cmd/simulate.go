@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/simulation"
+)
+
+// runSimulate implements the simulate subcommand: it scans etcd once and evaluates the secrets against a
+// proposed encryption configuration, before it is applied to the API servers.
+func runSimulate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	scanFlags := addScanFlags(flags)
+	configPath := flags.String("config", "", "The proposed EncryptionConfiguration file")
+	maxSecretNames := flags.Int("max-secret-names", 20, "The maximum number of undecryptable secrets listed. 0 lists them all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return fmt.Errorf("--config is required")
+	}
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		return fmt.Errorf("Failed to read --config: %w", err)
+	}
+	proposed, err := analyzer.ParseEncryptionConfiguration(data)
+	if err != nil {
+		return fmt.Errorf("Invalid --config: %w", err)
+	}
+	client, analyzerConfig, err := scanFlags.build(false)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	result, err := simulation.Simulate(ctx, client, proposed, simulation.Config{Analyzer: analyzerConfig, MaxSecretNames: *maxSecretNames})
+	if err != nil {
+		return err
+	}
+	if err := result.Render(os.Stdout); err != nil {
+		return fmt.Errorf("Failed to render the simulation: %w", err)
+	}
+	if result.Undecryptable > 0 {
+		return fmt.Errorf("%d secrets would be undecryptable with %s", result.Undecryptable, *configPath)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/rotation"
+)
+
+// runWait implements the wait subcommand: it scans etcd until every secret is encrypted by the target
+// provider, so that rotation automation can block on it instead of polling the report.
+func runWait(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("wait", flag.ExitOnError)
+	scanFlags := addScanFlags(flags)
+	targetKeyID := flags.String("target-key-id", "", "The KMS v2 key ID every secret must be encrypted with as well. Empty accepts any key ID")
+	timeout := flags.Duration("timeout", time.Hour, "How long to wait for the rotation to complete. 0 waits forever")
+	poll := flags.Duration("poll", rotation.DefaultPollInterval, "The interval between two scans")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	client, analyzerConfig, err := scanFlags.build(true)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	analyzerConfig.TargetKeyID = *targetKeyID
+
+	klog.InfoS("Waiting for every secret to be encrypted by the target provider", "name", *scanFlags.targetName, "seq", *scanFlags.targetSeq, "keyID", *targetKeyID, "timeout", *timeout)
+	result, err := rotation.Wait(ctx, client, rotation.Config{
+		Analyzer:     analyzerConfig,
+		PollInterval: *poll,
+		Timeout:      *timeout,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("All %d secrets are encrypted by the target provider\n", result.Total())
+	return nil
+}
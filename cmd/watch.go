@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/lzhecheng/kms-reporter/pkg/rotation"
+)
+
+// clearScreen moves the cursor home and clears the terminal
+const clearScreen = "\033[H\033[2J"
+
+// runWatch implements the watch subcommand: it scans etcd repeatedly and renders the progress of a
+// rotation in the terminal until interrupted.
+func runWatch(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	scanFlags := addScanFlags(flags)
+	poll := flags.Duration("poll", 10*time.Second, "The interval between two scans")
+	exitOnComplete := flags.Bool("exit-on-complete", false, "Exit once every secret is encrypted by the target provider")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	client, analyzerConfig, err := scanFlags.build(true)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Redraw in place on a terminal, append otherwise, e.g. when piped to a log
+	redraw := term.IsTerminal(int(os.Stdout.Fd()))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var renderErr error
+	rotation.Watch(ctx, client, rotation.Config{Analyzer: analyzerConfig, PollInterval: *poll}, func(status rotation.Status, err error) {
+		if redraw {
+			fmt.Print(clearScreen)
+		}
+		if err != nil {
+			fmt.Printf("%s: scan failed: %v\n", time.Now().Format(time.RFC3339), err)
+		} else if renderErr = status.Render(os.Stdout); renderErr != nil {
+			cancel()
+			return
+		}
+		if !redraw {
+			fmt.Println()
+		}
+		if *exitOnComplete && status.Complete() {
+			cancel()
+		}
+	})
+	if renderErr != nil {
+		return fmt.Errorf("Failed to render status: %w", renderErr)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Package alert decides whether a scan result should alert, debouncing threshold breaches
+// over consecutive runs so that short-lived spikes, e.g. during secret creation storms, do not page.
+package alert
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+// Thresholds configures when a scan result breaches.
+type Thresholds struct {
+	// MaxUnencrypted breaches when more secrets than this are unencrypted. Negative disables the check.
+	MaxUnencrypted int
+	// MinEncryptedPercent breaches when a smaller percentage of secrets is encrypted. 0 disables the check.
+	MinEncryptedPercent float64
+	// ConsecutiveRuns is the number of consecutive breaching runs after which the alert fires. Defaults to 1.
+	ConsecutiveRuns int
+}
+
+// Enabled reports whether any threshold is set.
+func (t Thresholds) Enabled() bool {
+	return t.MaxUnencrypted >= 0 || t.MinEncryptedPercent > 0
+}
+
+// Validate checks that the thresholds are within range.
+func (t Thresholds) Validate() error {
+	if t.MinEncryptedPercent < 0 || t.MinEncryptedPercent > 100 {
+		return fmt.Errorf("minimum encrypted percentage %v must be between 0 and 100", t.MinEncryptedPercent)
+	}
+	if t.ConsecutiveRuns < 0 {
+		return fmt.Errorf("consecutive runs %d must not be negative", t.ConsecutiveRuns)
+	}
+	return nil
+}
+
+// Breaches returns a description of every threshold result breaches.
+func (t Thresholds) Breaches(result analyzer.Result) []string {
+	var breaches []string
+	unencrypted := result.UnencryptedCount()
+	if t.MaxUnencrypted >= 0 && unencrypted > t.MaxUnencrypted {
+		breaches = append(breaches, fmt.Sprintf("%d unencrypted secrets exceed the maximum of %d", unencrypted, t.MaxUnencrypted))
+	}
+	// An empty result is fully encrypted
+	if total := result.Total(); t.MinEncryptedPercent > 0 && total > 0 {
+		percent := 100 * float64(result.EncryptedCount()) / float64(total)
+		if percent < t.MinEncryptedPercent {
+			breaches = append(breaches, fmt.Sprintf("%.1f%% of secrets encrypted is below the minimum of %v%%", percent, t.MinEncryptedPercent))
+		}
+	}
+	return breaches
+}
+
+// State is the outcome of evaluating a scan result.
+type State struct {
+	// Breaches describes the thresholds the latest result breaches.
+	Breaches []string
+	// ConsecutiveBreaches is the number of consecutive runs, including the latest, that breached.
+	ConsecutiveBreaches int
+	// Firing is set once ConsecutiveBreaches reaches Thresholds.ConsecutiveRuns, until a run no longer breaches.
+	Firing bool
+	// Changed is set when Firing differs from the previous evaluation.
+	Changed bool
+}
+
+// Evaluator applies thresholds to the results of consecutive runs. It is safe for concurrent use.
+type Evaluator struct {
+	mu          sync.Mutex
+	thresholds  Thresholds
+	consecutive int
+	firing      bool
+}
+
+func NewEvaluator(thresholds Thresholds) *Evaluator {
+	if thresholds.ConsecutiveRuns < 1 {
+		thresholds.ConsecutiveRuns = 1
+	}
+	return &Evaluator{thresholds: thresholds}
+}
+
+// Evaluate records the result of a run and returns the resulting alert state.
+func (e *Evaluator) Evaluate(result analyzer.Result) State {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	breaches := e.thresholds.Breaches(result)
+	if len(breaches) > 0 {
+		e.consecutive++
+	} else {
+		e.consecutive = 0
+	}
+
+	wasFiring := e.firing
+	e.firing = e.consecutive >= e.thresholds.ConsecutiveRuns
+	return State{
+		Breaches:            breaches,
+		ConsecutiveBreaches: e.consecutive,
+		Firing:              e.firing,
+		Changed:             e.firing != wasFiring,
+	}
+}
@@ -0,0 +1,119 @@
+package alert
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+func result(encrypted, unencrypted int) analyzer.Result {
+	r := analyzer.Result{}
+	for i := 0; i < encrypted; i++ {
+		r.EncryptedSecrets = append(r.EncryptedSecrets, "default/encrypted")
+	}
+	for i := 0; i < unencrypted; i++ {
+		r.UnencryptedSecrets = append(r.UnencryptedSecrets, "default/unencrypted")
+	}
+	return r
+}
+
+func TestThresholds_Breaches(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds Thresholds
+		result     analyzer.Result
+		expected   []string
+	}{
+		{
+			name:       "disabled",
+			thresholds: Thresholds{MaxUnencrypted: -1},
+			result:     result(0, 10),
+		},
+		{
+			name:       "unencrypted count at the maximum",
+			thresholds: Thresholds{MaxUnencrypted: 2},
+			result:     result(8, 2),
+		},
+		{
+			name:       "unencrypted count above the maximum",
+			thresholds: Thresholds{MaxUnencrypted: 2},
+			result:     result(7, 3),
+			expected:   []string{"3 unencrypted secrets exceed the maximum of 2"},
+		},
+		{
+			name:       "encrypted percentage below the minimum",
+			thresholds: Thresholds{MaxUnencrypted: -1, MinEncryptedPercent: 99.5},
+			result:     result(99, 1),
+			expected:   []string{"99.0% of secrets encrypted is below the minimum of 99.5%"},
+		},
+		{
+			name:       "no secrets",
+			thresholds: Thresholds{MaxUnencrypted: 0, MinEncryptedPercent: 100},
+			result:     result(0, 0),
+		},
+		{
+			name:       "both thresholds",
+			thresholds: Thresholds{MaxUnencrypted: 0, MinEncryptedPercent: 90},
+			result:     result(1, 1),
+			expected:   []string{"1 unencrypted secrets exceed the maximum of 0", "50.0% of secrets encrypted is below the minimum of 90%"},
+		},
+		{
+			name:       "omitted names are counted",
+			thresholds: Thresholds{MaxUnencrypted: 2, MinEncryptedPercent: 50},
+			result:     analyzer.Result{UnencryptedSecrets: []string{"default/a"}, OmittedUnencrypted: 2, OmittedEncrypted: 1},
+			expected:   []string{"3 unencrypted secrets exceed the maximum of 2", "25.0% of secrets encrypted is below the minimum of 50%"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.thresholds.Breaches(tt.result))
+		})
+	}
+}
+
+func TestThresholds_Validate(t *testing.T) {
+	assert.NoError(t, Thresholds{MaxUnencrypted: -1, MinEncryptedPercent: 100, ConsecutiveRuns: 3}.Validate())
+	assert.Error(t, Thresholds{MinEncryptedPercent: 101}.Validate())
+	assert.Error(t, Thresholds{ConsecutiveRuns: -1}.Validate())
+
+	assert.False(t, Thresholds{MaxUnencrypted: -1}.Enabled())
+	assert.True(t, Thresholds{MaxUnencrypted: 0}.Enabled())
+	assert.True(t, Thresholds{MaxUnencrypted: -1, MinEncryptedPercent: 50}.Enabled())
+}
+
+func TestEvaluator_Debounce(t *testing.T) {
+	evaluator := NewEvaluator(Thresholds{MaxUnencrypted: 0, ConsecutiveRuns: 3})
+
+	// A single-run blip does not fire
+	state := evaluator.Evaluate(result(9, 1))
+	assert.Equal(t, 1, state.ConsecutiveBreaches)
+	assert.False(t, state.Firing)
+	state = evaluator.Evaluate(result(10, 0))
+	assert.Equal(t, 0, state.ConsecutiveBreaches)
+	assert.Empty(t, state.Breaches)
+
+	evaluator.Evaluate(result(9, 1))
+	evaluator.Evaluate(result(9, 1))
+	state = evaluator.Evaluate(result(9, 1))
+	assert.True(t, state.Firing)
+	assert.True(t, state.Changed)
+	assert.Len(t, state.Breaches, 1)
+
+	state = evaluator.Evaluate(result(9, 1))
+	assert.True(t, state.Firing)
+	assert.False(t, state.Changed)
+
+	// A clean run resolves the alert
+	state = evaluator.Evaluate(result(10, 0))
+	assert.False(t, state.Firing)
+	assert.True(t, state.Changed)
+}
+
+func TestNewEvaluator_DefaultConsecutiveRuns(t *testing.T) {
+	evaluator := NewEvaluator(Thresholds{MaxUnencrypted: 0})
+	state := evaluator.Evaluate(result(0, 1))
+	assert.True(t, state.Firing, "alerts fire on the first breach by default")
+}
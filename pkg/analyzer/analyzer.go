@@ -0,0 +1,982 @@
+// Package analyzer scans secrets stored in etcd and reports which KMS provider encrypted them.
+// It has no dependency on the ConfigMap recorder, so it can be embedded in other controllers.
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+const (
+	// DefaultPrefix is the etcd key prefix under which the API server stores secrets.
+	DefaultPrefix = "/registry/secrets"
+	// DefaultKeyRoot is the default storage prefix of the API server, its --etcd-prefix.
+	DefaultKeyRoot = "/registry"
+	// DefaultTimeout bounds a single etcd request when Config.Timeout is unset, plus
+	// DefaultTimeoutPerKey for every key of a page.
+	DefaultTimeout = 5 * time.Second
+	// DefaultTimeoutPerKey scales the default timeout of paginated range requests with the page size.
+	DefaultTimeoutPerKey = 5 * time.Millisecond
+	// DefaultUnpaginatedTimeout bounds an unpaginated range request, which returns every secret at
+	// once, when Config.Timeout is unset.
+	DefaultUnpaginatedTimeout = 2 * time.Minute
+	// IdentityProviderSeq is the sequence number of the identity (no encryption) provider.
+	IdentityProviderSeq = -1
+	// IdentityProviderName is the name unencrypted secrets are counted under.
+	IdentityProviderName = "identity"
+	// DefaultParseErrorLogFirst is the number of parse errors of each class logged before they are sampled.
+	DefaultParseErrorLogFirst = 10
+	// DefaultParseErrorLogEvery is the sampling interval of the parse errors of each class past the first ones.
+	DefaultParseErrorLogEvery = 1000
+)
+
+// maxSkewSecrets bounds the secrets listed in a RevisionSkew
+const maxSkewSecrets = 100
+
+// ErrInvalidEncryptionConfig is returned when an EncryptionConfiguration cannot be parsed.
+var ErrInvalidEncryptionConfig = errors.New("invalid encryption configuration")
+
+// errPageTimeout is returned by getPage when a page exceeded its own timeout
+var errPageTimeout = errors.New("etcd page timed out")
+
+// Source is the etcd read access the analyzer needs. *clientv3.Client satisfies it.
+type Source interface {
+	Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error)
+}
+
+// LatestProviderFunc resolves the provider secrets are expected to be encrypted with.
+// It is only called when at least one secret was found.
+type LatestProviderFunc func(ctx context.Context) (LatestProvider, error)
+
+// StaticProvider returns a LatestProviderFunc that always resolves to latest.
+func StaticProvider(latest LatestProvider) LatestProviderFunc {
+	return func(context.Context) (LatestProvider, error) {
+		return latest, nil
+	}
+}
+
+// KeyRange is the half-open etcd key range [Start, End).
+type KeyRange struct {
+	Start string
+	End   string
+}
+
+// PrefixRange returns the key range covering every key with the given prefix.
+func PrefixRange(prefix string) KeyRange {
+	return KeyRange{Start: prefix, End: clientv3.GetPrefixRangeEnd(prefix)}
+}
+
+// Progress describes how far a paginated scan has come.
+type Progress struct {
+	// Processed is the number of keys read so far.
+	Processed int64
+	// Total is the number of keys in range when the scan started, or 0 if it could not be counted.
+	Total int64
+	// Page is the number of pages read so far.
+	Page int
+	// Elapsed is the time since the scan started.
+	Elapsed time.Duration
+}
+
+// Fraction returns the share of the keys processed, between 0 and 1, or 0 when Total is unknown.
+func (p Progress) Fraction() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return min(float64(p.Processed)/float64(p.Total), 1)
+}
+
+// Config configures a single analysis.
+type Config struct {
+	// Prefix is the etcd key prefix to scan. Defaults to DefaultPrefix.
+	Prefix string
+	// KeyRoot is the storage prefix of the API server, its --etcd-prefix, e.g. "/registry". When empty,
+	// the root of each key is found by searching it for the resource of Prefix, so that keys stored
+	// under a root of any depth are parsed.
+	KeyRoot string
+	// KeyRange restricts the scan to a range of keys under Prefix, e.g. one shard. Scans the whole prefix when nil.
+	KeyRange *KeyRange
+	// PageSize is the maximum number of keys read per etcd request. 0 reads all keys in a single request.
+	PageSize int64
+	// Timeout bounds each etcd request. Defaults to DefaultTimeout plus DefaultTimeoutPerKey for every
+	// key of a page when PageSize is set, and to DefaultUnpaginatedTimeout otherwise.
+	Timeout time.Duration
+	// AdaptivePageSize adapts the size of every page to the time the previous page took, starting at
+	// PageSize: a page that took more than half of the request timeout halves the next one, a page that
+	// took less than a tenth of it doubles the next one, and a page that timed out is read again with
+	// half the size. Every page keeps the timeout of PageSize. Ignored without PageSize.
+	AdaptivePageSize bool
+	// MinPageSize and MaxPageSize bound the adapted page size. They default to DefaultMinPageSize, or
+	// PageSize if smaller, and to DefaultMaxPageSizeFactor times PageSize.
+	MinPageSize int64
+	MaxPageSize int64
+	// MaxPageBytes bounds the adapted page size to about this many bytes of keys and values, at the
+	// average size of the key-values read so far, so that clusters with large secrets read smaller pages.
+	// Defaults to DefaultMaxPageBytes.
+	MaxPageBytes int64
+	// ProviderMatcher extracts sequence numbers from provider names. Required in sequence mode.
+	ProviderMatcher *utils.ProviderNameMatcher
+	// Classifiers recognize values in formats other than the "k8s:enc:" envelopes of the API server, and
+	// are tried in order before the built-in parser.
+	Classifiers []utils.Classifier
+	// Comparison selects how secrets are compared against the latest provider. Defaults to sequence.
+	Comparison ComparisonMode
+	// LatestProvider resolves the provider to compare against. Required.
+	LatestProvider LatestProviderFunc
+	// TargetKeyID additionally requires secrets to be encrypted with this KMS v2 key ID to count as
+	// using the latest provider, e.g. to follow a key rotation within a provider. Optional.
+	TargetKeyID string
+	// Progress is called after every page of a paginated scan. The total is counted with a count-only
+	// request before the first page. Optional.
+	Progress func(Progress)
+	// MaxSecretNames bounds the number of names kept in each of Result.EncryptedSecrets and
+	// Result.UnencryptedSecrets; further secrets are only counted. When set without Cache, secrets are
+	// classified page by page as they are read, so memory use is bounded by PageSize and MaxSecretNames
+	// instead of the number of secrets. 0 keeps every name.
+	MaxSecretNames int
+	// LargestSecrets is the number of secrets with the largest values listed in Result.LargestSecrets.
+	// 0 lists none.
+	LargestSecrets int
+	// OldestSecrets is the number of encrypted secrets not on the latest provider with the lowest provider
+	// sequences, the longest unrotated, listed in Result.OldestSecrets. Ignored in name mode. 0 lists none.
+	OldestSecrets int
+	// Findings is called with the finding of every secret analyzed, whether its name is kept in the
+	// result or not. Optional.
+	Findings func(Finding)
+	// CountStaleNamespaces counts, in Result.StaleNamespaces, the secrets of every namespace that are not
+	// encrypted by the latest provider, e.g. to rewrite them namespace by namespace. Cluster-scoped
+	// objects are not counted.
+	CountStaleNamespaces bool
+	// Cache keeps the parsed secrets between analyses, so that only the values of keys modified since
+	// the previous analysis are read. Optional; ignored with Kine.
+	Cache *Cache
+	// ParseErrorLogFirst is the number of parse errors of each class, e.g. invalid keys, logged before the
+	// following ones are sampled, so that a prefix holding thousands of foreign keys does not flood the
+	// logs. Every error is still counted in Result.Scan. Defaults to DefaultParseErrorLogFirst.
+	ParseErrorLogFirst int
+	// ParseErrorLogEvery logs one in every ParseErrorLogEvery parse errors of a class past the first
+	// ParseErrorLogFirst. Defaults to DefaultParseErrorLogEvery.
+	ParseErrorLogEvery int
+	// CheckRevisionSkew checks, after a paginated scan pinned to a revision, which secrets were created,
+	// updated or deleted since, and sets Result.Skew. Ignored with Kine.
+	CheckRevisionSkew bool
+	// Kine adapts the scan to kine, the etcd shim backed by SQL databases: every request covers the
+	// whole prefix, pages continue at the last key read instead of the key after it, and pages are not
+	// pinned to the revision of the first page. A KeyRange is applied by filtering the keys read.
+	Kine bool
+}
+
+// RequestTimeout returns the timeout of each etcd request of a scan.
+func (c Config) RequestTimeout() time.Duration {
+	switch {
+	case c.Timeout > 0:
+		return c.Timeout
+	case c.PageSize > 0:
+		return DefaultTimeout + time.Duration(c.PageSize)*DefaultTimeoutPerKey
+	default:
+		return DefaultUnpaginatedTimeout
+	}
+}
+
+// keyRange returns the key range analyzed with the config.
+func (c Config) keyRange() KeyRange {
+	if c.KeyRange != nil {
+		return *c.KeyRange
+	}
+	if c.Prefix == "" {
+		return PrefixRange(DefaultPrefix)
+	}
+	return PrefixRange(c.Prefix)
+}
+
+// Analyzer scans etcd and classifies secrets by the provider that encrypted them. Reusing an Analyzer
+// across periodic analyses preallocates the result of each key range to the size of the last one.
+type Analyzer struct {
+	mu sync.Mutex
+	// lastSizes holds the sizes of the last result of each key range
+	lastSizes map[KeyRange]resultSizes
+}
+
+func New() *Analyzer {
+	return &Analyzer{}
+}
+
+// Analyze reads all secrets under config.Prefix, or in config.KeyRange, from source and compares them against the latest provider.
+// When no secrets are found, the latest provider is not resolved and an empty result is returned.
+func (a *Analyzer) Analyze(ctx context.Context, source Source, config Config) (Result, error) {
+	if source == nil {
+		return Result{}, fmt.Errorf("etcd source is nil")
+	}
+	if config.LatestProvider == nil {
+		return Result{}, fmt.Errorf("latest provider resolver is nil")
+	}
+	if config.Comparison != ComparisonName && config.ProviderMatcher == nil {
+		return Result{}, fmt.Errorf("sequence comparison requires a provider name matcher")
+	}
+
+	start := time.Now()
+	counting := &countingSource{Source: source}
+	result, err := a.analyze(ctx, counting, config)
+	if err != nil {
+		return Result{}, err
+	}
+	if config.CheckRevisionSkew && config.PageSize > 0 && !config.Kine && result.Revision > 0 {
+		// The result is complete, so a failed check only loses the skew. Its requests are not part of the
+		// scan, so they are left out of its stats.
+		if result.Skew, err = a.checkRevisionSkew(ctx, source, config, result.Revision); err != nil {
+			klog.ErrorS(err, "Failed to check the secrets changed during the scan")
+		} else if result.Skew != nil {
+			klog.InfoS("Secrets changed during the scan, the result may be outdated for them", "revision", result.Revision,
+				"currentRevision", result.Skew.Revision, "modified", result.Skew.Modified, "deleted", result.Skew.Deleted)
+		}
+	}
+	// The parse errors are counted by the classification
+	counting.stats.ParseErrors = result.Scan.ParseErrors
+	counting.stats.ParseErrorClasses = result.Scan.ParseErrorClasses
+	counting.stats.Duration = time.Since(start)
+	counting.stats.Concurrency = 1
+	result.Scan = counting.stats
+	result.ClusterID = counting.clusterID
+	a.recordSizes(config.keyRange(), result)
+	return result, nil
+}
+
+// checkRevisionSkew returns the changes made to the scanned keys after revision, or nil if there were none.
+// Deletions leave no key behind, so they are counted from the number of keys at both revisions.
+func (a *Analyzer) checkRevisionSkew(ctx context.Context, source Source, config Config, revision int64) (*RevisionSkew, error) {
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	keyRange := PrefixRange(prefix)
+	if config.KeyRange != nil {
+		keyRange = *config.KeyRange
+	}
+	get := func(key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+		etcdCtx, cancel := context.WithTimeout(ctx, config.RequestTimeout())
+		defer cancel()
+		resp, err := source.Get(etcdCtx, key, append([]clientv3.OpOption{clientv3.WithRange(keyRange.End)}, opts...)...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check revision skew: %w: %w", etcd.ErrEtcdUnavailable, err)
+		}
+		return resp, nil
+	}
+	// etcd counts every key of the range whatever the revision filters and the limit, so the keys
+	// passing filter are listed page by page and counted, keeping the first maxSkewSecrets of them. A zero
+	// rev reads the latest revision, and the revision read is returned.
+	listKeys := func(filter clientv3.OpOption, rev int64) (int64, int, []string, error) {
+		var keys []string
+		count, key := 0, keyRange.Start
+		for {
+			resp, err := get(key, clientv3.WithKeysOnly(), filter, clientv3.WithLimit(int64(config.PageSize)), clientv3.WithRev(rev))
+			if err != nil {
+				return 0, 0, nil, err
+			}
+			if rev == 0 {
+				if resp.Header == nil {
+					return 0, 0, nil, nil
+				}
+				rev = resp.Header.Revision
+			}
+			count += len(resp.Kvs)
+			for _, kv := range resp.Kvs[:min(len(resp.Kvs), maxSkewSecrets-len(keys))] {
+				keys = append(keys, string(kv.Key))
+			}
+			if !resp.More || len(resp.Kvs) == 0 {
+				return rev, count, keys, nil
+			}
+			key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+		}
+	}
+
+	current, modified, modifiedKeys, err := listKeys(clientv3.WithMinModRev(revision+1), 0)
+	if err != nil {
+		return nil, err
+	}
+	if current <= revision {
+		// Nothing was written to etcd at all
+		return nil, nil
+	}
+	_, created, _, err := listKeys(clientv3.WithMinCreateRev(revision+1), current)
+	if err != nil {
+		return nil, err
+	}
+	countBefore, err := get(keyRange.Start, clientv3.WithCountOnly(), clientv3.WithRev(revision))
+	if err != nil {
+		return nil, err
+	}
+	countAfter, err := get(keyRange.Start, clientv3.WithCountOnly(), clientv3.WithRev(current))
+	if err != nil {
+		return nil, err
+	}
+
+	skew := &RevisionSkew{
+		Revision: current,
+		Modified: modified,
+		Created:  created,
+		Deleted:  int(countBefore.Count) + created - int(countAfter.Count),
+	}
+	if skew.Modified == 0 && skew.Deleted == 0 {
+		return nil, nil
+	}
+	for _, key := range modifiedKeys {
+		skew.ModifiedSecrets = append(skew.ModifiedSecrets, strings.TrimPrefix(key, prefix+"/"))
+	}
+	return skew, nil
+}
+
+// analyze runs the analysis mode selected by config.
+func (a *Analyzer) analyze(ctx context.Context, source Source, config Config) (Result, error) {
+	if config.Cache != nil && !config.Kine {
+		return a.analyzeIncremental(ctx, source, config)
+	}
+
+	if config.MaxSecretNames > 0 {
+		return a.analyzeStreaming(ctx, source, config)
+	}
+
+	kvs, revision, err := a.list(ctx, source, config, 0)
+	if err != nil {
+		return Result{}, err
+	}
+	defer putKVs(kvs)
+
+	var latest LatestProvider
+	if len(kvs) > 0 {
+		latest, err = config.LatestProvider(ctx)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to resolve latest provider: %w", err)
+		}
+	}
+
+	result := newSizedResult(latest, a.sizes(config.keyRange()))
+	classify(&result, kvs, config)
+	result.Revision = revision
+	return result, nil
+}
+
+// countingSource counts the keys and bytes returned by a source, and records the etcd cluster that
+// returned them.
+type countingSource struct {
+	Source
+	stats     ScanStats
+	clusterID uint64
+}
+
+func (s *countingSource) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	resp, err := s.Source.Get(ctx, key, opts...)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Header != nil && resp.Header.ClusterId != 0 {
+		s.clusterID = resp.Header.ClusterId
+	}
+	s.stats.Keys += int64(len(resp.Kvs))
+	if !clientv3.OpGet(key, opts...).IsCountOnly() {
+		s.stats.Pages++
+	}
+	for _, kv := range resp.Kvs {
+		s.stats.Bytes += int64(len(kv.Key) + len(kv.Value))
+	}
+	return resp, nil
+}
+
+// analyzeStreaming classifies the secrets page by page as they are read, so that only one page of
+// key-values is held in memory at a time.
+func (a *Analyzer) analyzeStreaming(ctx context.Context, source Source, config Config) (Result, error) {
+	result := newSizedResult(LatestProvider{}, a.sizes(config.keyRange()))
+	parser := newParser(config)
+	resolved := false
+	revision, err := a.scan(ctx, source, config, 0, func(kvs []*mvccpb.KeyValue) error {
+		if len(kvs) == 0 {
+			return nil
+		}
+		if !resolved {
+			latest, err := config.LatestProvider(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to resolve latest provider: %w", err)
+			}
+			result.LatestProvider = latest
+			resolved = true
+		}
+		for _, kv := range kvs {
+			obj, err := parser.Parse(kv.Key, kv.Value)
+			if err != nil {
+				result.addParseError(err, config)
+				continue
+			}
+			result.add(obj, len(kv.Value), config)
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	result.Revision = revision
+	return result, nil
+}
+
+// list reads the keys to analyze and returns them with the revision they were read at. See scan.
+// The slice is sized for the keys of the last analysis of the key range; callers return it with putKVs
+// once they are done with it.
+func (a *Analyzer) list(ctx context.Context, source Source, config Config, revision int64, opts ...clientv3.OpOption) ([]*mvccpb.KeyValue, int64, error) {
+	kvs := getKVs(withHeadroom(a.sizes(config.keyRange()).keys))
+	revision, err := a.scan(ctx, source, config, revision, func(page []*mvccpb.KeyValue) error {
+		kvs = append(kvs, page...)
+		return nil
+	}, opts...)
+	if err != nil {
+		putKVs(kvs)
+		return nil, 0, err
+	}
+	return kvs, revision, nil
+}
+
+// scan reads the keys to analyze, one page at a time when config.PageSize is set, passes every page to visit and
+// returns the revision they were read at. Pages after the first are read at the revision of the first page, or at
+// revision if it is set, so the result is a consistent snapshot. opts are added to every request.
+func (a *Analyzer) scan(ctx context.Context, source Source, config Config, revision int64, visit func([]*mvccpb.KeyValue) error, opts ...clientv3.OpOption) (int64, error) {
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	timeout := config.RequestTimeout()
+	keyRange := config.keyRange()
+
+	if config.Progress != nil && config.PageSize > 0 {
+		start := time.Now()
+		total, countRevision, err := a.count(ctx, source, config, prefix, keyRange, revision, timeout)
+		if err != nil {
+			klog.ErrorS(err, "Failed to count keys, scan progress has no total")
+		} else if revision == 0 && !config.Kine {
+			// Pin the scan to the counted revision so the total matches
+			revision = countRevision
+		}
+		var processed int64
+		page := 0
+		pageVisit := visit
+		visit = func(kvs []*mvccpb.KeyValue) error {
+			if err := pageVisit(kvs); err != nil {
+				return err
+			}
+			page++
+			processed += int64(len(kvs))
+			config.Progress(Progress{Processed: processed, Total: total, Page: page, Elapsed: time.Since(start)})
+			return nil
+		}
+	}
+
+	if config.Kine {
+		return 0, a.scanKine(ctx, source, config, prefix, keyRange, timeout, visit)
+	}
+
+	sizer := newPageSizer(config, timeout)
+	key := keyRange.Start
+	for {
+		pageOpts := append([]clientv3.OpOption{clientv3.WithRange(keyRange.End)}, opts...)
+		if limit := sizer.limit(); limit > 0 {
+			pageOpts = append(pageOpts, clientv3.WithLimit(limit))
+		}
+		if revision > 0 {
+			pageOpts = append(pageOpts, clientv3.WithRev(revision))
+		}
+
+		resp, elapsed, err := getPage(ctx, source, key, timeout, pageOpts...)
+		if errors.Is(err, errPageTimeout) && sizer.shrink() {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to get key from etcd: %w: %w", etcd.ErrEtcdUnavailable, err)
+		}
+		sizer.observeValues(len(resp.Kvs), pageBytes(resp.Kvs))
+		sizer.observe(elapsed)
+		if revision == 0 && resp.Header != nil {
+			revision = resp.Header.Revision
+		}
+		if err := visit(resp.Kvs); err != nil {
+			return 0, err
+		}
+
+		if !resp.More || len(resp.Kvs) == 0 {
+			return revision, nil
+		}
+		// Continue right after the last key of this page
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// getPage reads a page with its own timeout and returns how long it took. A page that exceeded its
+// timeout, rather than the deadline of ctx, returns an error wrapping errPageTimeout.
+func getPage(ctx context.Context, source Source, key string, timeout time.Duration, opts ...clientv3.OpOption) (*clientv3.GetResponse, time.Duration, error) {
+	start := time.Now()
+	etcdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := source.Get(etcdCtx, key, opts...)
+	elapsed := time.Since(start)
+	if err != nil && ctx.Err() == nil && errors.Is(etcdCtx.Err(), context.DeadlineExceeded) {
+		return nil, elapsed, fmt.Errorf("%w after %s: %w", errPageTimeout, timeout, err)
+	}
+	return resp, elapsed, err
+}
+
+// count returns the number of keys in keyRange and the revision they were counted at. Kine only
+// counts whole prefixes, so with Kine the count covers the prefix.
+func (a *Analyzer) count(ctx context.Context, source Source, config Config, prefix string, keyRange KeyRange, revision int64, timeout time.Duration) (int64, int64, error) {
+	key, rangeEnd := keyRange.Start, keyRange.End
+	if config.Kine {
+		key, rangeEnd = prefix, PrefixRange(prefix).End
+	}
+	opts := []clientv3.OpOption{clientv3.WithRange(rangeEnd), clientv3.WithCountOnly()}
+	if revision > 0 {
+		opts = append(opts, clientv3.WithRev(revision))
+	}
+
+	etcdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := source.Get(etcdCtx, key, opts...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count keys in etcd: %w: %w", etcd.ErrEtcdUnavailable, err)
+	}
+	var countRevision int64
+	if resp.Header != nil {
+		countRevision = resp.Header.Revision
+	}
+	return resp.Count, countRevision, nil
+}
+
+// scanKine reads the keys in keyRange from a kine endpoint. Kine derives the listed prefix from the
+// range end, so keys past keyRange.End are dropped here. Pages start at the last key of the previous
+// page, because SQL backends cannot compare against keys with the NUL byte appended by scan.
+func (a *Analyzer) scanKine(ctx context.Context, source Source, config Config, prefix string, keyRange KeyRange, timeout time.Duration, visit func([]*mvccpb.KeyValue) error) error {
+	rangeEnd := PrefixRange(prefix).End
+
+	sizer := newPageSizer(config, timeout)
+	var last []byte
+	key := keyRange.Start
+	for {
+		opts := []clientv3.OpOption{clientv3.WithRange(rangeEnd)}
+		if limit := sizer.limit(); limit > 0 {
+			if last != nil {
+				// The first key repeats the last key of the previous page
+				limit++
+			}
+			opts = append(opts, clientv3.WithLimit(limit))
+		}
+
+		resp, elapsed, err := getPage(ctx, source, key, timeout, opts...)
+		if errors.Is(err, errPageTimeout) && sizer.shrink() {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get key from etcd: %w: %w", etcd.ErrEtcdUnavailable, err)
+		}
+		sizer.observeValues(len(resp.Kvs), pageBytes(resp.Kvs))
+		sizer.observe(elapsed)
+
+		var kvs []*mvccpb.KeyValue
+		done := false
+		for _, kv := range resp.Kvs {
+			if last != nil && bytes.Compare(kv.Key, last) <= 0 {
+				continue
+			}
+			if keyRange.End != "" && string(kv.Key) >= keyRange.End {
+				done = true
+				break
+			}
+			kvs = append(kvs, kv)
+		}
+		if err := visit(kvs); err != nil {
+			return err
+		}
+
+		if done || !resp.More || len(resp.Kvs) == 0 {
+			return nil
+		}
+		next := resp.Kvs[len(resp.Kvs)-1].Key
+		if last != nil && bytes.Compare(next, last) <= 0 {
+			// The page made no progress
+			return nil
+		}
+		last = next
+		key = string(next)
+	}
+}
+
+// Classify processes etcd key-value pairs to categorize secrets by encryption status
+// and determines if all secrets use the latest provider, by sequence or by name depending on the comparison mode.
+func Classify(kvs []*mvccpb.KeyValue, latest LatestProvider, config Config) Result {
+	result := newResult(latest)
+	classify(&result, kvs, config)
+	return result
+}
+
+// ClassifyFindings classifies the secrets of findings, as collected from Config.Findings, like Classify,
+// e.g. to report on a subset of the secrets of an analysis. config.Findings is not called.
+func ClassifyFindings(findings []Finding, latest LatestProvider, config Config) Result {
+	config.Findings = nil
+	result := newResult(latest)
+	for _, finding := range findings {
+		result.add(finding.parsedObject(), finding.Size, config)
+	}
+	return result
+}
+
+// classify adds kvs to result.
+func classify(result *Result, kvs []*mvccpb.KeyValue, config Config) {
+	parser := newParser(config)
+	for _, kv := range kvs {
+		obj, err := parser.Parse(kv.Key, kv.Value)
+		if err != nil {
+			result.addParseError(err, config)
+			continue
+		}
+		result.add(obj, len(kv.Value), config)
+	}
+}
+
+// newParser returns an object parser for config. Provider sequences are only needed, and only
+// required to parse, in sequence mode.
+func newParser(config Config) *utils.ObjectParser {
+	providerMatcher := config.ProviderMatcher
+	if config.Comparison == ComparisonName {
+		providerMatcher = nil
+	}
+	parser := utils.NewObjectParser(providerMatcher)
+	parser.SetClassifiers(config.Classifiers)
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	parser.SetKeyLayout(utils.KeyLayout{Root: config.KeyRoot, Resource: ResourceFromPrefix(prefix)})
+	return parser
+}
+
+// newResult returns an empty result compared against latest.
+func newResult(latest LatestProvider) Result {
+	return Result{
+		EncryptedSecrets:            []string{},
+		UnencryptedSecrets:          []string{},
+		AllSecretsUseLatestProvider: true,
+		ProviderCounts:              map[string]int{},
+		LatestProvider:              latest,
+	}
+}
+
+// addParseError counts a key that could not be parsed, and logs err if it is among the sampled errors of
+// its class: the first config.ParseErrorLogFirst, then one in config.ParseErrorLogEvery.
+func (r *Result) addParseError(err error, config Config) {
+	class := utils.ParseErrorClass(err)
+	if r.Scan.ParseErrorClasses == nil {
+		r.Scan.ParseErrorClasses = map[string]int64{}
+	}
+	r.Scan.ParseErrors++
+	r.Scan.ParseErrorClasses[class]++
+	occurrences := r.Scan.ParseErrorClasses[class]
+
+	first, every := int64(config.ParseErrorLogFirst), int64(config.ParseErrorLogEvery)
+	if first <= 0 {
+		first = DefaultParseErrorLogFirst
+	}
+	if every <= 0 {
+		every = DefaultParseErrorLogEvery
+	}
+	if !sampled(occurrences, first, every) {
+		return
+	}
+	klog.ErrorS(utils.LoggedParseError(err), "Failed to parse secret", "class", class, "occurrences", occurrences)
+	if occurrences == first {
+		klog.InfoS("Sampling further parse errors", "class", class, "every", every)
+	}
+}
+
+// sampled reports whether the nth occurrence of an event is logged when the first ones are, then one in every.
+func sampled(n, first, every int64) bool {
+	return n <= first || (n-first)%every == 0
+}
+
+// add classifies a parsed secret whose value has size bytes into the result, counting its name as
+// omitted once the list it belongs to holds config.MaxSecretNames names.
+func (r *Result) add(obj utils.ParsedObject, size int, config Config) {
+	if config.LargestSecrets > 0 {
+		r.LargestSecrets = InsertLargest(r.LargestSecrets, SecretSize{Name: obj.NamespacedName(), Size: size}, config.LargestSecrets)
+	}
+	if config.Findings != nil {
+		config.Findings(newFinding(obj, size))
+	}
+	limit := config.MaxSecretNames
+	if obj.Encoding == utils.EncodingUnknown {
+		// Neither encrypted nor plaintext, so not on the latest provider either
+		r.AllSecretsUseLatestProvider = false
+		if limit <= 0 || len(r.UnrecognizedSecrets) < limit {
+			r.UnrecognizedSecrets = append(r.UnrecognizedSecrets, obj.NamespacedName())
+		} else {
+			r.OmittedUnrecognized++
+		}
+		return
+	}
+	if obj.Encoding != "" {
+		if r.EncodingCounts == nil {
+			r.EncodingCounts = map[string]int{}
+		}
+		r.EncodingCounts[obj.Encoding]++
+	}
+
+	// Unencrypted secrets have sequence 0, matching the historical behavior of ParseEtcdObject
+	usesLatest := obj.Seq == r.LatestProvider.Seq
+	// Without a KMS provider the latest provider has no name, which plaintext and other providers share
+	if config.Comparison == ComparisonName && r.LatestProvider.Name != "" {
+		usesLatest = obj.ProviderName == r.LatestProvider.Name
+	}
+	if config.TargetKeyID != "" && obj.KeyID != config.TargetKeyID {
+		usesLatest = false
+	}
+
+	// Other providers than KMS are counted by type, e.g. "aescbc", plaintext being of type identity
+	providerName := obj.ProviderName
+	if !obj.Encrypted {
+		providerName = obj.ProviderType
+	}
+	r.ProviderCounts[providerName]++
+	switch {
+	case !obj.Encrypted && obj.ProviderType != IdentityProviderName:
+		// Encrypted, but not by a KMS provider, so without a sequence
+		if r.NonKMSCounts == nil {
+			r.NonKMSCounts = map[string]int{}
+		}
+		r.NonKMSCounts[obj.ProviderType]++
+	case config.Comparison != ComparisonName:
+		if r.SequenceCounts == nil {
+			r.SequenceCounts = map[int]int{}
+		}
+		r.SequenceCounts[obj.Seq]++
+	}
+
+	if !usesLatest {
+		r.AllSecretsUseLatestProvider = false
+		if config.CountStaleNamespaces && !obj.ClusterScoped {
+			if r.StaleNamespaces == nil {
+				r.StaleNamespaces = map[string]int{}
+			}
+			r.StaleNamespaces[obj.Namespace]++
+		}
+	}
+	if config.OldestSecrets > 0 && obj.Encrypted && !usesLatest && config.Comparison != ComparisonName {
+		secret := SecretProvider{Name: obj.NamespacedName(), Provider: obj.ProviderName, Seq: obj.Seq}
+		r.OldestSecrets = InsertOldest(r.OldestSecrets, secret, config.OldestSecrets)
+	}
+
+	switch {
+	case obj.Encrypted && (limit <= 0 || len(r.EncryptedSecrets) < limit):
+		r.EncryptedSecrets = append(r.EncryptedSecrets, obj.NamespacedName())
+	case obj.Encrypted:
+		r.OmittedEncrypted++
+	case limit <= 0 || len(r.UnencryptedSecrets) < limit:
+		r.UnencryptedSecrets = append(r.UnencryptedSecrets, obj.NamespacedName())
+	default:
+		r.OmittedUnencrypted++
+	}
+}
+
+// newFinding returns the finding of obj, whose value is size bytes.
+func newFinding(obj utils.ParsedObject, size int) Finding {
+	finding := Finding{
+		Namespace:    obj.Namespace,
+		Name:         obj.Name,
+		Category:     CategoryUnencrypted,
+		ProviderType: obj.ProviderType,
+		Provider:     obj.ProviderName,
+		Seq:          obj.Seq,
+		KeyID:        obj.KeyID,
+		Encoding:     obj.Encoding,
+		Size:         size,
+	}
+	switch {
+	case obj.Encoding == utils.EncodingUnknown:
+		finding.Category = CategoryUnrecognized
+	case obj.Encrypted:
+		finding.Category = CategoryEncrypted
+	}
+	return finding
+}
+
+// parsedObject returns the parsed object f was found from.
+func (f Finding) parsedObject() utils.ParsedObject {
+	return utils.ParsedObject{
+		Encrypted:     f.Category == CategoryEncrypted,
+		ProviderType:  f.ProviderType,
+		ProviderName:  f.Provider,
+		Encoding:      f.Encoding,
+		Seq:           f.Seq,
+		KeyID:         f.KeyID,
+		ClusterScoped: f.Namespace == "",
+		Namespace:     f.Namespace,
+		Name:          f.Name,
+	}
+}
+
+// InsertLargest inserts secret into largest, sorted by decreasing size then name, and keeps at most
+// n secrets. n is small, so an insertion into a sorted slice beats a heap.
+func InsertLargest(largest []SecretSize, secret SecretSize, n int) []SecretSize {
+	i := sort.Search(len(largest), func(i int) bool {
+		return largest[i].Size < secret.Size || (largest[i].Size == secret.Size && largest[i].Name > secret.Name)
+	})
+	if i >= n {
+		return largest
+	}
+	if len(largest) < n {
+		largest = append(largest, SecretSize{})
+	}
+	copy(largest[i+1:], largest[i:])
+	largest[i] = secret
+	return largest
+}
+
+// InsertOldest inserts secret into oldest, sorted by increasing provider sequence then name, and keeps
+// at most n secrets.
+func InsertOldest(oldest []SecretProvider, secret SecretProvider, n int) []SecretProvider {
+	i := sort.Search(len(oldest), func(i int) bool {
+		return oldest[i].Seq > secret.Seq || (oldest[i].Seq == secret.Seq && oldest[i].Name > secret.Name)
+	})
+	if i >= n {
+		return oldest
+	}
+	if len(oldest) < n {
+		oldest = append(oldest, SecretProvider{})
+	}
+	copy(oldest[i+1:], oldest[i:])
+	oldest[i] = secret
+	return oldest
+}
+
+// ParseEncryptionConfiguration unmarshals an EncryptionConfiguration YAML document.
+func ParseEncryptionConfiguration(data []byte) (EncryptionConfiguration, error) {
+	var encryptionConfig EncryptionConfiguration
+	if err := yaml.Unmarshal(data, &encryptionConfig); err != nil {
+		return EncryptionConfiguration{}, fmt.Errorf("failed to unmarshal encryption configuration: %w: %w", ErrInvalidEncryptionConfig, err)
+	}
+	return encryptionConfig, nil
+}
+
+// Covers reports whether the resources of the configuration include resource, given as
+// "<resource>" for the core group or "<resource>.<group>", directly or with a wildcard.
+func (c EncryptionConfiguration) Covers(resource string) bool {
+	_, ok := c.entry(resource)
+	return ok
+}
+
+// ProvidersOf returns the providers of the first entry covering resource, which the API server
+// decrypts the resource with, or nil if the configuration does not cover it.
+func (c EncryptionConfiguration) ProvidersOf(resource string) []Provider {
+	entry, _ := c.entry(resource)
+	return entry.Providers
+}
+
+// entry returns the first entry of the configuration covering resource.
+func (c EncryptionConfiguration) entry(resource string) (Resource, bool) {
+	_, group, _ := strings.Cut(resource, ".")
+	for _, entry := range c.Resources {
+		for _, covered := range entry.Resources {
+			// "*." matches every resource of the core group
+			switch covered {
+			case resource, "*.*", "*." + group:
+				return entry, true
+			}
+		}
+	}
+	return Resource{}, false
+}
+
+// ResourceFromPrefix returns the resource stored under an etcd key prefix of the API server,
+// e.g. "secrets" for /registry/secrets or "widgets.example.com" for /registry/example.com/widgets.
+// The resource is the last segment, preceded by its API group if the segment before it has a dot,
+// so the root may have any number of segments, e.g. /cluster-a/registry/secrets. It returns "" if
+// the prefix has no resource path.
+func ResourceFromPrefix(prefix string) string {
+	segments := strings.Split(strings.Trim(prefix, "/"), "/")
+	if len(segments) < 2 {
+		return ""
+	}
+	resource := segments[len(segments)-1]
+	// API groups of custom resources always have a dot, unlike the single segment of the root
+	if group := segments[len(segments)-2]; len(segments) > 2 && strings.Contains(group, ".") {
+		return resource + "." + group
+	}
+	return resource
+}
+
+// KeyRootPrefix returns the prefix of resource under the storage prefix root, e.g. /registry/secrets
+// for "secrets" or /registry/example.com/widgets for "widgets.example.com". An empty root is
+// DefaultKeyRoot.
+func KeyRootPrefix(root, resource string) string {
+	if root == "" {
+		root = DefaultKeyRoot
+	}
+	if name, group, found := strings.Cut(resource, "."); found {
+		resource = group + "/" + name
+	}
+	return strings.TrimSuffix(root, "/") + "/" + resource
+}
+
+// NewTargetProvider returns the provider to compare against in place of the latest provider of the
+// encryption configuration. In name mode the name is required and seq is ignored. In sequence mode a
+// negative seq is parsed from the name.
+func NewTargetProvider(name string, seq int, providerMatcher *utils.ProviderNameMatcher, comparison ComparisonMode) (LatestProvider, error) {
+	if comparison == ComparisonName {
+		if name == "" {
+			return LatestProvider{}, fmt.Errorf("name comparison requires a target provider name")
+		}
+		return LatestProvider{Name: name}, nil
+	}
+	if seq >= 0 {
+		return LatestProvider{Name: name, Seq: seq}, nil
+	}
+	if name == "" {
+		return LatestProvider{}, fmt.Errorf("target provider requires a name or a sequence")
+	}
+	if providerMatcher == nil {
+		return LatestProvider{}, fmt.Errorf("sequence comparison requires a provider name matcher")
+	}
+	seq, err := providerMatcher.Seq(name)
+	if err != nil {
+		return LatestProvider{}, fmt.Errorf("failed to parse target provider sequence: %w", err)
+	}
+	return LatestProvider{Name: name, Seq: seq}, nil
+}
+
+// FindLatestProvider returns the first KMS provider of the entry of the encryption configuration covering
+// resource, e.g. "secrets", which the API server encrypts new writes with. In sequence mode providers whose
+// name has no parsable sequence are skipped; in name mode the first KMS provider is used as is.
+// If no KMS provider is found, or resource is not covered, it returns IdentityProviderSeq (-1) indicating
+// identity (no encryption) provider.
+func FindLatestProvider(encryptionConfig EncryptionConfiguration, resource string, providerMatcher *utils.ProviderNameMatcher, comparison ComparisonMode) LatestProvider {
+	for _, provider := range encryptionConfig.ProvidersOf(resource) {
+		if provider.KMS == nil {
+			continue
+		}
+		if comparison == ComparisonName {
+			return LatestProvider{Name: provider.KMS.Name}
+		}
+		providerSeq, err := providerMatcher.Seq(provider.KMS.Name)
+		if err != nil {
+			klog.ErrorS(err, "Failed to parse provider sequence number", "providerName", provider.KMS.Name)
+			continue
+		}
+		return LatestProvider{Name: provider.KMS.Name, Seq: providerSeq}
+	}
+
+	return LatestProvider{Seq: IdentityProviderSeq}
+}
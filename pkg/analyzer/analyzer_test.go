@@ -0,0 +1,1054 @@
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	mock_etcd "github.com/lzhecheng/kms-reporter/pkg/etcd/mock"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+func mustProviderMatcher(t *testing.T, kmsProviderName string) *utils.ProviderNameMatcher {
+	matcher, err := utils.NewProviderNameMatcher(kmsProviderName, "")
+	if err != nil {
+		t.Fatalf("Failed to create provider name matcher: %v", err)
+	}
+	return matcher
+}
+
+func TestAnalyzer_Analyze(t *testing.T) {
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data")},
+		{Key: []byte("/registry/secrets/default/secret2"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+	}
+
+	tests := []struct {
+		name             string
+		kvs              []*mvccpb.KeyValue
+		getErr           error
+		latestProvider   LatestProviderFunc
+		expectedError    string
+		expectedTotal    int
+		expectedAllOnNew bool
+	}{
+		{
+			name:           "secrets compared against the latest provider",
+			kvs:            kvs,
+			latestProvider: StaticProvider(LatestProvider{Name: "kmsprovider2", Seq: 2}),
+			expectedTotal:  2,
+		},
+		{
+			name:             "all secrets on the latest provider",
+			kvs:              kvs[:1],
+			latestProvider:   StaticProvider(LatestProvider{Name: "kmsprovider2", Seq: 2}),
+			expectedTotal:    1,
+			expectedAllOnNew: true,
+		},
+		{
+			name: "no secrets does not resolve the latest provider",
+			latestProvider: func(context.Context) (LatestProvider, error) {
+				return LatestProvider{}, errors.New("should not be called")
+			},
+			expectedAllOnNew: true,
+		},
+		{
+			name:           "etcd get fails",
+			getErr:         errors.New("etcd connection failed"),
+			latestProvider: StaticProvider(LatestProvider{Seq: 1}),
+			expectedError:  "failed to get key from etcd",
+		},
+		{
+			name: "latest provider resolution fails",
+			kvs:  kvs,
+			latestProvider: func(context.Context) (LatestProvider, error) {
+				return LatestProvider{}, errors.New("configmap not found")
+			},
+			expectedError: "failed to resolve latest provider",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			etcdMock := mock_etcd.NewMockEtcdClientOperator(ctrl)
+			if tt.getErr != nil {
+				etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Any()).Return(nil, tt.getErr)
+			} else {
+				etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Any()).Return(&clientv3.GetResponse{Kvs: tt.kvs}, nil)
+			}
+
+			result, err := New().Analyze(context.Background(), etcdMock, Config{
+				ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+				LatestProvider:  tt.latestProvider,
+			})
+
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				if tt.getErr != nil {
+					assert.ErrorIs(t, err, etcd.ErrEtcdUnavailable)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedTotal, result.Total())
+			assert.Equal(t, tt.expectedAllOnNew, result.AllSecretsUseLatestProvider)
+		})
+	}
+}
+
+func TestAnalyzer_Analyze_Pagination(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	etcdMock := mock_etcd.NewMockEtcdClientOperator(ctrl)
+	page := func(more bool, keys ...string) *clientv3.GetResponse {
+		resp := &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{ClusterId: 0xcdf818194e3a8c32, Revision: 42}, More: more}
+		for _, key := range keys {
+			resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(key), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")})
+		}
+		return resp
+	}
+	keyRange := KeyRange{Start: "/registry/secrets/a", End: "/registry/secrets/n"}
+
+	// Each page continues after the last key of the previous one
+	gomock.InOrder(
+		etcdMock.EXPECT().Get(gomock.Any(), keyRange.Start, gomock.Len(2)).
+			Return(page(true, "/registry/secrets/a/one", "/registry/secrets/b/two"), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets/b/two\x00", gomock.Len(3)).
+			Return(page(true, "/registry/secrets/c/three", "/registry/secrets/d/four"), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets/d/four\x00", gomock.Len(3)).
+			Return(page(false, "/registry/secrets/m/five"), nil),
+	)
+
+	result, err := New().Analyze(context.Background(), etcdMock, Config{
+		KeyRange:        &keyRange,
+		PageSize:        2,
+		ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider:  StaticProvider(LatestProvider{Name: "kmsprovider1", Seq: 1}),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/one", "b/two", "c/three", "d/four", "m/five"}, result.EncryptedSecrets)
+	assert.True(t, result.AllSecretsUseLatestProvider)
+	assert.Equal(t, int64(42), result.Revision)
+	assert.Equal(t, uint64(0xcdf818194e3a8c32), result.ClusterID)
+	// Keys of 23 to 25 bytes, each with a 32 bytes value
+	assert.Positive(t, result.Scan.Duration)
+	result.Scan.Duration = 0
+	assert.Equal(t, ScanStats{Keys: 5, Bytes: 23 + 23 + 25 + 24 + 24 + 5*32, Pages: 3, Concurrency: 1}, result.Scan)
+}
+
+func TestAnalyzer_Analyze_RevisionSkew(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	etcdMock := mock_etcd.NewMockEtcdClientOperator(ctrl)
+	response := func(revision, count int64, keys ...string) *clientv3.GetResponse {
+		resp := &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: revision}, Count: count}
+		for _, key := range keys {
+			resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(key), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")})
+		}
+		return resp
+	}
+	config := Config{
+		PageSize:          10,
+		CheckRevisionSkew: true,
+		ProviderMatcher:   mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider:    StaticProvider(LatestProvider{Name: "kmsprovider1", Seq: 1}),
+	}
+
+	// One secret was updated and one created while the scan ran at revision 42, and one deleted:
+	// 2 secrets at 42, plus 1 created, minus 1 deleted, leaves 2 at 50. As in etcd, the counts are those
+	// of the whole range, whatever the revision filters and the limit.
+	gomock.InOrder(
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets", gomock.Len(2)).
+			Return(response(42, 2, "/registry/secrets/default/a", "/registry/secrets/default/b"), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets", gomock.Len(5)).
+			DoAndReturn(func(context.Context, string, ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+				resp := response(50, 2, "/registry/secrets/default/a")
+				resp.More = true
+				return resp, nil
+			}),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets/default/a\x00", gomock.Len(5)).
+			Return(response(50, 2, "/registry/secrets/default/c"), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets", gomock.Len(5)).Return(response(50, 2, "/registry/secrets/default/c"), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets", gomock.Len(3)).Return(response(42, 2), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets", gomock.Len(3)).Return(response(50, 2), nil),
+	)
+	result, err := New().Analyze(context.Background(), etcdMock, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default/a", "default/b"}, result.EncryptedSecrets)
+	assert.Equal(t, &RevisionSkew{Revision: 50, Modified: 2, ModifiedSecrets: []string{"default/a", "default/c"}, Created: 1, Deleted: 1}, result.Skew)
+
+	// Nothing was written since the scan revision
+	gomock.InOrder(
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets", gomock.Len(2)).
+			Return(response(42, 1, "/registry/secrets/default/a"), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets", gomock.Len(5)).Return(response(42, 1), nil),
+	)
+	result, err = New().Analyze(context.Background(), etcdMock, config)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Skew)
+
+	// A failed check keeps the result
+	gomock.InOrder(
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets", gomock.Len(2)).
+			Return(response(42, 1, "/registry/secrets/default/a"), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets", gomock.Len(5)).Return(nil, errors.New("unavailable")),
+	)
+	result, err = New().Analyze(context.Background(), etcdMock, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default/a"}, result.EncryptedSecrets)
+	assert.Nil(t, result.Skew)
+}
+
+// writingSource writes to its MemoryClient after serving the first page of a scan, while the scan runs
+type writingSource struct {
+	*etcd.MemoryClient
+	write func()
+}
+
+func (s *writingSource) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	resp, err := s.MemoryClient.Get(ctx, key, opts...)
+	if s.write != nil {
+		s.write()
+		s.write = nil
+	}
+	return resp, err
+}
+
+func TestAnalyzer_Analyze_RevisionSkewWrites(t *testing.T) {
+	config := Config{
+		PageSize:          1,
+		CheckRevisionSkew: true,
+		ProviderMatcher:   mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider:    StaticProvider(LatestProvider{Name: "kmsprovider1", Seq: 1}),
+	}
+	newSource := func(write func(client *etcd.MemoryClient)) *writingSource {
+		client := etcd.NewMemoryClient([]*mvccpb.KeyValue{
+			{Key: []byte("/registry/secrets/default/a"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data"), CreateRevision: 2, ModRevision: 2},
+			{Key: []byte("/registry/secrets/default/b"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data"), CreateRevision: 3, ModRevision: 3},
+		})
+		return &writingSource{MemoryClient: client, write: func() { write(client) }}
+	}
+
+	// Writes outside the scanned range advance the revision but change no secret
+	result, err := New().Analyze(context.Background(), newSource(func(client *etcd.MemoryClient) {
+		client.Put("/registry/configmaps/default/c", "data")
+	}), config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default/a", "default/b"}, result.EncryptedSecrets)
+	assert.Nil(t, result.Skew)
+
+	// Only the updated secret is counted, not every secret of the range. The client keeps no history, so
+	// secrets created or deleted are checked by TestAnalyzer_Analyze_RevisionSkew.
+	result, err = New().Analyze(context.Background(), newSource(func(client *etcd.MemoryClient) {
+		client.Put("/registry/secrets/default/a", "k8s:enc:kms:v2:kmsprovider2:data")
+		client.Put("/registry/configmaps/default/c", "data")
+	}), config)
+	assert.NoError(t, err)
+	assert.Equal(t, &RevisionSkew{Revision: 5, Modified: 1, ModifiedSecrets: []string{"default/a"}}, result.Skew)
+	// The requests of the check are not part of the scan stats
+	assert.Equal(t, int64(2), result.Scan.Keys)
+	assert.Equal(t, int64(2), result.Scan.Pages)
+}
+
+func TestAnalyzer_Analyze_Kine(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	etcdMock := mock_etcd.NewMockEtcdClientOperator(ctrl)
+	page := func(more bool, keys ...string) *clientv3.GetResponse {
+		resp := &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: 42}, More: more}
+		for _, key := range keys {
+			resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(key), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")})
+		}
+		return resp
+	}
+	keyRange := KeyRange{Start: "/registry/secrets/a", End: "/registry/secrets/n"}
+
+	// Pages restart at the last key read, without a revision, and keys past the range end are dropped
+	gomock.InOrder(
+		etcdMock.EXPECT().Get(gomock.Any(), keyRange.Start, gomock.Len(2)).
+			Return(page(true, "/registry/secrets/a/one", "/registry/secrets/b/two"), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets/b/two", gomock.Len(2)).
+			Return(page(true, "/registry/secrets/b/two", "/registry/secrets/c/three", "/registry/secrets/d/four"), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets/d/four", gomock.Len(2)).
+			Return(page(true, "/registry/secrets/d/four", "/registry/secrets/m/five", "/registry/secrets/n/six"), nil),
+	)
+
+	result, err := New().Analyze(context.Background(), etcdMock, Config{
+		KeyRange:        &keyRange,
+		PageSize:        2,
+		ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider:  StaticProvider(LatestProvider{Name: "kmsprovider1", Seq: 1}),
+		Kine:            true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/one", "b/two", "c/three", "d/four", "m/five"}, result.EncryptedSecrets)
+	// Pages are not read at a single revision
+	assert.Zero(t, result.Revision)
+}
+
+func TestAnalyzer_Analyze_InvalidConfig(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	etcdMock := mock_etcd.NewMockEtcdClientOperator(ctrl)
+
+	_, err := New().Analyze(context.Background(), nil, Config{})
+	assert.ErrorContains(t, err, "etcd source is nil")
+
+	_, err = New().Analyze(context.Background(), etcdMock, Config{})
+	assert.ErrorContains(t, err, "latest provider resolver is nil")
+
+	_, err = New().Analyze(context.Background(), etcdMock, Config{LatestProvider: StaticProvider(LatestProvider{})})
+	assert.ErrorContains(t, err, "requires a provider name matcher")
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name                         string
+		kvs                          []*mvccpb.KeyValue
+		latestProviderSeq            int
+		expectedEncryptedSecrets     []string
+		expectedUnencryptedSecrets   []string
+		expectedAllUseLatestProvider bool
+		expectedProviderCounts       map[string]int
+	}{
+		{
+			name: "mixed encrypted and unencrypted secrets with latest provider",
+			kvs: []*mvccpb.KeyValue{
+				{
+					Key:   []byte("/registry/secrets/default/secret1"),
+					Value: []byte("k8s:enc:kms:v2:kmsprovider1:encrypted-data"),
+				},
+				{
+					Key:   []byte("/registry/secrets/kube-system/secret2"),
+					Value: []byte("k8s\x00unencrypted-data"),
+				},
+				{
+					Key:   []byte("/registry/secrets/default/secret3"),
+					Value: []byte("k8s:enc:kms:v2:kmsprovider1:more-encrypted-data"),
+				},
+			},
+			latestProviderSeq:            1,
+			expectedEncryptedSecrets:     []string{"default/secret1", "default/secret3"},
+			expectedUnencryptedSecrets:   []string{"kube-system/secret2"},
+			expectedAllUseLatestProvider: false, // because secret2 is unencrypted (seq 0 != 1)
+			expectedProviderCounts:       map[string]int{"kmsprovider1": 2, "identity": 1},
+		},
+		{
+			name: "all secrets encrypted with latest provider",
+			kvs: []*mvccpb.KeyValue{
+				{
+					Key:   []byte("/registry/secrets/default/secret1"),
+					Value: []byte("k8s:enc:kms:v2:kmsprovider2:encrypted-data"),
+				},
+				{
+					Key:   []byte("/registry/secrets/default/secret2"),
+					Value: []byte("k8s:enc:kms:v2:kmsprovider2:more-encrypted-data"),
+				},
+			},
+			latestProviderSeq:            2,
+			expectedEncryptedSecrets:     []string{"default/secret1", "default/secret2"},
+			expectedUnencryptedSecrets:   []string{},
+			expectedAllUseLatestProvider: true,
+			expectedProviderCounts:       map[string]int{"kmsprovider2": 2},
+		},
+		{
+			name: "encrypted secrets with older provider",
+			kvs: []*mvccpb.KeyValue{
+				{
+					Key:   []byte("/registry/secrets/default/secret1"),
+					Value: []byte("k8s:enc:kms:v2:kmsprovider1:encrypted-data"),
+				},
+			},
+			latestProviderSeq:            2,
+			expectedEncryptedSecrets:     []string{"default/secret1"},
+			expectedUnencryptedSecrets:   []string{},
+			expectedAllUseLatestProvider: false, // seq 1 != 2
+			expectedProviderCounts:       map[string]int{"kmsprovider1": 1},
+		},
+		{
+			name:                         "no secrets",
+			kvs:                          []*mvccpb.KeyValue{},
+			latestProviderSeq:            1,
+			expectedEncryptedSecrets:     []string{},
+			expectedUnencryptedSecrets:   []string{},
+			expectedAllUseLatestProvider: true,
+			expectedProviderCounts:       map[string]int{},
+		},
+		{
+			name: "invalid key format - should be skipped",
+			kvs: []*mvccpb.KeyValue{
+				{
+					Key:   []byte("/invalid/key"),
+					Value: []byte("k8s\x00some-data"),
+				},
+				{
+					Key:   []byte("/registry/secrets/default/valid-secret"),
+					Value: []byte("k8s\x00unencrypted-data"),
+				},
+			},
+			latestProviderSeq:            1,
+			expectedEncryptedSecrets:     []string{},
+			expectedUnencryptedSecrets:   []string{"default/valid-secret"},
+			expectedAllUseLatestProvider: false,
+			expectedProviderCounts:       map[string]int{"identity": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")}
+			result := Classify(tt.kvs, LatestProvider{Seq: tt.latestProviderSeq}, config)
+
+			assert.Equal(t, tt.expectedEncryptedSecrets, result.EncryptedSecrets)
+			assert.Equal(t, tt.expectedUnencryptedSecrets, result.UnencryptedSecrets)
+			assert.Equal(t, tt.expectedAllUseLatestProvider, result.AllSecretsUseLatestProvider)
+			assert.Equal(t, tt.expectedProviderCounts, result.ProviderCounts)
+		})
+	}
+}
+
+func TestClassify_MigrationCounts(t *testing.T) {
+	config := Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")}
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data")},
+		{Key: []byte("/registry/secrets/default/secret2"), Value: []byte("k8s:enc:kms:v2:kmsprovider3:data")},
+		{Key: []byte("/registry/secrets/default/secret3"), Value: []byte("k8s:enc:kms:v2:kmsprovider3:data")},
+		{Key: []byte("/registry/secrets/default/secret4"), Value: []byte("k8s:enc:kms:v2:otherprovider:data")},
+	}
+
+	result := Classify(kvs, LatestProvider{Name: "kmsprovider3", Seq: 3}, config)
+
+	// secret4 cannot be parsed in sequence mode and is skipped entirely
+	assert.Equal(t, map[string]int{"kmsprovider2": 1, "kmsprovider3": 2}, result.ProviderCounts)
+	assert.Equal(t, []string{"default/secret1", "default/secret2", "default/secret3"}, result.EncryptedSecrets)
+	assert.False(t, result.AllSecretsUseLatestProvider)
+}
+
+func TestClassify_SequenceCounts(t *testing.T) {
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+		{Key: []byte("/registry/secrets/default/secret2"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data")},
+		{Key: []byte("/registry/secrets/default/secret3"), Value: []byte("k8s:enc:kms:v2:kmsprovider3:data")},
+		{Key: []byte("/registry/secrets/default/secret4"), Value: []byte("k8s:enc:kms:v2:kmsprovider3:data")},
+		{Key: []byte("/registry/secrets/default/secret5"), Value: []byte("k8s\x00plaintext")},
+		{Key: []byte("/registry/secrets/default/secret6"), Value: []byte("k8s:enc:aescbc:v1:key1:data")},
+		{Key: []byte("/registry/secrets/default/secret7"), Value: []byte("k8s:enc:kms:v2:otherprovider:data")},
+	}
+	config := Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")}
+	result := Classify(kvs, LatestProvider{Name: "kmsprovider3", Seq: 3}, config)
+	// Unencrypted secrets are counted under 0, and the secrets of other providers by their type
+	assert.Equal(t, map[int]int{0: 1, 1: 1, 2: 1, 3: 2}, result.SequenceCounts)
+	assert.Equal(t, map[string]int{"aescbc": 1}, result.NonKMSCounts)
+	assert.Equal(t, int64(1), result.Scan.ParseErrors)
+	// aescbc ciphertext is not counted as plaintext
+	assert.Equal(t, map[string]int{"identity": 1, "aescbc": 1, "kmsprovider1": 1, "kmsprovider2": 1, "kmsprovider3": 2}, result.ProviderCounts)
+
+	// Sequences are not parsed in name mode, the provider types are still counted
+	config.Comparison = ComparisonName
+	result = Classify(kvs, LatestProvider{Name: "kmsprovider3"}, config)
+	assert.Nil(t, result.SequenceCounts)
+	assert.Equal(t, map[string]int{"aescbc": 1}, result.NonKMSCounts)
+}
+
+func TestClassify_NameComparisonWithoutKMSProvider(t *testing.T) {
+	encryptionConfig, err := ParseEncryptionConfiguration([]byte(`
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- providers:
+  - aescbc:
+      keys:
+      - name: key1
+        secret: c2VjcmV0IGlzIHNlY3VyZQ==
+  - identity: {}
+  resources:
+  - secrets
+`))
+	assert.NoError(t, err)
+	config := Config{Comparison: ComparisonName}
+	latest := FindLatestProvider(encryptionConfig, "secrets", nil, ComparisonName)
+	assert.Equal(t, LatestProvider{Seq: IdentityProviderSeq}, latest)
+
+	// Neither plaintext nor aescbc secrets are on the latest provider, whose name is empty
+	for _, value := range []string{"k8s\x00plaintext", "k8s:enc:aescbc:v1:key1:data"} {
+		result := Classify([]*mvccpb.KeyValue{{Key: []byte("/registry/secrets/default/a"), Value: []byte(value)}}, latest, config)
+		assert.False(t, result.AllSecretsUseLatestProvider, value)
+	}
+	result := Classify([]*mvccpb.KeyValue{{Key: []byte("/registry/secrets/default/a"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")}}, latest, config)
+	assert.False(t, result.AllSecretsUseLatestProvider)
+}
+
+func TestClassify_LargestSecrets(t *testing.T) {
+	config := Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider"), LargestSecrets: 2}
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/small"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+		{Key: []byte("/registry/secrets/default/large"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:" + strings.Repeat("x", 1000))},
+		{Key: []byte("/registry/secrets/kube-system/plain"), Value: []byte("k8s\x00" + strings.Repeat("x", 100))},
+		{Key: []byte("/registry/secrets/default/same-size"), Value: []byte("k8s\x00" + strings.Repeat("y", 100))},
+	}
+
+	// Ties are broken by name, whether the value is encrypted or not
+	result := Classify(kvs, LatestProvider{Name: "kmsprovider1", Seq: 1}, config)
+	assert.Equal(t, []SecretSize{{Name: "default/large", Size: 1028}, {Name: "default/same-size", Size: 104}}, result.LargestSecrets)
+
+	config.LargestSecrets = 0
+	assert.Empty(t, Classify(kvs, LatestProvider{Name: "kmsprovider1", Seq: 1}, config).LargestSecrets)
+}
+
+func TestClassify_OldestSecrets(t *testing.T) {
+	config := Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider"), OldestSecrets: 3}
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/latest"), Value: []byte("k8s:enc:kms:v2:kmsprovider3:data")},
+		{Key: []byte("/registry/secrets/default/b"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data")},
+		{Key: []byte("/registry/secrets/default/c"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+		{Key: []byte("/registry/secrets/default/a"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data")},
+		{Key: []byte("/registry/secrets/default/d"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data")},
+		{Key: []byte("/registry/secrets/default/plain"), Value: []byte("k8s\x00plaintext")},
+	}
+
+	// Unencrypted secrets and those on the latest provider are not listed, ties are broken by name
+	result := Classify(kvs, LatestProvider{Name: "kmsprovider3", Seq: 3}, config)
+	assert.Equal(t, []SecretProvider{
+		{Name: "default/c", Provider: "kmsprovider1", Seq: 1},
+		{Name: "default/a", Provider: "kmsprovider2", Seq: 2},
+		{Name: "default/b", Provider: "kmsprovider2", Seq: 2},
+	}, result.OldestSecrets)
+
+	config.Comparison = ComparisonName
+	assert.Empty(t, Classify(kvs, LatestProvider{Name: "kmsprovider3"}, config).OldestSecrets)
+}
+
+func TestClassify_TargetKeyID(t *testing.T) {
+	encryptedWith := func(keyID string) []byte {
+		encryptedObject := protowire.AppendTag(nil, 2, protowire.BytesType)
+		return append([]byte("k8s:enc:kms:v2:kmsprovider1:"), protowire.AppendString(encryptedObject, keyID)...)
+	}
+	config := Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")}
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/a"), Value: encryptedWith("key-1")},
+		{Key: []byte("/registry/secrets/default/b"), Value: encryptedWith("key-2")},
+	}
+	latest := LatestProvider{Name: "kmsprovider1", Seq: 1}
+	assert.True(t, Classify(kvs, latest, config).AllSecretsUseLatestProvider)
+
+	config.TargetKeyID = "key-2"
+	assert.False(t, Classify(kvs, latest, config).AllSecretsUseLatestProvider)
+	assert.True(t, Classify(kvs[1:], latest, config).AllSecretsUseLatestProvider)
+}
+
+func TestClassify_Findings(t *testing.T) {
+	var findings []Finding
+	config := Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider"), MaxSecretNames: 1, Findings: func(finding Finding) {
+		findings = append(findings, finding)
+	}}
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/a"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+		{Key: []byte("/registry/secrets/default/b"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data")},
+		{Key: []byte("/registry/secrets/kube-system/c"), Value: []byte("k8s\x00plain")},
+		{Key: []byte("/registry/secrets/kube-system/d"), Value: []byte("garbage")},
+	}
+
+	// Secrets left out of the names are found too
+	Classify(kvs, LatestProvider{Name: "kmsprovider2", Seq: 2}, config)
+	assert.Equal(t, []Finding{
+		{Namespace: "default", Name: "a", Category: CategoryEncrypted, ProviderType: "kms", Provider: "kmsprovider1", Seq: 1, Size: 32},
+		{Namespace: "default", Name: "b", Category: CategoryEncrypted, ProviderType: "kms", Provider: "kmsprovider2", Seq: 2, Size: 32},
+		{Namespace: "kube-system", Name: "c", Category: CategoryUnencrypted, ProviderType: "identity", Encoding: "protobuf", Size: 9},
+		{Namespace: "kube-system", Name: "d", Category: CategoryUnrecognized, ProviderType: "identity", Encoding: "unknown", Size: 7},
+	}, findings)
+}
+
+func TestClassifyFindings(t *testing.T) {
+	var findings []Finding
+	config := Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider"), CountStaleNamespaces: true, Findings: func(finding Finding) {
+		findings = append(findings, finding)
+	}}
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/a"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+		{Key: []byte("/registry/secrets/default/b"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data")},
+		{Key: []byte("/registry/secrets/kube-system/c"), Value: []byte("k8s\x00plain")},
+		{Key: []byte("/registry/secrets/kube-system/d"), Value: []byte("garbage")},
+	}
+	latest := LatestProvider{Name: "kmsprovider2", Seq: 2}
+	expected := Classify(kvs, latest, config)
+
+	// The findings of an analysis classify as its secrets did
+	assert.Equal(t, expected, ClassifyFindings(findings, latest, config))
+
+	result := ClassifyFindings(findings[1:2], latest, config)
+	assert.Equal(t, []string{"default/b"}, result.EncryptedSecrets)
+	assert.Equal(t, map[string]int{"kmsprovider2": 1}, result.ProviderCounts)
+	assert.True(t, result.AllSecretsUseLatestProvider)
+}
+
+func TestClassify_StaleNamespaces(t *testing.T) {
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/a"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+		{Key: []byte("/registry/secrets/default/b"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data")},
+		{Key: []byte("/registry/secrets/kube-system/c"), Value: []byte("k8s\x00plain")},
+		{Key: []byte("/registry/secrets/kube-system/d"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+		{Key: []byte("/registry/secrets/team-a/e"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data")},
+		// Cluster-scoped objects of other resources are in no namespace
+		{Key: []byte("/registry/example.com/clusterwidgets/f"), Value: []byte("k8s\x00plain")},
+	}
+	config := Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")}
+	assert.Nil(t, Classify(kvs, LatestProvider{Name: "kmsprovider2", Seq: 2}, config).StaleNamespaces)
+
+	config.CountStaleNamespaces = true
+	result := Classify(kvs, LatestProvider{Name: "kmsprovider2", Seq: 2}, config)
+	assert.Equal(t, map[string]int{"default": 1, "kube-system": 2}, result.StaleNamespaces)
+}
+
+func TestClassify_ParseErrors(t *testing.T) {
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/a"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+		{Key: []byte("garbage"), Value: []byte("data")},
+		{Key: []byte("/registry/secrets/default/b"), Value: []byte("k8s:enc:kms:v2:other:data")},
+	}
+	for i := 0; i < 3; i++ {
+		kvs = append(kvs, &mvccpb.KeyValue{Key: []byte(fmt.Sprintf("garbage-%d", i)), Value: []byte("data")})
+	}
+	result := Classify(kvs, LatestProvider{Name: "kmsprovider1", Seq: 1}, Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")})
+	assert.Equal(t, int64(5), result.Scan.ParseErrors)
+	assert.Equal(t, map[string]int64{utils.ParseErrorInvalidKey: 4, utils.ParseErrorProviderNameMismatch: 1}, result.Scan.ParseErrorClasses)
+
+	assert.Nil(t, Classify(kvs[:1], LatestProvider{Name: "kmsprovider1", Seq: 1}, Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")}).Scan.ParseErrorClasses)
+}
+
+func TestSampled(t *testing.T) {
+	var logged []int64
+	for n := int64(1); n <= 30; n++ {
+		if sampled(n, 3, 10) {
+			logged = append(logged, n)
+		}
+	}
+	assert.Equal(t, []int64{1, 2, 3, 13, 23}, logged)
+}
+
+func TestInsertLargest(t *testing.T) {
+	var largest []SecretSize
+	for i, size := range []int{5, 1, 9, 3, 9, 7} {
+		largest = InsertLargest(largest, SecretSize{Name: fmt.Sprintf("default/s%d", i), Size: size}, 3)
+	}
+	assert.Equal(t, []SecretSize{{Name: "default/s2", Size: 9}, {Name: "default/s4", Size: 9}, {Name: "default/s5", Size: 7}}, largest)
+}
+
+func TestParseComparisonMode(t *testing.T) {
+	mode, err := ParseComparisonMode("sequence")
+	assert.NoError(t, err)
+	assert.Equal(t, ComparisonSequence, mode)
+
+	mode, err = ParseComparisonMode("name")
+	assert.NoError(t, err)
+	assert.Equal(t, ComparisonName, mode)
+
+	_, err = ParseComparisonMode("position")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid comparison mode")
+}
+
+func TestFindLatestProvider(t *testing.T) {
+	encryptionConfig, err := ParseEncryptionConfiguration([]byte(`
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- providers:
+  - identity: {}
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms.sock
+      name: invalidname
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms2.sock
+      name: kmsprovider7
+  - aescbc:
+      keys:
+      - name: key1
+        secret: c2VjcmV0IGlzIHNlY3VyZQ==
+  resources:
+  - secrets
+`))
+	assert.NoError(t, err)
+
+	matcher := mustProviderMatcher(t, "kmsprovider")
+	assert.Equal(t, LatestProvider{Name: "kmsprovider7", Seq: 7}, FindLatestProvider(encryptionConfig, "secrets", matcher, ComparisonSequence))
+	assert.Equal(t, LatestProvider{Name: "invalidname"}, FindLatestProvider(encryptionConfig, "secrets", matcher, ComparisonName))
+	assert.Equal(t, LatestProvider{Seq: IdentityProviderSeq}, FindLatestProvider(EncryptionConfiguration{}, "secrets", matcher, ComparisonSequence))
+	// A resource the configuration does not cover is written in plaintext
+	assert.Equal(t, LatestProvider{Seq: IdentityProviderSeq}, FindLatestProvider(encryptionConfig, "configmaps", matcher, ComparisonSequence))
+
+	var types []string
+	for _, provider := range encryptionConfig.ProvidersOf("secrets") {
+		types = append(types, provider.Type())
+	}
+	assert.Equal(t, []string{"identity", "kms", "kms", "aescbc"}, types)
+
+	_, err = ParseEncryptionConfiguration([]byte("invalid: yaml: content: ["))
+	assert.ErrorContains(t, err, "failed to unmarshal encryption configuration")
+	assert.ErrorIs(t, err, ErrInvalidEncryptionConfig)
+}
+
+func TestConfig_RequestTimeout(t *testing.T) {
+	assert.Equal(t, DefaultUnpaginatedTimeout, Config{}.RequestTimeout())
+	// Paginated requests get more time for larger pages
+	assert.Equal(t, 10*time.Second, Config{PageSize: 1000}.RequestTimeout())
+	assert.Equal(t, 55*time.Second, Config{PageSize: 10000}.RequestTimeout())
+	// An explicit timeout is used as is
+	assert.Equal(t, 3*time.Second, Config{PageSize: 10000, Timeout: 3 * time.Second}.RequestTimeout())
+}
+
+func TestNewTargetProvider(t *testing.T) {
+	matcher := mustProviderMatcher(t, "kmsprovider")
+	testCases := []struct {
+		name          string
+		targetName    string
+		seq           int
+		comparison    ComparisonMode
+		expected      LatestProvider
+		expectedError string
+	}{
+		{name: "sequence parsed from the name", targetName: "kmsprovider3", seq: -1, comparison: ComparisonSequence, expected: LatestProvider{Name: "kmsprovider3", Seq: 3}},
+		{name: "explicit sequence", targetName: "custom", seq: 4, comparison: ComparisonSequence, expected: LatestProvider{Name: "custom", Seq: 4}},
+		{name: "sequence only", seq: 0, comparison: ComparisonSequence, expected: LatestProvider{}},
+		{name: "unparsable name", targetName: "custom", seq: -1, comparison: ComparisonSequence, expectedError: "failed to parse target provider sequence"},
+		{name: "neither name nor sequence", seq: -1, comparison: ComparisonSequence, expectedError: "requires a name or a sequence"},
+		{name: "name comparison ignores the sequence", targetName: "azure-keyvault-blue", seq: 4, comparison: ComparisonName, expected: LatestProvider{Name: "azure-keyvault-blue"}},
+		{name: "name comparison without name", seq: 4, comparison: ComparisonName, expectedError: "requires a target provider name"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target, err := NewTargetProvider(tc.targetName, tc.seq, matcher, tc.comparison)
+			if tc.expectedError != "" {
+				assert.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, target)
+		})
+	}
+}
+
+// classifyKVs returns n encrypted secrets spread over 100 namespaces and 3 providers.
+func classifyKVs(n int) []*mvccpb.KeyValue {
+	payload := make([]byte, 2048)
+	kvs := make([]*mvccpb.KeyValue, 0, n)
+	for i := 0; i < n; i++ {
+		kvs = append(kvs, &mvccpb.KeyValue{
+			Key:   []byte(fmt.Sprintf("/registry/secrets/namespace-%d/secret-%d", i%100, i)),
+			Value: append([]byte(fmt.Sprintf("k8s:enc:kms:v2:kmsprovider%d:", i%3)), payload...),
+		})
+	}
+	return kvs
+}
+
+// BenchmarkClassify reports the allocations per secret, which the hot path keeps to the single string
+// of its namespaced name.
+func BenchmarkClassify(b *testing.B) {
+	matcher, err := utils.NewProviderNameMatcher("kmsprovider", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	config := Config{ProviderMatcher: matcher}
+	kvs := classifyKVs(10000)
+
+	var before, after runtime.MemStats
+	b.ReportAllocs()
+	b.ResetTimer()
+	runtime.ReadMemStats(&before)
+	for i := 0; i < b.N; i++ {
+		Classify(kvs, LatestProvider{Name: "kmsprovider2", Seq: 2}, config)
+	}
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.Mallocs-before.Mallocs)/float64(b.N*len(kvs)), "allocs/secret")
+}
+
+func TestClassify_Allocations(t *testing.T) {
+	config := Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")}
+	kvs := classifyKVs(1000)
+	allocs := testing.AllocsPerRun(5, func() {
+		Classify(kvs, LatestProvider{Name: "kmsprovider2", Seq: 2}, config)
+	})
+	// One namespaced name per secret, and a few for the result and its growing lists
+	assert.LessOrEqual(t, allocs, float64(len(kvs)+100))
+}
+
+func TestAnalyzer_Analyze_Streaming(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	etcdMock := mock_etcd.NewMockEtcdClientOperator(ctrl)
+	page := func(more bool, values ...string) *clientv3.GetResponse {
+		resp := &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: 42}, More: more}
+		for i, value := range values {
+			key := fmt.Sprintf("/registry/secrets/default/secret-%d-%d", len(values), i)
+			if more {
+				key = fmt.Sprintf("/registry/secrets/a/secret-%d", i)
+			}
+			resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value)})
+		}
+		return resp
+	}
+	gomock.InOrder(
+		etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Len(2)).
+			Return(page(true, "k8s:enc:kms:v2:kmsprovider1:data", "k8s:enc:kms:v2:kmsprovider1:data"), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets/a/secret-1\x00", gomock.Len(3)).
+			Return(page(false, "k8s:enc:kms:v2:kmsprovider2:data", "k8s\x00plain", "k8s\x00plain"), nil),
+	)
+
+	resolved := 0
+	result, err := New().Analyze(context.Background(), etcdMock, Config{
+		PageSize:        2,
+		MaxSecretNames:  1,
+		ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider: func(context.Context) (LatestProvider, error) {
+			resolved++
+			return LatestProvider{Name: "kmsprovider2", Seq: 2}, nil
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resolved)
+	assert.Equal(t, []string{"a/secret-0"}, result.EncryptedSecrets)
+	assert.Equal(t, []string{"default/secret-3-1"}, result.UnencryptedSecrets)
+	assert.Equal(t, 2, result.OmittedEncrypted)
+	assert.Equal(t, 1, result.OmittedUnencrypted)
+	assert.Equal(t, 5, result.Total())
+	assert.Equal(t, map[string]int{"kmsprovider1": 2, "kmsprovider2": 1, IdentityProviderName: 2}, result.ProviderCounts)
+	assert.False(t, result.AllSecretsUseLatestProvider)
+	assert.Equal(t, int64(42), result.Revision)
+
+	// Errors resolving the latest provider abort the scan
+	etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Any()).
+		Return(page(false, "k8s:enc:kms:v2:kmsprovider1:data"), nil)
+	_, err = New().Analyze(context.Background(), etcdMock, Config{
+		MaxSecretNames:  1,
+		ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider: func(context.Context) (LatestProvider, error) {
+			return LatestProvider{}, errors.New("not found")
+		},
+	})
+	assert.ErrorContains(t, err, "failed to resolve latest provider")
+}
+
+func TestAnalyzer_Analyze_Progress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	etcdMock := mock_etcd.NewMockEtcdClientOperator(ctrl)
+	page := func(more bool, keys ...string) *clientv3.GetResponse {
+		resp := &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: 42}, More: more}
+		for _, key := range keys {
+			resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(key), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")})
+		}
+		return resp
+	}
+
+	// The keys are counted first, and the pages are pinned to the counted revision
+	gomock.InOrder(
+		etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Len(2)).
+			Return(&clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: 42}, Count: 3}, nil),
+		etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Len(3)).
+			Return(page(true, "/registry/secrets/a/one", "/registry/secrets/b/two"), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), "/registry/secrets/b/two\x00", gomock.Len(3)).
+			Return(page(false, "/registry/secrets/c/three"), nil),
+	)
+
+	var progress []Progress
+	_, err := New().Analyze(context.Background(), etcdMock, Config{
+		PageSize:        2,
+		ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider:  StaticProvider(LatestProvider{Name: "kmsprovider1", Seq: 1}),
+		Progress:        func(p Progress) { progress = append(progress, p) },
+	})
+	assert.NoError(t, err)
+	assert.Len(t, progress, 2)
+	assert.Equal(t, int64(2), progress[0].Processed)
+	assert.Equal(t, int64(3), progress[0].Total)
+	assert.Equal(t, 1, progress[0].Page)
+	assert.InDelta(t, 2.0/3, progress[0].Fraction(), 0.001)
+	assert.Equal(t, 2, progress[1].Page)
+	assert.Equal(t, 1.0, progress[1].Fraction())
+
+	// A failed count does not fail the scan
+	etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Len(2)).Return(nil, errors.New("unavailable"))
+	etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Len(2)).Return(page(false, "/registry/secrets/a/one"), nil)
+	progress = nil
+	_, err = New().Analyze(context.Background(), etcdMock, Config{
+		PageSize:        2,
+		ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider:  StaticProvider(LatestProvider{Name: "kmsprovider1", Seq: 1}),
+		Progress:        func(p Progress) { progress = append(progress, p) },
+	})
+	assert.NoError(t, err)
+	assert.Len(t, progress, 1)
+	assert.Equal(t, int64(0), progress[0].Total)
+	assert.Equal(t, 0.0, progress[0].Fraction())
+}
+
+func TestAnalyzer_Analyze_Fixture(t *testing.T) {
+	client, err := etcd.NewFixtureClient("testdata/edge-cases.yaml")
+	assert.NoError(t, err)
+
+	result, err := New().Analyze(context.Background(), client, Config{
+		PageSize:        2,
+		ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider:  StaticProvider(LatestProvider{Name: "kmsprovider2", Seq: 2}),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default/colon-in-payload", "default/encrypted", "default/older-provider"}, result.EncryptedSecrets)
+	assert.Equal(t, []string{"default/aescbc", "kube-system/json", "kube-system/plain"}, result.UnencryptedSecrets)
+	assert.Equal(t, []string{"kube-system/garbage"}, result.UnrecognizedSecrets)
+	assert.Equal(t, map[string]int{"json": 1, "protobuf": 1}, result.EncodingCounts)
+	assert.Equal(t, 7, result.Total())
+	assert.False(t, result.AllSecretsUseLatestProvider)
+}
+
+func TestEncryptionConfiguration_Covers(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources []string
+		resource  string
+		expected  bool
+	}{
+		{name: "listed", resources: []string{"configmaps", "secrets"}, resource: "secrets", expected: true},
+		{name: "not listed", resources: []string{"configmaps"}, resource: "secrets", expected: false},
+		{name: "core group wildcard", resources: []string{"*."}, resource: "secrets", expected: true},
+		{name: "all resources wildcard", resources: []string{"*.*"}, resource: "secrets", expected: true},
+		{name: "other group wildcard", resources: []string{"*.apps"}, resource: "secrets", expected: false},
+		{name: "group resource", resources: []string{"*.example.com"}, resource: "widgets.example.com", expected: true},
+		{name: "core group wildcard excludes other groups", resources: []string{"*."}, resource: "widgets.example.com", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := EncryptionConfiguration{Resources: []Resource{{Resources: tt.resources}}}
+			assert.Equal(t, tt.expected, config.Covers(tt.resource))
+		})
+	}
+}
+
+func TestEncryptionConfiguration_ProvidersOf(t *testing.T) {
+	config := EncryptionConfiguration{Resources: []Resource{
+		{Resources: []string{"configmaps"}, Providers: []Provider{{Identity: &struct{}{}}}},
+		{Resources: []string{"secrets"}, Providers: []Provider{{KMS: &KMSProvider{Name: "kmsprovider2"}}, {KMS: &KMSProvider{Name: "kmsprovider1"}}}},
+		{Resources: []string{"*.*"}, Providers: []Provider{{KMS: &KMSProvider{Name: "kmsprovider3"}}}},
+	}}
+	// Only the first matching entry is used
+	assert.Equal(t, config.Resources[1].Providers, config.ProvidersOf("secrets"))
+	assert.Equal(t, config.Resources[2].Providers, config.ProvidersOf("widgets.example.com"))
+	assert.Nil(t, EncryptionConfiguration{}.ProvidersOf("secrets"))
+}
+
+func TestResourceFromPrefix(t *testing.T) {
+	assert.Equal(t, "secrets", ResourceFromPrefix(DefaultPrefix))
+	assert.Equal(t, "secrets", ResourceFromPrefix("/custom-registry/secrets/"))
+	assert.Equal(t, "widgets.example.com", ResourceFromPrefix("/registry/example.com/widgets"))
+	assert.Equal(t, "", ResourceFromPrefix("/registry"))
+	assert.Equal(t, "secrets", ResourceFromPrefix("/cluster-a/registry/secrets"))
+	assert.Equal(t, "widgets.example.com", ResourceFromPrefix("/cluster-a/registry/example.com/widgets"))
+}
+
+func TestKeyRootPrefix(t *testing.T) {
+	assert.Equal(t, DefaultPrefix, KeyRootPrefix("", "secrets"))
+	assert.Equal(t, "/cluster-a/registry/secrets", KeyRootPrefix("/cluster-a/registry/", "secrets"))
+	assert.Equal(t, "/registry/example.com/widgets", KeyRootPrefix("/registry", "widgets.example.com"))
+}
+
+func TestAnalyzer_Analyze_KeyLayout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	kvs := []*mvccpb.KeyValue{
+		{Key: []byte("/cluster-a/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+		{Key: []byte("/cluster-a/registry/secrets/kube-system/secret2"), Value: []byte("k8s\x00plaintext")},
+	}
+	for _, keyRoot := range []string{"", "/cluster-a/registry"} {
+		etcdMock := mock_etcd.NewMockEtcdClientOperator(ctrl)
+		etcdMock.EXPECT().Get(gomock.Any(), "/cluster-a/registry/secrets", gomock.Any()).Return(&clientv3.GetResponse{Kvs: kvs}, nil)
+		result, err := New().Analyze(context.Background(), etcdMock, Config{
+			Prefix:          "/cluster-a/registry/secrets",
+			KeyRoot:         keyRoot,
+			ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+			LatestProvider:  StaticProvider(LatestProvider{Name: "kmsprovider1", Seq: 1}),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"default/secret1"}, result.EncryptedSecrets)
+		assert.Equal(t, []string{"kube-system/secret2"}, result.UnencryptedSecrets)
+	}
+}
+
+func TestAnalyzer_Analyze_Classifiers(t *testing.T) {
+	// An API server fork writing fork:enc:kms:<provider>:<data>
+	classifier := utils.ClassifierFunc(func(key, value []byte) (utils.Classification, bool, error) {
+		rest, ok := bytes.CutPrefix(value, []byte("fork:enc:kms:"))
+		if !ok {
+			return utils.Classification{}, false, nil
+		}
+		providerName, _, _ := bytes.Cut(rest, []byte(":"))
+		return utils.Classification{ProviderType: "kms", ProviderName: string(providerName)}, true, nil
+	})
+	source := etcd.NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/forked"), Value: []byte("fork:enc:kms:kmsprovider2:data")},
+		{Key: []byte("/registry/secrets/default/stale"), Value: []byte("fork:enc:kms:kmsprovider1:data")},
+		{Key: []byte("/registry/secrets/default/upstream"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data")},
+	})
+	result, err := New().Analyze(context.Background(), source, Config{
+		ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider:  StaticProvider(LatestProvider{Name: "kmsprovider2", Seq: 2}),
+		Classifiers:     []utils.Classifier{classifier},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default/forked", "default/stale", "default/upstream"}, result.EncryptedSecrets)
+	assert.Equal(t, map[string]int{"kmsprovider1": 1, "kmsprovider2": 2}, result.ProviderCounts)
+	assert.False(t, result.AllSecretsUseLatestProvider)
+}
+
+func TestResult_WithoutNames(t *testing.T) {
+	result := Result{
+		EncryptedSecrets:    []string{"default/secret1", "default/secret2"},
+		UnencryptedSecrets:  []string{"team-a/secret3"},
+		UnrecognizedSecrets: []string{"team-a/secret4"},
+		OmittedEncrypted:    3,
+		ProviderCounts:      map[string]int{"kmsprovider1": 5, "identity": 1},
+		LargestSecrets:      []SecretSize{{Name: "default/secret1", Size: 1024}},
+		OldestSecrets:       []SecretProvider{{Name: "default/secret2", Provider: "kmsprovider1", Seq: 1}},
+		StaleNamespaces:     map[string]int{"team-a": 1},
+		Skew:                &RevisionSkew{Revision: 10, Modified: 1, ModifiedSecrets: []string{"default/secret1"}},
+	}
+	redacted := result.WithoutNames()
+	assert.Empty(t, redacted.EncryptedSecrets)
+	assert.Empty(t, redacted.UnencryptedSecrets)
+	assert.Empty(t, redacted.UnrecognizedSecrets)
+	assert.Empty(t, redacted.LargestSecrets)
+	assert.Empty(t, redacted.OldestSecrets)
+	assert.Equal(t, &RevisionSkew{Revision: 10, Modified: 1}, redacted.Skew)
+	assert.Equal(t, 5, redacted.EncryptedCount())
+	assert.Equal(t, 1, redacted.UnencryptedCount())
+	assert.Equal(t, 1, redacted.UnrecognizedCount())
+	assert.Equal(t, result.ProviderCounts, redacted.ProviderCounts)
+	assert.Equal(t, result.StaleNamespaces, redacted.StaleNamespaces)
+	// The original result is unchanged
+	assert.Len(t, result.EncryptedSecrets, 2)
+	assert.Equal(t, []string{"default/secret1"}, result.Skew.ModifiedSecrets)
+}
@@ -0,0 +1,71 @@
+package analyzer
+
+import (
+	"sync"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+// kvsPool holds the slices the pages of unstreamed scans are collected in, so that periodic analyses
+// reuse them instead of growing a new one to the size of the key space every run.
+var kvsPool sync.Pool
+
+// getKVs returns an empty slice with room for at least n key-values, reused from an earlier analysis
+// when it is large enough.
+func getKVs(n int) []*mvccpb.KeyValue {
+	if pooled, ok := kvsPool.Get().(*[]*mvccpb.KeyValue); ok && cap(*pooled) >= n {
+		return (*pooled)[:0]
+	}
+	return make([]*mvccpb.KeyValue, 0, n)
+}
+
+// putKVs returns kvs to the pool once nothing refers to its key-values any more. The key-values are
+// cleared, so that the pool does not keep the values of the last scan alive.
+func putKVs(kvs []*mvccpb.KeyValue) {
+	clear(kvs)
+	kvs = kvs[:0]
+	kvsPool.Put(&kvs)
+}
+
+// resultSizes are the sizes of the last result of a key range, the capacities the next analysis of
+// the range preallocates, so that a steady key space is analyzed without growing any list.
+type resultSizes struct {
+	// keys is the number of keys classified
+	keys        int
+	encrypted   int
+	unencrypted int
+}
+
+// withHeadroom returns n with room for the key space to grow a little between two analyses.
+func withHeadroom(n int) int {
+	return n + n/16
+}
+
+// sizes returns the sizes of the last result of keyRange, zero before the first one.
+func (a *Analyzer) sizes(keyRange KeyRange) resultSizes {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastSizes[keyRange]
+}
+
+// recordSizes keeps the sizes of result, the analysis of keyRange, for the next analysis of the range.
+func (a *Analyzer) recordSizes(keyRange KeyRange, result Result) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lastSizes == nil {
+		a.lastSizes = map[KeyRange]resultSizes{}
+	}
+	a.lastSizes[keyRange] = resultSizes{
+		keys:        result.Total(),
+		encrypted:   len(result.EncryptedSecrets),
+		unencrypted: len(result.UnencryptedSecrets),
+	}
+}
+
+// newSizedResult returns an empty result compared against latest, with its lists preallocated to sizes.
+func newSizedResult(latest LatestProvider, sizes resultSizes) Result {
+	result := newResult(latest)
+	result.EncryptedSecrets = make([]string, 0, withHeadroom(sizes.encrypted))
+	result.UnencryptedSecrets = make([]string, 0, withHeadroom(sizes.unencrypted))
+	return result
+}
@@ -0,0 +1,119 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// benchmarkKVs returns n secrets spread over 100 namespaces, a third of them encrypted by each of
+// kmsprovider0, kmsprovider1 and kmsprovider2, with values of 2 KiB.
+func benchmarkKVs(n int) []*mvccpb.KeyValue {
+	payload := make([]byte, 2048)
+	kvs := make([]*mvccpb.KeyValue, 0, n)
+	for i := 0; i < n; i++ {
+		kvs = append(kvs, &mvccpb.KeyValue{
+			Key:         []byte(fmt.Sprintf("/registry/secrets/namespace-%d/secret-%d", i%100, i)),
+			Value:       append([]byte(fmt.Sprintf("k8s:enc:kms:v2:kmsprovider%d:", i%3)), payload...),
+			ModRevision: int64(i + 1),
+		})
+	}
+	return kvs
+}
+
+func benchmarkConfig(tb testing.TB) Config {
+	matcher, err := utils.NewProviderNameMatcher("kmsprovider", "")
+	require.NoError(tb, err)
+	return Config{ProviderMatcher: matcher, LatestProvider: StaticProvider(LatestProvider{Name: "kmsprovider2", Seq: 2})}
+}
+
+func TestAnalyzer_Analyze_Preallocated(t *testing.T) {
+	source := etcd.NewMemoryClient(append(benchmarkKVs(300), &mvccpb.KeyValue{Key: []byte("/registry/secrets/default/plain"), Value: []byte("k8s\x00plain")}))
+	for name, config := range map[string]Config{
+		"list":      benchmarkConfig(t),
+		"paginated": func() Config { c := benchmarkConfig(t); c.PageSize = 50; return c }(),
+		"streaming": func() Config { c := benchmarkConfig(t); c.MaxSecretNames = 100; return c }(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			a := New()
+			first, err := a.Analyze(context.Background(), source, config)
+			require.NoError(t, err)
+			second, err := a.Analyze(context.Background(), source, config)
+			require.NoError(t, err)
+
+			// The second result is the same, in lists sized for it from the start, only timed apart
+			first.Scan.Duration, second.Scan.Duration = 0, 0
+			assert.Equal(t, first, second)
+			assert.Equal(t, withHeadroom(len(first.EncryptedSecrets)), cap(second.EncryptedSecrets))
+			assert.Equal(t, withHeadroom(len(first.UnencryptedSecrets)), cap(second.UnencryptedSecrets))
+		})
+	}
+}
+
+func TestAnalyzer_Analyze_PreallocatedPerKeyRange(t *testing.T) {
+	source := etcd.NewMemoryClient(benchmarkKVs(300))
+	config := benchmarkConfig(t)
+	a := New()
+	_, err := a.Analyze(context.Background(), source, config)
+	require.NoError(t, err)
+
+	// Another key range is not sized after the first one
+	config.Prefix = "/registry/configmaps"
+	_, err = a.Analyze(context.Background(), source, config)
+	require.NoError(t, err)
+	assert.Equal(t, resultSizes{}, a.sizes(config.keyRange()))
+	config.Prefix = ""
+	assert.Equal(t, resultSizes{keys: 300, encrypted: 300}, a.sizes(config.keyRange()))
+}
+
+func TestPutKVs(t *testing.T) {
+	kvs := append(getKVs(2), &mvccpb.KeyValue{Key: []byte("a")}, &mvccpb.KeyValue{Key: []byte("b")})
+	putKVs(kvs)
+	// The key-values are released
+	assert.Equal(t, []*mvccpb.KeyValue{nil, nil}, kvs)
+	assert.Empty(t, getKVs(1))
+}
+
+// BenchmarkAnalyzer_Analyze compares a new Analyzer every run, which grows every list from scratch,
+// with a reused one in the steady state of periodic runs.
+func BenchmarkAnalyzer_Analyze(b *testing.B) {
+	source := etcd.NewMemoryClient(benchmarkKVs(10000))
+	for _, mode := range []struct {
+		name     string
+		pageSize int64
+	}{
+		{name: "list"},
+		{name: "paginated", pageSize: 1000},
+	} {
+		config := benchmarkConfig(b)
+		config.PageSize = mode.pageSize
+		b.Run(mode.name+"/new", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := New().Analyze(context.Background(), source, config); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(mode.name+"/reused", func(b *testing.B) {
+			a := New()
+			if _, err := a.Analyze(context.Background(), source, config); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := a.Analyze(context.Background(), source, config); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// Cache holds the secrets parsed by the previous analysis, keyed by etcd key, with the revision they
+// were read at. Later analyses list keys only and read the values of keys whose mod revision changed.
+// A Cache is only valid for one key range; analyzing another range replaces its contents.
+type Cache struct {
+	mu       sync.Mutex
+	keyRange KeyRange
+	revision int64
+	entries  map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	modRevision int64
+	obj         utils.ParsedObject
+	err         error
+	// size is the size of the value
+	size int
+}
+
+// NewCache returns an empty cache. The first analysis using it reads every value.
+func NewCache() *Cache {
+	return &Cache{}
+}
+
+// Len returns the number of cached keys.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// analyzeIncremental analyzes the keys in range using config.Cache, reading only the values modified
+// since the cached revision. The cache is only updated once the listing succeeded, so a failed analysis
+// leaves it as it was.
+func (a *Analyzer) analyzeIncremental(ctx context.Context, source Source, config Config) (Result, error) {
+	cache := config.Cache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	keyRange := config.keyRange()
+	if cache.entries == nil || cache.keyRange != keyRange {
+		cache.keyRange = keyRange
+		cache.revision = 0
+		cache.entries = map[string]cacheEntry{}
+	}
+
+	parser := newParser(config)
+	entries := cache.entries
+	revision := cache.revision
+	if revision == 0 {
+		kvs, rev, err := a.list(ctx, source, config, 0)
+		if err != nil {
+			return Result{}, err
+		}
+		entries = make(map[string]cacheEntry, len(kvs))
+		for _, kv := range kvs {
+			obj, err := parser.Parse(kv.Key, kv.Value)
+			entries[string(kv.Key)] = cacheEntry{modRevision: kv.ModRevision, obj: obj, err: err, size: len(kv.Value)}
+		}
+		putKVs(kvs)
+		revision = rev
+	} else {
+		keys, rev, err := a.list(ctx, source, config, 0, clientv3.WithKeysOnly())
+		if err != nil {
+			return Result{}, err
+		}
+
+		current := make(map[string]cacheEntry, len(keys))
+		changed := 0
+		for _, kv := range keys {
+			entry, ok := entries[string(kv.Key)]
+			if !ok || entry.modRevision != kv.ModRevision {
+				changed++
+				continue
+			}
+			current[string(kv.Key)] = entry
+		}
+		putKVs(keys)
+
+		if changed > 0 {
+			// Only keys modified after the cached revision are returned, read at the revision of the key listing
+			// Progress is reported for the key listing, which covers every key
+			changedConfig := config
+			changedConfig.Progress = nil
+			kvs, _, err := a.list(ctx, source, changedConfig, rev, clientv3.WithMinModRev(cache.revision+1))
+			if err != nil {
+				return Result{}, err
+			}
+			for _, kv := range kvs {
+				obj, err := parser.Parse(kv.Key, kv.Value)
+				current[string(kv.Key)] = cacheEntry{modRevision: kv.ModRevision, obj: obj, err: err, size: len(kv.Value)}
+			}
+			putKVs(kvs)
+		}
+		klog.V(2).InfoS("Incremental scan", "keys", len(current), "changed", changed, "revision", rev)
+		entries = current
+		revision = rev
+	}
+
+	cache.entries = entries
+	cache.revision = revision
+
+	if len(entries) == 0 {
+		result := Classify(nil, LatestProvider{}, config)
+		result.Revision = revision
+		return result, nil
+	}
+
+	latest, err := config.LatestProvider(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to resolve latest provider: %w", err)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := newSizedResult(latest, a.sizes(keyRange))
+	result.Revision = revision
+	for _, key := range keys {
+		entry := entries[key]
+		if entry.err != nil {
+			result.addParseError(entry.err, config)
+			continue
+		}
+		result.add(entry.obj, entry.size, config)
+	}
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/mock/gomock"
+
+	mock_etcd "github.com/lzhecheng/kms-reporter/pkg/etcd/mock"
+)
+
+func TestAnalyzer_Analyze_Incremental(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	etcdMock := mock_etcd.NewMockEtcdClientOperator(ctrl)
+	type kv struct {
+		key, provider string
+		modRevision   int64
+	}
+	response := func(revision int64, kvs ...kv) *clientv3.GetResponse {
+		resp := &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: revision}}
+		for _, kv := range kvs {
+			resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{
+				Key:         []byte("/registry/secrets/" + kv.key),
+				Value:       []byte("k8s:enc:kms:v2:" + kv.provider + ":data"),
+				ModRevision: kv.modRevision,
+			})
+		}
+		return resp
+	}
+
+	cache := NewCache()
+	config := Config{
+		ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider:  StaticProvider(LatestProvider{Name: "kmsprovider2", Seq: 2}),
+		Cache:           cache,
+	}
+
+	gomock.InOrder(
+		// The first analysis reads every value
+		etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Len(1)).
+			Return(response(10, kv{"a/one", "kmsprovider1", 5}, kv{"b/two", "kmsprovider1", 6}, kv{"c/three", "kmsprovider2", 7}), nil),
+		// Later analyses list keys, then read the values modified since the cached revision at the listed revision
+		etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Len(2)).
+			Return(response(20, kv{"a/one", "", 15}, kv{"c/three", "", 7}, kv{"d/four", "", 18}), nil),
+		etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Len(3)).
+			Return(response(20, kv{"a/one", "kmsprovider2", 15}, kv{"d/four", "kmsprovider2", 18}), nil),
+		// Without modified keys no value is read
+		etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Len(2)).
+			Return(response(21, kv{"a/one", "", 15}, kv{"c/three", "", 7}, kv{"d/four", "", 18}), nil),
+	)
+
+	result, err := New().Analyze(context.Background(), etcdMock, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/one", "b/two", "c/three"}, result.EncryptedSecrets)
+	assert.False(t, result.AllSecretsUseLatestProvider)
+	assert.Equal(t, 3, cache.Len())
+
+	// a/one was re-encrypted, b/two deleted and d/four created
+	result, err = New().Analyze(context.Background(), etcdMock, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/one", "c/three", "d/four"}, result.EncryptedSecrets)
+	assert.True(t, result.AllSecretsUseLatestProvider)
+	assert.Equal(t, map[string]int{"kmsprovider2": 3}, result.ProviderCounts)
+	assert.Equal(t, int64(20), result.Revision)
+
+	result, err = New().Analyze(context.Background(), etcdMock, config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/one", "c/three", "d/four"}, result.EncryptedSecrets)
+	assert.Equal(t, int64(21), cache.revision)
+}
+
+func TestAnalyzer_Analyze_IncrementalError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	etcdMock := mock_etcd.NewMockEtcdClientOperator(ctrl)
+	cache := NewCache()
+	config := Config{
+		ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider:  StaticProvider(LatestProvider{Name: "kmsprovider1", Seq: 1}),
+		Cache:           cache,
+	}
+
+	gomock.InOrder(
+		etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Any()).
+			Return(&clientv3.GetResponse{
+				Header: &etcdserverpb.ResponseHeader{Revision: 10},
+				Kvs:    []*mvccpb.KeyValue{{Key: []byte("/registry/secrets/a/one"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data"), ModRevision: 5}},
+			}, nil),
+		etcdMock.EXPECT().Get(gomock.Any(), DefaultPrefix, gomock.Any()).Return(nil, errors.New("unavailable")),
+	)
+
+	_, err := New().Analyze(context.Background(), etcdMock, config)
+	assert.NoError(t, err)
+
+	// A failed analysis leaves the cache as it was
+	_, err = New().Analyze(context.Background(), etcdMock, config)
+	assert.Error(t, err)
+	assert.Equal(t, 1, cache.Len())
+	assert.Equal(t, int64(10), cache.revision)
+
+	// Analyzing another range starts over
+	keyRange := PrefixRange(DefaultPrefix + "/kube-system/")
+	config.KeyRange = &keyRange
+	etcdMock.EXPECT().Get(gomock.Any(), keyRange.Start, gomock.Len(1)).
+		Return(&clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: 11}}, nil)
+	result, err := New().Analyze(context.Background(), etcdMock, config)
+	assert.NoError(t, err)
+	assert.Empty(t, result.EncryptedSecrets)
+	assert.Equal(t, 0, cache.Len())
+}
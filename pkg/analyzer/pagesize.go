@@ -0,0 +1,125 @@
+package analyzer
+
+import (
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultMinPageSize is the smallest page an adaptive scan shrinks to when Config.MinPageSize is unset.
+	DefaultMinPageSize = 100
+	// DefaultMaxPageSizeFactor sets the largest page an adaptive scan grows to, as a multiple of
+	// Config.PageSize, when Config.MaxPageSize is unset.
+	DefaultMaxPageSizeFactor = 10
+	// DefaultMaxPageBytes is the size of the keys and values an adaptive page is bounded to when
+	// Config.MaxPageBytes is unset.
+	DefaultMaxPageBytes = 16 << 20
+
+	// slowPageFraction of the request timeout: a slower page halves the next one
+	slowPageFraction = 0.5
+	// fastPageFraction of the request timeout: a faster page doubles the next one
+	fastPageFraction = 0.1
+)
+
+// pageSizer picks the size of every page of a scan. Unless the scan adapts the page size, every page
+// has the configured size.
+type pageSizer struct {
+	size     int64
+	min, max int64
+	adaptive bool
+	timeout  time.Duration
+	// maxBytes bounds the size of a page to about this many bytes at the average key-value size, 0 for no bound
+	maxBytes int64
+	// averageBytes is the moving average of the key-value size of the pages read so far
+	averageBytes float64
+}
+
+func newPageSizer(config Config, timeout time.Duration) *pageSizer {
+	s := &pageSizer{size: config.PageSize, adaptive: config.AdaptivePageSize && config.PageSize > 0, timeout: timeout}
+	if !s.adaptive {
+		return s
+	}
+	s.min = config.MinPageSize
+	if s.min <= 0 {
+		s.min = min(DefaultMinPageSize, config.PageSize)
+	}
+	s.max = config.MaxPageSize
+	if s.max <= 0 {
+		s.max = config.PageSize * DefaultMaxPageSizeFactor
+	}
+	s.maxBytes = config.MaxPageBytes
+	if s.maxBytes <= 0 {
+		s.maxBytes = DefaultMaxPageBytes
+	}
+	s.size = min(max(s.size, s.min), s.max)
+	return s
+}
+
+// limit returns the size of the next page, 0 for a single unpaginated request.
+func (s *pageSizer) limit() int64 {
+	return s.size
+}
+
+// observeValues records the key-values of the previous page, keys of bytes in total, in the average
+// key-value size. Every page weighs as much as all the pages before it, so the average follows ranges
+// of keys whose values are much larger or smaller than the others.
+func (s *pageSizer) observeValues(keys int, bytes int64) {
+	if !s.adaptive || keys == 0 {
+		return
+	}
+	pageAverage := float64(bytes) / float64(keys)
+	if s.averageBytes == 0 {
+		s.averageBytes = pageAverage
+		return
+	}
+	s.averageBytes = (s.averageBytes + pageAverage) / 2
+}
+
+// observe adapts the size of the next page to the time the previous page took: close to the timeout
+// the page is halved, far from it the page is doubled. The page is then bounded to maxBytes at the
+// average key-value size, but not below the minimum page size.
+func (s *pageSizer) observe(elapsed time.Duration) {
+	if !s.adaptive {
+		return
+	}
+	size := s.size
+	switch {
+	case elapsed > time.Duration(float64(s.timeout)*slowPageFraction):
+		size = s.size / 2
+	case elapsed < time.Duration(float64(s.timeout)*fastPageFraction):
+		size = s.size * 2
+	}
+	if s.maxBytes > 0 && s.averageBytes > 0 {
+		size = min(size, int64(float64(s.maxBytes)/s.averageBytes))
+	}
+	s.resize(min(max(size, s.min), s.max), elapsed)
+}
+
+// shrink halves the page after a page timed out and returns false if the page cannot be smaller, in
+// which case the timeout is an error.
+func (s *pageSizer) shrink() bool {
+	if !s.adaptive || s.size <= s.min {
+		return false
+	}
+	s.resize(max(s.size/2, s.min), s.timeout)
+	return true
+}
+
+// pageBytes returns the size of the keys and values of a page.
+func pageBytes(kvs []*mvccpb.KeyValue) int64 {
+	var bytes int64
+	for _, kv := range kvs {
+		bytes += int64(len(kv.Key) + len(kv.Value))
+	}
+	return bytes
+}
+
+func (s *pageSizer) resize(size int64, elapsed time.Duration) {
+	if size == s.size {
+		return
+	}
+	klog.V(2).InfoS("Adapting etcd page size", "from", s.size, "to", size, "pageDuration", elapsed, "timeout", s.timeout, "averageValueBytes", int64(s.averageBytes))
+	s.size = size
+}
@@ -0,0 +1,166 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestNewPageSizer(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		expected pageSizer
+	}{
+		{
+			name:     "static",
+			config:   Config{PageSize: 500},
+			expected: pageSizer{size: 500, timeout: time.Second},
+		},
+		{
+			name:     "unpaginated",
+			config:   Config{AdaptivePageSize: true},
+			expected: pageSizer{timeout: time.Second},
+		},
+		{
+			name:     "adaptive defaults",
+			config:   Config{PageSize: 500, AdaptivePageSize: true},
+			expected: pageSizer{size: 500, min: DefaultMinPageSize, max: 5000, adaptive: true, timeout: time.Second, maxBytes: DefaultMaxPageBytes},
+		},
+		{
+			name:     "page smaller than the default minimum",
+			config:   Config{PageSize: 10, AdaptivePageSize: true},
+			expected: pageSizer{size: 10, min: 10, max: 100, adaptive: true, timeout: time.Second, maxBytes: DefaultMaxPageBytes},
+		},
+		{
+			name:     "page bytes",
+			config:   Config{PageSize: 500, AdaptivePageSize: true, MaxPageBytes: 1 << 20},
+			expected: pageSizer{size: 500, min: DefaultMinPageSize, max: 5000, adaptive: true, timeout: time.Second, maxBytes: 1 << 20},
+		},
+		{
+			name:     "page clamped to the bounds",
+			config:   Config{PageSize: 500, AdaptivePageSize: true, MinPageSize: 1000, MaxPageSize: 2000},
+			expected: pageSizer{size: 1000, min: 1000, max: 2000, adaptive: true, timeout: time.Second, maxBytes: DefaultMaxPageBytes},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, *newPageSizer(tt.config, time.Second))
+		})
+	}
+}
+
+func TestPageSizer_Observe(t *testing.T) {
+	sizer := newPageSizer(Config{PageSize: 400, AdaptivePageSize: true, MinPageSize: 100, MaxPageSize: 1000}, time.Second)
+
+	// Pages between a tenth and half of the timeout keep their size
+	sizer.observe(300 * time.Millisecond)
+	assert.Equal(t, int64(400), sizer.limit())
+
+	sizer.observe(50 * time.Millisecond)
+	assert.Equal(t, int64(800), sizer.limit())
+	sizer.observe(50 * time.Millisecond)
+	assert.Equal(t, int64(1000), sizer.limit())
+
+	sizer.observe(900 * time.Millisecond)
+	assert.Equal(t, int64(500), sizer.limit())
+	assert.True(t, sizer.shrink())
+	assert.Equal(t, int64(250), sizer.limit())
+	assert.True(t, sizer.shrink())
+	assert.Equal(t, int64(125), sizer.limit())
+	assert.True(t, sizer.shrink())
+	assert.Equal(t, int64(100), sizer.limit())
+	assert.False(t, sizer.shrink())
+
+	static := newPageSizer(Config{PageSize: 400}, time.Second)
+	static.observeValues(400, 400<<20)
+	static.observe(900 * time.Millisecond)
+	assert.Equal(t, int64(400), static.limit())
+	assert.False(t, static.shrink())
+}
+
+func TestPageSizer_ObserveValues(t *testing.T) {
+	sizer := newPageSizer(Config{PageSize: 400, AdaptivePageSize: true, MinPageSize: 10, MaxPageSize: 1000, MaxPageBytes: 100 << 10}, time.Second)
+
+	// Pages of 1 KiB values fit 100 key-values, even when they are fast
+	sizer.observeValues(400, 400<<10)
+	sizer.observe(50 * time.Millisecond)
+	assert.Equal(t, int64(100), sizer.limit())
+
+	// The average follows smaller values, and the page grows with them
+	sizer.observeValues(100, 100*256)
+	sizer.observe(50 * time.Millisecond)
+	assert.Equal(t, int64(160), sizer.limit())
+	sizer.observeValues(160, 160*256)
+	sizer.observe(50 * time.Millisecond)
+	assert.Equal(t, int64(228), sizer.limit())
+
+	// The minimum page size takes precedence
+	sizer.observeValues(256, 256<<20)
+	sizer.observe(300 * time.Millisecond)
+	assert.Equal(t, int64(10), sizer.limit())
+
+	// Empty pages do not change the average
+	sizer.observeValues(0, 0)
+	assert.InDelta(t, float64(448+1<<20)/2, sizer.averageBytes, 1)
+}
+
+// slowSource serves keys in pages, but larger pages than maxLimit never return before the deadline,
+// like a node with slow disks
+type slowSource struct {
+	keys     []string
+	maxLimit int64
+	limits   []int64
+}
+
+func (s *slowSource) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	op := clientv3.OpGet(key, opts...)
+	s.limits = append(s.limits, op.Limit())
+	if op.Limit() > s.maxLimit {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	start := sort.SearchStrings(s.keys, key)
+	end := min(start+int(op.Limit()), len(s.keys))
+	resp := &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: 42}, More: end < len(s.keys)}
+	for _, k := range s.keys[start:end] {
+		resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")})
+	}
+	return resp, nil
+}
+
+func TestAnalyzer_Analyze_AdaptivePageSize(t *testing.T) {
+	source := &slowSource{maxLimit: 2}
+	for i := range 5 {
+		source.keys = append(source.keys, fmt.Sprintf("/registry/secrets/default/secret%d", i))
+	}
+	config := Config{
+		PageSize:         4,
+		AdaptivePageSize: true,
+		MinPageSize:      1,
+		Timeout:          50 * time.Millisecond,
+		ProviderMatcher:  mustProviderMatcher(t, "kmsprovider"),
+		LatestProvider:   StaticProvider(LatestProvider{Name: "kmsprovider1", Seq: 1}),
+	}
+
+	// Pages that time out are read again with half the size, fast pages double the next one
+	result, err := New().Analyze(context.Background(), source, config)
+	require.NoError(t, err)
+	assert.Len(t, result.EncryptedSecrets, 5)
+	assert.Equal(t, []int64{4, 2, 4, 2, 4, 2}, source.limits)
+
+	// A page that times out at the minimum size fails the scan
+	source.limits = nil
+	config.MinPageSize = 4
+	_, err = New().Analyze(context.Background(), source, config)
+	assert.ErrorIs(t, err, errPageTimeout)
+	assert.Equal(t, []int64{4}, source.limits)
+}
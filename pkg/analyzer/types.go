@@ -0,0 +1,299 @@
+package analyzer
+
+import (
+	"fmt"
+	"time"
+)
+
+// ComparisonMode selects how secrets are compared against the latest provider.
+type ComparisonMode string
+
+const (
+	// ComparisonSequence compares the ordering sequence extracted from provider names.
+	ComparisonSequence ComparisonMode = "sequence"
+	// ComparisonName treats the first KMS provider in the configuration as the latest
+	// and compares provider names exactly. Names need no numeric sequence.
+	ComparisonName ComparisonMode = "name"
+)
+
+// ParseComparisonMode validates a comparison mode flag value.
+func ParseComparisonMode(mode string) (ComparisonMode, error) {
+	switch ComparisonMode(mode) {
+	case ComparisonSequence, ComparisonName:
+		return ComparisonMode(mode), nil
+	default:
+		return "", fmt.Errorf("invalid comparison mode %q, must be %q or %q", mode, ComparisonSequence, ComparisonName)
+	}
+}
+
+// LatestProvider identifies the provider secrets are expected to be encrypted with.
+// Name is empty and Seq is IdentityProviderSeq when no KMS provider is configured.
+type LatestProvider struct {
+	Name string
+	Seq  int
+	// NotCovered is set when the encryption configuration has no entry for the scanned resource.
+	// The API server then writes it in plaintext whatever the providers are.
+	NotCovered bool
+}
+
+// EncryptionConfiguration represents the encryption configuration structure
+type EncryptionConfiguration struct {
+	APIVersion string     `yaml:"apiVersion"`
+	Kind       string     `yaml:"kind"`
+	Resources  []Resource `yaml:"resources"`
+}
+
+type Resource struct {
+	Providers []Provider `yaml:"providers"`
+	Resources []string   `yaml:"resources"`
+}
+
+type Provider struct {
+	KMS      *KMSProvider `yaml:"kms,omitempty"`
+	Identity *struct{}    `yaml:"identity,omitempty"`
+	// AESCBC, AESGCM and Secretbox only tell the type of the provider: their keys are not read.
+	AESCBC    *struct{} `yaml:"aescbc,omitempty"`
+	AESGCM    *struct{} `yaml:"aesgcm,omitempty"`
+	Secretbox *struct{} `yaml:"secretbox,omitempty"`
+}
+
+// Type returns the type of the provider as in the prefix of the values it encrypts, e.g. "kms" or
+// "aescbc", IdentityProviderName for the identity provider, or "" for an unknown type.
+func (p Provider) Type() string {
+	switch {
+	case p.KMS != nil:
+		return "kms"
+	case p.Identity != nil:
+		return IdentityProviderName
+	case p.AESCBC != nil:
+		return "aescbc"
+	case p.AESGCM != nil:
+		return "aesgcm"
+	case p.Secretbox != nil:
+		return "secretbox"
+	default:
+		return ""
+	}
+}
+
+type KMSProvider struct {
+	APIVersion string `yaml:"apiVersion"`
+	Endpoint   string `yaml:"endpoint"`
+	Name       string `yaml:"name"`
+}
+
+// Result holds the result of analyzing secret encryption status
+type Result struct {
+	EncryptedSecrets            []string
+	UnencryptedSecrets          []string
+	AllSecretsUseLatestProvider bool
+	// ProviderCounts maps each KMS provider name to the number of secrets it encrypted. The secrets of
+	// other providers are counted under their type, e.g. "aescbc", unencrypted ones under "identity".
+	ProviderCounts map[string]int
+	// SequenceCounts maps the sequence number of each KMS provider to the number of secrets it encrypted,
+	// unencrypted secrets being counted under 0. Secrets whose provider name has no valid sequence fail to
+	// parse and are not counted. Not set in name comparison mode, which does not parse the sequences.
+	SequenceCounts map[int]int
+	// NonKMSCounts maps the provider type of the secrets encrypted by a provider other than KMS, e.g.
+	// "aescbc", to their number. They have no sequence and are left out of SequenceCounts.
+	NonKMSCounts map[string]int
+	// LatestProvider is the provider the secrets were compared against.
+	LatestProvider LatestProvider
+	// OmittedEncrypted and OmittedUnencrypted count the secrets left out of EncryptedSecrets and
+	// UnencryptedSecrets because of Config.MaxSecretNames.
+	OmittedEncrypted   int
+	OmittedUnencrypted int
+	// UnrecognizedSecrets are the secrets whose value is neither encrypted nor in a known storage
+	// encoding, e.g. corrupted values or values written by another tool. They are not counted as unencrypted.
+	UnrecognizedSecrets []string
+	// OmittedUnrecognized counts the secrets left out of UnrecognizedSecrets because of Config.MaxSecretNames.
+	OmittedUnrecognized int
+	// EncodingCounts maps the storage encoding of unencrypted secrets (e.g. "protobuf", "json") to their number.
+	EncodingCounts map[string]int
+	// LargestSecrets are the Config.LargestSecrets secrets with the largest values, largest first.
+	LargestSecrets []SecretSize
+	// OldestSecrets are the Config.OldestSecrets encrypted secrets not on the latest provider with the
+	// lowest provider sequences, lowest first.
+	OldestSecrets []SecretProvider
+	// Scan describes the etcd reads of the analysis.
+	Scan ScanStats
+	// Revision is the etcd revision the secrets were read at, or 0 if the scan was not a snapshot of
+	// a single revision, e.g. with Kine.
+	Revision int64
+	// ClusterID is the ID of the etcd cluster the secrets were read from, from the response headers, or 0
+	// if the source does not set it, e.g. a fixture.
+	ClusterID uint64
+	// StaleNamespaces maps every namespace holding secrets that are not encrypted by the latest provider,
+	// unencrypted ones included, to their number. Only set with Config.CountStaleNamespaces.
+	StaleNamespaces map[string]int
+	// Skew describes the secrets changed between Revision and the end of a paginated scan, or is nil if
+	// none were or the scan was not checked. See Config.CheckRevisionSkew.
+	Skew *RevisionSkew
+}
+
+// Categories of a secret in a result
+const (
+	CategoryEncrypted    = "Encrypted"
+	CategoryUnencrypted  = "Unencrypted"
+	CategoryUnrecognized = "Unrecognized"
+)
+
+// Finding is what the analysis found about a single secret.
+type Finding struct {
+	// Namespace is empty for cluster-scoped objects.
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Category is CategoryEncrypted, CategoryUnencrypted or CategoryUnrecognized, as in Result.
+	Category string `json:"category"`
+	// ProviderType is the encryption provider type, e.g. "kms" or "aescbc", or "identity" in plaintext.
+	ProviderType string `json:"providerType"`
+	// Provider is the KMS provider name, empty unless the secret is KMS-encrypted.
+	Provider string `json:"provider"`
+	// Seq is the sequence of Provider, 0 in name comparison.
+	Seq int `json:"seq"`
+	// KeyID is the KMS v2 key ID, if it could be decoded.
+	KeyID string `json:"keyID"`
+	// Encoding is the storage encoding of a secret stored in plaintext.
+	Encoding string `json:"encoding"`
+	// Size is the size of the value as stored in etcd, in bytes.
+	Size int `json:"size"`
+}
+
+// RevisionSkew describes the secrets created, updated or deleted after the revision a scan was pinned
+// to, while it was running. The result may be outdated for them.
+type RevisionSkew struct {
+	// Revision is the etcd revision at the end of the scan.
+	Revision int64 `json:"revision"`
+	// Modified is the number of secrets created or updated after the scan revision.
+	Modified int `json:"modified"`
+	// ModifiedSecrets are the first of the Modified secrets.
+	ModifiedSecrets []string `json:"modifiedSecrets,omitempty"`
+	// Created is the number of secrets created after the scan revision.
+	Created int `json:"created"`
+	// Deleted is the number of scanned secrets deleted after the scan revision.
+	Deleted int `json:"deleted"`
+}
+
+// SecretSize is the size of the value of a secret as stored in etcd, encrypted or not.
+type SecretSize struct {
+	// Name is the namespaced name of the secret, e.g. default/my-secret.
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// SecretProvider is the provider a secret is encrypted with.
+type SecretProvider struct {
+	// Name is the namespaced name of the secret, e.g. default/my-secret.
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Seq      int    `json:"seq"`
+}
+
+// ScanStats describes the etcd reads of an analysis, for capacity planning.
+type ScanStats struct {
+	// Keys is the number of keys returned by etcd. An incremental scan counts the keys of its key
+	// listing and those whose value it reads.
+	Keys int64
+	// Bytes is the size of the keys and values returned by etcd.
+	Bytes int64
+	// Pages is the number of etcd requests that returned key-values, count-only requests excluded.
+	Pages int64
+	// ParseErrors is the number of keys skipped because they could not be parsed.
+	ParseErrors int64
+	// ParseErrorClasses counts the parse errors by class, e.g. utils.ParseErrorInvalidKey, or is nil if
+	// there were none.
+	ParseErrorClasses map[string]int64
+	// Duration is how long the analysis took, etcd reads included.
+	Duration time.Duration
+	// Concurrency is the number of scans that ran concurrently to produce the result: 1 for a single
+	// analysis, which reads and classifies sequentially, and the number of shards for a merged result.
+	Concurrency int
+}
+
+// Add returns the stats of the concurrent scans s and other: their sums, and the longest duration.
+func (s ScanStats) Add(other ScanStats) ScanStats {
+	return ScanStats{
+		Keys:              s.Keys + other.Keys,
+		Bytes:             s.Bytes + other.Bytes,
+		Pages:             s.Pages + other.Pages,
+		ParseErrors:       s.ParseErrors + other.ParseErrors,
+		ParseErrorClasses: addCounts(s.ParseErrorClasses, other.ParseErrorClasses),
+		Duration:          max(s.Duration, other.Duration),
+		Concurrency:       s.Concurrency + other.Concurrency,
+	}
+}
+
+// addCounts returns the sums of the counts of a and b by key, or nil if both are empty.
+func addCounts(a, b map[string]int64) map[string]int64 {
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	sum := make(map[string]int64, len(a)+len(b))
+	for key, count := range a {
+		sum[key] += count
+	}
+	for key, count := range b {
+		sum[key] += count
+	}
+	return sum
+}
+
+// Add returns the combined skew of s and other, either of which may be nil.
+func (s *RevisionSkew) Add(other *RevisionSkew) *RevisionSkew {
+	if s == nil {
+		return other
+	}
+	if other == nil {
+		return s
+	}
+	names := append(append([]string{}, s.ModifiedSecrets...), other.ModifiedSecrets...)
+	return &RevisionSkew{
+		Revision:        max(s.Revision, other.Revision),
+		Modified:        s.Modified + other.Modified,
+		ModifiedSecrets: names[:min(len(names), maxSkewSecrets)],
+		Created:         s.Created + other.Created,
+		Deleted:         s.Deleted + other.Deleted,
+	}
+}
+
+// Total returns the number of secrets that were analyzed.
+func (r Result) Total() int {
+	return r.EncryptedCount() + r.UnencryptedCount() + r.UnrecognizedCount()
+}
+
+// FullyEncryptedByLatest reports whether secrets were found and every one of them is encrypted by
+// the latest provider.
+func (r Result) FullyEncryptedByLatest() bool {
+	return r.Total() > 0 && r.UnencryptedCount() == 0 && r.UnrecognizedCount() == 0 && r.AllSecretsUseLatestProvider
+}
+
+// EncryptedCount returns the number of encrypted secrets, including omitted names.
+func (r Result) EncryptedCount() int {
+	return len(r.EncryptedSecrets) + r.OmittedEncrypted
+}
+
+// UnencryptedCount returns the number of unencrypted secrets, including omitted names.
+func (r Result) UnencryptedCount() int {
+	return len(r.UnencryptedSecrets) + r.OmittedUnencrypted
+}
+
+// UnrecognizedCount returns the number of secrets whose value was not recognized, including omitted names.
+func (r Result) UnrecognizedCount() int {
+	return len(r.UnrecognizedSecrets) + r.OmittedUnrecognized
+}
+
+// WithoutNames returns a copy of the result without any secret name, for outputs that must only show
+// counts. The names are counted as omitted, so every count is unchanged. Per-namespace counts are kept.
+func (r Result) WithoutNames() Result {
+	r.OmittedEncrypted += len(r.EncryptedSecrets)
+	r.OmittedUnencrypted += len(r.UnencryptedSecrets)
+	r.OmittedUnrecognized += len(r.UnrecognizedSecrets)
+	r.EncryptedSecrets, r.UnencryptedSecrets, r.UnrecognizedSecrets = nil, nil, nil
+	r.LargestSecrets, r.OldestSecrets = nil, nil
+	if r.Skew != nil {
+		skew := *r.Skew
+		skew.ModifiedSecrets = nil
+		r.Skew = &skew
+	}
+	return r
+}
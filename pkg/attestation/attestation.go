@@ -0,0 +1,377 @@
+// Package attestation writes an in-toto attestation of the encryption summary of every run, in a DSSE
+// envelope optionally signed with a private key, for supply-chain and compliance tooling.
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+	"github.com/lzhecheng/kms-reporter/pkg/version"
+)
+
+const (
+	// StatementType is the type of an in-toto v1 statement.
+	StatementType = "https://in-toto.io/Statement/v1"
+	// PredicateType is the type of the encryption summary predicate.
+	PredicateType = "https://github.com/lzhecheng/kms-reporter/encryption-summary/v1"
+	// PayloadType is the DSSE payload type of an in-toto statement.
+	PayloadType = "application/vnd.in-toto+json"
+
+	webhookTimeout = 10 * time.Second
+)
+
+// ErrSignatureMismatch is returned when no signature of an envelope is valid for the public key.
+var ErrSignatureMismatch = errors.New("attestation signature does not match")
+
+// Subject identifies what a statement is about: the scanned resource of a cluster at an etcd revision.
+type Subject struct {
+	// Name is <cluster>/<resource>@<revision>, e.g. prod/secrets@1234, without @<revision> if the scan
+	// was not a snapshot of a single revision.
+	Name string `json:"name"`
+	// Digest holds the SHA-256 of the predicate, binding the summary to the subject.
+	Digest map[string]string `json:"digest"`
+}
+
+// Predicate is the encryption summary of a run.
+type Predicate struct {
+	Cluster  string `json:"cluster"`
+	Resource string `json:"resource"`
+	// Revision is the etcd revision the secrets were read at, or 0.
+	Revision       int64          `json:"etcdRevision,omitempty"`
+	RunID          string         `json:"runID,omitempty"`
+	Reporter       string         `json:"reporter"`
+	ScannedAt      time.Time      `json:"scannedAt"`
+	Encrypted      int            `json:"encrypted"`
+	Unencrypted    int            `json:"unencrypted"`
+	Unrecognized   int            `json:"unrecognized"`
+	ProviderCounts map[string]int `json:"providerCounts"`
+	LatestProvider string         `json:"latestProvider"`
+	// AllSecretsUseLatestProvider is true when every secret is encrypted by LatestProvider.
+	AllSecretsUseLatestProvider bool `json:"allSecretsUseLatestProvider"`
+}
+
+// Statement is an in-toto v1 statement of the encryption summary.
+type Statement struct {
+	Type          string    `json:"_type"`
+	Subject       []Subject `json:"subject"`
+	PredicateType string    `json:"predicateType"`
+	Predicate     Predicate `json:"predicate"`
+}
+
+// Signature is a DSSE signature.
+type Signature struct {
+	// KeyID is the hex-encoded SHA-256 of the DER-encoded public key.
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"`
+}
+
+// Envelope is a DSSE envelope. Its Signatures are empty when no signing key is configured.
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     string      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// NewStatement returns the statement of result, the scan of resource in cluster at scannedAt.
+func NewStatement(cluster, resource, runID string, scannedAt time.Time, result analyzer.Result) (Statement, error) {
+	predicate := Predicate{
+		Cluster:                     cluster,
+		Resource:                    resource,
+		Revision:                    result.Revision,
+		RunID:                       runID,
+		Reporter:                    version.Get().Version,
+		ScannedAt:                   scannedAt.UTC().Truncate(time.Second),
+		Encrypted:                   result.EncryptedCount(),
+		Unencrypted:                 result.UnencryptedCount(),
+		Unrecognized:                result.UnrecognizedCount(),
+		ProviderCounts:              result.ProviderCounts,
+		LatestProvider:              result.LatestProvider.Name,
+		AllSecretsUseLatestProvider: result.AllSecretsUseLatestProvider,
+	}
+	if predicate.ProviderCounts == nil {
+		predicate.ProviderCounts = map[string]int{}
+	}
+	data, err := json.Marshal(predicate)
+	if err != nil {
+		return Statement{}, fmt.Errorf("failed to marshal attestation predicate: %w", err)
+	}
+	digest := sha256.Sum256(data)
+	name := cluster + "/" + resource
+	if result.Revision > 0 {
+		name += "@" + strconv.FormatInt(result.Revision, 10)
+	}
+	return Statement{
+		Type:          StatementType,
+		Subject:       []Subject{{Name: name, Digest: map[string]string{"sha256": hex.EncodeToString(digest[:])}}},
+		PredicateType: PredicateType,
+		Predicate:     predicate,
+	}, nil
+}
+
+// ReadSigningKey reads a PEM-encoded Ed25519, ECDSA or RSA private key from path, in PKCS #8, SEC 1
+// ("EC PRIVATE KEY") or PKCS #1 ("RSA PRIVATE KEY") form, e.g. as written by openssl genpkey.
+func ReadSigningKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("attestation signing key %s is not PEM-encoded", path)
+	}
+	var key any
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse attestation signing key %s: %w", path, err)
+	}
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case *rsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported attestation signing key type %T", key)
+	}
+}
+
+// pae returns the DSSE pre-authentication encoding of payload, the bytes that are signed.
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// keyID returns the hex-encoded SHA-256 of the DER-encoded public key.
+func keyID(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal attestation public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Seal returns the envelope of statement, signed with signer if it is not nil. Ed25519 keys sign the
+// encoding directly, ECDSA and RSA (PKCS #1 v1.5) keys its SHA-256.
+func Seal(statement Statement, signer crypto.Signer) (Envelope, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to marshal attestation statement: %w", err)
+	}
+	envelope := Envelope{PayloadType: PayloadType, Payload: base64.StdEncoding.EncodeToString(payload), Signatures: []Signature{}}
+	if signer == nil {
+		return envelope, nil
+	}
+	message := pae(PayloadType, payload)
+	var sig []byte
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		sig, err = signer.Sign(rand.Reader, message, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(message)
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to sign attestation: %w", err)
+	}
+	id, err := keyID(signer.Public())
+	if err != nil {
+		return Envelope{}, err
+	}
+	envelope.Signatures = append(envelope.Signatures, Signature{KeyID: id, Sig: base64.StdEncoding.EncodeToString(sig)})
+	return envelope, nil
+}
+
+// Open verifies that a signature of envelope is valid for publicKey and returns its statement.
+func Open(envelope Envelope, publicKey crypto.PublicKey) (Statement, error) {
+	if envelope.PayloadType != PayloadType {
+		return Statement{}, fmt.Errorf("unexpected attestation payload type %q", envelope.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return Statement{}, fmt.Errorf("malformed attestation payload: %w", err)
+	}
+	message := pae(envelope.PayloadType, payload)
+	digest := sha256.Sum256(message)
+	verified := false
+	for _, signature := range envelope.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			continue
+		}
+		switch key := publicKey.(type) {
+		case ed25519.PublicKey:
+			verified = ed25519.Verify(key, message, sig)
+		case *ecdsa.PublicKey:
+			verified = ecdsa.VerifyASN1(key, digest[:], sig)
+		case *rsa.PublicKey:
+			verified = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+		default:
+			return Statement{}, fmt.Errorf("unsupported attestation public key type %T", publicKey)
+		}
+		if verified {
+			break
+		}
+	}
+	if !verified {
+		return Statement{}, ErrSignatureMismatch
+	}
+	var statement Statement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return Statement{}, fmt.Errorf("failed to unmarshal attestation statement: %w", err)
+	}
+	return statement, nil
+}
+
+// Sink stores attestations.
+type Sink interface {
+	Write(ctx context.Context, envelope Envelope) error
+}
+
+// FileSink appends envelopes as JSON lines to a file, as in .intoto.jsonl bundles.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens path for appending, creating it if needed.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open attestation file: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write appends the envelope and syncs the file.
+func (s *FileSink) Write(_ context.Context, envelope Envelope) error {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attestation: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write attestation file: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync attestation file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// WebhookSink posts envelopes to an attestation endpoint.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a sink posting to url.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Write posts the envelope as JSON.
+func (s *WebhookSink) Write(ctx context.Context, envelope Envelope) error {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attestation: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create attestation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "kms-reporter/"+version.Get().Version)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send attestation to %s: %w", s.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("attestation endpoint %s returned %s: %s", s.url, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// Config configures an Attestor.
+type Config struct {
+	// Cluster names the cluster in the subject and predicate of the statements.
+	Cluster string
+	// Signer signs the envelopes. Optional, envelopes are unsigned without it.
+	Signer crypto.Signer
+	// Sinks receive every envelope.
+	Sinks []Sink
+}
+
+// Attestor writes an attestation of every complete result to its sinks. It is safe for concurrent use.
+type Attestor struct {
+	config Config
+	now    func() time.Time
+}
+
+// NewAttestor returns an attestor writing to config.Sinks.
+func NewAttestor(config Config) *Attestor {
+	return &Attestor{config: config, now: time.Now}
+}
+
+// Attest writes the attestation of result, the scan of resource by the run in ctx, to every sink.
+// Failures are logged and counted, and do not fail the run.
+func (a *Attestor) Attest(ctx context.Context, resource string, result analyzer.Result) {
+	statement, err := NewStatement(a.config.Cluster, resource, utils.RunIDFromContext(ctx), a.now(), result)
+	if err != nil {
+		metrics.AttestationWriteFailuresTotal.Inc()
+		klog.ErrorS(err, "Failed to create attestation", "resource", resource)
+		return
+	}
+	envelope, err := Seal(statement, a.config.Signer)
+	if err != nil {
+		metrics.AttestationWriteFailuresTotal.Inc()
+		klog.ErrorS(err, "Failed to create attestation", "resource", resource)
+		return
+	}
+	for _, sink := range a.config.Sinks {
+		if err := sink.Write(ctx, envelope); err != nil {
+			metrics.AttestationWriteFailuresTotal.Inc()
+			klog.ErrorS(err, "Failed to write attestation", "subject", statement.Subject[0].Name)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package attestation
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// memorySink keeps the envelopes written to it
+type memorySink struct {
+	envelopes []Envelope
+	err       error
+}
+
+func (s *memorySink) Write(_ context.Context, envelope Envelope) error {
+	s.envelopes = append(s.envelopes, envelope)
+	return s.err
+}
+
+var testResult = analyzer.Result{
+	EncryptedSecrets:   []string{"default/a", "default/b"},
+	UnencryptedSecrets: []string{"default/c"},
+	ProviderCounts:     map[string]int{"kmsprovider2": 2, "identity": 1},
+	LatestProvider:     analyzer.LatestProvider{Name: "kmsprovider2"},
+	Revision:           1234,
+}
+
+func TestNewStatement(t *testing.T) {
+	scannedAt := time.Date(2025, 1, 1, 0, 0, 0, 500, time.UTC)
+	statement, err := NewStatement("prod", "secrets", "run-1", scannedAt, testResult)
+	require.NoError(t, err)
+	assert.Equal(t, StatementType, statement.Type)
+	assert.Equal(t, PredicateType, statement.PredicateType)
+	require.Len(t, statement.Subject, 1)
+	assert.Equal(t, "prod/secrets@1234", statement.Subject[0].Name)
+	assert.Len(t, statement.Subject[0].Digest["sha256"], 64)
+	assert.Equal(t, 2, statement.Predicate.Encrypted)
+	assert.Equal(t, 1, statement.Predicate.Unencrypted)
+	assert.Equal(t, "kmsprovider2", statement.Predicate.LatestProvider)
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), statement.Predicate.ScannedAt)
+
+	// The digest covers the summary
+	changed := testResult
+	changed.UnencryptedSecrets = nil
+	other, err := NewStatement("prod", "secrets", "run-1", scannedAt, changed)
+	require.NoError(t, err)
+	assert.NotEqual(t, statement.Subject[0].Digest, other.Subject[0].Digest)
+
+	// Scans of several revisions have no revision in the subject
+	changed.Revision = 0
+	other, err = NewStatement("prod", "secrets", "run-1", scannedAt, changed)
+	require.NoError(t, err)
+	assert.Equal(t, "prod/secrets", other.Subject[0].Name)
+}
+
+func TestSealOpen(t *testing.T) {
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	statement, err := NewStatement("prod", "secrets", "run-1", time.Now(), testResult)
+	require.NoError(t, err)
+
+	for name, signer := range map[string]crypto.Signer{"ed25519": ed25519Key, "ecdsa": ecdsaKey, "rsa": rsaKey} {
+		t.Run(name, func(t *testing.T) {
+			envelope, err := Seal(statement, signer)
+			require.NoError(t, err)
+			assert.Equal(t, PayloadType, envelope.PayloadType)
+			require.Len(t, envelope.Signatures, 1)
+			assert.Len(t, envelope.Signatures[0].KeyID, 64)
+			opened, err := Open(envelope, signer.Public())
+			require.NoError(t, err)
+			assert.Equal(t, statement.Subject, opened.Subject)
+
+			// A changed payload is detected
+			tampered := statement
+			tampered.Predicate.Unencrypted = 0
+			payload, err := json.Marshal(tampered)
+			require.NoError(t, err)
+			envelope.Payload = base64.StdEncoding.EncodeToString(payload)
+			_, err = Open(envelope, signer.Public())
+			assert.ErrorIs(t, err, ErrSignatureMismatch)
+		})
+	}
+
+	unsigned, err := Seal(statement, nil)
+	require.NoError(t, err)
+	assert.Empty(t, unsigned.Signatures)
+	_, err = Open(unsigned, ecdsaKey.Public())
+	assert.ErrorIs(t, err, ErrSignatureMismatch)
+}
+
+func TestReadSigningKey(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ecdsaKey)
+	require.NoError(t, err)
+	sec1, err := x509.MarshalECPrivateKey(ecdsaKey)
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	for name, block := range map[string]*pem.Block{
+		"pkcs8": {Type: "PRIVATE KEY", Bytes: pkcs8},
+		"sec1":  {Type: "EC PRIVATE KEY", Bytes: sec1},
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+		signer, err := ReadSigningKey(path)
+		require.NoError(t, err, name)
+		assert.True(t, ecdsaKey.Equal(signer), name)
+	}
+
+	path := filepath.Join(dir, "invalid")
+	require.NoError(t, os.WriteFile(path, []byte("not a key"), 0o600))
+	_, err = ReadSigningKey(path)
+	assert.ErrorContains(t, err, "is not PEM-encoded")
+	_, err = ReadSigningKey(filepath.Join(dir, "missing"))
+	assert.ErrorContains(t, err, "failed to read attestation signing key")
+}
+
+func TestAttestor(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sink := &memorySink{}
+	attestor := NewAttestor(Config{Cluster: "prod", Signer: key, Sinks: []Sink{sink}})
+	attestor.now = func() time.Time { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	attestor.Attest(utils.ContextWithRunID(context.Background(), "run-1"), "secrets", testResult)
+	require.Len(t, sink.envelopes, 1)
+	statement, err := Open(sink.envelopes[0], key.Public())
+	require.NoError(t, err)
+	assert.Equal(t, "prod/secrets@1234", statement.Subject[0].Name)
+	assert.Equal(t, "run-1", statement.Predicate.RunID)
+	assert.Equal(t, map[string]int{"kmsprovider2": 2, "identity": 1}, statement.Predicate.ProviderCounts)
+}
+
+func TestAttestor_WriteFailure(t *testing.T) {
+	failures := testutil.ToFloat64(metrics.AttestationWriteFailuresTotal)
+	sink := &memorySink{err: errors.New("disk full")}
+	NewAttestor(Config{Sinks: []Sink{sink}}).Attest(context.Background(), "secrets", testResult)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.AttestationWriteFailuresTotal)-failures)
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kms-reporter.intoto.jsonl")
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(context.Background(), Envelope{PayloadType: PayloadType, Payload: "e30="}))
+	require.NoError(t, sink.Write(context.Background(), Envelope{PayloadType: PayloadType, Payload: "e30K"}))
+	require.NoError(t, sink.Close())
+
+	// Envelopes are appended, one per line
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var payloads []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var envelope Envelope
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &envelope))
+		payloads = append(payloads, envelope.Payload)
+	}
+	assert.Equal(t, []string{"e30=", "e30K"}, payloads)
+}
+
+func TestWebhookSink(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	require.NoError(t, NewWebhookSink(server.URL).Write(context.Background(), Envelope{PayloadType: PayloadType, Payload: "e30=", Signatures: []Signature{}}))
+	assert.JSONEq(t, `{"payloadType":"application/vnd.in-toto+json","payload":"e30=","signatures":[]}`, string(body))
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	err := NewWebhookSink(failing.URL).Write(context.Background(), Envelope{})
+	assert.ErrorContains(t, err, "returned 503 Service Unavailable: unavailable")
+}
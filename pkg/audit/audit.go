@@ -0,0 +1,273 @@
+// Package audit writes an append-only trail of reporter runs and of secrets changing category, as
+// structured records separate from the mutable report ConfigMap, e.g. as compliance evidence.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+	"github.com/lzhecheng/kms-reporter/pkg/version"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// Record types
+const (
+	// TypeRun records the outcome of a run.
+	TypeRun = "Run"
+	// TypeCategoryTransition records a secret moving from one report category to another between two runs.
+	TypeCategoryTransition = "CategoryTransition"
+)
+
+// Report categories of a secret
+const (
+	CategoryEncrypted    = "Encrypted"
+	CategoryUnencrypted  = "Unencrypted"
+	CategoryUnrecognized = "Unrecognized"
+)
+
+// Run outcomes
+const (
+	OutcomeSuccess = "Success"
+	OutcomeFailure = "Failure"
+)
+
+// Actor identifies who produced a record.
+type Actor struct {
+	// Component is always kms-reporter.
+	Component string `json:"component"`
+	// Version is the version of the reporter.
+	Version string `json:"version"`
+	// Host is the host name of the reporter, e.g. its pod name.
+	Host string `json:"host,omitempty"`
+	// Node is the node whose report is written, if one reporter runs per node.
+	Node string `json:"node,omitempty"`
+}
+
+// NewActor returns the actor of this process, writing the report of node.
+func NewActor(node string) Actor {
+	host, _ := os.Hostname()
+	return Actor{Component: "kms-reporter", Version: version.Get().Version, Host: host, Node: node}
+}
+
+// Summary is what a run found.
+type Summary struct {
+	Encrypted                   int    `json:"encrypted"`
+	Unencrypted                 int    `json:"unencrypted"`
+	Unrecognized                int    `json:"unrecognized"`
+	LatestProvider              string `json:"latestProvider"`
+	AllSecretsUseLatestProvider bool   `json:"allSecretsUseLatestProvider"`
+	Revision                    int64  `json:"revision,omitempty"`
+}
+
+// Record is a single audit record: who did what, when.
+type Record struct {
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	RunID string    `json:"runID,omitempty"`
+	Actor Actor     `json:"actor"`
+
+	// Outcome, Error and Summary describe a run.
+	Outcome string   `json:"outcome,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Summary *Summary `json:"summary,omitempty"`
+
+	// Secret, From and To describe a category transition.
+	Secret string `json:"secret,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// Sink stores audit records.
+type Sink interface {
+	Write(ctx context.Context, records []Record) error
+}
+
+// FileSink appends records as JSON lines to a file.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens path for appending, creating it if needed.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write appends the records in a single write and syncs the file.
+func (s *FileSink) Write(_ context.Context, records []Record) error {
+	data, err := marshalLines(records)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write audit file: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// WebhookSink posts records as JSON lines to an audit endpoint.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a sink posting to url.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Write posts the records in a single request.
+func (s *WebhookSink) Write(ctx context.Context, records []Record) error {
+	data, err := marshalLines(records)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create audit request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("User-Agent", "kms-reporter/"+version.Get().Version)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send audit records to %s: %w", s.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("audit endpoint %s returned %s: %s", s.url, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// marshalLines marshals records as JSON lines.
+func marshalLines(records []Record) ([]byte, error) {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return nil, fmt.Errorf("failed to marshal audit record: %w", err)
+		}
+	}
+	return data.Bytes(), nil
+}
+
+// Auditor writes the audit records of the runs to its sinks. It is safe for concurrent use.
+type Auditor struct {
+	actor Actor
+	sinks []Sink
+	now   func() time.Time
+
+	mu sync.Mutex
+	// categories maps each listed secret to its category in the previous result, or is nil before the first one
+	categories map[string]string
+	// summaries holds the summary of the result of each run until the run is recorded
+	summaries map[string]Summary
+}
+
+// NewAuditor returns an auditor writing the records of actor to sinks.
+func NewAuditor(actor Actor, sinks ...Sink) *Auditor {
+	return &Auditor{actor: actor, sinks: sinks, now: time.Now, summaries: map[string]Summary{}}
+}
+
+// ObserveResult records the complete result of the run in ctx: it is summarized in the record of the run,
+// and a transition record is written for every listed secret whose category changed since the previous
+// result. Secrets that appeared or disappeared are not transitions, and the first result after a start
+// only sets the categories.
+func (a *Auditor) ObserveResult(ctx context.Context, result analyzer.Result) {
+	runID := utils.RunIDFromContext(ctx)
+	categories := map[string]string{}
+	for category, names := range map[string][]string{
+		CategoryEncrypted:    result.EncryptedSecrets,
+		CategoryUnencrypted:  result.UnencryptedSecrets,
+		CategoryUnrecognized: result.UnrecognizedSecrets,
+	} {
+		for _, name := range names {
+			categories[name] = category
+		}
+	}
+
+	a.mu.Lock()
+	previous := a.categories
+	a.categories = categories
+	a.summaries[runID] = Summary{
+		Encrypted:                   result.EncryptedCount(),
+		Unencrypted:                 result.UnencryptedCount(),
+		Unrecognized:                result.UnrecognizedCount(),
+		LatestProvider:              result.LatestProvider.Name,
+		AllSecretsUseLatestProvider: result.AllSecretsUseLatestProvider,
+		Revision:                    result.Revision,
+	}
+	a.mu.Unlock()
+
+	now := a.now()
+	var records []Record
+	for name, category := range categories {
+		if from, ok := previous[name]; ok && from != category {
+			records = append(records, Record{Time: now, Type: TypeCategoryTransition, RunID: runID, Actor: a.actor, Secret: name, From: from, To: category})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Secret < records[j].Secret })
+	a.write(ctx, records)
+}
+
+// RecordRun writes the record of the run runID, that finished at finishedAt with runErr, nil if it succeeded.
+func (a *Auditor) RecordRun(ctx context.Context, runID string, runErr error, finishedAt time.Time) {
+	a.mu.Lock()
+	summary, ok := a.summaries[runID]
+	delete(a.summaries, runID)
+	a.mu.Unlock()
+
+	record := Record{Time: finishedAt, Type: TypeRun, RunID: runID, Actor: a.actor, Outcome: OutcomeSuccess}
+	if runErr != nil {
+		record.Outcome = OutcomeFailure
+		record.Error = runErr.Error()
+	}
+	if ok {
+		record.Summary = &summary
+	}
+	a.write(ctx, []Record{record})
+}
+
+// write writes records to every sink. Failures are logged and counted, and do not fail the run.
+func (a *Auditor) write(ctx context.Context, records []Record) {
+	if len(records) == 0 {
+		return
+	}
+	for _, sink := range a.sinks {
+		if err := sink.Write(ctx, records); err != nil {
+			metrics.AuditWriteFailuresTotal.Inc()
+			klog.ErrorS(err, "Failed to write audit records", "records", len(records))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// memorySink keeps the records written to it
+type memorySink struct {
+	records []Record
+	err     error
+}
+
+func (s *memorySink) Write(_ context.Context, records []Record) error {
+	s.records = append(s.records, records...)
+	return s.err
+}
+
+func TestAuditor(t *testing.T) {
+	sink := &memorySink{}
+	actor := Actor{Component: "kms-reporter", Version: "v1.0.0", Host: "kms-reporter-0"}
+	auditor := NewAuditor(actor, sink)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	auditor.now = func() time.Time { return now }
+
+	// The first result only sets the categories
+	ctx := utils.ContextWithRunID(context.Background(), "run-1")
+	auditor.ObserveResult(ctx, analyzer.Result{
+		EncryptedSecrets:   []string{"default/a"},
+		UnencryptedSecrets: []string{"default/b", "default/c"},
+		LatestProvider:     analyzer.LatestProvider{Name: "kmsprovider2"},
+	})
+	auditor.RecordRun(ctx, "run-1", nil, now)
+	require.Len(t, sink.records, 1)
+	assert.Equal(t, Record{
+		Time:    now,
+		Type:    TypeRun,
+		RunID:   "run-1",
+		Actor:   actor,
+		Outcome: OutcomeSuccess,
+		Summary: &Summary{Encrypted: 1, Unencrypted: 2, LatestProvider: "kmsprovider2"},
+	}, sink.records[0])
+
+	// Secrets changing category are recorded, secrets appearing are not
+	ctx = utils.ContextWithRunID(context.Background(), "run-2")
+	auditor.ObserveResult(ctx, analyzer.Result{
+		EncryptedSecrets:    []string{"default/a", "default/c"},
+		UnencryptedSecrets:  []string{"default/d"},
+		UnrecognizedSecrets: []string{"default/b"},
+	})
+	require.Len(t, sink.records, 3)
+	assert.Equal(t, Record{Time: now, Type: TypeCategoryTransition, RunID: "run-2", Actor: actor, Secret: "default/b", From: CategoryUnencrypted, To: CategoryUnrecognized}, sink.records[1])
+	assert.Equal(t, Record{Time: now, Type: TypeCategoryTransition, RunID: "run-2", Actor: actor, Secret: "default/c", From: CategoryUnencrypted, To: CategoryEncrypted}, sink.records[2])
+
+	// Failed runs have no summary
+	auditor.RecordRun(context.Background(), "run-3", errors.New("etcd unavailable"), now)
+	assert.Equal(t, Record{Time: now, Type: TypeRun, RunID: "run-3", Actor: actor, Outcome: OutcomeFailure, Error: "etcd unavailable"}, sink.records[3])
+}
+
+func TestAuditor_WriteFailure(t *testing.T) {
+	failures := testutil.ToFloat64(metrics.AuditWriteFailuresTotal)
+	sink := &memorySink{err: errors.New("disk full")}
+	NewAuditor(Actor{}, sink).RecordRun(context.Background(), "run-1", nil, time.Now())
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.AuditWriteFailuresTotal)-failures)
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(`{"type":"Run","runID":"earlier"}`+"\n"), 0o600))
+
+	sink, err := NewFileSink(path)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(context.Background(), []Record{{Type: TypeRun, RunID: "run-1"}, {Type: TypeCategoryTransition, RunID: "run-1", Secret: "default/a"}}))
+	require.NoError(t, sink.Close())
+
+	// Records are appended, one per line
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var runIDs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		runIDs = append(runIDs, record.RunID)
+	}
+	assert.Equal(t, []string{"earlier", "run-1", "run-1"}, runIDs)
+}
+
+func TestWebhookSink(t *testing.T) {
+	var contentType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL)
+	require.NoError(t, sink.Write(context.Background(), []Record{{Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Type: TypeRun, Actor: Actor{Component: "kms-reporter"}, Outcome: OutcomeSuccess}}))
+	assert.Equal(t, "application/x-ndjson", contentType)
+	assert.Equal(t, `{"time":"2025-01-01T00:00:00Z","type":"Run","actor":{"component":"kms-reporter","version":""},"outcome":"Success"}`+"\n", string(body))
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	err := NewWebhookSink(failing.URL).Write(context.Background(), []Record{{Type: TypeRun}})
+	assert.ErrorContains(t, err, "returned 503 Service Unavailable: unavailable")
+}
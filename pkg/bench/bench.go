@@ -0,0 +1,144 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+const (
+	// heapSampleInterval is how often the heap is sampled for its peak during a run
+	heapSampleInterval = 5 * time.Millisecond
+	// populateWorkers is the number of concurrent writes when populating etcd
+	populateWorkers = 32
+)
+
+// Putter is the etcd write access Populate needs. *clientv3.Client satisfies it.
+type Putter interface {
+	Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error)
+}
+
+// Stats are the measurements of one analysis.
+type Stats struct {
+	// Secrets is the number of secrets analyzed.
+	Secrets int
+	// Duration is the wall time of the analysis.
+	Duration time.Duration
+	// PeakHeapBytes is the highest heap growth sampled during the analysis.
+	PeakHeapBytes uint64
+	// AllocatedBytes is the total size of the allocations made during the analysis.
+	AllocatedBytes uint64
+}
+
+// SecretsPerSecond returns the scan throughput.
+func (s Stats) SecretsPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Secrets) / s.Duration.Seconds()
+}
+
+// String formats the stats for the bench output.
+func (s Stats) String() string {
+	return fmt.Sprintf("%d secrets in %v (%.0f secrets/s), peak heap +%.1f MiB, allocated %.1f MiB",
+		s.Secrets, s.Duration.Round(time.Millisecond), s.SecretsPerSecond(),
+		float64(s.PeakHeapBytes)/(1<<20), float64(s.AllocatedBytes)/(1<<20))
+}
+
+// Run analyzes the secrets in source with config and measures it. The heap is measured relative to
+// the start of the run, so a fixture held in memory is not counted.
+func Run(ctx context.Context, source analyzer.Source, config analyzer.Config) (Stats, error) {
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	peak := before.HeapAlloc
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(heapSampleInterval)
+		defer ticker.Stop()
+		var sample runtime.MemStats
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				runtime.ReadMemStats(&sample)
+				peak = max(peak, sample.HeapAlloc)
+			}
+		}
+	}()
+
+	start := time.Now()
+	result, err := analyzer.New().Analyze(ctx, source, config)
+	duration := time.Since(start)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	peak = max(peak, after.HeapAlloc)
+
+	return Stats{
+		Secrets:        result.Total(),
+		Duration:       duration,
+		PeakHeapBytes:  peak - before.HeapAlloc,
+		AllocatedBytes: after.TotalAlloc - before.TotalAlloc,
+	}, nil
+}
+
+// Populate writes kvs to etcd with concurrent requests, each bounded by timeout.
+func Populate(ctx context.Context, putter Putter, kvs []*mvccpb.KeyValue, timeout time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	work := make(chan *mvccpb.KeyValue)
+	errs := make(chan error, populateWorkers)
+	var wg sync.WaitGroup
+	for i := 0; i < populateWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for kv := range work {
+				putCtx, putCancel := context.WithTimeout(ctx, timeout)
+				_, err := putter.Put(putCtx, string(kv.Key), string(kv.Value))
+				putCancel()
+				if err != nil {
+					errs <- fmt.Errorf("failed to put %s: %w", kv.Key, err)
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, kv := range kvs {
+		select {
+		case work <- kv:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
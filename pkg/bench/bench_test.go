@@ -0,0 +1,86 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+type fakePutter struct {
+	mu   sync.Mutex
+	puts map[string]string
+	err  error
+}
+
+func (p *fakePutter) Put(_ context.Context, key, val string, _ ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.puts[key] = val
+	return &clientv3.PutResponse{}, nil
+}
+
+func TestGenerateFixture(t *testing.T) {
+	kvs := GenerateFixture(FixtureConfig{Secrets: 1000, UnencryptedPercent: 10, Namespaces: 10, ValueSize: 100, Seed: 1})
+	assert.Len(t, kvs, 1000)
+	assert.Equal(t, "/kms-reporter-bench/secrets/ns-0003/secret-00000003", string(kvs[3].Key))
+	assert.Equal(t, int64(4), kvs[3].ModRevision)
+
+	unencrypted := 0
+	for _, kv := range kvs {
+		assert.Len(t, kv.Value, 100)
+		if !strings.HasPrefix(string(kv.Value), "k8s:enc:kms:v2:kmsprovider") {
+			unencrypted++
+		}
+	}
+	assert.InDelta(t, 100, unencrypted, 40)
+
+	// The same seed generates the same fixture
+	assert.Equal(t, kvs, GenerateFixture(FixtureConfig{Secrets: 1000, UnencryptedPercent: 10, Namespaces: 10, ValueSize: 100, Seed: 1}))
+}
+
+func TestRun(t *testing.T) {
+	matcher, err := utils.NewProviderNameMatcher("kmsprovider", "")
+	assert.NoError(t, err)
+	source := etcd.NewMemoryClient(GenerateFixture(FixtureConfig{Secrets: 500, UnencryptedPercent: 20, Seed: 1}))
+
+	stats, err := Run(context.Background(), source, analyzer.Config{
+		Prefix:          DefaultPrefix,
+		PageSize:        100,
+		ProviderMatcher: matcher,
+		LatestProvider:  analyzer.StaticProvider(analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2}),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 500, stats.Secrets)
+	assert.Greater(t, stats.SecretsPerSecond(), 0.0)
+	assert.Greater(t, stats.AllocatedBytes, uint64(0))
+	assert.Contains(t, stats.String(), "500 secrets in")
+
+	_, err = Run(context.Background(), source, analyzer.Config{})
+	assert.Error(t, err)
+}
+
+func TestPopulate(t *testing.T) {
+	kvs := GenerateFixture(FixtureConfig{Secrets: 100, Seed: 1})
+
+	putter := &fakePutter{puts: map[string]string{}}
+	assert.NoError(t, Populate(context.Background(), putter, kvs, time.Second))
+	assert.Len(t, putter.puts, 100)
+	assert.Equal(t, string(kvs[0].Value), putter.puts[string(kvs[0].Key)])
+
+	putter = &fakePutter{puts: map[string]string{}, err: errors.New("no space")}
+	err := Populate(context.Background(), putter, kvs, time.Second)
+	assert.ErrorContains(t, err, "no space")
+}
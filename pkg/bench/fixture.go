@@ -0,0 +1,84 @@
+// Package bench generates synthetic secrets and measures the throughput and memory use of the analyzer.
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+const (
+	// DefaultPrefix is the key prefix synthetic secrets are generated under. It is kept apart from the
+	// secrets of the API server, so a test etcd can be populated without touching them.
+	DefaultPrefix = "/kms-reporter-bench/secrets"
+	// DefaultValueSize is the size of a generated value, close to the size of a typical encrypted secret.
+	DefaultValueSize = 2048
+	// DefaultNamespaces is the number of namespaces secrets are spread across.
+	DefaultNamespaces = 100
+
+	// unencryptedValuePrefix is the prefix of secrets the API server stored as protobuf, without encryption
+	unencryptedValuePrefix = "k8s\x00\n\x0c\n\x02v1\x12\x06Secret"
+)
+
+// DefaultProviders are the KMS provider names encrypted secrets are spread across, latest last.
+var DefaultProviders = []string{"kmsprovider1", "kmsprovider2"}
+
+// FixtureConfig describes a set of synthetic secrets.
+type FixtureConfig struct {
+	// Secrets is the number of secrets to generate.
+	Secrets int
+	// UnencryptedPercent is the share of secrets stored without encryption, from 0 to 100.
+	UnencryptedPercent float64
+	// Providers are the KMS provider names encrypted secrets are spread across. Defaults to DefaultProviders.
+	Providers []string
+	// Prefix is the key prefix of the secrets. Defaults to DefaultPrefix.
+	Prefix string
+	// Namespaces is the number of namespaces the secrets are spread across. Defaults to DefaultNamespaces.
+	Namespaces int
+	// ValueSize is the size of each value in bytes. Defaults to DefaultValueSize.
+	ValueSize int
+	// Seed seeds the random choices, so a fixture can be generated again identically.
+	Seed int64
+}
+
+// GenerateFixture returns config.Secrets synthetic secrets. Every secret has its own mod revision,
+// starting at 1, as if each was written by a separate request.
+func GenerateFixture(config FixtureConfig) []*mvccpb.KeyValue {
+	providers := config.Providers
+	if len(providers) == 0 {
+		providers = DefaultProviders
+	}
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	namespaces := config.Namespaces
+	if namespaces <= 0 {
+		namespaces = DefaultNamespaces
+	}
+	valueSize := config.ValueSize
+	if valueSize <= 0 {
+		valueSize = DefaultValueSize
+	}
+
+	random := rand.New(rand.NewSource(config.Seed))
+	kvs := make([]*mvccpb.KeyValue, 0, config.Secrets)
+	for i := 0; i < config.Secrets; i++ {
+		key := fmt.Sprintf("%s/ns-%04d/secret-%08d", prefix, i%namespaces, i)
+
+		var value []byte
+		if random.Float64()*100 < config.UnencryptedPercent {
+			value = []byte(unencryptedValuePrefix)
+		} else {
+			value = []byte(fmt.Sprintf("k8s:enc:kms:v2:%s:", providers[random.Intn(len(providers))]))
+		}
+		payload := make([]byte, max(valueSize-len(value), 0))
+		random.Read(payload)
+		value = append(value, payload...)
+
+		revision := int64(i + 1)
+		kvs = append(kvs, &mvccpb.KeyValue{Key: []byte(key), Value: value, CreateRevision: revision, ModRevision: revision, Version: 1})
+	}
+	return kvs
+}
@@ -0,0 +1,215 @@
+package doctor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/kmsplugin"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/reader"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// unixScheme prefixes the unix socket endpoints of the KMS providers
+const unixScheme = "unix://"
+
+// Certificate checks that the PEM certificates of certFile are valid for at least minValidity. With a
+// keyFile, certFile is a client certificate whose private key keyFile must match.
+func Certificate(certFile, keyFile string, minValidity time.Duration) CheckFunc {
+	return func(context.Context) (string, error) {
+		var certs []*x509.Certificate
+		if keyFile != "" {
+			pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return "", fmt.Errorf("failed to load certificate and key: %w", err)
+			}
+			for _, der := range pair.Certificate {
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return "", fmt.Errorf("failed to parse certificate %s: %w", certFile, err)
+				}
+				certs = append(certs, cert)
+			}
+		} else {
+			data, err := os.ReadFile(certFile)
+			if err != nil {
+				return "", fmt.Errorf("failed to read certificate: %w", err)
+			}
+			if certs, err = parseCertificates(data); err != nil {
+				return "", fmt.Errorf("failed to parse certificate %s: %w", certFile, err)
+			}
+		}
+		return validity(certs, time.Now(), minValidity)
+	}
+}
+
+// parseCertificates parses the CERTIFICATE blocks of PEM data.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	return certs, nil
+}
+
+// validity checks that certs are valid from now for at least minValidity, and describes the first to expire.
+func validity(certs []*x509.Certificate, now time.Time, minValidity time.Duration) (string, error) {
+	first := certs[0]
+	for _, cert := range certs {
+		switch {
+		case now.Before(cert.NotBefore):
+			return "", fmt.Errorf("certificate %q is not valid before %s", cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339))
+		case now.After(cert.NotAfter):
+			return "", fmt.Errorf("certificate %q expired on %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+		case cert.NotAfter.Sub(now) < minValidity:
+			return "", fmt.Errorf("certificate %q expires on %s, within %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339), minValidity)
+		}
+		if cert.NotAfter.Before(first.NotAfter) {
+			first = cert
+		}
+	}
+	return fmt.Sprintf("%q expires on %s", first.Subject.CommonName, first.NotAfter.Format(time.RFC3339)), nil
+}
+
+// Etcd checks that client can read etcd, by counting the keys of prefix.
+func Etcd(client etcd.EtcdClientOperator, prefix string, timeout time.Duration) CheckFunc {
+	return func(ctx context.Context) (string, error) {
+		readCtx, cancel := utils.ContextWithTimeout(ctx, timeout)
+		defer cancel()
+		response, err := client.Get(readCtx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			return "", fmt.Errorf("failed to count the keys of %s: %w: %w", prefix, etcd.ErrEtcdUnavailable, err)
+		}
+		return fmt.Sprintf("%d keys under %s at revision %d", response.Count, prefix, response.Header.Revision), nil
+	}
+}
+
+// Permissions checks that the identity of clientset is granted permissions.
+func Permissions(clientset kubernetes.Interface, permissions []rbac.Permission) CheckFunc {
+	return func(ctx context.Context) (string, error) {
+		if err := rbac.Check(ctx, clientset, permissions); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d permissions granted", len(permissions)), nil
+	}
+}
+
+// EncryptionConfig checks that the encryption-provider-config ConfigMap of namespace holds an encryption
+// configuration with providers for resource, and stores the configuration in config, e.g. to find the KMS
+// plugins to check.
+func EncryptionConfig(clientset kubernetes.Interface, namespace, resource string, timeout time.Duration, config *analyzer.EncryptionConfiguration) CheckFunc {
+	return func(ctx context.Context) (string, error) {
+		getCtx, cancel := utils.ContextWithTimeout(ctx, timeout)
+		defer cancel()
+		encryptionConfig, err := reader.GetEncryptionConfiguration(getCtx, clientset, namespace)
+		if err != nil {
+			return "", err
+		}
+		*config = encryptionConfig
+		providers := encryptionConfig.ProvidersOf(resource)
+		if len(providers) == 0 {
+			return "", fmt.Errorf("no provider for %s", resource)
+		}
+		names := make([]string, len(providers))
+		for i, provider := range providers {
+			switch {
+			case provider.KMS != nil:
+				names[i] = "kms " + provider.KMS.Name
+			case provider.Type() != "":
+				names[i] = provider.Type()
+			default:
+				names[i] = "unknown"
+			}
+		}
+		return fmt.Sprintf("%s providers: %s", resource, strings.Join(names, ", ")), nil
+	}
+}
+
+// KMSSockets returns the paths of the unix sockets of the KMS providers of resource in config.
+func KMSSockets(config analyzer.EncryptionConfiguration, resource string) []string {
+	var sockets []string
+	for _, provider := range config.ProvidersOf(resource) {
+		if provider.KMS != nil && strings.HasPrefix(provider.KMS.Endpoint, unixScheme) {
+			sockets = append(sockets, strings.TrimPrefix(provider.KMS.Endpoint, unixScheme))
+		}
+	}
+	return sockets
+}
+
+// KMSPlugins checks that the KMS plugins checker calls are healthy.
+func KMSPlugins(checker *kmsplugin.Checker) CheckFunc {
+	return func(ctx context.Context) (string, error) {
+		var healthy, unhealthy []string
+		for _, status := range checker.Check(ctx) {
+			if !status.Healthy {
+				reason := status.Error
+				if reason == "" {
+					reason = fmt.Sprintf("healthz %q", status.Healthz)
+				}
+				unhealthy = append(unhealthy, fmt.Sprintf("%s: %s", status.Socket, reason))
+				continue
+			}
+			healthy = append(healthy, fmt.Sprintf("%s: key %s", status.Socket, status.KeyID))
+		}
+		if len(unhealthy) > 0 {
+			return "", fmt.Errorf("unhealthy KMS plugins: %s", strings.Join(unhealthy, "; "))
+		}
+		return strings.Join(healthy, "; "), nil
+	}
+}
+
+// ReportWritable checks that the report ConfigMap name of namespace can be written, by creating it, or
+// updating it if it exists, in dry-run mode. Unlike a permission check, it also covers e.g. a missing
+// namespace, a quota or an admission webhook rejecting the report.
+func ReportWritable(clientset kubernetes.Interface, namespace, name string, timeout time.Duration) CheckFunc {
+	return func(ctx context.Context) (string, error) {
+		writeCtx, cancel := utils.ContextWithTimeout(ctx, timeout)
+		defer cancel()
+		configMaps := clientset.CoreV1().ConfigMaps(namespace)
+		dryRun := []string{metav1.DryRunAll}
+		report, err := configMaps.Get(writeCtx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			report = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+			if _, err := configMaps.Create(writeCtx, report, metav1.CreateOptions{DryRun: dryRun}); err != nil {
+				return "", fmt.Errorf("failed to create ConfigMap %s/%s: %w", namespace, name, err)
+			}
+			return fmt.Sprintf("ConfigMap %s/%s can be created", namespace, name), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to get ConfigMap %s/%s: %w", namespace, name, err)
+		}
+		if _, err := configMaps.Update(writeCtx, report, metav1.UpdateOptions{DryRun: dryRun}); err != nil {
+			return "", fmt.Errorf("failed to update ConfigMap %s/%s: %w", namespace, name, err)
+		}
+		return fmt.Sprintf("ConfigMap %s/%s can be updated", namespace, name), nil
+	}
+}
@@ -0,0 +1,238 @@
+package doctor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"google.golang.org/grpc"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	kmsapi "k8s.io/kms/apis/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/kmsplugin"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+)
+
+const encryptionConfigYAML = `
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- providers:
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///var/run/kmsplugin/socket.sock
+      name: key-2
+  - kms:
+      apiVersion: v2
+      endpoint: tcp://127.0.0.1:8080
+      name: key-1
+  - identity: {}
+  resources:
+  - secrets
+`
+
+// writeCertificate writes a self-signed certificate valid from notBefore to notAfter and its key to dir
+func writeCertificate(t *testing.T, dir string, notBefore, notAfter time.Time) (certFile, keyFile string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "etcd-client"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600))
+	return certFile, keyFile
+}
+
+func TestCertificate(t *testing.T) {
+	now := time.Now()
+	notAfter := now.Add(90 * 24 * time.Hour).UTC().Truncate(time.Second)
+	tests := []struct {
+		name          string
+		notBefore     time.Time
+		notAfter      time.Time
+		minValidity   time.Duration
+		expectedError string
+	}{
+		{
+			name:      "valid",
+			notBefore: now.Add(-time.Hour),
+			notAfter:  notAfter,
+		},
+		{
+			name:          "expiring",
+			notBefore:     now.Add(-time.Hour),
+			notAfter:      notAfter,
+			minValidity:   100 * 24 * time.Hour,
+			expectedError: `certificate "etcd-client" expires on ` + notAfter.Format(time.RFC3339) + ", within 2400h0m0s",
+		},
+		{
+			name:          "expired",
+			notBefore:     now.Add(-2 * time.Hour),
+			notAfter:      now.Add(-time.Hour),
+			expectedError: `certificate "etcd-client" expired on`,
+		},
+		{
+			name:          "not yet valid",
+			notBefore:     now.Add(time.Hour),
+			notAfter:      notAfter,
+			expectedError: `certificate "etcd-client" is not valid before`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certFile, keyFile := writeCertificate(t, t.TempDir(), tt.notBefore, tt.notAfter)
+			for _, key := range []string{keyFile, ""} {
+				detail, err := Certificate(certFile, key, tt.minValidity)(context.Background())
+				if tt.expectedError != "" {
+					assert.ErrorContains(t, err, tt.expectedError)
+					continue
+				}
+				require.NoError(t, err)
+				assert.Equal(t, `"etcd-client" expires on `+notAfter.Format(time.RFC3339), detail)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	certFile, _ := writeCertificate(t, dir, now.Add(-time.Hour), notAfter)
+	_, otherKey := writeCertificate(t, t.TempDir(), now.Add(-time.Hour), notAfter)
+	_, err := Certificate(certFile, otherKey, 0)(context.Background())
+	assert.ErrorContains(t, err, "failed to load certificate and key")
+	_, err = Certificate(filepath.Join(dir, "missing.crt"), "", 0)(context.Background())
+	assert.ErrorContains(t, err, "failed to read certificate")
+	require.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0o600))
+	_, err = Certificate(certFile, "", 0)(context.Background())
+	assert.ErrorContains(t, err, "no PEM certificate found")
+}
+
+func TestEtcd(t *testing.T) {
+	client := etcd.NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/a"), ModRevision: 4},
+		{Key: []byte("/registry/secrets/default/b"), ModRevision: 7},
+		{Key: []byte("/registry/configmaps/default/c"), ModRevision: 9},
+	})
+	detail, err := Etcd(client, "/registry/secrets/", time.Second)(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "2 keys under /registry/secrets/ at revision 9", detail)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Etcd(client, "/registry/secrets/", time.Second)(ctx)
+	assert.ErrorIs(t, err, etcd.ErrEtcdUnavailable)
+}
+
+func TestPermissions(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Verb == "get"
+		return true, review, nil
+	})
+	get := rbac.Permission{Verb: "get", Resource: "configmaps", Namespace: "kube-system"}
+	detail, err := Permissions(clientset, []rbac.Permission{get, get})(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "2 permissions granted", detail)
+
+	_, err = Permissions(clientset, []rbac.Permission{get, {Verb: "create", Resource: "configmaps", Namespace: "kube-system"}})(context.Background())
+	assert.ErrorIs(t, err, rbac.ErrMissingPermissions)
+	assert.ErrorContains(t, err, "create configmaps in namespace kube-system")
+}
+
+func TestEncryptionConfig(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "encryption-provider-config", Namespace: "kube-system"},
+		Data:       map[string]string{"encryption-provider-config.yaml": encryptionConfigYAML},
+	})
+	var config analyzer.EncryptionConfiguration
+	detail, err := EncryptionConfig(clientset, "kube-system", "secrets", time.Second, &config)(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "secrets providers: kms key-2, kms key-1, identity", detail)
+	assert.Equal(t, []string{"/var/run/kmsplugin/socket.sock"}, KMSSockets(config, "secrets"))
+	assert.Empty(t, KMSSockets(config, "configmaps"))
+
+	_, err = EncryptionConfig(clientset, "kube-system", "configmaps", time.Second, &config)(context.Background())
+	assert.ErrorContains(t, err, "no provider for configmaps")
+	_, err = EncryptionConfig(clientset, "default", "secrets", time.Second, &config)(context.Background())
+	assert.ErrorContains(t, err, "encryption configuration not found")
+}
+
+// healthyPlugin answers Status as a healthy KMS v2 plugin
+type healthyPlugin struct {
+	kmsapi.UnimplementedKeyManagementServiceServer
+}
+
+func (*healthyPlugin) Status(context.Context, *kmsapi.StatusRequest) (*kmsapi.StatusResponse, error) {
+	return &kmsapi.StatusResponse{Version: "v2", Healthz: "ok", KeyId: "key-2"}, nil
+}
+
+func TestKMSPlugins(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "healthy.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	kmsapi.RegisterKeyManagementServiceServer(server, &healthyPlugin{})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	checker, err := kmsplugin.NewChecker(kmsplugin.Config{Sockets: []string{socket}})
+	require.NoError(t, err)
+	detail, err := KMSPlugins(checker)(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, socket+": key key-2", detail)
+
+	missing := filepath.Join(dir, "missing.sock")
+	checker, err = kmsplugin.NewChecker(kmsplugin.Config{Sockets: []string{socket, missing}})
+	require.NoError(t, err)
+	_, err = KMSPlugins(checker)(context.Background())
+	assert.ErrorContains(t, err, "unhealthy KMS plugins: "+missing+": ")
+}
+
+func TestReportWritable(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	detail, err := ReportWritable(clientset, "kube-system", "kms-reporter", time.Second)(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ConfigMap kube-system/kms-reporter can be created", detail)
+
+	clientset = fake.NewSimpleClientset(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kms-reporter", Namespace: "kube-system"}})
+	detail, err = ReportWritable(clientset, "kube-system", "kms-reporter", time.Second)(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ConfigMap kube-system/kms-reporter can be updated", detail)
+
+	clientset.PrependReactor("update", "configmaps", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, "kms-reporter", errors.New("denied"))
+	})
+	_, err = ReportWritable(clientset, "kube-system", "kms-reporter", time.Second)(context.Background())
+	assert.ErrorContains(t, err, "failed to update ConfigMap kube-system/kms-reporter")
+	assert.True(t, apierrors.IsForbidden(errors.Unwrap(err)))
+}
@@ -0,0 +1,85 @@
+// Package doctor diagnoses the environment of the reporter: it runs a series of checks, e.g. that etcd
+// can be reached or that the report can be written, and prints whether each passed, so that a broken
+// deployment can be fixed without going through the logs of a failed run.
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	StatusPass Status = "PASS"
+	StatusFail Status = "FAIL"
+	// StatusSkip is the status of a check that does not apply, e.g. with a disabled feature.
+	StatusSkip Status = "SKIP"
+)
+
+// ErrSkipped is wrapped by the error of a check that does not apply.
+var ErrSkipped = errors.New("skipped")
+
+// CheckFunc runs a check, returning details on what was checked, or why the check failed.
+type CheckFunc func(ctx context.Context) (string, error)
+
+// Result is the outcome of a check.
+type Result struct {
+	Name   string
+	Status Status
+	Detail string
+}
+
+// Diagnosis runs checks one after the other and collects their results. The zero value is ready to use.
+type Diagnosis struct {
+	results []Result
+}
+
+// Check runs check and records its result under name, returning whether it passed. A check whose error
+// wraps ErrSkipped is skipped rather than failed.
+func (d *Diagnosis) Check(ctx context.Context, name string, check CheckFunc) bool {
+	detail, err := check(ctx)
+	result := Result{Name: name, Status: StatusPass, Detail: detail}
+	switch {
+	case errors.Is(err, ErrSkipped):
+		result.Status, result.Detail = StatusSkip, err.Error()
+	case err != nil:
+		result.Status, result.Detail = StatusFail, err.Error()
+	}
+	d.results = append(d.results, result)
+	return result.Status == StatusPass
+}
+
+// Skip records the check name as skipped for reason, e.g. because a check it depends on failed.
+func (d *Diagnosis) Skip(name, reason string) {
+	d.results = append(d.results, Result{Name: name, Status: StatusSkip, Detail: reason})
+}
+
+// Results returns the results of the checks, in the order they ran.
+func (d *Diagnosis) Results() []Result {
+	return d.results
+}
+
+// Failed reports whether a check failed.
+func (d *Diagnosis) Failed() bool {
+	for _, result := range d.results {
+		if result.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the results as a table to w.
+func (d *Diagnosis) Print(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CHECK\tSTATUS\tDETAIL")
+	for _, result := range d.results {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", result.Name, result.Status, result.Detail)
+	}
+	return table.Flush()
+}
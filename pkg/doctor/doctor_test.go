@@ -0,0 +1,45 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnosis(t *testing.T) {
+	var diagnosis Diagnosis
+	assert.False(t, diagnosis.Failed())
+
+	assert.True(t, diagnosis.Check(context.Background(), "etcd", func(context.Context) (string, error) {
+		return "3 keys", nil
+	}))
+	assert.False(t, diagnosis.Check(context.Background(), "plugins", func(context.Context) (string, error) {
+		return "", fmt.Errorf("%w: no KMS plugin", ErrSkipped)
+	}))
+	assert.False(t, diagnosis.Failed())
+	assert.False(t, diagnosis.Check(context.Background(), "report", func(context.Context) (string, error) {
+		return "ignored", errors.New("forbidden")
+	}))
+	diagnosis.Skip("history", "report failed")
+	assert.True(t, diagnosis.Failed())
+	assert.Equal(t, []Result{
+		{Name: "etcd", Status: StatusPass, Detail: "3 keys"},
+		{Name: "plugins", Status: StatusSkip, Detail: "skipped: no KMS plugin"},
+		{Name: "report", Status: StatusFail, Detail: "forbidden"},
+		{Name: "history", Status: StatusSkip, Detail: "report failed"},
+	}, diagnosis.Results())
+
+	var out bytes.Buffer
+	require.NoError(t, diagnosis.Print(&out))
+	assert.Equal(t, `CHECK    STATUS  DETAIL
+etcd     PASS    3 keys
+plugins  SKIP    skipped: no KMS plugin
+report   FAIL    forbidden
+history  SKIP    report failed
+`, out.String())
+}
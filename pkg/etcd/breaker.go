@@ -0,0 +1,117 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+)
+
+// ErrCircuitOpen is returned instead of sending a request while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("etcd circuit breaker open")
+
+// BreakerConfig configures a circuit breaker. A zero Failures disables it.
+type BreakerConfig struct {
+	// Failures is the number of failed requests within Window that opens the circuit.
+	Failures int
+	// Window is the period failures are counted over.
+	Window time.Duration
+	// CoolDown is how long the circuit stays open before a request is let through again.
+	CoolDown time.Duration
+}
+
+// Enabled reports whether the breaker is enabled.
+func (c BreakerConfig) Enabled() bool {
+	return c.Failures > 0
+}
+
+// BreakerClient is a circuit breaker around a client. After Failures failed requests within Window,
+// the circuit opens and requests fail immediately with ErrCircuitOpen for CoolDown, instead of
+// adding load to a struggling etcd. The first request after the cool-down is let through: the
+// circuit closes if it succeeds and opens again if it fails.
+type BreakerClient struct {
+	EtcdClientOperator
+	config BreakerConfig
+	now    func() time.Time
+
+	mu        sync.Mutex
+	failures  []time.Time
+	openUntil time.Time
+	// halfOpen is set once the cool-down of an open circuit elapsed, until a request succeeds
+	halfOpen bool
+}
+
+// NewBreakerClient returns client wrapped in a circuit breaker.
+func NewBreakerClient(client EtcdClientOperator, config BreakerConfig) *BreakerClient {
+	return &BreakerClient{EtcdClientOperator: client, config: config, now: time.Now}
+}
+
+// Get fails with ErrCircuitOpen while the circuit is open and reads from the wrapped client otherwise.
+func (b *BreakerClient) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := b.EtcdClientOperator.Get(ctx, key, opts...)
+	// Cancelled requests say nothing about the health of etcd
+	if errors.Is(err, context.Canceled) {
+		return resp, err
+	}
+	b.observe(err)
+	return resp, err
+}
+
+// allow returns ErrCircuitOpen while the circuit is open.
+func (b *BreakerClient) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if now := b.now(); now.Before(b.openUntil) {
+		return fmt.Errorf("%w until %s", ErrCircuitOpen, b.openUntil.Format(time.RFC3339))
+	}
+	b.halfOpen = true
+	return nil
+}
+
+// observe records the outcome of a request, opening or closing the circuit.
+func (b *BreakerClient) observe(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+
+	if err == nil {
+		if b.halfOpen {
+			klog.InfoS("etcd circuit breaker closed")
+			metrics.EtcdCircuitOpen.Set(0)
+		}
+		b.halfOpen = false
+		b.openUntil = time.Time{}
+		b.failures = b.failures[:0]
+		return
+	}
+
+	// Drop the failures that left the window
+	kept := b.failures[:0]
+	for _, failedAt := range b.failures {
+		if now.Sub(failedAt) < b.config.Window {
+			kept = append(kept, failedAt)
+		}
+	}
+	b.failures = append(kept, now)
+	if !b.halfOpen && len(b.failures) < b.config.Failures {
+		return
+	}
+
+	b.halfOpen = false
+	b.failures = b.failures[:0]
+	b.openUntil = now.Add(b.config.CoolDown)
+	metrics.EtcdCircuitOpen.Set(1)
+	klog.ErrorS(err, "etcd circuit breaker opened, skipping etcd requests", "until", b.openUntil)
+}
@@ -0,0 +1,80 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+)
+
+// stubClient returns err from every Get and counts the requests
+type stubClient struct {
+	EtcdClientOperator
+	err      error
+	requests int
+}
+
+func (c *stubClient) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	c.requests++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &clientv3.GetResponse{}, nil
+}
+
+func TestBreakerClient_Get(t *testing.T) {
+	stub := &stubClient{err: errors.New("connection refused")}
+	breaker := NewBreakerClient(stub, BreakerConfig{Failures: 3, Window: time.Minute, CoolDown: 10 * time.Minute})
+	now := time.Date(2025, 1, 7, 10, 0, 0, 0, time.UTC)
+	breaker.now = func() time.Time { return now }
+	get := func() error {
+		_, err := breaker.Get(context.Background(), "/registry/secrets")
+		return err
+	}
+
+	// Failures outside the window do not add up
+	assert.Error(t, get())
+	now = now.Add(2 * time.Minute)
+	assert.Error(t, get())
+	assert.Error(t, get())
+	assert.Equal(t, 3, stub.requests)
+	assert.NotErrorIs(t, get(), ErrCircuitOpen, "the third failure within the window is still sent")
+	assert.Equal(t, 4, stub.requests)
+
+	// The circuit is open: requests are skipped
+	assert.ErrorIs(t, get(), ErrCircuitOpen)
+	assert.Equal(t, 4, stub.requests)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.EtcdCircuitOpen))
+
+	// After the cool-down a single failure opens the circuit again
+	now = now.Add(10 * time.Minute)
+	assert.NotErrorIs(t, get(), ErrCircuitOpen)
+	assert.Equal(t, 5, stub.requests)
+	assert.ErrorIs(t, get(), ErrCircuitOpen)
+
+	// A success after the cool-down closes it
+	now = now.Add(10 * time.Minute)
+	stub.err = nil
+	assert.NoError(t, get())
+	assert.NoError(t, get())
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.EtcdCircuitOpen))
+}
+
+func TestBreakerClient_Get_Cancelled(t *testing.T) {
+	stub := &stubClient{err: context.Canceled}
+	breaker := NewBreakerClient(stub, BreakerConfig{Failures: 1, Window: time.Minute, CoolDown: time.Minute})
+
+	// Cancelled requests do not count as failures
+	for i := 0; i < 2; i++ {
+		_, err := breaker.Get(context.Background(), "/registry/secrets")
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+	assert.Equal(t, 2, stub.requests)
+	assert.False(t, BreakerConfig{}.Enabled())
+}
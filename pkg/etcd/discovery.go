@@ -0,0 +1,179 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+)
+
+// DiscoveryMode selects where the etcd connection details are discovered from.
+type DiscoveryMode string
+
+const (
+	// DiscoveryNone disables discovery; the connection details come from flags only.
+	DiscoveryNone DiscoveryMode = ""
+	// DiscoveryAPIServer reads the etcd flags of a kube-apiserver static pod.
+	DiscoveryAPIServer DiscoveryMode = "apiserver"
+	// DiscoveryKubeadm uses the file locations of a kubeadm control plane.
+	DiscoveryKubeadm DiscoveryMode = "kubeadm"
+	// DiscoveryAuto tries DiscoveryAPIServer, then DiscoveryKubeadm.
+	DiscoveryAuto DiscoveryMode = "auto"
+
+	// KubeadmPKIDir is the directory kubeadm stores the control plane certificates in.
+	KubeadmPKIDir = "/etc/kubernetes/pki"
+	// kubeadmEndpoint is the etcd endpoint of a stacked kubeadm control plane, reachable from the host network.
+	kubeadmEndpoint = "https://127.0.0.1:2379"
+
+	apiServerNamespace = "kube-system"
+	apiServerSelector  = "component=kube-apiserver"
+)
+
+// ErrDiscoveryFailed is returned when the etcd connection details cannot be discovered.
+var ErrDiscoveryFailed = errors.New("etcd discovery failed")
+
+// ParseDiscoveryMode parses the value of the --etcd-discovery flag.
+func ParseDiscoveryMode(value string) (DiscoveryMode, error) {
+	switch mode := DiscoveryMode(value); mode {
+	case DiscoveryNone, DiscoveryAPIServer, DiscoveryKubeadm, DiscoveryAuto:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid etcd discovery mode %q: must be one of %q, %q or %q", value, DiscoveryAPIServer, DiscoveryKubeadm, DiscoveryAuto)
+	}
+}
+
+// ConnectionConfig holds the details needed to connect to etcd.
+type ConnectionConfig struct {
+	Endpoints []string
+	CertFile  string
+	KeyFile   string
+	CAFile    string
+}
+
+// Override returns c with every field that is set in overrides replaced.
+func (c ConnectionConfig) Override(overrides ConnectionConfig) ConnectionConfig {
+	if len(overrides.Endpoints) > 0 {
+		c.Endpoints = overrides.Endpoints
+	}
+	if overrides.CertFile != "" {
+		c.CertFile = overrides.CertFile
+	}
+	if overrides.KeyFile != "" {
+		c.KeyFile = overrides.KeyFile
+	}
+	if overrides.CAFile != "" {
+		c.CAFile = overrides.CAFile
+	}
+	return c
+}
+
+// Discover returns the etcd connection details found with mode. The certificate paths are
+// paths on the control plane node, so the reporter must mount them at the same location.
+func Discover(ctx context.Context, clientset kubernetes.Interface, mode DiscoveryMode) (ConnectionConfig, error) {
+	switch mode {
+	case DiscoveryAPIServer:
+		return DiscoverFromAPIServer(ctx, clientset)
+	case DiscoveryKubeadm:
+		return DiscoverFromKubeadm(KubeadmPKIDir)
+	case DiscoveryAuto:
+		config, err := DiscoverFromAPIServer(ctx, clientset)
+		if err == nil {
+			return config, nil
+		}
+		klog.InfoS("Falling back to kubeadm defaults for etcd discovery", "err", err)
+		kubeadmConfig, kubeadmErr := DiscoverFromKubeadm(KubeadmPKIDir)
+		if kubeadmErr != nil {
+			return ConnectionConfig{}, errors.Join(err, kubeadmErr)
+		}
+		return kubeadmConfig, nil
+	default:
+		return ConnectionConfig{}, fmt.Errorf("%w: discovery is disabled", ErrDiscoveryFailed)
+	}
+}
+
+// DiscoverFromAPIServer reads the --etcd-servers, --etcd-certfile, --etcd-keyfile and --etcd-cafile
+// flags of a kube-apiserver static pod in kube-system.
+func DiscoverFromAPIServer(ctx context.Context, clientset kubernetes.Interface) (ConnectionConfig, error) {
+	pods, err := clientset.CoreV1().Pods(apiServerNamespace).List(ctx, metav1.ListOptions{LabelSelector: apiServerSelector})
+	if err != nil {
+		return ConnectionConfig{}, fmt.Errorf("%w: failed to list kube-apiserver pods: %w", ErrDiscoveryFailed, err)
+	}
+
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			config, ok := parseAPIServerFlags(container)
+			if !ok {
+				continue
+			}
+			klog.InfoS("Discovered etcd from kube-apiserver", "pod", klog.KObj(&pod), "endpoints", config.Endpoints)
+			return config, nil
+		}
+	}
+	return ConnectionConfig{}, fmt.Errorf("%w: no kube-apiserver pod with --etcd-servers found in %s", ErrDiscoveryFailed, apiServerNamespace)
+}
+
+// parseAPIServerFlags extracts the etcd flags from the command line of a kube-apiserver container.
+func parseAPIServerFlags(container v1.Container) (ConnectionConfig, bool) {
+	flags := map[string]string{}
+	args := append(append([]string{}, container.Command...), container.Args...)
+	for i := 0; i < len(args); i++ {
+		name, value, found := strings.Cut(args[i], "=")
+		if !strings.HasPrefix(name, "--etcd-") {
+			continue
+		}
+		// Flags may also be given as "--flag value"
+		if !found && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		flags[name] = value
+	}
+
+	var config ConnectionConfig
+	for _, endpoint := range strings.Split(flags["--etcd-servers"], ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			config.Endpoints = append(config.Endpoints, endpoint)
+		}
+	}
+	config.CertFile = flags["--etcd-certfile"]
+	config.KeyFile = flags["--etcd-keyfile"]
+	config.CAFile = flags["--etcd-cafile"]
+	return config, len(config.Endpoints) > 0
+}
+
+// DiscoverFromKubeadm returns the connection details of a stacked kubeadm control plane whose
+// certificates are in pkiDir. It requires the reporter to run on a control plane node with host networking.
+func DiscoverFromKubeadm(pkiDir string) (ConnectionConfig, error) {
+	config := ConnectionConfig{
+		Endpoints: []string{kubeadmEndpoint},
+		CertFile:  filepath.Join(pkiDir, "apiserver-etcd-client.crt"),
+		KeyFile:   filepath.Join(pkiDir, "apiserver-etcd-client.key"),
+		CAFile:    filepath.Join(pkiDir, "etcd", "ca.crt"),
+	}
+	for _, file := range []string{config.CertFile, config.KeyFile, config.CAFile} {
+		if _, err := os.Stat(file); err != nil {
+			return ConnectionConfig{}, fmt.Errorf("%w: kubeadm certificate not found: %w", ErrDiscoveryFailed, err)
+		}
+	}
+	return config, nil
+}
+
+// DiscoveryRequiredPermissions lists the Kubernetes API access discovery needs in the given mode.
+// DiscoveryAuto needs none, as it falls back to the kubeadm defaults when listing pods is forbidden.
+func DiscoveryRequiredPermissions(mode DiscoveryMode) []rbac.Permission {
+	if mode != DiscoveryAPIServer {
+		return nil
+	}
+	return []rbac.Permission{
+		{Verb: "list", Resource: "pods", Namespace: apiServerNamespace},
+	}
+}
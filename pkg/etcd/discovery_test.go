@@ -0,0 +1,163 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func apiServerPod(name string, command, args []string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "kube-system",
+			Labels:    map[string]string{"component": "kube-apiserver"},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "kube-apiserver", Command: command, Args: args}},
+		},
+	}
+}
+
+func TestParseDiscoveryMode(t *testing.T) {
+	for _, value := range []string{"", "apiserver", "kubeadm", "auto"} {
+		mode, err := ParseDiscoveryMode(value)
+		assert.NoError(t, err)
+		assert.Equal(t, DiscoveryMode(value), mode)
+	}
+
+	_, err := ParseDiscoveryMode("dns")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid etcd discovery mode")
+}
+
+func TestDiscoverFromAPIServer(t *testing.T) {
+	tests := []struct {
+		name          string
+		objects       []runtime.Object
+		listErr       error
+		expected      ConnectionConfig
+		expectedError string
+	}{
+		{
+			name: "flags in command",
+			objects: []runtime.Object{apiServerPod("kube-apiserver-cp1", []string{
+				"kube-apiserver",
+				"--advertise-address=10.0.0.1",
+				"--etcd-servers=https://10.0.0.1:2379,https://10.0.0.2:2379",
+				"--etcd-certfile=/etc/kubernetes/pki/apiserver-etcd-client.crt",
+				"--etcd-keyfile=/etc/kubernetes/pki/apiserver-etcd-client.key",
+				"--etcd-cafile=/etc/kubernetes/pki/etcd/ca.crt",
+			}, nil)},
+			expected: ConnectionConfig{
+				Endpoints: []string{"https://10.0.0.1:2379", "https://10.0.0.2:2379"},
+				CertFile:  "/etc/kubernetes/pki/apiserver-etcd-client.crt",
+				KeyFile:   "/etc/kubernetes/pki/apiserver-etcd-client.key",
+				CAFile:    "/etc/kubernetes/pki/etcd/ca.crt",
+			},
+		},
+		{
+			name: "space-separated flags in args",
+			objects: []runtime.Object{apiServerPod("kube-apiserver-cp1", []string{"kube-apiserver"}, []string{
+				"--etcd-servers", "https://etcd:2379",
+				"--etcd-cafile", "/pki/ca.crt",
+			})},
+			expected: ConnectionConfig{Endpoints: []string{"https://etcd:2379"}, CAFile: "/pki/ca.crt"},
+		},
+		{
+			name:          "no kube-apiserver pod",
+			expectedError: "no kube-apiserver pod with --etcd-servers found",
+		},
+		{
+			name:          "kube-apiserver without etcd flags",
+			objects:       []runtime.Object{apiServerPod("kube-apiserver-cp1", []string{"kube-apiserver", "--secure-port=6443"}, nil)},
+			expectedError: "no kube-apiserver pod with --etcd-servers found",
+		},
+		{
+			name:          "listing pods fails",
+			listErr:       errors.New("forbidden"),
+			expectedError: "failed to list kube-apiserver pods",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(tt.objects...)
+			if tt.listErr != nil {
+				clientset.PrependReactor("list", "pods", func(clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.listErr
+				})
+			}
+
+			config, err := DiscoverFromAPIServer(context.Background(), clientset)
+			if tt.expectedError != "" {
+				assert.ErrorIs(t, err, ErrDiscoveryFailed)
+				assert.Contains(t, err.Error(), tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, config)
+			}
+		})
+	}
+}
+
+func TestDiscoverFromKubeadm(t *testing.T) {
+	pkiDir := t.TempDir()
+	_, err := DiscoverFromKubeadm(pkiDir)
+	assert.ErrorIs(t, err, ErrDiscoveryFailed)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(pkiDir, "etcd"), 0o700))
+	for _, file := range []string{"apiserver-etcd-client.crt", "apiserver-etcd-client.key", "etcd/ca.crt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(pkiDir, file), nil, 0o600))
+	}
+
+	config, err := DiscoverFromKubeadm(pkiDir)
+	assert.NoError(t, err)
+	assert.Equal(t, ConnectionConfig{
+		Endpoints: []string{"https://127.0.0.1:2379"},
+		CertFile:  filepath.Join(pkiDir, "apiserver-etcd-client.crt"),
+		KeyFile:   filepath.Join(pkiDir, "apiserver-etcd-client.key"),
+		CAFile:    filepath.Join(pkiDir, "etcd", "ca.crt"),
+	}, config)
+}
+
+func TestDiscover(t *testing.T) {
+	clientset := fake.NewSimpleClientset(apiServerPod("kube-apiserver-cp1", []string{"kube-apiserver", "--etcd-servers=https://etcd:2379"}, nil))
+
+	config, err := Discover(context.Background(), clientset, DiscoveryAuto)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"https://etcd:2379"}, config.Endpoints)
+
+	_, err = Discover(context.Background(), clientset, DiscoveryNone)
+	assert.ErrorIs(t, err, ErrDiscoveryFailed)
+}
+
+func TestConnectionConfig_Override(t *testing.T) {
+	discovered := ConnectionConfig{Endpoints: []string{"https://etcd:2379"}, CertFile: "a.crt", KeyFile: "a.key", CAFile: "ca.crt"}
+
+	assert.Equal(t, discovered, discovered.Override(ConnectionConfig{}))
+	assert.Equal(t,
+		ConnectionConfig{Endpoints: []string{"https://other:2379"}, CertFile: "a.crt", KeyFile: "a.key", CAFile: "other-ca.crt"},
+		discovered.Override(ConnectionConfig{Endpoints: []string{"https://other:2379"}, CAFile: "other-ca.crt"}))
+}
+
+func TestDiscoveryRequiredPermissions(t *testing.T) {
+	assert.Empty(t, DiscoveryRequiredPermissions(DiscoveryNone))
+	assert.Empty(t, DiscoveryRequiredPermissions(DiscoveryKubeadm))
+	assert.Empty(t, DiscoveryRequiredPermissions(DiscoveryAuto))
+
+	permissions := DiscoveryRequiredPermissions(DiscoveryAPIServer)
+	assert.Len(t, permissions, 1)
+	assert.Equal(t, "pods", permissions[0].Resource)
+	assert.Equal(t, "kube-system", permissions[0].Namespace)
+}
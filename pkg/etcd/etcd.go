@@ -4,46 +4,107 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
+// DefaultDialTimeout bounds establishing the connection to etcd when no dial timeout is given.
+const DefaultDialTimeout = 5 * time.Second
+
+// ErrEtcdUnavailable is returned when etcd cannot be reached or a request to it fails.
+var ErrEtcdUnavailable = errors.New("etcd unavailable")
+
 type EtcdClientOperator interface {
 	Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error)
 	Close() error
 }
 
-func CreateEtcdClient(etcdEndpoint, etcdClientCrt, etcdClientKey, etcdClientCaCrt string) (EtcdClientOperator, error) {
+// ClientOptions tunes the connection to etcd. The zero value uses the defaults.
+type ClientOptions struct {
+	// DialTimeout bounds establishing the connection, not the requests. Defaults to DefaultDialTimeout.
+	DialTimeout time.Duration
+	// TLSMinVersion is the minimum TLS version negotiated with etcd. Defaults to TLS 1.2.
+	TLSMinVersion uint16
+	// CipherSuites restricts the cipher suites negotiated with etcd over TLS 1.2. TLS 1.3 suites are
+	// not configurable and always secure. Defaults to the secure suites of Go.
+	CipherSuites []uint16
+	// InsecureSkipVerify accepts any etcd server certificate, e.g. self-signed or issued for another
+	// host name, and makes the CA certificate optional. Only for disposable test clusters: the
+	// connection is then open to man-in-the-middle attacks.
+	InsecureSkipVerify bool
+	// GRPCMaxRetries is the number of times the gRPC client of etcd retries a read failing with
+	// Unavailable, e.g. during a leader election, before returning the error, within the deadline of
+	// the request. Defaults to that of the etcd client, 100.
+	GRPCMaxRetries uint
+	// GRPCBackoff is the wait between two retries of the gRPC client. It does not grow with the retries.
+	// Defaults to that of the etcd client, 25ms.
+	GRPCBackoff time.Duration
+	// GRPCBackoffJitter randomizes the wait between two retries of the gRPC client by up to this
+	// fraction of it, in [0, 1]. Defaults to that of the etcd client, 0.1.
+	GRPCBackoffJitter float64
+}
+
+// CreateEtcdClient connects to etcdEndpoint, which may be a comma-separated list of endpoints, with TLS client authentication.
+func CreateEtcdClient(etcdEndpoint, etcdClientCrt, etcdClientKey, etcdClientCaCrt string, options ClientOptions) (EtcdClientOperator, error) {
+	minVersion := options.TLSMinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+
 	// Load certificates
 	cert, err := tls.LoadX509KeyPair(etcdClientCrt, etcdClientKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load client certificate and key: %w", err)
 	}
 
-	// Load CA certificate
-	caCert, err := os.ReadFile(etcdClientCaCrt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	// Create TLS configuration
+	tlsConfig := &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		MinVersion:         minVersion,
+		CipherSuites:       options.CipherSuites,
+		InsecureSkipVerify: options.InsecureSkipVerify,
 	}
 
-	caCertPool := x509.NewCertPool()
-	if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-		return nil, fmt.Errorf("failed to append CA certificate to pool")
-	}
+	// Load CA certificate, which is not needed when the server certificate is not verified
+	if etcdClientCaCrt != "" || !options.InsecureSkipVerify {
+		caCert, err := os.ReadFile(etcdClientCaCrt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
 
-	// Create TLS configuration
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      caCertPool,
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+			return nil, fmt.Errorf("failed to append CA certificate to pool")
+		}
+		tlsConfig.RootCAs = caCertPool
 	}
 
 	// Connect to etcd
-	return clientv3.New(clientv3.Config{
-		Endpoints:   []string{etcdEndpoint},
-		DialTimeout: 5 * time.Second,
+	client, err := clientv3.New(clientConfig(etcdEndpoint, tlsConfig, options))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEtcdUnavailable, err)
+	}
+	return client, nil
+}
+
+// clientConfig returns the configuration of the etcd client connecting to etcdEndpoint with tlsConfig.
+func clientConfig(etcdEndpoint string, tlsConfig *tls.Config, options ClientOptions) clientv3.Config {
+	dialTimeout := options.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = DefaultDialTimeout
+	}
+	return clientv3.Config{
+		Endpoints:   strings.Split(etcdEndpoint, ","),
+		DialTimeout: dialTimeout,
 		TLS:         tlsConfig, // Use tls.Config for secure access
-	})
+		// The etcd client uses its defaults for zero values
+		MaxUnaryRetries:       options.GRPCMaxRetries,
+		BackoffWaitBetween:    options.GRPCBackoff,
+		BackoffJitterFraction: options.GRPCBackoffJitter,
+	}
 }
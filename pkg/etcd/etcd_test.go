@@ -144,7 +144,7 @@ func TestCreateEtcdClient_Success(t *testing.T) {
 
 	// Note: This test will fail to connect to etcd since we're not running an etcd server,
 	// but it will validate certificate loading and TLS configuration
-	client, err := CreateEtcdClient("https://localhost:2379", certFile, keyFile, caFile)
+	client, err := CreateEtcdClient("https://localhost:2379", certFile, keyFile, caFile, ClientOptions{})
 
 	// We expect the client creation to succeed (certificate loading should work)
 	// but connection might fail since no etcd server is running
@@ -164,11 +164,31 @@ func TestCreateEtcdClient_Success(t *testing.T) {
 	}
 }
 
+func TestCreateEtcdClient_InsecureSkipVerify(t *testing.T) {
+	certFile, keyFile, _, cleanup := createTempCertFiles(t)
+	defer cleanup()
+
+	// The CA certificate is optional when the server certificate is not verified
+	client, err := CreateEtcdClient("https://localhost:2379", certFile, keyFile, "", ClientOptions{InsecureSkipVerify: true})
+	if err != nil {
+		if !isConnectionError(err) {
+			t.Errorf("Expected connection error, but got certificate error: %v", err)
+		}
+	} else {
+		client.Close()
+	}
+
+	_, err = CreateEtcdClient("https://localhost:2379", certFile, keyFile, "", ClientOptions{})
+	if !containsError(err, "failed to read CA certificate") {
+		t.Errorf("Expected CA certificate error when verifying the server, got: %v", err)
+	}
+}
+
 func TestCreateEtcdClient_InvalidCertFile(t *testing.T) {
 	_, keyFile, caFile, cleanup := createTempCertFiles(t)
 	defer cleanup()
 
-	_, err := CreateEtcdClient("https://localhost:2379", "nonexistent.pem", keyFile, caFile)
+	_, err := CreateEtcdClient("https://localhost:2379", "nonexistent.pem", keyFile, caFile, ClientOptions{})
 	if err == nil {
 		t.Error("Expected error for invalid certificate file")
 	}
@@ -181,7 +201,7 @@ func TestCreateEtcdClient_InvalidKeyFile(t *testing.T) {
 	certFile, _, caFile, cleanup := createTempCertFiles(t)
 	defer cleanup()
 
-	_, err := CreateEtcdClient("https://localhost:2379", certFile, "nonexistent.pem", caFile)
+	_, err := CreateEtcdClient("https://localhost:2379", certFile, "nonexistent.pem", caFile, ClientOptions{})
 	if err == nil {
 		t.Error("Expected error for invalid key file")
 	}
@@ -194,7 +214,7 @@ func TestCreateEtcdClient_InvalidCAFile(t *testing.T) {
 	certFile, keyFile, _, cleanup := createTempCertFiles(t)
 	defer cleanup()
 
-	_, err := CreateEtcdClient("https://localhost:2379", certFile, keyFile, "nonexistent.pem")
+	_, err := CreateEtcdClient("https://localhost:2379", certFile, keyFile, "nonexistent.pem", ClientOptions{})
 	if err == nil {
 		t.Error("Expected error for invalid CA file")
 	}
@@ -211,7 +231,7 @@ func TestCreateEtcdClient_InvalidCACertContent(t *testing.T) {
 	invalidCAFile := createTempFile(t, "invalid-ca", []byte("invalid certificate content"))
 	defer os.Remove(invalidCAFile)
 
-	_, err := CreateEtcdClient("https://localhost:2379", certFile, keyFile, invalidCAFile)
+	_, err := CreateEtcdClient("https://localhost:2379", certFile, keyFile, invalidCAFile, ClientOptions{})
 	if err == nil {
 		t.Error("Expected error for invalid CA certificate content")
 	}
@@ -224,7 +244,7 @@ func TestCreateEtcdClient_EmptyEndpoint(t *testing.T) {
 	certFile, keyFile, caFile, cleanup := createTempCertFiles(t)
 	defer cleanup()
 
-	client, err := CreateEtcdClient("", certFile, keyFile, caFile)
+	client, err := CreateEtcdClient("", certFile, keyFile, caFile, ClientOptions{})
 	// The function should still create a client even with empty endpoint
 	// The actual connection error will happen when trying to use the client
 	if err != nil && !isConnectionError(err) {
@@ -243,7 +263,7 @@ func TestCreateEtcdClient_MismatchedCertAndKey(t *testing.T) {
 	defer cleanup2()
 
 	// Use cert from first generation with key from second generation
-	_, err := CreateEtcdClient("https://localhost:2379", certFile1, keyFile2, caFile)
+	_, err := CreateEtcdClient("https://localhost:2379", certFile1, keyFile2, caFile, ClientOptions{})
 	if err == nil {
 		t.Error("Expected error for mismatched certificate and key")
 	}
@@ -252,6 +272,25 @@ func TestCreateEtcdClient_MismatchedCertAndKey(t *testing.T) {
 	}
 }
 
+func TestClientConfig(t *testing.T) {
+	config := clientConfig("https://etcd-0:2379,https://etcd-1:2379", nil, ClientOptions{})
+	if config.DialTimeout != DefaultDialTimeout {
+		t.Errorf("Expected the default dial timeout, got %v", config.DialTimeout)
+	}
+	if len(config.Endpoints) != 2 {
+		t.Errorf("Expected 2 endpoints, got %v", config.Endpoints)
+	}
+	// Zero values leave the retry policy of the etcd client unchanged
+	if config.MaxUnaryRetries != 0 || config.BackoffWaitBetween != 0 || config.BackoffJitterFraction != 0 {
+		t.Errorf("Expected the default gRPC retry policy, got %+v", config)
+	}
+
+	config = clientConfig("https://etcd-0:2379", nil, ClientOptions{GRPCMaxRetries: 5, GRPCBackoff: time.Second, GRPCBackoffJitter: 0.5})
+	if config.MaxUnaryRetries != 5 || config.BackoffWaitBetween != time.Second || config.BackoffJitterFraction != 0.5 {
+		t.Errorf("Expected the gRPC retry policy of the options, got %+v", config)
+	}
+}
+
 // Helper function to check if error contains expected text
 func containsError(err error, expectedText string) bool {
 	if err == nil {
@@ -295,7 +334,7 @@ func BenchmarkCreateEtcdClient(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		client, err := CreateEtcdClient("https://localhost:2379", certFile, keyFile, caFile)
+		client, err := CreateEtcdClient("https://localhost:2379", certFile, keyFile, caFile, ClientOptions{})
 		if err != nil && !isConnectionError(err) {
 			b.Fatalf("Unexpected error: %v", err)
 		}
@@ -0,0 +1,92 @@
+package etcd
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"sigs.k8s.io/yaml"
+)
+
+// ErrInvalidFixture is returned when a fixture file cannot be parsed.
+var ErrInvalidFixture = errors.New("invalid etcd fixture")
+
+// Fixture is a set of etcd key-value pairs stored in a JSON or YAML file. Keys and values are plain
+// strings unless Base64 is set. The output of `etcdctl get --prefix -w json`, which has a header
+// and base64-encoded keys and values, is a fixture as well.
+type Fixture struct {
+	// Header is set in etcdctl output, whose keys and values are always base64-encoded.
+	Header *FixtureHeader `json:"header,omitempty"`
+	// Base64 marks keys and values as base64-encoded, e.g. to store binary values.
+	Base64 bool              `json:"base64,omitempty"`
+	KVs    []FixtureKeyValue `json:"kvs"`
+}
+
+// FixtureHeader is the response header of etcdctl output.
+type FixtureHeader struct {
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// FixtureKeyValue is a key-value pair of a fixture. Revisions default to the position of the pair in the fixture, starting at 1.
+type FixtureKeyValue struct {
+	Key            string `json:"key"`
+	Value          string `json:"value"`
+	CreateRevision int64  `json:"create_revision,omitempty"`
+	ModRevision    int64  `json:"mod_revision,omitempty"`
+	Version        int64  `json:"version,omitempty"`
+}
+
+// ParseFixture parses a JSON or YAML fixture into key-value pairs.
+func ParseFixture(data []byte) ([]*mvccpb.KeyValue, error) {
+	var fixture Fixture
+	if err := yaml.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFixture, err)
+	}
+	encoded := fixture.Base64 || fixture.Header != nil
+
+	kvs := make([]*mvccpb.KeyValue, 0, len(fixture.KVs))
+	for i, fkv := range fixture.KVs {
+		key, value := []byte(fkv.Key), []byte(fkv.Value)
+		if encoded {
+			var err error
+			if key, err = base64.StdEncoding.DecodeString(fkv.Key); err != nil {
+				return nil, fmt.Errorf("%w: key %d is not base64-encoded: %w", ErrInvalidFixture, i, err)
+			}
+			if value, err = base64.StdEncoding.DecodeString(fkv.Value); err != nil {
+				return nil, fmt.Errorf("%w: value of %s is not base64-encoded: %w", ErrInvalidFixture, key, err)
+			}
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("%w: key %d is empty", ErrInvalidFixture, i)
+		}
+
+		kv := &mvccpb.KeyValue{Key: key, Value: value, CreateRevision: fkv.CreateRevision, ModRevision: fkv.ModRevision, Version: fkv.Version}
+		if kv.ModRevision == 0 {
+			kv.ModRevision = int64(i + 1)
+		}
+		if kv.CreateRevision == 0 {
+			kv.CreateRevision = kv.ModRevision
+		}
+		if kv.Version == 0 {
+			kv.Version = 1
+		}
+		kvs = append(kvs, kv)
+	}
+	return kvs, nil
+}
+
+// NewFixtureClient returns a client serving the key-value pairs of the fixture file at path, for
+// offline analysis of exported data.
+func NewFixtureClient(path string) (*MemoryClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read etcd fixture: %w", err)
+	}
+	kvs, err := ParseFixture(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse etcd fixture %s: %w", path, err)
+	}
+	return NewMemoryClient(kvs), nil
+}
@@ -0,0 +1,100 @@
+package etcd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestParseFixture(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		expectedKeys  []string
+		expectedValue string
+		expectedError string
+	}{
+		{
+			name: "yaml",
+			data: `
+kvs:
+- key: /registry/secrets/default/a
+  value: "k8s:enc:kms:v2:kmsprovider1:data"
+- key: /registry/secrets/default/b
+  value: plain
+  mod_revision: 10
+`,
+			expectedKeys:  []string{"/registry/secrets/default/a", "/registry/secrets/default/b"},
+			expectedValue: "k8s:enc:kms:v2:kmsprovider1:data",
+		},
+		{
+			name:          "etcdctl json output",
+			data:          `{"header":{"revision":12},"kvs":[{"key":"L3JlZ2lzdHJ5L3NlY3JldHMvZGVmYXVsdC9h","create_revision":3,"mod_revision":5,"version":2,"value":"azhzOmVuYzprbXM6djI6a21zcHJvdmlkZXIxOmRhdGE="}],"count":1}`,
+			expectedKeys:  []string{"/registry/secrets/default/a"},
+			expectedValue: "k8s:enc:kms:v2:kmsprovider1:data",
+		},
+		{
+			name:          "invalid base64",
+			data:          `{"base64":true,"kvs":[{"key":"not base64!","value":""}]}`,
+			expectedError: "key 0 is not base64-encoded",
+		},
+		{
+			name:          "empty key",
+			data:          `{"kvs":[{"value":"plain"}]}`,
+			expectedError: "key 0 is empty",
+		},
+		{
+			name:          "not a fixture",
+			data:          `kvs: 3`,
+			expectedError: "invalid etcd fixture",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kvs, err := ParseFixture([]byte(tt.data))
+			if tt.expectedError != "" {
+				assert.ErrorIs(t, err, ErrInvalidFixture)
+				assert.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			var keys []string
+			for _, kv := range kvs {
+				keys = append(keys, string(kv.Key))
+			}
+			assert.Equal(t, tt.expectedKeys, keys)
+			assert.Equal(t, tt.expectedValue, string(kvs[0].Value))
+		})
+	}
+}
+
+func TestParseFixture_Revisions(t *testing.T) {
+	kvs, err := ParseFixture([]byte(`{"kvs":[{"key":"/a","value":"1"},{"key":"/b","value":"2","mod_revision":10,"create_revision":4}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), kvs[0].ModRevision)
+	assert.Equal(t, int64(1), kvs[0].CreateRevision)
+	assert.Equal(t, int64(1), kvs[0].Version)
+	assert.Equal(t, int64(10), kvs[1].ModRevision)
+	assert.Equal(t, int64(4), kvs[1].CreateRevision)
+}
+
+func TestNewFixtureClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixture.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("kvs:\n- key: /registry/secrets/default/a\n  value: plain\n"), 0o600))
+
+	client, err := NewFixtureClient(path)
+	require.NoError(t, err)
+	resp, err := client.Get(context.Background(), "/registry/secrets", clientv3.WithPrefix())
+	assert.NoError(t, err)
+	assert.Len(t, resp.Kvs, 1)
+	assert.NoError(t, client.Close())
+
+	_, err = NewFixtureClient(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(t, err, "failed to read etcd fixture")
+}
@@ -0,0 +1,162 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// DefaultMaxMemberLag is the number of revisions a member may be behind the most recent member
+// before it is flagged as lagging.
+const DefaultMaxMemberLag = 1000
+
+// DefaultMemberRequestTimeout bounds the read of each member when no request timeout is given. Members
+// only count keys, so this is short even for large prefixes.
+const DefaultMemberRequestTimeout = 5 * time.Second
+
+// Member is an etcd member read on its own, with a client connected to its endpoint only.
+type Member struct {
+	Endpoint string
+	Client   EtcdClientOperator
+}
+
+// MemberStatus is the view one member has of the scanned prefix.
+type MemberStatus struct {
+	Endpoint string `json:"endpoint"`
+	// Revision is the revision the member answered at.
+	Revision int64 `json:"revision,omitempty"`
+	// Keys is the number of keys of the prefix on the member.
+	Keys int64 `json:"keys"`
+	// Lag is the number of revisions the member is behind the most recent member.
+	Lag int64 `json:"lag"`
+	// Lagging is set when Lag exceeds the maximum lag.
+	Lagging bool `json:"lagging,omitempty"`
+	// Error is why the member could not be read, in which case the other fields are not set.
+	Error string `json:"error,omitempty"`
+}
+
+// MemberComparerConfig configures the comparison of the members.
+type MemberComparerConfig struct {
+	// MaxLag is the number of revisions a member may be behind the most recent member. Defaults to
+	// DefaultMaxMemberLag.
+	MaxLag int64
+	// RequestTimeout bounds the read of each member. Defaults to DefaultMemberRequestTimeout.
+	RequestTimeout time.Duration
+}
+
+// MemberComparer compares the view every etcd member has of a prefix, to detect the members lagging
+// behind the others: a scan served by a lagging follower reports stale data.
+type MemberComparer struct {
+	members []Member
+	config  MemberComparerConfig
+}
+
+func NewMemberComparer(members []Member, config MemberComparerConfig) (*MemberComparer, error) {
+	config, err := config.complete(len(members))
+	if err != nil {
+		return nil, err
+	}
+	return &MemberComparer{members: members, config: config}, nil
+}
+
+// ConnectMembers connects to every endpoint separately, with the options of CreateEtcdClient, and returns
+// the comparer of the members.
+func ConnectMembers(endpoints []string, etcdClientCrt, etcdClientKey, etcdClientCaCrt string, options ClientOptions, config MemberComparerConfig) (*MemberComparer, error) {
+	config, err := config.complete(len(endpoints))
+	if err != nil {
+		return nil, err
+	}
+	members := make([]Member, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		client, err := CreateEtcdClient(endpoint, etcdClientCrt, etcdClientKey, etcdClientCaCrt, options)
+		if err != nil {
+			if closeErr := closeMembers(members); closeErr != nil {
+				klog.ErrorS(closeErr, "Failed to close etcd member clients")
+			}
+			return nil, fmt.Errorf("failed to connect to etcd member %s: %w", endpoint, err)
+		}
+		members = append(members, Member{Endpoint: endpoint, Client: client})
+	}
+	return &MemberComparer{members: members, config: config}, nil
+}
+
+// complete validates the configuration of the comparison of count members and sets the defaults.
+func (c MemberComparerConfig) complete(count int) (MemberComparerConfig, error) {
+	if count < 2 {
+		return c, fmt.Errorf("comparing etcd members requires at least 2 endpoints, got %d", count)
+	}
+	if c.MaxLag < 0 {
+		return c, fmt.Errorf("maximum member lag must not be negative, got %d", c.MaxLag)
+	}
+	if c.MaxLag == 0 {
+		c.MaxLag = DefaultMaxMemberLag
+	}
+	if c.RequestTimeout == 0 {
+		c.RequestTimeout = DefaultMemberRequestTimeout
+	}
+	return c, nil
+}
+
+// Compare counts the keys of prefix on every member with serializable reads, which each member answers
+// from its own data without going through the leader, and returns the status of every member in the
+// order of the members. A member that cannot be read does not prevent the others from being compared.
+func (c *MemberComparer) Compare(ctx context.Context, prefix string) []MemberStatus {
+	statuses := make([]MemberStatus, len(c.members))
+	var wg sync.WaitGroup
+	for i, member := range c.members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = c.read(ctx, member, prefix)
+		}()
+	}
+	wg.Wait()
+
+	var latest int64
+	for _, status := range statuses {
+		if status.Error == "" {
+			latest = max(latest, status.Revision)
+		}
+	}
+	for i := range statuses {
+		if statuses[i].Error != "" {
+			continue
+		}
+		statuses[i].Lag = latest - statuses[i].Revision
+		statuses[i].Lagging = statuses[i].Lag > c.config.MaxLag
+	}
+	return statuses
+}
+
+// read counts the keys of prefix on member.
+func (c *MemberComparer) read(ctx context.Context, member Member, prefix string) MemberStatus {
+	readCtx, cancel := utils.ContextWithTimeout(ctx, c.config.RequestTimeout)
+	defer cancel()
+	response, err := member.Client.Get(readCtx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly(), clientv3.WithSerializable())
+	if err != nil {
+		return MemberStatus{Endpoint: member.Endpoint, Error: err.Error()}
+	}
+	return MemberStatus{Endpoint: member.Endpoint, Revision: response.Header.Revision, Keys: response.Count}
+}
+
+// Close closes the clients of the members.
+func (c *MemberComparer) Close() error {
+	return closeMembers(c.members)
+}
+
+func closeMembers(members []Member) error {
+	var errs []error
+	for _, member := range members {
+		if err := member.Client.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close client of etcd member %s: %w", member.Endpoint, err))
+		}
+	}
+	return errors.Join(errs...)
+}
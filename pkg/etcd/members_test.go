@@ -0,0 +1,83 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// memberClient answers count-only serializable reads at a fixed revision
+type memberClient struct {
+	EtcdClientOperator
+	t        *testing.T
+	revision int64
+	keys     int64
+	err      error
+	closed   bool
+}
+
+func (c *memberClient) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	op := clientv3.OpGet(key, opts...)
+	assert.True(c.t, op.IsSerializable(), "members must answer from their own data")
+	assert.True(c.t, op.IsCountOnly())
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: c.revision}, Count: c.keys}, nil
+}
+
+func (c *memberClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestNewMemberComparer(t *testing.T) {
+	_, err := NewMemberComparer([]Member{{Endpoint: "https://10.0.0.1:2379"}}, MemberComparerConfig{})
+	assert.ErrorContains(t, err, "at least 2 endpoints")
+	_, err = ConnectMembers([]string{"https://10.0.0.1:2379"}, "client.crt", "client.key", "ca.crt", ClientOptions{}, MemberComparerConfig{})
+	assert.ErrorContains(t, err, "at least 2 endpoints")
+
+	members := []Member{{Endpoint: "https://10.0.0.1:2379"}, {Endpoint: "https://10.0.0.2:2379"}}
+	_, err = NewMemberComparer(members, MemberComparerConfig{MaxLag: -1})
+	assert.ErrorContains(t, err, "must not be negative")
+
+	comparer, err := NewMemberComparer(members, MemberComparerConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(DefaultMaxMemberLag), comparer.config.MaxLag)
+	assert.Equal(t, DefaultMemberRequestTimeout, comparer.config.RequestTimeout)
+}
+
+func TestMemberComparer_Compare(t *testing.T) {
+	clients := []*memberClient{
+		{t: t, revision: 5000, keys: 120},
+		{t: t, revision: 4950, keys: 119},
+		{t: t, revision: 2000, keys: 80},
+		{t: t, err: errors.New("context deadline exceeded")},
+	}
+	members := []Member{
+		{Endpoint: "https://10.0.0.1:2379", Client: clients[0]},
+		{Endpoint: "https://10.0.0.2:2379", Client: clients[1]},
+		{Endpoint: "https://10.0.0.3:2379", Client: clients[2]},
+		{Endpoint: "https://10.0.0.4:2379", Client: clients[3]},
+	}
+	comparer, err := NewMemberComparer(members, MemberComparerConfig{MaxLag: 100})
+	require.NoError(t, err)
+
+	statuses := comparer.Compare(context.Background(), "/registry/secrets/")
+	assert.Equal(t, []MemberStatus{
+		{Endpoint: "https://10.0.0.1:2379", Revision: 5000, Keys: 120},
+		{Endpoint: "https://10.0.0.2:2379", Revision: 4950, Keys: 119, Lag: 50},
+		{Endpoint: "https://10.0.0.3:2379", Revision: 2000, Keys: 80, Lag: 3000, Lagging: true},
+		{Endpoint: "https://10.0.0.4:2379", Error: "context deadline exceeded"},
+	}, statuses)
+
+	require.NoError(t, comparer.Close())
+	for _, client := range clients {
+		assert.True(t, client.closed)
+	}
+}
@@ -0,0 +1,93 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// MemoryClient is an in-memory etcd key space implementing EtcdClientOperator, with the range, limit,
+// count-only, keys-only, minimum mod revision and minimum create revision options the analyzer uses. Like
+// etcd, its count is that of the whole range, whatever the revision filters and the limit. It keeps no
+// history: every request is served from the latest revision, and older revisions are accepted.
+type MemoryClient struct {
+	mu       sync.RWMutex
+	kvs      []*mvccpb.KeyValue
+	revision int64
+}
+
+// NewMemoryClient returns a client serving kvs. Its revision is the highest ModRevision of kvs.
+func NewMemoryClient(kvs []*mvccpb.KeyValue) *MemoryClient {
+	sorted := append([]*mvccpb.KeyValue{}, kvs...)
+	sort.Slice(sorted, func(i, j int) bool { return string(sorted[i].Key) < string(sorted[j].Key) })
+
+	client := &MemoryClient{kvs: sorted, revision: 1}
+	for _, kv := range sorted {
+		client.revision = max(client.revision, kv.ModRevision)
+	}
+	return client
+}
+
+// Get reads key, or the range starting at key when clientv3.WithRange is given.
+func (c *MemoryClient) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	op := clientv3.OpGet(key, opts...)
+	if op.Rev() > c.revision {
+		return nil, fmt.Errorf("required revision %d is a future revision", op.Rev())
+	}
+
+	end := string(op.RangeBytes())
+	resp := &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: c.revision}}
+	start := sort.Search(len(c.kvs), func(i int) bool { return string(c.kvs[i].Key) >= key })
+	for _, kv := range c.kvs[start:] {
+		if end == "" && string(kv.Key) != key {
+			break
+		}
+		// "\x00" is the range end of every key from key on
+		if end != "" && end != "\x00" && string(kv.Key) >= end {
+			break
+		}
+		resp.Count++
+		if op.IsCountOnly() || kv.ModRevision < op.MinModRev() || kv.CreateRevision < op.MinCreateRev() {
+			continue
+		}
+		if op.Limit() > 0 && int64(len(resp.Kvs)) == op.Limit() {
+			resp.More = true
+			continue
+		}
+		if op.IsKeysOnly() {
+			kv = &mvccpb.KeyValue{Key: kv.Key, CreateRevision: kv.CreateRevision, ModRevision: kv.ModRevision, Version: kv.Version}
+		}
+		resp.Kvs = append(resp.Kvs, kv)
+	}
+	return resp, nil
+}
+
+// Put writes value to key at the next revision.
+func (c *MemoryClient) Put(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revision++
+	kv := &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), CreateRevision: c.revision, ModRevision: c.revision, Version: 1}
+	i := sort.Search(len(c.kvs), func(i int) bool { return string(c.kvs[i].Key) >= key })
+	if i < len(c.kvs) && string(c.kvs[i].Key) == key {
+		kv.CreateRevision, kv.Version = c.kvs[i].CreateRevision, c.kvs[i].Version+1
+		c.kvs[i] = kv
+		return
+	}
+	c.kvs = append(c.kvs[:i], append([]*mvccpb.KeyValue{kv}, c.kvs[i:]...)...)
+}
+
+// Close does nothing; it implements EtcdClientOperator.
+func (c *MemoryClient) Close() error {
+	return nil
+}
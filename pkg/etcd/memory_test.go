@@ -0,0 +1,83 @@
+package etcd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestMemoryClient_Get(t *testing.T) {
+	client := NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/p/c"), Value: []byte("3"), CreateRevision: 7, ModRevision: 7},
+		{Key: []byte("/p/a"), Value: []byte("1"), ModRevision: 3},
+		{Key: []byte("/p/b"), Value: []byte("2"), ModRevision: 5},
+		{Key: []byte("/q/a"), Value: []byte("4"), ModRevision: 2},
+	})
+	keys := func(resp *clientv3.GetResponse) []string {
+		var keys []string
+		for _, kv := range resp.Kvs {
+			keys = append(keys, string(kv.Key))
+		}
+		return keys
+	}
+	ctx := context.Background()
+
+	resp, err := client.Get(ctx, "/p/b")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/p/b"}, keys(resp))
+	assert.Equal(t, int64(7), resp.Header.Revision)
+
+	resp, err = client.Get(ctx, "/p/", clientv3.WithPrefix(), clientv3.WithLimit(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/p/a", "/p/b"}, keys(resp))
+	assert.True(t, resp.More)
+	assert.Equal(t, int64(3), resp.Count)
+
+	resp, err = client.Get(ctx, "/p/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Kvs)
+	assert.Equal(t, int64(3), resp.Count)
+
+	resp, err = client.Get(ctx, "/p/", clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithMinModRev(5))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/p/b", "/p/c"}, keys(resp))
+	assert.Nil(t, resp.Kvs[0].Value)
+	// The count ignores the revision filters, as etcd's does
+	assert.Equal(t, int64(3), resp.Count)
+
+	resp, err = client.Get(ctx, "/p/", clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithMinCreateRev(6))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/p/c"}, keys(resp))
+	assert.Equal(t, int64(3), resp.Count)
+
+	resp, err = client.Get(ctx, "/p/", clientv3.WithPrefix(), clientv3.WithMinModRev(4), clientv3.WithLimit(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/p/b"}, keys(resp))
+	assert.True(t, resp.More)
+
+	resp, err = client.Get(ctx, "/p/b", clientv3.WithFromKey())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/p/b", "/p/c", "/q/a"}, keys(resp))
+
+	_, err = client.Get(ctx, "/p/", clientv3.WithPrefix(), clientv3.WithRev(8))
+	assert.ErrorContains(t, err, "future revision")
+}
+
+func TestMemoryClient_Put(t *testing.T) {
+	client := NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/p/b"), Value: []byte("2"), CreateRevision: 2, ModRevision: 4, Version: 2},
+	})
+	client.Put("/p/b", "3")
+	client.Put("/p/a", "1")
+
+	resp, err := client.Get(context.Background(), "/p/", clientv3.WithPrefix())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), resp.Header.Revision)
+	assert.Equal(t, []*mvccpb.KeyValue{
+		{Key: []byte("/p/a"), Value: []byte("1"), CreateRevision: 6, ModRevision: 6, Version: 1},
+		{Key: []byte("/p/b"), Value: []byte("3"), CreateRevision: 2, ModRevision: 5, Version: 3},
+	}, resp.Kvs)
+}
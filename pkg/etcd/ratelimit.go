@@ -0,0 +1,71 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig limits the etcd reads of a client. A zero limit disables it.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the maximum rate of range requests.
+	RequestsPerSecond float64
+	// BytesPerSecond is the maximum rate of key and value bytes read.
+	BytesPerSecond int64
+}
+
+// Enabled reports whether any limit is set.
+func (c RateLimitConfig) Enabled() bool {
+	return c.RequestsPerSecond > 0 || c.BytesPerSecond > 0
+}
+
+// RateLimitedClient throttles the range requests of a client with token buckets, so that a scan
+// does not compete with the API server for etcd throughput.
+type RateLimitedClient struct {
+	EtcdClientOperator
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+}
+
+// NewRateLimitedClient returns a client throttling the Get requests of client according to config.
+// Requests wait for a request token before being sent. The size of a response is only known once it
+// is received, so its bytes are waited for afterwards, delaying the next request.
+func NewRateLimitedClient(client EtcdClientOperator, config RateLimitConfig) *RateLimitedClient {
+	limited := &RateLimitedClient{EtcdClientOperator: client}
+	if config.RequestsPerSecond > 0 {
+		limited.requests = rate.NewLimiter(rate.Limit(config.RequestsPerSecond), 1)
+	}
+	if config.BytesPerSecond > 0 {
+		limited.bytes = rate.NewLimiter(rate.Limit(config.BytesPerSecond), int(config.BytesPerSecond))
+	}
+	return limited
+}
+
+// Get waits for the rate limits and reads from the wrapped client.
+func (c *RateLimitedClient) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	if c.requests != nil {
+		if err := c.requests.Wait(ctx); err != nil {
+			return nil, fmt.Errorf("failed to wait for the etcd request rate limit: %w", err)
+		}
+	}
+	resp, err := c.EtcdClientOperator.Get(ctx, key, opts...)
+	if err != nil || c.bytes == nil {
+		return resp, err
+	}
+
+	size := 0
+	for _, kv := range resp.Kvs {
+		size += len(kv.Key) + len(kv.Value)
+	}
+	// A response larger than the burst is waited for in chunks of at most the burst
+	for size > 0 {
+		n := min(size, c.bytes.Burst())
+		if err := c.bytes.WaitN(ctx, n); err != nil {
+			return nil, fmt.Errorf("failed to wait for the etcd bandwidth limit: %w", err)
+		}
+		size -= n
+	}
+	return resp, nil
+}
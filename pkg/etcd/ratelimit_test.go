@@ -0,0 +1,65 @@
+package etcd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+func TestRateLimitedClient_Get(t *testing.T) {
+	client := NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/p/a"), Value: []byte(strings.Repeat("x", 96))},
+	})
+
+	tests := []struct {
+		name        string
+		config      RateLimitConfig
+		requests    int
+		minDuration time.Duration
+	}{
+		{
+			// The first request uses the initial token, the next two wait 50ms each
+			name:        "requests per second",
+			config:      RateLimitConfig{RequestsPerSecond: 20},
+			requests:    3,
+			minDuration: 100 * time.Millisecond,
+		},
+		{
+			// Each response is 100 bytes: the first 10 use the initial burst of 1000 bytes, the next 2 wait 100ms each
+			name:        "bytes per second",
+			config:      RateLimitConfig{BytesPerSecond: 1000},
+			requests:    12,
+			minDuration: 200 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, tt.config.Enabled())
+			limited := NewRateLimitedClient(client, tt.config)
+			start := time.Now()
+			for i := 0; i < tt.requests; i++ {
+				resp, err := limited.Get(context.Background(), "/p/a")
+				assert.NoError(t, err)
+				assert.Len(t, resp.Kvs, 1)
+			}
+			assert.GreaterOrEqual(t, time.Since(start), tt.minDuration)
+		})
+	}
+}
+
+func TestRateLimitedClient_Get_Cancelled(t *testing.T) {
+	limited := NewRateLimitedClient(NewMemoryClient(nil), RateLimitConfig{RequestsPerSecond: 0.001})
+	_, err := limited.Get(context.Background(), "/p/a")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = limited.Get(ctx, "/p/a")
+	assert.ErrorContains(t, err, "failed to wait for the etcd request rate limit")
+	assert.False(t, RateLimitConfig{}.Enabled())
+}
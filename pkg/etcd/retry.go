@@ -0,0 +1,106 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// RetryConfig configures the retries of failed etcd requests. Zero Retries disables them.
+type RetryConfig struct {
+	// Retries is the number of times a request failing with a transient error is retried.
+	Retries int
+	// InitialBackoff is the wait before the first retry. It doubles with every further retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between two attempts.
+	MaxBackoff time.Duration
+}
+
+// Enabled reports whether requests are retried.
+func (c RetryConfig) Enabled() bool {
+	return c.Retries > 0
+}
+
+// RetryClient retries the requests of a client that fail with a transient error, e.g. a leader
+// change or a timeout, so that a single blip does not fail a whole run. The wait between two
+// attempts grows exponentially and is jittered, so that replicas retrying at once spread out.
+type RetryClient struct {
+	EtcdClientOperator
+	config RetryConfig
+	sleep  func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetryClient returns client retrying its Get requests according to config.
+func NewRetryClient(client EtcdClientOperator, config RetryConfig) *RetryClient {
+	return &RetryClient{EtcdClientOperator: client, config: config, sleep: sleep}
+}
+
+// Get reads from the wrapped client, retrying transient errors.
+func (c *RetryClient) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	backoff := c.config.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.EtcdClientOperator.Get(ctx, key, opts...)
+		if err == nil || attempt >= c.config.Retries || ctx.Err() != nil || !IsTransient(err) {
+			return resp, err
+		}
+
+		wait := jitter(min(backoff, c.config.MaxBackoff))
+		klog.V(2).InfoS("Retrying etcd request", "key", utils.LoggedKey(key), "attempt", attempt+1, "backoff", wait, "err", err)
+		metrics.EtcdRetriesTotal.Inc()
+		if err := c.sleep(ctx, wait); err != nil {
+			return nil, fmt.Errorf("failed to wait before retrying etcd request: %w", err)
+		}
+		backoff *= 2
+	}
+}
+
+// IsTransient reports whether err is an etcd error worth retrying: the server or the connection
+// was briefly unavailable, overloaded or too slow, as opposed to a malformed or forbidden request.
+func IsTransient(err error) bool {
+	// Errors of the caller's context are final
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// Known server errors, e.g. "leader changed", are converted to rpctypes errors by the client
+	code := status.Code(err)
+	var etcdErr rpctypes.EtcdError
+	if errors.As(err, &etcdErr) {
+		code = etcdErr.Code()
+	}
+	switch code {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// jitter returns a random duration between half of d and d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
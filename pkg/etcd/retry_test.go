@@ -0,0 +1,109 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+)
+
+func TestRetryClient_Get(t *testing.T) {
+	testCases := []struct {
+		name             string
+		err              error
+		retries          int
+		expectedRequests int
+		expectedBackoffs []time.Duration
+	}{
+		{
+			name:             "success is not retried",
+			retries:          3,
+			expectedRequests: 1,
+		},
+		{
+			name:             "leader change is retried with growing, capped backoff",
+			err:              rpctypes.ErrLeaderChanged,
+			retries:          3,
+			expectedRequests: 4,
+			expectedBackoffs: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond},
+		},
+		{
+			name:             "gRPC unavailable is retried",
+			err:              fmt.Errorf("failed to list secrets: %w", status.Error(codes.Unavailable, "connection reset")),
+			retries:          1,
+			expectedRequests: 2,
+			expectedBackoffs: []time.Duration{100 * time.Millisecond},
+		},
+		{
+			name:             "permission denied is not retried",
+			err:              rpctypes.ErrPermissionDenied,
+			retries:          3,
+			expectedRequests: 1,
+		},
+		{
+			name:             "context errors are not retried",
+			err:              context.DeadlineExceeded,
+			retries:          3,
+			expectedRequests: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := &stubClient{err: tc.err}
+			client := NewRetryClient(stub, RetryConfig{Retries: tc.retries, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond})
+			var backoffs []time.Duration
+			client.sleep = func(_ context.Context, d time.Duration) error {
+				backoffs = append(backoffs, d)
+				return nil
+			}
+			retries := testutil.ToFloat64(metrics.EtcdRetriesTotal)
+
+			_, err := client.Get(context.Background(), "/registry/secrets")
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.expectedRequests, stub.requests)
+			assert.Equal(t, float64(len(tc.expectedBackoffs)), testutil.ToFloat64(metrics.EtcdRetriesTotal)-retries)
+			// Backoffs are jittered between half of the nominal backoff and the nominal backoff
+			assert.Len(t, backoffs, len(tc.expectedBackoffs))
+			for i, backoff := range backoffs {
+				assert.GreaterOrEqual(t, backoff, tc.expectedBackoffs[i]/2)
+				assert.LessOrEqual(t, backoff, tc.expectedBackoffs[i])
+			}
+		})
+	}
+}
+
+func TestRetryClient_Get_Cancelled(t *testing.T) {
+	stub := &stubClient{err: rpctypes.ErrTimeout}
+	client := NewRetryClient(stub, RetryConfig{Retries: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	client.sleep = func(ctx context.Context, _ time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+
+	// Cancelling the run stops waiting for the next attempt
+	_, err := client.Get(ctx, "/registry/secrets")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, stub.requests)
+	assert.False(t, RetryConfig{}.Enabled())
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, IsTransient(rpctypes.ErrLeaderChanged))
+	assert.True(t, IsTransient(rpctypes.ErrTimeout))
+	assert.True(t, IsTransient(rpctypes.ErrTooManyRequests))
+	assert.True(t, IsTransient(status.Error(codes.DeadlineExceeded, "deadline exceeded")))
+	assert.False(t, IsTransient(rpctypes.ErrCompacted))
+	assert.False(t, IsTransient(errors.New("connection refused")))
+	assert.False(t, IsTransient(context.Canceled))
+}
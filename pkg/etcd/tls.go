@@ -0,0 +1,50 @@
+package etcd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// ParseTLSVersion parses a TLS version flag value, "1.2" or "1.3".
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("invalid TLS version %q, must be \"1.2\" or \"1.3\"", version)
+	}
+}
+
+// ParseCipherSuites parses cipher suite names as listed by tls.CipherSuites, e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Suites Go considers insecure are rejected, so that a
+// typo in a security baseline cannot allow a weak cipher.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	secure := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	insecure := map[string]bool{}
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	var ids []uint16
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if insecure[name] {
+			return nil, fmt.Errorf("cipher suite %s is insecure", name)
+		}
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
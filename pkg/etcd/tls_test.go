@@ -0,0 +1,64 @@
+package etcd
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	version, err := ParseTLSVersion("1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), version)
+
+	version, err = ParseTLSVersion("1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), version)
+
+	_, err = ParseTLSVersion("1.1")
+	assert.ErrorContains(t, err, "invalid TLS version")
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	testCases := []struct {
+		name          string
+		names         []string
+		expected      []uint16
+		expectedError string
+	}{
+		{
+			name:  "secure suites",
+			names: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", " TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", ""},
+			expected: []uint16{
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			},
+		},
+		{
+			name: "no suites",
+		},
+		{
+			name:          "insecure suite",
+			names:         []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"},
+			expectedError: "cipher suite TLS_RSA_WITH_RC4_128_SHA is insecure",
+		},
+		{
+			name:          "unknown suite",
+			names:         []string{"TLS_NOT_A_SUITE"},
+			expectedError: "unknown cipher suite",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ids, err := ParseCipherSuites(tc.names)
+			if tc.expectedError != "" {
+				assert.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, ids)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Package events emits Kubernetes events about reporter runs.
+package events
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+)
+
+const (
+	// Event reasons
+	ReasonRunFailed            = "RunFailed"
+	ReasonRunTimedOut          = "RunTimedOut"
+	ReasonResourceNotCovered   = "ResourceNotCovered"
+	ReasonCircuitOpen          = "EtcdCircuitOpen"
+	ReasonRotationComplete     = "RotationComplete"
+	ReasonTransformationErrors = "TransformationErrors"
+
+	component   = "kms-reporter"
+	emitTimeout = 5 * time.Second
+)
+
+// Emitter records events about reporter runs. Emitting is best effort: failures are logged, not returned.
+type Emitter interface {
+	Emit(ctx context.Context, eventType, reason, message string)
+}
+
+// KubeEmitter creates core/v1 Events attached to a single object.
+type KubeEmitter struct {
+	Clientset kubernetes.Interface
+	Object    v1.ObjectReference
+}
+
+// NewKubeEmitter returns an Emitter that attaches events to object. The events are created in the object's namespace.
+func NewKubeEmitter(clientset kubernetes.Interface, object v1.ObjectReference) Emitter {
+	return &KubeEmitter{
+		Clientset: clientset,
+		Object:    object,
+	}
+}
+
+// RequiredPermissions lists the Kubernetes API access the emitter needs in the given namespace.
+func RequiredPermissions(namespace string) []rbac.Permission {
+	return []rbac.Permission{
+		{Verb: "create", Resource: "events", Namespace: namespace},
+	}
+}
+
+// Emit creates an event. It does not use ctx's deadline, so events about timed out runs are still recorded.
+func (e *KubeEmitter) Emit(ctx context.Context, eventType, reason, message string) {
+	emitCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), emitTimeout)
+	defer cancel()
+
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: e.Object.Name + ".",
+			Namespace:    e.Object.Namespace,
+		},
+		InvolvedObject: e.Object,
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Source:         v1.EventSource{Component: component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := e.Clientset.CoreV1().Events(e.Object.Namespace).Create(emitCtx, event, metav1.CreateOptions{}); err != nil {
+		klog.ErrorS(err, "Failed to emit event", "reason", reason)
+	}
+}
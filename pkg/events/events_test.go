@@ -0,0 +1,53 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestKubeEmitter_Emit(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	object := v1.ObjectReference{Kind: "ConfigMap", APIVersion: "v1", Namespace: "kms", Name: "kms-reporter"}
+	emitter := NewKubeEmitter(clientset, object)
+
+	// A cancelled context, as left behind by a timed out run, must not prevent the event
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	emitter.Emit(ctx, v1.EventTypeWarning, ReasonRunTimedOut, "run exceeded 1m0s")
+
+	events, err := clientset.CoreV1().Events("kms").List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, events.Items, 1)
+	event := events.Items[0]
+	assert.Equal(t, object, event.InvolvedObject)
+	assert.Equal(t, v1.EventTypeWarning, event.Type)
+	assert.Equal(t, ReasonRunTimedOut, event.Reason)
+	assert.Equal(t, "run exceeded 1m0s", event.Message)
+	assert.Equal(t, component, event.Source.Component)
+}
+
+func TestKubeEmitter_Emit_Failure(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "events", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+	emitter := NewKubeEmitter(clientset, v1.ObjectReference{Namespace: "kms", Name: "kms-reporter"})
+
+	// Failures are logged and never panic or block the caller
+	emitter.Emit(context.Background(), v1.EventTypeWarning, ReasonRunFailed, "failed")
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	permissions := RequiredPermissions("kms")
+	assert.Len(t, permissions, 1)
+	assert.Equal(t, "create", permissions[0].Verb)
+	assert.Equal(t, "events", permissions[0].Resource)
+}
@@ -0,0 +1,52 @@
+// Package execjson runs commands with a JSON document on their standard input, as the hooks and plugins
+// integrating the reporter with site-specific tooling do.
+package execjson
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// waitDelay bounds the wait for the output of a command killed at its timeout
+const waitDelay = time.Second
+
+// maxOutputLength bounds the output of a command quoted in errors, in bytes
+const maxOutputLength = 1024
+
+// ErrTimeout is returned when a command is killed at its timeout.
+var ErrTimeout = errors.New("timed out")
+
+// Run runs command with input as JSON on its standard input, and waits for it to exit, killing it after
+// timeout. A command exiting with a non-zero status fails with its output, truncated to 1KiB.
+func Run(ctx context.Context, command []string, timeout time.Duration, input any) error {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal input: %w", err)
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Children of a killed command may keep its output open
+	cmd.WaitDelay = waitDelay
+	if err := cmd.Run(); err != nil {
+		if runCtx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%w after %s", ErrTimeout, timeout)
+		}
+		message := strings.TrimSpace(output.String())
+		if len(message) > maxOutputLength {
+			message = message[:maxOutputLength]
+		}
+		return fmt.Errorf("%w: %s", err, message)
+	}
+	return nil
+}
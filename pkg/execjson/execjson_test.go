@@ -0,0 +1,37 @@
+package execjson
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "input.json")
+	require.NoError(t, Run(context.Background(), []string{"/bin/sh", "-c", `cat > "$0"`, output}, time.Minute, map[string]int{"a": 1}))
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"a":1}`, string(data))
+}
+
+func TestRun_Errors(t *testing.T) {
+	err := Run(context.Background(), []string{"/bin/sh", "-c", "echo unavailable >&2; exit 3"}, time.Minute, nil)
+	assert.EqualError(t, err, "exit status 3: unavailable")
+
+	// The output quoted in the error is truncated
+	err = Run(context.Background(), []string{"/bin/sh", "-c", "head -c 4096 /dev/zero | tr '\\0' x; exit 1"}, time.Minute, nil)
+	assert.EqualError(t, err, "exit status 1: "+strings.Repeat("x", maxOutputLength))
+
+	err = Run(context.Background(), []string{"/bin/sh", "-c", "sleep 10"}, 50*time.Millisecond, nil)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.EqualError(t, err, "timed out after 50ms")
+
+	err = Run(context.Background(), []string{"/bin/true"}, time.Minute, func() {})
+	assert.ErrorContains(t, err, "failed to marshal input")
+}
@@ -0,0 +1,139 @@
+// Package export writes the per-secret findings of a scan as JSON, YAML or CSV, for ad-hoc analysis
+// with jq or spreadsheets. Findings are written as they are found, so exports of large clusters are
+// not held in memory.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// Formats
+const (
+	FormatJSON = utils.FormatJSON
+	FormatYAML = utils.FormatYAML
+	FormatCSV  = "csv"
+)
+
+// csvHeader names the CSV columns, in the order of the JSON fields of analyzer.Finding.
+var csvHeader = []string{"namespace", "name", "category", "providerType", "provider", "seq", "keyID", "encoding", "size"}
+
+// Writer writes findings in a format. Close must be called to complete the output.
+type Writer interface {
+	Write(finding analyzer.Finding) error
+	Close() error
+}
+
+// NewWriter returns a writer of findings in format to w.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	buffered := bufio.NewWriter(w)
+	switch format {
+	case FormatJSON:
+		return &jsonWriter{w: buffered}, nil
+	case FormatYAML:
+		return &yamlWriter{w: buffered}, nil
+	case FormatCSV:
+		writer := csv.NewWriter(buffered)
+		if err := writer.Write(csvHeader); err != nil {
+			return nil, fmt.Errorf("failed to write CSV header: %w", err)
+		}
+		return &csvWriter{w: buffered, csv: writer}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q, must be %q, %q or %q", format, FormatJSON, FormatYAML, FormatCSV)
+	}
+}
+
+// jsonWriter writes a JSON array with one finding per line.
+type jsonWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (j *jsonWriter) Write(finding analyzer.Finding) error {
+	data, err := utils.JSONMarshaller{}.Marshal(finding)
+	if err != nil {
+		return fmt.Errorf("failed to marshal finding: %w", err)
+	}
+	separator := ",\n"
+	if j.count == 0 {
+		separator = "[\n"
+	}
+	j.count++
+	j.w.WriteString(separator)
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if j.count == 0 {
+		j.w.WriteString("[")
+	}
+	j.w.WriteString("\n]\n")
+	return j.w.Flush()
+}
+
+// yamlWriter writes a YAML sequence of findings.
+type yamlWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (y *yamlWriter) Write(finding analyzer.Finding) error {
+	data, err := utils.YAMLMarshaller{}.Marshal(finding)
+	if err != nil {
+		return fmt.Errorf("failed to marshal finding: %w", err)
+	}
+	y.count++
+	// Indent the mapping as an item of the sequence
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+		prefix := "  "
+		if i == 0 {
+			prefix = "- "
+		}
+		y.w.WriteString(prefix + line + "\n")
+	}
+	return nil
+}
+
+func (y *yamlWriter) Close() error {
+	if y.count == 0 {
+		y.w.WriteString("[]\n")
+	}
+	return y.w.Flush()
+}
+
+// csvWriter writes a CSV file with a header row.
+type csvWriter struct {
+	w   *bufio.Writer
+	csv *csv.Writer
+}
+
+func (c *csvWriter) Write(finding analyzer.Finding) error {
+	return c.csv.Write([]string{
+		finding.Namespace,
+		finding.Name,
+		finding.Category,
+		finding.ProviderType,
+		finding.Provider,
+		strconv.Itoa(finding.Seq),
+		finding.KeyID,
+		finding.Encoding,
+		strconv.Itoa(finding.Size),
+	})
+}
+
+func (c *csvWriter) Close() error {
+	c.csv.Flush()
+	if err := c.csv.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return c.w.Flush()
+}
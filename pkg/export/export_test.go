@@ -0,0 +1,60 @@
+package export
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+var findings = []analyzer.Finding{
+	{Namespace: "default", Name: "a", Category: analyzer.CategoryEncrypted, ProviderType: "kms", Provider: "kmsprovider2", Seq: 2, KeyID: "key-1", Size: 120},
+	{Namespace: "kube-system", Name: "b,c", Category: analyzer.CategoryUnencrypted, ProviderType: "identity", Encoding: "protobuf", Size: 64},
+}
+
+func export(t *testing.T, format string, findings []analyzer.Finding) string {
+	var out strings.Builder
+	writer, err := NewWriter(format, &out)
+	require.NoError(t, err)
+	for _, finding := range findings {
+		require.NoError(t, writer.Write(finding))
+	}
+	require.NoError(t, writer.Close())
+	return out.String()
+}
+
+func TestWriter_JSON(t *testing.T) {
+	var decoded []analyzer.Finding
+	require.NoError(t, json.Unmarshal([]byte(export(t, FormatJSON, findings)), &decoded))
+	assert.Equal(t, findings, decoded)
+
+	assert.Equal(t, "[\n]\n", export(t, FormatJSON, nil))
+	require.NoError(t, json.Unmarshal([]byte(export(t, FormatJSON, nil)), &decoded))
+	assert.Empty(t, decoded)
+}
+
+func TestWriter_YAML(t *testing.T) {
+	var decoded []analyzer.Finding
+	require.NoError(t, yaml.Unmarshal([]byte(export(t, FormatYAML, findings)), &decoded))
+	assert.Equal(t, findings, decoded)
+
+	require.NoError(t, yaml.Unmarshal([]byte(export(t, FormatYAML, nil)), &decoded))
+	assert.Empty(t, decoded)
+}
+
+func TestWriter_CSV(t *testing.T) {
+	assert.Equal(t, `namespace,name,category,providerType,provider,seq,keyID,encoding,size
+default,a,Encrypted,kms,kmsprovider2,2,key-1,,120
+kube-system,"b,c",Unencrypted,identity,,0,,protobuf,64
+`, export(t, FormatCSV, findings))
+}
+
+func TestNewWriter_UnknownFormat(t *testing.T) {
+	_, err := NewWriter("xml", &strings.Builder{})
+	assert.ErrorContains(t, err, `unknown export format "xml"`)
+}
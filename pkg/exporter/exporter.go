@@ -0,0 +1,144 @@
+// Package exporter exposes the stored reports as Prometheus metrics, in the style of kube-state-metrics:
+// the report ConfigMaps are read on every scrape and converted to gauges. It needs read access to the
+// reports only, no etcd access, so that it can be deployed wherever monitoring needs it while the
+// privileged reporter stays on the control plane.
+package exporter
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+const (
+	namespace = "kms_reporter"
+	subsystem = "report"
+)
+
+// conditionStatuses are the values of the status label of the condition metric
+var conditionStatuses = []metav1.ConditionStatus{metav1.ConditionTrue, metav1.ConditionFalse, metav1.ConditionUnknown}
+
+// Config configures the exporter.
+type Config struct {
+	// Namespace is the namespace the reports are stored in.
+	Namespace string
+	// RequestTimeout bounds the Kubernetes API call of each scrape. 0 disables the limit.
+	RequestTimeout time.Duration
+	// KeyNames are the key name overrides the reports were written with. Optional.
+	KeyNames recorder.KeyNames
+}
+
+// Collector is a prometheus.Collector reading the reports on every scrape.
+type Collector struct {
+	clientset kubernetes.Interface
+	config    Config
+
+	info                        *prometheus.Desc
+	secrets                     *prometheus.Desc
+	unrecognizedSecrets         *prometheus.Desc
+	allSecretsUseLatestProvider *prometheus.Desc
+	rotationProgressPercent     *prometheus.Desc
+	lastSuccessfulRun           *prometheus.Desc
+	lastRunStatus               *prometheus.Desc
+	condition                   *prometheus.Desc
+	scrapeError                 *prometheus.Desc
+}
+
+func NewCollector(clientset kubernetes.Interface, config Config) *Collector {
+	labels := []string{"report", "node"}
+	desc := func(name, help string, extraLabels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, append(labels, extraLabels...), nil)
+	}
+	return &Collector{
+		clientset: clientset,
+		config:    config,
+
+		info:                        desc("info", "Information about the report, always 1.", "reporter_version"),
+		secrets:                     desc("secrets", "The number of secrets encrypted by each provider, identity counting the unencrypted secrets.", "provider"),
+		unrecognizedSecrets:         desc("unrecognized_secrets", "The number of secrets whose value was not recognized."),
+		allSecretsUseLatestProvider: desc("all_secrets_use_latest_provider", "Whether every secret is encrypted by the latest provider (1) or not (0). Only exposed while every secret is encrypted."),
+		rotationProgressPercent:     desc("rotation_progress_percent", "The percentage of secrets encrypted by the latest provider. Only exposed if the reporter tracks it."),
+		lastSuccessfulRun:           desc("last_successful_run_timestamp_seconds", "Unix timestamp of the last successful reporter run."),
+		lastRunStatus:               desc("last_run_status", "The outcome of the last reporter run, 1 for the current status.", "status"),
+		condition:                   desc("condition", "The status conditions of the report, 1 for the current status of each condition.", "condition", "status"),
+		scrapeError:                 prometheus.NewDesc(prometheus.BuildFQName(namespace, "exporter", "scrape_error"), "Whether the reports could not be read (1) or not (0) by the last scrape.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.info, c.secrets, c.unrecognizedSecrets, c.allSecretsUseLatestProvider, c.rotationProgressPercent,
+		c.lastSuccessfulRun, c.lastRunStatus, c.condition, c.scrapeError,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector. Reports that cannot be parsed are logged and skipped.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := utils.ContextWithTimeout(context.Background(), c.config.RequestTimeout)
+	defer cancel()
+	reports, err := recorder.ListReports(ctx, c.clientset, c.config.Namespace)
+	if err != nil {
+		klog.ErrorS(err, "Failed to read the reports", "namespace", c.config.Namespace)
+		ch <- prometheus.MustNewConstMetric(c.scrapeError, prometheus.GaugeValue, 1)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.scrapeError, prometheus.GaugeValue, 0)
+	for i := range reports {
+		c.collectReport(ch, &reports[i])
+	}
+}
+
+// collectReport sends the metrics of a report ConfigMap.
+func (c *Collector) collectReport(ch chan<- prometheus.Metric, configMap *v1.ConfigMap) {
+	summary, err := recorder.ParseReport(c.config.KeyNames.Restore(configMap.Data))
+	if err != nil {
+		klog.ErrorS(err, "Failed to parse the report", "configMap", klog.KObj(configMap))
+		return
+	}
+	labels := []string{configMap.Name, recorder.ReportNodeName(configMap)}
+	gauge := func(desc *prometheus.Desc, value float64, extraLabels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, append(labels, extraLabels...)...)
+	}
+
+	gauge(c.info, 1, summary.ReporterVersion)
+	for provider, count := range summary.ProviderCounts {
+		gauge(c.secrets, float64(count), provider)
+	}
+	gauge(c.unrecognizedSecrets, float64(summary.Unrecognized))
+	if summary.AllSecretsUseLatestProvider != nil {
+		gauge(c.allSecretsUseLatestProvider, boolValue(*summary.AllSecretsUseLatestProvider))
+	}
+	if summary.Progress != nil {
+		gauge(c.rotationProgressPercent, summary.Progress.Percent)
+	}
+	if !summary.LastSuccessfulRun.IsZero() {
+		gauge(c.lastSuccessfulRun, float64(summary.LastSuccessfulRun.Unix()))
+	}
+	if summary.LastRunStatus != "" {
+		gauge(c.lastRunStatus, 1, strings.ToLower(summary.LastRunStatus))
+	}
+	for _, condition := range summary.Conditions {
+		for _, status := range conditionStatuses {
+			gauge(c.condition, boolValue(condition.Status == status), condition.Type, strings.ToLower(string(status)))
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
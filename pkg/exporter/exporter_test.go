@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+)
+
+func TestCollector_Collect(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	nodeRecorder := recorder.NewRecorderOperator(clientset, recorder.Config{NodeName: "node1"})
+	report := recorder.NewReport([]string{"default/secret1", "default/secret2"}, nil, false, map[string]int{"kmsprovider1": 1, "kmsprovider2": 1})
+	report.Progress = &recorder.Progress{OnLatest: 1, Total: 2, Percent: 50}
+	require.NoError(t, nodeRecorder.Record(context.Background(), "kube-system", report))
+	require.NoError(t, nodeRecorder.RecordRunStatus(context.Background(), "kube-system", nil, time.Unix(1736157600, 0)))
+
+	// Invalid reports are skipped
+	require.NoError(t, recorder.NewRecorderOperator(clientset, recorder.Config{}).Record(context.Background(), "kube-system", recorder.NewReport([]string{"default/secret1"}, nil, true, nil)))
+	invalid, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "kms-reporter", metav1.GetOptions{})
+	require.NoError(t, err)
+	invalid.Data["PROVIDER_COUNTS"] = "not json"
+	_, err = clientset.CoreV1().ConfigMaps("kube-system").Update(context.Background(), invalid, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	collector := NewCollector(clientset, Config{Namespace: "kube-system"})
+	expected := `
+# HELP kms_reporter_exporter_scrape_error Whether the reports could not be read (1) or not (0) by the last scrape.
+# TYPE kms_reporter_exporter_scrape_error gauge
+kms_reporter_exporter_scrape_error 0
+# HELP kms_reporter_report_all_secrets_use_latest_provider Whether every secret is encrypted by the latest provider (1) or not (0). Only exposed while every secret is encrypted.
+# TYPE kms_reporter_report_all_secrets_use_latest_provider gauge
+kms_reporter_report_all_secrets_use_latest_provider{node="node1",report="kms-reporter-node1"} 0
+# HELP kms_reporter_report_last_run_status The outcome of the last reporter run, 1 for the current status.
+# TYPE kms_reporter_report_last_run_status gauge
+kms_reporter_report_last_run_status{node="node1",report="kms-reporter-node1",status="success"} 1
+# HELP kms_reporter_report_last_successful_run_timestamp_seconds Unix timestamp of the last successful reporter run.
+# TYPE kms_reporter_report_last_successful_run_timestamp_seconds gauge
+kms_reporter_report_last_successful_run_timestamp_seconds{node="node1",report="kms-reporter-node1"} 1.7361576e+09
+# HELP kms_reporter_report_rotation_progress_percent The percentage of secrets encrypted by the latest provider. Only exposed if the reporter tracks it.
+# TYPE kms_reporter_report_rotation_progress_percent gauge
+kms_reporter_report_rotation_progress_percent{node="node1",report="kms-reporter-node1"} 50
+# HELP kms_reporter_report_secrets The number of secrets encrypted by each provider, identity counting the unencrypted secrets.
+# TYPE kms_reporter_report_secrets gauge
+kms_reporter_report_secrets{node="node1",provider="kmsprovider1",report="kms-reporter-node1"} 1
+kms_reporter_report_secrets{node="node1",provider="kmsprovider2",report="kms-reporter-node1"} 1
+# HELP kms_reporter_report_unrecognized_secrets The number of secrets whose value was not recognized.
+# TYPE kms_reporter_report_unrecognized_secrets gauge
+kms_reporter_report_unrecognized_secrets{node="node1",report="kms-reporter-node1"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"kms_reporter_exporter_scrape_error",
+		"kms_reporter_report_all_secrets_use_latest_provider",
+		"kms_reporter_report_last_run_status",
+		"kms_reporter_report_last_successful_run_timestamp_seconds",
+		"kms_reporter_report_rotation_progress_percent",
+		"kms_reporter_report_secrets",
+		"kms_reporter_report_unrecognized_secrets",
+	))
+
+	// Every status of every condition is exposed
+	assert.Equal(t, 9, testutil.CollectAndCount(collector, "kms_reporter_report_condition"))
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "kms_reporter_report_info"))
+}
+
+func TestCollector_Collect_ListError(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kms-reporter", Namespace: "kube-system"}})
+	clientset.PrependReactor("list", "configmaps", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("configmaps is forbidden")
+	})
+
+	collector := NewCollector(clientset, Config{Namespace: "kube-system"})
+	assert.Equal(t, 1, testutil.CollectAndCount(collector))
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP kms_reporter_exporter_scrape_error Whether the reports could not be read (1) or not (0) by the last scrape.
+# TYPE kms_reporter_exporter_scrape_error gauge
+kms_reporter_exporter_scrape_error 1
+`)))
+}
+
+func TestCollector_Collect_KeyNames(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	keyNames := recorder.KeyNames{"PROVIDER_COUNTS": "provider_counts"}
+	reportRecorder := recorder.NewRecorderOperator(clientset, recorder.Config{KeyNames: keyNames})
+	require.NoError(t, reportRecorder.Record(context.Background(), "kube-system", recorder.NewReport([]string{"default/secret1"}, nil, true, map[string]int{"kmsprovider1": 1})))
+
+	collector := NewCollector(clientset, Config{Namespace: "kube-system", KeyNames: keyNames})
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP kms_reporter_report_secrets The number of secrets encrypted by each provider, identity counting the unencrypted secrets.
+# TYPE kms_reporter_report_secrets gauge
+kms_reporter_report_secrets{node="",provider="kmsprovider1",report="kms-reporter"} 1
+`), "kms_reporter_report_secrets"))
+
+	// Without the names, the report has no PROVIDER_COUNTS and is skipped
+	collector = NewCollector(clientset, Config{Namespace: "kube-system"})
+	assert.Equal(t, 0, testutil.CollectAndCount(collector, "kms_reporter_report_secrets"))
+}
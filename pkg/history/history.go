@@ -0,0 +1,215 @@
+// Package history keeps, across runs, the first run at which each secret was observed in its current
+// encryption state, its provider and KMS v2 key ID. A secret that stays in the same state for long was
+// not rewritten since, e.g. because nothing ever updates it, and keeps the data key it was written with.
+package history
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+// DefaultMaxNames is the number of unchanged secrets named in the summary.
+const DefaultMaxNames = 100
+
+// State is the encryption state of a secret, and the first run it was observed in.
+type State struct {
+	// Provider is the KMS provider name, or analyzer.IdentityProviderName for unencrypted secrets.
+	Provider string `json:"p"`
+	// KeyID is the KMS v2 key ID, if it could be decoded.
+	KeyID string `json:"k,omitempty"`
+	// Since is the unix time of the first run the secret was observed in this state.
+	Since int64 `json:"s"`
+}
+
+// Summary counts the secrets unchanged for longer than a duration.
+type Summary struct {
+	// Days is the number of days a secret must be unchanged for to be counted.
+	Days int `json:"days"`
+	// Tracked is the number of secrets whose state is tracked.
+	Tracked int `json:"tracked"`
+	// Unchanged is the number of secrets in the same state for longer than Days.
+	Unchanged int `json:"unchanged"`
+	// Secrets names the first unchanged secrets, unchanged for the longest first.
+	Secrets []string `json:"secrets,omitempty"`
+	// TrackedSince is the first run of the history: no secret can be counted as unchanged before Days
+	// have passed since.
+	TrackedSince time.Time `json:"trackedSince"`
+}
+
+// Deletion lists the secrets of the previous run that no longer exist.
+type Deletion struct {
+	// Previous is the number of secrets tracked in the previous run.
+	Previous int `json:"previous"`
+	// Deleted is the number of secrets of the previous run not observed in the current run.
+	Deleted int `json:"deleted"`
+	// Secrets names the first deleted secrets, in alphabetical order.
+	Secrets []string `json:"secrets,omitempty"`
+}
+
+// Config configures the tracker.
+type Config struct {
+	// Days is the number of days a secret must be unchanged for to be counted. 0 only tracks the
+	// deleted secrets.
+	Days int
+	// MaxNames is the number of unchanged and deleted secrets named in the summaries. Defaults to
+	// DefaultMaxNames.
+	MaxNames int
+}
+
+// Tracker follows the state of every secret across runs. Each run calls Begin, Observe for every secret
+// analyzed, then Commit once the analysis is complete.
+type Tracker struct {
+	store  Store
+	config Config
+
+	mu sync.Mutex
+	// states are the states of the last committed run, or nil until loaded from the store
+	states map[string]State
+	// observed are the states observed in the current run
+	observed map[string]State
+	// unrecognized are the secrets observed in the current run without a state, which were not deleted
+	unrecognized map[string]bool
+	// deletion lists the secrets deleted before the last committed run, or is nil before the second run
+	deletion *Deletion
+	// runStart is the start of the current run
+	runStart time.Time
+}
+
+func NewTracker(store Store, config Config) *Tracker {
+	if config.MaxNames <= 0 {
+		config.MaxNames = DefaultMaxNames
+	}
+	return &Tracker{store: store, config: config}
+}
+
+// Begin starts a run at now, discarding the observations of a run that did not complete.
+func (t *Tracker) Begin(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.observed = map[string]State{}
+	t.unrecognized = map[string]bool{}
+	t.runStart = now
+}
+
+// Observe records the state of a secret in the current run. Unrecognized secrets have no provider and
+// are not tracked.
+func (t *Tracker) Observe(finding analyzer.Finding) {
+	provider := finding.Provider
+	if finding.Category == analyzer.CategoryUnencrypted {
+		provider = analyzer.IdentityProviderName
+	}
+	name := finding.Name
+	if finding.Namespace != "" {
+		name = finding.Namespace + "/" + name
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.observed == nil:
+	case finding.Category == analyzer.CategoryUnrecognized:
+		t.unrecognized[name] = true
+	default:
+		t.observed[name] = State{Provider: provider, KeyID: finding.KeyID}
+	}
+}
+
+// Commit merges the states observed in the current run into the history and saves it: a secret keeps
+// the run it was first observed in while its state is unchanged, and deleted secrets are dropped, as
+// listed by Deletion. It returns the secrets unchanged for longer than Config.Days, or nil if Days is 0.
+func (t *Tracker) Commit(ctx context.Context) (*Summary, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.observed == nil {
+		return nil, fmt.Errorf("no run in progress")
+	}
+	if t.states == nil {
+		states, err := t.store.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		t.states = states
+	}
+
+	// The history is empty before the first run, which deletes nothing
+	var deletion *Deletion
+	if len(t.states) > 0 {
+		deletion = t.deleted()
+	}
+	runStart := t.runStart.Unix()
+	for name, state := range t.observed {
+		if previous, ok := t.states[name]; ok && previous.Provider == state.Provider && previous.KeyID == state.KeyID {
+			state.Since = previous.Since
+		} else {
+			state.Since = runStart
+		}
+		t.observed[name] = state
+	}
+	t.states, t.observed, t.unrecognized = t.observed, nil, nil
+	if err := t.store.Save(ctx, t.states); err != nil {
+		return nil, err
+	}
+	t.deletion = deletion
+	if t.config.Days == 0 {
+		return nil, nil
+	}
+	return t.summarize(), nil
+}
+
+// Deletion returns the secrets of the run before the last committed one that the last committed run
+// did not observe, or nil if there was no such run.
+func (t *Tracker) Deletion() *Deletion {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.deletion
+}
+
+// deleted lists the secrets of the history not observed in the current run.
+func (t *Tracker) deleted() *Deletion {
+	var names []string
+	for name := range t.states {
+		if _, ok := t.observed[name]; !ok && !t.unrecognized[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return &Deletion{
+		Previous: len(t.states),
+		Deleted:  len(names),
+		Secrets:  names[:min(len(names), t.config.MaxNames)],
+	}
+}
+
+// summarize counts the secrets of the history unchanged for longer than Config.Days at the start of the run.
+func (t *Tracker) summarize() *Summary {
+	cutoff := t.runStart.AddDate(0, 0, -t.config.Days).Unix()
+	summary := &Summary{Days: t.config.Days, Tracked: len(t.states)}
+	type unchanged struct {
+		name  string
+		since int64
+	}
+	var secrets []unchanged
+	trackedSince := t.runStart.Unix()
+	for name, state := range t.states {
+		trackedSince = min(trackedSince, state.Since)
+		if state.Since < cutoff {
+			secrets = append(secrets, unchanged{name: name, since: state.Since})
+		}
+	}
+	summary.TrackedSince = time.Unix(trackedSince, 0).UTC()
+	summary.Unchanged = len(secrets)
+	sort.Slice(secrets, func(i, j int) bool {
+		if secrets[i].since != secrets[j].since {
+			return secrets[i].since < secrets[j].since
+		}
+		return secrets[i].name < secrets[j].name
+	})
+	for _, secret := range secrets[:min(len(secrets), t.config.MaxNames)] {
+		summary.Secrets = append(summary.Secrets, secret.name)
+	}
+	return summary
+}
@@ -0,0 +1,132 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+// memoryStore keeps the saved states
+type memoryStore struct {
+	states map[string]State
+	err    error
+}
+
+func (s *memoryStore) Load(context.Context) (map[string]State, error) {
+	if s.states == nil {
+		return map[string]State{}, s.err
+	}
+	return s.states, s.err
+}
+
+func (s *memoryStore) Save(_ context.Context, states map[string]State) error {
+	s.states = states
+	return s.err
+}
+
+func encrypted(namespace, name, provider, keyID string) analyzer.Finding {
+	return analyzer.Finding{Namespace: namespace, Name: name, Category: analyzer.CategoryEncrypted, ProviderType: "kms", Provider: provider, KeyID: keyID}
+}
+
+func TestTracker(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &memoryStore{}
+	tracker := NewTracker(store, Config{Days: 30})
+
+	tracker.Begin(start)
+	tracker.Observe(encrypted("default", "a", "kmsprovider1", "key1"))
+	tracker.Observe(encrypted("default", "b", "kmsprovider1", "key1"))
+	tracker.Observe(analyzer.Finding{Namespace: "default", Name: "plain", Category: analyzer.CategoryUnencrypted, ProviderType: "identity"})
+	tracker.Observe(analyzer.Finding{Namespace: "default", Name: "corrupt", Category: analyzer.CategoryUnrecognized})
+	summary, err := tracker.Commit(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Summary{Days: 30, Tracked: 3, TrackedSince: start}, summary)
+	assert.Equal(t, State{Provider: analyzer.IdentityProviderName, Since: start.Unix()}, store.states["default/plain"])
+	assert.Nil(t, tracker.Deletion())
+
+	// A week later, b is rewritten with a new key and c is created
+	tracker.Begin(start.AddDate(0, 0, 7))
+	tracker.Observe(encrypted("default", "a", "kmsprovider1", "key1"))
+	tracker.Observe(encrypted("default", "b", "kmsprovider1", "key2"))
+	tracker.Observe(encrypted("default", "c", "kmsprovider1", "key2"))
+	_, err = tracker.Commit(context.Background())
+	require.NoError(t, err)
+
+	// A failed run is discarded by the next one
+	tracker.Begin(start.AddDate(0, 0, 20))
+	tracker.Observe(encrypted("default", "a", "kmsprovider2", "key3"))
+
+	tracker.Begin(start.AddDate(0, 0, 31))
+	tracker.Observe(encrypted("default", "a", "kmsprovider1", "key1"))
+	tracker.Observe(encrypted("default", "b", "kmsprovider1", "key2"))
+	tracker.Observe(encrypted("default", "c", "kmsprovider1", "key2"))
+	summary, err = tracker.Commit(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Summary{Days: 30, Tracked: 3, Unchanged: 1, Secrets: []string{"default/a"}, TrackedSince: start}, summary)
+	// The deleted secret is dropped
+	assert.NotContains(t, store.states, "default/plain")
+	assert.Equal(t, &Deletion{Previous: 3}, tracker.Deletion())
+}
+
+func TestTracker_Deletion(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewTracker(&memoryStore{}, Config{MaxNames: 2})
+	tracker.Begin(start)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		tracker.Observe(encrypted("default", name, "kmsprovider1", ""))
+	}
+	summary, err := tracker.Commit(context.Background())
+	require.NoError(t, err)
+	// Without days, only the deleted secrets are tracked
+	assert.Nil(t, summary)
+	// The first run deletes nothing
+	assert.Nil(t, tracker.Deletion())
+
+	tracker.Begin(start.Add(time.Hour))
+	tracker.Observe(encrypted("default", "a", "kmsprovider1", ""))
+	// A secret that cannot be parsed anymore still exists
+	tracker.Observe(analyzer.Finding{Namespace: "default", Name: "b", Category: analyzer.CategoryUnrecognized})
+	_, err = tracker.Commit(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Deletion{Previous: 4, Deleted: 2, Secrets: []string{"default/c", "default/d"}}, tracker.Deletion())
+
+	tracker.Begin(start.Add(2 * time.Hour))
+	tracker.Observe(encrypted("default", "a", "kmsprovider1", ""))
+	_, err = tracker.Commit(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Deletion{Previous: 1}, tracker.Deletion())
+}
+
+func TestTracker_LoadsHistory(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &memoryStore{states: map[string]State{
+		"default/a": {Provider: "kmsprovider1", Since: start.Unix()},
+		"default/b": {Provider: "kmsprovider1", Since: start.AddDate(0, 0, -1).Unix()},
+	}}
+	// A restarted reporter continues the saved history
+	tracker := NewTracker(store, Config{Days: 30, MaxNames: 1})
+	tracker.Begin(start.AddDate(0, 2, 0))
+	tracker.Observe(encrypted("default", "a", "kmsprovider1", ""))
+	tracker.Observe(encrypted("default", "b", "kmsprovider1", ""))
+	summary, err := tracker.Commit(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.Unchanged)
+	assert.Equal(t, []string{"default/b"}, summary.Secrets)
+	assert.Equal(t, start.AddDate(0, 0, -1), summary.TrackedSince)
+}
+
+func TestTracker_Errors(t *testing.T) {
+	tracker := NewTracker(&memoryStore{err: errors.New("forbidden")}, Config{Days: 30})
+	_, err := tracker.Commit(context.Background())
+	assert.ErrorContains(t, err, "no run in progress")
+
+	tracker.Begin(time.Now())
+	_, err = tracker.Commit(context.Background())
+	assert.ErrorContains(t, err, "forbidden")
+}
@@ -0,0 +1,142 @@
+package history
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// historyKey holds the gzipped JSON states of the secrets in the binary data of the ConfigMap
+const historyKey = "HISTORY"
+
+// Store persists the states of the secrets between runs.
+type Store interface {
+	// Load returns the saved states, empty before the first save.
+	Load(ctx context.Context) (map[string]State, error)
+	Save(ctx context.Context, states map[string]State) error
+}
+
+// ConfigMapStore stores the states, gzipped, in a ConfigMap. Secrets compress to about 20 bytes each, so
+// that a ConfigMap holds the history of about fifty thousand secrets.
+type ConfigMapStore struct {
+	Clientset kubernetes.Interface
+	Namespace string
+	Name      string
+	// RequestTimeout bounds each Kubernetes API call. 0 disables the limit.
+	RequestTimeout time.Duration
+}
+
+func NewConfigMapStore(clientset kubernetes.Interface, namespace, name string, requestTimeout time.Duration) *ConfigMapStore {
+	return &ConfigMapStore{Clientset: clientset, Namespace: namespace, Name: name, RequestTimeout: requestTimeout}
+}
+
+// ConfigMapName returns the name of the ConfigMap holding the history of the report reportName.
+func ConfigMapName(reportName string) string {
+	return reportName + "-history"
+}
+
+// RequiredPermissions lists the Kubernetes API access the ConfigMap store needs.
+func RequiredPermissions(namespace, name string) []rbac.Permission {
+	return []rbac.Permission{
+		{Verb: "get", Resource: "configmaps", Namespace: namespace, Name: name},
+		{Verb: "create", Resource: "configmaps", Namespace: namespace},
+		{Verb: "update", Resource: "configmaps", Namespace: namespace, Name: name},
+	}
+}
+
+// Load returns the states stored in the ConfigMap, empty if it does not exist yet.
+func (s *ConfigMapStore) Load(ctx context.Context) (map[string]State, error) {
+	configMap, err := s.get(ctx)
+	if apierrors.IsNotFound(err) {
+		return map[string]State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := configMap.BinaryData[historyKey]
+	if !ok {
+		return map[string]State{}, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress history: %w", err)
+	}
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress history: %w", err)
+	}
+	states := map[string]State{}
+	if err := json.Unmarshal(decompressed, &states); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal history: %w", err)
+	}
+	return states, nil
+}
+
+// Save creates or updates the ConfigMap with states.
+func (s *ConfigMapStore) Save(ctx context.Context, states map[string]State) error {
+	data, err := json.Marshal(states)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %w", err)
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("failed to compress history: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to compress history: %w", err)
+	}
+
+	configMap, err := s.get(ctx)
+	if apierrors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: s.Name, Namespace: s.Namespace},
+			BinaryData: map[string][]byte{historyKey: compressed.Bytes()},
+		}
+		createCtx, cancel := utils.ContextWithTimeout(ctx, s.RequestTimeout)
+		defer cancel()
+		if _, err := s.Clientset.CoreV1().ConfigMaps(s.Namespace).Create(createCtx, configMap, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create ConfigMap %s: %w", s.Name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if configMap.BinaryData == nil {
+		configMap.BinaryData = map[string][]byte{}
+	}
+	configMap.BinaryData[historyKey] = compressed.Bytes()
+	updateCtx, cancel := utils.ContextWithTimeout(ctx, s.RequestTimeout)
+	defer cancel()
+	if _, err := s.Clientset.CoreV1().ConfigMaps(s.Namespace).Update(updateCtx, configMap, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update ConfigMap %s: %w", s.Name, err)
+	}
+	return nil
+}
+
+// get returns the ConfigMap, with a NotFound error if it does not exist.
+func (s *ConfigMapStore) get(ctx context.Context) (*v1.ConfigMap, error) {
+	getCtx, cancel := utils.ContextWithTimeout(ctx, s.RequestTimeout)
+	defer cancel()
+	configMap, err := s.Clientset.CoreV1().ConfigMaps(s.Namespace).Get(getCtx, s.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ConfigMap %s: %w", s.Name, err)
+	}
+	return configMap, nil
+}
@@ -0,0 +1,54 @@
+package history
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapStore(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	store := NewConfigMapStore(clientset, "kms", ConfigMapName("kms-reporter"), 0)
+
+	states, err := store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, states)
+
+	saved := map[string]State{
+		"default/a": {Provider: "kmsprovider1", KeyID: "key1", Since: 1735689600},
+		"default/b": {Provider: "identity", Since: 1735689600},
+	}
+	require.NoError(t, store.Save(context.Background(), saved))
+	states, err = store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, saved, states)
+
+	// The ConfigMap is updated in place
+	delete(saved, "default/b")
+	require.NoError(t, store.Save(context.Background(), saved))
+	states, err = store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, saved, states)
+
+	configMap, err := clientset.CoreV1().ConfigMaps("kms").Get(context.Background(), "kms-reporter-history", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, configMap.BinaryData, historyKey)
+}
+
+func TestConfigMapStore_Corrupted(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	store := NewConfigMapStore(clientset, "kms", "kms-reporter-history", 0)
+	require.NoError(t, store.Save(context.Background(), map[string]State{}))
+	configMap, err := clientset.CoreV1().ConfigMaps("kms").Get(context.Background(), "kms-reporter-history", metav1.GetOptions{})
+	require.NoError(t, err)
+	configMap.BinaryData[historyKey] = []byte("not gzip")
+	_, err = clientset.CoreV1().ConfigMaps("kms").Update(context.Background(), configMap, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	_, err = store.Load(context.Background())
+	assert.ErrorContains(t, err, "failed to decompress history")
+}
@@ -0,0 +1,109 @@
+// Package hook runs a command when the status of a report changes, with the report as JSON on its
+// standard input. It is the escape hatch for site-specific integrations: anything a script can do, e.g.
+// opening a ticket or calling an internal API, without a dedicated client in the reporter.
+package hook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/lzhecheng/kms-reporter/pkg/execjson"
+)
+
+// DefaultTimeout bounds a run of the command.
+const DefaultTimeout = 30 * time.Second
+
+// Transition is a change of the status of a report condition.
+type Transition struct {
+	// Type is the type of the condition, e.g. Encrypted.
+	Type string `json:"type"`
+	// From is the previous status, empty if the condition was not set.
+	From metav1.ConditionStatus `json:"from"`
+	// To is the new status.
+	To      metav1.ConditionStatus `json:"to"`
+	Reason  string                 `json:"reason"`
+	Message string                 `json:"message"`
+}
+
+// Event is written as JSON to the standard input of the command.
+type Event struct {
+	// Namespace and Name identify the report ConfigMap.
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Time is when the report was written.
+	Time time.Time `json:"time"`
+	// Transitions are the conditions whose status changed.
+	Transitions []Transition `json:"transitions"`
+	// Report is the data of the report as written, under the default key names.
+	Report map[string]string `json:"report"`
+}
+
+// Config configures a hook.
+type Config struct {
+	// Command is the executable and its arguments. It is run directly, not through a shell.
+	Command []string
+	// Timeout bounds each run, after which the command is killed. Defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Hook runs its command for every event.
+type Hook struct {
+	config Config
+}
+
+func New(config Config) (*Hook, error) {
+	if len(config.Command) == 0 || config.Command[0] == "" {
+		return nil, fmt.Errorf("hook command is required")
+	}
+	// Fail at startup rather than at the first transition
+	if _, err := exec.LookPath(config.Command[0]); err != nil {
+		return nil, fmt.Errorf("invalid hook command: %w", err)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return &Hook{config: config}, nil
+}
+
+// Run runs the command with event as JSON on its standard input, and waits for it to exit. A command
+// exiting with a non-zero status fails with its output.
+func (h *Hook) Run(ctx context.Context, event Event) error {
+	if err := execjson.Run(ctx, h.config.Command, h.config.Timeout, event); err != nil {
+		if errors.Is(err, execjson.ErrTimeout) {
+			return fmt.Errorf("hook %s %w", h.config.Command[0], err)
+		}
+		return fmt.Errorf("hook %s failed: %w", h.config.Command[0], err)
+	}
+	return nil
+}
+
+// Transitions returns the conditions of current whose status differs from previous, in the order of
+// current. A condition missing from previous, e.g. in the first report, transitions from "".
+func Transitions(previous, current []metav1.Condition) []Transition {
+	var transitions []Transition
+	for _, condition := range current {
+		var from metav1.ConditionStatus
+		for _, old := range previous {
+			if old.Type == condition.Type {
+				from = old.Status
+				break
+			}
+		}
+		if from == condition.Status {
+			continue
+		}
+		transitions = append(transitions, Transition{
+			Type:    condition.Type,
+			From:    from,
+			To:      condition.Status,
+			Reason:  condition.Reason,
+			Message: condition.Message,
+		})
+	}
+	return transitions
+}
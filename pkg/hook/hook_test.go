@@ -0,0 +1,77 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{})
+	assert.ErrorContains(t, err, "hook command is required")
+
+	_, err = New(Config{Command: []string{filepath.Join(t.TempDir(), "missing")}})
+	assert.ErrorContains(t, err, "invalid hook command")
+
+	hook, err := New(Config{Command: []string{"/bin/true"}})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTimeout, hook.config.Timeout)
+}
+
+func TestHook_Run(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "event.json")
+	hook, err := New(Config{Command: []string{"/bin/sh", "-c", `cat > "$0"`, output}})
+	require.NoError(t, err)
+
+	event := Event{
+		Namespace:   "kube-system",
+		Name:        "kms-reporter",
+		Time:        time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Transitions: []Transition{{Type: "Encrypted", From: metav1.ConditionTrue, To: metav1.ConditionFalse, Reason: "UnencryptedSecretsFound", Message: "1 of 2 secrets are not encrypted"}},
+		Report:      map[string]string{"UNENCRYPTED": "default/a"},
+	}
+	require.NoError(t, hook.Run(context.Background(), event))
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	var received Event
+	require.NoError(t, json.Unmarshal(data, &received))
+	assert.Equal(t, event, received)
+}
+
+func TestHook_Run_Errors(t *testing.T) {
+	hook, err := New(Config{Command: []string{"/bin/sh", "-c", "echo ticket system unavailable >&2; exit 3"}})
+	require.NoError(t, err)
+	err = hook.Run(context.Background(), Event{})
+	assert.ErrorContains(t, err, "hook /bin/sh failed: exit status 3: ticket system unavailable")
+
+	hook, err = New(Config{Command: []string{"/bin/sh", "-c", "sleep 10"}, Timeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	assert.ErrorContains(t, hook.Run(context.Background(), Event{}), "hook /bin/sh timed out after 50ms")
+}
+
+func TestTransitions(t *testing.T) {
+	previous := []metav1.Condition{
+		{Type: "Encrypted", Status: metav1.ConditionTrue},
+		{Type: "OnLatestProvider", Status: metav1.ConditionFalse},
+	}
+	current := []metav1.Condition{
+		{Type: "Encrypted", Status: metav1.ConditionTrue},
+		{Type: "OnLatestProvider", Status: metav1.ConditionTrue, Reason: "AllSecretsOnLatestProvider", Message: "All secrets are encrypted by the latest provider"},
+		{Type: "ScanHealthy", Status: metav1.ConditionTrue, Reason: "RunSucceeded"},
+	}
+	assert.Equal(t, []Transition{
+		{Type: "OnLatestProvider", From: metav1.ConditionFalse, To: metav1.ConditionTrue, Reason: "AllSecretsOnLatestProvider", Message: "All secrets are encrypted by the latest provider"},
+		// A condition set for the first time
+		{Type: "ScanHealthy", To: metav1.ConditionTrue, Reason: "RunSucceeded"},
+	}, Transitions(previous, current))
+
+	assert.Empty(t, Transitions(current, current))
+}
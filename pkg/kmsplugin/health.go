@@ -0,0 +1,133 @@
+package kmsplugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// HealthConfigMapName is the ConfigMap the node agents of every node share, with one key per node.
+const HealthConfigMapName = "kms-reporter-plugin-health"
+
+// managedByLabel marks the ConfigMap as written by the reporter, like its reports
+const managedByLabel = "app.kubernetes.io/managed-by"
+
+// NodeHealth is the health of the plugins of one node.
+type NodeHealth struct {
+	Plugins []PluginStatus `json:"plugins"`
+	// CheckedAt is when the plugins were checked, which tells a stale entry, e.g. of a removed node.
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// Healthy returns true if every plugin of the node is healthy.
+func (h NodeHealth) Healthy() bool {
+	for _, plugin := range h.Plugins {
+		if !plugin.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// RecorderConfig configures the recorder of a node.
+type RecorderConfig struct {
+	// Namespace is the namespace of the shared ConfigMap.
+	Namespace string
+	// NodeName is the node whose key the recorder writes.
+	NodeName string
+	// RequestTimeout bounds each Kubernetes API call. 0 disables the limit.
+	RequestTimeout time.Duration
+}
+
+// Recorder stores the health of the plugins of a node under the key of the node in the shared
+// ConfigMap. It only ever patches its own key, so that the agents of all nodes write concurrently
+// without conflicts.
+type Recorder struct {
+	clientset kubernetes.Interface
+	config    RecorderConfig
+}
+
+func NewRecorder(clientset kubernetes.Interface, config RecorderConfig) *Recorder {
+	return &Recorder{clientset: clientset, config: config}
+}
+
+// RequiredPermissions lists the Kubernetes API access the recorder needs in namespace.
+func RequiredPermissions(namespace string) []rbac.Permission {
+	return []rbac.Permission{
+		{Verb: "create", Resource: "configmaps", Namespace: namespace},
+		{Verb: "patch", Resource: "configmaps", Namespace: namespace, Name: HealthConfigMapName},
+	}
+}
+
+// Record stores health under the key of the node, creating the shared ConfigMap if needed.
+func (r *Recorder) Record(ctx context.Context, health NodeHealth) error {
+	value, err := json.Marshal(health)
+	if err != nil {
+		return fmt.Errorf("failed to marshal plugin health: %w", err)
+	}
+	patch, err := json.Marshal(map[string]any{"data": map[string]string{r.config.NodeName: string(value)}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal ConfigMap patch: %w", err)
+	}
+
+	err = r.patch(ctx, patch)
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	createCtx, cancel := utils.ContextWithTimeout(ctx, r.config.RequestTimeout)
+	defer cancel()
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      HealthConfigMapName,
+			Namespace: r.config.Namespace,
+			Labels:    map[string]string{managedByLabel: "kms-reporter"},
+		},
+		Data: map[string]string{r.config.NodeName: string(value)},
+	}
+	_, err = r.clientset.CoreV1().ConfigMaps(r.config.Namespace).Create(createCtx, configMap, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// The agent of another node created it first
+		return r.patch(ctx, patch)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// patch applies the JSON merge patch to the shared ConfigMap. A missing ConfigMap is returned as is.
+func (r *Recorder) patch(ctx context.Context, patch []byte) error {
+	patchCtx, cancel := utils.ContextWithTimeout(ctx, r.config.RequestTimeout)
+	defer cancel()
+	_, err := r.clientset.CoreV1().ConfigMaps(r.config.Namespace).Patch(patchCtx, HealthConfigMapName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to patch ConfigMap: %w", err)
+	}
+	return err
+}
+
+// ParseNodeHealth returns the health of every node stored in the data of the shared ConfigMap, by node.
+// Keys that cannot be parsed are logged and skipped.
+func ParseNodeHealth(data map[string]string) map[string]NodeHealth {
+	nodes := make(map[string]NodeHealth, len(data))
+	for node, value := range data {
+		var health NodeHealth
+		if err := json.Unmarshal([]byte(value), &health); err != nil {
+			klog.ErrorS(err, "Failed to parse plugin health", "node", node)
+			continue
+		}
+		nodes[node] = health
+	}
+	return nodes
+}
@@ -0,0 +1,41 @@
+package kmsplugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNodeHealth_Healthy(t *testing.T) {
+	assert.True(t, NodeHealth{Plugins: []PluginStatus{{Healthy: true}, {Healthy: true}}}.Healthy())
+	assert.False(t, NodeHealth{Plugins: []PluginStatus{{Healthy: true}, {Error: "KMS plugin socket not found"}}}.Healthy())
+}
+
+func TestRecorder_Record(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	checkedAt := time.Date(2025, 1, 7, 10, 0, 0, 0, time.UTC)
+	node1 := NodeHealth{Plugins: []PluginStatus{{Socket: DefaultSocket, Healthy: true, Version: "v2", Healthz: "ok", KeyID: "key1"}}, CheckedAt: checkedAt}
+	node2 := NodeHealth{Plugins: []PluginStatus{{Socket: DefaultSocket, Error: "KMS plugin socket not found"}}, CheckedAt: checkedAt}
+
+	// The first agent creates the ConfigMap, the others add their key
+	require.NoError(t, NewRecorder(clientset, RecorderConfig{Namespace: "kube-system", NodeName: "node1"}).Record(context.Background(), node1))
+	require.NoError(t, NewRecorder(clientset, RecorderConfig{Namespace: "kube-system", NodeName: "node2"}).Record(context.Background(), node2))
+	node1.Plugins[0].Healthy = false
+	node1.Plugins[0].Healthz = "key vault unreachable"
+	require.NoError(t, NewRecorder(clientset, RecorderConfig{Namespace: "kube-system", NodeName: "node1"}).Record(context.Background(), node1))
+
+	configMap, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), HealthConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "kms-reporter", configMap.Labels[managedByLabel])
+	assert.JSONEq(t, `{"plugins":[{"socket":"/var/run/kmsplugin/socket.sock","healthy":false,"version":"v2","healthz":"key vault unreachable","keyID":"key1"}],"checkedAt":"2025-01-07T10:00:00Z"}`, configMap.Data["node1"])
+	assert.Equal(t, map[string]NodeHealth{"node1": node1, "node2": node2}, ParseNodeHealth(configMap.Data))
+}
+
+func TestParseNodeHealth_Invalid(t *testing.T) {
+	assert.Equal(t, map[string]NodeHealth{"node2": {}}, ParseNodeHealth(map[string]string{"node1": "not json", "node2": "{}"}))
+}
@@ -0,0 +1,129 @@
+// Package kmsplugin checks the health of the KMS plugins running on a control plane node. The API server
+// of every control plane node talks to its own plugin through a unix socket, so a plugin outage on a
+// single node breaks the encryption of that API server only, which a scan of etcd does not show.
+package kmsplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	kmsapi "k8s.io/kms/apis/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// DefaultSocket is the socket the KMS plugin of the kube-apiserver documentation listens on.
+const DefaultSocket = "/var/run/kmsplugin/socket.sock"
+
+// DefaultTimeout bounds the Status call of each plugin when no timeout is given. The API server itself
+// gives up on a plugin after 3 seconds by default.
+const DefaultTimeout = 3 * time.Second
+
+// healthzOK is the healthz of a healthy KMS v2 plugin
+const healthzOK = "ok"
+
+// PluginStatus is the health of the plugin listening on one socket.
+type PluginStatus struct {
+	Socket string `json:"socket"`
+	// Healthy is set when the plugin answered Status with an "ok" healthz.
+	Healthy bool `json:"healthy"`
+	// Version, Healthz and KeyID are the answer of the plugin to Status.
+	Version string `json:"version,omitempty"`
+	Healthz string `json:"healthz,omitempty"`
+	KeyID   string `json:"keyID,omitempty"`
+	// Error is why the plugin could not be checked, e.g. a missing socket.
+	Error string `json:"error,omitempty"`
+}
+
+// Config configures the checker.
+type Config struct {
+	// Sockets are the paths of the unix sockets of the plugins. At least one is required.
+	Sockets []string
+	// Timeout bounds the Status call of each plugin. Defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Checker calls the KMS v2 Status method of the plugins listening on local unix sockets.
+type Checker struct {
+	config Config
+}
+
+func NewChecker(config Config) (*Checker, error) {
+	if len(config.Sockets) == 0 {
+		return nil, errors.New("at least one KMS plugin socket is required")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return &Checker{config: config}, nil
+}
+
+// Check returns the status of every plugin, in the order of the sockets. A plugin that cannot be
+// checked is unhealthy and does not prevent the others from being checked.
+func (c *Checker) Check(ctx context.Context) []PluginStatus {
+	statuses := make([]PluginStatus, len(c.config.Sockets))
+	var wg sync.WaitGroup
+	for i, socket := range c.config.Sockets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = c.check(ctx, socket)
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+// check returns the status of the plugin listening on socket.
+func (c *Checker) check(ctx context.Context, socket string) PluginStatus {
+	status := PluginStatus{Socket: socket}
+	if err := checkSocket(socket); err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	response, err := c.status(ctx, socket)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Version = response.Version
+	status.Healthz = response.Healthz
+	status.KeyID = response.KeyId
+	status.Healthy = response.Healthz == healthzOK
+	return status
+}
+
+// checkSocket returns an error if no unix socket exists at path, which tells a plugin that is not
+// running from one that does not answer.
+func checkSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("KMS plugin socket not found: %w", err)
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s is not a unix socket", path)
+	}
+	return nil
+}
+
+// status calls the Status method of the plugin listening on socket.
+func (c *Checker) status(ctx context.Context, socket string) (*kmsapi.StatusResponse, error) {
+	conn, err := grpc.NewClient("unix:"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create KMS plugin client: %w", err)
+	}
+	defer conn.Close()
+	statusCtx, cancel := utils.ContextWithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	response, err := kmsapi.NewKeyManagementServiceClient(conn).Status(statusCtx, &kmsapi.StatusRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("KMS plugin Status failed: %w", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,73 @@
+package kmsplugin
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	kmsapi "k8s.io/kms/apis/v2"
+)
+
+// fakePlugin answers Status with a fixed response or error
+type fakePlugin struct {
+	kmsapi.UnimplementedKeyManagementServiceServer
+	response *kmsapi.StatusResponse
+	err      error
+}
+
+func (p *fakePlugin) Status(context.Context, *kmsapi.StatusRequest) (*kmsapi.StatusResponse, error) {
+	return p.response, p.err
+}
+
+// servePlugin serves plugin on a unix socket in dir and returns the path of the socket
+func servePlugin(t *testing.T, dir, name string, plugin *fakePlugin) string {
+	socket := filepath.Join(dir, name)
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	kmsapi.RegisterKeyManagementServiceServer(server, plugin)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+	return socket
+}
+
+func TestNewChecker(t *testing.T) {
+	_, err := NewChecker(Config{})
+	assert.ErrorContains(t, err, "at least one KMS plugin socket")
+
+	checker, err := NewChecker(Config{Sockets: []string{DefaultSocket}})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTimeout, checker.config.Timeout)
+}
+
+func TestChecker_Check(t *testing.T) {
+	dir := t.TempDir()
+	healthy := servePlugin(t, dir, "healthy.sock", &fakePlugin{response: &kmsapi.StatusResponse{Version: "v2", Healthz: "ok", KeyId: "key1"}})
+	unhealthy := servePlugin(t, dir, "unhealthy.sock", &fakePlugin{response: &kmsapi.StatusResponse{Version: "v2", Healthz: "key vault unreachable", KeyId: "key1"}})
+	failing := servePlugin(t, dir, "failing.sock", &fakePlugin{err: status.Error(codes.Unavailable, "not ready")})
+	regularFile := filepath.Join(dir, "file.sock")
+	require.NoError(t, os.WriteFile(regularFile, nil, 0o600))
+	missing := filepath.Join(dir, "missing.sock")
+
+	checker, err := NewChecker(Config{Sockets: []string{healthy, unhealthy, failing, regularFile, missing}})
+	require.NoError(t, err)
+	statuses := checker.Check(context.Background())
+	require.Len(t, statuses, 5)
+	assert.Equal(t, PluginStatus{Socket: healthy, Healthy: true, Version: "v2", Healthz: "ok", KeyID: "key1"}, statuses[0])
+	assert.Equal(t, PluginStatus{Socket: unhealthy, Version: "v2", Healthz: "key vault unreachable", KeyID: "key1"}, statuses[1])
+	assert.False(t, statuses[2].Healthy)
+	assert.Contains(t, statuses[2].Error, "not ready")
+	assert.False(t, statuses[3].Healthy)
+	assert.Contains(t, statuses[3].Error, "is not a unix socket")
+	assert.False(t, statuses[4].Healthy)
+	assert.Contains(t, statuses[4].Error, "KMS plugin socket not found")
+}
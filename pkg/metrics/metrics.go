@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+
+	"github.com/lzhecheng/kms-reporter/pkg/version"
+)
+
+const (
+	namespace = "kms_reporter"
+
+	// Run results used as the "result" label value
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	// Registry holds all kms-reporter metrics. It is served on the public metrics listener.
+	Registry = prometheus.NewRegistry()
+
+	// RunsTotal counts reporter runs by result.
+	RunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Total number of reporter runs, partitioned by result.",
+	}, []string{"result"})
+
+	// RunTimeoutsTotal counts reporter runs cancelled by the run timeout.
+	RunTimeoutsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "run_timeouts_total",
+		Help:      "Total number of reporter runs cancelled because they exceeded the run timeout.",
+	})
+
+	// RunsSkippedTotal counts reporter runs skipped because another instance held the run lock.
+	RunsSkippedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_skipped_total",
+		Help:      "Total number of reporter runs skipped because another instance held the run lock.",
+	})
+
+	// LastRunTimestamp records the unix time of the last completed run.
+	LastRunTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Unix timestamp of the last completed reporter run.",
+	})
+
+	// AlertFiring is 1 while the alert thresholds have been breached for the configured number of consecutive runs.
+	AlertFiring = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "alert_firing",
+		Help:      "Whether the alert thresholds have been breached for the configured number of consecutive runs (1) or not (0).",
+	})
+
+	// RotationComplete is 1 while every secret is encrypted by the latest provider.
+	RotationComplete = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rotation_complete",
+		Help:      "Whether every secret is encrypted by the latest provider (1) or not (0), as of the last complete scan.",
+	})
+
+	// RotationProgressPercent is the share of secrets on the latest provider.
+	RotationProgressPercent = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rotation_progress_percent",
+		Help:      "The percentage of secrets encrypted by the latest provider, as of the last complete scan.",
+	})
+
+	// RotationEstimatedCompletion is the estimated completion time of the current rotation.
+	RotationEstimatedCompletion = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rotation_estimated_completion_timestamp_seconds",
+		Help:      "The Unix time the current rotation is estimated to complete at, from the rate secrets moved to the latest provider since it started, or 0 if it cannot be estimated.",
+	})
+
+	// ScanProgress is the share of keys processed by the current paginated scan.
+	ScanProgress = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scan_progress",
+		Help:      "Share of the secrets processed by the current paginated etcd scan, from 0 to 1. It is 1 once the scan completed.",
+	})
+
+	// ScanKeys is the number of keys returned by etcd in the last scan.
+	ScanKeys = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scan_keys",
+		Help:      "Number of keys returned by etcd in the last scan.",
+	})
+
+	// ScanBytes is the size of the keys and values returned by etcd in the last scan.
+	ScanBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scan_bytes",
+		Help:      "Size in bytes of the keys and values returned by etcd in the last scan.",
+	})
+
+	// EtcdCircuitOpen is 1 while the etcd circuit breaker is open.
+	EtcdCircuitOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "etcd_circuit_open",
+		Help:      "Whether the etcd circuit breaker is open (1), skipping etcd requests after repeated failures, or closed (0).",
+	})
+
+	// EtcdRetriesTotal counts the etcd requests retried after a transient error.
+	EtcdRetriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "etcd_retries_total",
+		Help:      "Total number of etcd requests retried after a transient error, e.g. a leader change.",
+	})
+
+	// EtcdMemberRevisionLag is the number of revisions each etcd member is behind the most recent member.
+	EtcdMemberRevisionLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "etcd_member_revision_lag",
+		Help:      "The number of revisions each etcd member was behind the most recent member at the last comparison of the members.",
+	}, []string{"endpoint"})
+
+	// TransformationErrors is the number of envelope transformations the API server failed since the previous run.
+	TransformationErrors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "apiserver_transformation_errors",
+		Help:      "The number of writes the API server failed to encrypt (operation=\"write\") and reads it failed to decrypt (operation=\"read\") with the KMS provider between the last two runs.",
+	}, []string{"operation"})
+
+	// SecretsNotRewritten counts the encrypted secrets last written before the date they must have been rewritten since.
+	SecretsNotRewritten = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secrets_not_rewritten",
+		Help:      "The number of encrypted secrets last written before the date they must have been rewritten since, e.g. the last key rotation.",
+	})
+
+	// SecretsUnchanged counts the secrets whose encryption state has not changed for longer than the threshold.
+	SecretsUnchanged = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secrets_unchanged",
+		Help:      "The number of secrets observed with the same provider and key ID for longer than the configured number of days.",
+	})
+
+	// SecretsDeleted counts the secrets of the previous run that no longer exist.
+	SecretsDeleted = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secrets_deleted_since_last_run",
+		Help:      "The number of secrets observed in the previous run that the last run did not observe.",
+	})
+
+	// AuditWriteFailuresTotal counts the failed writes of audit records to an audit sink.
+	AuditWriteFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_write_failures_total",
+		Help:      "Total number of failed writes of audit records to an audit file or endpoint.",
+	})
+
+	// AttestationWriteFailuresTotal counts the attestations that could not be created or written to a sink.
+	AttestationWriteFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "attestation_write_failures_total",
+		Help:      "Total number of attestations that could not be created or written to an attestation file or endpoint.",
+	})
+
+	// BuildInfo is always 1, labeled with the build of the running binary.
+	BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "A metric with a constant '1' value labeled by the version, commit, build date and Go version of kms-reporter.",
+	}, []string{"version", "commit", "build_date", "go_version"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RunsTotal,
+		RunTimeoutsTotal,
+		RunsSkippedTotal,
+		LastRunTimestamp,
+		AlertFiring,
+		RotationComplete,
+		RotationProgressPercent,
+		RotationEstimatedCompletion,
+		ScanProgress,
+		ScanKeys,
+		ScanBytes,
+		EtcdCircuitOpen,
+		EtcdRetriesTotal,
+		EtcdMemberRevisionLag,
+		TransformationErrors,
+		SecretsNotRewritten,
+		SecretsUnchanged,
+		SecretsDeleted,
+		AuditWriteFailuresTotal,
+		AttestationWriteFailuresTotal,
+		BuildInfo,
+	)
+
+	info := version.Get()
+	BuildInfo.WithLabelValues(info.Version, info.GitCommit, info.BuildDate, info.GoVersion).Set(1)
+}
+
+// ObserveRun records the outcome of a reporter run.
+func ObserveRun(err error, timestamp float64) {
+	if err != nil {
+		RunsTotal.WithLabelValues(ResultFailure).Inc()
+	} else {
+		RunsTotal.WithLabelValues(ResultSuccess).Inc()
+	}
+	LastRunTimestamp.Set(timestamp)
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lzhecheng/kms-reporter/pkg/version"
+)
+
+func TestObserveRun(t *testing.T) {
+	successBefore := testutil.ToFloat64(RunsTotal.WithLabelValues(ResultSuccess))
+	failureBefore := testutil.ToFloat64(RunsTotal.WithLabelValues(ResultFailure))
+
+	ObserveRun(nil, 100)
+	assert.Equal(t, successBefore+1, testutil.ToFloat64(RunsTotal.WithLabelValues(ResultSuccess)))
+	assert.Equal(t, float64(100), testutil.ToFloat64(LastRunTimestamp))
+
+	ObserveRun(errors.New("run failed"), 200)
+	assert.Equal(t, failureBefore+1, testutil.ToFloat64(RunsTotal.WithLabelValues(ResultFailure)))
+	assert.Equal(t, float64(200), testutil.ToFloat64(LastRunTimestamp))
+}
+
+func TestRegistry_Gather(t *testing.T) {
+	ObserveRun(nil, 1)
+
+	families, err := Registry.Gather()
+	assert.NoError(t, err)
+
+	names := map[string]bool{}
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	assert.True(t, names["kms_reporter_runs_total"])
+	assert.True(t, names["kms_reporter_last_run_timestamp_seconds"])
+	assert.True(t, names["kms_reporter_build_info"])
+}
+
+func TestBuildInfo(t *testing.T) {
+	info := version.Get()
+	assert.Equal(t, float64(1), testutil.ToFloat64(BuildInfo.WithLabelValues(info.Version, info.GitCommit, info.BuildDate, info.GoVersion)))
+}
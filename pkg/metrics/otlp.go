@@ -0,0 +1,242 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/version"
+)
+
+const (
+	// DefaultOTLPInterval is the default interval between two OTLP exports.
+	DefaultOTLPInterval = time.Minute
+
+	otlpTimeout     = 10 * time.Second
+	otlpServiceName = "kms-reporter"
+
+	// OTLP aggregation temporality of Prometheus counters and histograms
+	otlpTemporalityCumulative = 2
+)
+
+// OTLPEndpointFromEnv returns the OTLP/HTTP metrics endpoint configured by the standard OpenTelemetry
+// environment variables: OTEL_EXPORTER_OTLP_METRICS_ENDPOINT as is, or OTEL_EXPORTER_OTLP_ENDPOINT
+// with the /v1/metrics path appended.
+func OTLPEndpointFromEnv() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/v1/metrics"
+	}
+	return ""
+}
+
+// OTLPHeadersFromEnv parses the OTEL_EXPORTER_OTLP_HEADERS environment variable ("key1=value1,key2=value2").
+func OTLPHeadersFromEnv() map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if key = strings.TrimSpace(key); found && key != "" {
+			headers[key] = strings.TrimSpace(value)
+		}
+	}
+	return headers
+}
+
+// OTLPExporter periodically pushes the metrics of a registry to an OpenTelemetry collector,
+// using OTLP/HTTP with the JSON encoding.
+type OTLPExporter struct {
+	// Endpoint is the URL metrics are posted to, e.g. http://collector:4318/v1/metrics.
+	Endpoint string
+	// Headers are added to every request, e.g. for authentication.
+	Headers map[string]string
+	// Interval is the time between two exports. Defaults to DefaultOTLPInterval.
+	Interval time.Duration
+	// Gatherer is the source of the metrics. Defaults to Registry.
+	Gatherer prometheus.Gatherer
+	// Client sends the requests. Defaults to a client with a 10s timeout.
+	Client *http.Client
+
+	startTime time.Time
+}
+
+// Run exports the metrics every Interval until ctx is cancelled, then exports them a last time.
+func (e *OTLPExporter) Run(ctx context.Context) {
+	interval := e.Interval
+	if interval <= 0 {
+		interval = DefaultOTLPInterval
+	}
+	klog.InfoS("Exporting metrics with OTLP", "endpoint", e.Endpoint, "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Flush the final values, e.g. of the last run, before exiting
+			flushCtx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+			defer cancel()
+			if err := e.Export(flushCtx); err != nil {
+				klog.ErrorS(err, "Failed to export metrics with OTLP")
+			}
+			return
+		case <-ticker.C:
+			if err := e.Export(ctx); err != nil {
+				klog.ErrorS(err, "Failed to export metrics with OTLP")
+			}
+		}
+	}
+}
+
+// Export gathers the metrics and posts them to the endpoint once.
+func (e *OTLPExporter) Export(ctx context.Context) error {
+	gatherer := e.Gatherer
+	if gatherer == nil {
+		gatherer = Registry
+	}
+	if e.startTime.IsZero() {
+		e.startTime = time.Now()
+	}
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+	body, err := json.Marshal(otlpRequest(families, e.startTime, time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to marshal OTLP request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: otlpTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post metrics to %s: %w", e.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("OTLP endpoint %s returned %s: %s", e.Endpoint, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// otlpRequest converts Prometheus metric families into an OTLP ExportMetricsServiceRequest, in the
+// protobuf JSON mapping OTLP/HTTP uses: 64-bit integers are strings and field names are camelCase.
+func otlpRequest(families []*dto.MetricFamily, startTime, now time.Time) map[string]any {
+	start := strconv.FormatInt(startTime.UnixNano(), 10)
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+
+	var metrics []map[string]any
+	for _, family := range families {
+		metric := map[string]any{"name": family.GetName(), "description": family.GetHelp()}
+		var points []map[string]any
+		for _, m := range family.GetMetric() {
+			point := map[string]any{"attributes": otlpAttributes(m.GetLabel()), "timeUnixNano": timestamp}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				point["startTimeUnixNano"] = start
+				point["asDouble"] = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				point["asDouble"] = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				point["asDouble"] = m.GetUntyped().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				histogram := m.GetHistogram()
+				point["startTimeUnixNano"] = start
+				point["count"] = strconv.FormatUint(histogram.GetSampleCount(), 10)
+				point["sum"] = histogram.GetSampleSum()
+				// Prometheus buckets are cumulative, OTLP buckets are not and end with the +Inf bucket
+				var bounds []float64
+				var counts []string
+				var previous uint64
+				for _, bucket := range histogram.GetBucket() {
+					if math.IsInf(bucket.GetUpperBound(), 1) {
+						continue
+					}
+					bounds = append(bounds, bucket.GetUpperBound())
+					counts = append(counts, strconv.FormatUint(bucket.GetCumulativeCount()-previous, 10))
+					previous = bucket.GetCumulativeCount()
+				}
+				point["explicitBounds"] = bounds
+				point["bucketCounts"] = append(counts, strconv.FormatUint(histogram.GetSampleCount()-previous, 10))
+			case dto.MetricType_SUMMARY:
+				summary := m.GetSummary()
+				point["startTimeUnixNano"] = start
+				point["count"] = strconv.FormatUint(summary.GetSampleCount(), 10)
+				point["sum"] = summary.GetSampleSum()
+				var quantiles []map[string]any
+				for _, q := range summary.GetQuantile() {
+					quantiles = append(quantiles, map[string]any{"quantile": q.GetQuantile(), "value": q.GetValue()})
+				}
+				point["quantileValues"] = quantiles
+			default:
+				continue
+			}
+			points = append(points, point)
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			metric["sum"] = map[string]any{"dataPoints": points, "aggregationTemporality": otlpTemporalityCumulative, "isMonotonic": true}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			metric["gauge"] = map[string]any{"dataPoints": points}
+		case dto.MetricType_HISTOGRAM:
+			metric["histogram"] = map[string]any{"dataPoints": points, "aggregationTemporality": otlpTemporalityCumulative}
+		case dto.MetricType_SUMMARY:
+			metric["summary"] = map[string]any{"dataPoints": points}
+		default:
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return map[string]any{
+		"resourceMetrics": []map[string]any{{
+			"resource": map[string]any{"attributes": []map[string]any{
+				otlpAttribute("service.name", otlpServiceName),
+				otlpAttribute("service.version", version.Get().Version),
+			}},
+			"scopeMetrics": []map[string]any{{
+				"scope":   map[string]any{"name": otlpServiceName, "version": version.Get().Version},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(labels []*dto.LabelPair) []map[string]any {
+	attributes := make([]map[string]any, 0, len(labels))
+	for _, label := range labels {
+		attributes = append(attributes, otlpAttribute(label.GetName(), label.GetValue()))
+	}
+	return attributes
+}
+
+func otlpAttribute(key, value string) map[string]any {
+	return map[string]any{"key": key, "value": map[string]any{"stringValue": value}}
+}
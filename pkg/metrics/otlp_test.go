@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOTLPExporter_Export(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_runs_total", Help: "Runs."}, []string{"result"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_progress", Help: "Progress."})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Help: "Duration.", Buckets: []float64{1, 10}})
+	registry.MustRegister(counter, gauge, histogram)
+	counter.WithLabelValues("success").Add(3)
+	gauge.Set(0.5)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+	histogram.Observe(50)
+
+	var request struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []map[string]json.RawMessage `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	var header http.Header
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		assert.Equal(t, "/v1/metrics", r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+	}))
+	defer collector.Close()
+
+	exporter := &OTLPExporter{Endpoint: collector.URL + "/v1/metrics", Headers: map[string]string{"Authorization": "Bearer token"}, Gatherer: registry}
+	require.NoError(t, exporter.Export(context.Background()))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, "Bearer token", header.Get("Authorization"))
+
+	metrics := map[string]map[string]json.RawMessage{}
+	for _, metric := range request.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		var name string
+		require.NoError(t, json.Unmarshal(metric["name"], &name))
+		metrics[name] = metric
+	}
+	assert.JSONEq(t, `{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[{"attributes":[{"key":"result","value":{"stringValue":"success"}}],"asDouble":3}]}`,
+		withoutTimestamps(t, metrics["test_runs_total"]["sum"]))
+	assert.JSONEq(t, `{"dataPoints":[{"attributes":[],"asDouble":0.5}]}`, withoutTimestamps(t, metrics["test_progress"]["gauge"]))
+	assert.JSONEq(t, `{"aggregationTemporality":2,"dataPoints":[{"attributes":[],"count":"3","sum":55.5,"explicitBounds":[1,10],"bucketCounts":["1","1","1"]}]}`,
+		withoutTimestamps(t, metrics["test_duration_seconds"]["histogram"]))
+}
+
+// withoutTimestamps drops the timestamps of the data points of an OTLP metric.
+func withoutTimestamps(t *testing.T, data json.RawMessage) string {
+	var metric map[string]any
+	require.NoError(t, json.Unmarshal(data, &metric))
+	for _, point := range metric["dataPoints"].([]any) {
+		delete(point.(map[string]any), "timeUnixNano")
+		delete(point.(map[string]any), "startTimeUnixNano")
+	}
+	out, err := json.Marshal(metric)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestOTLPExporter_ExportError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer collector.Close()
+
+	err := (&OTLPExporter{Endpoint: collector.URL}).Export(context.Background())
+	assert.ErrorContains(t, err, "429 Too Many Requests: quota exceeded")
+}
+
+func TestOTLPFromEnv(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	assert.Equal(t, "http://collector:4318/v1/metrics", OTLPEndpointFromEnv())
+	t.Setenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", "http://metrics:4318/custom")
+	assert.Equal(t, "http://metrics:4318/custom", OTLPEndpointFromEnv())
+
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=secret, tenant = a,invalid")
+	assert.Equal(t, map[string]string{"api-key": "secret", "tenant": "a"}, OTLPHeadersFromEnv())
+}
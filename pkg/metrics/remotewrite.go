@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/lzhecheng/kms-reporter/pkg/version"
+)
+
+const remoteWriteTimeout = 30 * time.Second
+
+// Sample is a single value of a time series written with remote-write.
+type Sample struct {
+	// Name is the metric name, e.g. kms_reporter_encrypted_secrets.
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// RemoteWriteConfig configures a Prometheus remote-write client.
+type RemoteWriteConfig struct {
+	// Endpoint is the remote-write URL, e.g. https://prometheus.example.com/api/v1/write.
+	Endpoint string
+	// Username and PasswordFile enable basic authentication. The password is read from the file on every write.
+	Username     string
+	PasswordFile string
+	// CAFile verifies the server certificate instead of the system roots. Optional.
+	CAFile string
+	// CertFile and KeyFile are a client certificate presented to the server. Optional.
+	CertFile string
+	KeyFile  string
+	// ExternalLabels are added to every series, e.g. cluster="prod-1" to tell clusters apart centrally.
+	ExternalLabels map[string]string
+}
+
+// RemoteWriter sends samples to a Prometheus remote-write endpoint, using the snappy-compressed
+// protobuf format of remote-write 1.0.
+type RemoteWriter struct {
+	config RemoteWriteConfig
+	client *http.Client
+}
+
+// NewRemoteWriter returns a remote-write client. The TLS files are loaded once.
+func NewRemoteWriter(config RemoteWriteConfig) (*RemoteWriter, error) {
+	if config.Endpoint == "" {
+		return nil, fmt.Errorf("remote-write endpoint is required")
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, fmt.Errorf("remote-write client certificate and key must be set together")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CAFile != "" {
+		caCert, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read remote-write CA certificate: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+			return nil, fmt.Errorf("failed to append remote-write CA certificate to pool")
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load remote-write client certificate and key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &RemoteWriter{
+		config: config,
+		client: &http.Client{Transport: transport, Timeout: remoteWriteTimeout},
+	}, nil
+}
+
+// Write sends the samples, all at timestamp, in a single request.
+func (w *RemoteWriter) Write(ctx context.Context, samples []Sample, timestamp time.Time) error {
+	body := snappy.Encode(nil, w.encode(samples, timestamp))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create remote-write request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "kms-reporter/"+version.Get().Version)
+	if w.config.Username != "" {
+		password, err := os.ReadFile(w.config.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read remote-write password: %w", err)
+		}
+		req.SetBasicAuth(w.config.Username, strings.TrimSpace(string(password)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to remote-write to %s: %w", w.config.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("remote-write endpoint %s returned %s: %s", w.config.Endpoint, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// encode marshals the samples into a prometheus.WriteRequest: one time series per sample, with
+// labels sorted by name as remote-write requires.
+func (w *RemoteWriter) encode(samples []Sample, timestamp time.Time) []byte {
+	var request []byte
+	for _, sample := range samples {
+		labels := map[string]string{}
+		for name, value := range w.config.ExternalLabels {
+			labels[name] = value
+		}
+		for name, value := range sample.Labels {
+			labels[name] = value
+		}
+		labels["__name__"] = sample.Name
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var series []byte
+		for _, name := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, labels[name])
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var point []byte
+		point = protowire.AppendTag(point, 1, protowire.Fixed64Type)
+		point = protowire.AppendFixed64(point, math.Float64bits(sample.Value))
+		point = protowire.AppendTag(point, 2, protowire.VarintType)
+		point = protowire.AppendVarint(point, uint64(timestamp.UnixMilli()))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, point)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+	return request
+}
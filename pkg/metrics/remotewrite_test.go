@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// writtenSeries is a decoded time series of a remote-write request
+type writtenSeries struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+// decodeWriteRequest decodes the fields of a prometheus.WriteRequest the writer sets
+func decodeWriteRequest(t *testing.T, data []byte) []writtenSeries {
+	fields := func(data []byte, visit func(num protowire.Number, typ protowire.Type, value []byte, varint uint64)) {
+		for len(data) > 0 {
+			num, typ, n := protowire.ConsumeTag(data)
+			require.GreaterOrEqual(t, n, 0)
+			data = data[n:]
+			switch typ {
+			case protowire.BytesType:
+				value, n := protowire.ConsumeBytes(data)
+				require.GreaterOrEqual(t, n, 0)
+				visit(num, typ, value, 0)
+				data = data[n:]
+			case protowire.Fixed64Type:
+				value, n := protowire.ConsumeFixed64(data)
+				require.GreaterOrEqual(t, n, 0)
+				visit(num, typ, nil, value)
+				data = data[n:]
+			case protowire.VarintType:
+				value, n := protowire.ConsumeVarint(data)
+				require.GreaterOrEqual(t, n, 0)
+				visit(num, typ, nil, value)
+				data = data[n:]
+			default:
+				t.Fatalf("unexpected wire type %d", typ)
+			}
+		}
+	}
+
+	var result []writtenSeries
+	fields(data, func(_ protowire.Number, _ protowire.Type, seriesData []byte, _ uint64) {
+		series := writtenSeries{labels: map[string]string{}}
+		var names []string
+		fields(seriesData, func(num protowire.Number, _ protowire.Type, value []byte, _ uint64) {
+			switch num {
+			case 1:
+				var name, labelValue string
+				fields(value, func(num protowire.Number, _ protowire.Type, value []byte, _ uint64) {
+					if num == 1 {
+						name = string(value)
+					} else {
+						labelValue = string(value)
+					}
+				})
+				names = append(names, name)
+				series.labels[name] = labelValue
+			case 2:
+				fields(value, func(num protowire.Number, _ protowire.Type, _ []byte, varint uint64) {
+					if num == 1 {
+						series.value = math.Float64frombits(varint)
+					} else {
+						series.timestamp = int64(varint)
+					}
+				})
+			}
+		})
+		assert.IsIncreasing(t, names, "labels must be sorted by name")
+		result = append(result, series)
+	})
+	return result
+}
+
+func TestRemoteWriter_Write(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0o600))
+
+	var series []writtenSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "reporter", username)
+		assert.Equal(t, "secret", password)
+
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		data, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		series = decodeWriteRequest(t, data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer, err := NewRemoteWriter(RemoteWriteConfig{
+		Endpoint:       server.URL,
+		Username:       "reporter",
+		PasswordFile:   passwordFile,
+		ExternalLabels: map[string]string{"cluster": "prod-1"},
+	})
+	require.NoError(t, err)
+
+	timestamp := time.UnixMilli(1700000000000)
+	err = writer.Write(context.Background(), []Sample{
+		{Name: "kms_reporter_unencrypted_secrets", Value: 3},
+		{Name: "kms_reporter_provider_secrets", Labels: map[string]string{"provider": "kms-v2"}, Value: 2},
+	}, timestamp)
+	require.NoError(t, err)
+
+	assert.Equal(t, []writtenSeries{
+		{labels: map[string]string{"__name__": "kms_reporter_unencrypted_secrets", "cluster": "prod-1"}, value: 3, timestamp: 1700000000000},
+		{labels: map[string]string{"__name__": "kms_reporter_provider_secrets", "cluster": "prod-1", "provider": "kms-v2"}, value: 2, timestamp: 1700000000000},
+	}, series)
+}
+
+func TestRemoteWriter_Write_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	writer, err := NewRemoteWriter(RemoteWriteConfig{Endpoint: server.URL})
+	require.NoError(t, err)
+	err = writer.Write(context.Background(), []Sample{{Name: "kms_reporter_encrypted_secrets", Value: 1}}, time.Now())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "out of order sample")
+}
+
+func TestNewRemoteWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      RemoteWriteConfig
+		expectedErr string
+	}{
+		{
+			name:        "missing endpoint",
+			config:      RemoteWriteConfig{},
+			expectedErr: "remote-write endpoint is required",
+		},
+		{
+			name:        "certificate without key",
+			config:      RemoteWriteConfig{Endpoint: "https://prometheus", CertFile: "tls.crt"},
+			expectedErr: "must be set together",
+		},
+		{
+			name:        "missing CA file",
+			config:      RemoteWriteConfig{Endpoint: "https://prometheus", CAFile: "/nonexistent/ca.crt"},
+			expectedErr: "failed to read remote-write CA certificate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRemoteWriter(tt.config)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultStatsDPrefix is prepended to the name of every StatsD metric.
+	DefaultStatsDPrefix = "kms_reporter"
+
+	// maxStatsDPacketSize keeps packets within the MTU of common networks
+	maxStatsDPacketSize = 1432
+)
+
+// StatsDConfig configures a StatsD emitter.
+type StatsDConfig struct {
+	// Address is the UDP address of the StatsD server, e.g. localhost:8125.
+	Address string
+	// Prefix is prepended to every metric name. Defaults to DefaultStatsDPrefix.
+	Prefix string
+	// DogStatsD enables the DogStatsD tag extension. Without it, tags are appended to the metric name.
+	DogStatsD bool
+	// Tags are added to every metric, as "key:value" pairs. Only sent with DogStatsD.
+	Tags []string
+}
+
+// StatsD sends gauges to a StatsD or DogStatsD server over UDP. Sending is best effort:
+// UDP gives no delivery guarantee and write errors are returned but not retried.
+type StatsD struct {
+	config StatsDConfig
+	mu     sync.Mutex
+	conn   net.Conn
+	lines  []string
+}
+
+// NewStatsD returns an emitter sending to config.Address.
+func NewStatsD(config StatsDConfig) (*StatsD, error) {
+	if config.Prefix == "" {
+		config.Prefix = DefaultStatsDPrefix
+	}
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial StatsD server %s: %w", config.Address, err)
+	}
+	return &StatsD{config: config, conn: conn}, nil
+}
+
+// Gauge queues a gauge. tags are "key:value" pairs; without DogStatsD their values are appended
+// to the name in key order, e.g. prefix.name.value. Queued metrics are sent by Flush.
+func (s *StatsD) Gauge(name string, value float64, tags ...string) {
+	line := s.config.Prefix + "." + name
+	if !s.config.DogStatsD {
+		sorted := append([]string{}, tags...)
+		sort.Strings(sorted)
+		for _, tag := range sorted {
+			_, tagValue, _ := strings.Cut(tag, ":")
+			line += "." + sanitizeStatsD(tagValue)
+		}
+	}
+	line += ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|g"
+	if s.config.DogStatsD {
+		var allTags []string
+		for _, tag := range append(append([]string{}, s.config.Tags...), tags...) {
+			allTags = append(allTags, sanitizeDogStatsDTag(tag))
+		}
+		if len(allTags) > 0 {
+			line += "|#" + strings.Join(allTags, ",")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, line)
+}
+
+// Flush sends the queued metrics, several per packet.
+func (s *StatsD) Flush() error {
+	s.mu.Lock()
+	lines := s.lines
+	s.lines = nil
+	s.mu.Unlock()
+
+	var packet []byte
+	send := func() error {
+		if len(packet) == 0 {
+			return nil
+		}
+		_, err := s.conn.Write(packet)
+		packet = packet[:0]
+		if err != nil {
+			return fmt.Errorf("failed to send StatsD metrics: %w", err)
+		}
+		return nil
+	}
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > maxStatsDPacketSize {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	return send()
+}
+
+// Close closes the connection to the server.
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+// sanitizeStatsD replaces the characters StatsD uses as separators in metric names.
+func sanitizeStatsD(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// sanitizeDogStatsDTag replaces the characters DogStatsD uses as separators in tags. The colon
+// separating the key from the value is kept.
+func sanitizeDogStatsDTag(tag string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '|', '#', ',', '\n':
+			return '_'
+		}
+		return r
+	}, tag)
+}
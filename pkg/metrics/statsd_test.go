@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsD(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   StatsDConfig
+		expected []string
+	}{
+		{
+			name:   "plain StatsD appends tag values to names",
+			config: StatsDConfig{Tags: []string{"cluster:dev"}},
+			expected: []string{
+				"kms_reporter.secrets.unencrypted:3|g",
+				"kms_reporter.secrets.provider.kms_v2_key_1:2.5|g",
+			},
+		},
+		{
+			name:   "DogStatsD sends tags",
+			config: StatsDConfig{Prefix: "edge", DogStatsD: true, Tags: []string{"cluster:dev"}},
+			expected: []string{
+				"edge.secrets.unencrypted:3|g|#cluster:dev",
+				"edge.secrets.provider:2.5|g|#cluster:dev,provider:kms v2:key_1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer server.Close()
+
+			tt.config.Address = server.LocalAddr().String()
+			statsd, err := NewStatsD(tt.config)
+			require.NoError(t, err)
+			defer statsd.Close()
+
+			statsd.Gauge("secrets.unencrypted", 3)
+			statsd.Gauge("secrets.provider", 2.5, "provider:kms v2:key|1")
+			require.NoError(t, statsd.Flush())
+
+			buffer := make([]byte, maxStatsDPacketSize)
+			require.NoError(t, server.SetReadDeadline(time.Now().Add(5*time.Second)))
+			n, _, err := server.ReadFrom(buffer)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, strings.Split(string(buffer[:n]), "\n"))
+		})
+	}
+}
+
+func TestStatsD_Flush_SplitsPackets(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+
+	statsd, err := NewStatsD(StatsDConfig{Address: server.LocalAddr().String()})
+	require.NoError(t, err)
+	defer statsd.Close()
+
+	name := strings.Repeat("n", 1000)
+	statsd.Gauge(name, 1)
+	statsd.Gauge(name, 2)
+	require.NoError(t, statsd.Flush())
+
+	buffer := make([]byte, 2*maxStatsDPacketSize)
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for _, value := range []string{"1", "2"} {
+		n, _, err := server.ReadFrom(buffer)
+		require.NoError(t, err)
+		assert.Equal(t, "kms_reporter."+name+":"+value+"|g", string(buffer[:n]))
+	}
+}
@@ -0,0 +1,191 @@
+// Package notifier sends notifications about scan results to an HTTP endpoint. The payload is rendered
+// from a Go template over the result, so that any system accepting webhooks, e.g. Opsgenie or Mattermost,
+// can be integrated without a dedicated client.
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/version"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Events a notification is sent for
+const (
+	// EventAlertFiring is sent when the alert thresholds start firing.
+	EventAlertFiring = "AlertFiring"
+	// EventAlertResolved is sent when a firing alert no longer breaches.
+	EventAlertResolved = "AlertResolved"
+	// EventRotationComplete is sent when every secret becomes encrypted by the latest provider.
+	EventRotationComplete = "RotationComplete"
+)
+
+// DefaultTemplate renders a JSON object summarizing the notification.
+const DefaultTemplate = `{"event":{{json .Event}},"message":{{json .Message}},"time":{{json .Time}},` +
+	`"encrypted":{{.Result.EncryptedCount}},"unencrypted":{{.Result.UnencryptedCount}},` +
+	`"unrecognized":{{.Result.UnrecognizedCount}},"latestProvider":{{json .Result.LatestProvider.Name}},` +
+	`"allSecretsUseLatestProvider":{{.Result.AllSecretsUseLatestProvider}}}`
+
+// Config configures a notifier.
+type Config struct {
+	// URL is the endpoint notifications are sent to.
+	URL string
+	// Method is the HTTP method of the requests. Defaults to POST.
+	Method string
+	// Template is the text/template the request body is rendered from, over a Notification.
+	// Defaults to DefaultTemplate.
+	Template string
+	// ContentType is the content type of the request body. Defaults to application/json.
+	ContentType string
+	// Headers are added to every request.
+	Headers map[string]string
+	// AuthorizationFile holds the value of the Authorization header, e.g. "Bearer <token>". It is read
+	// on every notification, so that rotated credentials are picked up. Optional.
+	AuthorizationFile string
+	// Timeout bounds each request. Defaults to 10s.
+	Timeout time.Duration
+}
+
+// Notification is the data the template is rendered over.
+type Notification struct {
+	// Event is what happened, e.g. EventAlertFiring.
+	Event string
+	// Message describes the event for humans.
+	Message string
+	// Time is when the event was observed.
+	Time time.Time
+	// Result is the analysis result the event was observed on.
+	Result analyzer.Result
+}
+
+// Sender sends notifications, e.g. a Notifier.
+type Sender interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// multiSender sends notifications with each of its senders.
+type multiSender []Sender
+
+// Join returns a Sender sending every notification with each of senders, or nil if there are none.
+// A failing sender does not prevent the following ones from sending; the errors of all failures are joined.
+func Join(senders ...Sender) Sender {
+	switch len(senders) {
+	case 0:
+		return nil
+	case 1:
+		return senders[0]
+	}
+	return multiSender(senders)
+}
+
+func (m multiSender) Notify(ctx context.Context, notification Notification) error {
+	var errs []error
+	for _, sender := range m {
+		errs = append(errs, sender.Notify(ctx, notification))
+	}
+	return errors.Join(errs...)
+}
+
+// Notifier renders notifications with its template and sends them to its endpoint.
+type Notifier struct {
+	config   Config
+	template *template.Template
+	client   *http.Client
+}
+
+// New returns a notifier for config. The template is parsed once.
+func New(config Config) (*Notifier, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("notifier URL is required")
+	}
+	if config.Method == "" {
+		config.Method = http.MethodPost
+	}
+	if config.Template == "" {
+		config.Template = DefaultTemplate
+	}
+	if config.ContentType == "" {
+		config.ContentType = "application/json"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	tmpl, err := template.New("notification").Funcs(template.FuncMap{
+		"json": toJSON,
+		"join": strings.Join,
+	}).Parse(config.Template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse notifier template: %w", err)
+	}
+	return &Notifier{
+		config:   config,
+		template: tmpl,
+		client:   &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+// Render returns the request body of notification.
+func (n *Notifier) Render(notification Notification) ([]byte, error) {
+	var body bytes.Buffer
+	if err := n.template.Execute(&body, notification); err != nil {
+		return nil, fmt.Errorf("failed to render notification: %w", err)
+	}
+	return body.Bytes(), nil
+}
+
+// Notify renders notification and sends it in a single request.
+func (n *Notifier) Notify(ctx context.Context, notification Notification) error {
+	body, err := n.Render(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, n.config.Method, n.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", n.config.ContentType)
+	req.Header.Set("User-Agent", "kms-reporter/"+version.Get().Version)
+	for name, value := range n.config.Headers {
+		req.Header.Set(name, value)
+	}
+	if n.config.AuthorizationFile != "" {
+		authorization, err := os.ReadFile(n.config.AuthorizationFile)
+		if err != nil {
+			return fmt.Errorf("failed to read notifier authorization: %w", err)
+		}
+		req.Header.Set("Authorization", strings.TrimSpace(string(authorization)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification to %s: %w", n.config.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("notifier endpoint %s returned %s: %s", n.config.URL, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// toJSON marshals value for embedding in a JSON template, e.g. a quoted and escaped string.
+func toJSON(value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,138 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+func notification() Notification {
+	return Notification{
+		Event:   EventAlertFiring,
+		Message: `1 unencrypted secret exceeds the maximum of "0"`,
+		Time:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Result: analyzer.Result{
+			EncryptedSecrets:            []string{"default/a"},
+			UnencryptedSecrets:          []string{"default/b"},
+			LatestProvider:              analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2},
+			AllSecretsUseLatestProvider: false,
+		},
+	}
+}
+
+func TestNotifier_Render(t *testing.T) {
+	testCases := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{
+			name: "default template",
+			expected: `{"event":"AlertFiring","message":"1 unencrypted secret exceeds the maximum of \"0\"","time":"2025-01-01T00:00:00Z",` +
+				`"encrypted":1,"unencrypted":1,"unrecognized":0,"latestProvider":"kmsprovider2","allSecretsUseLatestProvider":false}`,
+		},
+		{
+			name:     "custom template",
+			template: `{{.Event}}: {{join .Result.UnencryptedSecrets ", "}} not on {{.Result.LatestProvider.Name}}`,
+			expected: "AlertFiring: default/b not on kmsprovider2",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := New(Config{URL: "http://localhost", Template: tc.template})
+			require.NoError(t, err)
+			body, err := n.Render(notification())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(body))
+		})
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	_, err := New(Config{})
+	assert.ErrorContains(t, err, "notifier URL is required")
+	_, err = New(Config{URL: "http://localhost", Template: "{{.Event"})
+	assert.ErrorContains(t, err, "failed to parse notifier template")
+
+	// Fields missing from Notification fail when rendering
+	n, err := New(Config{URL: "http://localhost", Template: "{{.Cluster}}"})
+	require.NoError(t, err)
+	_, err = n.Render(notification())
+	assert.ErrorContains(t, err, "failed to render notification")
+}
+
+func TestNotifier_Notify(t *testing.T) {
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	authorizationFile := filepath.Join(t.TempDir(), "authorization")
+	require.NoError(t, os.WriteFile(authorizationFile, []byte("Bearer secret\n"), 0o600))
+
+	n, err := New(Config{
+		URL:               server.URL,
+		Method:            http.MethodPut,
+		Template:          "text={{.Message}}",
+		ContentType:       "text/plain",
+		Headers:           map[string]string{"X-Team": "platform"},
+		AuthorizationFile: authorizationFile,
+	})
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), notification()))
+
+	assert.Equal(t, http.MethodPut, received.Method)
+	assert.Equal(t, "text/plain", received.Header.Get("Content-Type"))
+	assert.Equal(t, "platform", received.Header.Get("X-Team"))
+	assert.Equal(t, "Bearer secret", received.Header.Get("Authorization"))
+	assert.Equal(t, `text=1 unencrypted secret exceeds the maximum of "0"`, string(body))
+}
+
+func TestNotifier_Notify_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	n, err := New(Config{URL: server.URL})
+	require.NoError(t, err)
+	err = n.Notify(context.Background(), notification())
+	assert.ErrorContains(t, err, "returned 400 Bad Request: invalid payload")
+}
+
+// senderFunc adapts a function to a Sender
+type senderFunc func(ctx context.Context, notification Notification) error
+
+func (f senderFunc) Notify(ctx context.Context, notification Notification) error {
+	return f(ctx, notification)
+}
+
+func TestJoin(t *testing.T) {
+	assert.Nil(t, Join())
+
+	var sent []string
+	sender := func(name string, err error) Sender {
+		return senderFunc(func(ctx context.Context, notification Notification) error {
+			sent = append(sent, name)
+			return err
+		})
+	}
+	// A failing sender does not prevent the next one from sending
+	joined := Join(sender("first", errors.New("endpoint unavailable")), sender("second", nil))
+	assert.EqualError(t, joined.Notify(context.Background(), notification()), "endpoint unavailable")
+	assert.Equal(t, []string{"first", "second"}, sent)
+}
@@ -0,0 +1,101 @@
+// Package plugin records reports with out-of-tree executables, so that proprietary sinks can be added
+// without patching the reporter. A plugin is run once per report and run status, with a Request as JSON
+// on its standard input, and succeeds by exiting with status 0.
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/lzhecheng/kms-reporter/pkg/execjson"
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+)
+
+// APIVersion is the version of the Request contract. It changes when a field is removed or its meaning
+// changes, not when fields are added.
+const APIVersion = "kms-reporter/plugin.v1"
+
+// Methods of a Request
+const (
+	// MethodRecord records the report of a successful run.
+	MethodRecord = "Record"
+	// MethodRecordRunStatus records the outcome of a run.
+	MethodRecordRunStatus = "RecordRunStatus"
+)
+
+// DefaultTimeout bounds a run of a plugin.
+const DefaultTimeout = 30 * time.Second
+
+// Request is written as JSON to the standard input of a plugin.
+type Request struct {
+	// APIVersion is the version of the contract, APIVersion.
+	APIVersion string `json:"apiVersion"`
+	// Method is MethodRecord or MethodRecordRunStatus.
+	Method string `json:"method"`
+	// Namespace is the namespace of the report.
+	Namespace string `json:"namespace"`
+	// Report is the report to record, set for MethodRecord. Its fields are those of recorder.Report, so
+	// that plugins written in Go can decode it into one.
+	Report *recorder.Report `json:"report,omitempty"`
+	// RunError is the error of a failed run, set for MethodRecordRunStatus. It is empty if the run succeeded.
+	RunError string `json:"runError,omitempty"`
+	// FinishedAt is when the run finished, set for MethodRecordRunStatus.
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Config configures a plugin.
+type Config struct {
+	// Command is the executable and its arguments. It is run directly, not through a shell.
+	Command []string
+	// Timeout bounds each run, after which the plugin is killed. Defaults to DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Recorder is a recorder.RecorderOperator running its plugin for every report and run status.
+type Recorder struct {
+	config Config
+}
+
+func New(config Config) (*Recorder, error) {
+	if len(config.Command) == 0 || config.Command[0] == "" {
+		return nil, fmt.Errorf("plugin command is required")
+	}
+	// Fail at startup rather than at the first report
+	if _, err := exec.LookPath(config.Command[0]); err != nil {
+		return nil, fmt.Errorf("invalid plugin command: %w", err)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return &Recorder{config: config}, nil
+}
+
+// Record runs the plugin with the report.
+func (r *Recorder) Record(ctx context.Context, namespace string, report recorder.Report) error {
+	return r.run(ctx, Request{Method: MethodRecord, Namespace: namespace, Report: &report})
+}
+
+// RecordRunStatus runs the plugin with the outcome of the run.
+func (r *Recorder) RecordRunStatus(ctx context.Context, namespace string, runErr error, finishedAt time.Time) error {
+	request := Request{Method: MethodRecordRunStatus, Namespace: namespace, FinishedAt: &finishedAt}
+	if runErr != nil {
+		request.RunError = runErr.Error()
+	}
+	return r.run(ctx, request)
+}
+
+// run runs the plugin with request as JSON on its standard input, and waits for it to exit. A plugin
+// exiting with a non-zero status fails with its output.
+func (r *Recorder) run(ctx context.Context, request Request) error {
+	request.APIVersion = APIVersion
+	if err := execjson.Run(ctx, r.config.Command, r.config.Timeout, request); err != nil {
+		if errors.Is(err, execjson.ErrTimeout) {
+			return fmt.Errorf("plugin %s %w", r.config.Command[0], err)
+		}
+		return fmt.Errorf("plugin %s failed to %s: %w", r.config.Command[0], request.Method, err)
+	}
+	return nil
+}
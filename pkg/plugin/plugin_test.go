@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{})
+	assert.ErrorContains(t, err, "plugin command is required")
+
+	_, err = New(Config{Command: []string{filepath.Join(t.TempDir(), "missing")}})
+	assert.ErrorContains(t, err, "invalid plugin command")
+
+	plugin, err := New(Config{Command: []string{"/bin/true"}})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTimeout, plugin.config.Timeout)
+}
+
+// newCapturingRecorder returns a plugin writing its requests to a file, and a function reading the last one
+func newCapturingRecorder(t *testing.T) (*Recorder, func() Request) {
+	output := filepath.Join(t.TempDir(), "request.json")
+	plugin, err := New(Config{Command: []string{"/bin/sh", "-c", `cat > "$0"`, output}})
+	require.NoError(t, err)
+	return plugin, func() Request {
+		data, err := os.ReadFile(output)
+		require.NoError(t, err)
+		var request Request
+		require.NoError(t, json.Unmarshal(data, &request))
+		return request
+	}
+}
+
+func TestRecorder_Record(t *testing.T) {
+	plugin, lastRequest := newCapturingRecorder(t)
+	report := recorder.NewReport([]string{"default/a"}, []string{"default/b"}, true, map[string]int{"kmsprovider1": 1, "identity": 1})
+	require.NoError(t, plugin.Record(context.Background(), "kube-system", report))
+	assert.Equal(t, Request{APIVersion: APIVersion, Method: MethodRecord, Namespace: "kube-system", Report: &report}, lastRequest())
+}
+
+func TestRecorder_RecordRunStatus(t *testing.T) {
+	plugin, lastRequest := newCapturingRecorder(t)
+	finishedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, plugin.RecordRunStatus(context.Background(), "kube-system", errors.New("etcd unavailable"), finishedAt))
+	assert.Equal(t, Request{APIVersion: APIVersion, Method: MethodRecordRunStatus, Namespace: "kube-system", RunError: "etcd unavailable", FinishedAt: &finishedAt}, lastRequest())
+
+	require.NoError(t, plugin.RecordRunStatus(context.Background(), "kube-system", nil, finishedAt))
+	assert.Empty(t, lastRequest().RunError)
+}
+
+func TestRecorder_Errors(t *testing.T) {
+	plugin, err := New(Config{Command: []string{"/bin/sh", "-c", "echo sink unavailable >&2; exit 3"}})
+	require.NoError(t, err)
+	err = plugin.Record(context.Background(), "kube-system", recorder.Report{})
+	assert.ErrorContains(t, err, "plugin /bin/sh failed to Record: exit status 3: sink unavailable")
+
+	plugin, err = New(Config{Command: []string{"/bin/sh", "-c", "sleep 10"}, Timeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	assert.ErrorContains(t, plugin.RecordRunStatus(context.Background(), "kube-system", nil, time.Now()), "plugin /bin/sh timed out after 50ms")
+}
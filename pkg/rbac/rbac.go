@@ -0,0 +1,77 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrMissingPermissions is returned by Check when at least one permission is not granted.
+var ErrMissingPermissions = errors.New("missing RBAC permissions")
+
+// Permission describes a single API access the reporter relies on.
+type Permission struct {
+	Verb      string
+	Group     string
+	Resource  string
+	Namespace string
+	Name      string
+	// NonResourceURL is the path of a non-resource request, e.g. /metrics, which the other fields
+	// but Verb do not apply to.
+	NonResourceURL string
+}
+
+func (p Permission) String() string {
+	if p.NonResourceURL != "" {
+		return fmt.Sprintf("%s %s (non-resource URL)", p.Verb, p.NonResourceURL)
+	}
+	resource := p.Resource
+	if p.Group != "" {
+		resource = p.Resource + "." + p.Group
+	}
+	if p.Name != "" {
+		resource = resource + "/" + p.Name
+	}
+	if p.Namespace != "" {
+		return fmt.Sprintf("%s %s in namespace %s", p.Verb, resource, p.Namespace)
+	}
+	return fmt.Sprintf("%s %s (cluster-scoped)", p.Verb, resource)
+}
+
+// Check performs a SelfSubjectAccessReview for each permission with the identity of clientset
+// and returns an error listing every permission that is not granted.
+func Check(ctx context.Context, clientset kubernetes.Interface, permissions []Permission) error {
+	var missing []string
+	for _, permission := range permissions {
+		review := &authorizationv1.SelfSubjectAccessReview{}
+		if permission.NonResourceURL != "" {
+			review.Spec.NonResourceAttributes = &authorizationv1.NonResourceAttributes{Path: permission.NonResourceURL, Verb: permission.Verb}
+		} else {
+			review.Spec.ResourceAttributes = &authorizationv1.ResourceAttributes{
+				Verb:      permission.Verb,
+				Group:     permission.Group,
+				Resource:  permission.Resource,
+				Namespace: permission.Namespace,
+				Name:      permission.Name,
+			}
+		}
+
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to review permission %q: %w", permission, err)
+		}
+		if !result.Status.Allowed {
+			missing = append(missing, permission.String())
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingPermissions, strings.Join(missing, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// newReviewClientset returns a clientset that allows exactly the given verb/resource pairs.
+func newReviewClientset(allowed map[string]bool, reviewErr error) *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if reviewErr != nil {
+			return true, nil, reviewErr
+		}
+		review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		if attrs := review.Spec.NonResourceAttributes; attrs != nil {
+			review.Status.Allowed = allowed[attrs.Verb+" "+attrs.Path]
+			return true, review, nil
+		}
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = allowed[attrs.Verb+" "+attrs.Resource]
+		return true, review, nil
+	})
+	return clientset
+}
+
+func TestPermission_String(t *testing.T) {
+	assert.Equal(t, "get configmaps/kms-reporter in namespace kms",
+		Permission{Verb: "get", Resource: "configmaps", Namespace: "kms", Name: "kms-reporter"}.String())
+	assert.Equal(t, "create tokenreviews.authentication.k8s.io (cluster-scoped)",
+		Permission{Verb: "create", Group: "authentication.k8s.io", Resource: "tokenreviews"}.String())
+	assert.Equal(t, "get /metrics (non-resource URL)", Permission{Verb: "get", NonResourceURL: "/metrics"}.String())
+}
+
+func TestCheck(t *testing.T) {
+	permissions := []Permission{
+		{Verb: "get", Resource: "configmaps", Namespace: "kms"},
+		{Verb: "create", Resource: "configmaps", Namespace: "kms"},
+		{Verb: "update", Resource: "configmaps", Namespace: "kms"},
+		{Verb: "get", NonResourceURL: "/metrics"},
+	}
+
+	tests := []struct {
+		name          string
+		allowed       map[string]bool
+		reviewErr     error
+		expectedError string
+		notExpected   string
+	}{
+		{
+			name:    "all permissions granted",
+			allowed: map[string]bool{"get configmaps": true, "create configmaps": true, "update configmaps": true, "get /metrics": true},
+		},
+		{
+			name:          "missing permissions are all listed",
+			allowed:       map[string]bool{"get configmaps": true},
+			expectedError: "missing RBAC permissions: create configmaps in namespace kms; update configmaps in namespace kms; get /metrics (non-resource URL)",
+			notExpected:   "get configmaps",
+		},
+		{
+			name:          "review request fails",
+			reviewErr:     errors.New("forbidden"),
+			expectedError: "failed to review permission",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(context.Background(), newReviewClientset(tt.allowed, tt.reviewErr), permissions)
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				if tt.reviewErr == nil {
+					assert.ErrorIs(t, err, ErrMissingPermissions)
+				}
+				if tt.notExpected != "" {
+					assert.NotContains(t, err.Error(), tt.notExpected)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
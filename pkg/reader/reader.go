@@ -2,31 +2,49 @@ package reader
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"regexp"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"go.etcd.io/etcd/api/v3/mvccpb"
-	clientv3 "go.etcd.io/etcd/client/v3"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
-	"sigs.k8s.io/yaml"
 
+	"github.com/lzhecheng/kms-reporter/pkg/alert"
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/attestation"
+	"github.com/lzhecheng/kms-reporter/pkg/audit"
 	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/events"
+	"github.com/lzhecheng/kms-reporter/pkg/history"
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+	"github.com/lzhecheng/kms-reporter/pkg/notifier"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/recency"
 	"github.com/lzhecheng/kms-reporter/pkg/recorder"
-	"github.com/lzhecheng/kms-reporter/pkg/utils"
+	"github.com/lzhecheng/kms-reporter/pkg/remediation"
+	"github.com/lzhecheng/kms-reporter/pkg/retention"
+	"github.com/lzhecheng/kms-reporter/pkg/rotation"
+	"github.com/lzhecheng/kms-reporter/pkg/shard"
+	"github.com/lzhecheng/kms-reporter/pkg/teams"
+	"github.com/lzhecheng/kms-reporter/pkg/transformation"
 )
 
 const (
-	secretEtcdKey                = "/registry/secrets"
 	defaultTimeout               = 5 * time.Second
+	progressLogInterval          = 10 * time.Second
 	encryptionProviderConfigName = "encryption-provider-config"
 	encryptionConfigYAMLKey      = "encryption-provider-config.yaml"
-	identityProviderSeq          = -1 // Sequence number for identity (no encryption) provider
 )
 
+// ErrEncryptionConfigNotFound is returned when the encryption-provider-config ConfigMap or its
+// encryption configuration key does not exist.
+var ErrEncryptionConfigNotFound = errors.New("encryption configuration not found")
+
 // ReaderOperator defines the interface for reading and analyzing secret encryption status from etcd.
 type ReaderOperator interface {
 	Read(ctx context.Context, namespace string) error
@@ -38,128 +56,766 @@ type ReadOperation struct {
 	etcdCli   etcd.EtcdClientOperator
 	clientset kubernetes.Interface
 	recorder.RecorderOperator
-	kmsProviderName string
+	analyzer *analyzer.Analyzer
+	config   Config
+
+	mu sync.Mutex
+	// rotationComplete is whether the previous complete result was fully encrypted by the latest
+	// provider, or nil before the first one
+	rotationComplete *bool
+	// rotation measures the rate secrets move to the latest provider, or is nil before the first result
+	rotation *rotation.Tracker
+}
+
+// Config configures how the reader scans etcd.
+type Config struct {
+	// Analyzer is the analysis configuration. When it has no LatestProvider resolver,
+	// the latest provider is read from the encryption-provider-config ConfigMap.
+	Analyzer analyzer.Config
+	// Shard selects the part of the key space this replica scans.
+	Shard shard.Config
+	// ShardStore holds the partial results of the shards. Required when sharding is enabled.
+	ShardStore shard.Store
+	// KubeRequestTimeout bounds each Kubernetes API call of the reader. Defaults to 5s.
+	KubeRequestTimeout time.Duration
+	// Alerts evaluates the alert thresholds against every complete result. Optional.
+	Alerts *alert.Evaluator
+	// StatsD receives the summary of every complete result. Optional.
+	StatsD *metrics.StatsD
+	// RemoteWrite receives the summary series of every complete result. Optional.
+	RemoteWrite *metrics.RemoteWriter
+	// TargetProvider is compared against instead of the latest provider of the encryption configuration,
+	// e.g. during a staged rollout whose configuration still lists the old provider first. Optional.
+	TargetProvider *analyzer.LatestProvider
+	// Events receives a warning when the encryption configuration does not cover the scanned resource,
+	// and an event when a rotation to the latest provider completes. Optional.
+	Events events.Emitter
+	// ExtraPrefixes are additional etcd prefixes scanned after Analyzer.Prefix, e.g. /registry/configmaps,
+	// each recorded in its own report. Not supported with sharding. Optional.
+	ExtraPrefixes []string
+	// Notifier is notified when the alert starts or stops firing and when a rotation completes. Optional.
+	Notifier notifier.Sender
+	// Audit receives every complete result, to record the secrets changing category. Optional.
+	Audit *audit.Auditor
+	// Attestor receives every recorded result, to write an attestation of its encryption summary. Optional.
+	Attestor *attestation.Attestor
+	// Remediator creates Jobs rewriting the secrets not encrypted by the latest provider, namespace by
+	// namespace, after every complete result. Requires Analyzer.CountStaleNamespaces. Optional.
+	Remediator *remediation.Remediator
+	// EtcdEndpoints are the endpoints of the etcd connection, recorded in every report so that it is
+	// unambiguous which etcd it describes. Optional.
+	EtcdEndpoints []string
+	// Members compares the view every etcd member has of the scanned prefix after every complete result,
+	// to flag members lagging behind the others. Optional.
+	Members *etcd.MemberComparer
+	// Transformation reads the envelope transformation errors of the API server on every complete
+	// result, as the etcd content does not show writes that failed to be encrypted. Optional.
+	Transformation *transformation.Monitor
+	// Recency flags the encrypted secrets of every complete result that were not rewritten since a date,
+	// e.g. the last key rotation. Optional.
+	Recency *recency.Checker
+	// History follows the encryption state of every secret across runs, to count the secrets whose state
+	// has not changed for long and those deleted since the previous run. Not supported with sharding.
+	// Optional.
+	History *history.Tracker
+	// Teams collects the secrets of the namespaces of each team, whose filtered report is written after the
+	// full report, to the namespace of the team. Not supported with sharding. Optional.
+	Teams *teams.Views
+	// CountsOnly keeps secret names out of everything but the recorder, which needs them to count the
+	// secrets per namespace: the audit trail, notifications and logs only get counts. Not supported with
+	// sharding, whose partial results hold the names.
+	CountsOnly bool
+	// Retention deletes the reports and partial results no longer written, e.g. those of removed prefixes or
+	// teams, after every complete run. Optional.
+	Retention *retention.Collector
 }
 
-func NewReadOperator(etcdCli etcd.EtcdClientOperator, clientset kubernetes.Interface, recorderOperator recorder.RecorderOperator, kmsProviderName string) ReaderOperator {
+func NewReadOperator(etcdCli etcd.EtcdClientOperator, clientset kubernetes.Interface, recorderOperator recorder.RecorderOperator, config Config) ReaderOperator {
 	return &ReadOperation{
 		etcdCli:          etcdCli,
 		clientset:        clientset,
 		RecorderOperator: recorderOperator,
-		kmsProviderName:  kmsProviderName,
+		analyzer:         analyzer.New(),
+		config:           config,
+	}
+}
+
+// RequiredPermissions lists the Kubernetes API access the reader needs in the given namespace.
+func RequiredPermissions(namespace string) []rbac.Permission {
+	return []rbac.Permission{
+		{Verb: "get", Resource: "configmaps", Namespace: namespace, Name: encryptionProviderConfigName},
 	}
 }
 
 // Read analyzes the encryption status of secrets stored in etcd by comparing
 // their encryption sequence numbers against the latest KMS provider configuration.
 func (o *ReadOperation) Read(ctx context.Context, namespace string) error {
-	// Get the secret
-	etcdCtx, cancel := context.WithTimeout(ctx, defaultTimeout)
-	defer cancel()
-
 	if o.etcdCli == nil {
 		return fmt.Errorf("etcd client is nil")
 	}
-	// TODO: Pagination for perf
-	resp, err := o.etcdCli.Get(etcdCtx, secretEtcdKey, clientv3.WithPrefix())
+
+	config := o.config.Analyzer
+	if o.config.Shard.Enabled() && !o.config.Shard.Hashed() {
+		prefix := config.Prefix
+		if prefix == "" {
+			prefix = analyzer.DefaultPrefix
+		}
+		keyRange := o.config.Shard.KeyRange(prefix)
+		config.KeyRange = &keyRange
+	}
+	if config.LatestProvider == nil {
+		config.LatestProvider = o.latestProvider(namespace, o.resource())
+	}
+
+	if config.Progress == nil {
+		config.Progress = newProgressLogger()
+	}
+	if tracker := o.config.History; tracker != nil {
+		tracker.Begin(time.Now())
+		findings := config.Findings
+		config.Findings = func(finding analyzer.Finding) {
+			tracker.Observe(finding)
+			if findings != nil {
+				findings(finding)
+			}
+		}
+	}
+
+	if views := o.config.Teams; views != nil {
+		if err := views.Begin(ctx); err != nil {
+			return err
+		}
+		findings := config.Findings
+		config.Findings = func(finding analyzer.Finding) {
+			views.Observe(finding)
+			if findings != nil {
+				findings(finding)
+			}
+		}
+	}
+
+	metrics.ScanProgress.Set(0)
+	var analysisResult analyzer.Result
+	var err error
+	if o.config.Shard.Hashed() {
+		analysisResult, err = o.analyzeNamespaces(ctx, config)
+	} else {
+		analysisResult, err = o.analyzer.Analyze(ctx, o.etcdCli, config)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get key from etcd: %w", err)
+		return err
 	}
+	metrics.ScanProgress.Set(1)
+	metrics.ScanKeys.Set(float64(analysisResult.Scan.Keys))
+	metrics.ScanBytes.Set(float64(analysisResult.Scan.Bytes))
+	klog.V(2).InfoS("Scanned etcd", "keys", analysisResult.Scan.Keys, "bytes", analysisResult.Scan.Bytes,
+		"pages", analysisResult.Scan.Pages, "parseErrors", analysisResult.Scan.ParseErrors, "duration", analysisResult.Scan.Duration)
 
-	if len(resp.Kvs) == 0 {
-		klog.Warning("No secrets found in etcd")
+	if o.config.Shard.Enabled() {
+		return o.recordShard(ctx, namespace, analysisResult)
+	}
+	if err := o.record(ctx, namespace, analysisResult); err != nil {
+		return err
+	}
+	if err := o.readExtraPrefixes(ctx, namespace); err != nil {
+		return err
+	}
+	o.collectUnused(ctx)
+	return nil
+}
+
+// collectUnused deletes the reports and partial results no longer written. It runs only after every
+// report was written, so that a report failing to be written is not taken for an unused one.
+func (o *ReadOperation) collectUnused(ctx context.Context) {
+	if o.config.Retention == nil {
+		return
+	}
+	if err := o.config.Retention.Collect(ctx); err != nil {
+		klog.ErrorS(err, "Failed to delete the ConfigMaps no longer written")
+	}
+}
+
+// analyzeNamespaces lists the namespaces and analyzes those assigned to this shard by hash one by one,
+// then merges their results into the partial result of the shard.
+func (o *ReadOperation) analyzeNamespaces(ctx context.Context, config analyzer.Config) (analyzer.Result, error) {
+	start := time.Now()
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = analyzer.DefaultPrefix
+	}
+	namespaces, err := shard.ListNamespaces(ctx, o.etcdCli, prefix, config.PageSize, config.RequestTimeout())
+	if err != nil {
+		return analyzer.Result{}, err
+	}
+	ranges := o.config.Shard.NamespaceRanges(prefix, namespaces)
+	klog.InfoS("Scanning the namespaces of this shard", "shard", o.config.Shard.Index, "namespaces", len(ranges), "total", len(namespaces))
+
+	// Each namespace is a scan of its own: the latest provider is resolved once for all of them, the
+	// progress is that of the namespaces, and skew checks would cost requests for every namespace
+	config.LatestProvider = resolveOnce(config.LatestProvider)
+	config.Progress = nil
+	config.CheckRevisionSkew = false
+	partials := make([]analyzer.Result, 0, len(ranges))
+	for i, keyRange := range ranges {
+		config.KeyRange = &keyRange
+		partial, err := o.analyzer.Analyze(ctx, o.etcdCli, config)
+		if err != nil {
+			return analyzer.Result{}, fmt.Errorf("failed to scan %s: %w", keyRange.Start, err)
+		}
+		partials = append(partials, partial)
+		metrics.ScanProgress.Set(float64(i+1) / float64(len(ranges)))
+	}
+	result := shard.Merge(partials)
+	// The namespaces were scanned one after the other
+	result.Scan.Duration = time.Since(start)
+	result.Scan.Concurrency = 1
+	return result, nil
+}
+
+// resolveOnce returns a resolver that calls resolve until it succeeds, then returns its result.
+func resolveOnce(resolve analyzer.LatestProviderFunc) analyzer.LatestProviderFunc {
+	var latest *analyzer.LatestProvider
+	return func(ctx context.Context) (analyzer.LatestProvider, error) {
+		if latest != nil {
+			return *latest, nil
+		}
+		resolved, err := resolve(ctx)
+		if err != nil {
+			return analyzer.LatestProvider{}, err
+		}
+		latest = &resolved
+		return resolved, nil
+	}
+}
+
+// latestProvider returns the resolver of the provider the secrets of resource are compared against.
+func (o *ReadOperation) latestProvider(namespace, resource string) analyzer.LatestProviderFunc {
+	return func(ctx context.Context) (analyzer.LatestProvider, error) {
+		latest, err := o.getLatestProvider(ctx, namespace, resource)
+		if target := o.config.TargetProvider; target != nil {
+			return o.targetProvider(*target, latest, err)
+		}
+		if err != nil {
+			return analyzer.LatestProvider{}, fmt.Errorf("failed to get latest provider seq: %w", err)
+		}
+		return latest, nil
+	}
+}
+
+// readExtraPrefixes scans every additional prefix and records its report. A failing prefix does not
+// prevent the others from being scanned.
+func (o *ReadOperation) readExtraPrefixes(ctx context.Context, namespace string) error {
+	var errs []error
+	for _, prefix := range o.config.ExtraPrefixes {
+		if err := o.readExtraPrefix(ctx, namespace, prefix); err != nil {
+			errs = append(errs, fmt.Errorf("failed to scan %s: %w", prefix, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readExtraPrefix scans prefix and records its report, named after its resource.
+func (o *ReadOperation) readExtraPrefix(ctx context.Context, namespace, prefix string) error {
+	resource := analyzer.ResourceFromPrefix(prefix)
+	config := o.config.Analyzer
+	config.Prefix = prefix
+	config.KeyRange = nil
+	// The cache holds the keys of the main prefix
+	config.Cache = nil
+	config.Progress = nil
+	if config.LatestProvider == nil {
+		config.LatestProvider = o.latestProvider(namespace, resource)
+	}
+
+	result, err := o.analyzer.Analyze(ctx, o.etcdCli, config)
+	if err != nil {
+		return err
+	}
+	o.warnNotCovered(ctx, resource, result)
+	klog.InfoS("Scanned additional prefix", "prefix", prefix, "encrypted", result.EncryptedCount(), "unencrypted", result.UnencryptedCount(), "unrecognized", result.UnrecognizedCount())
+	if result.Total() == 0 {
 		return nil
 	}
+	if err := o.RecorderOperator.Record(ctx, namespace, recorder.Report{Result: result, Resource: resource, EtcdEndpoints: o.config.EtcdEndpoints}); err != nil {
+		return fmt.Errorf("failed to store encryption status of %s in recorder: %w", resource, err)
+	}
+	if o.config.Attestor != nil {
+		o.config.Attestor.Attest(ctx, resource, result)
+	}
+	return nil
+}
+
+// newProgressLogger returns a progress callback that exports the scan progress and logs it at most
+// once per progressLogInterval, and for the first page.
+func newProgressLogger() func(analyzer.Progress) {
+	var lastLogged time.Duration
+	return func(progress analyzer.Progress) {
+		metrics.ScanProgress.Set(progress.Fraction())
+		if progress.Page > 1 && progress.Elapsed-lastLogged < progressLogInterval {
+			return
+		}
+		lastLogged = progress.Elapsed
+		klog.InfoS("Scan progress", "processed", progress.Processed, "total", progress.Total, "page", progress.Page, "elapsed", progress.Elapsed.Round(time.Millisecond))
+	}
+}
 
-	latestProviderSeq, err := o.getLatestProviderSeq(ctx, namespace)
+// recordShard saves the partial result of this shard. The leader then merges the partial results
+// of all shards into the report once every shard has saved a fresh one, and deletes those of the
+// shards removed by a scale-down.
+func (o *ReadOperation) recordShard(ctx context.Context, namespace string, partial analyzer.Result) error {
+	if err := o.config.ShardStore.Save(ctx, namespace, o.config.Shard.Index, partial); err != nil {
+		return fmt.Errorf("failed to store partial result of shard %d: %w", o.config.Shard.Index, err)
+	}
+	klog.InfoS("Stored partial result", "shard", o.config.Shard.Index, "secrets", partial.Total())
+	if !o.config.Shard.IsLeader() {
+		return nil
+	}
+
+	partials, err := o.config.ShardStore.Load(ctx, namespace, o.config.Shard.Count)
+	if errors.Is(err, shard.ErrIncomplete) {
+		klog.InfoS("Waiting for partial results before merging the report", "reason", err.Error())
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get latest provider seq: %w", err)
+		return fmt.Errorf("failed to load partial results: %w", err)
+	}
+	if err := o.config.ShardStore.Prune(ctx, namespace, o.config.Shard.Count); err != nil {
+		klog.ErrorS(err, "Failed to delete the partial results of removed shards")
+	}
+	if err := o.record(ctx, namespace, shard.Merge(partials)); err != nil {
+		return err
 	}
+	o.collectUnused(ctx)
+	return nil
+}
+
+// record evaluates the alert thresholds against the analysis result and stores it in the recorder.
+func (o *ReadOperation) record(ctx context.Context, namespace string, fullResult analyzer.Result) error {
+	analysisResult := fullResult
+	if o.config.CountsOnly {
+		analysisResult = fullResult.WithoutNames()
+	}
+	o.warnNotCovered(ctx, o.resource(), analysisResult)
+	o.evaluateAlerts(ctx, analysisResult)
+	o.observeRotation(ctx, analysisResult)
+	progress, estimatedCompletion := o.trackRotation(analysisResult)
+	if o.config.Audit != nil {
+		o.config.Audit.ObserveResult(ctx, analysisResult)
+	}
+	o.emitStatsD(analysisResult)
+	o.remoteWrite(ctx, analysisResult)
 
-	analysisResult := o.analyzeSecretEncryption(resp.Kvs, latestProviderSeq)
+	if analysisResult.Total() == 0 {
+		klog.Warning("No secrets found in etcd")
+		return nil
+	}
 
-	if err := o.RecorderOperator.Record(ctx, namespace, analysisResult.EncryptedSecrets, analysisResult.UnencryptedSecrets, analysisResult.AllSecretsUseLatestProvider); err != nil {
+	report := recorder.Report{Result: fullResult, Progress: &progress, EstimatedCompletion: estimatedCompletion, EtcdEndpoints: o.config.EtcdEndpoints}
+	report.Remediation = o.remediate(ctx, analysisResult)
+	report.Members = o.compareMembers(ctx)
+	report.Transformation = o.checkTransformation(ctx)
+	report.Recency = o.checkRecency(ctx, fullResult)
+	report.History, report.Deleted = o.trackHistory(ctx)
+	if err := o.RecorderOperator.Record(ctx, namespace, report); err != nil {
 		return fmt.Errorf("failed to store secret encryption status in recorder: %w", err)
 	}
+	if err := o.recordTeams(ctx, fullResult); err != nil {
+		return err
+	}
+	if o.config.Attestor != nil {
+		o.config.Attestor.Attest(ctx, o.resource(), analysisResult)
+	}
+	if unrecognized := analysisResult.UnrecognizedCount(); unrecognized > 0 {
+		keysAndValues := []any{"count", unrecognized}
+		if !o.config.CountsOnly {
+			keysAndValues = append(keysAndValues, "secrets", analysisResult.UnrecognizedSecrets)
+		}
+		klog.InfoS("Secrets with unrecognized values, neither encrypted nor in a known storage encoding", keysAndValues...)
+	}
+	if omitted := fullResult.OmittedEncrypted + fullResult.OmittedUnencrypted; omitted > 0 {
+		klog.InfoS("Secret names truncated in the report", "recorded", len(fullResult.EncryptedSecrets)+len(fullResult.UnencryptedSecrets), "omitted", omitted)
+	}
 	klog.Info("Read etcd successfully")
 	return nil
 }
 
-// analyzeSecretEncryption processes etcd key-value pairs to categorize secrets by encryption status
-// and determines if all secrets use the latest provider sequence.
-func (o *ReadOperation) analyzeSecretEncryption(kvs []*mvccpb.KeyValue, latestProviderSeq int) EncryptionAnalysisResult {
-	result := EncryptionAnalysisResult{
-		EncryptedSecrets:            []string{},
-		UnencryptedSecrets:          []string{},
-		AllSecretsUseLatestProvider: true,
+// recordTeams records the report of every team, covering the secrets of its namespaces in fullResult. A
+// failing team does not prevent the other teams from being recorded.
+func (o *ReadOperation) recordTeams(ctx context.Context, fullResult analyzer.Result) error {
+	if o.config.Teams == nil {
+		return nil
+	}
+	var errs []error
+	for _, view := range o.config.Teams.Views() {
+		result := analyzer.ClassifyFindings(view.Findings, fullResult.LatestProvider, o.config.Analyzer)
+		result.Revision = fullResult.Revision
+		report := recorder.Report{Result: result, Team: view.Team.Name, EtcdEndpoints: o.config.EtcdEndpoints}
+		if err := o.RecorderOperator.Record(ctx, view.Team.ReportNamespace, report); err != nil {
+			errs = append(errs, fmt.Errorf("failed to store the report of team %s in recorder: %w", view.Team.Name, err))
+			continue
+		}
+		klog.V(2).InfoS("Recorded team report", "team", view.Team.Name, "namespace", view.Team.ReportNamespace, "secrets", result.Total())
+	}
+	return errors.Join(errs...)
+}
+
+// warnNotCovered reports a complete result of resource that the encryption configuration does not cover.
+func (o *ReadOperation) warnNotCovered(ctx context.Context, resource string, analysisResult analyzer.Result) {
+	if !analysisResult.LatestProvider.NotCovered {
+		return
 	}
+	message := fmt.Sprintf("The encryption configuration does not list %s in its resources: they are stored in plaintext whatever the providers are", resource)
+	klog.Warning(message)
+	if o.config.Events != nil {
+		o.config.Events.Emit(ctx, v1.EventTypeWarning, events.ReasonResourceNotCovered, message)
+	}
+}
 
-	for _, kv := range kvs {
-		key := string(kv.Key)
-		value := string(kv.Value)
+// observeRotation tracks whether every secret is encrypted by the latest provider, and announces the
+// transition to it once. The first result after a start only sets the state, so that restarts do not
+// repeat the announcement of a rotation that completed earlier.
+func (o *ReadOperation) observeRotation(ctx context.Context, analysisResult analyzer.Result) {
+	complete := analysisResult.FullyEncryptedByLatest()
+	if complete {
+		metrics.RotationComplete.Set(1)
+	} else {
+		metrics.RotationComplete.Set(0)
+	}
 
-		encrypted, parsedSecret, providerSeq, err := utils.ParseEtcdObject(key, value, o.kmsProviderName)
-		if err != nil {
-			klog.ErrorS(err, "Failed to parse secret")
-			continue
+	o.mu.Lock()
+	previous := o.rotationComplete
+	o.rotationComplete = &complete
+	o.mu.Unlock()
+	if previous == nil || *previous == complete {
+		return
+	}
+
+	if !complete {
+		klog.InfoS("Secrets are no longer all encrypted by the latest provider", "unencrypted", analysisResult.UnencryptedCount(), "unrecognized", analysisResult.UnrecognizedCount())
+		return
+	}
+	message := fmt.Sprintf("Rotation complete: all %d %s are encrypted by the latest provider %s", analysisResult.Total(), o.resource(), analysisResult.LatestProvider.Name)
+	klog.Info(message)
+	if o.config.Events != nil {
+		o.config.Events.Emit(ctx, v1.EventTypeNormal, events.ReasonRotationComplete, message)
+	}
+	o.notify(ctx, notifier.EventRotationComplete, message, analysisResult)
+}
+
+// trackRotation returns the progress of the current rotation, and when it is estimated to complete from
+// the rate secrets moved to the latest provider since the first result compared against it, or the
+// zero time if it is complete or cannot be estimated, e.g. because no secret moved yet.
+func (o *ReadOperation) trackRotation(analysisResult analyzer.Result) (recorder.Progress, time.Time) {
+	o.mu.Lock()
+	if o.rotation == nil {
+		o.rotation = rotation.NewTracker(o.config.Analyzer)
+	}
+	status := o.rotation.Observe(time.Now(), analysisResult)
+	o.mu.Unlock()
+
+	progress := recorder.Progress{OnLatest: status.OnTarget, Total: analysisResult.Total(), Percent: status.Percent(), Delta: status.Delta}
+	metrics.RotationProgressPercent.Set(progress.Percent)
+	if status.ETA == 0 || status.Complete() {
+		metrics.RotationEstimatedCompletion.Set(0)
+		return progress, time.Time{}
+	}
+	estimate := status.Time.Add(status.ETA).Truncate(time.Second)
+	metrics.RotationEstimatedCompletion.Set(float64(estimate.Unix()))
+	klog.V(2).InfoS("Estimated rotation completion", "percent", progress.Percent, "rate", status.Rate, "remaining", progress.Total-progress.OnLatest, "completion", estimate)
+	return progress, estimate
+}
+
+// remediate creates the remediation Jobs of the namespaces holding secrets that are not encrypted by the
+// latest provider, and returns the summary of the Jobs, or nil if remediation is disabled or failed.
+// Failures are logged and do not fail the run.
+func (o *ReadOperation) remediate(ctx context.Context, analysisResult analyzer.Result) *remediation.Summary {
+	if o.config.Remediator == nil {
+		return nil
+	}
+	// Rewriting secrets only helps if the API server writes them with a KMS provider
+	if analysisResult.LatestProvider.NotCovered || analysisResult.LatestProvider.Name == "" {
+		klog.V(2).InfoS("Skipping remediation without a latest KMS provider")
+		return nil
+	}
+	summary, err := o.config.Remediator.Reconcile(ctx, analysisResult.StaleNamespaces)
+	if err != nil {
+		klog.ErrorS(err, "Failed to reconcile remediation Jobs")
+		return nil
+	}
+	klog.InfoS("Remediation Jobs", "active", summary.Active, "succeeded", summary.Succeeded, "failed", summary.Failed, "created", summary.Created, "pending", summary.Pending)
+	return &summary
+}
+
+// compareMembers returns the view every etcd member has of the scanned prefix, or nil if members are not
+// compared. Lagging members are logged: a scan they served may report stale data.
+func (o *ReadOperation) compareMembers(ctx context.Context) []etcd.MemberStatus {
+	if o.config.Members == nil {
+		return nil
+	}
+	prefix := o.config.Analyzer.Prefix
+	if prefix == "" {
+		prefix = analyzer.DefaultPrefix
+	}
+	statuses := o.config.Members.Compare(ctx, prefix)
+	for _, status := range statuses {
+		switch {
+		case status.Error != "":
+			metrics.EtcdMemberRevisionLag.DeleteLabelValues(status.Endpoint)
+			klog.InfoS("Failed to read etcd member", "endpoint", status.Endpoint, "err", status.Error)
+		case status.Lagging:
+			metrics.EtcdMemberRevisionLag.WithLabelValues(status.Endpoint).Set(float64(status.Lag))
+			klog.Warningf("etcd member %s is %d revisions behind the most recent member: results read from it may be stale", status.Endpoint, status.Lag)
+		default:
+			metrics.EtcdMemberRevisionLag.WithLabelValues(status.Endpoint).Set(float64(status.Lag))
+			klog.V(2).InfoS("etcd member", "endpoint", status.Endpoint, "revision", status.Revision, "keys", status.Keys, "lag", status.Lag)
 		}
+	}
+	return statuses
+}
 
-		if providerSeq != latestProviderSeq {
-			result.AllSecretsUseLatestProvider = false
+// checkTransformation returns the envelope transformations the API server failed since the previous run,
+// or nil if the API server is not monitored, could not be read, or is read for the first time.
+// Failures are logged and do not fail the run.
+func (o *ReadOperation) checkTransformation(ctx context.Context) *transformation.Errors {
+	if o.config.Transformation == nil {
+		return nil
+	}
+	failures, err := o.config.Transformation.Check(ctx)
+	if err != nil {
+		klog.ErrorS(err, "Failed to check the API server for transformation errors")
+		return nil
+	}
+	if failures == nil {
+		klog.V(2).InfoS("Recorded the API server transformation counters, errors are reported from the next run")
+		return nil
+	}
+	metrics.TransformationErrors.WithLabelValues("write").Set(float64(failures.Writes))
+	metrics.TransformationErrors.WithLabelValues("read").Set(float64(failures.Reads))
+	if failures.Total() > 0 {
+		message := "The API server failed envelope transformations since the previous run: " + failures.String()
+		klog.Warning(message)
+		if o.config.Events != nil {
+			o.config.Events.Emit(ctx, v1.EventTypeWarning, events.ReasonTransformationErrors, message)
 		}
+	}
+	return failures
+}
 
-		if encrypted {
-			result.EncryptedSecrets = append(result.EncryptedSecrets, parsedSecret)
-		} else {
-			result.UnencryptedSecrets = append(result.UnencryptedSecrets, parsedSecret)
+// checkRecency returns the encrypted secrets of fullResult that were not rewritten since the configured
+// date, or nil if they are not checked or could not be listed. Failures are logged and do not fail the run.
+func (o *ReadOperation) checkRecency(ctx context.Context, fullResult analyzer.Result) *recency.Summary {
+	if o.config.Recency == nil {
+		return nil
+	}
+	summary, err := o.config.Recency.Check(ctx, fullResult)
+	if err != nil {
+		klog.ErrorS(err, "Failed to check when secrets were last written")
+		return nil
+	}
+	metrics.SecretsNotRewritten.Set(float64(summary.NotRewritten))
+	if summary.NotRewritten > 0 {
+		keysAndValues := []any{"since", summary.Since, "count", summary.NotRewritten, "checked", summary.Checked}
+		if !o.config.CountsOnly {
+			keysAndValues = append(keysAndValues, "oldest", summary.Secrets)
 		}
+		klog.InfoS("Encrypted secrets not rewritten since the rotation date", keysAndValues...)
+	}
+	if o.config.CountsOnly {
+		summary.Secrets = nil
 	}
+	return summary
+}
 
-	return result
+// trackHistory saves the encryption state of the secrets observed in the run and returns the secrets
+// unchanged for long and those deleted since the previous run, each nil if not tracked. Both are nil if
+// the history could not be saved. Failures are logged and do not fail the run.
+func (o *ReadOperation) trackHistory(ctx context.Context) (*history.Summary, *history.Deletion) {
+	if o.config.History == nil {
+		return nil, nil
+	}
+	summary, err := o.config.History.Commit(ctx)
+	if err != nil {
+		klog.ErrorS(err, "Failed to track the history of secrets")
+		return nil, nil
+	}
+	if summary != nil {
+		metrics.SecretsUnchanged.Set(float64(summary.Unchanged))
+		if summary.Unchanged > 0 {
+			keysAndValues := []any{"days", summary.Days, "count", summary.Unchanged, "tracked", summary.Tracked}
+			if !o.config.CountsOnly {
+				keysAndValues = append(keysAndValues, "oldest", summary.Secrets)
+			}
+			klog.InfoS("Secrets unchanged for longer than the threshold", keysAndValues...)
+		}
+		if o.config.CountsOnly {
+			summary.Secrets = nil
+		}
+	}
+	deletion := o.config.History.Deletion()
+	if deletion != nil {
+		metrics.SecretsDeleted.Set(float64(deletion.Deleted))
+		if deletion.Deleted > 0 {
+			keysAndValues := []any{"count", deletion.Deleted, "previous", deletion.Previous}
+			if !o.config.CountsOnly {
+				keysAndValues = append(keysAndValues, "secrets", deletion.Secrets)
+			}
+			klog.InfoS("Secrets deleted since the previous run", keysAndValues...)
+		}
+		if o.config.CountsOnly {
+			copied := *deletion
+			copied.Secrets = nil
+			deletion = &copied
+		}
+	}
+	return summary, deletion
+}
+
+// notify sends a notification about a complete result. Failures are logged and do not fail the run.
+func (o *ReadOperation) notify(ctx context.Context, event, message string, analysisResult analyzer.Result) {
+	if o.config.Notifier == nil {
+		return
+	}
+	notification := notifier.Notification{Event: event, Message: message, Time: time.Now(), Result: analysisResult}
+	if err := o.config.Notifier.Notify(ctx, notification); err != nil {
+		klog.ErrorS(err, "Failed to send notification", "event", event)
+		return
+	}
+	klog.V(2).InfoS("Sent notification", "event", event)
 }
 
-// getLatestProviderSeq returns the sequence number of the first KMS provider found in the encryption configuration.
-// If no KMS provider is found, it returns identityProviderSeq (-1) indicating identity (no encryption) provider.
-func (o *ReadOperation) getLatestProviderSeq(ctx context.Context, namespace string) (int, error) {
-	k8sCtx, cancel := context.WithTimeout(ctx, defaultTimeout)
+// resource returns the resource the reader scans, e.g. "secrets".
+func (o *ReadOperation) resource() string {
+	prefix := o.config.Analyzer.Prefix
+	if prefix == "" {
+		prefix = analyzer.DefaultPrefix
+	}
+	return analyzer.ResourceFromPrefix(prefix)
+}
+
+// evaluateAlerts applies the alert thresholds to a complete result.
+func (o *ReadOperation) evaluateAlerts(ctx context.Context, analysisResult analyzer.Result) {
+	if o.config.Alerts == nil {
+		return
+	}
+	state := o.config.Alerts.Evaluate(analysisResult)
+	if state.Firing {
+		metrics.AlertFiring.Set(1)
+	} else {
+		metrics.AlertFiring.Set(0)
+	}
+
+	switch {
+	case state.Changed && state.Firing:
+		klog.InfoS("Alert firing", "breaches", state.Breaches, "consecutiveRuns", state.ConsecutiveBreaches)
+		o.notify(ctx, notifier.EventAlertFiring, "Alert firing: "+strings.Join(state.Breaches, "; "), analysisResult)
+	case state.Changed:
+		klog.InfoS("Alert resolved")
+		o.notify(ctx, notifier.EventAlertResolved, "Alert resolved", analysisResult)
+	case len(state.Breaches) > 0 && !state.Firing:
+		klog.V(2).InfoS("Alert threshold breached, waiting for consecutive runs", "breaches", state.Breaches, "consecutiveRuns", state.ConsecutiveBreaches)
+	}
+}
+
+// emitStatsD sends the summary of a complete result to StatsD.
+func (o *ReadOperation) emitStatsD(analysisResult analyzer.Result) {
+	statsd := o.config.StatsD
+	if statsd == nil {
+		return
+	}
+	allLatest := 0.0
+	if analysisResult.AllSecretsUseLatestProvider {
+		allLatest = 1
+	}
+	statsd.Gauge("secrets.encrypted", float64(analysisResult.EncryptedCount()))
+	statsd.Gauge("secrets.unencrypted", float64(analysisResult.UnencryptedCount()))
+	statsd.Gauge("secrets.all_latest_provider", allLatest)
+	for provider, count := range analysisResult.ProviderCounts {
+		statsd.Gauge("secrets.provider", float64(count), "provider:"+provider)
+	}
+	if err := statsd.Flush(); err != nil {
+		klog.ErrorS(err, "Failed to emit StatsD metrics")
+	}
+}
+
+// remoteWrite sends the summary series of a complete result to the remote-write endpoint. Failures
+// are logged and do not fail the run: the report is still recorded.
+func (o *ReadOperation) remoteWrite(ctx context.Context, analysisResult analyzer.Result) {
+	writer := o.config.RemoteWrite
+	if writer == nil {
+		return
+	}
+	allLatest := 0.0
+	if analysisResult.AllSecretsUseLatestProvider {
+		allLatest = 1
+	}
+	samples := []metrics.Sample{
+		{Name: "kms_reporter_encrypted_secrets", Value: float64(analysisResult.EncryptedCount())},
+		{Name: "kms_reporter_unencrypted_secrets", Value: float64(analysisResult.UnencryptedCount())},
+		{Name: "kms_reporter_all_secrets_use_latest_provider", Value: allLatest},
+	}
+	for provider, count := range analysisResult.ProviderCounts {
+		samples = append(samples, metrics.Sample{Name: "kms_reporter_provider_secrets", Labels: map[string]string{"provider": provider}, Value: float64(count)})
+	}
+	if err := writer.Write(ctx, samples, time.Now()); err != nil {
+		klog.ErrorS(err, "Failed to remote-write the report summary")
+		return
+	}
+	klog.V(2).InfoS("Remote-wrote the report summary", "series", len(samples))
+}
+
+// targetProvider returns the target provider in place of latest, the latest provider of the encryption
+// configuration, or of err if it could not be read. The encryption configuration then only tells whether
+// the scanned resource is covered, so a missing one is not an error.
+func (o *ReadOperation) targetProvider(target, latest analyzer.LatestProvider, err error) (analyzer.LatestProvider, error) {
+	if err != nil && !errors.Is(err, ErrEncryptionConfigNotFound) {
+		return analyzer.LatestProvider{}, fmt.Errorf("failed to get latest provider seq: %w", err)
+	}
+	if err == nil && (latest.Name != target.Name || latest.Seq != target.Seq) {
+		klog.V(2).InfoS("Comparing against the target provider instead of the latest provider of the encryption configuration", "target", target.Name, "latest", latest.Name)
+	}
+	target.NotCovered = latest.NotCovered
+	return target, nil
+}
+
+// getLatestProvider reads the encryption configuration from the encryption-provider-config ConfigMap
+// and returns the latest provider of resource, telling whether it covers resource.
+func (o *ReadOperation) getLatestProvider(ctx context.Context, namespace, resource string) (analyzer.LatestProvider, error) {
+	timeout := o.config.KubeRequestTimeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	k8sCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
+	encryptionConfig, err := GetEncryptionConfiguration(k8sCtx, o.clientset, namespace)
+	if err != nil {
+		return analyzer.LatestProvider{}, err
+	}
+
+	latest := analyzer.FindLatestProvider(encryptionConfig, resource, o.config.Analyzer.ProviderMatcher, o.config.Analyzer.Comparison)
+	if resource != "" {
+		latest.NotCovered = !encryptionConfig.Covers(resource)
+	}
+	return latest, nil
+}
+
+// GetEncryptionConfiguration reads the encryption configuration from the encryption-provider-config
+// ConfigMap of namespace. The error wraps ErrEncryptionConfigNotFound when the ConfigMap or its key is missing.
+func GetEncryptionConfiguration(ctx context.Context, clientset kubernetes.Interface, namespace string) (analyzer.EncryptionConfiguration, error) {
 	// Get the encryption-provider-config ConfigMap
-	cm, err := o.clientset.CoreV1().ConfigMaps(namespace).Get(k8sCtx, encryptionProviderConfigName, metav1.GetOptions{})
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, encryptionProviderConfigName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return analyzer.EncryptionConfiguration{}, fmt.Errorf("failed to get encryption-provider-config ConfigMap: %w: %w", ErrEncryptionConfigNotFound, err)
+	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to get encryption-provider-config ConfigMap: %w", err)
+		return analyzer.EncryptionConfiguration{}, fmt.Errorf("failed to get encryption-provider-config ConfigMap: %w", err)
 	}
 
 	// Get the encryption configuration YAML from the ConfigMap
 	encryptionConfigYAML, exists := cm.Data[encryptionConfigYAMLKey]
 	if !exists {
-		return 0, fmt.Errorf("%s not found in ConfigMap data", encryptionConfigYAMLKey)
-	}
-
-	// Parse the YAML into our configuration structure
-	var encryptionConfig EncryptionConfiguration
-	if err := yaml.Unmarshal([]byte(encryptionConfigYAML), &encryptionConfig); err != nil {
-		return 0, fmt.Errorf("failed to unmarshal encryption configuration: %w", err)
-	}
-
-	// Find the first KMS provider sequence number
-	providerNameRegex := regexp.MustCompile(o.kmsProviderName + `(\d+)`)
-
-	for _, resource := range encryptionConfig.Resources {
-		for _, provider := range resource.Providers {
-			if provider.KMS != nil {
-				matches := providerNameRegex.FindStringSubmatch(provider.KMS.Name)
-				if len(matches) == 2 {
-					providerSeq, err := strconv.Atoi(matches[1])
-					if err != nil {
-						klog.ErrorS(err, "Failed to parse provider sequence number", "providerName", provider.KMS.Name)
-						continue
-					}
-					return providerSeq, nil
-				}
-			}
-		}
+		return analyzer.EncryptionConfiguration{}, fmt.Errorf("%w: %s not found in ConfigMap data", ErrEncryptionConfigNotFound, encryptionConfigYAMLKey)
 	}
 
-	return identityProviderSeq, nil
+	return analyzer.ParseEncryptionConfiguration([]byte(encryptionConfigYAML))
 }
@@ -3,24 +3,76 @@ package reader
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.uber.org/mock/gomock"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
 
+	"github.com/lzhecheng/kms-reporter/pkg/alert"
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
 	mock_etcd "github.com/lzhecheng/kms-reporter/pkg/etcd/mock"
+	"github.com/lzhecheng/kms-reporter/pkg/events"
+	"github.com/lzhecheng/kms-reporter/pkg/history"
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+	"github.com/lzhecheng/kms-reporter/pkg/notifier"
 	mock_reader "github.com/lzhecheng/kms-reporter/pkg/reader/mock"
+	"github.com/lzhecheng/kms-reporter/pkg/recency"
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
 	mock_recorder "github.com/lzhecheng/kms-reporter/pkg/recorder/mock"
+	"github.com/lzhecheng/kms-reporter/pkg/remediation"
+	"github.com/lzhecheng/kms-reporter/pkg/retention"
+	"github.com/lzhecheng/kms-reporter/pkg/shard"
+	"github.com/lzhecheng/kms-reporter/pkg/teams"
+	"github.com/lzhecheng/kms-reporter/pkg/transformation"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
 )
 
 // Tests use generated mocks from gomock for all interface dependencies
 
+func mustProviderMatcher(t *testing.T, kmsProviderName string) *utils.ProviderNameMatcher {
+	matcher, err := utils.NewProviderNameMatcher(kmsProviderName, "")
+	if err != nil {
+		t.Fatalf("Failed to create provider name matcher: %v", err)
+	}
+	return matcher
+}
+
+// untimedReport matches a report equal to the expected one but for its scan duration, which depends on
+// the clock
+type untimedReport struct {
+	want recorder.Report
+}
+
+func (m untimedReport) Matches(x any) bool {
+	report, ok := x.(recorder.Report)
+	if !ok {
+		return false
+	}
+	report.Scan.Duration = 0
+	return gomock.Eq(m.want).Matches(report)
+}
+
+func (m untimedReport) String() string {
+	return gomock.Eq(m.want).String() + " but for its scan duration"
+}
+
 func TestNewReadOperator(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -28,9 +80,10 @@ func TestNewReadOperator(t *testing.T) {
 	mockEtcd := mock_etcd.NewMockEtcdClientOperator(ctrl)
 	mockClientset := fake.NewSimpleClientset()
 	mockRecorder := mock_recorder.NewMockRecorderOperator(ctrl)
-	kmsProviderName := "testprovider"
+	providerMatcher := mustProviderMatcher(t, "testprovider")
 
-	reader := NewReadOperator(mockEtcd, mockClientset, mockRecorder, kmsProviderName)
+	config := Config{Analyzer: analyzer.Config{ProviderMatcher: providerMatcher, Comparison: analyzer.ComparisonName}}
+	reader := NewReadOperator(mockEtcd, mockClientset, mockRecorder, config)
 
 	assert.NotNil(t, reader)
 	assert.IsType(t, &ReadOperation{}, reader)
@@ -39,7 +92,8 @@ func TestNewReadOperator(t *testing.T) {
 	assert.Equal(t, mockEtcd, readOp.etcdCli)
 	assert.Equal(t, mockClientset, readOp.clientset)
 	assert.Equal(t, mockRecorder, readOp.RecorderOperator)
-	assert.Equal(t, kmsProviderName, readOp.kmsProviderName)
+	assert.NotNil(t, readOp.analyzer)
+	assert.Equal(t, config, readOp.config)
 }
 
 func TestReaderOperator_Interface(t *testing.T) {
@@ -68,6 +122,7 @@ func TestReadOperation_Read(t *testing.T) {
 		setup         func(ctrl *gomock.Controller) (*mock_etcd.MockEtcdClientOperator, *mock_recorder.MockRecorderOperator, kubernetes.Interface)
 		namespace     string
 		expectedError string
+		expectedErrIs error
 		nilEtcdClient bool
 	}{
 		{
@@ -85,10 +140,10 @@ func TestReadOperation_Read(t *testing.T) {
 					},
 					{
 						Key:   []byte("/registry/secrets/default/secret2"),
-						Value: []byte("unencrypted-data"),
+						Value: []byte("k8s\x00unencrypted-data"),
 					},
 				}
-				etcdMock.EXPECT().Get(gomock.Any(), secretEtcdKey, gomock.Any()).Return(&clientv3.GetResponse{Kvs: kvs}, nil)
+				etcdMock.EXPECT().Get(gomock.Any(), analyzer.DefaultPrefix, gomock.Any()).Return(&clientv3.GetResponse{Kvs: kvs}, nil)
 
 				// Setup encryption config ConfigMap
 				encryptionConfig := `
@@ -116,7 +171,15 @@ resources:
 				clientset.CoreV1().ConfigMaps("test-namespace").Create(context.TODO(), cm, metav1.CreateOptions{})
 
 				// Setup recorder mock
-				recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", []string{"default/secret1"}, []string{"default/secret2"}, false).Return(nil)
+				recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", untimedReport{recorder.Report{Result: analyzer.Result{
+					EncryptedSecrets:   []string{"default/secret1"},
+					UnencryptedSecrets: []string{"default/secret2"},
+					ProviderCounts:     map[string]int{"kmsprovider1": 1, "identity": 1},
+					SequenceCounts:     map[int]int{0: 1, 1: 1},
+					LatestProvider:     analyzer.LatestProvider{Name: "kmsprovider1", Seq: 1},
+					EncodingCounts:     map[string]int{"protobuf": 1},
+					Scan:               analyzer.ScanStats{Keys: 2, Bytes: 128, Pages: 1, Concurrency: 1},
+				}, Progress: &recorder.Progress{OnLatest: 1, Total: 2, Percent: 50}}}).Return(nil)
 
 				return etcdMock, recorderMock, clientset
 			},
@@ -140,12 +203,13 @@ resources:
 				recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
 				clientset := fake.NewSimpleClientset()
 
-				etcdMock.EXPECT().Get(gomock.Any(), secretEtcdKey, gomock.Any()).Return(nil, errors.New("etcd connection failed"))
+				etcdMock.EXPECT().Get(gomock.Any(), analyzer.DefaultPrefix, gomock.Any()).Return(nil, errors.New("etcd connection failed"))
 
 				return etcdMock, recorderMock, clientset
 			},
 			namespace:     "test-namespace",
 			expectedError: "failed to get key from etcd",
+			expectedErrIs: etcd.ErrEtcdUnavailable,
 		},
 		{
 			name: "no secrets found in etcd",
@@ -154,7 +218,7 @@ resources:
 				recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
 				clientset := fake.NewSimpleClientset()
 
-				etcdMock.EXPECT().Get(gomock.Any(), secretEtcdKey, gomock.Any()).Return(&clientv3.GetResponse{Kvs: []*mvccpb.KeyValue{}}, nil)
+				etcdMock.EXPECT().Get(gomock.Any(), analyzer.DefaultPrefix, gomock.Any()).Return(&clientv3.GetResponse{Kvs: []*mvccpb.KeyValue{}}, nil)
 
 				return etcdMock, recorderMock, clientset
 			},
@@ -173,13 +237,14 @@ resources:
 						Value: []byte("k8s:enc:kms:v2:kmsprovider1:encrypted-data"),
 					},
 				}
-				etcdMock.EXPECT().Get(gomock.Any(), secretEtcdKey, gomock.Any()).Return(&clientv3.GetResponse{Kvs: kvs}, nil)
+				etcdMock.EXPECT().Get(gomock.Any(), analyzer.DefaultPrefix, gomock.Any()).Return(&clientv3.GetResponse{Kvs: kvs}, nil)
 				// ConfigMap not created, so it won't be found
 
 				return etcdMock, recorderMock, clientset
 			},
 			namespace:     "test-namespace",
 			expectedError: "failed to get latest provider seq",
+			expectedErrIs: ErrEncryptionConfigNotFound,
 		},
 		{
 			name: "recorder fails",
@@ -194,7 +259,7 @@ resources:
 						Value: []byte("k8s:enc:kms:v2:kmsprovider1:encrypted-data"),
 					},
 				}
-				etcdMock.EXPECT().Get(gomock.Any(), secretEtcdKey, gomock.Any()).Return(&clientv3.GetResponse{Kvs: kvs}, nil)
+				etcdMock.EXPECT().Get(gomock.Any(), analyzer.DefaultPrefix, gomock.Any()).Return(&clientv3.GetResponse{Kvs: kvs}, nil)
 
 				encryptionConfig := `
 apiVersion: apiserver.config.k8s.io/v1
@@ -220,7 +285,7 @@ resources:
 				}
 				clientset.CoreV1().ConfigMaps("test-namespace").Create(context.TODO(), cm, metav1.CreateOptions{})
 
-				recorderMock.EXPECT().Record(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("recorder failed"))
+				recorderMock.EXPECT().Record(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("recorder failed"))
 
 				return etcdMock, recorderMock, clientset
 			},
@@ -242,14 +307,16 @@ resources:
 					etcdCli:          nil,
 					clientset:        clientset,
 					RecorderOperator: recorderMock,
-					kmsProviderName:  "kmsprovider",
+					analyzer:         analyzer.New(),
+					config:           Config{Analyzer: analyzer.Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")}},
 				}
 			} else {
 				readOp = &ReadOperation{
 					etcdCli:          etcdMock,
 					clientset:        clientset,
 					RecorderOperator: recorderMock,
-					kmsProviderName:  "kmsprovider",
+					analyzer:         analyzer.New(),
+					config:           Config{Analyzer: analyzer.Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")}},
 				}
 			}
 
@@ -258,6 +325,9 @@ resources:
 			if tt.expectedError != "" {
 				assert.Error(t, err)
 				assert.Contains(t, err.Error(), tt.expectedError)
+				if tt.expectedErrIs != nil {
+					assert.ErrorIs(t, err, tt.expectedErrIs)
+				}
 			} else {
 				assert.NoError(t, err)
 			}
@@ -265,108 +335,7 @@ resources:
 	}
 }
 
-func TestReadOperation_analyzeSecretEncryption(t *testing.T) {
-	tests := []struct {
-		name                         string
-		kvs                          []*mvccpb.KeyValue
-		latestProviderSeq            int
-		expectedEncryptedSecrets     []string
-		expectedUnencryptedSecrets   []string
-		expectedAllUseLatestProvider bool
-	}{
-		{
-			name: "mixed encrypted and unencrypted secrets with latest provider",
-			kvs: []*mvccpb.KeyValue{
-				{
-					Key:   []byte("/registry/secrets/default/secret1"),
-					Value: []byte("k8s:enc:kms:v2:kmsprovider1:encrypted-data"),
-				},
-				{
-					Key:   []byte("/registry/secrets/kube-system/secret2"),
-					Value: []byte("unencrypted-data"),
-				},
-				{
-					Key:   []byte("/registry/secrets/default/secret3"),
-					Value: []byte("k8s:enc:kms:v2:kmsprovider1:more-encrypted-data"),
-				},
-			},
-			latestProviderSeq:            1,
-			expectedEncryptedSecrets:     []string{"default/secret1", "default/secret3"},
-			expectedUnencryptedSecrets:   []string{"kube-system/secret2"},
-			expectedAllUseLatestProvider: false, // because secret2 is unencrypted (seq 0 != 1)
-		},
-		{
-			name: "all secrets encrypted with latest provider",
-			kvs: []*mvccpb.KeyValue{
-				{
-					Key:   []byte("/registry/secrets/default/secret1"),
-					Value: []byte("k8s:enc:kms:v2:kmsprovider2:encrypted-data"),
-				},
-				{
-					Key:   []byte("/registry/secrets/default/secret2"),
-					Value: []byte("k8s:enc:kms:v2:kmsprovider2:more-encrypted-data"),
-				},
-			},
-			latestProviderSeq:            2,
-			expectedEncryptedSecrets:     []string{"default/secret1", "default/secret2"},
-			expectedUnencryptedSecrets:   []string{},
-			expectedAllUseLatestProvider: true,
-		},
-		{
-			name: "encrypted secrets with older provider",
-			kvs: []*mvccpb.KeyValue{
-				{
-					Key:   []byte("/registry/secrets/default/secret1"),
-					Value: []byte("k8s:enc:kms:v2:kmsprovider1:encrypted-data"),
-				},
-			},
-			latestProviderSeq:            2,
-			expectedEncryptedSecrets:     []string{"default/secret1"},
-			expectedUnencryptedSecrets:   []string{},
-			expectedAllUseLatestProvider: false, // seq 1 != 2
-		},
-		{
-			name:                         "no secrets",
-			kvs:                          []*mvccpb.KeyValue{},
-			latestProviderSeq:            1,
-			expectedEncryptedSecrets:     []string{},
-			expectedUnencryptedSecrets:   []string{},
-			expectedAllUseLatestProvider: true,
-		},
-		{
-			name: "invalid key format - should be skipped",
-			kvs: []*mvccpb.KeyValue{
-				{
-					Key:   []byte("/invalid/key"),
-					Value: []byte("some-data"),
-				},
-				{
-					Key:   []byte("/registry/secrets/default/valid-secret"),
-					Value: []byte("unencrypted-data"),
-				},
-			},
-			latestProviderSeq:            1,
-			expectedEncryptedSecrets:     []string{},
-			expectedUnencryptedSecrets:   []string{"default/valid-secret"},
-			expectedAllUseLatestProvider: false,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			readOp := &ReadOperation{
-				kmsProviderName: "kmsprovider",
-			}
-			result := readOp.analyzeSecretEncryption(tt.kvs, tt.latestProviderSeq)
-
-			assert.Equal(t, tt.expectedEncryptedSecrets, result.EncryptedSecrets)
-			assert.Equal(t, tt.expectedUnencryptedSecrets, result.UnencryptedSecrets)
-			assert.Equal(t, tt.expectedAllUseLatestProvider, result.AllSecretsUseLatestProvider)
-		})
-	}
-}
-
-func TestReadOperation_getLatestProviderSeq(t *testing.T) {
+func TestReadOperation_getLatestProvider(t *testing.T) {
 	tests := []struct {
 		name           string
 		setupConfigMap func(kubernetes.Interface, string)
@@ -462,7 +431,7 @@ resources:
 				clientset.CoreV1().ConfigMaps(namespace).Create(context.TODO(), cm, metav1.CreateOptions{})
 			},
 			namespace:   "test-namespace",
-			expectedSeq: identityProviderSeq,
+			expectedSeq: analyzer.IdentityProviderSeq,
 		},
 		{
 			name: "configmap not found",
@@ -534,7 +503,7 @@ resources:
 				clientset.CoreV1().ConfigMaps(namespace).Create(context.TODO(), cm, metav1.CreateOptions{})
 			},
 			namespace:   "test-namespace",
-			expectedSeq: identityProviderSeq, // Should return identity provider seq when no valid KMS found
+			expectedSeq: analyzer.IdentityProviderSeq, // Should return identity provider seq when no valid KMS found
 		},
 	}
 
@@ -544,19 +513,877 @@ resources:
 			tt.setupConfigMap(clientset, tt.namespace)
 
 			readOp := &ReadOperation{
-				clientset:       clientset,
-				kmsProviderName: "kmsprovider",
+				clientset: clientset,
+				config:    Config{Analyzer: analyzer.Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")}},
 			}
 
-			seq, err := readOp.getLatestProviderSeq(context.Background(), tt.namespace)
+			latest, err := readOp.getLatestProvider(context.Background(), tt.namespace, "secrets")
 
 			if tt.expectedError != "" {
 				assert.Error(t, err)
 				assert.Contains(t, err.Error(), tt.expectedError)
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, tt.expectedSeq, seq)
+				assert.Equal(t, tt.expectedSeq, latest.Seq)
 			}
 		})
 	}
 }
+
+func TestReadOperation_getLatestProvider_CustomRegex(t *testing.T) {
+	encryptionConfig := `
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- providers:
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms.sock
+      name: kms-provider-v2-2024-06
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms-old.sock
+      name: kms-provider-v2-2023-11
+  resources:
+  - secrets
+`
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: encryptionProviderConfigName, Namespace: "test-namespace"},
+		Data:       map[string]string{encryptionConfigYAMLKey: encryptionConfig},
+	})
+
+	providerMatcher, err := utils.NewProviderNameMatcher("", `^kms-provider-v2-(?P<seq>\d{4})-(?P<seq>\d{2})$`)
+	assert.NoError(t, err)
+
+	readOp := &ReadOperation{clientset: clientset, config: Config{Analyzer: analyzer.Config{ProviderMatcher: providerMatcher}}}
+	latest, err := readOp.getLatestProvider(context.Background(), "test-namespace", "secrets")
+	assert.NoError(t, err)
+	assert.Equal(t, 202406, latest.Seq)
+
+	// Secrets are parsed with the same matcher, so the latest provider compares equal
+	result := analyzer.Classify([]*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:kms-provider-v2-2024-06:data")},
+	}, latest, readOp.config.Analyzer)
+	assert.True(t, result.AllSecretsUseLatestProvider)
+}
+
+func TestReadOperation_NameComparison(t *testing.T) {
+	encryptionConfig := `
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- providers:
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms-new.sock
+      name: azure-keyvault-blue
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms-old.sock
+      name: azure-keyvault-green
+  - identity: {}
+  resources:
+  - secrets
+`
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: encryptionProviderConfigName, Namespace: "test-namespace"},
+		Data:       map[string]string{encryptionConfigYAMLKey: encryptionConfig},
+	})
+	readOp := &ReadOperation{
+		clientset: clientset,
+		config:    Config{Analyzer: analyzer.Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider"), Comparison: analyzer.ComparisonName}},
+	}
+
+	latest, err := readOp.getLatestProvider(context.Background(), "test-namespace", "secrets")
+	assert.NoError(t, err)
+	assert.Equal(t, "azure-keyvault-blue", latest.Name)
+
+	tests := []struct {
+		name                         string
+		kvs                          []*mvccpb.KeyValue
+		expectedEncryptedSecrets     []string
+		expectedAllUseLatestProvider bool
+	}{
+		{
+			name: "all secrets on the first provider",
+			kvs: []*mvccpb.KeyValue{
+				{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:azure-keyvault-blue:data")},
+				{Key: []byte("/registry/secrets/default/secret2"), Value: []byte("k8s:enc:kms:v2:azure-keyvault-blue:data")},
+			},
+			expectedEncryptedSecrets:     []string{"default/secret1", "default/secret2"},
+			expectedAllUseLatestProvider: true,
+		},
+		{
+			name: "secret still on an older provider",
+			kvs: []*mvccpb.KeyValue{
+				{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:azure-keyvault-blue:data")},
+				{Key: []byte("/registry/secrets/default/secret2"), Value: []byte("k8s:enc:kms:v2:azure-keyvault-green:data")},
+			},
+			expectedEncryptedSecrets:     []string{"default/secret1", "default/secret2"},
+			expectedAllUseLatestProvider: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := analyzer.Classify(tt.kvs, latest, readOp.config.Analyzer)
+			assert.Equal(t, tt.expectedEncryptedSecrets, result.EncryptedSecrets)
+			assert.Empty(t, result.UnencryptedSecrets)
+			assert.Equal(t, tt.expectedAllUseLatestProvider, result.AllSecretsUseLatestProvider)
+		})
+	}
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	permissions := RequiredPermissions("test-namespace")
+	assert.Len(t, permissions, 1)
+	assert.Equal(t, "get", permissions[0].Verb)
+	assert.Equal(t, "configmaps", permissions[0].Resource)
+	assert.Equal(t, "test-namespace", permissions[0].Namespace)
+	assert.Equal(t, encryptionProviderConfigName, permissions[0].Name)
+}
+
+func TestReadOperation_Read_Sharded(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	encryptionConfig := `
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- providers:
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms.sock
+      name: kmsprovider2
+  resources:
+  - secrets
+`
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: encryptionProviderConfigName, Namespace: "test-namespace"},
+		Data:       map[string]string{encryptionConfigYAMLKey: encryptionConfig},
+	}, &v1.ConfigMap{
+		// Left by a shard removed by a scale-down
+		ObjectMeta: metav1.ObjectMeta{Name: "kms-reporter-shard-2", Namespace: "test-namespace", Labels: map[string]string{"app.kubernetes.io/managed-by": "kms-reporter", "kms-reporter/shard": "2"}},
+	})
+	store := shard.NewConfigMapStore(clientset, 0, 0)
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	etcdMock := mock_etcd.NewMockEtcdClientOperator(ctrl)
+
+	newShard := func(index int) *ReadOperation {
+		return NewReadOperator(etcdMock, clientset, recorderMock, Config{
+			Analyzer:   analyzer.Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")},
+			Shard:      shard.Config{Index: index, Count: 2},
+			ShardStore: store,
+		}).(*ReadOperation)
+	}
+	kv := func(key, provider string) *mvccpb.KeyValue {
+		return &mvccpb.KeyValue{Key: []byte(key), Value: []byte("k8s:enc:kms:v2:" + provider + ":data")}
+	}
+
+	// Shard 0 scans namespaces before "i", shard 1 the rest
+	etcdMock.EXPECT().Get(gomock.Any(), analyzer.DefaultPrefix+"/", gomock.Any()).Return(&clientv3.GetResponse{
+		Kvs: []*mvccpb.KeyValue{kv("/registry/secrets/default/secret1", "kmsprovider2")},
+	}, nil).Times(2)
+	etcdMock.EXPECT().Get(gomock.Any(), analyzer.DefaultPrefix+"/i", gomock.Any()).Return(&clientv3.GetResponse{
+		Kvs: []*mvccpb.KeyValue{kv("/registry/secrets/kube-system/secret2", "kmsprovider1")},
+	}, nil)
+
+	// The leader waits until every shard has stored a partial result
+	assert.NoError(t, newShard(0).Read(context.Background(), "test-namespace"))
+
+	// Other shards only store their partial result
+	assert.NoError(t, newShard(1).Read(context.Background(), "test-namespace"))
+
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", untimedReport{recorder.Report{Result: analyzer.Result{
+		EncryptedSecrets:   []string{"default/secret1", "kube-system/secret2"},
+		UnencryptedSecrets: []string{},
+		ProviderCounts:     map[string]int{"kmsprovider1": 1, "kmsprovider2": 1},
+		SequenceCounts:     map[int]int{1: 1, 2: 1},
+		LatestProvider:     analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2},
+		// The scan statistics of the shards add up, the shards scanning concurrently
+		Scan: analyzer.ScanStats{Keys: 2, Bytes: 134, Pages: 2, Concurrency: 2},
+	}, Progress: &recorder.Progress{OnLatest: 1, Total: 2, Percent: 50}}}).Return(nil)
+	assert.NoError(t, newShard(0).Read(context.Background(), "test-namespace"))
+	_, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.Background(), "kms-reporter-shard-2", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestReadOperation_Read_HashSharded(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	clientset := fake.NewSimpleClientset()
+	store := shard.NewConfigMapStore(clientset, 0, 0)
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	kv := func(key, provider string) *mvccpb.KeyValue {
+		return &mvccpb.KeyValue{Key: []byte(key), Value: []byte("k8s:enc:kms:v2:" + provider + ":data")}
+	}
+	// default and kube-system hash to shard 0, team-b to shard 1
+	etcdClient := etcd.NewMemoryClient([]*mvccpb.KeyValue{
+		kv("/registry/secrets/default/secret1", "kmsprovider2"),
+		kv("/registry/secrets/kube-system/secret2", "kmsprovider1"),
+		kv("/registry/secrets/team-b/secret3", "kmsprovider2"),
+	})
+	resolved := 0
+	newShard := func(index int) *ReadOperation {
+		return NewReadOperator(etcdClient, clientset, recorderMock, Config{
+			Analyzer: analyzer.Config{
+				ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+				LatestProvider: func(context.Context) (analyzer.LatestProvider, error) {
+					resolved++
+					return analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2}, nil
+				},
+			},
+			Shard:      shard.Config{Index: index, Count: 2, Mode: shard.ModeHash},
+			ShardStore: store,
+		}).(*ReadOperation)
+	}
+
+	assert.NoError(t, newShard(1).Read(context.Background(), "test-namespace"))
+	var report recorder.Report
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, r recorder.Report) error {
+		report = r
+		return nil
+	})
+	assert.NoError(t, newShard(0).Read(context.Background(), "test-namespace"))
+
+	assert.ElementsMatch(t, []string{"default/secret1", "kube-system/secret2", "team-b/secret3"}, report.EncryptedSecrets)
+	assert.Equal(t, map[string]int{"kmsprovider1": 1, "kmsprovider2": 2}, report.ProviderCounts)
+	assert.False(t, report.AllSecretsUseLatestProvider)
+	assert.Equal(t, int64(3), report.Scan.Keys)
+	assert.Equal(t, 2, report.Scan.Concurrency)
+	// Once per shard, not once per namespace
+	assert.Equal(t, 2, resolved)
+}
+
+func TestReadOperation_Record_Alerts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).Return(nil).Times(3)
+	var notified []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		notified = append(notified, string(body))
+	}))
+	defer server.Close()
+	notify, err := notifier.New(notifier.Config{URL: server.URL, Template: "{{.Event}}: {{.Message}}"})
+	require.NoError(t, err)
+	readOp := NewReadOperator(nil, nil, recorderMock, Config{
+		Alerts:   alert.NewEvaluator(alert.Thresholds{MaxUnencrypted: 0, ConsecutiveRuns: 2}),
+		Notifier: notify,
+	}).(*ReadOperation)
+	breaching := analyzer.Result{EncryptedSecrets: []string{"default/secret1"}, UnencryptedSecrets: []string{"default/secret2"}}
+	clean := analyzer.Result{EncryptedSecrets: []string{"default/secret1", "default/secret2"}}
+
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", breaching))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.AlertFiring))
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", breaching))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.AlertFiring))
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", clean))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.AlertFiring))
+	// Only the transitions are notified
+	assert.Equal(t, []string{
+		"AlertFiring: Alert firing: 1 unencrypted secrets exceed the maximum of 0",
+		"AlertResolved: Alert resolved",
+	}, notified)
+}
+
+func TestReadOperation_Record_CountsOnly(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// The recorder needs the names to count the secrets per namespace
+	breaching := analyzer.Result{EncryptedSecrets: []string{"default/secret1"}, UnencryptedSecrets: []string{"default/secret2"}}
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+		assert.Equal(t, breaching.UnencryptedSecrets, report.UnencryptedSecrets)
+		return nil
+	})
+	var notified []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		notified = append(notified, string(body))
+	}))
+	defer server.Close()
+	notify, err := notifier.New(notifier.Config{URL: server.URL, Template: "{{.Event}}: {{.Result.UnencryptedSecrets}} {{.Result.UnencryptedCount}}"})
+	require.NoError(t, err)
+	readOp := NewReadOperator(nil, nil, recorderMock, Config{
+		Alerts:     alert.NewEvaluator(alert.Thresholds{MaxUnencrypted: 0, ConsecutiveRuns: 1}),
+		Notifier:   notify,
+		CountsOnly: true,
+	}).(*ReadOperation)
+
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", breaching))
+	assert.Equal(t, []string{"AlertFiring: [] 1"}, notified)
+}
+
+func TestProgressLogger(t *testing.T) {
+	progress := newProgressLogger()
+	progress(analyzer.Progress{Processed: 1, Total: 4, Page: 1})
+	assert.Equal(t, 0.25, testutil.ToFloat64(metrics.ScanProgress))
+	progress(analyzer.Progress{Processed: 3, Total: 4, Page: 2, Elapsed: time.Second})
+	assert.Equal(t, 0.75, testutil.ToFloat64(metrics.ScanProgress))
+}
+
+// recordingEmitter records the reasons of the emitted events
+type recordingEmitter struct {
+	reasons []string
+}
+
+func (e *recordingEmitter) Emit(_ context.Context, _, reason, _ string) {
+	e.reasons = append(e.reasons, reason)
+}
+
+func TestReadOperation_NotCovered(t *testing.T) {
+	encryptionConfig := `
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- providers:
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms.sock
+      name: kmsprovider3
+  resources:
+  - configmaps
+`
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: encryptionProviderConfigName, Namespace: "test-namespace"},
+		Data:       map[string]string{encryptionConfigYAMLKey: encryptionConfig},
+	})
+	emitter := &recordingEmitter{}
+	ctrl := gomock.NewController(t)
+	mockRecorder := mock_recorder.NewMockRecorderOperator(ctrl)
+	readOp := &ReadOperation{
+		clientset:        clientset,
+		RecorderOperator: mockRecorder,
+		config:           Config{Analyzer: analyzer.Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")}, Events: emitter},
+	}
+
+	latest, err := readOp.getLatestProvider(context.Background(), "test-namespace", "secrets")
+	assert.NoError(t, err)
+	assert.True(t, latest.NotCovered)
+	// Not the provider of configmaps: secrets are written in plaintext
+	assert.Equal(t, analyzer.IdentityProviderSeq, latest.Seq)
+	assert.Empty(t, latest.Name)
+
+	result := analyzer.Result{UnencryptedSecrets: []string{"default/secret1"}, LatestProvider: latest}
+	mockRecorder.EXPECT().Record(gomock.Any(), "test-namespace", recorder.Report{Result: result, Progress: &recorder.Progress{Total: 1}}).Return(nil)
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", result))
+	assert.Equal(t, []string{events.ReasonResourceNotCovered}, emitter.reasons)
+
+	// A covered resource emits no event
+	emitter.reasons = nil
+	result.LatestProvider.NotCovered = false
+	mockRecorder.EXPECT().Record(gomock.Any(), "test-namespace", recorder.Report{Result: result, Progress: &recorder.Progress{Total: 1}}).Return(nil)
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", result))
+	assert.Empty(t, emitter.reasons)
+}
+
+func TestReadOperation_Record_RotationComplete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).Return(nil).AnyTimes()
+	emitter := &recordingEmitter{}
+	readOp := NewReadOperator(nil, nil, recorderMock, Config{Events: emitter}).(*ReadOperation)
+	latest := analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2}
+	rotating := analyzer.Result{EncryptedSecrets: []string{"default/secret1", "default/secret2"}, LatestProvider: latest}
+	complete := analyzer.Result{EncryptedSecrets: []string{"default/secret1", "default/secret2"}, AllSecretsUseLatestProvider: true, LatestProvider: latest}
+	record := func(result analyzer.Result) {
+		assert.NoError(t, readOp.record(context.Background(), "test-namespace", result))
+	}
+
+	// The first result only sets the state, even when the rotation is already complete
+	record(complete)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.RotationComplete))
+	assert.Empty(t, emitter.reasons)
+
+	record(rotating)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.RotationComplete))
+	record(rotating)
+	assert.Empty(t, emitter.reasons)
+
+	// The transition is announced once
+	record(complete)
+	record(complete)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.RotationComplete))
+	assert.Equal(t, []string{events.ReasonRotationComplete}, emitter.reasons)
+
+	// Plaintext secrets are never complete, whatever the provider comparison says
+	record(analyzer.Result{UnencryptedSecrets: []string{"default/secret3"}, AllSecretsUseLatestProvider: true, LatestProvider: latest})
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.RotationComplete))
+}
+
+func TestReadOperation_Record_Progress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var reports []recorder.Report
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+		reports = append(reports, report)
+		return nil
+	}).AnyTimes()
+	readOp := NewReadOperator(nil, nil, recorderMock, Config{}).(*ReadOperation)
+	latest := analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2}
+	result := func(onLatest int) analyzer.Result {
+		return analyzer.Result{
+			EncryptedSecrets: make([]string, 1000),
+			LatestProvider:   latest,
+			ProviderCounts:   map[string]int{"kmsprovider1": 1000 - onLatest, "kmsprovider2": onLatest},
+		}
+	}
+
+	// The first result has no rate yet
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", result(100)))
+	assert.True(t, reports[0].EstimatedCompletion.IsZero())
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.RotationEstimatedCompletion))
+	assert.Equal(t, &recorder.Progress{OnLatest: 100, Total: 1000, Percent: 10}, reports[0].Progress)
+
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", result(200)))
+	estimate := reports[1].EstimatedCompletion
+	assert.WithinDuration(t, time.Now(), estimate, time.Minute)
+	assert.Equal(t, float64(estimate.Unix()), testutil.ToFloat64(metrics.RotationEstimatedCompletion))
+	assert.Equal(t, &recorder.Progress{OnLatest: 200, Total: 1000, Percent: 20, Delta: 10}, reports[1].Progress)
+	assert.Equal(t, float64(20), testutil.ToFloat64(metrics.RotationProgressPercent))
+
+	// A complete rotation has no estimate
+	complete := result(1000)
+	complete.AllSecretsUseLatestProvider = true
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", complete))
+	assert.True(t, reports[2].EstimatedCompletion.IsZero())
+}
+
+func TestReadOperation_Record_Remediation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var reports []recorder.Report
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+		reports = append(reports, report)
+		return nil
+	}).AnyTimes()
+	clientset := fake.NewSimpleClientset()
+	remediator, err := remediation.NewRemediator(clientset, remediation.Config{Image: "bitnami/kubectl:1.33"})
+	require.NoError(t, err)
+	readOp := NewReadOperator(nil, nil, recorderMock, Config{Remediator: remediator}).(*ReadOperation)
+	result := analyzer.Result{
+		EncryptedSecrets:   []string{"default/a", "team-a/b"},
+		UnencryptedSecrets: []string{"team-b/c"},
+		LatestProvider:     analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2},
+		ProviderCounts:     map[string]int{"kmsprovider1": 1, "kmsprovider2": 1, "identity": 1},
+		StaleNamespaces:    map[string]int{"team-a": 1, "team-b": 1},
+	}
+
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", result))
+	assert.Equal(t, &remediation.Summary{Active: 2, Created: 2}, reports[0].Remediation)
+	jobs, err := clientset.BatchV1().Jobs("team-b").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, jobs.Items, 1)
+
+	// Without a KMS provider, rewriting the secrets would not encrypt them
+	result.LatestProvider = analyzer.LatestProvider{Seq: analyzer.IdentityProviderSeq}
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", result))
+	assert.Nil(t, reports[1].Remediation)
+}
+
+func TestReadOperation_Record_Members(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var reports []recorder.Report
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+		reports = append(reports, report)
+		return nil
+	}).AnyTimes()
+	member := func(endpoint string, revision int64) etcd.Member {
+		client := mock_etcd.NewMockEtcdClientOperator(ctrl)
+		client.EXPECT().Get(gomock.Any(), analyzer.DefaultPrefix, gomock.Any()).Return(&clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: revision}, Count: 1}, nil)
+		return etcd.Member{Endpoint: endpoint, Client: client}
+	}
+	members, err := etcd.NewMemberComparer([]etcd.Member{member("https://10.0.0.1:2379", 5000), member("https://10.0.0.2:2379", 2000)}, etcd.MemberComparerConfig{})
+	require.NoError(t, err)
+	readOp := NewReadOperator(nil, nil, recorderMock, Config{Members: members}).(*ReadOperation)
+
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", analyzer.Result{EncryptedSecrets: []string{"default/a"}, AllSecretsUseLatestProvider: true}))
+	require.Len(t, reports[0].Members, 2)
+	assert.True(t, reports[0].Members[1].Lagging)
+	assert.Equal(t, 3000.0, testutil.ToFloat64(metrics.EtcdMemberRevisionLag.WithLabelValues("https://10.0.0.2:2379")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.EtcdMemberRevisionLag.WithLabelValues("https://10.0.0.1:2379")))
+}
+
+func TestReadOperation_Record_Transformation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var reports []recorder.Report
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+		reports = append(reports, report)
+		return nil
+	}).AnyTimes()
+	failed := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, "apiserver_storage_transformation_operations_total{status=\"Unavailable\",transformation_type=\"to_storage\",transformer_prefix=\"k8s:enc:kms:v2:\"} %d\n", failed)
+	}))
+	defer server.Close()
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	require.NoError(t, err)
+	emitter := &recordingEmitter{}
+	readOp := NewReadOperator(nil, nil, recorderMock, Config{
+		Transformation: transformation.NewMonitor(clientset.Discovery().RESTClient(), transformation.Config{}),
+		Events:         emitter,
+	}).(*ReadOperation)
+	result := analyzer.Result{EncryptedSecrets: []string{"default/a"}, AllSecretsUseLatestProvider: true}
+
+	// The first run only records the counters
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", result))
+	assert.Nil(t, reports[0].Transformation)
+
+	failed = 4
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", result))
+	assert.Equal(t, &transformation.Errors{Writes: 4, Statuses: map[string]int64{"Unavailable": 4}}, reports[1].Transformation)
+	assert.Equal(t, 4.0, testutil.ToFloat64(metrics.TransformationErrors.WithLabelValues("write")))
+	assert.Equal(t, []string{events.ReasonTransformationErrors}, emitter.reasons)
+
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", result))
+	assert.Equal(t, &transformation.Errors{}, reports[2].Transformation)
+	assert.Len(t, emitter.reasons, 1)
+}
+
+func TestReadOperation_Record_Recency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var reports []recorder.Report
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+		reports = append(reports, report)
+		return nil
+	}).AnyTimes()
+	rotatedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"items":[{"metadata":{"namespace":"default","name":"a","creationTimestamp":"2024-06-01T00:00:00Z"}},{"metadata":{"namespace":"default","name":"b","creationTimestamp":"2025-02-01T00:00:00Z"}}]}`)
+	}))
+	defer server.Close()
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	require.NoError(t, err)
+	result := analyzer.Result{EncryptedSecrets: []string{"default/a", "default/b"}, AllSecretsUseLatestProvider: true}
+
+	readOp := NewReadOperator(nil, nil, recorderMock, Config{
+		Recency: recency.NewChecker(clientset.CoreV1().RESTClient(), recency.Config{Since: rotatedAt}),
+	}).(*ReadOperation)
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", result))
+	assert.Equal(t, &recency.Summary{Since: rotatedAt, Checked: 2, NotRewritten: 1, Secrets: []string{"default/a"}}, reports[0].Recency)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.SecretsNotRewritten))
+
+	// Counts-only reports do not name the secrets
+	readOp.config.CountsOnly = true
+	assert.NoError(t, readOp.record(context.Background(), "test-namespace", result))
+	assert.Equal(t, &recency.Summary{Since: rotatedAt, Checked: 2, NotRewritten: 1}, reports[1].Recency)
+}
+
+func TestReadOperation_Read_TargetProvider(t *testing.T) {
+	encryptionConfig := `
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- providers:
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms.sock
+      name: kmsprovider1
+  resources:
+  - secrets
+`
+	etcdCli := etcd.NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data"), ModRevision: 1},
+		{Key: []byte("/registry/secrets/default/secret2"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data"), ModRevision: 2},
+	})
+	target := analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2}
+	var recorded recorder.Report
+	ctrl := gomock.NewController(t)
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+		recorded = report
+		return nil
+	}).Times(2)
+	newReader := func(clientset *fake.Clientset) ReaderOperator {
+		return NewReadOperator(etcdCli, clientset, recorderMock, Config{
+			Analyzer:       analyzer.Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")},
+			TargetProvider: &target,
+		})
+	}
+
+	// The target overrides the latest provider of the encryption configuration
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: encryptionProviderConfigName, Namespace: "test-namespace"},
+		Data:       map[string]string{encryptionConfigYAMLKey: encryptionConfig},
+	})
+	assert.NoError(t, newReader(clientset).Read(context.Background(), "test-namespace"))
+	assert.Equal(t, target, recorded.LatestProvider)
+	assert.False(t, recorded.AllSecretsUseLatestProvider)
+
+	// Without an encryption configuration the target is used as is
+	assert.NoError(t, newReader(fake.NewSimpleClientset()).Read(context.Background(), "test-namespace"))
+	assert.Equal(t, target, recorded.LatestProvider)
+}
+
+func TestReadOperation_Read_History(t *testing.T) {
+	etcdCli := etcd.NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data"), ModRevision: 1},
+		{Key: []byte("/registry/secrets/default/secret2"), Value: []byte("k8s\x00plaintext"), ModRevision: 2},
+	})
+	var recorded recorder.Report
+	ctrl := gomock.NewController(t)
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+		recorded = report
+		return nil
+	})
+	clientset := fake.NewSimpleClientset()
+	store := history.NewConfigMapStore(clientset, "test-namespace", "kms-reporter-history", 0)
+	var findings int
+	readOp := NewReadOperator(etcdCli, clientset, recorderMock, Config{
+		Analyzer: analyzer.Config{
+			ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+			LatestProvider:  analyzer.StaticProvider(analyzer.LatestProvider{Name: "kmsprovider1", Seq: 1}),
+			Findings:        func(analyzer.Finding) { findings++ },
+		},
+		History: history.NewTracker(store, history.Config{Days: 30}),
+	})
+
+	assert.NoError(t, readOp.Read(context.Background(), "test-namespace"))
+	require.NotNil(t, recorded.History)
+	assert.Equal(t, 2, recorded.History.Tracked)
+	assert.Equal(t, 0, recorded.History.Unchanged)
+	// The configured findings callback is still called
+	assert.Equal(t, 2, findings)
+	states, err := store.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "kmsprovider1", states["default/secret1"].Provider)
+	assert.Equal(t, analyzer.IdentityProviderName, states["default/secret2"].Provider)
+}
+
+func TestReadOperation_Read_DeletedSecrets(t *testing.T) {
+	etcdCli := etcd.NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data"), ModRevision: 1},
+	})
+	var recorded recorder.Report
+	ctrl := gomock.NewController(t)
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+		recorded = report
+		return nil
+	})
+	clientset := fake.NewSimpleClientset()
+	store := history.NewConfigMapStore(clientset, "test-namespace", "kms-reporter-history", 0)
+	// The previous run observed a secret deleted since
+	require.NoError(t, store.Save(context.Background(), map[string]history.State{
+		"default/secret1": {Provider: "kmsprovider1"},
+		"default/secret2": {Provider: "kmsprovider1"},
+	}))
+	readOp := NewReadOperator(etcdCli, clientset, recorderMock, Config{
+		Analyzer: analyzer.Config{
+			ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+			LatestProvider:  analyzer.StaticProvider(analyzer.LatestProvider{Name: "kmsprovider1", Seq: 1}),
+		},
+		History: history.NewTracker(store, history.Config{}),
+	})
+
+	assert.NoError(t, readOp.Read(context.Background(), "test-namespace"))
+	assert.Nil(t, recorded.History)
+	assert.Equal(t, &history.Deletion{Previous: 2, Deleted: 1, Secrets: []string{"default/secret2"}}, recorded.Deleted)
+}
+
+func TestReadOperation_Read_Teams(t *testing.T) {
+	etcdCli := etcd.NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s\x00plaintext")},
+		{Key: []byte("/registry/secrets/payments/secret2"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+		{Key: []byte("/registry/secrets/payments-staging/secret3"), Value: []byte("k8s\x00plaintext")},
+	})
+	ctrl := gomock.NewController(t)
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	var teamReport recorder.Report
+	gomock.InOrder(
+		recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+			assert.Len(t, report.UnencryptedSecrets, 2)
+			return nil
+		}),
+		recorderMock.EXPECT().Record(gomock.Any(), "payments", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+			teamReport = report
+			return nil
+		}),
+		recorderMock.EXPECT().Record(gomock.Any(), "search", gomock.Any()).Return(errors.New("forbidden")),
+	)
+	clientset := fake.NewSimpleClientset()
+	readOp := NewReadOperator(etcdCli, clientset, recorderMock, Config{
+		Analyzer: analyzer.Config{
+			ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+			LatestProvider:  analyzer.StaticProvider(analyzer.LatestProvider{Name: "kmsprovider1", Seq: 1}),
+		},
+		Teams: teams.NewViews(clientset, teams.Config{Teams: []teams.Team{
+			{Name: "payments", Namespaces: []string{"payments*"}, ReportNamespace: "payments"},
+			{Name: "search", Namespaces: []string{"search"}, ReportNamespace: "search"},
+		}}),
+	})
+
+	// A failing team fails the run once the other teams are recorded
+	assert.ErrorContains(t, readOp.Read(context.Background(), "test-namespace"), "failed to store the report of team search in recorder: forbidden")
+	assert.Equal(t, "payments", teamReport.Team)
+	assert.Equal(t, []string{"payments/secret2"}, teamReport.EncryptedSecrets)
+	assert.Equal(t, []string{"payments-staging/secret3"}, teamReport.UnencryptedSecrets)
+	assert.Equal(t, map[string]int{"kmsprovider1": 1, analyzer.IdentityProviderName: 1}, teamReport.ProviderCounts)
+}
+
+func TestReadOperation_Read_ExtraPrefixes(t *testing.T) {
+	encryptionConfig := `
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- providers:
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms.sock
+      name: kmsprovider1
+  resources:
+  - secrets
+`
+	etcdCli := etcd.NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/registry/configmaps/default/config1"), Value: []byte("k8s\x00plaintext"), ModRevision: 1},
+		{Key: []byte("/registry/oauth.openshift.io/oauthaccesstokens/token1"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data"), ModRevision: 2},
+		{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data"), ModRevision: 3},
+	})
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: encryptionProviderConfigName, Namespace: "test-namespace"},
+		Data:       map[string]string{encryptionConfigYAMLKey: encryptionConfig},
+	})
+	recorded := map[string]recorder.Report{}
+	ctrl := gomock.NewController(t)
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+		recorded[report.Resource] = report
+		return nil
+	}).Times(3)
+	emitter := &recordingEmitter{}
+	readOp := NewReadOperator(etcdCli, clientset, recorderMock, Config{
+		Analyzer:      analyzer.Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")},
+		ExtraPrefixes: []string{"/registry/configmaps", "/registry/oauth.openshift.io/oauthaccesstokens", "/registry/empty"},
+		Events:        emitter,
+	})
+
+	// Every prefix is recorded in its own report, except the empty one
+	assert.NoError(t, readOp.Read(context.Background(), "test-namespace"))
+	assert.Len(t, recorded, 3)
+	assert.Equal(t, []string{"default/secret1"}, recorded[""].EncryptedSecrets)
+	assert.False(t, recorded[""].LatestProvider.NotCovered)
+	assert.Equal(t, []string{"default/config1"}, recorded["configmaps"].UnencryptedSecrets)
+	assert.Equal(t, []string{"token1"}, recorded["oauthaccesstokens.oauth.openshift.io"].EncryptedSecrets)
+	// Coverage is checked per resource, whatever the encryption configuration declares
+	assert.True(t, recorded["configmaps"].LatestProvider.NotCovered)
+	assert.Equal(t, []string{events.ReasonResourceNotCovered, events.ReasonResourceNotCovered}, emitter.reasons)
+}
+
+func TestReadOperation_Read_ProviderPerResource(t *testing.T) {
+	// configmaps are listed first, on a provider of their own
+	encryptionConfig := `
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- providers:
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms2.sock
+      name: kmsprovider2
+  resources:
+  - configmaps
+- providers:
+  - kms:
+      apiVersion: v2
+      endpoint: unix:///tmp/kms.sock
+      name: kmsprovider1
+  resources:
+  - secrets
+`
+	etcdCli := etcd.NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/registry/configmaps/default/config1"), Value: []byte("k8s:enc:kms:v2:kmsprovider2:data"), ModRevision: 1},
+		{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data"), ModRevision: 2},
+	})
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: encryptionProviderConfigName, Namespace: "test-namespace"},
+		Data:       map[string]string{encryptionConfigYAMLKey: encryptionConfig},
+	})
+	recorded := map[string]recorder.Report{}
+	ctrl := gomock.NewController(t)
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, report recorder.Report) error {
+		recorded[report.Resource] = report
+		return nil
+	}).Times(2)
+	readOp := NewReadOperator(etcdCli, clientset, recorderMock, Config{
+		Analyzer:      analyzer.Config{ProviderMatcher: mustProviderMatcher(t, "kmsprovider")},
+		ExtraPrefixes: []string{"/registry/configmaps"},
+	})
+
+	// Each resource is compared against the provider of its own entry
+	assert.NoError(t, readOp.Read(context.Background(), "test-namespace"))
+	assert.Equal(t, analyzer.LatestProvider{Name: "kmsprovider1", Seq: 1}, recorded[""].LatestProvider)
+	assert.True(t, recorded[""].AllSecretsUseLatestProvider)
+	assert.Equal(t, analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2}, recorded["configmaps"].LatestProvider)
+	assert.True(t, recorded["configmaps"].AllSecretsUseLatestProvider)
+}
+
+func TestReadOperation_Read_Retention(t *testing.T) {
+	etcdCli := etcd.NewMemoryClient([]*mvccpb.KeyValue{
+		{Key: []byte("/registry/secrets/default/secret1"), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data"), ModRevision: 1},
+		{Key: []byte("/registry/configmaps/default/config1"), Value: []byte("k8s\x00plaintext"), ModRevision: 2},
+	})
+	labels := map[string]string{"app.kubernetes.io/managed-by": "kms-reporter", "app.kubernetes.io/name": "kms-reporter"}
+	clientset := fake.NewSimpleClientset(
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kms-reporter-configmaps", Namespace: "test-namespace", Labels: labels}},
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kms-reporter-oauthaccesstokens.oauth.openshift.io", Namespace: "test-namespace", Labels: labels}},
+	)
+	ctrl := gomock.NewController(t)
+	recorderMock := mock_recorder.NewMockRecorderOperator(ctrl)
+	readOp := NewReadOperator(etcdCli, clientset, recorderMock, Config{
+		Analyzer: analyzer.Config{
+			ProviderMatcher: mustProviderMatcher(t, "kmsprovider"),
+			LatestProvider:  analyzer.StaticProvider(analyzer.LatestProvider{Name: "kmsprovider1", Seq: 1}),
+		},
+		ExtraPrefixes: []string{"/registry/configmaps"},
+		Retention: retention.NewCollector(clientset, retention.Config{
+			Namespace: "test-namespace",
+			Keep:      map[string][]string{"test-namespace": {"kms-reporter", "kms-reporter-configmaps"}},
+		}),
+	})
+	unused := func() map[string]bool {
+		configMaps, err := clientset.CoreV1().ConfigMaps("test-namespace").List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+		unused := map[string]bool{}
+		for _, configMap := range configMaps.Items {
+			unused[configMap.Name] = configMap.Annotations[recorder.UnusedSinceAnnotation] != ""
+		}
+		return unused
+	}
+
+	// Nothing is collected when a report fails to be written
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).Return(nil)
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).Return(errors.New("conflict"))
+	assert.Error(t, readOp.Read(context.Background(), "test-namespace"))
+	assert.Equal(t, map[string]bool{"kms-reporter-configmaps": false, "kms-reporter-oauthaccesstokens.oauth.openshift.io": false}, unused())
+
+	// The report of the removed prefix is marked, then deleted on the next run
+	recorderMock.EXPECT().Record(gomock.Any(), "test-namespace", gomock.Any()).Return(nil).Times(4)
+	assert.NoError(t, readOp.Read(context.Background(), "test-namespace"))
+	assert.Equal(t, map[string]bool{"kms-reporter-configmaps": false, "kms-reporter-oauthaccesstokens.oauth.openshift.io": true}, unused())
+	assert.NoError(t, readOp.Read(context.Background(), "test-namespace"))
+	assert.Equal(t, map[string]bool{"kms-reporter-configmaps": false}, unused())
+}
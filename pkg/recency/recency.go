@@ -0,0 +1,176 @@
+// Package recency flags encrypted secrets that were not rewritten since a date, e.g. the last key
+// rotation. A KMS plugin may rotate its key behind an unchanged provider name, so a secret encrypted by
+// the latest provider may still be encrypted with a key that was rotated out: only a write since the
+// rotation proves that it is encrypted with the current key. etcd does not record when a key was
+// written, so the time of the last write is read from the API: the creation timestamp of the secret
+// and the times of its managed fields entries.
+package recency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+const (
+	// secretsPath lists the secrets of every namespace
+	secretsPath = "/api/v1/secrets"
+	// metadataAccept asks the API server for the metadata of the secrets only, without their data
+	metadataAccept = "application/json;as=PartialObjectMetadataList;g=meta.k8s.io;v=v1"
+
+	// DefaultPageSize is the number of secrets listed per request.
+	DefaultPageSize = 500
+	// DefaultMaxNames is the number of secrets not rewritten since the date that are named in the summary.
+	DefaultMaxNames = 100
+)
+
+// Summary counts the encrypted secrets that were not rewritten since a date.
+type Summary struct {
+	// Since is the date every encrypted secret must have been written since.
+	Since time.Time `json:"since"`
+	// Checked is the number of encrypted secrets whose last write was read from the API.
+	Checked int `json:"checked"`
+	// NotRewritten is the number of checked secrets last written before Since.
+	NotRewritten int `json:"notRewritten"`
+	// Secrets names the first secrets last written before Since, oldest first.
+	Secrets []string `json:"secrets,omitempty"`
+	// Unchecked is the number of encrypted secrets that could not be checked: their names were left out
+	// of the result, or they were deleted between the scan and the listing.
+	Unchecked int `json:"unchecked,omitempty"`
+}
+
+// Config configures the checker.
+type Config struct {
+	// Since is the date every encrypted secret must have been written since, e.g. the date of the last
+	// key rotation.
+	Since time.Time
+	// PageSize is the number of secrets listed per request. Defaults to DefaultPageSize.
+	PageSize int64
+	// MaxNames is the number of secrets named in the summary. Defaults to DefaultMaxNames.
+	MaxNames int
+	// RequestTimeout bounds each request. 0 disables the limit.
+	RequestTimeout time.Duration
+}
+
+// Checker compares the last write of the encrypted secrets of a result with a date.
+type Checker struct {
+	client rest.Interface
+	config Config
+}
+
+// NewChecker returns a checker listing secrets through client, e.g. the REST client of the core/v1
+// client.
+func NewChecker(client rest.Interface, config Config) *Checker {
+	if config.PageSize <= 0 {
+		config.PageSize = DefaultPageSize
+	}
+	if config.MaxNames <= 0 {
+		config.MaxNames = DefaultMaxNames
+	}
+	return &Checker{client: client, config: config}
+}
+
+// RequiredPermissions lists the Kubernetes API access the checker needs. Only the metadata of the secrets
+// is requested, but RBAC does not tell it apart from their data.
+func RequiredPermissions() []rbac.Permission {
+	return []rbac.Permission{{Verb: "list", Resource: "secrets"}}
+}
+
+// Check returns the encrypted secrets of result that were last written before Config.Since.
+func (c *Checker) Check(ctx context.Context, result analyzer.Result) (*Summary, error) {
+	lastWrites, err := c.lastWrites(ctx)
+	if err != nil {
+		return nil, err
+	}
+	summary := &Summary{Since: c.config.Since.UTC(), Unchecked: result.OmittedEncrypted}
+	type stale struct {
+		name      string
+		lastWrite time.Time
+	}
+	var notRewritten []stale
+	for _, name := range result.EncryptedSecrets {
+		lastWrite, ok := lastWrites[name]
+		if !ok {
+			summary.Unchecked++
+			continue
+		}
+		summary.Checked++
+		if lastWrite.Before(c.config.Since) {
+			notRewritten = append(notRewritten, stale{name: name, lastWrite: lastWrite})
+		}
+	}
+	summary.NotRewritten = len(notRewritten)
+	sort.Slice(notRewritten, func(i, j int) bool {
+		if !notRewritten[i].lastWrite.Equal(notRewritten[j].lastWrite) {
+			return notRewritten[i].lastWrite.Before(notRewritten[j].lastWrite)
+		}
+		return notRewritten[i].name < notRewritten[j].name
+	})
+	for _, secret := range notRewritten[:min(len(notRewritten), c.config.MaxNames)] {
+		summary.Secrets = append(summary.Secrets, secret.name)
+	}
+	return summary, nil
+}
+
+// lastWrites lists the metadata of every secret, page by page, and returns the time of the last write of
+// each, by namespace/name.
+func (c *Checker) lastWrites(ctx context.Context) (map[string]time.Time, error) {
+	lastWrites := map[string]time.Time{}
+	continueToken := ""
+	for {
+		list, err := c.listPage(ctx, continueToken)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			item := &list.Items[i]
+			lastWrites[item.Namespace+"/"+item.Name] = LastWrite(item)
+		}
+		if continueToken = list.Continue; continueToken == "" {
+			return lastWrites, nil
+		}
+	}
+}
+
+// listPage returns the page of secret metadata starting at continueToken.
+func (c *Checker) listPage(ctx context.Context, continueToken string) (*metav1.PartialObjectMetadataList, error) {
+	requestCtx, cancel := utils.ContextWithTimeout(ctx, c.config.RequestTimeout)
+	defer cancel()
+	request := c.client.Get().AbsPath(secretsPath).
+		SetHeader("Accept", metadataAccept).
+		Param("limit", strconv.FormatInt(c.config.PageSize, 10))
+	if continueToken != "" {
+		request = request.Param("continue", continueToken)
+	}
+	data, err := request.DoRaw(requestCtx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secret metadata: %w", err)
+	}
+	var list metav1.PartialObjectMetadataList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse secret metadata: %w", err)
+	}
+	return &list, nil
+}
+
+// LastWrite returns the time object was last written: the latest of its creation timestamp and the times
+// of its managed fields entries. Every write stores the whole object again, encrypted with the current key.
+func LastWrite(object metav1.Object) time.Time {
+	lastWrite := object.GetCreationTimestamp().Time
+	for _, entry := range object.GetManagedFields() {
+		if entry.Time != nil && entry.Time.After(lastWrite) {
+			lastWrite = entry.Time.Time
+		}
+	}
+	return lastWrite
+}
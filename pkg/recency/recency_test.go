@@ -0,0 +1,118 @@
+package recency
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+var rotatedAt = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// secretMetadata returns the metadata of a secret created at created and last updated at updated
+func secretMetadata(namespace, name string, created, updated time.Time) metav1.PartialObjectMetadata {
+	return metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{
+		Namespace:         namespace,
+		Name:              name,
+		CreationTimestamp: metav1.NewTime(created),
+		ManagedFields: []metav1.ManagedFieldsEntry{
+			{Manager: "kubectl-create", Time: &metav1.Time{Time: created}},
+			{Manager: "kubectl-edit", Time: &metav1.Time{Time: updated}},
+		},
+	}}
+}
+
+// newTestChecker returns a checker listing pages, one per request
+func newTestChecker(t *testing.T, config Config, pages ...[]metav1.PartialObjectMetadata) *Checker {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != secretsPath || r.Header.Get("Accept") != metadataAccept {
+			http.NotFound(w, r)
+			return
+		}
+		page := 0
+		if token := r.URL.Query().Get("continue"); token != "" {
+			require.NoError(t, json.Unmarshal([]byte(token), &page))
+		}
+		list := metav1.PartialObjectMetadataList{Items: pages[page]}
+		if page+1 < len(pages) {
+			continueToken, _ := json.Marshal(page + 1)
+			list.Continue = string(continueToken)
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(list))
+	}))
+	t.Cleanup(server.Close)
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	require.NoError(t, err)
+	return NewChecker(clientset.CoreV1().RESTClient(), config)
+}
+
+func TestChecker_Check(t *testing.T) {
+	checker := newTestChecker(t, Config{Since: rotatedAt, PageSize: 2},
+		[]metav1.PartialObjectMetadata{
+			secretMetadata("default", "old", rotatedAt.AddDate(-1, 0, 0), rotatedAt.AddDate(0, -1, 0)),
+			secretMetadata("default", "rewritten", rotatedAt.AddDate(-1, 0, 0), rotatedAt.AddDate(0, 0, 1)),
+		},
+		[]metav1.PartialObjectMetadata{
+			secretMetadata("team-a", "older", rotatedAt.AddDate(-2, 0, 0), rotatedAt.AddDate(-2, 0, 0)),
+			secretMetadata("team-a", "new", rotatedAt.AddDate(0, 0, 2), rotatedAt.AddDate(0, 0, 2)),
+			secretMetadata("team-a", "plain", rotatedAt.AddDate(-2, 0, 0), rotatedAt.AddDate(-2, 0, 0)),
+		},
+	)
+
+	summary, err := checker.Check(context.Background(), analyzer.Result{
+		EncryptedSecrets:   []string{"default/old", "default/rewritten", "team-a/older", "team-a/new", "team-a/deleted"},
+		UnencryptedSecrets: []string{"team-a/plain"},
+		OmittedEncrypted:   2,
+	})
+	require.NoError(t, err)
+	// Unencrypted secrets are reported as such already
+	assert.Equal(t, &Summary{
+		Since:        rotatedAt,
+		Checked:      4,
+		NotRewritten: 2,
+		Secrets:      []string{"team-a/older", "default/old"},
+		Unchecked:    3,
+	}, summary)
+}
+
+func TestChecker_Check_MaxNames(t *testing.T) {
+	checker := newTestChecker(t, Config{Since: rotatedAt, MaxNames: 1}, []metav1.PartialObjectMetadata{
+		secretMetadata("default", "a", rotatedAt.AddDate(-1, 0, 0), rotatedAt.AddDate(-1, 0, 0)),
+		secretMetadata("default", "b", rotatedAt.AddDate(-1, 0, 0), rotatedAt.AddDate(-1, 0, 0)),
+	})
+	summary, err := checker.Check(context.Background(), analyzer.Result{EncryptedSecrets: []string{"default/b", "default/a"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.NotRewritten)
+	assert.Equal(t, []string{"default/a"}, summary.Secrets)
+}
+
+func TestChecker_Check_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	require.NoError(t, err)
+	_, err = NewChecker(clientset.CoreV1().RESTClient(), Config{Since: rotatedAt}).Check(context.Background(), analyzer.Result{})
+	assert.ErrorContains(t, err, "failed to list secret metadata")
+}
+
+func TestLastWrite(t *testing.T) {
+	created := rotatedAt.AddDate(-1, 0, 0)
+	metadata := secretMetadata("default", "a", created, rotatedAt)
+	assert.Equal(t, rotatedAt, LastWrite(&metadata))
+
+	// Without managed fields, e.g. on clusters that strip them, the creation is the last known write
+	metadata.ManagedFields = nil
+	assert.Equal(t, created, LastWrite(&metadata))
+}
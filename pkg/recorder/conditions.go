@@ -0,0 +1,145 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// Types of the conditions in CONDITIONS, in the order they are written
+const (
+	// ConditionEncrypted is True if every secret is encrypted.
+	ConditionEncrypted = "Encrypted"
+	// ConditionOnLatestProvider is True if every secret is encrypted by the latest provider, and Unknown
+	// while some secrets are not encrypted at all.
+	ConditionOnLatestProvider = "OnLatestProvider"
+	// ConditionTransformationHealthy is True if the API server encrypted and decrypted every secret with
+	// the KMS provider since the previous run. Only set when the API server is monitored.
+	ConditionTransformationHealthy = "TransformationHealthy"
+	// ConditionScanHealthy is True if the last run succeeded.
+	ConditionScanHealthy = "ScanHealthy"
+)
+
+var conditionOrder = map[string]int{
+	ConditionEncrypted:             0,
+	ConditionOnLatestProvider:      1,
+	ConditionTransformationHealthy: 2,
+	ConditionScanHealthy:           3,
+}
+
+// reportConditions returns the conditions derived from the result of a successful run.
+func reportConditions(report Report) []metav1.Condition {
+	encrypted := metav1.Condition{
+		Type:    ConditionEncrypted,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AllSecretsEncrypted",
+		Message: fmt.Sprintf("All %d secrets are encrypted", report.Total()),
+	}
+	switch {
+	case report.LatestProvider.NotCovered:
+		encrypted.Status = metav1.ConditionFalse
+		encrypted.Reason = "ResourceNotCovered"
+		encrypted.Message = "The resource is not covered by the encryption configuration, every write is plaintext"
+	case report.UnencryptedCount() > 0:
+		encrypted.Status = metav1.ConditionFalse
+		encrypted.Reason = "UnencryptedSecretsFound"
+		encrypted.Message = fmt.Sprintf("%d of %d secrets are not encrypted", report.UnencryptedCount(), report.Total())
+	}
+
+	onLatest := metav1.Condition{
+		Type:    ConditionOnLatestProvider,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AllSecretsOnLatestProvider",
+		Message: "All secrets are encrypted by the latest provider",
+	}
+	switch {
+	case encrypted.Status != metav1.ConditionTrue:
+		onLatest.Status = metav1.ConditionUnknown
+		onLatest.Reason = "NotAllSecretsEncrypted"
+		onLatest.Message = "The provider of unencrypted secrets cannot be compared"
+	case !report.AllSecretsUseLatestProvider:
+		onLatest.Status = metav1.ConditionFalse
+		onLatest.Reason = "SecretsOnPreviousProvider"
+		onLatest.Message = "Some secrets are encrypted by a previous provider"
+		if report.Progress != nil {
+			onLatest.Message = fmt.Sprintf("%d of %d secrets are encrypted by the latest provider", report.Progress.OnLatest, report.Progress.Total)
+		}
+	}
+	conditions := []metav1.Condition{encrypted, onLatest}
+
+	if report.Transformation != nil {
+		transformationHealthy := metav1.Condition{
+			Type:    ConditionTransformationHealthy,
+			Status:  metav1.ConditionTrue,
+			Reason:  "NoTransformationErrors",
+			Message: "The API server encrypted and decrypted every secret with the KMS provider since the previous run",
+		}
+		if report.Transformation.Total() > 0 {
+			transformationHealthy.Status = metav1.ConditionFalse
+			transformationHealthy.Reason = "TransformationErrors"
+			transformationHealthy.Message = report.Transformation.String() + " since the previous run"
+		}
+		conditions = append(conditions, transformationHealthy)
+	}
+	return conditions
+}
+
+// runCondition returns the ScanHealthy condition of a run, runErr being nil if it succeeded.
+func runCondition(runErr error) metav1.Condition {
+	condition := metav1.Condition{
+		Type:    ConditionScanHealthy,
+		Status:  metav1.ConditionTrue,
+		Reason:  "RunSucceeded",
+		Message: "The last run succeeded",
+	}
+	if runErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "RunFailed"
+		if errors.Is(runErr, etcd.ErrCircuitOpen) {
+			condition.Reason = "CircuitOpen"
+		}
+		condition.Message = truncate(runErr.Error(), maxRunErrorLength)
+	}
+	return condition
+}
+
+// formatConditions sets conditions in previous, the CONDITIONS value of the existing report, and returns
+// the new value. As in Kubernetes, the transition time of a condition only changes with its status.
+func formatConditions(marshaller utils.Marshaller, previous string, now time.Time, conditions ...metav1.Condition) (string, error) {
+	var merged []metav1.Condition
+	if previous != "" {
+		if err := utils.Unmarshal([]byte(previous), &merged); err != nil {
+			// The key is rewritten from scratch rather than failing every run until it is fixed
+			klog.ErrorS(err, "Ignoring invalid report conditions")
+			merged = nil
+		}
+	}
+	for _, condition := range conditions {
+		condition.LastTransitionTime = metav1.NewTime(now.UTC().Truncate(time.Second))
+		meta.SetStatusCondition(&merged, condition)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return conditionRank(merged[i].Type) < conditionRank(merged[j].Type)
+	})
+	data, err := marshaller.Marshal(merged)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal conditions: %w", err)
+	}
+	return string(data), nil
+}
+
+// conditionRank returns the position of conditionType in the report, unknown types coming last.
+func conditionRank(conditionType string) int {
+	if rank, ok := conditionOrder[conditionType]; ok {
+		return rank
+	}
+	return len(conditionOrder)
+}
@@ -0,0 +1,189 @@
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/transformation"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+func TestReportConditions(t *testing.T) {
+	notCovered := NewReport(nil, []string{"default/secret1"}, false, nil)
+	notCovered.LatestProvider.NotCovered = true
+	rotating := NewReport([]string{"default/secret1", "default/secret2"}, nil, false, nil)
+	rotating.Progress = &Progress{OnLatest: 1, Total: 2, Percent: 50}
+
+	tests := []struct {
+		name            string
+		report          Report
+		encrypted       metav1.ConditionStatus
+		encryptedReason string
+		onLatest        metav1.ConditionStatus
+		onLatestReason  string
+		onLatestMessage string
+	}{
+		{
+			name:            "all secrets on the latest provider",
+			report:          NewReport([]string{"default/secret1"}, nil, true, nil),
+			encrypted:       metav1.ConditionTrue,
+			encryptedReason: "AllSecretsEncrypted",
+			onLatest:        metav1.ConditionTrue,
+			onLatestReason:  "AllSecretsOnLatestProvider",
+			onLatestMessage: "All secrets are encrypted by the latest provider",
+		},
+		{
+			name:            "rotation in progress",
+			report:          rotating,
+			encrypted:       metav1.ConditionTrue,
+			encryptedReason: "AllSecretsEncrypted",
+			onLatest:        metav1.ConditionFalse,
+			onLatestReason:  "SecretsOnPreviousProvider",
+			onLatestMessage: "1 of 2 secrets are encrypted by the latest provider",
+		},
+		{
+			name:            "unencrypted secrets",
+			report:          NewReport([]string{"default/secret1"}, []string{"default/secret2"}, false, nil),
+			encrypted:       metav1.ConditionFalse,
+			encryptedReason: "UnencryptedSecretsFound",
+			onLatest:        metav1.ConditionUnknown,
+			onLatestReason:  "NotAllSecretsEncrypted",
+			onLatestMessage: "The provider of unencrypted secrets cannot be compared",
+		},
+		{
+			name:            "resource not covered",
+			report:          notCovered,
+			encrypted:       metav1.ConditionFalse,
+			encryptedReason: "ResourceNotCovered",
+			onLatest:        metav1.ConditionUnknown,
+			onLatestReason:  "NotAllSecretsEncrypted",
+			onLatestMessage: "The provider of unencrypted secrets cannot be compared",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conditions := reportConditions(tt.report)
+			require.Len(t, conditions, 2)
+			assert.Equal(t, ConditionEncrypted, conditions[0].Type)
+			assert.Equal(t, tt.encrypted, conditions[0].Status)
+			assert.Equal(t, tt.encryptedReason, conditions[0].Reason)
+			assert.Equal(t, ConditionOnLatestProvider, conditions[1].Type)
+			assert.Equal(t, tt.onLatest, conditions[1].Status)
+			assert.Equal(t, tt.onLatestReason, conditions[1].Reason)
+			assert.Equal(t, tt.onLatestMessage, conditions[1].Message)
+		})
+	}
+}
+
+func TestReportConditions_Transformation(t *testing.T) {
+	report := NewReport([]string{"default/secret1"}, nil, true, nil)
+	assert.Len(t, reportConditions(report), 2, "TransformationHealthy is only set when the API server is monitored")
+
+	report.Transformation = &transformation.Errors{}
+	conditions := reportConditions(report)
+	require.Len(t, conditions, 3)
+	assert.Equal(t, ConditionTransformationHealthy, conditions[2].Type)
+	assert.Equal(t, metav1.ConditionTrue, conditions[2].Status)
+
+	report.Transformation = &transformation.Errors{Writes: 3, Statuses: map[string]int64{"Unavailable": 3}}
+	conditions = reportConditions(report)
+	assert.Equal(t, metav1.ConditionFalse, conditions[2].Status)
+	assert.Equal(t, "TransformationErrors", conditions[2].Reason)
+	assert.Equal(t, "3 writes failed to be encrypted and 0 reads failed to be decrypted by the KMS provider (Unavailable: 3) since the previous run", conditions[2].Message)
+
+	// ScanHealthy stays last
+	value, err := formatConditions(utils.JSONMarshaller{}, "", time.Now(), append(conditions, runCondition(nil))...)
+	require.NoError(t, err)
+	var formatted []metav1.Condition
+	require.NoError(t, json.Unmarshal([]byte(value), &formatted))
+	assert.Equal(t, ConditionScanHealthy, formatted[3].Type)
+}
+
+func TestRunCondition(t *testing.T) {
+	assert.Equal(t, metav1.ConditionTrue, runCondition(nil).Status)
+
+	condition := runCondition(errors.New("etcd unavailable"))
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, "RunFailed", condition.Reason)
+	assert.Equal(t, "etcd unavailable", condition.Message)
+
+	condition = runCondition(fmt.Errorf("failed to read etcd: %w", etcd.ErrCircuitOpen))
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, "CircuitOpen", condition.Reason)
+}
+
+func TestFormatConditions(t *testing.T) {
+	monday := time.Date(2025, 1, 6, 10, 0, 0, 0, time.UTC)
+	decode := func(value string) []metav1.Condition {
+		var conditions []metav1.Condition
+		require.NoError(t, json.Unmarshal([]byte(value), &conditions))
+		return conditions
+	}
+
+	value, err := formatConditions(utils.JSONMarshaller{}, "", monday, runCondition(nil))
+	require.NoError(t, err)
+	value, err = formatConditions(utils.JSONMarshaller{}, value, monday.Add(time.Hour), reportConditions(NewReport([]string{"default/secret1"}, nil, false, nil))...)
+	require.NoError(t, err)
+	conditions := decode(value)
+	require.Len(t, conditions, 3)
+	// Conditions are ordered by type whatever the order they were set in
+	assert.Equal(t, []string{ConditionEncrypted, ConditionOnLatestProvider, ConditionScanHealthy}, []string{conditions[0].Type, conditions[1].Type, conditions[2].Type})
+	assert.True(t, conditions[2].LastTransitionTime.Equal(&metav1.Time{Time: monday}))
+
+	// The transition time only changes with the status
+	value, err = formatConditions(utils.JSONMarshaller{}, value, monday.Add(2*time.Hour), reportConditions(NewReport([]string{"default/secret1"}, nil, true, nil))...)
+	require.NoError(t, err)
+	conditions = decode(value)
+	assert.True(t, conditions[0].LastTransitionTime.Equal(&metav1.Time{Time: monday.Add(time.Hour)}))
+	assert.Equal(t, metav1.ConditionTrue, conditions[1].Status)
+	assert.True(t, conditions[1].LastTransitionTime.Equal(&metav1.Time{Time: monday.Add(2 * time.Hour)}))
+
+	// Invalid conditions are replaced
+	value, err = formatConditions(utils.JSONMarshaller{}, "not json", monday, runCondition(nil))
+	require.NoError(t, err)
+	assert.Len(t, decode(value), 1)
+}
+
+func TestRecorderOperation_Record_Conditions(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getConditions := func() []metav1.Condition {
+		data, err := GetReport(context.TODO(), clientset, "test-namespace", "")
+		require.NoError(t, err)
+		var conditions []metav1.Condition
+		require.NoError(t, json.Unmarshal([]byte(data[conditionsKey]), &conditions))
+		return conditions
+	}
+
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1"}, []string{"default/secret2"}, false, nil)))
+	conditions := getConditions()
+	require.Len(t, conditions, 2)
+	assert.Equal(t, metav1.ConditionFalse, conditions[0].Status)
+	assert.Equal(t, metav1.ConditionUnknown, conditions[1].Status)
+
+	// The run status adds ScanHealthy and keeps the report conditions
+	require.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", errors.New("etcd unavailable"), time.Now()))
+	conditions = getConditions()
+	require.Len(t, conditions, 3)
+	assert.Equal(t, metav1.ConditionFalse, conditions[0].Status)
+	assert.Equal(t, ConditionScanHealthy, conditions[2].Type)
+	assert.Equal(t, metav1.ConditionFalse, conditions[2].Status)
+
+	// The next report keeps ScanHealthy
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1", "default/secret2"}, nil, true, nil)))
+	conditions = getConditions()
+	require.Len(t, conditions, 3)
+	assert.Equal(t, metav1.ConditionTrue, conditions[0].Status)
+	assert.Equal(t, metav1.ConditionTrue, conditions[1].Status)
+	assert.Equal(t, metav1.ConditionFalse, conditions[2].Status)
+}
@@ -0,0 +1,56 @@
+package recorder_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+	mock_recorder "github.com/lzhecheng/kms-reporter/pkg/recorder/mock"
+)
+
+// The mock imports the recorder package, so tests using it live in the external test package
+
+func TestRecorderOperator_Interface(t *testing.T) {
+	// Test using the generated mock for interface-level testing
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRecorder := mock_recorder.NewMockRecorderOperator(ctrl)
+
+	// Setup expectations
+	mockRecorder.EXPECT().
+		Record(gomock.Any(), "test-namespace", recorder.NewReport([]string{"secret1"}, []string{"secret2"}, false, map[string]int{"kmsprovider1": 1})).
+		Return(nil).
+		Times(1)
+
+	// Test the interface
+	var recorderOperator recorder.RecorderOperator = mockRecorder
+	err := recorderOperator.Record(context.Background(), "test-namespace", recorder.NewReport([]string{"secret1"}, []string{"secret2"}, false, map[string]int{"kmsprovider1": 1}))
+
+	assert.NoError(t, err)
+}
+
+func TestRecorderOperator_Interface_WithError(t *testing.T) {
+	// Test error case using the generated mock
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRecorder := mock_recorder.NewMockRecorderOperator(ctrl)
+
+	// Setup expectations for error case
+	mockRecorder.EXPECT().
+		Record(gomock.Any(), "test-namespace", gomock.Any()).
+		Return(errors.New("mock recorder error")).
+		Times(1)
+
+	// Test the interface
+	var recorderOperator recorder.RecorderOperator = mockRecorder
+	err := recorderOperator.Record(context.Background(), "test-namespace", recorder.NewReport([]string{"secret1"}, []string{}, true, nil))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "mock recorder error")
+}
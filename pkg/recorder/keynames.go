@@ -0,0 +1,117 @@
+package recorder
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// reportKeys are the data keys of the report whose names can be overridden. REPORT_SIGNATURE keeps its
+// name, so that any copy of the report can be verified.
+var reportKeys = []string{
+	encryptedSecretsKey, unencryptedSecretsKey, encryptedByLatestProviderKey, providerCountsKey,
+	resourceNotCoveredKey, unrecognizedSecretsKey, encodingCountsKey, etcdRevisionKey, scannedKeysKey,
+	scannedBytesKey, largestSecretsKey, scanRevisionSkewKey, estimatedCompletionKey, progressKey,
+	conditionsKey, remediationJobsKey, etcdMembersKey, transformationErrorsKey, encryptedRollupKey,
+	unencryptedRollupKey, unrecognizedRollupKey, encryptedChecksumKey, unencryptedChecksumKey,
+	secretCountsKey, reporterVersionKey, lastRunStatusKey, lastRunErrorKey, lastSuccessfulRunKey,
+	secretListsKey, notRewrittenKey, unchangedSecretsKey, scanStatsKey, etcdClusterKey, deletedSinceLastRunKey,
+	sequenceCountsKey, oldestSecretsKey, nonKMSCountsKey,
+}
+
+// KeyNames overrides the names of the data keys of the report, by default name, e.g.
+// {"ENCRYPTED": "encrypted_secrets"}, for consumers that standardized on other names. Keys that are not
+// overridden keep their default name. A nil KeyNames writes every key under its default name.
+type KeyNames map[string]string
+
+// ParseKeyNames parses comma-separated KEY=name overrides, e.g. "ENCRYPTED=encrypted_secrets,UNENCRYPTED=plaintext_secrets".
+func ParseKeyNames(value string) (KeyNames, error) {
+	if value == "" {
+		return nil, nil
+	}
+	names := KeyNames{}
+	for _, override := range strings.Split(value, ",") {
+		key, name, ok := strings.Cut(strings.TrimSpace(override), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid key name override %q, must be KEY=name", override)
+		}
+		if _, ok := names[key]; ok {
+			return nil, fmt.Errorf("key %s is renamed more than once", key)
+		}
+		names[key] = name
+	}
+	if err := names.Validate(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Validate returns an error if a renamed key is not a report key, or if a name is not a valid ConfigMap
+// key or is used by another key.
+func (n KeyNames) Validate() error {
+	known := make(map[string]bool, len(reportKeys))
+	for _, key := range reportKeys {
+		known[key] = true
+	}
+	used := map[string]string{}
+	for key, name := range n {
+		if !known[key] {
+			return fmt.Errorf("unknown report key %s, must be one of %s", key, strings.Join(reportKeys, ", "))
+		}
+		if errs := validation.IsConfigMapKey(name); len(errs) > 0 {
+			return fmt.Errorf("invalid name %q for report key %s: %s", name, key, strings.Join(errs, "; "))
+		}
+		if other, ok := used[name]; ok {
+			return fmt.Errorf("report keys %s and %s are both named %q", other, key, name)
+		}
+		used[name] = key
+	}
+	for name, key := range used {
+		// A default name is only free when its own key is renamed
+		if _, renamed := n[name]; known[name] && name != key && !renamed {
+			return fmt.Errorf("report key %s cannot be named %q, the name of another report key", key, name)
+		}
+	}
+	return nil
+}
+
+// rename returns data with the report keys under their overridden names.
+func (n KeyNames) rename(data map[string]string) map[string]string {
+	if len(n) == 0 || data == nil {
+		return data
+	}
+	renamed := make(map[string]string, len(data))
+	for key, value := range data {
+		if name, ok := n[key]; ok {
+			key = name
+		}
+		renamed[key] = value
+	}
+	return renamed
+}
+
+// Restore returns data, as stored with the overridden names, with the report keys under their default
+// names, as expected by ParseReport. Keys left under the default name of a renamed key, e.g. by a run
+// before the key was renamed, are dropped.
+func (n KeyNames) Restore(data map[string]string) map[string]string {
+	if len(n) == 0 || data == nil {
+		return data
+	}
+	defaults := make(map[string]string, len(n))
+	for key, name := range n {
+		defaults[name] = key
+	}
+	restored := make(map[string]string, len(data))
+	for key, value := range data {
+		if defaultName, ok := defaults[key]; ok {
+			restored[defaultName] = value
+			continue
+		}
+		if _, renamed := n[key]; renamed {
+			continue
+		}
+		restored[key] = value
+	}
+	return restored
+}
@@ -0,0 +1,93 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseKeyNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected KeyNames
+		err      string
+	}{
+		{name: "empty", value: ""},
+		{name: "overrides", value: "ENCRYPTED=encrypted_secrets, UNENCRYPTED=plaintext_secrets", expected: KeyNames{"ENCRYPTED": "encrypted_secrets", "UNENCRYPTED": "plaintext_secrets"}},
+		{name: "swapped default names", value: "ENCRYPTED=UNENCRYPTED,UNENCRYPTED=ENCRYPTED", expected: KeyNames{"ENCRYPTED": "UNENCRYPTED", "UNENCRYPTED": "ENCRYPTED"}},
+		{name: "missing name", value: "ENCRYPTED", err: `invalid key name override "ENCRYPTED"`},
+		{name: "renamed twice", value: "ENCRYPTED=a,ENCRYPTED=b", err: "key ENCRYPTED is renamed more than once"},
+		{name: "unknown key", value: "SECRETS=secrets", err: "unknown report key SECRETS"},
+		{name: "signature", value: "REPORT_SIGNATURE=signature", err: "unknown report key REPORT_SIGNATURE"},
+		{name: "invalid name", value: "ENCRYPTED=encrypted secrets", err: `invalid name "encrypted secrets" for report key ENCRYPTED`},
+		{name: "duplicate name", value: "ENCRYPTED=secrets,UNENCRYPTED=secrets", err: `are both named "secrets"`},
+		{name: "default name of another key", value: "ENCRYPTED=UNENCRYPTED", err: `report key ENCRYPTED cannot be named "UNENCRYPTED"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, err := ParseKeyNames(tt.value)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, names)
+		})
+	}
+}
+
+func TestKeyNames_Restore(t *testing.T) {
+	names := KeyNames{encryptedSecretsKey: "encrypted_secrets"}
+	data := map[string]string{"encrypted_secrets": "default/secret1", encryptedSecretsKey: "stale", providerCountsKey: "{}"}
+	assert.Equal(t, map[string]string{encryptedSecretsKey: "default/secret1", providerCountsKey: "{}"}, names.Restore(data))
+	assert.Equal(t, data, KeyNames(nil).Restore(data))
+	assert.Nil(t, names.Restore(nil))
+}
+
+func TestRecorderOperation_Record_KeyNames(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	names := KeyNames{encryptedSecretsKey: "encrypted_secrets", providerCountsKey: "provider_counts", lastRunStatusKey: "last_run_status"}
+	recorder := NewRecorderOperator(clientset, Config{KeyNames: names, Patch: true})
+	report := NewReport([]string{"default/secret1"}, []string{"default/secret2"}, false, map[string]int{"kmsprovider1": 1, "identity": 1})
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	require.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", errors.New("etcd unavailable"), time.Now()))
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+
+	cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "default/secret1", cm.Data["encrypted_secrets"])
+	assert.JSONEq(t, `{"kmsprovider1":1,"identity":1}`, cm.Data["provider_counts"])
+	assert.Equal(t, runStatusFailed, cm.Data["last_run_status"])
+	assert.Equal(t, "default/secret2", cm.Data[unencryptedSecretsKey])
+	assert.NotContains(t, cm.Data, encryptedSecretsKey)
+	assert.NotContains(t, cm.Data, providerCountsKey)
+	assert.NotContains(t, cm.Data, lastRunStatusKey)
+
+	summary, err := ParseReport(names.Restore(cm.Data))
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Encrypted)
+	assert.Equal(t, runStatusFailed, summary.LastRunStatus)
+	assert.Len(t, summary.Conditions, 3)
+}
+
+func TestRecorderOperation_Record_KeyNames_Migration(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	report := NewReport([]string{"default/secret1"}, nil, true, map[string]int{"kmsprovider1": 1})
+	require.NoError(t, NewRecorderOperator(clientset, Config{}).Record(context.Background(), "test-namespace", report))
+
+	// The key written under its default name by the previous run is moved
+	recorder := NewRecorderOperator(clientset, Config{KeyNames: KeyNames{encryptedSecretsKey: "encrypted_secrets"}})
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+
+	cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, allSecretsPattern, cm.Data["encrypted_secrets"])
+	assert.NotContains(t, cm.Data, encryptedSecretsKey)
+}
@@ -7,7 +7,9 @@ package mock_recorder
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
+	recorder "github.com/lzhecheng/kms-reporter/pkg/recorder"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -35,15 +37,29 @@ func (m *MockRecorderOperator) EXPECT() *MockRecorderOperatorMockRecorder {
 }
 
 // Record mocks base method.
-func (m *MockRecorderOperator) Record(ctx context.Context, namespace string, encryptedSecrets, unencryptedSecrets []string, allSecretsUseLatestProvider bool) error {
+func (m *MockRecorderOperator) Record(ctx context.Context, namespace string, report recorder.Report) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Record", ctx, namespace, encryptedSecrets, unencryptedSecrets, allSecretsUseLatestProvider)
+	ret := m.ctrl.Call(m, "Record", ctx, namespace, report)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Record indicates an expected call of Record.
-func (mr *MockRecorderOperatorMockRecorder) Record(ctx, namespace, encryptedSecrets, unencryptedSecrets, allSecretsUseLatestProvider interface{}) *gomock.Call {
+func (mr *MockRecorderOperatorMockRecorder) Record(ctx, namespace, report interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Record", reflect.TypeOf((*MockRecorderOperator)(nil).Record), ctx, namespace, encryptedSecrets, unencryptedSecrets, allSecretsUseLatestProvider)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Record", reflect.TypeOf((*MockRecorderOperator)(nil).Record), ctx, namespace, report)
+}
+
+// RecordRunStatus mocks base method.
+func (m *MockRecorderOperator) RecordRunStatus(ctx context.Context, namespace string, runErr error, finishedAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordRunStatus", ctx, namespace, runErr, finishedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecordRunStatus indicates an expected call of RecordRunStatus.
+func (mr *MockRecorderOperatorMockRecorder) RecordRunStatus(ctx, namespace, runErr, finishedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordRunStatus", reflect.TypeOf((*MockRecorderOperator)(nil).RecordRunStatus), ctx, namespace, runErr, finishedAt)
 }
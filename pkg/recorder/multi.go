@@ -0,0 +1,53 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// multiRecorder records with each of its recorders in turn.
+type multiRecorder struct {
+	recorders []RecorderOperator
+}
+
+// NewMultiRecorder returns a RecorderOperator recording every report and run status with each of
+// recorders, in order, e.g. the report ConfigMap then additional sinks. A failing recorder does not
+// prevent the following ones from recording; the errors of all failures are joined.
+func NewMultiRecorder(recorders ...RecorderOperator) RecorderOperator {
+	if len(recorders) == 1 {
+		return recorders[0]
+	}
+	return &multiRecorder{recorders: recorders}
+}
+
+func (m *multiRecorder) Record(ctx context.Context, namespace string, report Report) error {
+	var errs []error
+	for _, recorder := range m.recorders {
+		errs = append(errs, recorder.Record(ctx, namespace, report))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *multiRecorder) RecordRunStatus(ctx context.Context, namespace string, runErr error, finishedAt time.Time) error {
+	var errs []error
+	for _, recorder := range m.recorders {
+		errs = append(errs, recorder.RecordRunStatus(ctx, namespace, runErr, finishedAt))
+	}
+	return errors.Join(errs...)
+}
+
+// redactingRecorder records reports without any secret name with its recorder.
+type redactingRecorder struct {
+	RecorderOperator
+}
+
+// NewRedactingRecorder returns a RecorderOperator recording every report with recorder, without any
+// secret name, e.g. for plugins when the names must not leave the report ConfigMap in plaintext.
+func NewRedactingRecorder(recorder RecorderOperator) RecorderOperator {
+	return &redactingRecorder{RecorderOperator: recorder}
+}
+
+func (r *redactingRecorder) Record(ctx context.Context, namespace string, report Report) error {
+	return r.RecorderOperator.Record(ctx, namespace, report.withoutNames())
+}
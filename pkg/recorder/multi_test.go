@@ -0,0 +1,70 @@
+package recorder_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/lzhecheng/kms-reporter/pkg/history"
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+	mock_recorder "github.com/lzhecheng/kms-reporter/pkg/recorder/mock"
+)
+
+func TestMultiRecorder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	first := mock_recorder.NewMockRecorderOperator(ctrl)
+	second := mock_recorder.NewMockRecorderOperator(ctrl)
+	multi := recorder.NewMultiRecorder(first, second)
+	report := recorder.NewReport([]string{"default/a"}, []string{}, true, map[string]int{"kmsprovider1": 1})
+	finishedAt := time.Now()
+	runErr := errors.New("scan failed")
+
+	// A failing recorder does not prevent the next one from recording
+	gomock.InOrder(
+		first.EXPECT().Record(gomock.Any(), "kube-system", report).Return(errors.New("ConfigMap unavailable")),
+		second.EXPECT().Record(gomock.Any(), "kube-system", report).Return(nil),
+	)
+	assert.EqualError(t, multi.Record(context.Background(), "kube-system", report), "ConfigMap unavailable")
+
+	gomock.InOrder(
+		first.EXPECT().RecordRunStatus(gomock.Any(), "kube-system", runErr, finishedAt).Return(nil),
+		second.EXPECT().RecordRunStatus(gomock.Any(), "kube-system", runErr, finishedAt).Return(errors.New("sink unavailable")),
+	)
+	assert.EqualError(t, multi.RecordRunStatus(context.Background(), "kube-system", runErr, finishedAt), "sink unavailable")
+
+	// A single recorder is returned as is
+	assert.Same(t, first, recorder.NewMultiRecorder(first))
+}
+
+func TestRedactingRecorder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	plugin := mock_recorder.NewMockRecorderOperator(ctrl)
+	redacting := recorder.NewRedactingRecorder(plugin)
+	report := recorder.NewReport([]string{"default/a"}, []string{"default/b"}, false, map[string]int{"kmsprovider1": 1, "identity": 1})
+	report.Deleted = &history.Deletion{Previous: 3, Deleted: 1, Secrets: []string{"default/c"}}
+
+	plugin.EXPECT().Record(gomock.Any(), "kube-system", gomock.Any()).DoAndReturn(func(_ context.Context, _ string, got recorder.Report) error {
+		assert.Empty(t, got.EncryptedSecrets)
+		assert.Empty(t, got.UnencryptedSecrets)
+		assert.Equal(t, 1, got.OmittedEncrypted)
+		assert.Equal(t, 1, got.OmittedUnencrypted)
+		assert.Equal(t, &history.Deletion{Previous: 3, Deleted: 1}, got.Deleted)
+		return nil
+	})
+	assert.NoError(t, redacting.Record(context.Background(), "kube-system", report))
+	// The report of the caller keeps its names
+	assert.Equal(t, []string{"default/a"}, report.EncryptedSecrets)
+	assert.Equal(t, []string{"default/c"}, report.Deleted.Secrets)
+
+	finishedAt := time.Now()
+	plugin.EXPECT().RecordRunStatus(gomock.Any(), "kube-system", nil, finishedAt).Return(nil)
+	assert.NoError(t, redacting.RecordRunStatus(context.Background(), "kube-system", nil, finishedAt))
+}
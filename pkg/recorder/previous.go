@@ -0,0 +1,101 @@
+package recorder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// previousReportAnnotationKey holds the summary of the report data replaced by the last change
+const previousReportAnnotationKey = "kms-reporter/previous-report"
+
+// PreviousReport summarizes the report data replaced by the last write that changed it, so that
+// consumers see what changed in one read.
+type PreviousReport struct {
+	Encrypted    int `json:"encrypted"`
+	Unencrypted  int `json:"unencrypted"`
+	Unrecognized int `json:"unrecognized"`
+	// ProviderCounts maps each provider to the number of secrets it encrypted.
+	ProviderCounts map[string]int `json:"providerCounts"`
+	// LastSuccessfulRun is when the run that wrote the data finished, if it was recorded.
+	LastSuccessfulRun *time.Time `json:"lastSuccessfulRun,omitempty"`
+	// ReplacedAt is when the data was replaced.
+	ReplacedAt time.Time `json:"replacedAt"`
+	// Hash is the ReportHash of the data, as stored.
+	Hash string `json:"hash"`
+}
+
+// ReportHash returns the hex-encoded SHA-256 of the data of a report, covering every key and value.
+func ReportHash(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		// Length-prefixed, so that no two reports hash the same fields
+		for _, field := range []string{key, data[key]} {
+			hash.Write([]byte(strconv.Itoa(len(field))))
+			hash.Write([]byte{':'})
+			hash.Write([]byte(field))
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ParsePreviousReport returns the summary of the report data replaced by the last change of the report
+// ConfigMap, or nil if the report never changed.
+func ParsePreviousReport(configMap *v1.ConfigMap) (*PreviousReport, error) {
+	value, ok := configMap.Annotations[previousReportAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	var previous PreviousReport
+	if err := json.Unmarshal([]byte(value), &previous); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal previous report: %w", err)
+	}
+	return &previous, nil
+}
+
+// annotatePreviousReport stores the summary of original, the report as read before the write, in
+// modified if the write changes the data. A report that cannot be summarized, e.g. one only holding the
+// run status, is not recorded.
+func (o *RecorderOperation) annotatePreviousReport(original, modified *v1.ConfigMap, replacedAt time.Time) {
+	if maps.Equal(original.Data, modified.Data) {
+		return
+	}
+	summary, err := ParseReport(o.KeyNames.Restore(original.Data))
+	if err != nil {
+		klog.V(2).InfoS("Not recording the previous report", "configMap", klog.KObj(original), "reason", err)
+		return
+	}
+	previous := PreviousReport{
+		Encrypted:      summary.Encrypted,
+		Unencrypted:    summary.Unencrypted,
+		Unrecognized:   summary.Unrecognized,
+		ProviderCounts: summary.ProviderCounts,
+		ReplacedAt:     replacedAt.UTC().Truncate(time.Second),
+		Hash:           ReportHash(original.Data),
+	}
+	if !summary.LastSuccessfulRun.IsZero() {
+		previous.LastSuccessfulRun = &summary.LastSuccessfulRun
+	}
+	value, err := json.Marshal(previous)
+	if err != nil {
+		klog.ErrorS(err, "Failed to marshal the previous report", "configMap", klog.KObj(original))
+		return
+	}
+	if modified.Annotations == nil {
+		modified.Annotations = map[string]string{}
+	}
+	modified.Annotations[previousReportAnnotationKey] = string(value)
+}
@@ -0,0 +1,66 @@
+package recorder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReportHash(t *testing.T) {
+	data := map[string]string{"ENCRYPTED": "default/secret1", "UNENCRYPTED": ""}
+	assert.Equal(t, ReportHash(data), ReportHash(map[string]string{"UNENCRYPTED": "", "ENCRYPTED": "default/secret1"}))
+	assert.NotEqual(t, ReportHash(data), ReportHash(map[string]string{"ENCRYPTED": "", "UNENCRYPTED": "default/secret1"}))
+	// Fields are delimited
+	assert.NotEqual(t, ReportHash(map[string]string{"A": "BC"}), ReportHash(map[string]string{"AB": "C"}))
+}
+
+func TestRecorderOperation_Record_PreviousReport(t *testing.T) {
+	for _, patch := range []bool{false, true} {
+		clientset := fake.NewSimpleClientset()
+		recorder := NewRecorderOperator(clientset, Config{Patch: patch})
+		getConfigMap := func() *v1.ConfigMap {
+			cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+			require.NoError(t, err)
+			return cm
+		}
+
+		first := NewReport([]string{"default/secret1"}, []string{"default/secret2"}, false, map[string]int{"kmsprovider1": 1, "identity": 1})
+		require.NoError(t, recorder.Record(context.Background(), "test-namespace", first))
+		finishedAt := time.Date(2025, 1, 6, 10, 0, 0, 0, time.UTC)
+		require.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", nil, finishedAt))
+		previous, err := ParsePreviousReport(getConfigMap())
+		require.NoError(t, err)
+		assert.Nil(t, previous, "patch=%t", patch)
+
+		firstData := getConfigMap().Data
+		second := NewReport([]string{"default/secret1", "default/secret2"}, nil, true, map[string]int{"kmsprovider1": 2})
+		require.NoError(t, recorder.Record(context.Background(), "test-namespace", second))
+		previous, err = ParsePreviousReport(getConfigMap())
+		require.NoError(t, err)
+		require.NotNil(t, previous, "patch=%t", patch)
+		assert.Equal(t, 1, previous.Encrypted)
+		assert.Equal(t, 1, previous.Unencrypted)
+		assert.Equal(t, map[string]int{"kmsprovider1": 1, "identity": 1}, previous.ProviderCounts)
+		require.NotNil(t, previous.LastSuccessfulRun)
+		assert.True(t, previous.LastSuccessfulRun.Equal(finishedAt))
+		assert.False(t, previous.ReplacedAt.IsZero())
+		assert.Equal(t, ReportHash(firstData), previous.Hash)
+
+		// An unchanged report keeps the summary of the last change
+		require.NoError(t, recorder.Record(context.Background(), "test-namespace", second))
+		unchanged, err := ParsePreviousReport(getConfigMap())
+		require.NoError(t, err)
+		assert.Equal(t, previous, unchanged, "patch=%t", patch)
+	}
+}
+
+func TestParsePreviousReport_Invalid(t *testing.T) {
+	_, err := ParsePreviousReport(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{previousReportAnnotationKey: "{"}}})
+	assert.ErrorContains(t, err, "failed to unmarshal previous report")
+}
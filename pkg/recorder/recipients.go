@@ -0,0 +1,140 @@
+package recorder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+// SecretLists are the secret names of a report, encrypted to the recipients of the report in
+// SECRET_LISTS instead of being written in plaintext.
+type SecretLists struct {
+	Encrypted    []string `json:"encrypted"`
+	Unencrypted  []string `json:"unencrypted"`
+	Unrecognized []string `json:"unrecognized,omitempty"`
+	// Largest are the secrets with the largest values, as in LARGEST_SECRETS.
+	Largest []analyzer.SecretSize `json:"largest,omitempty"`
+	// Oldest are the secrets on the oldest providers, as in OLDEST_PROVIDER_SECRETS.
+	Oldest []analyzer.SecretProvider `json:"oldest,omitempty"`
+	// Modified are the secrets changed during the scan, as in SCAN_REVISION_SKEW.
+	Modified []string `json:"modified,omitempty"`
+	// NotRewritten are the secrets not rewritten since the rotation date, as in NOT_REWRITTEN_SINCE.
+	NotRewritten []string `json:"notRewritten,omitempty"`
+	// Unchanged are the secrets whose state has not changed for long, as in UNCHANGED_SECRETS.
+	Unchanged []string `json:"unchanged,omitempty"`
+	// Deleted are the secrets deleted since the previous run, as in DELETED_SINCE_LAST_RUN.
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// encryptedLists is the SECRET_LISTS value last written for a report, reused while the lists do not
+// change: age encryption is randomized, so encrypting the same lists again would change the report.
+type encryptedLists struct {
+	digest [sha256.Size]byte
+	value  string
+}
+
+// ReadRecipients reads the age recipients the secret lists of the report are encrypted to from path,
+// one X25519 public key (age1...) per line, as written by age-keygen -y. Comments start with #.
+func ReadRecipients(path string) ([]age.Recipient, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report recipients: %w", err)
+	}
+	defer file.Close()
+	recipients, err := age.ParseRecipients(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse report recipients in %s: %w", path, err)
+	}
+	return recipients, nil
+}
+
+// newSecretLists returns the secret names of report.
+func newSecretLists(report Report) SecretLists {
+	lists := SecretLists{
+		Encrypted:    report.EncryptedSecrets,
+		Unencrypted:  report.UnencryptedSecrets,
+		Unrecognized: report.UnrecognizedSecrets,
+		Largest:      report.LargestSecrets,
+		Oldest:       report.OldestSecrets,
+	}
+	if report.Skew != nil {
+		lists.Modified = report.Skew.ModifiedSecrets
+	}
+	if report.Recency != nil {
+		lists.NotRewritten = report.Recency.Secrets
+	}
+	if report.History != nil {
+		lists.Unchanged = report.History.Secrets
+	}
+	if report.Deleted != nil {
+		lists.Deleted = report.Deleted.Secrets
+	}
+	return lists
+}
+
+// encryptSecretLists returns the SECRET_LISTS value of the report name: the ASCII-armored age
+// encryption of lists to Recipients.
+func (o *RecorderOperation) encryptSecretLists(name string, lists SecretLists) (string, error) {
+	plaintext, err := json.Marshal(lists)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal secret lists: %w", err)
+	}
+	digest := sha256.Sum256(plaintext)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if previous, ok := o.encryptedLists[name]; ok && previous.digest == digest {
+		return previous.value, nil
+	}
+
+	var ciphertext bytes.Buffer
+	armored := armor.NewWriter(&ciphertext)
+	encrypted, err := age.Encrypt(armored, o.Recipients...)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt secret lists: %w", err)
+	}
+	if _, err := encrypted.Write(plaintext); err != nil {
+		return "", fmt.Errorf("failed to encrypt secret lists: %w", err)
+	}
+	if err := encrypted.Close(); err != nil {
+		return "", fmt.Errorf("failed to encrypt secret lists: %w", err)
+	}
+	if err := armored.Close(); err != nil {
+		return "", fmt.Errorf("failed to encrypt secret lists: %w", err)
+	}
+	if o.encryptedLists == nil {
+		o.encryptedLists = map[string]encryptedLists{}
+	}
+	o.encryptedLists[name] = encryptedLists{digest: digest, value: ciphertext.String()}
+	return ciphertext.String(), nil
+}
+
+// DecryptSecretLists returns the secret names encrypted in the SECRET_LISTS key of a report, as
+// returned by GetReport, with the identity of one of its recipients, e.g. read with age.ParseIdentities.
+func DecryptSecretLists(data map[string]string, identities ...age.Identity) (SecretLists, error) {
+	value, ok := data[secretListsKey]
+	if !ok {
+		return SecretLists{}, fmt.Errorf("report has no %s key", secretListsKey)
+	}
+	decrypted, err := age.Decrypt(armor.NewReader(strings.NewReader(value)), identities...)
+	if err != nil {
+		return SecretLists{}, fmt.Errorf("failed to decrypt secret lists: %w", err)
+	}
+	plaintext, err := io.ReadAll(decrypted)
+	if err != nil {
+		return SecretLists{}, fmt.Errorf("failed to decrypt secret lists: %w", err)
+	}
+	var lists SecretLists
+	if err := json.Unmarshal(plaintext, &lists); err != nil {
+		return SecretLists{}, fmt.Errorf("failed to unmarshal secret lists: %w", err)
+	}
+	return lists, nil
+}
@@ -0,0 +1,106 @@
+package recorder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/history"
+	"github.com/lzhecheng/kms-reporter/pkg/recency"
+)
+
+func TestReadRecipients(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "recipients.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# security team\n"+identity.Recipient().String()+"\n"), 0o600))
+	recipients, err := ReadRecipients(path)
+	require.NoError(t, err)
+	assert.Len(t, recipients, 1)
+
+	require.NoError(t, os.WriteFile(path, []byte("not-a-key\n"), 0o600))
+	_, err = ReadRecipients(path)
+	assert.ErrorContains(t, err, "failed to parse report recipients")
+	_, err = ReadRecipients(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorContains(t, err, "failed to read report recipients")
+}
+
+func TestRecorderOperation_Record_Recipients(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	other, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{Recipients: []age.Recipient{identity.Recipient()}, Patch: true})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		require.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport([]string{"default/a", "kube-system/b"}, []string{"kube-system/c"}, false, map[string]int{"kmsprovider1": 2, "identity": 1})
+	report.UnrecognizedSecrets = []string{"default/d"}
+	report.LargestSecrets = []analyzer.SecretSize{{Name: "default/a", Size: 2048}}
+	report.OldestSecrets = []analyzer.SecretProvider{{Name: "kube-system/b", Provider: "kmsprovider1", Seq: 1}}
+	report.Skew = &analyzer.RevisionSkew{Revision: 12, Modified: 1, ModifiedSecrets: []string{"default/a"}}
+	report.Recency = &recency.Summary{Checked: 2, NotRewritten: 1, Secrets: []string{"kube-system/b"}}
+	report.History = &history.Summary{Days: 30, Tracked: 3, Unchanged: 1, Secrets: []string{"kube-system/c"}}
+	report.Deleted = &history.Deletion{Previous: 4, Deleted: 1, Secrets: []string{"default/e"}}
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+
+	data := getData()
+	for key, value := range data {
+		for _, name := range []string{"default/", "kube-system/"} {
+			assert.NotContains(t, value, name, "key %s", key)
+		}
+	}
+	assert.JSONEq(t, `{"total":4,"encrypted":2,"unencrypted":1,"unrecognized":1,"encryptedPercent":50}`, data[secretCountsKey])
+	assert.JSONEq(t, `{"kmsprovider1":2,"identity":1}`, data[providerCountsKey])
+	assert.JSONEq(t, `{"since":"0001-01-01T00:00:00Z","checked":2,"notRewritten":1}`, data[notRewrittenKey])
+	assert.JSONEq(t, `{"days":30,"tracked":3,"unchanged":1,"trackedSince":"0001-01-01T00:00:00Z"}`, data[unchangedSecretsKey])
+	assert.JSONEq(t, `{"previous":4,"deleted":1}`, data[deletedSinceLastRunKey])
+	// The summaries of the caller are left untouched
+	assert.Equal(t, []string{"kube-system/b"}, report.Recency.Secrets)
+
+	lists, err := DecryptSecretLists(data, identity)
+	require.NoError(t, err)
+	assert.Equal(t, SecretLists{
+		Encrypted:    []string{"default/a", "kube-system/b"},
+		Unencrypted:  []string{"kube-system/c"},
+		Unrecognized: []string{"default/d"},
+		Largest:      []analyzer.SecretSize{{Name: "default/a", Size: 2048}},
+		Oldest:       []analyzer.SecretProvider{{Name: "kube-system/b", Provider: "kmsprovider1", Seq: 1}},
+		Modified:     []string{"default/a"},
+		NotRewritten: []string{"kube-system/b"},
+		Unchanged:    []string{"kube-system/c"},
+		Deleted:      []string{"default/e"},
+	}, lists)
+	_, err = DecryptSecretLists(data, other)
+	assert.ErrorContains(t, err, "failed to decrypt secret lists")
+
+	// Unchanged lists are not encrypted again, so that the report does not change
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.Equal(t, data[secretListsKey], getData()[secretListsKey])
+
+	report = NewReport([]string{"default/a", "kube-system/b", "kube-system/c"}, nil, true, map[string]int{"kmsprovider1": 3})
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	lists, err = DecryptSecretLists(getData(), identity)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default/a", "kube-system/b", "kube-system/c"}, lists.Encrypted)
+	assert.Empty(t, lists.Unencrypted)
+}
+
+func TestDecryptSecretLists_Missing(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	_, err = DecryptSecretLists(map[string]string{encryptedSecretsKey: allSecretsPattern}, identity)
+	assert.ErrorContains(t, err, "report has no SECRET_LISTS key")
+}
@@ -2,14 +2,33 @@ package recorder
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
+	"filippo.io/age"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/hook"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+	"github.com/lzhecheng/kms-reporter/pkg/version"
 )
 
 const (
@@ -23,8 +42,64 @@ const (
 	encryptedSecretsKey          = "ENCRYPTED"
 	unencryptedSecretsKey        = "UNENCRYPTED"
 	encryptedByLatestProviderKey = "ENCRYPTED_BY_LATEST_SEQ"
+	providerCountsKey            = "PROVIDER_COUNTS"
+	resourceNotCoveredKey        = "RESOURCE_NOT_COVERED"
+	unrecognizedSecretsKey       = "UNRECOGNIZED"
+	encodingCountsKey            = "ENCODING_COUNTS"
+	etcdRevisionKey              = "ETCD_REVISION"
+	etcdClusterKey               = "ETCD_CLUSTER"
+	scannedKeysKey               = "SCANNED_KEYS"
+	scannedBytesKey              = "SCANNED_BYTES"
+	scanStatsKey                 = "SCAN_STATS"
+	largestSecretsKey            = "LARGEST_SECRETS"
+	oldestSecretsKey             = "OLDEST_PROVIDER_SECRETS"
+	scanRevisionSkewKey          = "SCAN_REVISION_SKEW"
+	estimatedCompletionKey       = "ESTIMATED_COMPLETION"
+	progressKey                  = "PROGRESS"
+	conditionsKey                = "CONDITIONS"
+	remediationJobsKey           = "REMEDIATION_JOBS"
+	etcdMembersKey               = "ETCD_MEMBERS"
+	transformationErrorsKey      = "TRANSFORMATION_ERRORS"
+	notRewrittenKey              = "NOT_REWRITTEN_SINCE"
+	unchangedSecretsKey          = "UNCHANGED_SECRETS"
+	deletedSinceLastRunKey       = "DELETED_SINCE_LAST_RUN"
+	sequenceCountsKey            = "PROVIDER_SEQUENCE_COUNTS"
+	nonKMSCountsKey              = "NON_KMS_COUNTS"
+	encryptedRollupKey           = "ENCRYPTED_ROLLUP"
+	unencryptedRollupKey         = "UNENCRYPTED_ROLLUP"
+	unrecognizedRollupKey        = "UNRECOGNIZED_ROLLUP"
+	encryptedChecksumKey         = "ENCRYPTED_SHA256"
+	unencryptedChecksumKey       = "UNENCRYPTED_SHA256"
+	secretCountsKey              = "SECRET_COUNTS"
+	reporterVersionKey           = "REPORTER_VERSION"
+	lastRunStatusKey             = "LAST_RUN_STATUS"
+	lastRunErrorKey              = "LAST_RUN_ERROR"
+	lastSuccessfulRunKey         = "LAST_SUCCESSFUL_RUN"
+	secretListsKey               = "SECRET_LISTS"
+
+	// Values of LAST_RUN_STATUS
+	runStatusSuccess  = "Success"
+	runStatusFailed   = "Failed"
+	runStatusDegraded = "Degraded"
+
+	// maxRunErrorLength is the maximum length of LAST_RUN_ERROR in bytes
+	maxRunErrorLength = 1024
+
+	// Labels and annotations set on the report ConfigMap
+	managedByLabel     = "app.kubernetes.io/managed-by"
+	nameLabel          = "app.kubernetes.io/name"
+	reporterName       = "kms-reporter"
+	runIDAnnotationKey = "kms-reporter/run-id"
+	nodeNameLabel      = "kms-reporter/node-name"
+	teamLabel          = "kms-reporter/team"
+
+	// maxConfigMapSize is the API server limit on the total size of a ConfigMap's data
+	maxConfigMapSize = 1024 * 1024
 )
 
+// ErrConfigMapTooLarge is returned when the report does not fit in a single ConfigMap.
+var ErrConfigMapTooLarge = errors.New("ConfigMap exceeds the maximum size")
+
 // formatSecretLists converts secret lists into string representations for ConfigMap storage.
 // Returns formatted strings for encrypted and unencrypted secret lists, using a special
 // pattern when all secrets belong to one category.
@@ -54,74 +129,733 @@ func formatSecretLists(encryptedSecrets, unencryptedSecrets []string) (string, s
 	return encryptedValue, unencryptedValue
 }
 
+// formatProviderCounts converts the per-provider secret counts into an object for ConfigMap storage.
+func formatProviderCounts(marshaller utils.Marshaller, providerCounts map[string]int) (string, error) {
+	if providerCounts == nil {
+		providerCounts = map[string]int{}
+	}
+	data, err := marshaller.Marshal(providerCounts)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal provider counts: %w", err)
+	}
+	return string(data), nil
+}
+
+// ParseProviderCounts returns the per-provider secret counts of a report as returned by GetReport.
+func ParseProviderCounts(data map[string]string) (map[string]int, error) {
+	value, ok := data[providerCountsKey]
+	if !ok {
+		return nil, fmt.Errorf("report has no %s key", providerCountsKey)
+	}
+	var providerCounts map[string]int
+	if err := utils.Unmarshal([]byte(value), &providerCounts); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal provider counts: %w", err)
+	}
+	return providerCounts, nil
+}
+
+// checksum returns the hex-encoded SHA-256 of a report value, so that consumers mirroring the report
+// can detect changes and verify their copy without comparing the lists.
+func checksum(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// capSecretList returns the first maxListed names, and the number of the remaining names per namespace.
+// Names of cluster-scoped objects, which have no namespace, are counted under "". maxListed < 0 keeps
+// every name.
+func capSecretList(names []string, maxListed int) ([]string, map[string]int) {
+	if maxListed < 0 || len(names) <= maxListed {
+		return names, nil
+	}
+	rollup := map[string]int{}
+	for _, name := range names[maxListed:] {
+		namespace, _, namespaced := strings.Cut(name, "/")
+		if !namespaced {
+			namespace = ""
+		}
+		rollup[namespace]++
+	}
+	return names[:maxListed], rollup
+}
+
+// marshaller returns the marshaller of the structured values of the report.
+func (o *RecorderOperation) marshaller() utils.Marshaller {
+	if o.Marshaller == nil {
+		return utils.JSONMarshaller{}
+	}
+	return o.Marshaller
+}
+
+// maxListed returns the maximum number of names written per list, negative for no limit.
+func (o *RecorderOperation) maxListed() int {
+	if o.MaxListedSecrets <= 0 {
+		return -1
+	}
+	return o.MaxListedSecrets
+}
+
+// summarizeSecretList returns the value of a secret list in a summary-only report: ALL_SECRETS is kept,
+// names are dropped.
+func summarizeSecretList(value string) string {
+	if value == allSecretsPattern {
+		return value
+	}
+	return ""
+}
+
+// secretCounts is the value of SECRET_COUNTS
+type secretCounts struct {
+	Total            int     `json:"total"`
+	Encrypted        int     `json:"encrypted"`
+	Unencrypted      int     `json:"unencrypted"`
+	Unrecognized     int     `json:"unrecognized"`
+	EncryptedPercent float64 `json:"encryptedPercent"`
+}
+
+// etcdCluster is the value of ETCD_CLUSTER
+type etcdCluster struct {
+	// ClusterID is hexadecimal, as etcdctl prints it
+	ClusterID string   `json:"clusterID,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
+}
+
+// scanStats is the value of SCAN_STATS
+type scanStats struct {
+	DurationSeconds   float64          `json:"durationSeconds"`
+	Pages             int64            `json:"pages"`
+	Keys              int64            `json:"keys"`
+	Bytes             int64            `json:"bytes"`
+	ParseErrors       int64            `json:"parseErrors"`
+	ParseErrorClasses map[string]int64 `json:"parseErrorClasses,omitempty"`
+	Concurrency       int              `json:"concurrency"`
+}
+
+// formatScanStats converts the statistics of a scan into an object for ConfigMap storage.
+func formatScanStats(marshaller utils.Marshaller, stats analyzer.ScanStats) (string, error) {
+	data, err := marshaller.Marshal(scanStats{
+		DurationSeconds:   math.Round(stats.Duration.Seconds()*1000) / 1000,
+		Pages:             stats.Pages,
+		Keys:              stats.Keys,
+		Bytes:             stats.Bytes,
+		ParseErrors:       stats.ParseErrors,
+		ParseErrorClasses: stats.ParseErrorClasses,
+		Concurrency:       stats.Concurrency,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal scan stats: %w", err)
+	}
+	return string(data), nil
+}
+
+// formatSecretCounts converts the secret counts of result into an object for ConfigMap storage.
+func formatSecretCounts(marshaller utils.Marshaller, result analyzer.Result) (string, error) {
+	counts := secretCounts{
+		Total:        result.Total(),
+		Encrypted:    result.EncryptedCount(),
+		Unencrypted:  result.UnencryptedCount(),
+		Unrecognized: result.UnrecognizedCount(),
+	}
+	if counts.Total > 0 {
+		counts.EncryptedPercent = math.Round(10000*float64(counts.Encrypted)/float64(counts.Total)) / 100
+	}
+	data, err := marshaller.Marshal(counts)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal secret counts: %w", err)
+	}
+	return string(data), nil
+}
+
+// formatRollup converts the per-namespace counts of the secrets left out of a list into an object
+// for ConfigMap storage, or "" if none were left out.
+func formatRollup(marshaller utils.Marshaller, rollup map[string]int) (string, error) {
+	if len(rollup) == 0 {
+		return "", nil
+	}
+	data, err := marshaller.Marshal(rollup)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal secret rollup: %w", err)
+	}
+	return string(data), nil
+}
+
 // RecorderOperator defines the interface for recording secret encryption status reports.
 // It stores the analysis results in a Kubernetes ConfigMap for monitoring and alerting purposes.
 type RecorderOperator interface {
-	Record(ctx context.Context, namespace string, encryptedSecrets, unencryptedSecrets []string, allSecretsUseLatestProvider bool) error
+	// Record stores the report of a successful run.
+	Record(ctx context.Context, namespace string, report Report) error
+	// RecordRunStatus stores the outcome of the run that finished at finishedAt, runErr being nil if it succeeded.
+	// The report data of failed runs is left untouched.
+	RecordRunStatus(ctx context.Context, namespace string, runErr error, finishedAt time.Time) error
+}
+
+// Config configures the recorder.
+type Config struct {
+	// RequestTimeout bounds each Kubernetes API call. 0 disables the limit.
+	RequestTimeout time.Duration
+	// Owner is added to the owner references of the report ConfigMap, so that it is
+	// garbage-collected together with the owner. Optional.
+	Owner *metav1.OwnerReference
+	// NodeName is the node the reporter runs on when one reporter runs per control plane node.
+	// Each node then writes its own report, named after and labeled with the node. Optional.
+	NodeName string
+	// Patch writes only the changed data keys and metadata of an existing report with a JSON merge
+	// patch instead of replacing the whole ConfigMap, so concurrent writers of other keys do not conflict.
+	Patch bool
+	// MaxListedSecrets bounds the number of names written in each secret list of the report. The secrets
+	// left out are counted per namespace in the rollup key of the list. 0 writes every name.
+	MaxListedSecrets int
+	// SummaryOnlyAbove switches reports of more than this many secrets to summary-only: the secret lists
+	// are replaced by their per-namespace rollups and the secret counts. 0 always writes the lists.
+	SummaryOnlyAbove int
+	// SigningKeyFile holds the HMAC key the report is signed with, so that changes made to it by anyone
+	// but the reporter can be detected with VerifyReport. It is read on every write. Optional.
+	SigningKeyFile string
+	// Marshaller writes the structured values of the report, e.g. PROVIDER_COUNTS and CONDITIONS.
+	// Defaults to JSON.
+	Marshaller utils.Marshaller
+	// KeyNames overrides the names of the data keys of the report. Readers of the report restore the
+	// default names with KeyNames.Restore. Optional.
+	KeyNames KeyNames
+	// CountsOnly never writes a secret name: every report is summary-only, and the unrecognized,
+	// largest and modified secrets are only counted.
+	CountsOnly bool
+	// Recipients encrypts the secret names of the report to these age recipients in SECRET_LISTS,
+	// so that only the holders of their identities can read them. The rest of the report is written as
+	// with CountsOnly. Optional.
+	Recipients []age.Recipient
+	// Hook runs when the status of a condition of the report changes, with the report on its standard
+	// input. Optional.
+	Hook *hook.Hook
+	// CreateNamespace creates the namespace of a report, labeled as managed by the reporter, when the
+	// report cannot be created because the namespace does not exist.
+	CreateNamespace bool
 }
 
 // RecorderOperation handles the storage of secret encryption status reports in Kubernetes ConfigMaps.
 type RecorderOperation struct {
 	Clientset kubernetes.Interface
+	// RequestTimeout bounds each Kubernetes API call. 0 disables the limit.
+	RequestTimeout time.Duration
+	// Owner is added to the owner references of the report ConfigMap. Optional.
+	Owner *metav1.OwnerReference
+	// NodeName is the node whose report is written, or "" for the cluster-wide report.
+	NodeName string
+	// Patch writes the changes to an existing report with a JSON merge patch instead of an update.
+	Patch bool
+	// MaxListedSecrets bounds the number of names written in each secret list. 0 writes every name.
+	MaxListedSecrets int
+	// SummaryOnlyAbove switches reports of more than this many secrets to summary-only. 0 disables it.
+	SummaryOnlyAbove int
+	// SigningKeyFile holds the HMAC key the report is signed with. Optional.
+	SigningKeyFile string
+	// Marshaller writes the structured values of the report. Defaults to JSON.
+	Marshaller utils.Marshaller
+	// KeyNames overrides the names of the data keys of the report. Optional.
+	KeyNames KeyNames
+	// CountsOnly never writes a secret name.
+	CountsOnly bool
+	// Recipients encrypts the secret names of the report to these age recipients. Optional.
+	Recipients []age.Recipient
+	// Hook runs when the status of a condition of the report changes. Optional.
+	Hook *hook.Hook
+	// CreateNamespace creates the namespace of a report that does not exist.
+	CreateNamespace bool
+
+	// mu guards encryptedLists, the SECRET_LISTS value last written per report name
+	mu             sync.Mutex
+	encryptedLists map[string]encryptedLists
 }
 
-func NewRecorderOperator(clientset kubernetes.Interface) RecorderOperator {
+func NewRecorderOperator(clientset kubernetes.Interface, config Config) RecorderOperator {
 	return &RecorderOperation{
-		Clientset: clientset,
+		Clientset:        clientset,
+		RequestTimeout:   config.RequestTimeout,
+		Owner:            config.Owner,
+		NodeName:         config.NodeName,
+		Patch:            config.Patch,
+		MaxListedSecrets: config.MaxListedSecrets,
+		SummaryOnlyAbove: config.SummaryOnlyAbove,
+		SigningKeyFile:   config.SigningKeyFile,
+		Marshaller:       config.Marshaller,
+		KeyNames:         config.KeyNames,
+		CountsOnly:       config.CountsOnly,
+		Recipients:       config.Recipients,
+		Hook:             config.Hook,
+		CreateNamespace:  config.CreateNamespace,
+	}
+}
+
+// ReportName returns the name of the report ConfigMap written on nodeName, or of the
+// cluster-wide report if nodeName is empty.
+func ReportName(nodeName string) string {
+	if nodeName == "" {
+		return kmsReporterConfigMapName
+	}
+	return kmsReporterConfigMapName + "-" + nodeName
+}
+
+// ResourceReportName returns the name of the report of resource written on nodeName, resource being
+// that of an additional scan prefix, e.g. configmaps, or "" for the report of the main prefix.
+func ResourceReportName(nodeName, resource string) string {
+	if resource == "" {
+		return ReportName(nodeName)
+	}
+	return ReportName(nodeName) + "-" + resource
+}
+
+// TeamReportName returns the name of the report of team written on nodeName.
+func TeamReportName(nodeName, team string) string {
+	return ReportName(nodeName) + "-team-" + team
+}
+
+// reportName returns the name of the ConfigMap report is written to.
+func (o *RecorderOperation) reportName(report Report) string {
+	if report.Team != "" {
+		return TeamReportName(o.NodeName, report.Team)
+	}
+	return ResourceReportName(o.NodeName, report.Resource)
+}
+
+// RequiredPermissions lists the Kubernetes API access the recorder needs in the given namespace.
+// nodeName and patch are as in Config, and ownerDeployment is the name of the Deployment owning the
+// report, or "" if it has no owner. resources are those of the additional scan prefixes, whose reports
+// are written as well.
+func RequiredPermissions(namespace, nodeName, ownerDeployment string, patch bool, resources ...string) []rbac.Permission {
+	name := ReportName(nodeName)
+	writeVerb := "update"
+	if patch {
+		writeVerb = "patch"
+	}
+	permissions := []rbac.Permission{
+		{Verb: "get", Resource: "configmaps", Namespace: namespace, Name: name},
+		{Verb: "create", Resource: "configmaps", Namespace: namespace},
+		{Verb: writeVerb, Resource: "configmaps", Namespace: namespace, Name: name},
+	}
+	for _, resource := range resources {
+		resourceName := ResourceReportName(nodeName, resource)
+		permissions = append(permissions,
+			rbac.Permission{Verb: "get", Resource: "configmaps", Namespace: namespace, Name: resourceName},
+			rbac.Permission{Verb: writeVerb, Resource: "configmaps", Namespace: namespace, Name: resourceName},
+		)
+	}
+	if ownerDeployment != "" {
+		permissions = append(permissions, rbac.Permission{Verb: "get", Group: "apps", Resource: "deployments", Namespace: namespace, Name: ownerDeployment})
+	}
+	return permissions
+}
+
+// TeamRequiredPermissions lists the Kubernetes API access the recorder needs to write the report of team
+// in namespace. nodeName and patch are as in Config.
+func TeamRequiredPermissions(namespace, nodeName, team string, patch bool) []rbac.Permission {
+	name := TeamReportName(nodeName, team)
+	writeVerb := "update"
+	if patch {
+		writeVerb = "patch"
+	}
+	return []rbac.Permission{
+		{Verb: "get", Resource: "configmaps", Namespace: namespace, Name: name},
+		{Verb: "create", Resource: "configmaps", Namespace: namespace},
+		{Verb: writeVerb, Resource: "configmaps", Namespace: namespace, Name: name},
+	}
+}
+
+// NamespaceRequiredPermissions lists the Kubernetes API access the recorder needs to create the
+// namespace of its reports, as with Config.CreateNamespace.
+func NamespaceRequiredPermissions() []rbac.Permission {
+	return []rbac.Permission{{Verb: "create", Resource: "namespaces"}}
+}
+
+// CreateNamespace creates namespace, labeled as managed by the reporter, unless it already exists.
+func CreateNamespace(ctx context.Context, clientset kubernetes.Interface, namespace string) error {
+	ns := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   namespace,
+			Labels: map[string]string{managedByLabel: reporterName, nameLabel: reporterName},
+		},
+	}
+	_, err := clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create namespace %s: %w", namespace, err)
+	}
+	klog.InfoS("Created the report namespace", "namespace", namespace)
+	return nil
+}
+
+// DeploymentOwnerReference returns an owner reference to the Deployment name in namespace.
+func DeploymentOwnerReference(ctx context.Context, clientset kubernetes.Interface, namespace, name string) (*metav1.OwnerReference, error) {
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get owner Deployment %s/%s: %w", namespace, name, err)
+	}
+	return &metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Name:       deployment.Name,
+		UID:        deployment.UID,
+	}, nil
+}
+
+// ReportObjectReference returns a reference to the report ConfigMap of nodeName in namespace, e.g. to attach events to it.
+func ReportObjectReference(namespace, nodeName string) v1.ObjectReference {
+	return v1.ObjectReference{
+		Kind:       "ConfigMap",
+		APIVersion: "v1",
+		Namespace:  namespace,
+		Name:       ReportName(nodeName),
 	}
 }
 
 // Record stores the secret encryption status analysis results in a Kubernetes ConfigMap.
 // It creates a new ConfigMap if one doesn't exist, or updates an existing one.
-func (o *RecorderOperation) Record(ctx context.Context, namespace string, encryptedSecrets, unencryptedSecrets []string, allSecretsUseLatestProvider bool) error {
-	allSecretsEncrypted := len(unencryptedSecrets) == 0
-
-	encryptedValue, unencryptedValue := formatSecretLists(encryptedSecrets, unencryptedSecrets)
+func (o *RecorderOperation) Record(ctx context.Context, namespace string, report Report) error {
+	if report.Team != "" {
+		ctx = context.WithValue(ctx, teamKey{}, report.Team)
+	}
+	allSecretsEncrypted := len(report.UnencryptedSecrets) == 0
+	allSecretsUseLatestProvider := report.AllSecretsUseLatestProvider
 
-	configMap, err := o.Clientset.CoreV1().ConfigMaps(namespace).Get(ctx, kmsReporterConfigMapName, metav1.GetOptions{})
+	encryptLists := len(o.Recipients) > 0
+	summaryOnly := o.CountsOnly || encryptLists || (o.SummaryOnlyAbove > 0 && report.Total() > o.SummaryOnlyAbove)
+	optionalData, err := o.formatListRollups(&report, summaryOnly)
 	if err != nil {
-		if !apierrors.IsNotFound(err) {
-			return fmt.Errorf("failed to get ConfigMap: %w", err)
+		return err
+	}
+	encryptedValue, unencryptedValue := formatSecretLists(report.EncryptedSecrets, report.UnencryptedSecrets)
+	optionalData[secretCountsKey] = ""
+	if summaryOnly {
+		// Only ALL_SECRETS is kept, the names are covered by the rollups
+		encryptedValue, unencryptedValue = summarizeSecretList(encryptedValue), summarizeSecretList(unencryptedValue)
+		if optionalData[secretCountsKey], err = formatSecretCounts(o.marshaller(), report.Result); err != nil {
+			return err
+		}
+		klog.V(2).InfoS("Writing a summary-only report", "secrets", report.Total(), "threshold", o.SummaryOnlyAbove)
+	}
+	optionalData[encryptedChecksumKey] = checksum(encryptedValue)
+	optionalData[unencryptedChecksumKey] = checksum(unencryptedValue)
+	providerCountsValue, err := formatProviderCounts(o.marshaller(), report.ProviderCounts)
+	if err != nil {
+		return err
+	}
+	secretListsValue := ""
+	if encryptLists {
+		if secretListsValue, err = o.encryptSecretLists(o.reportName(report), newSecretLists(report)); err != nil {
+			return err
 		}
+	}
+	if o.CountsOnly || encryptLists {
+		// The names were only needed for the rollups
+		report = report.withoutNames()
+	}
+	reportData, err := formatOptionalData(o.marshaller(), report)
+	if err != nil {
+		return err
+	}
+	reportData[secretListsKey] = secretListsValue
+	for key, value := range reportData {
+		optionalData[key] = value
+	}
+	if summaryOnly {
+		optionalData[unrecognizedSecretsKey] = ""
+	}
 
+	getCtx, cancel := utils.ContextWithTimeout(ctx, o.RequestTimeout)
+	defer cancel()
+	name := o.reportName(report)
+	configMap, err := o.Clientset.CoreV1().ConfigMaps(namespace).Get(getCtx, name, metav1.GetOptions{})
+	notFound := apierrors.IsNotFound(err)
+	if err != nil && !notFound {
+		return fmt.Errorf("failed to get ConfigMap: %w", err)
+	}
+	var previousConditions string
+	if !notFound {
+		previousConditions = o.KeyNames.Restore(configMap.Data)[conditionsKey]
+	}
+	if optionalData[conditionsKey], err = formatConditions(o.marshaller(), previousConditions, time.Now(), reportConditions(report)...); err != nil {
+		return err
+	}
+	if notFound {
 		// ConfigMap doesn't exist, create a new one
-		return o.createConfigMap(ctx, namespace, encryptedValue, unencryptedValue, allSecretsEncrypted, allSecretsUseLatestProvider)
+		configMap, err = o.createConfigMap(ctx, namespace, name, encryptedValue, unencryptedValue, providerCountsValue, allSecretsEncrypted, allSecretsUseLatestProvider, optionalData)
+	} else {
+		// ConfigMap exists, update it
+		configMap, err = o.updateConfigMap(ctx, configMap, encryptedValue, unencryptedValue, providerCountsValue, allSecretsEncrypted, allSecretsUseLatestProvider, optionalData)
+	}
+	if err != nil {
+		return err
 	}
+	o.runHook(ctx, configMap, previousConditions)
+	return nil
+}
 
-	// ConfigMap exists, update it
-	return o.updateConfigMap(ctx, configMap, encryptedValue, unencryptedValue, allSecretsEncrypted, allSecretsUseLatestProvider)
+// formatListRollups caps the secret lists of report at MaxListedSecrets names and returns the rollup
+// keys of the lists, empty for the lists that were not capped. A list written as ALL_SECRETS is not capped.
+// In summary-only reports every list is rolled up in full and left for the caller to drop.
+func (o *RecorderOperation) formatListRollups(report *Report, summaryOnly bool) (map[string]string, error) {
+	mixed := len(report.EncryptedSecrets) > 0 && len(report.UnencryptedSecrets) > 0
+	rollups := map[string]string{}
+	for _, list := range []struct {
+		key    string
+		names  *[]string
+		listed bool
+	}{
+		{encryptedRollupKey, &report.EncryptedSecrets, mixed},
+		{unencryptedRollupKey, &report.UnencryptedSecrets, mixed},
+		{unrecognizedRollupKey, &report.UnrecognizedSecrets, true},
+	} {
+		var rollup map[string]int
+		switch {
+		case summaryOnly:
+			_, rollup = capSecretList(*list.names, 0)
+		case list.listed:
+			*list.names, rollup = capSecretList(*list.names, o.maxListed())
+		}
+		value, err := formatRollup(o.marshaller(), rollup)
+		if err != nil {
+			return nil, err
+		}
+		rollups[list.key] = value
+	}
+	return rollups, nil
+}
+
+// formatOptionalData returns the report keys that are only set in some reports, with an empty
+// value for the keys that must be removed from the report.
+func formatOptionalData(marshaller utils.Marshaller, report Report) (map[string]string, error) {
+	optionalData := map[string]string{
+		resourceNotCoveredKey:   "",
+		unrecognizedSecretsKey:  strings.Join(report.UnrecognizedSecrets, ","),
+		encodingCountsKey:       "",
+		etcdRevisionKey:         "",
+		etcdClusterKey:          "",
+		scannedKeysKey:          "",
+		scannedBytesKey:         "",
+		scanStatsKey:            "",
+		largestSecretsKey:       "",
+		oldestSecretsKey:        "",
+		scanRevisionSkewKey:     "",
+		estimatedCompletionKey:  "",
+		progressKey:             "",
+		remediationJobsKey:      "",
+		etcdMembersKey:          "",
+		transformationErrorsKey: "",
+		notRewrittenKey:         "",
+		unchangedSecretsKey:     "",
+		deletedSinceLastRunKey:  "",
+		sequenceCountsKey:       "",
+		nonKMSCountsKey:         "",
+	}
+	// Warn that every write is plaintext, whatever the providers are
+	if report.LatestProvider.NotCovered {
+		optionalData[resourceNotCoveredKey] = "true"
+	}
+	if report.Progress != nil {
+		progress := *report.Progress
+		progress.Percent = math.Round(100*progress.Percent) / 100
+		progress.Delta = math.Round(100*progress.Delta) / 100
+		data, err := marshaller.Marshal(progress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal progress: %w", err)
+		}
+		optionalData[progressKey] = string(data)
+	}
+	if report.Remediation != nil {
+		data, err := marshaller.Marshal(report.Remediation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal remediation summary: %w", err)
+		}
+		optionalData[remediationJobsKey] = string(data)
+	}
+	if len(report.Members) > 0 {
+		data, err := marshaller.Marshal(report.Members)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal etcd members: %w", err)
+		}
+		optionalData[etcdMembersKey] = string(data)
+	}
+	if report.Transformation != nil {
+		data, err := marshaller.Marshal(report.Transformation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal transformation errors: %w", err)
+		}
+		optionalData[transformationErrorsKey] = string(data)
+	}
+	if report.Recency != nil {
+		data, err := marshaller.Marshal(report.Recency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal secrets not rewritten: %w", err)
+		}
+		optionalData[notRewrittenKey] = string(data)
+	}
+	if report.History != nil {
+		data, err := marshaller.Marshal(report.History)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal unchanged secrets: %w", err)
+		}
+		optionalData[unchangedSecretsKey] = string(data)
+	}
+	if report.Deleted != nil {
+		data, err := marshaller.Marshal(report.Deleted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal deleted secrets: %w", err)
+		}
+		optionalData[deletedSinceLastRunKey] = string(data)
+	}
+	if !report.EstimatedCompletion.IsZero() {
+		optionalData[estimatedCompletionKey] = report.EstimatedCompletion.UTC().Format(time.RFC3339)
+	}
+	if report.Revision > 0 {
+		optionalData[etcdRevisionKey] = strconv.FormatInt(report.Revision, 10)
+	}
+	if report.ClusterID != 0 || len(report.EtcdEndpoints) > 0 {
+		cluster := etcdCluster{Endpoints: report.EtcdEndpoints}
+		if report.ClusterID != 0 {
+			cluster.ClusterID = strconv.FormatUint(report.ClusterID, 16)
+		}
+		data, err := marshaller.Marshal(cluster)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal etcd cluster: %w", err)
+		}
+		optionalData[etcdClusterKey] = string(data)
+	}
+	if report.Scan.Keys > 0 {
+		optionalData[scannedKeysKey] = strconv.FormatInt(report.Scan.Keys, 10)
+		optionalData[scannedBytesKey] = strconv.FormatInt(report.Scan.Bytes, 10)
+	}
+	if report.Scan.Concurrency > 0 {
+		data, err := formatScanStats(marshaller, report.Scan)
+		if err != nil {
+			return nil, err
+		}
+		optionalData[scanStatsKey] = data
+	}
+	if len(report.LargestSecrets) > 0 {
+		data, err := marshaller.Marshal(report.LargestSecrets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal largest secrets: %w", err)
+		}
+		optionalData[largestSecretsKey] = string(data)
+	}
+	if len(report.OldestSecrets) > 0 {
+		data, err := marshaller.Marshal(report.OldestSecrets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal oldest provider secrets: %w", err)
+		}
+		optionalData[oldestSecretsKey] = string(data)
+	}
+	if report.Skew != nil {
+		data, err := marshaller.Marshal(report.Skew)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal revision skew: %w", err)
+		}
+		optionalData[scanRevisionSkewKey] = string(data)
+	}
+	if len(report.EncodingCounts) > 0 {
+		data, err := marshaller.Marshal(report.EncodingCounts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal encoding counts: %w", err)
+		}
+		optionalData[encodingCountsKey] = string(data)
+	}
+	if len(report.SequenceCounts) > 0 {
+		data, err := marshaller.Marshal(report.SequenceCounts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal provider sequence counts: %w", err)
+		}
+		optionalData[sequenceCountsKey] = string(data)
+	}
+	if len(report.NonKMSCounts) > 0 {
+		data, err := marshaller.Marshal(report.NonKMSCounts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal non-KMS counts: %w", err)
+		}
+		optionalData[nonKMSCountsKey] = string(data)
+	}
+	return optionalData, nil
+}
+
+// setOptionalData sets the non-empty optional keys in data and removes the others.
+func setOptionalData(data, optionalData map[string]string) {
+	for key, value := range optionalData {
+		if value == "" {
+			delete(data, key)
+		} else {
+			data[key] = value
+		}
+	}
 }
 
 // createConfigMap creates a new ConfigMap with the encryption status data.
-func (o *RecorderOperation) createConfigMap(ctx context.Context, namespace, encryptedValue, unencryptedValue string, allSecretsEncrypted, allSecretsUseLatestProvider bool) error {
+func (o *RecorderOperation) createConfigMap(ctx context.Context, namespace, name, encryptedValue, unencryptedValue, providerCountsValue string, allSecretsEncrypted, allSecretsUseLatestProvider bool, optionalData map[string]string) (*v1.ConfigMap, error) {
 	configMap := &v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      kmsReporterConfigMapName,
+			Name:      name,
 			Namespace: namespace,
 		},
 		Data: map[string]string{
 			encryptedSecretsKey:   encryptedValue,
 			unencryptedSecretsKey: unencryptedValue,
+			providerCountsKey:     providerCountsValue,
+			reporterVersionKey:    version.Get().String(),
 		},
 	}
+	o.setMetadata(ctx, configMap)
 
 	// Only add the latest provider status if all secrets are encrypted
 	if allSecretsEncrypted {
 		configMap.Data[encryptedByLatestProviderKey] = fmt.Sprintf("%t", allSecretsUseLatestProvider)
 	}
+	setOptionalData(configMap.Data, optionalData)
+	configMap.Data = o.KeyNames.rename(configMap.Data)
+	if err := o.sign(configMap); err != nil {
+		return nil, err
+	}
 
-	if _, err := o.Clientset.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
-		return fmt.Errorf("failed to create ConfigMap: %w", err)
+	if err := CheckConfigMapSize(configMap); err != nil {
+		return nil, err
 	}
+	if err := o.create(ctx, configMap); err != nil {
+		return nil, err
+	}
+
+	klog.Infof("ConfigMap %s created successfully", configMap.Name)
+	return configMap, nil
+}
 
-	klog.Infof("ConfigMap %s created successfully", kmsReporterConfigMapName)
+// create creates configMap, first creating its namespace if it does not exist and CreateNamespace is set.
+func (o *RecorderOperation) create(ctx context.Context, configMap *v1.ConfigMap) error {
+	createCtx, cancel := utils.ContextWithTimeout(ctx, o.RequestTimeout)
+	defer cancel()
+	configMaps := o.Clientset.CoreV1().ConfigMaps(configMap.Namespace)
+	_, err := configMaps.Create(createCtx, configMap, metav1.CreateOptions{})
+	if apierrors.IsNotFound(err) && o.CreateNamespace {
+		if err := CreateNamespace(createCtx, o.Clientset, configMap.Namespace); err != nil {
+			return err
+		}
+		_, err = configMaps.Create(createCtx, configMap, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create ConfigMap: %w", err)
+	}
 	return nil
 }
 
 // updateConfigMap updates an existing ConfigMap with new encryption status data.
-func (o *RecorderOperation) updateConfigMap(ctx context.Context, configMap *v1.ConfigMap, encryptedValue, unencryptedValue string, allSecretsEncrypted, allSecretsUseLatestProvider bool) error {
+func (o *RecorderOperation) updateConfigMap(ctx context.Context, configMap *v1.ConfigMap, encryptedValue, unencryptedValue, providerCountsValue string, allSecretsEncrypted, allSecretsUseLatestProvider bool, optionalData map[string]string) (*v1.ConfigMap, error) {
+	original := configMap.DeepCopy()
+	configMap.Data = o.KeyNames.Restore(configMap.Data)
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
 	configMap.Data[encryptedSecretsKey] = encryptedValue
 	configMap.Data[unencryptedSecretsKey] = unencryptedValue
+	configMap.Data[providerCountsKey] = providerCountsValue
+	configMap.Data[reporterVersionKey] = version.Get().String()
+	// Reports written by older versions have no labels or owner yet
+	o.setMetadata(ctx, configMap)
 
 	// Only add/update the latest provider status if all secrets are encrypted
 	if allSecretsEncrypted {
@@ -130,11 +864,269 @@ func (o *RecorderOperation) updateConfigMap(ctx context.Context, configMap *v1.C
 		// Remove the key if not all secrets are encrypted
 		delete(configMap.Data, encryptedByLatestProviderKey)
 	}
+	setOptionalData(configMap.Data, optionalData)
+	configMap.Data = o.KeyNames.rename(configMap.Data)
+	if err := o.sign(configMap); err != nil {
+		return nil, err
+	}
+	o.annotatePreviousReport(original, configMap, time.Now())
+
+	if err := CheckConfigMapSize(configMap); err != nil {
+		return nil, err
+	}
+	if err := o.writeConfigMap(ctx, original, configMap); err != nil {
+		return nil, err
+	}
+
+	klog.Infof("ConfigMap %s updated successfully", configMap.Name)
+	return configMap, nil
+}
+
+// RecordRunStatus stores the outcome of a run in the report ConfigMap, creating it if needed.
+func (o *RecorderOperation) RecordRunStatus(ctx context.Context, namespace string, runErr error, finishedAt time.Time) error {
+	getCtx, cancel := utils.ContextWithTimeout(ctx, o.RequestTimeout)
+	defer cancel()
+	configMap, err := o.Clientset.CoreV1().ConfigMaps(namespace).Get(getCtx, ReportName(o.NodeName), metav1.GetOptions{})
+	exists := err == nil
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get ConfigMap: %w", err)
+	}
 
-	if _, err := o.Clientset.CoreV1().ConfigMaps(configMap.Namespace).Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
-		return fmt.Errorf("failed to update ConfigMap: %w", err)
+	if !exists {
+		// No report has been written yet
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ReportName(o.NodeName),
+				Namespace: namespace,
+			},
+		}
+	}
+	original := configMap.DeepCopy()
+	configMap.Data = o.KeyNames.Restore(configMap.Data)
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	if runErr != nil {
+		configMap.Data[lastRunStatusKey] = runStatusFailed
+		// The scan was skipped rather than failed
+		if errors.Is(runErr, etcd.ErrCircuitOpen) {
+			configMap.Data[lastRunStatusKey] = runStatusDegraded
+		}
+		configMap.Data[lastRunErrorKey] = truncate(runErr.Error(), maxRunErrorLength)
+	} else {
+		configMap.Data[lastRunStatusKey] = runStatusSuccess
+		configMap.Data[lastSuccessfulRunKey] = finishedAt.UTC().Format(time.RFC3339)
+		delete(configMap.Data, lastRunErrorKey)
+	}
+	previousConditions := configMap.Data[conditionsKey]
+	conditions, err := formatConditions(o.marshaller(), previousConditions, finishedAt, runCondition(runErr))
+	if err != nil {
+		return err
+	}
+	configMap.Data[conditionsKey] = conditions
+	configMap.Data = o.KeyNames.rename(configMap.Data)
+	o.setMetadata(ctx, configMap)
+	if err := o.sign(configMap); err != nil {
+		return err
 	}
 
-	klog.Infof("ConfigMap %s updated successfully", kmsReporterConfigMapName)
+	if !exists {
+		if err := o.create(ctx, configMap); err != nil {
+			return err
+		}
+	} else if err := o.writeConfigMap(ctx, original, configMap); err != nil {
+		return err
+	}
+	o.runHook(ctx, configMap, previousConditions)
 	return nil
 }
+
+// runHook runs the hook if the status of a condition of configMap, the report as written, changed from
+// previousConditions, the CONDITIONS value it replaced. Failures are logged and do not fail the run.
+func (o *RecorderOperation) runHook(ctx context.Context, configMap *v1.ConfigMap, previousConditions string) {
+	if o.Hook == nil {
+		return
+	}
+	data := o.KeyNames.Restore(configMap.Data)
+	var previous, current []metav1.Condition
+	// Invalid conditions were rewritten from scratch, every condition transitioned
+	_ = utils.Unmarshal([]byte(previousConditions), &previous)
+	if err := utils.Unmarshal([]byte(data[conditionsKey]), &current); err != nil {
+		klog.ErrorS(err, "Failed to parse report conditions for the hook", "configMap", klog.KObj(configMap))
+		return
+	}
+	transitions := hook.Transitions(previous, current)
+	if len(transitions) == 0 {
+		return
+	}
+	event := hook.Event{Namespace: configMap.Namespace, Name: configMap.Name, Time: time.Now().UTC(), Transitions: transitions, Report: data}
+	if err := o.Hook.Run(ctx, event); err != nil {
+		klog.ErrorS(err, "Failed to run hook", "configMap", klog.KObj(configMap))
+		return
+	}
+	klog.V(2).InfoS("Ran hook", "configMap", klog.KObj(configMap), "transitions", len(transitions))
+}
+
+// writeConfigMap stores the changes made to original in modified, with an update or, if Patch is
+// set, with a JSON merge patch of the changed fields only. Nothing is written if nothing changed.
+func (o *RecorderOperation) writeConfigMap(ctx context.Context, original, modified *v1.ConfigMap) error {
+	writeCtx, cancel := utils.ContextWithTimeout(ctx, o.RequestTimeout)
+	defer cancel()
+	if !o.Patch {
+		if _, err := o.Clientset.CoreV1().ConfigMaps(modified.Namespace).Update(writeCtx, modified, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update ConfigMap: %w", err)
+		}
+		return nil
+	}
+
+	patch, changed, err := mergePatch(original, modified)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		klog.V(2).InfoS("Report unchanged, skipping the patch", "configMap", klog.KObj(modified))
+		return nil
+	}
+	if _, err := o.Clientset.CoreV1().ConfigMaps(modified.Namespace).Patch(writeCtx, modified.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to patch ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// mergePatch returns the JSON merge patch (RFC 7386) turning original into modified. It covers the
+// fields the recorder writes: data keys, labels and annotations, which are set or removed one by one,
+// and owner references, which are replaced as a whole. changed is false if the patch is empty.
+func mergePatch(original, modified *v1.ConfigMap) ([]byte, bool, error) {
+	metadata := map[string]any{}
+	if diff := stringMapPatch(original.Labels, modified.Labels); len(diff) > 0 {
+		metadata["labels"] = diff
+	}
+	if diff := stringMapPatch(original.Annotations, modified.Annotations); len(diff) > 0 {
+		metadata["annotations"] = diff
+	}
+	if !equality.Semantic.DeepEqual(original.OwnerReferences, modified.OwnerReferences) {
+		metadata["ownerReferences"] = modified.OwnerReferences
+	}
+
+	patch := map[string]any{}
+	if len(metadata) > 0 {
+		patch["metadata"] = metadata
+	}
+	if diff := stringMapPatch(original.Data, modified.Data); len(diff) > 0 {
+		patch["data"] = diff
+	}
+	if len(patch) == 0 {
+		return nil, false, nil
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to marshal ConfigMap patch: %w", err)
+	}
+	return data, true, nil
+}
+
+// stringMapPatch returns the merge patch of a string map: changed and added keys with their new
+// value, removed keys with null.
+func stringMapPatch(original, modified map[string]string) map[string]any {
+	diff := map[string]any{}
+	for key, value := range modified {
+		if originalValue, ok := original[key]; !ok || originalValue != value {
+			diff[key] = value
+		}
+	}
+	for key := range original {
+		if _, ok := modified[key]; !ok {
+			diff[key] = nil
+		}
+	}
+	return diff
+}
+
+// truncate shortens s to at most maxLength bytes without splitting a UTF-8 character, marking the cut with "...".
+func truncate(s string, maxLength int) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	cut := maxLength - len("...")
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
+// teamKey is the context key of the team of the report being recorded.
+type teamKey struct{}
+
+// setMetadata labels the report ConfigMap, annotates it with the current run ID, removes the mark of an
+// unused report and adds the owner reference.
+func (o *RecorderOperation) setMetadata(ctx context.Context, configMap *v1.ConfigMap) {
+	if configMap.Labels == nil {
+		configMap.Labels = map[string]string{}
+	}
+	configMap.Labels[managedByLabel] = reporterName
+	configMap.Labels[nameLabel] = reporterName
+	if o.NodeName != "" {
+		configMap.Labels[nodeNameLabel] = o.NodeName
+	}
+
+	delete(configMap.Annotations, UnusedSinceAnnotation)
+	if runID := utils.RunIDFromContext(ctx); runID != "" {
+		if configMap.Annotations == nil {
+			configMap.Annotations = map[string]string{}
+		}
+		configMap.Annotations[runIDAnnotationKey] = runID
+	}
+
+	// Team reports may be written to other namespaces, where the owner cannot own objects
+	if team, _ := ctx.Value(teamKey{}).(string); team != "" {
+		configMap.Labels[teamLabel] = team
+		return
+	}
+	if o.Owner == nil {
+		return
+	}
+	for _, ref := range configMap.OwnerReferences {
+		if ref.UID == o.Owner.UID {
+			return
+		}
+	}
+	configMap.OwnerReferences = append(configMap.OwnerReferences, *o.Owner)
+}
+
+// sign signs the data of the report ConfigMap if a signing key is configured.
+func (o *RecorderOperation) sign(configMap *v1.ConfigMap) error {
+	if o.SigningKeyFile == "" {
+		return nil
+	}
+	key, err := ReadSigningKey(o.SigningKeyFile)
+	if err != nil {
+		return err
+	}
+	SignReport(configMap.Data, key)
+	return nil
+}
+
+// CheckConfigMapSize rejects a ConfigMap the API server would refuse, so the caller gets
+// ErrConfigMapTooLarge instead of an opaque validation error.
+func CheckConfigMapSize(configMap *v1.ConfigMap) error {
+	size := 0
+	for key, value := range configMap.Data {
+		size += len(key) + len(value)
+	}
+	for key, value := range configMap.BinaryData {
+		size += len(key) + len(value)
+	}
+	if size > maxConfigMapSize {
+		return fmt.Errorf("%w: ConfigMap %s/%s is %d bytes, limit is %d", ErrConfigMapTooLarge, configMap.Namespace, configMap.Name, size, maxConfigMapSize)
+	}
+	return nil
+}
+
+// GetReport returns the data of the report ConfigMap of nodeName stored in the given namespace.
+func GetReport(ctx context.Context, clientset kubernetes.Interface, namespace, nodeName string) (map[string]string, error) {
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, ReportName(nodeName), metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ConfigMap: %w", err)
+	}
+	return configMap.Data, nil
+}
@@ -2,18 +2,36 @@ package recorder
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
-	"go.uber.org/mock/gomock"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
 	clienttesting "k8s.io/client-go/testing"
 
-	mock_recorder "github.com/lzhecheng/kms-reporter/pkg/recorder/mock"
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/history"
+	"github.com/lzhecheng/kms-reporter/pkg/hook"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/recency"
+	"github.com/lzhecheng/kms-reporter/pkg/remediation"
+	"github.com/lzhecheng/kms-reporter/pkg/transformation"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+	"github.com/lzhecheng/kms-reporter/pkg/version"
 )
 
 func TestFormatSecretLists(t *testing.T) {
@@ -79,13 +97,14 @@ func TestFormatSecretLists(t *testing.T) {
 
 func TestNewRecorderOperator(t *testing.T) {
 	clientset := fake.NewSimpleClientset()
-	recorder := NewRecorderOperator(clientset)
+	recorder := NewRecorderOperator(clientset, Config{RequestTimeout: 10 * time.Second})
 
 	assert.NotNil(t, recorder)
 	assert.IsType(t, &RecorderOperation{}, recorder)
 
 	recorderOp := recorder.(*RecorderOperation)
 	assert.Equal(t, clientset, recorderOp.Clientset)
+	assert.Equal(t, 10*time.Second, recorderOp.RequestTimeout)
 }
 
 func TestRecorderOperation_Record(t *testing.T) {
@@ -276,7 +295,7 @@ func TestRecorderOperation_Record(t *testing.T) {
 				Clientset: clientset,
 			}
 
-			err := recorder.Record(context.Background(), tt.namespace, tt.encryptedSecrets, tt.unencryptedSecrets, tt.allSecretsUseLatestProvider)
+			err := recorder.Record(context.Background(), tt.namespace, NewReport(tt.encryptedSecrets, tt.unencryptedSecrets, tt.allSecretsUseLatestProvider, nil))
 
 			if tt.expectedError != "" {
 				assert.Error(t, err)
@@ -294,14 +313,14 @@ func TestRecorderOperation_Record(t *testing.T) {
 func TestRecorderOperation_Record_Integration(t *testing.T) {
 	// Integration test that tests the complete flow
 	clientset := fake.NewSimpleClientset()
-	recorder := NewRecorderOperator(clientset)
+	recorder := NewRecorderOperator(clientset, Config{})
 
 	namespace := "integration-test"
 	encryptedSecrets := []string{"default/secret1", "kube-system/secret2"}
 	unencryptedSecrets := []string{"default/secret3"}
 
 	// First call - creates ConfigMap
-	err := recorder.Record(context.Background(), namespace, encryptedSecrets, unencryptedSecrets, false)
+	err := recorder.Record(context.Background(), namespace, NewReport(encryptedSecrets, unencryptedSecrets, false, nil))
 	assert.NoError(t, err)
 
 	// Verify ConfigMap was created
@@ -309,12 +328,13 @@ func TestRecorderOperation_Record_Integration(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "default/secret1,kube-system/secret2", cm.Data[encryptedSecretsKey])
 	assert.Equal(t, "default/secret3", cm.Data[unencryptedSecretsKey])
+	assert.Equal(t, version.Get().String(), cm.Data[reporterVersionKey])
 	_, exists := cm.Data[encryptedByLatestProviderKey]
 	assert.False(t, exists, "latest provider key should not exist when not all secrets are encrypted")
 
 	// Second call - updates ConfigMap (all secrets now encrypted)
 	allEncryptedSecrets := []string{"default/secret1", "kube-system/secret2", "default/secret3"}
-	err = recorder.Record(context.Background(), namespace, allEncryptedSecrets, []string{}, true)
+	err = recorder.Record(context.Background(), namespace, NewReport(allEncryptedSecrets, []string{}, true, nil))
 	assert.NoError(t, err)
 
 	// Verify ConfigMap was updated
@@ -325,7 +345,7 @@ func TestRecorderOperation_Record_Integration(t *testing.T) {
 	assert.Equal(t, "true", cm.Data[encryptedByLatestProviderKey])
 
 	// Third call - updates ConfigMap (some secrets become unencrypted again)
-	err = recorder.Record(context.Background(), namespace, []string{"default/secret1"}, []string{"default/secret2"}, false)
+	err = recorder.Record(context.Background(), namespace, NewReport([]string{"default/secret1"}, []string{"default/secret2"}, false, nil))
 	assert.NoError(t, err)
 
 	// Verify ConfigMap was updated and latest provider key was removed
@@ -386,7 +406,7 @@ func TestRecorderOperation_CreateConfigMap_EdgeCases(t *testing.T) {
 				Clientset: clientset,
 			}
 
-			err := recorder.Record(context.Background(), "test-namespace", tt.encryptedSecrets, tt.unencryptedSecrets, tt.allSecretsUseLatestProvider)
+			err := recorder.Record(context.Background(), "test-namespace", NewReport(tt.encryptedSecrets, tt.unencryptedSecrets, tt.allSecretsUseLatestProvider, nil))
 			assert.NoError(t, err)
 
 			// Verify the ConfigMap contents
@@ -407,43 +427,838 @@ func TestRecorderOperation_CreateConfigMap_EdgeCases(t *testing.T) {
 	}
 }
 
-func TestRecorderOperator_Interface(t *testing.T) {
-	// Test using the generated mock for interface-level testing
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
+func TestGetReport(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+
+	_, err := GetReport(context.Background(), clientset, "test-namespace", "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get ConfigMap")
+
+	recorder := NewRecorderOperator(clientset, Config{})
+	err = recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1"}, []string{"default/secret2"}, false, nil))
+	assert.NoError(t, err)
+
+	data, err := GetReport(context.Background(), clientset, "test-namespace", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "default/secret1", data[encryptedSecretsKey])
+	assert.Equal(t, "default/secret2", data[unencryptedSecretsKey])
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	var verbs []string
+	for _, permission := range RequiredPermissions("test-namespace", "", "", false) {
+		assert.Equal(t, "configmaps", permission.Resource)
+		assert.Equal(t, "test-namespace", permission.Namespace)
+		verbs = append(verbs, permission.Verb)
+	}
+	assert.ElementsMatch(t, []string{"get", "create", "update"}, verbs)
+
+	permissions := RequiredPermissions("test-namespace", "", "kms-reporter", false)
+	assert.Len(t, permissions, 4)
+	assert.Equal(t, rbac.Permission{Verb: "get", Group: "apps", Resource: "deployments", Namespace: "test-namespace", Name: "kms-reporter"}, permissions[3])
+
+	// Patching replaces the update permission
+	assert.Contains(t, RequiredPermissions("test-namespace", "", "", true), rbac.Permission{Verb: "patch", Resource: "configmaps", Namespace: "test-namespace", Name: kmsReporterConfigMapName})
+	assert.NotContains(t, RequiredPermissions("test-namespace", "", "", true), rbac.Permission{Verb: "update", Resource: "configmaps", Namespace: "test-namespace", Name: kmsReporterConfigMapName})
+
+	// The reports of additional prefixes are read and written as well
+	permissions = RequiredPermissions("test-namespace", "cp1", "", false, "configmaps")
+	assert.Len(t, permissions, 5)
+	assert.Contains(t, permissions, rbac.Permission{Verb: "get", Resource: "configmaps", Namespace: "test-namespace", Name: "kms-reporter-cp1-configmaps"})
+	assert.Contains(t, permissions, rbac.Permission{Verb: "update", Resource: "configmaps", Namespace: "test-namespace", Name: "kms-reporter-cp1-configmaps"})
+}
 
-	mockRecorder := mock_recorder.NewMockRecorderOperator(ctrl)
+func TestRecorderOperation_Record_Resource(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
 
-	// Setup expectations
-	mockRecorder.EXPECT().
-		Record(gomock.Any(), "test-namespace", []string{"secret1"}, []string{"secret2"}, false).
-		Return(nil).
-		Times(1)
+	report := NewReport(nil, []string{"default/config1"}, false, nil)
+	report.Resource = "configmaps"
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), "kms-reporter-configmaps", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, allSecretsPattern, cm.Data[unencryptedSecretsKey])
+	// The main report is left alone
+	_, err = clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
 
-	// Test the interface
-	var recorder RecorderOperator = mockRecorder
-	err := recorder.Record(context.Background(), "test-namespace", []string{"secret1"}, []string{"secret2"}, false)
+func TestRecorderOperation_Record_Metadata(t *testing.T) {
+	owner := &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "kms-reporter", UID: "uid-1"}
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            kmsReporterConfigMapName,
+			Namespace:       "test-namespace",
+			Labels:          map[string]string{"team": "security"},
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+	})
+	recorder := NewRecorderOperator(clientset, Config{Owner: owner})
 
+	ctx := utils.ContextWithRunID(context.Background(), "run-1")
+	err := recorder.Record(ctx, "test-namespace", NewReport([]string{"default/secret1"}, nil, true, nil))
 	assert.NoError(t, err)
+
+	cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "security", managedByLabel: "kms-reporter", nameLabel: "kms-reporter"}, cm.Labels)
+	assert.Equal(t, "run-1", cm.Annotations[runIDAnnotationKey])
+	assert.Len(t, cm.OwnerReferences, 1, "an existing owner reference should not be duplicated")
 }
 
-func TestRecorderOperator_Interface_WithError(t *testing.T) {
-	// Test error case using the generated mock
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
+func TestRecorderOperation_Record_MetadataOnCreate(t *testing.T) {
+	owner := &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "kms-reporter", UID: "uid-1"}
+	clientset := fake.NewSimpleClientset()
 
-	mockRecorder := mock_recorder.NewMockRecorderOperator(ctrl)
+	// Without an owner or run ID only the labels are set
+	err := NewRecorderOperator(clientset, Config{}).Record(context.Background(), "ns1", NewReport([]string{"default/secret1"}, nil, true, nil))
+	assert.NoError(t, err)
+	cm, err := clientset.CoreV1().ConfigMaps("ns1").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "kms-reporter", cm.Labels[managedByLabel])
+	assert.Empty(t, cm.Annotations)
+	assert.Empty(t, cm.OwnerReferences)
 
-	// Setup expectations for error case
-	mockRecorder.EXPECT().
-		Record(gomock.Any(), "test-namespace", gomock.Any(), gomock.Any(), gomock.Any()).
-		Return(errors.New("mock recorder error")).
-		Times(1)
+	err = NewRecorderOperator(clientset, Config{Owner: owner}).Record(utils.ContextWithRunID(context.Background(), "run-2"), "ns2", NewReport([]string{"default/secret1"}, nil, true, nil))
+	assert.NoError(t, err)
+	cm, err = clientset.CoreV1().ConfigMaps("ns2").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "run-2", cm.Annotations[runIDAnnotationKey])
+	assert.Equal(t, []metav1.OwnerReference{*owner}, cm.OwnerReferences)
+}
 
-	// Test the interface
-	var recorder RecorderOperator = mockRecorder
-	err := recorder.Record(context.Background(), "test-namespace", []string{"secret1"}, []string{}, true)
+func TestRecorderOperation_Record_RemovesUnusedMark(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:        kmsReporterConfigMapName,
+		Namespace:   "test-namespace",
+		Annotations: map[string]string{UnusedSinceAnnotation: "2024-05-01T12:00:00Z"},
+	}})
 
+	// A report written again, e.g. once its prefix is configured back, is no longer collected
+	for _, patch := range []bool{false, true} {
+		err := NewRecorderOperator(clientset, Config{Patch: patch}).Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1"}, nil, true, nil))
+		assert.NoError(t, err)
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.NotContains(t, cm.Annotations, UnusedSinceAnnotation)
+		cm.Annotations = map[string]string{UnusedSinceAnnotation: "2024-05-01T12:00:00Z"}
+		_, err = clientset.CoreV1().ConfigMaps("test-namespace").Update(context.TODO(), cm, metav1.UpdateOptions{})
+		assert.NoError(t, err)
+	}
+}
+
+func TestDeploymentOwnerReference(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "kms-reporter", Namespace: "kms", UID: "uid-1"},
+	})
+
+	owner, err := DeploymentOwnerReference(context.Background(), clientset, "kms", "kms-reporter")
+	assert.NoError(t, err)
+	assert.Equal(t, &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "kms-reporter", UID: "uid-1"}, owner)
+
+	_, err = DeploymentOwnerReference(context.Background(), clientset, "kms", "missing")
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "mock recorder error")
+	assert.Contains(t, err.Error(), "failed to get owner Deployment kms/missing")
+}
+
+func TestRecorderOperation_Record_ProviderCounts(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+
+	// Mid-migration: both providers hold secrets
+	err := recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1", "default/secret2", "default/secret3"}, []string{}, false,
+		map[string]int{"kmsprovider2": 1, "kmsprovider3": 2}))
+	assert.NoError(t, err)
+
+	cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"kmsprovider2":1,"kmsprovider3":2}`, cm.Data[providerCountsKey])
+
+	// Migration finished: the old bucket disappears
+	err = recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1", "default/secret2", "default/secret3"}, []string{}, true,
+		map[string]int{"kmsprovider3": 3}))
+	assert.NoError(t, err)
+
+	cm, err = clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"kmsprovider3":3}`, cm.Data[providerCountsKey])
+}
+
+func TestFormatProviderCounts(t *testing.T) {
+	value, err := formatProviderCounts(utils.JSONMarshaller{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", value)
+
+	value, err = formatProviderCounts(utils.JSONMarshaller{}, map[string]int{"identity": 4, "kmsprovider1": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"identity":4,"kmsprovider1":2}`, value)
+
+	value, err = formatProviderCounts(utils.YAMLMarshaller{}, map[string]int{"identity": 4, "kmsprovider1": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "identity: 4\nkmsprovider1: 2\n", value)
+}
+
+func TestParseProviderCounts(t *testing.T) {
+	providerCounts, err := ParseProviderCounts(map[string]string{providerCountsKey: `{"identity":4,"kmsprovider1":2}`})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"identity": 4, "kmsprovider1": 2}, providerCounts)
+
+	providerCounts, err = ParseProviderCounts(map[string]string{providerCountsKey: "identity: 4\nkmsprovider1: 2\n"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"identity": 4, "kmsprovider1": 2}, providerCounts)
+
+	_, err = ParseProviderCounts(map[string]string{})
+	assert.ErrorContains(t, err, "report has no PROVIDER_COUNTS key")
+	_, err = ParseProviderCounts(map[string]string{providerCountsKey: "{"})
+	assert.ErrorContains(t, err, "failed to unmarshal provider counts")
+}
+
+func TestRecorderOperation_Record_TooLarge(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+
+	// Enough unencrypted secrets to push the list past the ConfigMap size limit
+	secrets := make([]string, 0, 60000)
+	for i := 0; i < 60000; i++ {
+		secrets = append(secrets, fmt.Sprintf("default/secret-%d", i))
+	}
+
+	err := recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/encrypted"}, secrets, false, nil))
+	assert.ErrorIs(t, err, ErrConfigMapTooLarge)
+
+	_, err = clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.Error(t, err, "an oversized ConfigMap must not be created")
+}
+
+func TestReportObjectReference(t *testing.T) {
+	ref := ReportObjectReference("kms", "")
+	assert.Equal(t, "ConfigMap", ref.Kind)
+	assert.Equal(t, "kms", ref.Namespace)
+	assert.Equal(t, kmsReporterConfigMapName, ref.Name)
+
+	assert.Equal(t, "kms-reporter-cp1", ReportObjectReference("kms", "cp1").Name)
+}
+
+func TestRecorderOperation_Record_NodeName(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	for _, node := range []string{"cp1", "cp2"} {
+		recorder := NewRecorderOperator(clientset, Config{NodeName: node})
+		assert.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1"}, nil, true, nil)))
+	}
+
+	for _, node := range []string{"cp1", "cp2"} {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), "kms-reporter-"+node, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, node, cm.Labels[nodeNameLabel])
+	}
+	_, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.Error(t, err, "per-node reporters should not write the cluster-wide report")
+
+	data, err := GetReport(context.Background(), clientset, "test-namespace", "cp2")
+	assert.NoError(t, err)
+	assert.Equal(t, allSecretsPattern, data[encryptedSecretsKey])
+
+	for _, permission := range RequiredPermissions("test-namespace", "cp1", "", false) {
+		if permission.Name != "" {
+			assert.Equal(t, "kms-reporter-cp1", permission.Name)
+		}
+	}
+}
+
+func TestRecorderOperation_Hook(t *testing.T) {
+	// The hook appends every event it receives to a file, one per line
+	output := filepath.Join(t.TempDir(), "events")
+	statusHook, err := hook.New(hook.Config{Command: []string{"/bin/sh", "-c", `cat >> "$0"; echo >> "$0"`, output}})
+	require.NoError(t, err)
+	recorder := NewRecorderOperator(fake.NewSimpleClientset(), Config{Hook: statusHook})
+	events := func() []hook.Event {
+		data, err := os.ReadFile(output)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		require.NoError(t, err)
+		var events []hook.Event
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var event hook.Event
+			require.NoError(t, json.Unmarshal([]byte(line), &event))
+			events = append(events, event)
+		}
+		return events
+	}
+	statuses := func(event hook.Event) []string {
+		var statuses []string
+		for _, transition := range event.Transitions {
+			statuses = append(statuses, fmt.Sprintf("%s:%s->%s", transition.Type, transition.From, transition.To))
+		}
+		return statuses
+	}
+
+	// The conditions of the first report are all new
+	report := NewReport([]string{"default/secret1"}, []string{"default/secret2"}, false, nil)
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	require.Len(t, events(), 1)
+	event := events()[0]
+	assert.Equal(t, "test-namespace", event.Namespace)
+	assert.Equal(t, kmsReporterConfigMapName, event.Name)
+	assert.Equal(t, []string{"Encrypted:->False", "OnLatestProvider:->Unknown"}, statuses(event))
+	assert.Equal(t, "default/secret2", event.Report[unencryptedSecretsKey])
+
+	// An unchanged status does not run the hook
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.Len(t, events(), 1)
+
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1", "default/secret2"}, nil, true, nil)))
+	require.Len(t, events(), 2)
+	assert.Equal(t, []string{"Encrypted:False->True", "OnLatestProvider:Unknown->True"}, statuses(events()[1]))
+
+	assert.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", errors.New("etcd unavailable"), time.Now()))
+	require.Len(t, events(), 3)
+	assert.Equal(t, []string{"ScanHealthy:->False"}, statuses(events()[2]))
+	assert.Equal(t, runStatusFailed, events()[2].Report[lastRunStatusKey])
+}
+
+func TestRecorderOperation_RecordRunStatus(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+	tuesday := time.Date(2025, 1, 7, 10, 0, 0, 0, time.UTC)
+
+	// A failure before any report exists creates the ConfigMap with the status only
+	assert.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", errors.New("etcd unavailable"), tuesday))
+	data := getData()
+	assert.Equal(t, runStatusFailed, data[lastRunStatusKey])
+	assert.Equal(t, "etcd unavailable", data[lastRunErrorKey])
+	assert.NotContains(t, data, lastSuccessfulRunKey)
+
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1"}, nil, true, nil)))
+	assert.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", nil, tuesday))
+	data = getData()
+	assert.Equal(t, runStatusSuccess, data[lastRunStatusKey])
+	assert.Equal(t, "2025-01-07T10:00:00Z", data[lastSuccessfulRunKey])
+	assert.NotContains(t, data, lastRunErrorKey)
+
+	// Later failures keep the report data and the time of the last successful run
+	assert.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", errors.New(strings.Repeat("x", 2000)), tuesday.Add(time.Hour)))
+	data = getData()
+	assert.Equal(t, runStatusFailed, data[lastRunStatusKey])
+	assert.Len(t, data[lastRunErrorKey], maxRunErrorLength)
+	assert.Equal(t, "2025-01-07T10:00:00Z", data[lastSuccessfulRunKey])
+	assert.Equal(t, allSecretsPattern, data[encryptedSecretsKey])
+
+	// Record keeps the status keys
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1"}, nil, true, nil)))
+	assert.Equal(t, runStatusFailed, getData()[lastRunStatusKey])
+
+	// Runs skipped by an open circuit breaker are degraded rather than failed
+	assert.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", fmt.Errorf("failed to read etcd: %w", etcd.ErrCircuitOpen), tuesday.Add(2*time.Hour)))
+	data = getData()
+	assert.Equal(t, runStatusDegraded, data[lastRunStatusKey])
+	assert.Contains(t, data[lastRunErrorKey], "circuit breaker open")
+	assert.Equal(t, "2025-01-07T10:00:00Z", data[lastSuccessfulRunKey])
+}
+
+func TestRecorderOperation_CreateNamespace(t *testing.T) {
+	newClientset := func() *fake.Clientset {
+		clientset := fake.NewSimpleClientset()
+		// The fake clientset does not check that the namespace of an object exists
+		clientset.PrependReactor("create", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			namespace := action.GetNamespace()
+			if _, err := clientset.Tracker().Get(v1.SchemeGroupVersion.WithResource("namespaces"), "", namespace); err != nil {
+				return true, nil, apierrors.NewNotFound(v1.Resource("namespaces"), namespace)
+			}
+			return false, nil, nil
+		})
+		return clientset
+	}
+
+	clientset := newClientset()
+	err := NewRecorderOperator(clientset, Config{}).Record(context.Background(), "reports", NewReport(nil, nil, true, nil))
+	assert.ErrorContains(t, err, `namespaces "reports" not found`)
+
+	recorder := NewRecorderOperator(clientset, Config{CreateNamespace: true})
+	require.NoError(t, recorder.Record(context.Background(), "reports", NewReport([]string{"default/secret1"}, nil, true, nil)))
+	namespace, err := clientset.CoreV1().Namespaces().Get(context.Background(), "reports", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{managedByLabel: reporterName, nameLabel: reporterName}, namespace.Labels)
+	_, err = clientset.CoreV1().ConfigMaps("reports").Get(context.Background(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// A run failing before any report exists creates the namespace as well
+	clientset = newClientset()
+	recorder = NewRecorderOperator(clientset, Config{CreateNamespace: true})
+	require.NoError(t, recorder.RecordRunStatus(context.Background(), "reports", errors.New("etcd unavailable"), time.Now()))
+	_, err = clientset.CoreV1().ConfigMaps("reports").Get(context.Background(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	// An existing namespace is left as is
+	assert.NoError(t, CreateNamespace(context.Background(), clientset, "reports"))
+	assert.Equal(t, []rbac.Permission{{Verb: "create", Resource: "namespaces"}}, NamespaceRequiredPermissions())
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short", 10))
+	assert.Equal(t, "abcdefg...", truncate("abcdefghijkl", 10))
+	// Multi-byte characters are not split
+	assert.Equal(t, "ab...", truncate("abéééé", 6))
+}
+
+func TestRecorderOperation_Record_Patch(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: kmsReporterConfigMapName, Namespace: "test-namespace"},
+		Data: map[string]string{
+			encryptedSecretsKey:          allSecretsPattern,
+			encryptedByLatestProviderKey: "true",
+			"OWNED_BY_ANOTHER_WRITER":    "x",
+		},
+	})
+	recorder := NewRecorderOperator(clientset, Config{Patch: true})
+	report := NewReport([]string{"default/secret1"}, []string{"default/secret2"}, false, map[string]int{"kmsprovider": 1})
+
+	clientset.ClearActions()
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	var patches []clienttesting.PatchAction
+	for _, action := range clientset.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb())
+		if patch, ok := action.(clienttesting.PatchAction); ok {
+			patches = append(patches, patch)
+		}
+	}
+	cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, patches, 1) {
+		assert.Equal(t, types.MergePatchType, patches[0].GetPatchType())
+		assert.JSONEq(t, fmt.Sprintf(`{
+			"metadata": {"labels": {%q: "kms-reporter", %q: "kms-reporter"}},
+			"data": {
+				%q: "default/secret1",
+				%q: "default/secret2",
+				%q: "{\"kmsprovider\":1}",
+				%q: %q,
+				%q: %q,
+				%q: %q,
+				%q: %q,
+				%q: null
+			}
+		}`, managedByLabel, nameLabel, encryptedSecretsKey, unencryptedSecretsKey, providerCountsKey, reporterVersionKey, version.Get().String(),
+			encryptedChecksumKey, checksum("default/secret1"), unencryptedChecksumKey, checksum("default/secret2"), conditionsKey, cm.Data[conditionsKey],
+			encryptedByLatestProviderKey), string(patches[0].GetPatch()))
+	}
+	assert.Equal(t, "x", cm.Data["OWNED_BY_ANOTHER_WRITER"])
+	assert.Equal(t, "default/secret2", cm.Data[unencryptedSecretsKey])
+	assert.NotContains(t, cm.Data, encryptedByLatestProviderKey)
+
+	// An unchanged report is not written at all
+	clientset.ClearActions()
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	for _, action := range clientset.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+
+	// Run statuses are patched as well
+	clientset.ClearActions()
+	assert.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", errors.New("etcd unavailable"), time.Now()))
+	patches = nil
+	for _, action := range clientset.Actions() {
+		if patch, ok := action.(clienttesting.PatchAction); ok {
+			patches = append(patches, patch)
+		}
+	}
+	cm, err = clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, patches, 1) {
+		assert.JSONEq(t, fmt.Sprintf(`{"data": {%q: %q, %q: "etcd unavailable", %q: %q}}`, lastRunStatusKey, runStatusFailed, lastRunErrorKey,
+			conditionsKey, cm.Data[conditionsKey]), string(patches[0].GetPatch()))
+	}
+}
+
+func TestRecorderOperation_Record_NotCovered(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport(nil, []string{"default/secret1"}, false, nil)
+	report.LatestProvider.NotCovered = true
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.Equal(t, "true", getData()[resourceNotCoveredKey])
+
+	// The key is removed once the resource is covered
+	report.LatestProvider.NotCovered = false
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.NotContains(t, getData(), resourceNotCoveredKey)
+}
+
+func TestRecorderOperation_Record_Encodings(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport([]string{"default/secret1"}, []string{"default/secret2", "default/secret3"}, false, nil)
+	report.UnrecognizedSecrets = []string{"default/garbage1", "default/garbage2"}
+	report.EncodingCounts = map[string]int{"protobuf": 1, "json": 1}
+	report.SequenceCounts = map[int]int{0: 2, 1: 1}
+	report.NonKMSCounts = map[string]int{"aescbc": 1}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	data := getData()
+	assert.Equal(t, "default/garbage1,default/garbage2", data[unrecognizedSecretsKey])
+	assert.JSONEq(t, `{"json":1,"protobuf":1}`, data[encodingCountsKey])
+	assert.JSONEq(t, `{"0":2,"1":1}`, data[sequenceCountsKey])
+	assert.JSONEq(t, `{"aescbc":1}`, data[nonKMSCountsKey])
+
+	// Both keys are removed once every secret is encrypted
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1"}, nil, true, nil)))
+	data = getData()
+	assert.NotContains(t, data, unrecognizedSecretsKey)
+	assert.NotContains(t, data, encodingCountsKey)
+	assert.NotContains(t, data, sequenceCountsKey)
+	assert.NotContains(t, data, nonKMSCountsKey)
+}
+
+func TestRecorderOperation_Record_Revision(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport([]string{"default/secret1"}, nil, true, nil)
+	report.Revision = 123456
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.Equal(t, "123456", getData()[etcdRevisionKey])
+
+	// Scans without a single revision, e.g. with Kine, remove the key
+	report.Revision = 0
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.NotContains(t, getData(), etcdRevisionKey)
+}
+
+func TestRecorderOperation_Record_Remediation(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport([]string{"default/secret1"}, nil, false, nil)
+	report.Remediation = &remediation.Summary{Active: 1, Failed: 1, Created: 1, FailedNamespaces: []string{"team-a"}}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.Equal(t, `{"active":1,"succeeded":0,"failed":1,"created":1,"pending":0,"failedNamespaces":["team-a"]}`, getData()[remediationJobsKey])
+
+	report.Remediation = nil
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.NotContains(t, getData(), remediationJobsKey)
+}
+
+func TestRecorderOperation_Record_EtcdMembers(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport([]string{"default/secret1"}, nil, true, nil)
+	report.Members = []etcd.MemberStatus{
+		{Endpoint: "https://10.0.0.1:2379", Revision: 5000, Keys: 1},
+		{Endpoint: "https://10.0.0.2:2379", Revision: 2000, Keys: 0, Lag: 3000, Lagging: true},
+		{Endpoint: "https://10.0.0.3:2379", Error: "context deadline exceeded"},
+	}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.Equal(t, `[{"endpoint":"https://10.0.0.1:2379","revision":5000,"keys":1,"lag":0},`+
+		`{"endpoint":"https://10.0.0.2:2379","revision":2000,"keys":0,"lag":3000,"lagging":true},`+
+		`{"endpoint":"https://10.0.0.3:2379","keys":0,"lag":0,"error":"context deadline exceeded"}]`, getData()[etcdMembersKey])
+
+	report.Members = nil
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.NotContains(t, getData(), etcdMembersKey)
+}
+
+func TestRecorderOperation_Record_TransformationErrors(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport([]string{"default/secret1"}, nil, true, nil)
+	report.Transformation = &transformation.Errors{Writes: 2, Reads: 1, Statuses: map[string]int64{"DeadlineExceeded": 3}}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.Equal(t, `{"writes":2,"reads":1,"statuses":{"DeadlineExceeded":3}}`, getData()[transformationErrorsKey])
+
+	report.Transformation = nil
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.NotContains(t, getData(), transformationErrorsKey)
+}
+
+func TestRecorderOperation_Record_Recency(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport([]string{"default/secret1", "default/secret2"}, nil, true, nil)
+	report.Recency = &recency.Summary{Since: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Checked: 2, NotRewritten: 1, Secrets: []string{"default/secret1"}}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.Equal(t, `{"since":"2025-01-01T00:00:00Z","checked":2,"notRewritten":1,"secrets":["default/secret1"]}`, getData()[notRewrittenKey])
+
+	report.Recency = nil
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.NotContains(t, getData(), notRewrittenKey)
+}
+
+func TestRecorderOperation_Record_History(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport([]string{"default/secret1", "default/secret2"}, nil, true, nil)
+	report.History = &history.Summary{Days: 90, Tracked: 2, Unchanged: 1, Secrets: []string{"default/secret1"}, TrackedSince: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.Equal(t, `{"days":90,"tracked":2,"unchanged":1,"secrets":["default/secret1"],"trackedSince":"2025-01-01T00:00:00Z"}`, getData()[unchangedSecretsKey])
+
+	report.History = nil
+	report.Deleted = &history.Deletion{Previous: 3, Deleted: 1, Secrets: []string{"default/secret3"}}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.NotContains(t, getData(), unchangedSecretsKey)
+	assert.Equal(t, `{"previous":3,"deleted":1,"secrets":["default/secret3"]}`, getData()[deletedSinceLastRunKey])
+
+	report.Deleted = nil
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.NotContains(t, getData(), deletedSinceLastRunKey)
+}
+
+func TestRecorderOperation_Record_EtcdCluster(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport([]string{"default/secret1"}, nil, true, nil)
+	report.ClusterID = 0xcdf818194e3a8c32
+	report.EtcdEndpoints = []string{"https://10.0.0.1:2379", "https://10.0.0.2:2379"}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.Equal(t, `{"clusterID":"cdf818194e3a8c32","endpoints":["https://10.0.0.1:2379","https://10.0.0.2:2379"]}`, getData()[etcdClusterKey])
+
+	// A fixture has neither
+	report.ClusterID, report.EtcdEndpoints = 0, nil
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	assert.NotContains(t, getData(), etcdClusterKey)
+}
+
+func TestRecorderOperation_Record_Team(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	owner := &metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "kms-reporter", UID: "uid-1"}
+	recorder := NewRecorderOperator(clientset, Config{Owner: owner})
+
+	report := NewReport([]string{"payments/api-key"}, []string{"payments/token"}, true, map[string]int{"kmsprovider1": 1, "identity": 1})
+	report.Team = "payments"
+	assert.NoError(t, recorder.Record(context.Background(), "payments", report))
+
+	cm, err := clientset.CoreV1().ConfigMaps("payments").Get(context.TODO(), "kms-reporter-team-payments", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "payments/token", cm.Data[unencryptedSecretsKey])
+	assert.Equal(t, "payments", cm.Labels[teamLabel])
+	// The owner lives in the namespace of the main report
+	assert.Empty(t, cm.OwnerReferences)
+
+	assert.Equal(t, "kms-reporter-node-1-team-payments", TeamReportName("node-1", "payments"))
+}
+
+func TestRecorderOperation_Record_ScanStats(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport([]string{"default/secret1"}, nil, true, nil)
+	report.Scan = analyzer.ScanStats{Keys: 1200, Bytes: 3456789, Pages: 3, ParseErrors: 2, ParseErrorClasses: map[string]int64{"InvalidKey": 2}, Duration: 1500 * time.Millisecond, Concurrency: 1}
+	report.LargestSecrets = []analyzer.SecretSize{{Name: "default/secret1", Size: 1048576}}
+	report.OldestSecrets = []analyzer.SecretProvider{{Name: "default/secret1", Provider: "kmsprovider1", Seq: 1}}
+	report.Skew = &analyzer.RevisionSkew{Revision: 50, Modified: 1, ModifiedSecrets: []string{"default/secret2"}, Created: 1}
+	report.EstimatedCompletion = time.Date(2025, 1, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	report.Progress = &Progress{OnLatest: 2, Total: 3, Percent: 200.0 / 3, Delta: 100.0 / 3}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	data := getData()
+	assert.Equal(t, "1200", data[scannedKeysKey])
+	assert.Equal(t, "3456789", data[scannedBytesKey])
+	assert.JSONEq(t, `{"durationSeconds":1.5,"pages":3,"keys":1200,"bytes":3456789,"parseErrors":2,"parseErrorClasses":{"InvalidKey":2},"concurrency":1}`, data[scanStatsKey])
+	assert.JSONEq(t, `[{"name":"default/secret1","size":1048576}]`, data[largestSecretsKey])
+	assert.JSONEq(t, `[{"name":"default/secret1","provider":"kmsprovider1","seq":1}]`, data[oldestSecretsKey])
+	assert.JSONEq(t, `{"revision":50,"modified":1,"modifiedSecrets":["default/secret2"],"created":1,"deleted":0}`, data[scanRevisionSkewKey])
+	assert.Equal(t, "2025-01-01T11:00:00Z", data[estimatedCompletionKey])
+	assert.JSONEq(t, `{"onLatest":2,"total":3,"percent":66.67,"delta":33.33}`, data[progressKey])
+
+	// Reports built without scan statistics remove the keys
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1"}, nil, true, nil)))
+	data = getData()
+	assert.NotContains(t, data, scannedKeysKey)
+	assert.NotContains(t, data, scannedBytesKey)
+	assert.NotContains(t, data, scanStatsKey)
+	assert.NotContains(t, data, largestSecretsKey)
+	assert.NotContains(t, data, oldestSecretsKey)
+	assert.NotContains(t, data, scanRevisionSkewKey)
+	assert.NotContains(t, data, estimatedCompletionKey)
+	assert.NotContains(t, data, progressKey)
+}
+
+func TestRecorderOperation_Record_MaxListedSecrets(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{MaxListedSecrets: 2})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	report := NewReport(
+		[]string{"default/a", "default/b", "default/c", "kube-system/d", "kube-system/e"},
+		[]string{"default/f", "default/g"},
+		false, nil)
+	report.UnrecognizedSecrets = []string{"apps/h", "apps/i", "apps/j"}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	data := getData()
+	assert.Equal(t, "default/a,default/b", data[encryptedSecretsKey])
+	assert.JSONEq(t, `{"default":1,"kube-system":2}`, data[encryptedRollupKey])
+	// Lists within the limit have no rollup
+	assert.Equal(t, "default/f,default/g", data[unencryptedSecretsKey])
+	assert.NotContains(t, data, unencryptedRollupKey)
+	assert.Equal(t, "apps/h,apps/i", data[unrecognizedSecretsKey])
+	assert.JSONEq(t, `{"apps":1}`, data[unrecognizedRollupKey])
+
+	// ALL_SECRETS lists no names, so nothing is rolled up and the stale rollups are removed
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/a", "default/b", "default/c"}, nil, true, nil)))
+	data = getData()
+	assert.Equal(t, allSecretsPattern, data[encryptedSecretsKey])
+	assert.NotContains(t, data, encryptedRollupKey)
+	assert.NotContains(t, data, unrecognizedRollupKey)
+}
+
+func TestRecorderOperation_Record_MaxListedSecrets_ClusterScoped(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{MaxListedSecrets: 1})
+
+	// Cluster-scoped objects of additional resources have no namespace to be rolled up under
+	report := NewReport([]string{"v1.apps", "v1beta1.metrics.k8s.io", "v1.batch"}, []string{"v1.custom.example.com"}, false, nil)
+	report.Resource = "apiservices.apiregistration.k8s.io"
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), ResourceReportName("", report.Resource), metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.apps", cm.Data[encryptedSecretsKey])
+	assert.JSONEq(t, `{"":2}`, cm.Data[encryptedRollupKey])
+}
+
+func TestRecorderOperation_Record_Checksums(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{MaxListedSecrets: 1})
+
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/a", "default/b"}, []string{"default/c"}, false, nil)))
+	cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	// The checksums cover the values as written, after capping
+	assert.Equal(t, "default/a", cm.Data[encryptedSecretsKey])
+	assert.Equal(t, "9efb36117d50566eadc122eb79d9e2f34884b3d46f59dc004380a552109f51b0", cm.Data[encryptedChecksumKey])
+	assert.Equal(t, checksum("default/c"), cm.Data[unencryptedChecksumKey])
+	// Empty lists have the checksum of an empty value
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/a"}, nil, true, nil)))
+	cm, err = clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", cm.Data[unencryptedChecksumKey])
+}
+
+func TestRecorderOperation_Record_CountsOnly(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{CountsOnly: true})
+	report := NewReport([]string{"default/a", "kube-system/b"}, []string{"kube-system/c"}, false, map[string]int{"kmsprovider1": 2, "identity": 1})
+	report.UnrecognizedSecrets = []string{"default/d"}
+	report.LargestSecrets = []analyzer.SecretSize{{Name: "default/a", Size: 2048}}
+	report.Skew = &analyzer.RevisionSkew{Revision: 12, Modified: 1, ModifiedSecrets: []string{"default/a"}}
+	report.Recency = &recency.Summary{Checked: 2, NotRewritten: 1, Secrets: []string{"kube-system/b"}}
+	report.History = &history.Summary{Days: 30, Tracked: 3, Unchanged: 1, Secrets: []string{"kube-system/c"}}
+	report.Deleted = &history.Deletion{Previous: 4, Deleted: 1, Secrets: []string{"default/e"}}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+
+	cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	for key, value := range cm.Data {
+		for _, name := range []string{"/a", "/b", "/c", "/d", "/e"} {
+			assert.NotContains(t, value, name, "key %s", key)
+		}
+	}
+	assert.JSONEq(t, `{"default":1,"kube-system":1}`, cm.Data[encryptedRollupKey])
+	assert.JSONEq(t, `{"kube-system":1}`, cm.Data[unencryptedRollupKey])
+	assert.JSONEq(t, `{"default":1}`, cm.Data[unrecognizedRollupKey])
+	assert.JSONEq(t, `{"total":4,"encrypted":2,"unencrypted":1,"unrecognized":1,"encryptedPercent":50}`, cm.Data[secretCountsKey])
+	assert.JSONEq(t, `{"revision":12,"modified":1,"created":0,"deleted":0}`, cm.Data[scanRevisionSkewKey])
+	assert.NotContains(t, cm.Data, largestSecretsKey)
+	assert.JSONEq(t, `{"previous":4,"deleted":1}`, cm.Data[deletedSinceLastRunKey])
+	assert.Contains(t, cm.Data[conditionsKey], "1 of 4 secrets are not encrypted")
+}
+
+func TestRecorderOperation_Record_SummaryOnly(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{SummaryOnlyAbove: 3})
+	getData := func() map[string]string {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return cm.Data
+	}
+
+	// At the threshold, the lists are written
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/a", "default/b"}, []string{"kube-system/c"}, false, nil)))
+	data := getData()
+	assert.Equal(t, "default/a,default/b", data[encryptedSecretsKey])
+	assert.NotContains(t, data, secretCountsKey)
+	assert.NotContains(t, data, encryptedRollupKey)
+
+	// Above it, they are replaced by their rollups and the counts
+	report := NewReport([]string{"default/a", "default/b", "kube-system/d"}, []string{"kube-system/c"}, false, nil)
+	report.UnrecognizedSecrets = []string{"default/e"}
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	data = getData()
+	assert.Equal(t, "", data[encryptedSecretsKey])
+	assert.Equal(t, "", data[unencryptedSecretsKey])
+	assert.NotContains(t, data, unrecognizedSecretsKey)
+	assert.JSONEq(t, `{"default":2,"kube-system":1}`, data[encryptedRollupKey])
+	assert.JSONEq(t, `{"kube-system":1}`, data[unencryptedRollupKey])
+	assert.JSONEq(t, `{"default":1}`, data[unrecognizedRollupKey])
+	assert.JSONEq(t, `{"total":5,"encrypted":3,"unencrypted":1,"unrecognized":1,"encryptedPercent":60}`, data[secretCountsKey])
+
+	// ALL_SECRETS is kept
+	assert.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/a", "default/b", "default/c", "default/d"}, nil, true, nil)))
+	data = getData()
+	assert.Equal(t, allSecretsPattern, data[encryptedSecretsKey])
+	assert.JSONEq(t, `{"default":4}`, data[encryptedRollupKey])
+	assert.NotContains(t, data, unencryptedRollupKey)
+	assert.JSONEq(t, `{"total":4,"encrypted":4,"unencrypted":0,"unrecognized":0,"encryptedPercent":100}`, data[secretCountsKey])
 }
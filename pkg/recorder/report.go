@@ -0,0 +1,129 @@
+package recorder
+
+import (
+	"context"
+	"time"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/history"
+	"github.com/lzhecheng/kms-reporter/pkg/recency"
+	"github.com/lzhecheng/kms-reporter/pkg/remediation"
+	"github.com/lzhecheng/kms-reporter/pkg/transformation"
+)
+
+// Report is what a run records: the analysis result and the metadata describing it. New fields are
+// added here rather than as parameters of RecorderOperator.Record.
+type Report struct {
+	analyzer.Result
+	// Resource is the resource of an additional scan prefix, e.g. configmaps, whose report is stored
+	// separately, or "" for the report of the main prefix.
+	Resource string
+	// Team is the team of a filtered view of the report, covering only the namespaces of the team, or ""
+	// for the full report.
+	Team string
+	// Progress is the share of secrets on the latest provider, or nil if it is not tracked.
+	Progress *Progress
+	// EstimatedCompletion is when the current rotation is estimated to complete, or the zero time if it
+	// is complete or cannot be estimated.
+	EstimatedCompletion time.Time
+	// Remediation summarizes the remediation Jobs, or is nil if remediation is disabled.
+	Remediation *remediation.Summary
+	// EtcdEndpoints are the endpoints of the etcd the result was read from, or nil if unknown, e.g. for a
+	// fixture.
+	EtcdEndpoints []string
+	// Members is the view every etcd member has of the scanned prefix, or nil if members are not compared.
+	Members []etcd.MemberStatus
+	// Transformation counts the envelope transformations the API server failed since the previous run,
+	// or is nil if the API server is not monitored.
+	Transformation *transformation.Errors
+	// Recency counts the encrypted secrets not rewritten since a date, e.g. the last key rotation, or is
+	// nil if they are not checked.
+	Recency *recency.Summary
+	// History counts the secrets whose encryption state has not changed for long, or is nil if it is not
+	// tracked.
+	History *history.Summary
+	// Deleted lists the secrets deleted since the previous run, or is nil if they are not tracked or
+	// there was no previous run.
+	Deleted *history.Deletion
+}
+
+// withoutNames returns a copy of the report without any secret name, neither in the result nor in the
+// summaries that name secrets. The summaries are copied, as they may be shared with the caller.
+func (r Report) withoutNames() Report {
+	r.Result = r.Result.WithoutNames()
+	if r.Recency != nil {
+		summary := *r.Recency
+		summary.Secrets = nil
+		r.Recency = &summary
+	}
+	if r.History != nil {
+		summary := *r.History
+		summary.Secrets = nil
+		r.History = &summary
+	}
+	if r.Deleted != nil {
+		deletion := *r.Deleted
+		deletion.Secrets = nil
+		r.Deleted = &deletion
+	}
+	return r
+}
+
+// Progress is the share of secrets encrypted by the latest provider, tracked across runs.
+type Progress struct {
+	// OnLatest is the number of secrets encrypted by the latest provider.
+	OnLatest int `json:"onLatest"`
+	// Total is the number of secrets.
+	Total int `json:"total"`
+	// Percent is the percentage of secrets encrypted by the latest provider.
+	Percent float64 `json:"percent"`
+	// Delta is the change of Percent since the previous run, in percentage points.
+	Delta float64 `json:"delta"`
+}
+
+// NewReport builds a report from the positional parameters Record took before Report existed.
+func NewReport(encryptedSecrets, unencryptedSecrets []string, allSecretsUseLatestProvider bool, providerCounts map[string]int) Report {
+	return Report{Result: analyzer.Result{
+		EncryptedSecrets:            encryptedSecrets,
+		UnencryptedSecrets:          unencryptedSecrets,
+		AllSecretsUseLatestProvider: allSecretsUseLatestProvider,
+		ProviderCounts:              providerCounts,
+	}}
+}
+
+// LegacyRecorder is the positional Record signature of RecorderOperator before Report existed.
+//
+// Deprecated: implement RecorderOperator and wrap remaining implementations with NewLegacyAdapter.
+type LegacyRecorder interface {
+	Record(ctx context.Context, namespace string, encryptedSecrets, unencryptedSecrets []string, allSecretsUseLatestProvider bool, providerCounts map[string]int) error
+}
+
+// legacyAdapter adapts a LegacyRecorder to RecorderOperator.
+type legacyAdapter struct {
+	legacy LegacyRecorder
+}
+
+// NewLegacyAdapter returns a RecorderOperator that records reports with legacy. Fields of the report
+// the legacy signature has no parameter for are dropped, and the reports of additional scan prefixes and
+// teams are skipped, as legacy could not tell them from the main report. Run statuses are recorded if legacy implements
+// RecordRunStatus and ignored otherwise.
+func NewLegacyAdapter(legacy LegacyRecorder) RecorderOperator {
+	return &legacyAdapter{legacy: legacy}
+}
+
+func (a *legacyAdapter) Record(ctx context.Context, namespace string, report Report) error {
+	if report.Resource != "" || report.Team != "" {
+		return nil
+	}
+	return a.legacy.Record(ctx, namespace, report.EncryptedSecrets, report.UnencryptedSecrets, report.AllSecretsUseLatestProvider, report.ProviderCounts)
+}
+
+func (a *legacyAdapter) RecordRunStatus(ctx context.Context, namespace string, runErr error, finishedAt time.Time) error {
+	if status, ok := a.legacy.(interface {
+		RecordRunStatus(ctx context.Context, namespace string, runErr error, finishedAt time.Time) error
+	}); ok {
+		return status.RecordRunStatus(ctx, namespace, runErr, finishedAt)
+	}
+	return nil
+}
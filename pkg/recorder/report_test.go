@@ -0,0 +1,68 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+type legacyRecorder struct {
+	encrypted, unencrypted []string
+	allLatest              bool
+	providerCounts         map[string]int
+}
+
+func (r *legacyRecorder) Record(_ context.Context, _ string, encryptedSecrets, unencryptedSecrets []string, allSecretsUseLatestProvider bool, providerCounts map[string]int) error {
+	r.encrypted, r.unencrypted, r.allLatest, r.providerCounts = encryptedSecrets, unencryptedSecrets, allSecretsUseLatestProvider, providerCounts
+	return nil
+}
+
+type legacyStatusRecorder struct {
+	legacyRecorder
+	runErr error
+}
+
+func (r *legacyStatusRecorder) RecordRunStatus(_ context.Context, _ string, runErr error, _ time.Time) error {
+	r.runErr = runErr
+	return nil
+}
+
+func TestNewReport(t *testing.T) {
+	report := NewReport([]string{"default/a"}, []string{"default/b"}, false, map[string]int{"kmsprovider1": 1, "identity": 1})
+	assert.Equal(t, []string{"default/a"}, report.EncryptedSecrets)
+	assert.Equal(t, []string{"default/b"}, report.UnencryptedSecrets)
+	assert.Equal(t, 2, report.Total())
+}
+
+func TestLegacyAdapter(t *testing.T) {
+	legacy := &legacyRecorder{}
+	adapter := NewLegacyAdapter(legacy)
+	report := Report{Result: analyzer.Result{
+		EncryptedSecrets:            []string{"default/a"},
+		UnencryptedSecrets:          []string{},
+		AllSecretsUseLatestProvider: true,
+		ProviderCounts:              map[string]int{"kmsprovider1": 1},
+		LatestProvider:              analyzer.LatestProvider{Name: "kmsprovider1", Seq: 1},
+	}}
+
+	assert.NoError(t, adapter.Record(context.Background(), "ns", report))
+	assert.Equal(t, []string{"default/a"}, legacy.encrypted)
+	assert.Equal(t, []string{}, legacy.unencrypted)
+	assert.True(t, legacy.allLatest)
+	assert.Equal(t, map[string]int{"kmsprovider1": 1}, legacy.providerCounts)
+
+	// Reports of additional prefixes would overwrite the main report
+	assert.NoError(t, adapter.Record(context.Background(), "ns", Report{Result: analyzer.Result{EncryptedSecrets: []string{"default/config1"}}, Resource: "configmaps"}))
+	assert.Equal(t, []string{"default/a"}, legacy.encrypted)
+
+	// Run statuses are dropped unless the legacy recorder records them
+	assert.NoError(t, adapter.RecordRunStatus(context.Background(), "ns", errors.New("failed"), time.Now()))
+	withStatus := &legacyStatusRecorder{}
+	assert.NoError(t, NewLegacyAdapter(withStatus).RecordRunStatus(context.Background(), "ns", errors.New("failed"), time.Now()))
+	assert.EqualError(t, withStatus.runErr, "failed")
+}
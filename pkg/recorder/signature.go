@@ -0,0 +1,120 @@
+package recorder
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reportSignatureKey holds the HMAC signature of the other data keys of the report
+const reportSignatureKey = "REPORT_SIGNATURE"
+
+var (
+	// ErrSignatureMissing is returned when verifying a report that has no signature.
+	ErrSignatureMissing = errors.New("report is not signed")
+	// ErrSignatureMismatch is returned when a report was modified after it was signed, or signed with another key.
+	ErrSignatureMismatch = errors.New("report signature does not match")
+)
+
+// ReadSigningKey reads an HMAC signing key from path, e.g. a mounted Secret, ignoring surrounding whitespace.
+func ReadSigningKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report signing key: %w", err)
+	}
+	key := []byte(strings.TrimSpace(string(data)))
+	if len(key) == 0 {
+		return nil, fmt.Errorf("report signing key %s is empty", path)
+	}
+	return key, nil
+}
+
+// SignReport sets the signature of data, computed with key over every other data key and its value.
+// The signed keys are listed in the signature, so keys of other tools added afterwards, e.g. to a patched
+// report, do not invalidate it, while a changed or removed signed key, or an added report key, does.
+func SignReport(data map[string]string, key []byte) {
+	keys := make([]string, 0, len(data))
+	for name := range data {
+		if name != reportSignatureKey {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+	data[reportSignatureKey] = "keys=" + strings.Join(keys, ",") + ";hmac-sha256=" + hex.EncodeToString(reportMAC(data, keys, key))
+}
+
+// VerifyReport checks the signature of data with key. names are the key name overrides the report was
+// written with, or nil for the default names. A report key added after the report was signed, under its
+// default or overridden name, fails the verification. It returns the other data keys the signature does
+// not cover, added by other tools after the report was signed.
+func VerifyReport(data map[string]string, key []byte, names KeyNames) ([]string, error) {
+	signature, ok := data[reportSignatureKey]
+	if !ok {
+		return nil, ErrSignatureMissing
+	}
+	keysField, macField, ok := strings.Cut(signature, ";")
+	keyList, keysOK := strings.CutPrefix(keysField, "keys=")
+	macHex, macOK := strings.CutPrefix(macField, "hmac-sha256=")
+	if !ok || !keysOK || !macOK {
+		return nil, fmt.Errorf("malformed report signature %q", signature)
+	}
+	mac, err := hex.DecodeString(macHex)
+	if err != nil {
+		return nil, fmt.Errorf("malformed report signature %q: %w", signature, err)
+	}
+
+	var keys []string
+	signed := map[string]bool{reportSignatureKey: true}
+	if keyList != "" {
+		keys = strings.Split(keyList, ",")
+	}
+	for _, name := range keys {
+		if _, ok := data[name]; !ok {
+			return nil, fmt.Errorf("%w: signed key %s was removed", ErrSignatureMismatch, name)
+		}
+		signed[name] = true
+	}
+	if !hmac.Equal(mac, reportMAC(data, keys, key)) {
+		return nil, ErrSignatureMismatch
+	}
+
+	owned := make(map[string]bool, len(reportKeys)+len(names))
+	for _, name := range reportKeys {
+		owned[name] = true
+	}
+	for _, name := range names {
+		owned[name] = true
+	}
+	var unsigned []string
+	for name := range data {
+		if signed[name] {
+			continue
+		}
+		if owned[name] {
+			return nil, fmt.Errorf("%w: report key %s was added after the report was signed", ErrSignatureMismatch, name)
+		}
+		unsigned = append(unsigned, name)
+	}
+	sort.Strings(unsigned)
+	return unsigned, nil
+}
+
+// reportMAC returns the HMAC-SHA256 of the sorted keys of data and their values. Every key and value
+// is prefixed with its length, so that no two reports have the same input.
+func reportMAC(data map[string]string, keys []string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, name := range keys {
+		for _, field := range []string{name, data[name]} {
+			mac.Write([]byte(strconv.Itoa(len(field))))
+			mac.Write([]byte{':'})
+			mac.Write([]byte(field))
+		}
+	}
+	return mac.Sum(nil)
+}
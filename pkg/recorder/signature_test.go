@@ -0,0 +1,131 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestVerifyReport(t *testing.T) {
+	key := []byte("signing-key")
+	signed := func() map[string]string {
+		data := map[string]string{encryptedSecretsKey: allSecretsPattern, unencryptedSecretsKey: ""}
+		SignReport(data, key)
+		return data
+	}
+
+	testCases := []struct {
+		name             string
+		modify           func(data map[string]string)
+		key              []byte
+		names            KeyNames
+		expectedUnsigned []string
+		expectedError    string
+	}{
+		{
+			name: "unmodified",
+		},
+		{
+			name:             "keys added after signing are not covered",
+			modify:           func(data map[string]string) { data["OWNER"] = "team-a" },
+			expectedUnsigned: []string{"OWNER"},
+		},
+		{
+			name:          "report keys added after signing",
+			modify:        func(data map[string]string) { data[encryptedByLatestProviderKey] = "true" },
+			expectedError: "report key ENCRYPTED_BY_LATEST_SEQ was added after the report was signed",
+		},
+		{
+			name:          "renamed report keys added after signing",
+			modify:        func(data map[string]string) { data["secret_lists"] = "" },
+			names:         KeyNames{secretListsKey: "secret_lists"},
+			expectedError: "report key secret_lists was added after the report was signed",
+		},
+		{
+			name:          "modified value",
+			modify:        func(data map[string]string) { data[unencryptedSecretsKey] = "default/a" },
+			expectedError: ErrSignatureMismatch.Error(),
+		},
+		{
+			name:          "removed key",
+			modify:        func(data map[string]string) { delete(data, unencryptedSecretsKey) },
+			expectedError: "signed key UNENCRYPTED was removed",
+		},
+		{
+			name: "moved value",
+			modify: func(data map[string]string) {
+				data[encryptedSecretsKey], data[unencryptedSecretsKey] = "", allSecretsPattern
+			},
+			expectedError: ErrSignatureMismatch.Error(),
+		},
+		{
+			name:          "other key",
+			key:           []byte("other-key"),
+			expectedError: ErrSignatureMismatch.Error(),
+		},
+		{
+			name:          "not signed",
+			modify:        func(data map[string]string) { delete(data, reportSignatureKey) },
+			expectedError: ErrSignatureMissing.Error(),
+		},
+		{
+			name:          "malformed",
+			modify:        func(data map[string]string) { data[reportSignatureKey] = "hmac-sha256=00" },
+			expectedError: "malformed report signature",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := signed()
+			if tc.modify != nil {
+				tc.modify(data)
+			}
+			verifyKey := key
+			if tc.key != nil {
+				verifyKey = tc.key
+			}
+			unsigned, err := VerifyReport(data, verifyKey, tc.names)
+			if tc.expectedError != "" {
+				assert.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedUnsigned, unsigned)
+		})
+	}
+}
+
+func TestRecorderOperation_SigningKeyFile(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("signing-key\n"), 0o600))
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{SigningKeyFile: keyFile})
+	verify := func() error {
+		cm, err := clientset.CoreV1().ConfigMaps("test-namespace").Get(context.TODO(), kmsReporterConfigMapName, metav1.GetOptions{})
+		require.NoError(t, err)
+		_, err = VerifyReport(cm.Data, []byte("signing-key"), nil)
+		return err
+	}
+
+	// Both the report and the run status are signed, whichever is written first
+	require.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", errors.New("etcd unavailable"), time.Now()))
+	assert.NoError(t, verify())
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/a"}, []string{"default/b"}, false, nil)))
+	assert.NoError(t, verify())
+	require.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", nil, time.Now()))
+	assert.NoError(t, verify())
+
+	// A missing key fails the write rather than leaving an unsigned report
+	require.NoError(t, os.Remove(keyFile))
+	err := recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/a"}, nil, true, nil))
+	assert.ErrorContains(t, err, "failed to read report signing key")
+}
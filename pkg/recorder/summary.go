@@ -0,0 +1,159 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// UnusedSinceAnnotation holds when a report, or another ConfigMap of the reporter, was first found to be no
+// longer written, e.g. because its scan prefix or team was removed. It is removed when it is written again.
+const UnusedSinceAnnotation = "kms-reporter/unused-since"
+
+// ReportSelector selects the report ConfigMaps of every node and resource.
+var ReportSelector = managedByLabel + "=" + reporterName + "," + nameLabel + "=" + reporterName
+
+// NodeReportSelector selects the report ConfigMaps written on nodeName, or the cluster-wide reports if
+// nodeName is empty, of every resource and team.
+func NodeReportSelector(nodeName string) string {
+	if nodeName == "" {
+		return ReportSelector + ",!" + nodeNameLabel
+	}
+	return ReportSelector + "," + nodeNameLabel + "=" + nodeName
+}
+
+// TeamReportSelector selects the team report ConfigMaps written on nodeName, as NodeReportSelector.
+func TeamReportSelector(nodeName string) string {
+	return NodeReportSelector(nodeName) + "," + teamLabel
+}
+
+// ListReports returns the report ConfigMaps stored in the given namespace.
+func ListReports(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]v1.ConfigMap, error) {
+	configMaps, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: ReportSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list report ConfigMaps: %w", err)
+	}
+	return configMaps.Items, nil
+}
+
+// ReportNodeName returns the node a report ConfigMap was written on, or "" for a cluster-wide report.
+func ReportNodeName(configMap *v1.ConfigMap) string {
+	return configMap.Labels[nodeNameLabel]
+}
+
+// ReportSummary is the status described by a stored report, read back from its data. It holds counts
+// only, so that it can be computed from summary-only reports as well.
+type ReportSummary struct {
+	// ProviderCounts maps each provider to the number of secrets it encrypted, as in
+	// analyzer.Result.ProviderCounts.
+	ProviderCounts map[string]int
+	// Encrypted, Unencrypted and Unrecognized count the secrets of the report.
+	Encrypted    int
+	Unencrypted  int
+	Unrecognized int
+	// AllSecretsUseLatestProvider is nil when not every secret is encrypted, as the report then does
+	// not compare providers.
+	AllSecretsUseLatestProvider *bool
+	// Progress is the share of secrets on the latest provider, or nil if it is not tracked.
+	Progress *Progress
+	// ReporterVersion is the version of the reporter that wrote the report.
+	ReporterVersion string
+	// LastRunStatus is the outcome of the last run, or "" if none was recorded.
+	LastRunStatus string
+	// LastSuccessfulRun is when the last successful run finished, or the zero time.
+	LastSuccessfulRun time.Time
+	// Conditions are the status conditions of the report.
+	Conditions []metav1.Condition
+}
+
+// ParseReport returns the summary of a report as returned by GetReport. Keys missing from the report
+// are left at their zero value, only keys that cannot be read are errors.
+func ParseReport(data map[string]string) (ReportSummary, error) {
+	providerCounts, err := ParseProviderCounts(data)
+	if err != nil {
+		return ReportSummary{}, err
+	}
+	summary := ReportSummary{
+		ProviderCounts:  providerCounts,
+		ReporterVersion: data[reporterVersionKey],
+		LastRunStatus:   data[lastRunStatusKey],
+	}
+	// The secrets of other providers than KMS are counted as unencrypted, like in the secret lists
+	var nonKMSCounts map[string]int
+	if value, ok := data[nonKMSCountsKey]; ok {
+		if err := utils.Unmarshal([]byte(value), &nonKMSCounts); err != nil {
+			return ReportSummary{}, fmt.Errorf("invalid %s: %w", nonKMSCountsKey, err)
+		}
+	}
+	for provider, count := range providerCounts {
+		if _, nonKMS := nonKMSCounts[provider]; nonKMS || provider == analyzer.IdentityProviderName {
+			summary.Unencrypted += count
+		} else {
+			summary.Encrypted += count
+		}
+	}
+
+	if summary.Unrecognized, err = unrecognizedCount(data); err != nil {
+		return ReportSummary{}, err
+	}
+	if value, ok := data[encryptedByLatestProviderKey]; ok {
+		useLatest, err := strconv.ParseBool(value)
+		if err != nil {
+			return ReportSummary{}, fmt.Errorf("invalid %s: %w", encryptedByLatestProviderKey, err)
+		}
+		summary.AllSecretsUseLatestProvider = &useLatest
+	}
+	if value, ok := data[progressKey]; ok {
+		var progress Progress
+		if err := utils.Unmarshal([]byte(value), &progress); err != nil {
+			return ReportSummary{}, fmt.Errorf("failed to unmarshal progress: %w", err)
+		}
+		summary.Progress = &progress
+	}
+	if value, ok := data[lastSuccessfulRunKey]; ok {
+		if summary.LastSuccessfulRun, err = time.Parse(time.RFC3339, value); err != nil {
+			return ReportSummary{}, fmt.Errorf("invalid %s: %w", lastSuccessfulRunKey, err)
+		}
+	}
+	if value, ok := data[conditionsKey]; ok {
+		if err := utils.Unmarshal([]byte(value), &summary.Conditions); err != nil {
+			return ReportSummary{}, fmt.Errorf("failed to unmarshal conditions: %w", err)
+		}
+	}
+	return summary, nil
+}
+
+// unrecognizedCount returns the number of unrecognized secrets of a report: that of SECRET_COUNTS in
+// summary-only reports, otherwise the listed names and those rolled up.
+func unrecognizedCount(data map[string]string) (int, error) {
+	if value, ok := data[secretCountsKey]; ok {
+		var counts secretCounts
+		if err := utils.Unmarshal([]byte(value), &counts); err != nil {
+			return 0, fmt.Errorf("failed to unmarshal secret counts: %w", err)
+		}
+		return counts.Unrecognized, nil
+	}
+	var count int
+	if value := data[unrecognizedSecretsKey]; value != "" {
+		count = len(strings.Split(value, ","))
+	}
+	if value, ok := data[unrecognizedRollupKey]; ok {
+		var rollup map[string]int
+		if err := utils.Unmarshal([]byte(value), &rollup); err != nil {
+			return 0, fmt.Errorf("failed to unmarshal unrecognized rollup: %w", err)
+		}
+		for _, omitted := range rollup {
+			count += omitted
+		}
+	}
+	return count, nil
+}
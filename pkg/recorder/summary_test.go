@@ -0,0 +1,157 @@
+package recorder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+func TestParseReport(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{MaxListedSecrets: 1})
+	report := NewReport([]string{"default/secret1", "default/secret2"}, []string{"default/secret3"}, false, map[string]int{"kmsprovider1": 2, "identity": 1})
+	report.UnrecognizedSecrets = []string{"default/secret4", "team-a/secret5"}
+	report.Progress = &Progress{OnLatest: 2, Total: 3, Percent: 66.67}
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	finishedAt := time.Date(2025, 1, 6, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", nil, finishedAt))
+
+	data, err := GetReport(context.Background(), clientset, "test-namespace", "")
+	require.NoError(t, err)
+	summary, err := ParseReport(data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"kmsprovider1": 2, "identity": 1}, summary.ProviderCounts)
+	assert.Equal(t, 2, summary.Encrypted)
+	assert.Equal(t, 1, summary.Unencrypted)
+	// One listed, one rolled up
+	assert.Equal(t, 2, summary.Unrecognized)
+	// Providers are not compared while secrets are unencrypted
+	assert.Nil(t, summary.AllSecretsUseLatestProvider)
+	assert.Equal(t, &Progress{OnLatest: 2, Total: 3, Percent: 66.67}, summary.Progress)
+	assert.Equal(t, runStatusSuccess, summary.LastRunStatus)
+	assert.True(t, summary.LastSuccessfulRun.Equal(finishedAt))
+	assert.Len(t, summary.Conditions, 3)
+}
+
+func TestParseReport_NonKMS(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{})
+	report := NewReport([]string{"default/secret1"}, []string{"default/secret2", "default/secret3"}, false, map[string]int{"kmsprovider1": 1, "aescbc": 1, "identity": 1})
+	report.NonKMSCounts = map[string]int{"aescbc": 1}
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+
+	data, err := GetReport(context.Background(), clientset, "test-namespace", "")
+	require.NoError(t, err)
+	summary, err := ParseReport(data)
+	require.NoError(t, err)
+	// Secrets encrypted by aescbc are not KMS-encrypted
+	assert.Equal(t, 1, summary.Encrypted)
+	assert.Equal(t, 2, summary.Unencrypted)
+}
+
+func TestParseReport_SummaryOnly(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{SummaryOnlyAbove: 1})
+	report := NewReport([]string{"default/secret1", "default/secret2"}, nil, true, map[string]int{"kmsprovider1": 2})
+	report.UnrecognizedSecrets = []string{"default/secret3"}
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+
+	data, err := GetReport(context.Background(), clientset, "test-namespace", "")
+	require.NoError(t, err)
+	summary, err := ParseReport(data)
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.Encrypted)
+	assert.Equal(t, 1, summary.Unrecognized)
+	require.NotNil(t, summary.AllSecretsUseLatestProvider)
+	assert.True(t, *summary.AllSecretsUseLatestProvider)
+	assert.Empty(t, summary.LastRunStatus)
+	assert.True(t, summary.LastSuccessfulRun.IsZero())
+}
+
+func TestParseReport_YAML(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	recorder := NewRecorderOperator(clientset, Config{MaxListedSecrets: 1, Marshaller: utils.YAMLMarshaller{}})
+	report := NewReport([]string{"default/secret1"}, []string{"default/secret2"}, false, map[string]int{"kmsprovider1": 1, "identity": 1})
+	report.UnrecognizedSecrets = []string{"default/secret3", "team-a/secret4"}
+	report.Progress = &Progress{OnLatest: 1, Total: 2, Percent: 50}
+	require.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	require.NoError(t, recorder.RecordRunStatus(context.Background(), "test-namespace", nil, time.Now()))
+
+	data, err := GetReport(context.Background(), clientset, "test-namespace", "")
+	require.NoError(t, err)
+	assert.Equal(t, "identity: 1\nkmsprovider1: 1\n", data[providerCountsKey])
+	summary, err := ParseReport(data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"kmsprovider1": 1, "identity": 1}, summary.ProviderCounts)
+	assert.Equal(t, 2, summary.Unrecognized)
+	assert.Equal(t, &Progress{OnLatest: 1, Total: 2, Percent: 50}, summary.Progress)
+	assert.Len(t, summary.Conditions, 3)
+}
+
+func TestParseReport_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]string
+		err  string
+	}{
+		{name: "no provider counts", data: map[string]string{}, err: "report has no PROVIDER_COUNTS key"},
+		{name: "invalid latest provider", data: map[string]string{providerCountsKey: "{}", encryptedByLatestProviderKey: "yes"}, err: "invalid ENCRYPTED_BY_LATEST_SEQ"},
+		{name: "invalid last successful run", data: map[string]string{providerCountsKey: "{}", lastSuccessfulRunKey: "yesterday"}, err: "invalid LAST_SUCCESSFUL_RUN"},
+		{name: "invalid rollup", data: map[string]string{providerCountsKey: "{}", unrecognizedRollupKey: "[]"}, err: "failed to unmarshal unrecognized rollup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseReport(tt.data)
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestListReports(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "test-namespace"}})
+	for _, nodeName := range []string{"", "node1"} {
+		recorder := NewRecorderOperator(clientset, Config{NodeName: nodeName})
+		require.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1"}, nil, true, nil)))
+	}
+
+	reports, err := ListReports(context.Background(), clientset, "test-namespace")
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	nodes := map[string]string{}
+	for i := range reports {
+		nodes[reports[i].Name] = ReportNodeName(&reports[i])
+	}
+	assert.Equal(t, map[string]string{"kms-reporter": "", "kms-reporter-node1": "node1"}, nodes)
+}
+
+func TestNodeReportSelector(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	for _, config := range []Config{{}, {NodeName: "node1"}, {NodeName: "node2"}} {
+		recorder := NewRecorderOperator(clientset, config)
+		require.NoError(t, recorder.Record(context.Background(), "test-namespace", NewReport([]string{"default/secret1"}, nil, true, nil)))
+		report := NewReport([]string{"default/secret1"}, nil, true, nil)
+		report.Team = "a"
+		require.NoError(t, recorder.Record(context.Background(), "test-namespace", report))
+	}
+
+	list := func(selector string) []string {
+		configMaps, err := clientset.CoreV1().ConfigMaps("test-namespace").List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+		require.NoError(t, err)
+		var names []string
+		for _, configMap := range configMaps.Items {
+			names = append(names, configMap.Name)
+		}
+		return names
+	}
+	assert.ElementsMatch(t, []string{"kms-reporter", "kms-reporter-team-a"}, list(NodeReportSelector("")))
+	assert.ElementsMatch(t, []string{"kms-reporter-node1", "kms-reporter-node1-team-a"}, list(NodeReportSelector("node1")))
+	assert.Equal(t, []string{"kms-reporter-node1-team-a"}, list(TeamReportSelector("node1")))
+}
@@ -0,0 +1,243 @@
+// Package remediation rewrites the secrets that are not encrypted by the latest provider with Kubernetes
+// Jobs, one per namespace: every Job re-saves the secrets of its namespace through the API server, which
+// encrypts them with the latest provider. The Jobs run with a service account of their namespace, so
+// remediation needs no cluster-wide write access to secrets, and its progress is visible as Job status.
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+const (
+	// DefaultServiceAccountName is the service account remediation Jobs run with. It must exist in every
+	// namespace remediated and be allowed to list, get and update its secrets.
+	DefaultServiceAccountName = "kms-reporter-remediation"
+	// DefaultTTLAfterFinished is how long finished Jobs are kept, so that failures can be inspected.
+	DefaultTTLAfterFinished = 24 * time.Hour
+	// DefaultActiveDeadline is how long a Job may run before it is failed, e.g. when its image cannot
+	// be pulled, so that it does not hold a slot of Config.MaxActiveJobs forever.
+	DefaultActiveDeadline = time.Hour
+
+	// jobNamePrefix is the generated name prefix of remediation Jobs
+	jobNamePrefix = "kms-reporter-remediation-"
+	// Labels set on remediation Jobs and their pods
+	managedByLabel   = "app.kubernetes.io/managed-by"
+	reporterName     = "kms-reporter"
+	remediationLabel = "kms-reporter/remediation"
+	// backoffLimit is the number of retries of a failed rewrite, e.g. after a conflicting update
+	backoffLimit = 2
+)
+
+// DefaultCommand re-saves every secret of the namespace the Job runs in, so that the API server encrypts
+// it with the latest provider. Every secret is rewritten, not only those on a previous provider: etcd,
+// not the API, tells them apart. Secrets are replaced one by one, and a secret updated or deleted since
+// it was read is skipped, as it no longer needs a rewrite; any other failure fails the Job once every
+// secret was tried.
+var DefaultCommand = []string{"/bin/sh", "-c", `secrets=$(kubectl get secrets -o name) || exit 1
+failed=0
+for secret in $secrets; do
+  if ! output=$({ json=$(kubectl get "$secret" -o json) && printf '%s\n' "$json" | kubectl replace -f -; } 2>&1); then
+    case "$output" in
+    *"the object has been modified"* | *NotFound*) ;;
+    *) echo "$secret: $output" >&2; failed=1 ;;
+    esac
+  fi
+done
+exit $failed`}
+
+// Config configures the remediation Jobs.
+type Config struct {
+	// Image is the container image of the Jobs, which must provide the command. Required.
+	Image string
+	// Command rewrites the secrets of the namespace the Job runs in. Defaults to DefaultCommand.
+	Command []string
+	// ServiceAccountName is the service account of the Jobs. Defaults to DefaultServiceAccountName.
+	ServiceAccountName string
+	// MaxActiveJobs bounds the number of Jobs running at once, so that the API server is not flooded
+	// with writes. Further namespaces are remediated by later runs. 0 disables the limit.
+	MaxActiveJobs int
+	// TTLAfterFinished is how long finished Jobs are kept. Defaults to DefaultTTLAfterFinished.
+	TTLAfterFinished time.Duration
+	// ActiveDeadline is how long a Job may run before it is failed. A Job that has created no pod after
+	// it, e.g. because its service account is missing, is counted as failed. Defaults to DefaultActiveDeadline.
+	ActiveDeadline time.Duration
+	// RequestTimeout bounds each Kubernetes API call. 0 disables the limit.
+	RequestTimeout time.Duration
+}
+
+// Summary counts the remediation Jobs by outcome.
+type Summary struct {
+	// Active, Succeeded and Failed count the existing Jobs, including those created by the run.
+	Active    int `json:"active"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// Created is the number of Jobs created by the run.
+	Created int `json:"created"`
+	// Pending is the number of namespaces left for later runs because of Config.MaxActiveJobs, or because
+	// their Job could not be created.
+	Pending int `json:"pending"`
+	// FailedNamespaces are the namespaces whose Job failed. They are not remediated again until the Job is
+	// deleted, e.g. once its TTL expires.
+	FailedNamespaces []string `json:"failedNamespaces,omitempty"`
+}
+
+// Remediator creates and tracks the remediation Jobs.
+type Remediator struct {
+	clientset kubernetes.Interface
+	config    Config
+}
+
+func NewRemediator(clientset kubernetes.Interface, config Config) (*Remediator, error) {
+	if config.Image == "" {
+		return nil, fmt.Errorf("remediation requires a Job image")
+	}
+	if len(config.Command) == 0 {
+		config.Command = DefaultCommand
+	}
+	if config.ServiceAccountName == "" {
+		config.ServiceAccountName = DefaultServiceAccountName
+	}
+	if config.TTLAfterFinished == 0 {
+		config.TTLAfterFinished = DefaultTTLAfterFinished
+	}
+	if config.ActiveDeadline == 0 {
+		config.ActiveDeadline = DefaultActiveDeadline
+	}
+	return &Remediator{clientset: clientset, config: config}, nil
+}
+
+// RequiredPermissions lists the Kubernetes API access the remediator needs.
+func RequiredPermissions() []rbac.Permission {
+	return []rbac.Permission{
+		{Verb: "list", Group: "batch", Resource: "jobs"},
+		{Verb: "create", Group: "batch", Resource: "jobs"},
+	}
+}
+
+// Reconcile creates a Job in every namespace of stale, which maps namespaces to their number of secrets
+// not encrypted by the latest provider, unless the namespace has a running or failed Job already, and
+// returns the summary of the Jobs. Jobs that cannot be created are logged and left for the next run.
+// A Job that has created no pod within the active deadline is counted as failed.
+func (r *Remediator) Reconcile(ctx context.Context, stale map[string]int) (Summary, error) {
+	listCtx, cancel := utils.ContextWithTimeout(ctx, r.config.RequestTimeout)
+	defer cancel()
+	jobs, err := r.clientset.BatchV1().Jobs(metav1.NamespaceAll).List(listCtx, metav1.ListOptions{LabelSelector: remediationLabel + "=true"})
+	if err != nil {
+		return Summary{}, fmt.Errorf("failed to list remediation Jobs: %w", err)
+	}
+
+	var summary Summary
+	// Namespaces with a running or failed Job get no new one
+	busy := map[string]bool{}
+	for _, job := range jobs.Items {
+		switch {
+		case jobCondition(job, batchv1.JobComplete):
+			summary.Succeeded++
+		case jobCondition(job, batchv1.JobFailed) || r.stuck(job):
+			summary.Failed++
+			summary.FailedNamespaces = append(summary.FailedNamespaces, job.Namespace)
+			busy[job.Namespace] = true
+		default:
+			summary.Active++
+			busy[job.Namespace] = true
+		}
+	}
+	sort.Strings(summary.FailedNamespaces)
+
+	namespaces := make([]string, 0, len(stale))
+	for namespace, count := range stale {
+		if count > 0 && !busy[namespace] {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		if r.config.MaxActiveJobs > 0 && summary.Active >= r.config.MaxActiveJobs {
+			summary.Pending++
+			continue
+		}
+		if err := r.createJob(ctx, namespace); err != nil {
+			klog.ErrorS(err, "Failed to create remediation Job", "namespace", namespace)
+			summary.Pending++
+			continue
+		}
+		klog.InfoS("Created remediation Job", "namespace", namespace, "staleSecrets", stale[namespace])
+		summary.Created++
+		summary.Active++
+	}
+	return summary, nil
+}
+
+// stuck reports whether job has created no pod within the active deadline, e.g. because its service
+// account is missing. The Job controller fails it once the deadline is exceeded, but counting it as
+// failed right away does not depend on it.
+func (r *Remediator) stuck(job batchv1.Job) bool {
+	noPods := job.Status.Active == 0 && job.Status.Succeeded == 0 && job.Status.Failed == 0
+	return noPods && !job.CreationTimestamp.IsZero() && time.Since(job.CreationTimestamp.Time) > r.config.ActiveDeadline
+}
+
+// createJob creates the Job rewriting the secrets of namespace.
+func (r *Remediator) createJob(ctx context.Context, namespace string) error {
+	labels := map[string]string{managedByLabel: reporterName, remediationLabel: "true"}
+	ttl := int32(r.config.TTLAfterFinished.Seconds())
+	deadline := int64(r.config.ActiveDeadline.Seconds())
+	backoff := int32(backoffLimit)
+	allowPrivilegeEscalation := false
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: jobNamePrefix,
+			Namespace:    namespace,
+			Labels:       labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoff,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					ServiceAccountName: r.config.ServiceAccountName,
+					RestartPolicy:      v1.RestartPolicyNever,
+					Containers: []v1.Container{{
+						Name:    "rewrite",
+						Image:   r.config.Image,
+						Command: r.config.Command,
+						SecurityContext: &v1.SecurityContext{
+							AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+							Capabilities:             &v1.Capabilities{Drop: []v1.Capability{"ALL"}},
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	createCtx, cancel := utils.ContextWithTimeout(ctx, r.config.RequestTimeout)
+	defer cancel()
+	if _, err := r.clientset.BatchV1().Jobs(namespace).Create(createCtx, job, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create remediation Job in namespace %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// jobCondition reports whether the condition of job is true.
+func jobCondition(job batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType && condition.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
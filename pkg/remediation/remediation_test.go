@@ -0,0 +1,131 @@
+package remediation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// remediationJob returns a remediation Job of namespace with the given condition, or running if conditionType is empty.
+func remediationJob(namespace, name string, conditionType batchv1.JobConditionType) *batchv1.Job {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: namespace,
+		Labels:    map[string]string{remediationLabel: "true"},
+	}}
+	if conditionType != "" {
+		job.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: v1.ConditionTrue}}
+	}
+	return job
+}
+
+func jobsIn(t *testing.T, clientset *fake.Clientset, namespace string) []batchv1.Job {
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	return jobs.Items
+}
+
+func TestNewRemediator(t *testing.T) {
+	_, err := NewRemediator(fake.NewSimpleClientset(), Config{})
+	assert.ErrorContains(t, err, "requires a Job image")
+
+	remediator, err := NewRemediator(fake.NewSimpleClientset(), Config{Image: "bitnami/kubectl:1.33"})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultCommand, remediator.config.Command)
+	assert.Equal(t, DefaultServiceAccountName, remediator.config.ServiceAccountName)
+	assert.Equal(t, DefaultTTLAfterFinished, remediator.config.TTLAfterFinished)
+	assert.Equal(t, DefaultActiveDeadline, remediator.config.ActiveDeadline)
+}
+
+func TestRemediator_Reconcile(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		remediationJob("running", "job-1", ""),
+		remediationJob("failed", "job-2", batchv1.JobFailed),
+		remediationJob("done", "job-3", batchv1.JobComplete),
+		// Jobs of other tools are ignored
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default"}},
+	)
+	remediator, err := NewRemediator(clientset, Config{Image: "bitnami/kubectl:1.33", MaxActiveJobs: 3})
+	require.NoError(t, err)
+
+	summary, err := remediator.Reconcile(context.Background(), map[string]int{
+		"running": 2, "failed": 1, "done": 4, "team-a": 1, "team-b": 3, "clean": 0,
+	})
+	require.NoError(t, err)
+	// done and team-a get a Job, team-b waits for a free slot
+	assert.Equal(t, Summary{Active: 3, Succeeded: 1, Failed: 1, Created: 2, Pending: 1, FailedNamespaces: []string{"failed"}}, summary)
+	assert.Len(t, jobsIn(t, clientset, "running"), 1)
+	assert.Len(t, jobsIn(t, clientset, "failed"), 1)
+	assert.Len(t, jobsIn(t, clientset, "done"), 2)
+	assert.Empty(t, jobsIn(t, clientset, "team-b"))
+	assert.Empty(t, jobsIn(t, clientset, "clean"))
+
+	jobs := jobsIn(t, clientset, "team-a")
+	require.Len(t, jobs, 1)
+	job := jobs[0]
+	assert.Equal(t, jobNamePrefix, job.GenerateName)
+	assert.Equal(t, "true", job.Labels[remediationLabel])
+	assert.Equal(t, int32(DefaultTTLAfterFinished.Seconds()), *job.Spec.TTLSecondsAfterFinished)
+	assert.Equal(t, int64(DefaultActiveDeadline.Seconds()), *job.Spec.ActiveDeadlineSeconds)
+	pod := job.Spec.Template.Spec
+	assert.Equal(t, DefaultServiceAccountName, pod.ServiceAccountName)
+	assert.Equal(t, v1.RestartPolicyNever, pod.RestartPolicy)
+	require.Len(t, pod.Containers, 1)
+	assert.Equal(t, "bitnami/kubectl:1.33", pod.Containers[0].Image)
+	assert.Equal(t, DefaultCommand, pod.Containers[0].Command)
+}
+
+func TestRemediator_Reconcile_Stuck(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-2 * DefaultActiveDeadline))
+	// No pod was ever created, e.g. the service account is missing
+	stuck := remediationJob("no-account", "job-1", "")
+	stuck.CreationTimestamp = created
+	// Pods were created, the Job controller fails it once the deadline is exceeded
+	pulling := remediationJob("pulling", "job-2", "")
+	pulling.CreationTimestamp = created
+	pulling.Status.Active = 1
+	recent := remediationJob("recent", "job-3", "")
+	recent.CreationTimestamp = metav1.Now()
+	clientset := fake.NewSimpleClientset(stuck, pulling, recent)
+	remediator, err := NewRemediator(clientset, Config{Image: "bitnami/kubectl:1.33", MaxActiveJobs: 3})
+	require.NoError(t, err)
+
+	// The stuck Job no longer holds a slot
+	summary, err := remediator.Reconcile(context.Background(), map[string]int{"no-account": 1, "team-a": 1})
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Active: 3, Failed: 1, Created: 1, FailedNamespaces: []string{"no-account"}}, summary)
+	assert.Len(t, jobsIn(t, clientset, "no-account"), 1)
+}
+
+func TestRemediator_Reconcile_Errors(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "jobs", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "forbidden" {
+			return true, nil, errors.New("jobs is forbidden")
+		}
+		return false, nil, nil
+	})
+	remediator, err := NewRemediator(clientset, Config{Image: "bitnami/kubectl:1.33"})
+	require.NoError(t, err)
+
+	// A namespace failing does not stop the others
+	summary, err := remediator.Reconcile(context.Background(), map[string]int{"forbidden": 1, "team-a": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, Summary{Active: 1, Created: 1, Pending: 1}, summary)
+
+	clientset.PrependReactor("list", "jobs", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	_, err = remediator.Reconcile(context.Background(), map[string]int{"team-a": 1})
+	assert.ErrorContains(t, err, "failed to list remediation Jobs")
+}
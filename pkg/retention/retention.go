@@ -0,0 +1,153 @@
+// Package retention deletes the ConfigMaps the reporter no longer writes, e.g. the report of a removed scan
+// prefix or team, or the partial result of a shard once sharding is disabled, so that consumers do not read
+// them as current. They are kept for a retention period after they were found to be unused, in case the
+// configuration change is reverted.
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+	"github.com/lzhecheng/kms-reporter/pkg/shard"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// Config configures the collector.
+type Config struct {
+	// Namespace is the namespace of the report. Its reports written on NodeName and the partial results
+	// of the shards are collected.
+	Namespace string
+	// NodeName is the node the reports are written on, as in recorder.Config. Only its reports are
+	// collected, as the reporters of the other nodes write theirs. Optional.
+	NodeName string
+	// Keep lists the names of the ConfigMaps still written, by namespace. In the namespaces other than
+	// Namespace, e.g. the report namespaces of teams, only the team reports written on NodeName are
+	// collected.
+	Keep map[string][]string
+	// Retention is how long an unused ConfigMap is kept after it was first found to be unused.
+	Retention time.Duration
+	// RequestTimeout bounds each Kubernetes API call. 0 disables the limit.
+	RequestTimeout time.Duration
+}
+
+// Collector deletes the ConfigMaps the reporter no longer writes. It marks each one with
+// recorder.UnusedSinceAnnotation the first time it finds it unused, and deletes it once Config.Retention
+// has passed. Writing the ConfigMap again removes the mark.
+type Collector struct {
+	clientset kubernetes.Interface
+	config    Config
+	now       func() time.Time
+}
+
+func NewCollector(clientset kubernetes.Interface, config Config) *Collector {
+	return &Collector{clientset: clientset, config: config, now: time.Now}
+}
+
+// RequiredPermissions lists the Kubernetes API access the collector needs. The ConfigMaps are selected by
+// label, which RBAC cannot express.
+func RequiredPermissions(config Config) []rbac.Permission {
+	namespaces := []string{config.Namespace}
+	for namespace := range config.Keep {
+		if !slices.Contains(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	slices.Sort(namespaces)
+	var permissions []rbac.Permission
+	for _, namespace := range namespaces {
+		for _, verb := range []string{"list", "patch", "delete"} {
+			permissions = append(permissions, rbac.Permission{Verb: verb, Resource: "configmaps", Namespace: namespace})
+		}
+	}
+	return permissions
+}
+
+// Collect marks the unused ConfigMaps and deletes those unused for longer than the retention. A failure
+// does not prevent the other ConfigMaps from being collected; the errors of all failures are joined.
+func (c *Collector) Collect(ctx context.Context) error {
+	var errs []error
+	selectors := map[string][]string{c.config.Namespace: {recorder.NodeReportSelector(c.config.NodeName), shard.PartialSelector}}
+	for namespace := range c.config.Keep {
+		if namespace != c.config.Namespace {
+			selectors[namespace] = []string{recorder.TeamReportSelector(c.config.NodeName)}
+		}
+	}
+	for namespace, namespaceSelectors := range selectors {
+		for _, selector := range namespaceSelectors {
+			errs = append(errs, c.collect(ctx, namespace, selector))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// collect marks or deletes the unused ConfigMaps of namespace selected by selector.
+func (c *Collector) collect(ctx context.Context, namespace, selector string) error {
+	listCtx, cancel := utils.ContextWithTimeout(ctx, c.config.RequestTimeout)
+	defer cancel()
+	configMaps, err := c.clientset.CoreV1().ConfigMaps(namespace).List(listCtx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("failed to list the ConfigMaps of namespace %s: %w", namespace, err)
+	}
+	var errs []error
+	for i := range configMaps.Items {
+		configMap := &configMaps.Items[i]
+		if slices.Contains(c.config.Keep[namespace], configMap.Name) {
+			continue
+		}
+		errs = append(errs, c.collectConfigMap(ctx, configMap))
+	}
+	return errors.Join(errs...)
+}
+
+// collectConfigMap marks configMap as unused, or deletes it if it was marked more than the retention ago.
+// A mark that cannot be read is replaced.
+func (c *Collector) collectConfigMap(ctx context.Context, configMap *v1.ConfigMap) error {
+	now := c.now()
+	unusedSince, err := time.Parse(time.RFC3339, configMap.Annotations[recorder.UnusedSinceAnnotation])
+	if err != nil {
+		patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]string{
+			recorder.UnusedSinceAnnotation: now.UTC().Format(time.RFC3339),
+		}}})
+		if err != nil {
+			return err
+		}
+		patchCtx, cancel := utils.ContextWithTimeout(ctx, c.config.RequestTimeout)
+		defer cancel()
+		if _, err := c.clientset.CoreV1().ConfigMaps(configMap.Namespace).Patch(patchCtx, configMap.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to mark ConfigMap %s as unused: %w", configMap.Name, err)
+		}
+		klog.InfoS("ConfigMap no longer written, deleting it after the retention", "configMap", klog.KObj(configMap), "retention", c.config.Retention)
+		return nil
+	}
+	if now.Sub(unusedSince) < c.config.Retention {
+		return nil
+	}
+
+	deleteCtx, cancel := utils.ContextWithTimeout(ctx, c.config.RequestTimeout)
+	defer cancel()
+	// The precondition keeps a ConfigMap written again since it was listed
+	err = c.clientset.CoreV1().ConfigMaps(configMap.Namespace).Delete(deleteCtx, configMap.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: &configMap.ResourceVersion},
+	})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete ConfigMap %s: %w", configMap.Name, err)
+	}
+	klog.InfoS("Deleted a ConfigMap no longer written", "configMap", klog.KObj(configMap), "unusedSince", unusedSince)
+	return nil
+}
@@ -0,0 +1,150 @@
+package retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+)
+
+func report(namespace, name, nodeName, team string) *v1.ConfigMap {
+	labels := map[string]string{"app.kubernetes.io/managed-by": "kms-reporter", "app.kubernetes.io/name": "kms-reporter"}
+	if nodeName != "" {
+		labels["kms-reporter/node-name"] = nodeName
+	}
+	if team != "" {
+		labels["kms-reporter/team"] = team
+	}
+	return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}}
+}
+
+func partial(name string) *v1.ConfigMap {
+	return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kms", Labels: map[string]string{
+		"app.kubernetes.io/managed-by": "kms-reporter",
+		"kms-reporter/shard":           name[len(name)-1:],
+	}}}
+}
+
+func names(t *testing.T, clientset *fake.Clientset, namespace string) []string {
+	configMaps, err := clientset.CoreV1().ConfigMaps(namespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	var names []string
+	for _, configMap := range configMaps.Items {
+		names = append(names, configMap.Name)
+	}
+	return names
+}
+
+func TestCollector_Collect(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		report("kms", "kms-reporter-node1", "node1", ""),
+		report("kms", "kms-reporter-node1-configmaps", "node1", ""),
+		report("kms", "kms-reporter-node1-team-a", "node1", "a"),
+		report("kms", "kms-reporter-node2-configmaps", "node2", ""),
+		report("kms", "kms-reporter-configmaps", "", ""),
+		report("team-b", "kms-reporter-node1-team-b", "node1", "b"),
+		report("team-b", "kms-reporter-node1-team-c", "node1", "c"),
+		partial("kms-reporter-shard-0"),
+		partial("kms-reporter-shard-1"),
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kms-reporter-history", Namespace: "kms"}},
+	)
+	collector := NewCollector(clientset, Config{
+		Namespace: "kms",
+		NodeName:  "node1",
+		Keep: map[string][]string{
+			"kms":    {"kms-reporter-node1", "kms-reporter-node1-team-a", "kms-reporter-shard-0"},
+			"team-b": {"kms-reporter-node1-team-b"},
+		},
+		Retention: time.Hour,
+	})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// The unused ConfigMaps are first marked
+	require.NoError(t, collector.Collect(ctx))
+	for namespace, name := range map[string]string{"kms": "kms-reporter-node1-configmaps", "team-b": "kms-reporter-node1-team-c"} {
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "2024-05-01T12:00:00Z", configMap.Annotations[recorder.UnusedSinceAnnotation])
+	}
+	configMap, err := clientset.CoreV1().ConfigMaps("kms").Get(ctx, "kms-reporter-shard-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, configMap.Annotations, recorder.UnusedSinceAnnotation)
+	for _, name := range []string{"kms-reporter-node1", "kms-reporter-node2-configmaps", "kms-reporter-configmaps", "kms-reporter-history"} {
+		configMap, err := clientset.CoreV1().ConfigMaps("kms").Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.NotContains(t, configMap.Annotations, recorder.UnusedSinceAnnotation, name)
+	}
+
+	// and kept during the retention
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, collector.Collect(ctx))
+	assert.Len(t, names(t, clientset, "kms"), 8)
+
+	// then deleted. Those of other nodes, the cluster-wide reports and the other ConfigMaps are kept.
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, collector.Collect(ctx))
+	assert.ElementsMatch(t, []string{
+		"kms-reporter-node1",
+		"kms-reporter-node1-team-a",
+		"kms-reporter-node2-configmaps",
+		"kms-reporter-configmaps",
+		"kms-reporter-shard-0",
+		"kms-reporter-history",
+	}, names(t, clientset, "kms"))
+	assert.Equal(t, []string{"kms-reporter-node1-team-b"}, names(t, clientset, "team-b"))
+}
+
+func TestCollector_Collect_ClusterWide(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		report("kms", "kms-reporter", "", ""),
+		report("kms", "kms-reporter-configmaps", "", ""),
+		report("kms", "kms-reporter-node1", "node1", ""),
+	)
+	collector := NewCollector(clientset, Config{Namespace: "kms", Keep: map[string][]string{"kms": {"kms-reporter"}}})
+	ctx := context.Background()
+
+	// A retention of 0 deletes on the run after the mark
+	require.NoError(t, collector.Collect(ctx))
+	assert.Len(t, names(t, clientset, "kms"), 3)
+	require.NoError(t, collector.Collect(ctx))
+	assert.ElementsMatch(t, []string{"kms-reporter", "kms-reporter-node1"}, names(t, clientset, "kms"))
+}
+
+func TestCollector_Collect_InvalidMark(t *testing.T) {
+	configMap := report("kms", "kms-reporter-configmaps", "", "")
+	configMap.Annotations = map[string]string{recorder.UnusedSinceAnnotation: "yesterday"}
+	clientset := fake.NewSimpleClientset(configMap)
+	collector := NewCollector(clientset, Config{Namespace: "kms", Retention: time.Hour})
+	ctx := context.Background()
+
+	// An invalid mark is replaced rather than deleting the ConfigMap
+	require.NoError(t, collector.Collect(ctx))
+	configMap, err := clientset.CoreV1().ConfigMaps("kms").Get(ctx, "kms-reporter-configmaps", metav1.GetOptions{})
+	require.NoError(t, err)
+	_, err = time.Parse(time.RFC3339, configMap.Annotations[recorder.UnusedSinceAnnotation])
+	assert.NoError(t, err)
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	permissions := RequiredPermissions(Config{Namespace: "kms", Keep: map[string][]string{
+		"team-b": {"kms-reporter-team-b"},
+		"kms":    {"kms-reporter"},
+	}})
+	assert.Len(t, permissions, 6)
+	assert.Equal(t, "kms", permissions[0].Namespace)
+	assert.Equal(t, "list", permissions[0].Verb)
+	assert.Equal(t, "delete", permissions[2].Verb)
+	assert.Equal(t, "team-b", permissions[3].Namespace)
+	for _, permission := range permissions {
+		assert.Empty(t, permission.Name)
+	}
+}
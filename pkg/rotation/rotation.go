@@ -0,0 +1,80 @@
+// Package rotation waits for a KMS provider rotation to complete, by scanning etcd until every secret
+// is encrypted by the target provider. It backs the wait subcommand used by rotation automation.
+package rotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+// DefaultPollInterval is the interval between two scans when Config.PollInterval is unset.
+const DefaultPollInterval = time.Minute
+
+// ErrTimeout is returned when the rotation did not complete within Config.Timeout.
+var ErrTimeout = errors.New("timed out waiting for the rotation to complete")
+
+// Config configures Wait.
+type Config struct {
+	// Analyzer configures every scan. Its LatestProvider resolves the target provider, and its
+	// TargetKeyID optionally requires a KMS v2 key ID as well.
+	Analyzer analyzer.Config
+	// PollInterval is the interval between the start of two scans. Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+	// Timeout bounds the whole wait. 0 waits until ctx is done.
+	Timeout time.Duration
+}
+
+// Wait scans source every config.PollInterval until every secret is encrypted by the target provider,
+// and returns the result of the last scan. A failed scan is logged and retried at the next poll. Wait
+// returns ErrTimeout, wrapping the error of the last scan if it failed, once config.Timeout has passed.
+// A scan cut short by the timeout does not replace the previous one.
+func Wait(ctx context.Context, source analyzer.Source, config Config) (analyzer.Result, error) {
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	a := analyzer.New()
+	ticker := time.NewTicker(config.PollInterval)
+	defer ticker.Stop()
+	var result analyzer.Result
+	var scanErr error
+	for scans := 0; ; scans++ {
+		scanned, err := a.Analyze(ctx, source, config.Analyzer)
+		switch {
+		case err != nil && ctx.Err() != nil && scans > 0:
+			// Cut short by the timeout or cancellation, so the previous scan is returned
+		case err != nil:
+			result, scanErr = scanned, err
+			klog.ErrorS(scanErr, "Failed to scan secrets, retrying at the next poll")
+		case scanned.FullyEncryptedByLatest():
+			return scanned, nil
+		default:
+			result, scanErr = scanned, nil
+			klog.InfoS("Waiting for the rotation to complete", "secrets", result.Total(), "unencrypted", result.UnencryptedCount(),
+				"unrecognized", result.UnrecognizedCount(), "providerCounts", result.ProviderCounts)
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				if scanErr != nil {
+					return result, fmt.Errorf("%w after %s: %w", ErrTimeout, config.Timeout, scanErr)
+				}
+				return result, fmt.Errorf("%w after %s", ErrTimeout, config.Timeout)
+			}
+			return result, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package rotation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// scanSource serves one request, i.e. one unpaginated scan, from each of its sources in turn, then the last one
+type scanSource struct {
+	mu      sync.Mutex
+	sources []analyzer.Source
+	scans   int
+}
+
+func (s *scanSource) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	s.mu.Lock()
+	source := s.sources[min(s.scans, len(s.sources)-1)]
+	s.scans++
+	s.mu.Unlock()
+	return source.Get(ctx, key, opts...)
+}
+
+// failingSource fails every request
+type failingSource struct{}
+
+func (failingSource) Get(context.Context, string, ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	return nil, errors.New("connection refused")
+}
+
+// blockingSource blocks every request until its context is done
+type blockingSource struct{}
+
+func (blockingSource) Get(ctx context.Context, _ string, _ ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func secrets(values ...string) *etcd.MemoryClient {
+	var kvs []*mvccpb.KeyValue
+	for i, value := range values {
+		kvs = append(kvs, &mvccpb.KeyValue{Key: []byte("/registry/secrets/default/" + string(rune('a'+i))), Value: []byte(value)})
+	}
+	return etcd.NewMemoryClient(kvs)
+}
+
+func config(t *testing.T) Config {
+	matcher, err := utils.NewProviderNameMatcher("kmsprovider", "")
+	require.NoError(t, err)
+	return Config{
+		Analyzer: analyzer.Config{
+			ProviderMatcher: matcher,
+			LatestProvider:  analyzer.StaticProvider(analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2}),
+		},
+		PollInterval: time.Millisecond,
+		Timeout:      time.Second,
+	}
+}
+
+func TestWait(t *testing.T) {
+	// The last secret is re-encrypted by the target provider at the third scan
+	source := &scanSource{sources: []analyzer.Source{
+		secrets("k8s:enc:kms:v2:kmsprovider2:data", "k8s\x00plaintext"),
+		secrets("k8s:enc:kms:v2:kmsprovider2:data", "k8s:enc:kms:v2:kmsprovider1:data"),
+		secrets("k8s:enc:kms:v2:kmsprovider2:data", "k8s:enc:kms:v2:kmsprovider2:data"),
+	}}
+	result, err := Wait(context.Background(), source, config(t))
+	assert.NoError(t, err)
+	assert.True(t, result.FullyEncryptedByLatest())
+	assert.Equal(t, 3, source.scans)
+}
+
+func TestWait_Timeout(t *testing.T) {
+	config := config(t)
+	config.Timeout = 20 * time.Millisecond
+
+	result, err := Wait(context.Background(), secrets("k8s:enc:kms:v2:kmsprovider1:data"), config)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Equal(t, map[string]int{"kmsprovider1": 1}, result.ProviderCounts)
+
+	// The error of the last scan explains why the rotation never completed
+	_, err = Wait(context.Background(), failingSource{}, config)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.ErrorContains(t, err, "connection refused")
+
+	// A scan cut short by the timeout does not replace the previous one
+	source := &scanSource{sources: []analyzer.Source{secrets("k8s:enc:kms:v2:kmsprovider1:data"), blockingSource{}}}
+	result, err = Wait(context.Background(), source, config)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.NotContains(t, err.Error(), "context deadline exceeded")
+	assert.Equal(t, map[string]int{"kmsprovider1": 1}, result.ProviderCounts)
+}
+
+func TestWait_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Wait(ctx, secrets("k8s\x00plaintext"), config(t))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrTimeout)
+}
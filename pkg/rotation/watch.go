@@ -0,0 +1,179 @@
+package rotation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+// Status is the progress of a rotation at one scan.
+type Status struct {
+	// Time is when the scan finished.
+	Time time.Time
+	// Result is the result of the scan.
+	Result analyzer.Result
+	// OnTarget is the number of secrets encrypted by the target provider.
+	OnTarget int
+	// Delta is the change of Percent since the previous result compared against the same provider, in
+	// percentage points, or 0 for the first one.
+	Delta float64
+	// Rate is the number of secrets moved to the target provider per second since the first scan,
+	// or 0 before the second scan.
+	Rate float64
+	// ETA is the estimated time until every secret is encrypted by the target provider, or 0 if it
+	// cannot be estimated yet.
+	ETA time.Duration
+}
+
+// Percent returns the percentage of secrets encrypted by the target provider.
+func (s Status) Percent() float64 {
+	return percent(s.OnTarget, s.Result.Total())
+}
+
+// Complete reports whether every secret is encrypted by the target provider.
+func (s Status) Complete() bool {
+	return s.Result.FullyEncryptedByLatest()
+}
+
+// Render writes the status as a summary followed by a table of the secrets per provider.
+func (s Status) Render(w io.Writer) error {
+	target := s.Result.LatestProvider.Name
+	if target == "" {
+		target = fmt.Sprintf("sequence %d", s.Result.LatestProvider.Seq)
+	}
+	eta := "unknown"
+	switch {
+	case s.Complete():
+		eta = "complete"
+	case s.ETA > 0:
+		eta = s.ETA.Round(time.Second).String()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Time:\t%s\n", s.Time.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Target:\t%s\n", target)
+	fmt.Fprintf(tw, "Progress:\t%d/%d secrets (%.2f%%), %.1f secrets/s, ETA %s\n", s.OnTarget, s.Result.Total(), s.Percent(), s.Rate, eta)
+	if s.Result.UnrecognizedCount() > 0 {
+		fmt.Fprintf(tw, "Unrecognized:\t%d\n", s.Result.UnrecognizedCount())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	providers := make([]string, 0, len(s.Result.ProviderCounts))
+	for provider := range s.Result.ProviderCounts {
+		providers = append(providers, provider)
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		ci, cj := s.Result.ProviderCounts[providers[i]], s.Result.ProviderCounts[providers[j]]
+		return ci > cj || ci == cj && providers[i] < providers[j]
+	})
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "PROVIDER\tSECRETS\tPERCENT")
+	for _, provider := range providers {
+		count := s.Result.ProviderCounts[provider]
+		fmt.Fprintf(tw, "%s\t%d\t%.2f%%\n", provider, count, percent(count, s.Result.Total()))
+	}
+	return tw.Flush()
+}
+
+// Tracker estimates the progress of a rotation from the results of successive scans.
+type Tracker struct {
+	config   analyzer.Config
+	first    *Status
+	previous *Status
+}
+
+// NewTracker returns a tracker comparing providers as config does.
+func NewTracker(config analyzer.Config) *Tracker {
+	return &Tracker{config: config}
+}
+
+// Observe returns the status of result, read at now. The rate is measured since the first result
+// compared against the same provider, so a new rotation starts a new measurement.
+func (t *Tracker) Observe(now time.Time, result analyzer.Result) Status {
+	status := Status{Time: now, Result: result, OnTarget: t.onTarget(result)}
+	if t.first == nil || t.first.Result.LatestProvider != result.LatestProvider {
+		first := status
+		t.first, t.previous = &first, &first
+		return status
+	}
+
+	status.Delta = status.Percent() - t.previous.Percent()
+	if elapsed := now.Sub(t.first.Time).Seconds(); elapsed > 0 {
+		status.Rate = max(float64(status.OnTarget-t.first.OnTarget)/elapsed, 0)
+	}
+	if remaining := result.Total() - status.OnTarget; remaining > 0 && status.Rate > 0 {
+		status.ETA = time.Duration(float64(remaining) / status.Rate * float64(time.Second))
+	}
+	previous := status
+	t.previous = &previous
+	return status
+}
+
+// onTarget returns the number of secrets of result encrypted by the provider it was compared against.
+func (t *Tracker) onTarget(result analyzer.Result) int {
+	target := result.LatestProvider
+	onTarget := 0
+	for provider, count := range result.ProviderCounts {
+		if provider == analyzer.IdentityProviderName {
+			continue
+		}
+		if t.config.Comparison == analyzer.ComparisonName || t.config.ProviderMatcher == nil {
+			if provider == target.Name {
+				onTarget += count
+			}
+		} else if seq, err := t.config.ProviderMatcher.Seq(provider); err == nil && seq == target.Seq {
+			onTarget += count
+		}
+	}
+	return onTarget
+}
+
+// Watch scans source every config.PollInterval until ctx is done or config.Timeout has passed, and
+// calls observe with the status of every scan, or the error of a failed one.
+func Watch(ctx context.Context, source analyzer.Source, config Config, observe func(Status, error)) {
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	a := analyzer.New()
+	tracker := NewTracker(config.Analyzer)
+	ticker := time.NewTicker(config.PollInterval)
+	defer ticker.Stop()
+	for {
+		result, err := a.Analyze(ctx, source, config.Analyzer)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			observe(Status{}, err)
+		} else {
+			observe(tracker.Observe(time.Now(), result), nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// percent returns count as a percentage of total, or 0 if total is 0.
+func percent(count, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) / float64(total) * 100
+}
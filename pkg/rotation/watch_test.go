@@ -0,0 +1,111 @@
+package rotation
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+func TestTracker_Observe(t *testing.T) {
+	tracker := NewTracker(config(t).Analyzer)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	result := func(onTarget, previous int) analyzer.Result {
+		return analyzer.Result{
+			EncryptedSecrets: make([]string, onTarget+previous),
+			LatestProvider:   analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2},
+			ProviderCounts:   map[string]int{"kmsprovider1": previous, "kmsprovider2": onTarget},
+		}
+	}
+
+	// The first scan has no rate yet
+	status := tracker.Observe(now, result(100, 900))
+	assert.Equal(t, 100, status.OnTarget)
+	assert.Equal(t, float64(10), status.Percent())
+	assert.Zero(t, status.ETA)
+
+	// 200 secrets moved in 10s: the remaining 700 take 35s
+	status = tracker.Observe(now.Add(10*time.Second), result(300, 700))
+	assert.Equal(t, float64(20), status.Rate)
+	assert.Equal(t, 35*time.Second, status.ETA)
+	assert.Equal(t, float64(20), status.Delta)
+
+	// Secrets moving away from the target do not make the rate negative
+	status = tracker.Observe(now.Add(20*time.Second), result(50, 950))
+	assert.Zero(t, status.Rate)
+	assert.Zero(t, status.ETA)
+	assert.Equal(t, float64(-25), status.Delta)
+
+	// A new target provider starts a new measurement
+	next := result(0, 1000)
+	next.LatestProvider = analyzer.LatestProvider{Name: "kmsprovider3", Seq: 3}
+	status = tracker.Observe(now.Add(30*time.Second), next)
+	assert.Zero(t, status.Rate)
+	assert.Zero(t, status.Delta)
+	next.ProviderCounts["kmsprovider3"] = 100
+	status = tracker.Observe(now.Add(40*time.Second), next)
+	assert.Equal(t, float64(10), status.Rate)
+}
+
+func TestStatus_Render(t *testing.T) {
+	status := Status{
+		Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Result: analyzer.Result{
+			EncryptedSecrets:    make([]string, 3),
+			UnencryptedSecrets:  make([]string, 1),
+			UnrecognizedSecrets: []string{"default/garbage"},
+			LatestProvider:      analyzer.LatestProvider{Seq: 2},
+			ProviderCounts:      map[string]int{"kmsprovider1": 1, "kmsprovider2": 2, "identity": 1},
+		},
+		OnTarget: 2,
+		Rate:     0.5,
+		ETA:      6 * time.Second,
+	}
+	var out strings.Builder
+	require.NoError(t, status.Render(&out))
+	assert.Equal(t, `Time:          2025-01-01T00:00:00Z
+Target:        sequence 2
+Progress:      2/5 secrets (40.00%), 0.5 secrets/s, ETA 6s
+Unrecognized:  1
+
+PROVIDER      SECRETS  PERCENT
+kmsprovider2  2        40.00%
+identity      1        20.00%
+kmsprovider1  1        20.00%
+`, out.String())
+}
+
+func TestWatch(t *testing.T) {
+	source := &scanSource{sources: []analyzer.Source{
+		secrets("k8s:enc:kms:v2:kmsprovider1:data", "k8s:enc:kms:v2:kmsprovider1:data"),
+		secrets("k8s:enc:kms:v2:kmsprovider2:data", "k8s:enc:kms:v2:kmsprovider1:data"),
+		secrets("k8s:enc:kms:v2:kmsprovider2:data", "k8s:enc:kms:v2:kmsprovider2:data"),
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var statuses []Status
+	Watch(ctx, source, config(t), func(status Status, err error) {
+		assert.NoError(t, err)
+		statuses = append(statuses, status)
+		if status.Complete() {
+			cancel()
+		}
+	})
+	require.Len(t, statuses, 3)
+	assert.Equal(t, []int{0, 1, 2}, []int{statuses[0].OnTarget, statuses[1].OnTarget, statuses[2].OnTarget})
+	assert.Positive(t, statuses[1].Rate)
+
+	// Failed scans are reported until the timeout
+	var errs int
+	Watch(context.Background(), failingSource{}, Config{Analyzer: config(t).Analyzer, PollInterval: time.Millisecond, Timeout: 20 * time.Millisecond}, func(_ Status, err error) {
+		assert.ErrorContains(t, err, "connection refused")
+		errs++
+	})
+	assert.Positive(t, errs)
+}
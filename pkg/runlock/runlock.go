@@ -0,0 +1,219 @@
+package runlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// DefaultDuration is how long a lock is held without being renewed before another instance may take it.
+const DefaultDuration = time.Minute
+
+// ErrLocked is returned by Lock.Acquire when another instance holds the lock.
+var ErrLocked = errors.New("run lock is held by another instance")
+
+// Config configures a run lock.
+type Config struct {
+	// Namespace is the namespace of the Lease.
+	Namespace string
+	// Name is the name of the Lease.
+	Name string
+	// Identity identifies this instance as the holder of the Lease.
+	Identity string
+	// Duration is how long the lock is held without being renewed. It is renewed every third of it
+	// while held. Defaults to DefaultDuration.
+	Duration time.Duration
+	// RequestTimeout bounds each Kubernetes API call. 0 disables the limit.
+	RequestTimeout time.Duration
+}
+
+// Lock excludes concurrent runs of several instances, e.g. overlapping CronJob runs, through a Lease,
+// so that their report writes never interleave. A lock that is not renewed, because its holder died,
+// expires after Config.Duration.
+type Lock struct {
+	clientset kubernetes.Interface
+	config    Config
+	now       func() time.Time
+}
+
+func NewLock(clientset kubernetes.Interface, config Config) *Lock {
+	if config.Duration <= 0 {
+		config.Duration = DefaultDuration
+	}
+	return &Lock{clientset: clientset, config: config, now: time.Now}
+}
+
+// LeaseName returns the name of the Lease locking the runs writing the report reportName.
+func LeaseName(reportName string) string {
+	return reportName + "-run-lock"
+}
+
+// RequiredPermissions lists the Kubernetes API access the lock needs.
+func RequiredPermissions(namespace, name string) []rbac.Permission {
+	return []rbac.Permission{
+		{Verb: "get", Group: coordinationv1.GroupName, Resource: "leases", Namespace: namespace, Name: name},
+		{Verb: "create", Group: coordinationv1.GroupName, Resource: "leases", Namespace: namespace},
+		{Verb: "update", Group: coordinationv1.GroupName, Resource: "leases", Namespace: namespace, Name: name},
+	}
+}
+
+// Acquire takes the lock, or returns ErrLocked if another instance holds it. The lock is renewed in
+// the background until the returned function releases it.
+func (l *Lock) Acquire(ctx context.Context) (func(), error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	renewCtx, stop := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		l.renew(renewCtx)
+	}()
+	return func() {
+		stop()
+		wg.Wait()
+		// The run context may have timed out, and the lock is released anyway
+		if err := l.release(context.WithoutCancel(ctx)); err != nil {
+			klog.ErrorS(err, "Failed to release run lock", "lease", l.config.Name)
+		}
+	}, nil
+}
+
+// acquire creates the Lease, or takes it over if it is free or expired.
+func (l *Lock) acquire(ctx context.Context) error {
+	leases := l.clientset.CoordinationV1().Leases(l.config.Namespace)
+	getCtx, cancel := utils.ContextWithTimeout(ctx, l.config.RequestTimeout)
+	lease, err := leases.Get(getCtx, l.config.Name, metav1.GetOptions{})
+	cancel()
+	now := metav1.NewMicroTime(l.now())
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: l.config.Name, Namespace: l.config.Namespace}}
+		l.hold(lease, now)
+		createCtx, cancel := utils.ContextWithTimeout(ctx, l.config.RequestTimeout)
+		defer cancel()
+		_, err = leases.Create(createCtx, lease, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("%w: lease %s was created concurrently", ErrLocked, l.config.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create lease %s: %w", l.config.Name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get lease %s: %w", l.config.Name, err)
+	}
+
+	holder := holderOf(lease)
+	if holder != "" && holder != l.config.Identity {
+		if expiry := expiresAt(lease); expiry.After(now.Time) {
+			return fmt.Errorf("%w: %s holds lease %s until %s", ErrLocked, holder, l.config.Name, expiry.UTC().Format(time.RFC3339))
+		}
+		klog.InfoS("Taking over expired run lock", "lease", l.config.Name, "holder", holder)
+	}
+	if holder != l.config.Identity {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions += *lease.Spec.LeaseTransitions
+		}
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	l.hold(lease, now)
+	updateCtx, cancel := utils.ContextWithTimeout(ctx, l.config.RequestTimeout)
+	defer cancel()
+	// The update fails with a conflict if another instance took the lease since it was read
+	_, err = leases.Update(updateCtx, lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return fmt.Errorf("%w: lease %s was taken concurrently", ErrLocked, l.config.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update lease %s: %w", l.config.Name, err)
+	}
+	return nil
+}
+
+// hold sets this instance as the holder of lease, acquired at now.
+func (l *Lock) hold(lease *coordinationv1.Lease, now metav1.MicroTime) {
+	identity := l.config.Identity
+	seconds := int32(max(l.config.Duration.Round(time.Second), time.Second) / time.Second)
+	lease.Spec.HolderIdentity = &identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+}
+
+// holderOf returns the holder of lease, empty if it is free.
+func holderOf(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// expiresAt returns when lease expires unless renewed.
+func expiresAt(lease *coordinationv1.Lease) time.Time {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return time.Time{}
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+}
+
+// renew renews the lock every third of its duration until ctx is cancelled.
+func (l *Lock) renew(ctx context.Context) {
+	ticker := time.NewTicker(max(l.config.Duration/3, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.update(ctx, func(lease *coordinationv1.Lease) {
+				now := metav1.NewMicroTime(l.now())
+				lease.Spec.RenewTime = &now
+			}); err != nil {
+				klog.ErrorS(err, "Failed to renew run lock", "lease", l.config.Name)
+			}
+		}
+	}
+}
+
+// release frees the lock, so that the next run does not wait for it to expire.
+func (l *Lock) release(ctx context.Context) error {
+	return l.update(ctx, func(lease *coordinationv1.Lease) {
+		lease.Spec.HolderIdentity = nil
+		lease.Spec.RenewTime = nil
+	})
+}
+
+// update applies change to the Lease while this instance holds it.
+func (l *Lock) update(ctx context.Context, change func(*coordinationv1.Lease)) error {
+	leases := l.clientset.CoordinationV1().Leases(l.config.Namespace)
+	getCtx, cancel := utils.ContextWithTimeout(ctx, l.config.RequestTimeout)
+	lease, err := leases.Get(getCtx, l.config.Name, metav1.GetOptions{})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to get lease %s: %w", l.config.Name, err)
+	}
+	if holder := holderOf(lease); holder != l.config.Identity {
+		return fmt.Errorf("lease %s is held by %q", l.config.Name, holder)
+	}
+	change(lease)
+	updateCtx, cancel := utils.ContextWithTimeout(ctx, l.config.RequestTimeout)
+	defer cancel()
+	if _, err := leases.Update(updateCtx, lease, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update lease %s: %w", l.config.Name, err)
+	}
+	return nil
+}
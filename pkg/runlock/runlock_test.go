@@ -0,0 +1,88 @@
+package runlock
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestLock(clientset kubernetes.Interface, identity string, now time.Time) *Lock {
+	lock := NewLock(clientset, Config{Namespace: "kms", Name: "kms-reporter-run-lock", Identity: identity})
+	lock.now = func() time.Time { return now }
+	return lock
+}
+
+func getLease(t *testing.T, clientset kubernetes.Interface) *coordinationv1.Lease {
+	lease, err := clientset.CoordinationV1().Leases("kms").Get(context.Background(), "kms-reporter-run-lock", metav1.GetOptions{})
+	require.NoError(t, err)
+	return lease
+}
+
+func TestLock_Acquire(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	release, err := newTestLock(clientset, "pod-a", now).Acquire(context.Background())
+	require.NoError(t, err)
+	lease := getLease(t, clientset)
+	assert.Equal(t, "pod-a", *lease.Spec.HolderIdentity)
+	assert.Equal(t, int32(60), *lease.Spec.LeaseDurationSeconds)
+
+	// Another instance is locked out until the lock is released
+	_, err = newTestLock(clientset, "pod-b", now.Add(30*time.Second)).Acquire(context.Background())
+	assert.ErrorIs(t, err, ErrLocked)
+	assert.ErrorContains(t, err, "pod-a holds lease kms-reporter-run-lock until 2025-01-01T00:01:00Z")
+
+	release()
+	assert.Nil(t, getLease(t, clientset).Spec.HolderIdentity)
+	release, err = newTestLock(clientset, "pod-b", now.Add(30*time.Second)).Acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+	lease = getLease(t, clientset)
+	assert.Equal(t, "pod-b", *lease.Spec.HolderIdentity)
+	assert.Equal(t, int32(1), *lease.Spec.LeaseTransitions)
+}
+
+func TestLock_Acquire_Expired(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err := newTestLock(clientset, "pod-a", now).Acquire(context.Background())
+	require.NoError(t, err)
+
+	// The lock of an instance that died without releasing it expires
+	release, err := newTestLock(clientset, "pod-b", now.Add(61*time.Second)).Acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+	assert.Equal(t, "pod-b", *getLease(t, clientset).Spec.HolderIdentity)
+}
+
+func TestLock_Renew(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	lock := NewLock(clientset, Config{Namespace: "kms", Name: "kms-reporter-run-lock", Identity: "pod-a", Duration: 30 * time.Millisecond})
+	// Every call is an hour later
+	var calls atomic.Int64
+	lock.now = func() time.Time { return start.Add(time.Duration(calls.Add(1)) * time.Hour) }
+	release, err := lock.Acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+
+	acquired := getLease(t, clientset).Spec.AcquireTime.Time
+	assert.Eventually(t, func() bool {
+		return getLease(t, clientset).Spec.RenewTime.After(acquired)
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	permissions := RequiredPermissions("kms", LeaseName("kms-reporter"))
+	assert.Len(t, permissions, 3)
+	assert.Equal(t, "update leases.coordination.k8s.io/kms-reporter-run-lock in namespace kms", permissions[2].String())
+}
@@ -0,0 +1,173 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/audit"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/events"
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+	"github.com/lzhecheng/kms-reporter/pkg/reader"
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+	"github.com/lzhecheng/kms-reporter/pkg/runlock"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+var (
+	// ErrRunTimeout is returned when a run is cancelled because it exceeded Config.Timeout.
+	ErrRunTimeout = errors.New("run timed out")
+	// ErrSkipped is returned when a run is skipped because another instance holds Config.Lock. The
+	// skip is not a failure: it is neither recorded nor counted as one.
+	ErrSkipped = errors.New("run skipped")
+)
+
+// Config configures the reporter runs.
+type Config struct {
+	// Namespace is the namespace the report is stored in.
+	Namespace string
+	// Timeout bounds an entire read and record cycle. 0 disables the limit.
+	Timeout time.Duration
+	// Events receives an event for every failed run. Optional.
+	Events events.Emitter
+	// Status stores the outcome of every run in the report. Optional.
+	Status recorder.RecorderOperator
+	// Audit receives an audit record of every run. Optional.
+	Audit *audit.Auditor
+	// Lock excludes the runs of other instances. A run is skipped while another instance holds it. Optional.
+	Lock Locker
+}
+
+// Locker is a lock shared by several instances, such as runlock.Lock.
+type Locker interface {
+	// Acquire takes the lock until the returned function is called, or returns an error wrapping
+	// runlock.ErrLocked if another instance holds it.
+	Acquire(ctx context.Context) (func(), error)
+}
+
+// Runner serializes reporter runs so that periodic runs and on-demand scans
+// triggered through the control server never interleave their ConfigMap writes.
+type Runner struct {
+	mu     sync.Mutex
+	reader reader.ReaderOperator
+	config Config
+}
+
+func NewRunner(readerOperator reader.ReaderOperator, config Config) *Runner {
+	return &Runner{
+		reader: readerOperator,
+		config: config,
+	}
+}
+
+// RunOnce performs a single read and record cycle. Concurrent callers wait for
+// the in-flight run to finish before starting their own. Each run gets a new run ID,
+// available to the reader through utils.RunIDFromContext. With Config.Lock, a run is
+// skipped while another instance holds the lock, returning an error wrapping ErrSkipped.
+func (r *Runner) RunOnce(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	runID := string(uuid.NewUUID())
+	klog.V(2).InfoS("Starting run", "runID", runID)
+	runCtx := utils.ContextWithRunID(ctx, runID)
+	if r.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, r.config.Timeout)
+		defer cancel()
+	}
+
+	if r.config.Lock != nil {
+		release, err := r.config.Lock.Acquire(runCtx)
+		if errors.Is(err, runlock.ErrLocked) {
+			klog.InfoS("Skipping run, another instance is running", "runID", runID, "reason", err.Error())
+			metrics.RunsSkippedTotal.Inc()
+			return fmt.Errorf("%w: %w", ErrSkipped, err)
+		}
+		if err != nil {
+			return r.finish(ctx, runCtx, runID, fmt.Errorf("failed to acquire run lock: %w", err))
+		}
+		// The lock is held until the outcome of the run is recorded as well
+		defer release()
+	}
+	err := r.reader.Read(runCtx, r.config.Namespace)
+	return r.finish(ctx, runCtx, runID, err)
+}
+
+// finish records the outcome of the run runID, in runCtx derived from ctx, and returns its error.
+func (r *Runner) finish(ctx, runCtx context.Context, runID string, err error) error {
+	// Only the run deadline counts as a timeout, not a cancellation of the parent context
+	if err != nil && ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%w after %s: %w", ErrRunTimeout, r.config.Timeout, err)
+		metrics.RunTimeoutsTotal.Inc()
+	}
+	finishedAt := time.Now()
+	metrics.ObserveRun(err, float64(finishedAt.Unix()))
+	if err != nil {
+		r.emitFailure(ctx, err)
+	}
+	r.recordStatus(ctx, runID, err, finishedAt)
+	if r.config.Audit != nil {
+		r.config.Audit.RecordRun(context.WithoutCancel(ctx), runID, err, finishedAt)
+	}
+	return err
+}
+
+// recordStatus stores the outcome of a run in the report. It uses the parent context, as the
+// run context may have timed out.
+func (r *Runner) recordStatus(ctx context.Context, runID string, runErr error, finishedAt time.Time) {
+	if r.config.Status == nil || ctx.Err() != nil {
+		return
+	}
+	statusCtx := utils.ContextWithRunID(ctx, runID)
+	if err := r.config.Status.RecordRunStatus(statusCtx, r.config.Namespace, runErr, finishedAt); err != nil {
+		klog.ErrorS(err, "Failed to record run status")
+	}
+}
+
+// emitFailure records an event about a failed run.
+func (r *Runner) emitFailure(ctx context.Context, err error) {
+	if r.config.Events == nil || ctx.Err() != nil {
+		return
+	}
+	reason := events.ReasonRunFailed
+	switch {
+	case errors.Is(err, ErrRunTimeout):
+		reason = events.ReasonRunTimedOut
+	case errors.Is(err, etcd.ErrCircuitOpen):
+		reason = events.ReasonCircuitOpen
+	}
+	r.config.Events.Emit(ctx, v1.EventTypeWarning, reason, err.Error())
+}
+
+// Run executes a run immediately and then once per interval until ctx is cancelled.
+func (r *Runner) Run(ctx context.Context, interval time.Duration) {
+	// Run once at startup
+	r.runLogged(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			klog.Info("Received termination signal, shutting down gracefully...")
+			return
+		case <-ticker.C:
+			r.runLogged(ctx)
+		}
+	}
+}
+
+// runLogged performs a single run and logs its failure. Skipped runs are logged by RunOnce.
+func (r *Runner) runLogged(ctx context.Context) {
+	if err := r.RunOnce(ctx); err != nil && !errors.Is(err, ErrSkipped) {
+		klog.ErrorS(err, "Failed to read etcd")
+	}
+}
@@ -0,0 +1,287 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/audit"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+	"github.com/lzhecheng/kms-reporter/pkg/events"
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+	mock_reader "github.com/lzhecheng/kms-reporter/pkg/reader/mock"
+	mock_recorder "github.com/lzhecheng/kms-reporter/pkg/recorder/mock"
+	"github.com/lzhecheng/kms-reporter/pkg/runlock"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+func TestRunner_RunOnce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockReader := mock_reader.NewMockReaderOperator(ctrl)
+	mockReader.EXPECT().Read(gomock.Any(), "test-namespace").Return(nil)
+	mockReader.EXPECT().Read(gomock.Any(), "test-namespace").Return(errors.New("read failed"))
+
+	r := NewRunner(mockReader, Config{Namespace: "test-namespace"})
+	assert.NoError(t, r.RunOnce(context.Background()))
+
+	err := r.RunOnce(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "read failed")
+}
+
+func TestRunner_RunOnce_RunID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var runIDs []string
+	mockReader := mock_reader.NewMockReaderOperator(ctrl)
+	mockReader.EXPECT().Read(gomock.Any(), "test-namespace").DoAndReturn(func(ctx context.Context, namespace string) error {
+		runIDs = append(runIDs, utils.RunIDFromContext(ctx))
+		return nil
+	}).Times(2)
+
+	r := NewRunner(mockReader, Config{Namespace: "test-namespace"})
+	assert.NoError(t, r.RunOnce(context.Background()))
+	assert.NoError(t, r.RunOnce(context.Background()))
+
+	assert.Len(t, runIDs, 2)
+	assert.NotEmpty(t, runIDs[0])
+	assert.NotEqual(t, runIDs[0], runIDs[1], "every run should get its own ID")
+}
+
+func TestRunner_RunOnce_Serialized(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	mockReader := mock_reader.NewMockReaderOperator(ctrl)
+	mockReader.EXPECT().Read(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, namespace string) error {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return nil
+	}).Times(5)
+
+	r := NewRunner(mockReader, Config{Namespace: "test-namespace"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = r.RunOnce(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, maxActive, "runs should never overlap")
+}
+
+func TestRunner_Run_StopsOnCancel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mockReader := mock_reader.NewMockReaderOperator(ctrl)
+	mockReader.EXPECT().Read(gomock.Any(), "test-namespace").DoAndReturn(func(context.Context, string) error {
+		cancel()
+		return nil
+	}).MinTimes(1)
+
+	r := NewRunner(mockReader, Config{Namespace: "test-namespace"})
+
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx, time.Hour)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after context cancellation")
+	}
+}
+
+type recordedEvent struct {
+	eventType, reason, message string
+}
+
+type stubEmitter struct {
+	events []recordedEvent
+}
+
+func (e *stubEmitter) Emit(_ context.Context, eventType, reason, message string) {
+	e.events = append(e.events, recordedEvent{eventType, reason, message})
+}
+
+func TestRunner_RunOnce_Timeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockReader := mock_reader.NewMockReaderOperator(ctrl)
+	// A hung dependency only returns once the run context is cancelled
+	mockReader.EXPECT().Read(gomock.Any(), "test-namespace").DoAndReturn(func(ctx context.Context, namespace string) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	mockReader.EXPECT().Read(gomock.Any(), "test-namespace").Return(errors.New("read failed"))
+	mockReader.EXPECT().Read(gomock.Any(), "test-namespace").Return(nil)
+
+	emitter := &stubEmitter{}
+	r := NewRunner(mockReader, Config{Namespace: "test-namespace", Timeout: 10 * time.Millisecond, Events: emitter})
+	timeouts := testutil.ToFloat64(metrics.RunTimeoutsTotal)
+
+	err := r.RunOnce(context.Background())
+	assert.ErrorIs(t, err, ErrRunTimeout)
+	assert.Equal(t, timeouts+1, testutil.ToFloat64(metrics.RunTimeoutsTotal))
+
+	// The next run starts fresh with its own deadline
+	err = r.RunOnce(context.Background())
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrRunTimeout)
+	assert.NoError(t, r.RunOnce(context.Background()))
+
+	assert.Len(t, emitter.events, 2)
+	assert.Equal(t, events.ReasonRunTimedOut, emitter.events[0].reason)
+	assert.Equal(t, v1.EventTypeWarning, emitter.events[0].eventType)
+	assert.Equal(t, events.ReasonRunFailed, emitter.events[1].reason)
+	assert.Contains(t, emitter.events[1].message, "read failed")
+}
+
+func TestRunner_RunOnce_CircuitOpen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockReader := mock_reader.NewMockReaderOperator(ctrl)
+	mockReader.EXPECT().Read(gomock.Any(), "test-namespace").Return(fmt.Errorf("failed to list secrets: %w", etcd.ErrCircuitOpen))
+
+	emitter := &stubEmitter{}
+	r := NewRunner(mockReader, Config{Namespace: "test-namespace", Events: emitter})
+	assert.ErrorIs(t, r.RunOnce(context.Background()), etcd.ErrCircuitOpen)
+
+	assert.Len(t, emitter.events, 1)
+	assert.Equal(t, events.ReasonCircuitOpen, emitter.events[0].reason)
+	assert.Equal(t, v1.EventTypeWarning, emitter.events[0].eventType)
+}
+
+func TestRunner_RunOnce_RecordsStatus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	readErr := errors.New("read failed")
+	mockReader := mock_reader.NewMockReaderOperator(ctrl)
+	mockRecorder := mock_recorder.NewMockRecorderOperator(ctrl)
+	gomock.InOrder(
+		mockReader.EXPECT().Read(gomock.Any(), "test-namespace").Return(readErr),
+		mockRecorder.EXPECT().RecordRunStatus(gomock.Any(), "test-namespace", readErr, gomock.Any()).Return(nil),
+		mockReader.EXPECT().Read(gomock.Any(), "test-namespace").Return(nil),
+		// A failure to record the status does not fail the run
+		mockRecorder.EXPECT().RecordRunStatus(gomock.Any(), "test-namespace", nil, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, namespace string, runErr error, finishedAt time.Time) error {
+				assert.NotEmpty(t, utils.RunIDFromContext(ctx))
+				return errors.New("conflict")
+			}),
+	)
+
+	r := NewRunner(mockReader, Config{Namespace: "test-namespace", Status: mockRecorder})
+	assert.ErrorIs(t, r.RunOnce(context.Background()), readErr)
+	assert.NoError(t, r.RunOnce(context.Background()))
+}
+
+// auditSink keeps the audit records written to it
+type auditSink struct {
+	records []audit.Record
+}
+
+func (s *auditSink) Write(_ context.Context, records []audit.Record) error {
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func TestRunner_RunOnce_Audit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sink := &auditSink{}
+	auditor := audit.NewAuditor(audit.Actor{Component: "kms-reporter"}, sink)
+	mockReader := mock_reader.NewMockReaderOperator(ctrl)
+	gomock.InOrder(
+		mockReader.EXPECT().Read(gomock.Any(), "test-namespace").DoAndReturn(func(ctx context.Context, _ string) error {
+			auditor.ObserveResult(ctx, analyzer.Result{EncryptedSecrets: []string{"default/a"}})
+			return nil
+		}),
+		mockReader.EXPECT().Read(gomock.Any(), "test-namespace").Return(errors.New("etcd unavailable")),
+	)
+
+	r := NewRunner(mockReader, Config{Namespace: "test-namespace", Audit: auditor})
+	assert.NoError(t, r.RunOnce(context.Background()))
+	assert.Error(t, r.RunOnce(context.Background()))
+
+	// Every run is recorded under its run ID, with the summary of its result if it had one
+	assert.Len(t, sink.records, 2)
+	assert.Equal(t, audit.OutcomeSuccess, sink.records[0].Outcome)
+	assert.Equal(t, &audit.Summary{Encrypted: 1}, sink.records[0].Summary)
+	assert.Equal(t, audit.OutcomeFailure, sink.records[1].Outcome)
+	assert.Equal(t, "etcd unavailable", sink.records[1].Error)
+	assert.Nil(t, sink.records[1].Summary)
+	assert.NotEqual(t, sink.records[0].RunID, sink.records[1].RunID)
+}
+
+// fakeLocker fails with err, or counts the releases of the lock
+type fakeLocker struct {
+	err      error
+	released int
+}
+
+func (l *fakeLocker) Acquire(_ context.Context) (func(), error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	return func() { l.released++ }, nil
+}
+
+func TestRunner_RunOnce_Lock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockReader := mock_reader.NewMockReaderOperator(ctrl)
+	mockReader.EXPECT().Read(gomock.Any(), "test-namespace").Return(nil)
+	lock := &fakeLocker{}
+	r := NewRunner(mockReader, Config{Namespace: "test-namespace", Lock: lock})
+	assert.NoError(t, r.RunOnce(context.Background()))
+	assert.Equal(t, 1, lock.released)
+
+	// A run is skipped while another instance holds the lock
+	skipped := testutil.ToFloat64(metrics.RunsSkippedTotal)
+	lock.err = fmt.Errorf("%w: other holds lease", runlock.ErrLocked)
+	err := r.RunOnce(context.Background())
+	assert.ErrorIs(t, err, ErrSkipped)
+	assert.ErrorIs(t, err, runlock.ErrLocked)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.RunsSkippedTotal)-skipped)
+
+	// and fails if the lock cannot be read
+	lock.err = errors.New("forbidden")
+	assert.ErrorContains(t, r.RunOnce(context.Background()), "failed to acquire run lock: forbidden")
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+)
+
+const (
+	// DefaultTerminationMessagePath is where Kubernetes reads the termination message of a container by default.
+	DefaultTerminationMessagePath = "/dev/termination-log"
+	// maxTerminationMessageLength is the size Kubernetes truncates termination messages to
+	maxTerminationMessageLength = 4096
+)
+
+// Outcomes of a run in the termination message
+const (
+	OutcomeSuccess = "Success"
+	OutcomeFailure = "Failure"
+	// OutcomeSkipped is the outcome of a run skipped because another instance was running, see ErrSkipped.
+	OutcomeSkipped = "Skipped"
+)
+
+// TerminationSummary is the summary of the report in the termination message.
+type TerminationSummary struct {
+	Encrypted    int `json:"encrypted"`
+	Unencrypted  int `json:"unencrypted"`
+	Unrecognized int `json:"unrecognized"`
+	// ProviderCounts maps each provider to the number of secrets it encrypted.
+	ProviderCounts map[string]int `json:"providerCounts"`
+	// AllSecretsUseLatestProvider is only set when every secret is encrypted.
+	AllSecretsUseLatestProvider *bool `json:"allSecretsUseLatestProvider,omitempty"`
+}
+
+// TerminationMessage is the outcome of a single run, written where Kubernetes reads the termination
+// message of the container, so that the result of a Job is visible in its pod status.
+type TerminationMessage struct {
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	// ExitCode is the exit code of the process.
+	ExitCode   int       `json:"exitCode"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Summary is the summary of the report after the run, or nil if it could not be read.
+	Summary *TerminationSummary `json:"summary,omitempty"`
+}
+
+// NewTerminationMessage returns the termination message of a run that finished at finishedAt with
+// runErr, nil if it succeeded, and exited with exitCode. report is the summary of the report after
+// the run, or nil.
+func NewTerminationMessage(runErr error, exitCode int, finishedAt time.Time, report *recorder.ReportSummary) TerminationMessage {
+	message := TerminationMessage{Outcome: OutcomeSuccess, ExitCode: exitCode, FinishedAt: finishedAt.UTC().Truncate(time.Second)}
+	if runErr != nil {
+		message.Outcome = OutcomeFailure
+		if errors.Is(runErr, ErrSkipped) {
+			message.Outcome = OutcomeSkipped
+		}
+		message.Error = runErr.Error()
+	}
+	if report != nil {
+		message.Summary = &TerminationSummary{
+			Encrypted:                   report.Encrypted,
+			Unencrypted:                 report.Unencrypted,
+			Unrecognized:                report.Unrecognized,
+			ProviderCounts:              report.ProviderCounts,
+			AllSecretsUseLatestProvider: report.AllSecretsUseLatestProvider,
+		}
+	}
+	return message
+}
+
+// WriteTerminationMessage writes message to path as JSON. The error is shortened so that the message
+// fits in the size Kubernetes keeps.
+func WriteTerminationMessage(path string, message TerminationMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal termination message: %w", err)
+	}
+	// JSON escaping may make the error longer than its length, so it is shortened until it fits
+	runErr := message.Error
+	for excess := len(data) - maxTerminationMessageLength; excess > 0 && runErr != ""; excess = len(data) - maxTerminationMessageLength {
+		runErr = runErr[:max(len(runErr)-excess, 0)]
+		message.Error = runErr + "..."
+		if data, err = json.Marshal(message); err != nil {
+			return fmt.Errorf("failed to marshal termination message: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write termination message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+)
+
+func TestNewTerminationMessage(t *testing.T) {
+	finishedAt := time.Date(2025, 1, 1, 0, 0, 0, 500, time.FixedZone("CET", 3600))
+	latest := true
+
+	message := NewTerminationMessage(nil, 0, finishedAt, &recorder.ReportSummary{
+		Encrypted:                   3,
+		ProviderCounts:              map[string]int{"kmsprovider2": 3},
+		AllSecretsUseLatestProvider: &latest,
+	})
+	assert.Equal(t, TerminationMessage{
+		Outcome:    OutcomeSuccess,
+		FinishedAt: time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC),
+		Summary: &TerminationSummary{
+			Encrypted:                   3,
+			ProviderCounts:              map[string]int{"kmsprovider2": 3},
+			AllSecretsUseLatestProvider: &latest,
+		},
+	}, message)
+
+	// A failed run whose report cannot be read has no summary
+	message = NewTerminationMessage(errors.New("etcd unavailable"), 3, finishedAt, nil)
+	assert.Equal(t, OutcomeFailure, message.Outcome)
+	assert.Equal(t, "etcd unavailable", message.Error)
+	assert.Equal(t, 3, message.ExitCode)
+	assert.Nil(t, message.Summary)
+
+	// A skipped run is not a failure
+	message = NewTerminationMessage(fmt.Errorf("%w: lease held", ErrSkipped), 0, finishedAt, nil)
+	assert.Equal(t, OutcomeSkipped, message.Outcome)
+	assert.Equal(t, "run skipped: lease held", message.Error)
+	assert.Equal(t, 0, message.ExitCode)
+}
+
+func TestWriteTerminationMessage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "termination-log")
+	message := NewTerminationMessage(errors.New("etcd unavailable"), 3, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	require.NoError(t, WriteTerminationMessage(path, message))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"outcome":"Failure","error":"etcd unavailable","exitCode":3,"finishedAt":"2025-01-01T00:00:00Z"}`, string(data))
+}
+
+func TestWriteTerminationMessage_LongError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "termination-log")
+	// Escaped characters make the JSON longer than the error
+	runErr := errors.New(strings.Repeat("<\"", 3000))
+	require.NoError(t, WriteTerminationMessage(path, NewTerminationMessage(runErr, 1, time.Now(), nil)))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(data), maxTerminationMessageLength)
+
+	var message TerminationMessage
+	require.NoError(t, json.Unmarshal(data, &message))
+	assert.True(t, strings.HasSuffix(message.Error, "..."))
+	assert.True(t, strings.HasPrefix(runErr.Error(), strings.TrimSuffix(message.Error, "...")))
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+)
+
+var errNoCredentials = errors.New("no credentials provided")
+
+// User is the identity of the caller of a control endpoint.
+type User struct {
+	Name   string
+	Groups []string
+}
+
+// Authenticator verifies the caller of a privileged control endpoint and returns its identity.
+type Authenticator interface {
+	Authenticate(r *http.Request) (User, error)
+}
+
+// ClientCertAuthenticator accepts requests that presented a client certificate
+// verified by the listener's tls.Config against the configured client CA.
+// As in Kubernetes, the common name is the user and the organizations are its groups.
+type ClientCertAuthenticator struct{}
+
+func (ClientCertAuthenticator) Authenticate(r *http.Request) (User, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return User{}, errNoCredentials
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	return User{Name: subject.CommonName, Groups: subject.Organization}, nil
+}
+
+// TokenReviewAuthenticator validates bearer tokens through the Kubernetes TokenReview API.
+type TokenReviewAuthenticator struct {
+	Clientset kubernetes.Interface
+	Audiences []string
+}
+
+func (a *TokenReviewAuthenticator) Authenticate(r *http.Request) (User, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return User{}, errNoCredentials
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: a.Audiences,
+		},
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), defaultTimeout)
+	defer cancel()
+
+	result, err := a.Clientset.AuthenticationV1().TokenReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return User{}, fmt.Errorf("failed to create TokenReview: %w", err)
+	}
+	if !result.Status.Authenticated {
+		return User{}, fmt.Errorf("token not authenticated: %s", result.Status.Error)
+	}
+	return User{Name: result.Status.User.Username, Groups: result.Status.User.Groups}, nil
+}
+
+// unionAuthenticator tries each authenticator in order and accepts the first success.
+type unionAuthenticator []Authenticator
+
+func (u unionAuthenticator) Authenticate(r *http.Request) (User, error) {
+	var errs []error
+	for _, authenticator := range u {
+		user, err := authenticator.Authenticate(r)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, errNoCredentials) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return User{}, errNoCredentials
+	}
+	return User{}, errors.Join(errs...)
+}
+
+// allowList authorizes the users named in it and the members of its groups. Authentication alone
+// is not enough: any token of the cluster, or any certificate of a shared CA, would be accepted.
+type allowList struct {
+	users  map[string]bool
+	groups map[string]bool
+}
+
+func newAllowList(users, groups []string) allowList {
+	list := allowList{users: map[string]bool{}, groups: map[string]bool{}}
+	for _, user := range users {
+		list.users[user] = true
+	}
+	for _, group := range groups {
+		list.groups[group] = true
+	}
+	return list
+}
+
+// allows returns whether user is named in the list or is a member of one of its groups.
+func (l allowList) allows(user User) bool {
+	if l.users[user.Name] {
+		return true
+	}
+	for _, group := range user.Groups {
+		if l.groups[group] {
+			return true
+		}
+	}
+	return false
+}
+
+// withAuthentication rejects requests that cannot be authenticated, or whose user is not allowed,
+// before they reach the handler.
+func withAuthentication(authenticator Authenticator, allowed allowList, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := authenticator.Authenticate(r)
+		if err != nil {
+			klog.V(2).InfoS("Rejected unauthenticated control request", "path", r.URL.Path, "remoteAddr", r.RemoteAddr, "err", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kms-reporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !allowed.allows(user) {
+			klog.V(2).InfoS("Rejected unauthorized control request", "path", r.URL.Path, "user", user.Name, "groups", user.Groups)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		klog.V(4).InfoS("Authenticated control request", "path", r.URL.Path, "user", user.Name)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	token, found := strings.CutPrefix(header, "Bearer ")
+	token = strings.TrimSpace(token)
+	if !found || token == "" {
+		return "", false
+	}
+	return token, true
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+type stubAuthenticator struct {
+	user User
+	err  error
+}
+
+func (s stubAuthenticator) Authenticate(*http.Request) (User, error) {
+	return s.user, s.err
+}
+
+// newTokenReviewClientset authenticates validToken as a member of the ops group, and other-token as
+// a user of no group.
+func newTokenReviewClientset(validToken string) *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case validToken:
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: "system:serviceaccount:ops:scanner", Groups: []string{"system:serviceaccounts", "ops"}},
+			}
+		case "other-token":
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: "system:serviceaccount:default:app"},
+			}
+		default:
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: false, Error: "invalid token"}
+		}
+		return true, review, nil
+	})
+	return clientset
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        string
+		expectedToken string
+		expectedOK    bool
+	}{
+		{name: "valid bearer token", header: "Bearer abc123", expectedToken: "abc123", expectedOK: true},
+		{name: "missing header", header: ""},
+		{name: "basic auth", header: "Basic dXNlcjpwYXNz"},
+		{name: "empty bearer token", header: "Bearer  "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/report", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			token, ok := bearerToken(r)
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedToken, token)
+		})
+	}
+}
+
+func TestTokenReviewAuthenticator(t *testing.T) {
+	authenticator := &TokenReviewAuthenticator{Clientset: newTokenReviewClientset("good-token")}
+
+	tests := []struct {
+		name          string
+		token         string
+		expectedUser  User
+		expectedError string
+	}{
+		{name: "valid token", token: "good-token", expectedUser: User{Name: "system:serviceaccount:ops:scanner", Groups: []string{"system:serviceaccounts", "ops"}}},
+		{name: "invalid token", token: "bad-token", expectedError: "token not authenticated"},
+		{name: "no token", expectedError: errNoCredentials.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/report", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			user, err := authenticator.Authenticate(r)
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedUser, user)
+			}
+		})
+	}
+}
+
+func TestClientCertAuthenticator(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/report", nil)
+	_, err := ClientCertAuthenticator{}.Authenticate(r)
+	assert.ErrorIs(t, err, errNoCredentials)
+
+	r.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "ops-client", Organization: []string{"ops"}}}}},
+	}
+	user, err := ClientCertAuthenticator{}.Authenticate(r)
+	assert.NoError(t, err)
+	assert.Equal(t, User{Name: "ops-client", Groups: []string{"ops"}}, user)
+}
+
+func TestUnionAuthenticator(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/report", nil)
+
+	user, err := unionAuthenticator{
+		stubAuthenticator{err: errNoCredentials},
+		stubAuthenticator{user: User{Name: "second"}},
+	}.Authenticate(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", user.Name)
+
+	_, err = unionAuthenticator{
+		stubAuthenticator{err: errNoCredentials},
+		stubAuthenticator{err: errNoCredentials},
+	}.Authenticate(r)
+	assert.ErrorIs(t, err, errNoCredentials)
+
+	_, err = unionAuthenticator{
+		stubAuthenticator{err: errNoCredentials},
+		stubAuthenticator{err: errors.New("token expired")},
+	}.Authenticate(r)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "token expired")
+}
+
+func TestWithAuthentication(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	allowed := newAllowList([]string{"admin"}, []string{"ops"})
+
+	w := httptest.NewRecorder()
+	withAuthentication(stubAuthenticator{user: User{Name: "admin"}}, allowed, handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/report", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	withAuthentication(stubAuthenticator{user: User{Name: "scanner", Groups: []string{"system:authenticated", "ops"}}}, allowed, handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/report", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	withAuthentication(stubAuthenticator{user: User{Name: "app", Groups: []string{"system:authenticated"}}}, allowed, handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/report", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	withAuthentication(stubAuthenticator{err: errNoCredentials}, allowed, handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/report", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/lzhecheng/kms-reporter/pkg/version"
+)
+
+// openAPIVersion is the OpenAPI specification version of the served document
+const openAPIVersion = "3.0.3"
+
+// openAPIDocument returns the OpenAPI document of the control endpoints. Only the authentication
+// methods enabled in config are listed as security schemes.
+func openAPIDocument(config Config) map[string]any {
+	errorResponse := func(description string) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+			},
+		}
+	}
+	unauthorized := map[string]any{"description": "The request is not authenticated."}
+	forbidden := map[string]any{"description": "The authenticated user is not allowed."}
+
+	securitySchemes := map[string]any{}
+	var security []map[string][]string
+	if config.ClientCAFile != "" {
+		securitySchemes["clientCertificate"] = map[string]any{
+			"type":        "mutualTLS",
+			"description": "A client certificate signed by the configured client CA.",
+		}
+		security = append(security, map[string][]string{"clientCertificate": {}})
+	}
+	if config.TokenAuth {
+		securitySchemes["bearerToken"] = map[string]any{
+			"type":        "http",
+			"scheme":      "bearer",
+			"description": "A Kubernetes token, authenticated with the TokenReview API.",
+		}
+		security = append(security, map[string][]string{"bearerToken": {}})
+	}
+
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":       "kms-reporter control API",
+			"description": "Authenticated endpoints to trigger scans and read the secret encryption report.",
+			"version":     version.Get().Version,
+		},
+		"security": security,
+		"paths": map[string]any{
+			"/scan": map[string]any{
+				"post": map[string]any{
+					"operationId": "scan",
+					"summary":     "Run the reporter once and wait for the run to complete.",
+					"responses": map[string]any{
+						"200": map[string]any{
+							"description": "The run succeeded.",
+							"content": map[string]any{
+								"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/ScanResult"}},
+							},
+						},
+						"401": unauthorized,
+						"403": forbidden,
+						"409": errorResponse("The run was skipped, as another instance holds the run lock."),
+						"500": errorResponse("The run failed."),
+					},
+				},
+			},
+			"/report": map[string]any{
+				"get": map[string]any{
+					"operationId": "getReport",
+					"summary":     "Return the latest stored report.",
+					"responses": map[string]any{
+						"200": map[string]any{
+							"description": "The data of the report ConfigMap.",
+							"content": map[string]any{
+								"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Report"}},
+							},
+						},
+						"401": unauthorized,
+						"403": forbidden,
+						"500": errorResponse("The report could not be read."),
+					},
+				},
+			},
+			"/openapi.json": map[string]any{
+				"get": map[string]any{
+					"operationId": "getOpenAPI",
+					"summary":     "Return this OpenAPI document.",
+					"responses": map[string]any{
+						"200": map[string]any{
+							"description": "The OpenAPI document.",
+							"content":     map[string]any{"application/json": map[string]any{"schema": map[string]any{"type": "object"}}},
+						},
+						"401": unauthorized,
+						"403": forbidden,
+					},
+				},
+			},
+		},
+		"components": map[string]any{
+			"securitySchemes": securitySchemes,
+			"schemas": map[string]any{
+				"ScanResult": map[string]any{
+					"type":     "object",
+					"required": []string{"status"},
+					"properties": map[string]any{
+						"status": map[string]any{"type": "string", "enum": []string{"succeeded"}},
+					},
+				},
+				"Error": map[string]any{
+					"type":     "object",
+					"required": []string{"error"},
+					"properties": map[string]any{
+						"status": map[string]any{"type": "string", "enum": []string{"failed", "skipped"}},
+						"error":  map[string]any{"type": "string"},
+					},
+				},
+				"Report": map[string]any{
+					"type":                 "object",
+					"description":          "The data of the report ConfigMap, such as ENCRYPTED, UNENCRYPTED and PROVIDER_COUNTS.",
+					"additionalProperties": map[string]any{"type": "string"},
+				},
+			},
+		},
+	}
+}
+
+// handleOpenAPI returns the OpenAPI document of the control endpoints.
+func (s *Server) handleOpenAPI() http.HandlerFunc {
+	document := openAPIDocument(s.config)
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.writeJSON(w, http.StatusOK, document)
+	}
+}
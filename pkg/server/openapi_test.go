@@ -0,0 +1,57 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_OpenAPI(t *testing.T) {
+	pki := newTestPKI(t)
+	s, err := NewServer(Config{
+		ControlBindAddress: ":0",
+		TLSCertFile:        pki.serverCertFile,
+		TLSKeyFile:         pki.serverKeyFile,
+		ClientCAFile:       pki.caFile,
+		AllowedUsers:       []string{"ops-client"},
+	}, &stubScanner{}, nil, nil)
+	require.NoError(t, err)
+	ts := startControl(t, s)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      pki.caPool,
+		Certificates: []tls.Certificate{pki.clientCert},
+	}}}
+	resp, err := client.Get(ts.URL + "/openapi.json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var document struct {
+		OpenAPI    string                    `json:"openapi"`
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			SecuritySchemes map[string]any `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&document))
+	assert.Equal(t, openAPIVersion, document.OpenAPI)
+	assert.Contains(t, document.Paths["/scan"], "post")
+	assert.Contains(t, document.Paths["/report"], "get")
+	assert.Contains(t, document.Paths, "/openapi.json")
+	assert.Contains(t, document.Components.SecuritySchemes, "clientCertificate")
+	assert.NotContains(t, document.Components.SecuritySchemes, "bearerToken")
+}
+
+func TestOpenAPIDocument_SecuritySchemes(t *testing.T) {
+	document := openAPIDocument(Config{ClientCAFile: "ca.crt", TokenAuth: true})
+	schemes := document["components"].(map[string]any)["securitySchemes"].(map[string]any)
+	assert.Contains(t, schemes, "clientCertificate")
+	assert.Contains(t, schemes, "bearerToken")
+	assert.Len(t, document["security"], 2)
+}
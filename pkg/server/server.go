@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/metrics"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/runner"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+	"github.com/lzhecheng/kms-reporter/pkg/webhook"
+)
+
+const (
+	defaultTimeout  = 5 * time.Second
+	shutdownTimeout = 5 * time.Second
+)
+
+// Config configures the management listeners. The metrics listener is public,
+// while every endpoint on the control listener requires authentication and authorization.
+type Config struct {
+	// MetricsBindAddress is the address of the public metrics listener. Empty disables it.
+	MetricsBindAddress string
+	// ControlBindAddress is the address of the privileged control listener. Empty disables it.
+	ControlBindAddress string
+	// TLSCertFile and TLSKeyFile are the serving certificate of the control listener.
+	TLSCertFile string
+	TLSKeyFile  string
+	// ClientCAFile enables mTLS client verification against the given CA bundle.
+	ClientCAFile string
+	// TokenAuth enables bearer-token authentication through the TokenReview API.
+	TokenAuth bool
+	// TokenAudiences are the audiences requested in TokenReviews.
+	TokenAudiences []string
+	// AllowedUsers and AllowedGroups are the authenticated users, and the groups of users, allowed
+	// to call the control endpoints. At least one of them is required.
+	AllowedUsers  []string
+	AllowedGroups []string
+	// WebhookBindAddress is the address of the admission webhook listener, which the API server calls
+	// on updates of the encryption configuration. Empty disables it.
+	WebhookBindAddress string
+	// WebhookTLSCertFile and WebhookTLSKeyFile are the serving certificate of the webhook listener.
+	WebhookTLSCertFile string
+	WebhookTLSKeyFile  string
+	// Webhook configures the admission webhook.
+	Webhook webhook.Config
+}
+
+// Scanner triggers an on-demand reporter run.
+type Scanner interface {
+	RunOnce(ctx context.Context) error
+}
+
+// ReportGetter returns the latest stored report.
+type ReportGetter func(ctx context.Context) (map[string]string, error)
+
+// Server hosts the public metrics listener and the authenticated control listener.
+type Server struct {
+	config     Config
+	marshaller utils.Marshaller
+	metrics    *http.Server
+	control    *http.Server
+	webhook    *http.Server
+}
+
+func NewServer(config Config, scanner Scanner, getReport ReportGetter, clientset kubernetes.Interface) (*Server, error) {
+	s := &Server{
+		config:     config,
+		marshaller: utils.JSONMarshaller{},
+	}
+
+	if config.MetricsBindAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+		s.metrics = &http.Server{Addr: config.MetricsBindAddress, Handler: mux, ReadHeaderTimeout: defaultTimeout}
+	}
+
+	if config.ControlBindAddress != "" {
+		authenticator, tlsConfig, err := buildControlAuth(config, clientset)
+		if err != nil {
+			return nil, err
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /scan", s.handleScan(scanner))
+		mux.HandleFunc("GET /report", s.handleReport(getReport))
+		mux.HandleFunc("GET /openapi.json", s.handleOpenAPI())
+		s.control = &http.Server{
+			Addr:              config.ControlBindAddress,
+			Handler:           withAuthentication(authenticator, newAllowList(config.AllowedUsers, config.AllowedGroups), mux),
+			TLSConfig:         tlsConfig,
+			ReadHeaderTimeout: defaultTimeout,
+		}
+	}
+
+	if config.WebhookBindAddress != "" {
+		if config.WebhookTLSCertFile == "" || config.WebhookTLSKeyFile == "" {
+			return nil, fmt.Errorf("webhook listener requires a TLS certificate and key")
+		}
+		cert, err := tls.LoadX509KeyPair(config.WebhookTLSCertFile, config.WebhookTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load webhook listener certificate and key: %w", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("POST /validate", webhook.NewHandler(config.Webhook, webhook.ReportGetter(getReport)))
+		s.webhook = &http.Server{
+			Addr:              config.WebhookBindAddress,
+			Handler:           mux,
+			TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+			ReadHeaderTimeout: defaultTimeout,
+		}
+	}
+
+	return s, nil
+}
+
+// RequiredPermissions lists the Kubernetes API access the server needs for the given configuration.
+func RequiredPermissions(config Config) []rbac.Permission {
+	if config.ControlBindAddress == "" || !config.TokenAuth {
+		return nil
+	}
+	return []rbac.Permission{
+		{Verb: "create", Group: "authentication.k8s.io", Resource: "tokenreviews"},
+	}
+}
+
+// buildControlAuth validates the control listener settings and returns its authenticator and TLS configuration.
+func buildControlAuth(config Config, clientset kubernetes.Interface) (Authenticator, *tls.Config, error) {
+	if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+		return nil, nil, fmt.Errorf("control listener requires a TLS certificate and key")
+	}
+	if config.ClientCAFile == "" && !config.TokenAuth {
+		return nil, nil, fmt.Errorf("control listener requires mTLS client verification and/or token authentication")
+	}
+	if len(config.AllowedUsers) == 0 && len(config.AllowedGroups) == 0 {
+		return nil, nil, fmt.Errorf("control listener requires allowed users and/or groups")
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load control listener certificate and key: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	var authenticators unionAuthenticator
+	if config.ClientCAFile != "" {
+		caCert, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read client CA certificate: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+			return nil, nil, fmt.Errorf("failed to append client CA certificate to pool")
+		}
+		tlsConfig.ClientCAs = caCertPool
+		// Token clients may connect without a certificate when both methods are enabled
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if config.TokenAuth {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		authenticators = append(authenticators, ClientCertAuthenticator{})
+	}
+	if config.TokenAuth {
+		if clientset == nil {
+			return nil, nil, fmt.Errorf("token authentication requires a Kubernetes client")
+		}
+		authenticators = append(authenticators, &TokenReviewAuthenticator{Clientset: clientset, Audiences: config.TokenAudiences})
+	}
+
+	return authenticators, tlsConfig, nil
+}
+
+// Start binds the configured listeners and serves them until ctx is cancelled.
+// Bind errors are returned immediately.
+func (s *Server) Start(ctx context.Context) error {
+	if s.metrics != nil {
+		listener, err := net.Listen("tcp", s.metrics.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on metrics address %s: %w", s.metrics.Addr, err)
+		}
+		go s.serve(s.metrics, func() error { return s.metrics.Serve(listener) })
+		klog.InfoS("Metrics listener started", "address", s.metrics.Addr)
+	}
+
+	if s.control != nil {
+		listener, err := net.Listen("tcp", s.control.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on control address %s: %w", s.control.Addr, err)
+		}
+		go s.serve(s.control, func() error { return s.control.ServeTLS(listener, "", "") })
+		klog.InfoS("Control listener started", "address", s.control.Addr, "mTLS", s.config.ClientCAFile != "", "tokenAuth", s.config.TokenAuth)
+	}
+
+	if s.webhook != nil {
+		listener, err := net.Listen("tcp", s.webhook.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on webhook address %s: %w", s.webhook.Addr, err)
+		}
+		go s.serve(s.webhook, func() error { return s.webhook.ServeTLS(listener, "", "") })
+		klog.InfoS("Webhook listener started", "address", s.webhook.Addr, "deny", s.config.Webhook.Deny)
+	}
+
+	go func() {
+		<-ctx.Done()
+		s.shutdown()
+	}()
+	return nil
+}
+
+func (s *Server) serve(srv *http.Server, serve func() error) {
+	if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		klog.ErrorS(err, "Listener stopped unexpectedly", "address", srv.Addr)
+	}
+}
+
+func (s *Server) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, srv := range []*http.Server{s.metrics, s.control, s.webhook} {
+		if srv == nil {
+			continue
+		}
+		if err := srv.Shutdown(ctx); err != nil {
+			klog.ErrorS(err, "Failed to shut down listener", "address", srv.Addr)
+		}
+	}
+}
+
+// handleScan runs the reporter once and responds when the run completes. A run skipped because
+// another instance is running is a conflict, not a failure.
+func (s *Server) handleScan(scanner Scanner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := scanner.RunOnce(r.Context())
+		if errors.Is(err, runner.ErrSkipped) {
+			s.writeJSON(w, http.StatusConflict, map[string]string{"status": "skipped", "error": err.Error()})
+			return
+		}
+		if err != nil {
+			s.writeJSON(w, http.StatusInternalServerError, map[string]string{"status": "failed", "error": err.Error()})
+			return
+		}
+		s.writeJSON(w, http.StatusOK, map[string]string{"status": "succeeded"})
+	}
+}
+
+// handleReport returns the latest stored report.
+func (s *Server) handleReport(getReport ReportGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := getReport(r.Context())
+		if err != nil {
+			s.writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		s.writeJSON(w, http.StatusOK, report)
+	}
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := s.marshaller.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		klog.ErrorS(err, "Failed to write response")
+	}
+}
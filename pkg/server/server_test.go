@@ -0,0 +1,361 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/lzhecheng/kms-reporter/pkg/runner"
+	"github.com/lzhecheng/kms-reporter/pkg/webhook"
+)
+
+type stubScanner struct {
+	err   error
+	calls int
+}
+
+func (s *stubScanner) RunOnce(context.Context) error {
+	s.calls++
+	return s.err
+}
+
+type testPKI struct {
+	caFile, serverCertFile, serverKeyFile string
+	caPool                                *x509.CertPool
+	clientCert                            tls.Certificate
+}
+
+// newTestPKI creates a CA plus server and client certificates signed by it.
+func newTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issue := func(serial int64, cn string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	}
+
+	pki := &testPKI{
+		caFile:         filepath.Join(dir, "ca.crt"),
+		serverCertFile: filepath.Join(dir, "server.crt"),
+		serverKeyFile:  filepath.Join(dir, "server.key"),
+		caPool:         x509.NewCertPool(),
+	}
+	pki.caPool.AddCert(caCert)
+	require.NoError(t, os.WriteFile(pki.caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0o600))
+
+	serverCert, serverKey := issue(2, "kms-reporter", x509.ExtKeyUsageServerAuth)
+	require.NoError(t, os.WriteFile(pki.serverCertFile, serverCert, 0o600))
+	require.NoError(t, os.WriteFile(pki.serverKeyFile, serverKey, 0o600))
+
+	clientCert, clientKey := issue(3, "ops-client", x509.ExtKeyUsageClientAuth)
+	pki.clientCert, err = tls.X509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+
+	return pki
+}
+
+// startControl serves the control listener of s on an httptest TLS server.
+func startControl(t *testing.T, s *Server) *httptest.Server {
+	ts := httptest.NewUnstartedServer(s.control.Handler)
+	ts.TLS = s.control.TLSConfig
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestNewServer_Validation(t *testing.T) {
+	pki := newTestPKI(t)
+
+	tests := []struct {
+		name          string
+		config        Config
+		expectedError string
+	}{
+		{
+			name:   "metrics only",
+			config: Config{MetricsBindAddress: ":0"},
+		},
+		{
+			name:          "control without TLS",
+			config:        Config{ControlBindAddress: ":0", TokenAuth: true},
+			expectedError: "requires a TLS certificate and key",
+		},
+		{
+			name:          "control without any authentication",
+			config:        Config{ControlBindAddress: ":0", TLSCertFile: pki.serverCertFile, TLSKeyFile: pki.serverKeyFile},
+			expectedError: "requires mTLS client verification and/or token authentication",
+		},
+		{
+			name:          "control without allowed users or groups",
+			config:        Config{ControlBindAddress: ":0", TLSCertFile: pki.serverCertFile, TLSKeyFile: pki.serverKeyFile, ClientCAFile: pki.caFile},
+			expectedError: "requires allowed users and/or groups",
+		},
+		{
+			name:          "control with missing client CA",
+			config:        Config{ControlBindAddress: ":0", TLSCertFile: pki.serverCertFile, TLSKeyFile: pki.serverKeyFile, ClientCAFile: "nonexistent.pem", AllowedUsers: []string{"ops-client"}},
+			expectedError: "failed to read client CA certificate",
+		},
+		{
+			name:          "token auth without clientset",
+			config:        Config{ControlBindAddress: ":0", TLSCertFile: pki.serverCertFile, TLSKeyFile: pki.serverKeyFile, TokenAuth: true, AllowedGroups: []string{"ops"}},
+			expectedError: "requires a Kubernetes client",
+		},
+		{
+			name:   "control with mTLS",
+			config: Config{ControlBindAddress: ":0", TLSCertFile: pki.serverCertFile, TLSKeyFile: pki.serverKeyFile, ClientCAFile: pki.caFile, AllowedUsers: []string{"ops-client"}},
+		},
+		{
+			name:          "webhook without TLS",
+			config:        Config{WebhookBindAddress: ":0"},
+			expectedError: "webhook listener requires a TLS certificate and key",
+		},
+		{
+			name:   "webhook",
+			config: Config{WebhookBindAddress: ":0", WebhookTLSCertFile: pki.serverCertFile, WebhookTLSKeyFile: pki.serverKeyFile},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewServer(tt.config, &stubScanner{}, nil, nil)
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestServer_ControlMTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	scanner := &stubScanner{}
+	getReport := func(context.Context) (map[string]string, error) {
+		return map[string]string{"ENCRYPTED": "ALL_SECRETS"}, nil
+	}
+
+	s, err := NewServer(Config{
+		ControlBindAddress: ":0",
+		TLSCertFile:        pki.serverCertFile,
+		TLSKeyFile:         pki.serverKeyFile,
+		ClientCAFile:       pki.caFile,
+		AllowedUsers:       []string{"ops-client"},
+	}, scanner, getReport, nil)
+	require.NoError(t, err)
+	ts := startControl(t, s)
+
+	withCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      pki.caPool,
+		Certificates: []tls.Certificate{pki.clientCert},
+	}}}
+	withoutCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pki.caPool}}}
+
+	resp, err := withCert.Get(ts.URL + "/report")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var report map[string]string
+	assert.NoError(t, json.Unmarshal(body, &report))
+	assert.Equal(t, "ALL_SECRETS", report["ENCRYPTED"])
+
+	resp, err = withCert.Post(ts.URL+"/scan", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, scanner.calls)
+
+	// Without a client certificate the TLS handshake itself is rejected
+	_, err = withoutCert.Get(ts.URL + "/report")
+	assert.Error(t, err)
+}
+
+func TestServer_ControlTokenAuth(t *testing.T) {
+	pki := newTestPKI(t)
+	scanner := &stubScanner{err: errors.New("etcd unavailable")}
+
+	s, err := NewServer(Config{
+		ControlBindAddress: ":0",
+		TLSCertFile:        pki.serverCertFile,
+		TLSKeyFile:         pki.serverKeyFile,
+		ClientCAFile:       pki.caFile,
+		TokenAuth:          true,
+		AllowedGroups:      []string{"ops"},
+	}, scanner, nil, newTokenReviewClientset("good-token"))
+	require.NoError(t, err)
+	ts := startControl(t, s)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pki.caPool}}}
+	post := func(token string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/scan", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post("").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, post("bad-token").StatusCode)
+	assert.Equal(t, 0, scanner.calls)
+
+	// Authenticated, but not in an allowed group
+	assert.Equal(t, http.StatusForbidden, post("other-token").StatusCode)
+	assert.Equal(t, 0, scanner.calls)
+
+	assert.Equal(t, http.StatusInternalServerError, post("good-token").StatusCode)
+	assert.Equal(t, 1, scanner.calls)
+
+	// A run skipped because another instance is running is a conflict
+	scanner.err = fmt.Errorf("%w: lease held", runner.ErrSkipped)
+	assert.Equal(t, http.StatusConflict, post("good-token").StatusCode)
+	assert.Equal(t, 2, scanner.calls)
+}
+
+func TestServer_Webhook(t *testing.T) {
+	pki := newTestPKI(t)
+	getReport := func(context.Context) (map[string]string, error) {
+		return map[string]string{"PROVIDER_COUNTS": `{"kmsprovider1":3}`}, nil
+	}
+	s, err := NewServer(Config{
+		WebhookBindAddress: ":0",
+		WebhookTLSCertFile: pki.serverCertFile,
+		WebhookTLSKeyFile:  pki.serverKeyFile,
+		Webhook:            webhook.Config{Namespace: "kube-system", Deny: true},
+	}, nil, getReport, nil)
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(s.webhook.Handler)
+	ts.TLS = s.webhook.TLSConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	configMap := func(providers ...string) []byte {
+		config := "resources:\n- resources: [secrets]\n  providers:\n"
+		for _, provider := range providers {
+			config += "  - kms: {name: " + provider + "}\n"
+		}
+		data, err := json.Marshal(map[string]any{"data": map[string]string{"encryption-provider-config.yaml": config}})
+		require.NoError(t, err)
+		return data
+	}
+	review, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "1234",
+			Operation: admissionv1.Update,
+			Namespace: "kube-system",
+			Name:      "encryption-provider-config",
+			OldObject: runtime.RawExtension{Raw: configMap("kmsprovider2", "kmsprovider1")},
+			Object:    runtime.RawExtension{Raw: configMap("kmsprovider2")},
+		},
+	})
+	require.NoError(t, err)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pki.caPool}}}
+	resp, err := client.Post(ts.URL+"/validate", "application/json", bytes.NewReader(review))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var response admissionv1.AdmissionReview
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	require.NotNil(t, response.Response)
+	assert.Equal(t, types.UID("1234"), response.Response.UID)
+	assert.False(t, response.Response.Allowed)
+	assert.Contains(t, response.Response.Result.Message, "3 secrets are still encrypted by the removed provider kmsprovider1")
+}
+
+func TestServer_Metrics(t *testing.T) {
+	s, err := NewServer(Config{MetricsBindAddress: ":0"}, nil, nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, s.control)
+
+	w := httptest.NewRecorder()
+	s.metrics.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
+
+func TestServer_Start(t *testing.T) {
+	s, err := NewServer(Config{MetricsBindAddress: "127.0.0.1:0"}, nil, nil, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, s.Start(ctx))
+
+	blocker, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer blocker.Close()
+
+	s, err = NewServer(Config{MetricsBindAddress: blocker.Addr().String()}, nil, nil, nil)
+	require.NoError(t, err)
+	err = s.Start(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to listen on metrics address")
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	assert.Empty(t, RequiredPermissions(Config{MetricsBindAddress: ":8080"}))
+	assert.Empty(t, RequiredPermissions(Config{ControlBindAddress: ":8443", ClientCAFile: "ca.crt"}))
+
+	permissions := RequiredPermissions(Config{ControlBindAddress: ":8443", TokenAuth: true})
+	assert.Len(t, permissions, 1)
+	assert.Equal(t, "tokenreviews", permissions[0].Resource)
+}
@@ -0,0 +1,60 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+)
+
+// ListNamespaces returns the namespaces holding keys under prefix, in key order. Keys are read without
+// their values, pageSize at a time, 0 reading every key in a single request, and every page continues
+// after the last namespace it holds, so that the keys of a namespace are mostly skipped. Keys without a
+// namespace segment are ignored. Pages after the first are read at the revision of the first page.
+func ListNamespaces(ctx context.Context, source analyzer.Source, prefix string, pageSize int64, timeout time.Duration) ([]string, error) {
+	base := prefix + "/"
+	keyRange := analyzer.PrefixRange(base)
+	var namespaces []string
+	var revision int64
+	key := keyRange.Start
+	for {
+		opts := []clientv3.OpOption{clientv3.WithRange(keyRange.End), clientv3.WithKeysOnly()}
+		if pageSize > 0 {
+			opts = append(opts, clientv3.WithLimit(pageSize))
+		}
+		if revision > 0 {
+			opts = append(opts, clientv3.WithRev(revision))
+		}
+		etcdCtx, cancel := context.WithTimeout(ctx, timeout)
+		resp, err := source.Get(etcdCtx, key, opts...)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list namespaces in etcd: %w: %w", etcd.ErrEtcdUnavailable, err)
+		}
+		if revision == 0 && resp.Header != nil {
+			revision = resp.Header.Revision
+		}
+		for _, kv := range resp.Kvs {
+			namespace, _, found := strings.Cut(strings.TrimPrefix(string(kv.Key), base), "/")
+			if !found || namespace == "" {
+				continue
+			}
+			if len(namespaces) == 0 || namespaces[len(namespaces)-1] != namespace {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			return namespaces, nil
+		}
+		// Continue after the last key read, and after the keys of the last namespace read
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+		if len(namespaces) > 0 {
+			key = max(key, analyzer.PrefixRange(base+namespaces[len(namespaces)-1]+"/").End)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package shard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+)
+
+// countingSource counts the requests made to a source
+type countingSource struct {
+	analyzer.Source
+	requests int
+}
+
+func (s *countingSource) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	s.requests++
+	return s.Source.Get(ctx, key, opts...)
+}
+
+func TestListNamespaces(t *testing.T) {
+	var kvs []*mvccpb.KeyValue
+	for _, key := range []string{
+		"/registry/secrets/a-b/one",
+		"/registry/secrets/a/one",
+		"/registry/secrets/a/two",
+		"/registry/secrets/a/three",
+		"/registry/secrets/default/one",
+		"/registry/secrets/invalid",
+		"/registry/secrets/kube-system/one",
+		"/registry/secrets/kube-system/two",
+		"/registry/configmaps/other/one",
+	} {
+		kvs = append(kvs, &mvccpb.KeyValue{Key: []byte(key), Value: []byte("k8s:enc:kms:v2:kmsprovider1:data")})
+	}
+	expected := []string{"a-b", "a", "default", "kube-system"}
+
+	for _, tt := range []struct {
+		name     string
+		pageSize int64
+		requests int
+	}{
+		{name: "single request", requests: 1},
+		// The pages skip the remaining keys of their last namespace
+		{name: "paginated", pageSize: 2, requests: 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &countingSource{Source: etcd.NewMemoryClient(kvs)}
+			namespaces, err := ListNamespaces(context.Background(), source, analyzer.DefaultPrefix, tt.pageSize, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, expected, namespaces)
+			assert.Equal(t, tt.requests, source.requests)
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Package shard splits the etcd secret key space across reporter replicas and merges their partial results.
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+// namespaceAlphabet holds the characters a namespace can start with, in key order.
+// Without explicit boundaries, shards split it evenly.
+const namespaceAlphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// Mode selects how namespaces are assigned to shards.
+type Mode string
+
+const (
+	// ModeRange assigns each shard a contiguous range of namespaces, see Config.KeyRange.
+	ModeRange Mode = "range"
+	// ModeHash assigns each namespace to the shard its hash maps to, see Config.NamespaceRanges. Shards
+	// are balanced whatever the names of the namespaces, but each shard lists the namespaces first and
+	// reads every namespace it owns separately.
+	ModeHash Mode = "hash"
+)
+
+// Config selects the shard scanned by this replica.
+type Config struct {
+	// Index is the shard of this replica, in [0, Count). Shard 0 merges the final report.
+	Index int
+	// Count is the number of shards. 1 disables sharding.
+	Count int
+	// Mode selects how namespaces are assigned to shards. Defaults to ModeRange.
+	Mode Mode
+	// Boundaries optionally lists the Count-1 namespace prefixes that separate the shards, in increasing order.
+	// Without boundaries, shards split the first character of the namespace evenly. Only with ModeRange.
+	Boundaries []string
+}
+
+// Enabled reports whether the key space is split across more than one replica.
+func (c Config) Enabled() bool {
+	return c.Count > 1
+}
+
+// IsLeader reports whether this replica merges the partial results into the final report.
+func (c Config) IsLeader() bool {
+	return c.Index == 0
+}
+
+// Validate checks that the shard index and boundaries are consistent with the shard count.
+func (c Config) Validate() error {
+	if c.Count < 1 {
+		return fmt.Errorf("shard count must be at least 1, got %d", c.Count)
+	}
+	if c.Index < 0 || c.Index >= c.Count {
+		return fmt.Errorf("shard index %d is out of range [0, %d)", c.Index, c.Count)
+	}
+	switch c.Mode {
+	case "", ModeRange:
+	case ModeHash:
+		if len(c.Boundaries) > 0 {
+			return fmt.Errorf("shard boundaries are not supported in %s mode", ModeHash)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown shard mode %q, expected %s or %s", c.Mode, ModeRange, ModeHash)
+	}
+	if len(c.Boundaries) == 0 {
+		if c.Count > len(namespaceAlphabet) {
+			return fmt.Errorf("shard count %d requires explicit shard boundaries", c.Count)
+		}
+		return nil
+	}
+	if len(c.Boundaries) != c.Count-1 {
+		return fmt.Errorf("%d shards require %d boundaries, got %d", c.Count, c.Count-1, len(c.Boundaries))
+	}
+	for i, boundary := range c.Boundaries {
+		if boundary == "" {
+			return fmt.Errorf("shard boundary %d is empty", i)
+		}
+		if i > 0 && c.Boundaries[i-1] >= boundary {
+			return fmt.Errorf("shard boundaries must be in strictly increasing order")
+		}
+	}
+	return nil
+}
+
+// KeyRange returns the keys under prefix scanned by this shard in ModeRange. The ranges of all shards
+// are contiguous and together cover every key under prefix.
+func (c Config) KeyRange(prefix string) analyzer.KeyRange {
+	base := prefix + "/"
+	full := analyzer.PrefixRange(base)
+	if !c.Enabled() {
+		return full
+	}
+
+	boundary := func(i int) string {
+		if len(c.Boundaries) > 0 {
+			return base + c.Boundaries[i-1]
+		}
+		return base + string(namespaceAlphabet[i*len(namespaceAlphabet)/c.Count])
+	}
+
+	keyRange := full
+	if c.Index > 0 {
+		keyRange.Start = boundary(c.Index)
+	}
+	if c.Index < c.Count-1 {
+		keyRange.End = boundary(c.Index + 1)
+	}
+	return keyRange
+}
+
+// Hashed reports whether namespaces are assigned to shards by hash.
+func (c Config) Hashed() bool {
+	return c.Enabled() && c.Mode == ModeHash
+}
+
+// HashIndex returns the shard namespace is assigned to in ModeHash, among count shards.
+func HashIndex(namespace string, count int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(namespace))
+	return int(hash.Sum32() % uint32(count))
+}
+
+// NamespaceRanges returns the key ranges under prefix of the namespaces assigned to this shard in
+// ModeHash, one per namespace, in the order of namespaces.
+func (c Config) NamespaceRanges(prefix string, namespaces []string) []analyzer.KeyRange {
+	var ranges []analyzer.KeyRange
+	for _, namespace := range namespaces {
+		if HashIndex(namespace, c.Count) == c.Index {
+			ranges = append(ranges, analyzer.PrefixRange(prefix+"/"+namespace+"/"))
+		}
+	}
+	return ranges
+}
+
+// IndexFromHostname returns the ordinal suffix of a StatefulSet pod hostname, e.g. 2 for "kms-reporter-2".
+func IndexFromHostname(hostname string) (int, error) {
+	i := strings.LastIndex(hostname, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("hostname %q has no ordinal suffix", hostname)
+	}
+	index, err := strconv.Atoi(hostname[i+1:])
+	if err != nil {
+		return 0, fmt.Errorf("hostname %q has no ordinal suffix: %w", hostname, err)
+	}
+	return index, nil
+}
+
+// Merge combines the partial results of all shards into the result of a full scan.
+func Merge(partials []analyzer.Result) analyzer.Result {
+	merged := analyzer.Result{
+		EncryptedSecrets:            []string{},
+		UnencryptedSecrets:          []string{},
+		AllSecretsUseLatestProvider: true,
+		ProviderCounts:              map[string]int{},
+	}
+
+	// Every shard lists the same number of largest and oldest secrets, unless it has fewer secrets
+	largest, oldest := 0, 0
+	for _, partial := range partials {
+		largest = max(largest, len(partial.LargestSecrets))
+		oldest = max(oldest, len(partial.OldestSecrets))
+	}
+
+	latestSet := false
+	for _, partial := range partials {
+		for _, secret := range partial.LargestSecrets {
+			merged.LargestSecrets = analyzer.InsertLargest(merged.LargestSecrets, secret, largest)
+		}
+		for _, secret := range partial.OldestSecrets {
+			merged.OldestSecrets = analyzer.InsertOldest(merged.OldestSecrets, secret, oldest)
+		}
+		merged.EncryptedSecrets = append(merged.EncryptedSecrets, partial.EncryptedSecrets...)
+		merged.UnencryptedSecrets = append(merged.UnencryptedSecrets, partial.UnencryptedSecrets...)
+		merged.OmittedEncrypted += partial.OmittedEncrypted
+		merged.OmittedUnencrypted += partial.OmittedUnencrypted
+		merged.UnrecognizedSecrets = append(merged.UnrecognizedSecrets, partial.UnrecognizedSecrets...)
+		merged.OmittedUnrecognized += partial.OmittedUnrecognized
+		for encoding, count := range partial.EncodingCounts {
+			if merged.EncodingCounts == nil {
+				merged.EncodingCounts = map[string]int{}
+			}
+			merged.EncodingCounts[encoding] += count
+		}
+		for provider, count := range partial.ProviderCounts {
+			merged.ProviderCounts[provider] += count
+		}
+		for seq, count := range partial.SequenceCounts {
+			if merged.SequenceCounts == nil {
+				merged.SequenceCounts = map[int]int{}
+			}
+			merged.SequenceCounts[seq] += count
+		}
+		for providerType, count := range partial.NonKMSCounts {
+			if merged.NonKMSCounts == nil {
+				merged.NonKMSCounts = map[string]int{}
+			}
+			merged.NonKMSCounts[providerType] += count
+		}
+		for namespace, count := range partial.StaleNamespaces {
+			if merged.StaleNamespaces == nil {
+				merged.StaleNamespaces = map[string]int{}
+			}
+			merged.StaleNamespaces[namespace] += count
+		}
+		if !partial.AllSecretsUseLatestProvider {
+			merged.AllSecretsUseLatestProvider = false
+		}
+		// Shards are read at different revisions, the merged result reports the most recent one
+		merged.Revision = max(merged.Revision, partial.Revision)
+		if merged.ClusterID == 0 {
+			merged.ClusterID = partial.ClusterID
+		}
+		merged.Scan = merged.Scan.Add(partial.Scan)
+		merged.Skew = merged.Skew.Add(partial.Skew)
+
+		// Empty shards never resolve the latest provider
+		if partial.Total() == 0 {
+			continue
+		}
+		if !latestSet {
+			merged.LatestProvider = partial.LatestProvider
+			latestSet = true
+		} else if merged.LatestProvider != partial.LatestProvider {
+			// Shards scanned on either side of a provider rotation cannot all be on the latest provider
+			merged.AllSecretsUseLatestProvider = false
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,196 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        Config
+		expectedError string
+	}{
+		{name: "sharding disabled", config: Config{Count: 1}},
+		{name: "even split", config: Config{Index: 2, Count: 3}},
+		{name: "explicit boundaries", config: Config{Index: 1, Count: 3, Boundaries: []string{"g", "kube"}}},
+		{name: "zero shards", config: Config{Count: 0}, expectedError: "must be at least 1"},
+		{name: "index out of range", config: Config{Index: 3, Count: 3}, expectedError: "out of range"},
+		{name: "negative index", config: Config{Index: -1, Count: 3}, expectedError: "out of range"},
+		{name: "too many shards for an even split", config: Config{Count: 37}, expectedError: "requires explicit shard boundaries"},
+		{name: "wrong number of boundaries", config: Config{Count: 3, Boundaries: []string{"m"}}, expectedError: "3 shards require 2 boundaries"},
+		{name: "unsorted boundaries", config: Config{Count: 3, Boundaries: []string{"t", "g"}}, expectedError: "strictly increasing"},
+		{name: "duplicate boundaries", config: Config{Count: 3, Boundaries: []string{"g", "g"}}, expectedError: "strictly increasing"},
+		{name: "empty boundary", config: Config{Count: 2, Boundaries: []string{""}}, expectedError: "is empty"},
+		{name: "hash", config: Config{Index: 1, Count: 64, Mode: ModeHash}},
+		{name: "hash with boundaries", config: Config{Count: 2, Mode: ModeHash, Boundaries: []string{"m"}}, expectedError: "not supported in hash mode"},
+		{name: "unknown mode", config: Config{Count: 2, Mode: "random"}, expectedError: "unknown shard mode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConfig_KeyRange(t *testing.T) {
+	prefix := analyzer.DefaultPrefix
+	full := analyzer.PrefixRange(prefix + "/")
+
+	assert.Equal(t, full, Config{Count: 1}.KeyRange(prefix))
+
+	tests := []struct {
+		name       string
+		boundaries []string
+		expected   []analyzer.KeyRange
+	}{
+		{
+			name: "even split by first character",
+			expected: []analyzer.KeyRange{
+				{Start: full.Start, End: prefix + "/c"},
+				{Start: prefix + "/c", End: prefix + "/o"},
+				{Start: prefix + "/o", End: full.End},
+			},
+		},
+		{
+			name:       "explicit boundaries",
+			boundaries: []string{"default", "kube-system"},
+			expected: []analyzer.KeyRange{
+				{Start: full.Start, End: prefix + "/default"},
+				{Start: prefix + "/default", End: prefix + "/kube-system"},
+				{Start: prefix + "/kube-system", End: full.End},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for index, expected := range tt.expected {
+				config := Config{Index: index, Count: len(tt.expected), Boundaries: tt.boundaries}
+				assert.NoError(t, config.Validate())
+				assert.Equal(t, expected, config.KeyRange(prefix))
+			}
+		})
+	}
+}
+
+func TestConfig_NamespaceRanges(t *testing.T) {
+	prefix := analyzer.DefaultPrefix
+	namespaces := []string{"default", "kube-system", "team-a", "team-b", "team-c", "team-d"}
+
+	// Every namespace is scanned by exactly one shard
+	scanned := map[analyzer.KeyRange]int{}
+	for index := range 3 {
+		config := Config{Index: index, Count: 3, Mode: ModeHash}
+		assert.True(t, config.Hashed())
+		for _, keyRange := range config.NamespaceRanges(prefix, namespaces) {
+			scanned[keyRange]++
+		}
+	}
+	assert.Len(t, scanned, len(namespaces))
+	for _, namespace := range namespaces {
+		assert.Equal(t, 1, scanned[analyzer.PrefixRange(prefix+"/"+namespace+"/")], namespace)
+	}
+
+	// Replicas running different versions must agree on the assignment, so the hash never changes
+	assert.Equal(t, 0, HashIndex("default", 3))
+	assert.Equal(t, 2, HashIndex("kube-system", 3))
+	assert.Equal(t, 1, HashIndex("team-a", 3))
+	assert.False(t, Config{Count: 3}.Hashed())
+}
+
+func TestIndexFromHostname(t *testing.T) {
+	index, err := IndexFromHostname("kms-reporter-12")
+	assert.NoError(t, err)
+	assert.Equal(t, 12, index)
+
+	_, err = IndexFromHostname("kms-reporter-7c9d8b5f4-x2x9q")
+	assert.Error(t, err)
+
+	_, err = IndexFromHostname("localhost")
+	assert.Error(t, err)
+}
+
+func TestMerge(t *testing.T) {
+	latest := analyzer.LatestProvider{Name: "kmsprovider2", Seq: 2}
+	partials := []analyzer.Result{
+		{
+			EncryptedSecrets:            []string{"default/a"},
+			UnencryptedSecrets:          []string{},
+			AllSecretsUseLatestProvider: true,
+			ProviderCounts:              map[string]int{"kmsprovider2": 1},
+			SequenceCounts:              map[int]int{2: 1},
+			LatestProvider:              latest,
+			Revision:                    40,
+			ClusterID:                   0xcdf818194e3a8c32,
+			LargestSecrets:              []analyzer.SecretSize{{Name: "default/a", Size: 300}},
+			Skew:                        &analyzer.RevisionSkew{Revision: 45, Modified: 1, ModifiedSecrets: []string{"default/f"}, Created: 1},
+		},
+		{
+			// Empty shard: the latest provider was never resolved
+			EncryptedSecrets:            []string{},
+			UnencryptedSecrets:          []string{},
+			AllSecretsUseLatestProvider: true,
+			ProviderCounts:              map[string]int{},
+		},
+		{
+			EncryptedSecrets:            []string{"kube-system/b", "kube-system/c"},
+			UnencryptedSecrets:          []string{"kube-system/d"},
+			OmittedEncrypted:            2,
+			AllSecretsUseLatestProvider: false,
+			ProviderCounts:              map[string]int{"kmsprovider1": 2, "kmsprovider2": 2, "aescbc": 1},
+			SequenceCounts:              map[int]int{1: 2, 2: 2},
+			NonKMSCounts:                map[string]int{"aescbc": 1},
+			UnrecognizedSecrets:         []string{"kube-system/e"},
+			EncodingCounts:              map[string]int{"protobuf": 1},
+			StaleNamespaces:             map[string]int{"kube-system": 3},
+			LatestProvider:              latest,
+			Revision:                    42,
+			ClusterID:                   0xcdf818194e3a8c32,
+			LargestSecrets:              []analyzer.SecretSize{{Name: "kube-system/b", Size: 500}, {Name: "kube-system/c", Size: 200}},
+			OldestSecrets:               []analyzer.SecretProvider{{Name: "kube-system/b", Provider: "kmsprovider1", Seq: 1}, {Name: "kube-system/c", Provider: "kmsprovider1", Seq: 1}},
+			Skew:                        &analyzer.RevisionSkew{Revision: 44, Modified: 1, ModifiedSecrets: []string{"kube-system/b"}, Deleted: 1},
+		},
+	}
+
+	merged := Merge(partials)
+	assert.Equal(t, []string{"default/a", "kube-system/b", "kube-system/c"}, merged.EncryptedSecrets)
+	assert.Equal(t, []string{"kube-system/d"}, merged.UnencryptedSecrets)
+	assert.Equal(t, 2, merged.OmittedEncrypted)
+	assert.Equal(t, []string{"kube-system/e"}, merged.UnrecognizedSecrets)
+	assert.Equal(t, map[string]int{"protobuf": 1}, merged.EncodingCounts)
+	assert.Equal(t, map[string]int{"kube-system": 3}, merged.StaleNamespaces)
+	assert.Equal(t, 7, merged.Total())
+	assert.Equal(t, map[string]int{"kmsprovider1": 2, "kmsprovider2": 3, "aescbc": 1}, merged.ProviderCounts)
+	assert.Equal(t, map[int]int{1: 2, 2: 3}, merged.SequenceCounts)
+	assert.Equal(t, map[string]int{"aescbc": 1}, merged.NonKMSCounts)
+	assert.Equal(t, latest, merged.LatestProvider)
+	assert.False(t, merged.AllSecretsUseLatestProvider)
+	assert.Equal(t, int64(42), merged.Revision)
+	assert.Equal(t, uint64(0xcdf818194e3a8c32), merged.ClusterID)
+	assert.Equal(t, []analyzer.SecretSize{{Name: "kube-system/b", Size: 500}, {Name: "default/a", Size: 300}}, merged.LargestSecrets)
+	assert.Equal(t, []analyzer.SecretProvider{{Name: "kube-system/b", Provider: "kmsprovider1", Seq: 1}, {Name: "kube-system/c", Provider: "kmsprovider1", Seq: 1}}, merged.OldestSecrets)
+	assert.Equal(t, &analyzer.RevisionSkew{Revision: 45, Modified: 2, ModifiedSecrets: []string{"default/f", "kube-system/b"}, Created: 1, Deleted: 1}, merged.Skew)
+
+	// Shards that resolved different latest providers straddle a rotation
+	merged = Merge([]analyzer.Result{partials[0], {
+		EncryptedSecrets:            []string{"kube-system/b"},
+		AllSecretsUseLatestProvider: true,
+		ProviderCounts:              map[string]int{"kmsprovider3": 1},
+		LatestProvider:              analyzer.LatestProvider{Name: "kmsprovider3", Seq: 3},
+	}})
+	assert.False(t, merged.AllSecretsUseLatestProvider)
+
+	merged = Merge([]analyzer.Result{partials[0], partials[1]})
+	assert.True(t, merged.AllSecretsUseLatestProvider)
+}
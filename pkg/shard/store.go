@@ -0,0 +1,257 @@
+package shard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+const (
+	// partialConfigMapPrefix is followed by the shard index in the name of a partial result ConfigMap
+	partialConfigMapPrefix = "kms-reporter-shard-"
+	partialResultKey       = "RESULT"
+	// partialSavedAtKey and partialRevisionKey stamp a partial result with when it was saved and the
+	// etcd revision it was read at, so that the partial of a dead or lagging shard is not merged forever
+	partialSavedAtKey  = "SAVED_AT"
+	partialRevisionKey = "REVISION"
+
+	// managedByLabel marks the partial result ConfigMaps as written by the reporter, like its reports, and
+	// shardLabel holds their shard index, so that the leader only ever lists and prunes partial results
+	managedByLabel = "app.kubernetes.io/managed-by"
+	reporterName   = "kms-reporter"
+	shardLabel     = "kms-reporter/shard"
+)
+
+// ErrIncomplete is returned by Store.Load when some shards have not saved a partial result yet, or
+// their last one is stale.
+var ErrIncomplete = errors.New("partial results of some shards are missing")
+
+// Store persists the partial result of each shard until the leader merges them.
+type Store interface {
+	Save(ctx context.Context, namespace string, index int, result analyzer.Result) error
+	Load(ctx context.Context, namespace string, count int) ([]analyzer.Result, error)
+	// Prune deletes the partial results of the shards from count up, left by a scale-down.
+	Prune(ctx context.Context, namespace string, count int) error
+}
+
+// ConfigMapStore stores each partial result in its own ConfigMap.
+type ConfigMapStore struct {
+	Clientset kubernetes.Interface
+	// RequestTimeout bounds each Kubernetes API call. 0 disables the limit.
+	RequestTimeout time.Duration
+	// MaxAge is the age after which a partial result is stale and not merged, e.g. the run interval.
+	// 0 merges partial results of any age.
+	MaxAge     time.Duration
+	marshaller utils.Marshaller
+}
+
+func NewConfigMapStore(clientset kubernetes.Interface, requestTimeout, maxAge time.Duration) Store {
+	return &ConfigMapStore{
+		Clientset:      clientset,
+		RequestTimeout: requestTimeout,
+		MaxAge:         maxAge,
+		marshaller:     utils.JSONMarshaller{},
+	}
+}
+
+// RequiredPermissions lists the Kubernetes API access the ConfigMap store needs in the given namespace.
+// Only the leader reads the partial results of other shards, and prunes those of removed shards. It only
+// deletes the ConfigMaps listed with the shard label, which RBAC cannot express.
+func RequiredPermissions(namespace string, config Config) []rbac.Permission {
+	if !config.Enabled() {
+		return nil
+	}
+	permissions := []rbac.Permission{
+		{Verb: "get", Resource: "configmaps", Namespace: namespace, Name: partialConfigMapName(config.Index)},
+		{Verb: "create", Resource: "configmaps", Namespace: namespace},
+		{Verb: "update", Resource: "configmaps", Namespace: namespace, Name: partialConfigMapName(config.Index)},
+	}
+	if config.IsLeader() {
+		for index := 1; index < config.Count; index++ {
+			permissions = append(permissions, rbac.Permission{Verb: "get", Resource: "configmaps", Namespace: namespace, Name: partialConfigMapName(index)})
+		}
+		// The shards beyond the count are not known in advance
+		permissions = append(permissions,
+			rbac.Permission{Verb: "list", Resource: "configmaps", Namespace: namespace},
+			rbac.Permission{Verb: "delete", Resource: "configmaps", Namespace: namespace},
+		)
+	}
+	return permissions
+}
+
+func partialConfigMapName(index int) string {
+	return partialConfigMapPrefix + strconv.Itoa(index)
+}
+
+// PartialSelector selects the partial result ConfigMaps of every shard.
+var PartialSelector = managedByLabel + "=" + reporterName + "," + shardLabel
+
+// PartialConfigMapNames returns the names of the partial result ConfigMaps of the shards of config, or nil
+// if sharding is disabled.
+func PartialConfigMapNames(config Config) []string {
+	if !config.Enabled() {
+		return nil
+	}
+	names := make([]string, 0, config.Count)
+	for index := 0; index < config.Count; index++ {
+		names = append(names, partialConfigMapName(index))
+	}
+	return names
+}
+
+// setMetadata labels the partial result ConfigMap of shard index, and removes the mark of an unused one.
+func setMetadata(configMap *v1.ConfigMap, index int) {
+	if configMap.Labels == nil {
+		configMap.Labels = map[string]string{}
+	}
+	configMap.Labels[managedByLabel] = reporterName
+	configMap.Labels[shardLabel] = strconv.Itoa(index)
+	delete(configMap.Annotations, recorder.UnusedSinceAnnotation)
+}
+
+// Save creates or updates the partial result ConfigMap of shard index, stamped with the current time
+// and the revision of result.
+func (s *ConfigMapStore) Save(ctx context.Context, namespace string, index int, result analyzer.Result) error {
+	marshalled, err := s.marshaller.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal partial result: %w", err)
+	}
+	data := map[string]string{
+		partialResultKey:   string(marshalled),
+		partialSavedAtKey:  time.Now().UTC().Format(time.RFC3339),
+		partialRevisionKey: strconv.FormatInt(result.Revision, 10),
+	}
+
+	name := partialConfigMapName(index)
+	getCtx, cancel := utils.ContextWithTimeout(ctx, s.RequestTimeout)
+	defer cancel()
+	configMap, err := s.Clientset.CoreV1().ConfigMaps(namespace).Get(getCtx, name, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to get ConfigMap %s: %w", name, err)
+		}
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       data,
+		}
+		setMetadata(configMap, index)
+		if err := recorder.CheckConfigMapSize(configMap); err != nil {
+			return err
+		}
+		createCtx, cancel := utils.ContextWithTimeout(ctx, s.RequestTimeout)
+		defer cancel()
+		if _, err := s.Clientset.CoreV1().ConfigMaps(namespace).Create(createCtx, configMap, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create ConfigMap %s: %w", name, err)
+		}
+		return nil
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	for key, value := range data {
+		configMap.Data[key] = value
+	}
+	setMetadata(configMap, index)
+	if err := recorder.CheckConfigMapSize(configMap); err != nil {
+		return err
+	}
+	updateCtx, cancel := utils.ContextWithTimeout(ctx, s.RequestTimeout)
+	defer cancel()
+	if _, err := s.Clientset.CoreV1().ConfigMaps(namespace).Update(updateCtx, configMap, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update ConfigMap %s: %w", name, err)
+	}
+	return nil
+}
+
+// Load returns the partial results of all count shards, ordered by shard index.
+// It returns ErrIncomplete, listing the missing and stale shards, if any shard has not saved a result
+// yet or saved its last one more than MaxAge ago. A result saved without a time is stale.
+func (s *ConfigMapStore) Load(ctx context.Context, namespace string, count int) ([]analyzer.Result, error) {
+	partials := make([]analyzer.Result, 0, count)
+	var missing, stale []string
+	now := time.Now()
+	for index := 0; index < count; index++ {
+		name := partialConfigMapName(index)
+		getCtx, cancel := utils.ContextWithTimeout(ctx, s.RequestTimeout)
+		configMap, err := s.Clientset.CoreV1().ConfigMaps(namespace).Get(getCtx, name, metav1.GetOptions{})
+		cancel()
+		if apierrors.IsNotFound(err) {
+			missing = append(missing, strconv.Itoa(index))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ConfigMap %s: %w", name, err)
+		}
+
+		data, exists := configMap.Data[partialResultKey]
+		if !exists {
+			missing = append(missing, strconv.Itoa(index))
+			continue
+		}
+		if s.MaxAge > 0 {
+			savedAt, err := time.Parse(time.RFC3339, configMap.Data[partialSavedAtKey])
+			if err != nil || now.Sub(savedAt) > s.MaxAge {
+				stale = append(stale, fmt.Sprintf("%d (saved at %q, revision %s)", index, configMap.Data[partialSavedAtKey], configMap.Data[partialRevisionKey]))
+				continue
+			}
+		}
+		var partial analyzer.Result
+		if err := json.Unmarshal([]byte(data), &partial); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal partial result of shard %d: %w", index, err)
+		}
+		partials = append(partials, partial)
+	}
+
+	var reasons []string
+	if len(missing) > 0 {
+		reasons = append(reasons, "shards "+strings.Join(missing, ", "))
+	}
+	if len(stale) > 0 {
+		reasons = append(reasons, "stale shards "+strings.Join(stale, ", "))
+	}
+	if len(reasons) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrIncomplete, strings.Join(reasons, "; "))
+	}
+	return partials, nil
+}
+
+// Prune deletes the partial result ConfigMaps of the shards from count up. They are listed by their shard
+// label, so no other ConfigMap of the namespace is ever deleted.
+func (s *ConfigMapStore) Prune(ctx context.Context, namespace string, count int) error {
+	listCtx, cancel := utils.ContextWithTimeout(ctx, s.RequestTimeout)
+	defer cancel()
+	configMaps, err := s.Clientset.CoreV1().ConfigMaps(namespace).List(listCtx, metav1.ListOptions{LabelSelector: PartialSelector})
+	if err != nil {
+		return fmt.Errorf("failed to list the partial results: %w", err)
+	}
+	for _, configMap := range configMaps.Items {
+		index, err := strconv.Atoi(configMap.Labels[shardLabel])
+		if err != nil || index < count || configMap.Name != partialConfigMapName(index) {
+			continue
+		}
+		deleteCtx, cancel := utils.ContextWithTimeout(ctx, s.RequestTimeout)
+		err = s.Clientset.CoreV1().ConfigMaps(namespace).Delete(deleteCtx, configMap.Name, metav1.DeleteOptions{})
+		cancel()
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete ConfigMap %s: %w", configMap.Name, err)
+		}
+		klog.InfoS("Deleted the partial result of a removed shard", "configMap", configMap.Name)
+	}
+	return nil
+}
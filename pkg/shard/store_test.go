@@ -0,0 +1,126 @@
+package shard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+func TestConfigMapStore(t *testing.T) {
+	store := NewConfigMapStore(fake.NewSimpleClientset(), 0, 0)
+	ctx := context.Background()
+
+	first := analyzer.Result{
+		EncryptedSecrets:            []string{"default/a"},
+		UnencryptedSecrets:          []string{},
+		AllSecretsUseLatestProvider: true,
+		ProviderCounts:              map[string]int{"kmsprovider1": 1},
+		LatestProvider:              analyzer.LatestProvider{Name: "kmsprovider1", Seq: 1},
+	}
+	assert.NoError(t, store.Save(ctx, "kms", 0, first))
+
+	_, err := store.Load(ctx, "kms", 2)
+	assert.ErrorIs(t, err, ErrIncomplete)
+	assert.Contains(t, err.Error(), "shards 1")
+
+	second := analyzer.Result{
+		EncryptedSecrets:   []string{},
+		UnencryptedSecrets: []string{"kube-system/b"},
+		ProviderCounts:     map[string]int{"identity": 1},
+	}
+	assert.NoError(t, store.Save(ctx, "kms", 1, second))
+	// Saving again updates the existing ConfigMap
+	first.EncryptedSecrets = append(first.EncryptedSecrets, "default/c")
+	first.ProviderCounts["kmsprovider1"] = 2
+	assert.NoError(t, store.Save(ctx, "kms", 0, first))
+
+	partials, err := store.Load(ctx, "kms", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []analyzer.Result{first, second}, partials)
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	assert.Empty(t, RequiredPermissions("kms", Config{Count: 1}))
+
+	permissions := RequiredPermissions("kms", Config{Index: 2, Count: 3})
+	assert.Len(t, permissions, 3)
+	assert.Equal(t, "kms-reporter-shard-2", permissions[0].Name)
+
+	// The leader also reads the partial results of the other shards, and deletes those of removed shards
+	permissions = RequiredPermissions("kms", Config{Index: 0, Count: 3})
+	assert.Len(t, permissions, 7)
+	assert.Equal(t, "kms-reporter-shard-2", permissions[4].Name)
+	assert.Equal(t, "list", permissions[5].Verb)
+	assert.Equal(t, "delete", permissions[6].Verb)
+}
+
+func TestConfigMapStore_Stale(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	store := NewConfigMapStore(clientset, 0, time.Hour)
+	ctx := context.Background()
+
+	assert.NoError(t, store.Save(ctx, "kms", 0, analyzer.Result{Revision: 12}))
+	assert.NoError(t, store.Save(ctx, "kms", 1, analyzer.Result{Revision: 12}))
+	configMap, err := clientset.CoreV1().ConfigMaps("kms").Get(ctx, "kms-reporter-shard-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "12", configMap.Data[partialRevisionKey])
+	partials, err := store.Load(ctx, "kms", 2)
+	assert.NoError(t, err)
+	assert.Len(t, partials, 2)
+
+	// The partial of a shard that stopped saving is not merged
+	configMap.Data[partialSavedAtKey] = time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	_, err = clientset.CoreV1().ConfigMaps("kms").Update(ctx, configMap, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	_, err = store.Load(ctx, "kms", 2)
+	assert.ErrorIs(t, err, ErrIncomplete)
+	assert.Contains(t, err.Error(), "stale shards 1")
+
+	// Nor is one saved without a time, by a previous version
+	delete(configMap.Data, partialSavedAtKey)
+	_, err = clientset.CoreV1().ConfigMaps("kms").Update(ctx, configMap, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	_, err = store.Load(ctx, "kms", 2)
+	assert.ErrorIs(t, err, ErrIncomplete)
+
+	// Until the shard saves again
+	assert.NoError(t, store.Save(ctx, "kms", 1, analyzer.Result{Revision: 13}))
+	_, err = store.Load(ctx, "kms", 2)
+	assert.NoError(t, err)
+}
+
+func TestConfigMapStore_Prune(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	store := NewConfigMapStore(clientset, 0, 0)
+	ctx := context.Background()
+	for index := 0; index < 4; index++ {
+		assert.NoError(t, store.Save(ctx, "kms", index, analyzer.Result{}))
+	}
+	configMap, err := clientset.CoreV1().ConfigMaps("kms").Get(ctx, "kms-reporter-shard-3", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"app.kubernetes.io/managed-by": "kms-reporter", "kms-reporter/shard": "3"}, configMap.Labels)
+	// Other ConfigMaps of the namespace are never deleted, whatever their name
+	_, err = clientset.CoreV1().ConfigMaps("kms").Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kms-reporter-shard-5", Namespace: "kms"}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// Scaled down from 4 shards to 2
+	assert.NoError(t, store.Prune(ctx, "kms", 2))
+	configMaps, err := clientset.CoreV1().ConfigMaps("kms").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	var names []string
+	for _, configMap := range configMaps.Items {
+		names = append(names, configMap.Name)
+	}
+	assert.ElementsMatch(t, []string{"kms-reporter-shard-0", "kms-reporter-shard-1", "kms-reporter-shard-5"}, names)
+
+	// Nothing left to delete
+	assert.NoError(t, store.Prune(ctx, "kms", 2))
+}
@@ -0,0 +1,193 @@
+// Package simulation evaluates a proposed encryption configuration against the secrets stored in etcd
+// before it is applied: which provider the API server would write secrets with, how many secrets would
+// then be stale, and which ones it could no longer decrypt, e.g. because their provider was removed.
+package simulation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+)
+
+// Outcomes of the secrets of a provider under the proposed configuration
+const (
+	OutcomeUpToDate      = "up to date"
+	OutcomeStale         = "stale"
+	OutcomeUndecryptable = "undecryptable"
+)
+
+// Config configures Simulate.
+type Config struct {
+	// Analyzer configures the scan. Its Prefix selects the resource, secrets by default, whose entry of
+	// the proposed configuration is evaluated. Its provider comparison, LatestProvider and Findings are not
+	// used: like the API server, the simulation matches providers by name.
+	Analyzer analyzer.Config
+	// MaxSecretNames bounds the names kept in Result.UndecryptableSecrets. 0 keeps them all.
+	MaxSecretNames int
+}
+
+// Result is the outcome of a proposed configuration. Providers are named as in
+// analyzer.Result.ProviderCounts: KMS providers by name, secrets stored in plaintext under
+// analyzer.IdentityProviderName, and secrets encrypted by other providers under their type, e.g. "aescbc",
+// whose keys are not compared.
+type Result struct {
+	// WriteProvider is the provider the API server would write the secrets with: the first provider of the
+	// entry of the configuration covering them, or identity if none does.
+	WriteProvider string
+	// ReadProviders are the providers the API server could decrypt the secrets with, in order.
+	ReadProviders []string
+	// ProviderCounts maps the provider each secret is stored with to the number of secrets.
+	ProviderCounts map[string]int
+	// UpToDate counts the secrets stored with WriteProvider, Stale those stored with another of the
+	// ReadProviders, which a rewrite would move to WriteProvider, and Undecryptable those stored with a
+	// provider that is not one of ReadProviders, which the API server could no longer read.
+	UpToDate      int
+	Stale         int
+	Undecryptable int
+	// UndecryptableSecrets are the first Config.MaxSecretNames undecryptable secrets.
+	UndecryptableSecrets []string
+}
+
+// Total returns the number of secrets evaluated.
+func (r Result) Total() int {
+	return r.UpToDate + r.Stale + r.Undecryptable
+}
+
+// Outcome returns the outcome of the secrets stored with provider.
+func (r Result) Outcome(provider string) string {
+	switch {
+	case provider == r.WriteProvider:
+		return OutcomeUpToDate
+	case slices.Contains(r.ReadProviders, provider):
+		return OutcomeStale
+	default:
+		return OutcomeUndecryptable
+	}
+}
+
+// Simulate scans the secrets of source and evaluates them against the proposed encryption configuration.
+func Simulate(ctx context.Context, source analyzer.Source, proposed analyzer.EncryptionConfiguration, config Config) (Result, error) {
+	prefix := config.Analyzer.Prefix
+	if prefix == "" {
+		prefix = analyzer.DefaultPrefix
+	}
+	result := newResult(proposed, analyzer.ResourceFromPrefix(prefix))
+
+	analyzerConfig := config.Analyzer
+	analyzerConfig.Comparison = analyzer.ComparisonName
+	analyzerConfig.ProviderMatcher = nil
+	analyzerConfig.LatestProvider = analyzer.StaticProvider(analyzer.LatestProvider{Name: result.WriteProvider})
+	// The names in the analyzer result are not needed, and bounding them classifies the secrets page by page
+	analyzerConfig.MaxSecretNames = 1
+	analyzerConfig.Findings = func(finding analyzer.Finding) {
+		result.add(finding, config.MaxSecretNames)
+	}
+	if _, err := analyzer.New().Analyze(ctx, source, analyzerConfig); err != nil {
+		return Result{}, fmt.Errorf("failed to scan etcd: %w", err)
+	}
+	return result, nil
+}
+
+// newResult returns the empty result of the proposed configuration for resource.
+func newResult(proposed analyzer.EncryptionConfiguration, resource string) Result {
+	result := Result{WriteProvider: analyzer.IdentityProviderName, ProviderCounts: map[string]int{}}
+	if !proposed.Covers(resource) {
+		// The API server stores uncovered resources in plaintext
+		result.ReadProviders = []string{analyzer.IdentityProviderName}
+		return result
+	}
+	for _, provider := range proposed.ProvidersOf(resource) {
+		name := provider.Type()
+		if provider.KMS != nil {
+			name = provider.KMS.Name
+		}
+		// The API server rejects providers of unknown types, which cannot read anything
+		if name != "" {
+			result.ReadProviders = append(result.ReadProviders, name)
+		}
+	}
+	if len(result.ReadProviders) > 0 {
+		result.WriteProvider = result.ReadProviders[0]
+	}
+	return result
+}
+
+// add evaluates the secret of finding.
+func (r *Result) add(finding analyzer.Finding, maxNames int) {
+	provider := finding.Provider
+	if finding.ProviderType != "kms" {
+		// Values without an encryption prefix, unrecognized ones included, are read by identity
+		provider = finding.ProviderType
+	}
+	r.ProviderCounts[provider]++
+	switch r.Outcome(provider) {
+	case OutcomeUpToDate:
+		r.UpToDate++
+	case OutcomeStale:
+		r.Stale++
+	default:
+		r.Undecryptable++
+		if maxNames <= 0 || len(r.UndecryptableSecrets) < maxNames {
+			r.UndecryptableSecrets = append(r.UndecryptableSecrets, namespacedName(finding))
+		}
+	}
+}
+
+// Render writes the result as a summary followed by a table of the outcome of the secrets per provider,
+// and the undecryptable secrets.
+func (r Result) Render(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Write provider:\t%s\n", r.WriteProvider)
+	fmt.Fprintf(tw, "Read providers:\t%s\n", strings.Join(r.ReadProviders, ", "))
+	fmt.Fprintf(tw, "Secrets:\t%d\n", r.Total())
+	fmt.Fprintf(tw, "Up to date:\t%d\n", r.UpToDate)
+	fmt.Fprintf(tw, "Stale:\t%d\n", r.Stale)
+	fmt.Fprintf(tw, "Undecryptable:\t%d\n", r.Undecryptable)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	providers := make([]string, 0, len(r.ProviderCounts))
+	for provider := range r.ProviderCounts {
+		providers = append(providers, provider)
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		ci, cj := r.ProviderCounts[providers[i]], r.ProviderCounts[providers[j]]
+		return ci > cj || ci == cj && providers[i] < providers[j]
+	})
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "PROVIDER\tSECRETS\tOUTCOME")
+	for _, provider := range providers {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", provider, r.ProviderCounts[provider], r.Outcome(provider))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if r.Undecryptable == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Undecryptable secrets:")
+	for _, name := range r.UndecryptableSecrets {
+		fmt.Fprintf(w, "  %s\n", name)
+	}
+	if omitted := r.Undecryptable - len(r.UndecryptableSecrets); omitted > 0 {
+		fmt.Fprintf(w, "  ... and %d more\n", omitted)
+	}
+	return nil
+}
+
+// namespacedName returns the secret of finding as "<namespace>/<name>", or "<name>" if it is cluster-scoped.
+func namespacedName(finding analyzer.Finding) string {
+	if finding.Namespace == "" {
+		return finding.Name
+	}
+	return finding.Namespace + "/" + finding.Name
+}
@@ -0,0 +1,150 @@
+package simulation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/etcd"
+)
+
+// failingSource fails every request
+type failingSource struct{}
+
+func (failingSource) Get(context.Context, string, ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	return nil, errors.New("connection refused")
+}
+
+func secrets(values ...string) *etcd.MemoryClient {
+	var kvs []*mvccpb.KeyValue
+	for i, value := range values {
+		kvs = append(kvs, &mvccpb.KeyValue{Key: []byte("/registry/secrets/default/" + string(rune('a'+i))), Value: []byte(value)})
+	}
+	return etcd.NewMemoryClient(kvs)
+}
+
+func parse(t *testing.T, config string) analyzer.EncryptionConfiguration {
+	encryptionConfig, err := analyzer.ParseEncryptionConfiguration([]byte(config))
+	require.NoError(t, err)
+	return encryptionConfig
+}
+
+func TestSimulate(t *testing.T) {
+	source := secrets(
+		"k8s:enc:kms:v2:kmsprovider2:data",
+		"k8s:enc:kms:v2:kmsprovider1:data",
+		"k8s:enc:kms:v2:kmsprovider1:data",
+		"k8s:enc:aescbc:v1:key1:data",
+		"k8s\x00plaintext",
+	)
+	tests := []struct {
+		name     string
+		config   string
+		maxNames int
+		expected Result
+	}{
+		{
+			name: "rotation keeping the previous provider",
+			config: `
+resources:
+- resources: ["secrets"]
+  providers:
+  - kms: {apiVersion: v2, name: kmsprovider3, endpoint: unix:///tmp/3.sock}
+  - kms: {apiVersion: v2, name: kmsprovider2, endpoint: unix:///tmp/2.sock}
+  - kms: {apiVersion: v2, name: kmsprovider1, endpoint: unix:///tmp/1.sock}
+  - aescbc: {keys: [{name: key1, secret: c2VjcmV0IGlzIHNlY3VyZQ==}]}
+  - identity: {}
+`,
+			expected: Result{
+				WriteProvider:  "kmsprovider3",
+				ReadProviders:  []string{"kmsprovider3", "kmsprovider2", "kmsprovider1", "aescbc", "identity"},
+				ProviderCounts: map[string]int{"kmsprovider2": 1, "kmsprovider1": 2, "aescbc": 1, "identity": 1},
+				Stale:          5,
+			},
+		},
+		{
+			name: "provider removed",
+			config: `
+resources:
+- resources: ["*.*"]
+  providers:
+  - kms: {apiVersion: v2, name: kmsprovider2, endpoint: unix:///tmp/2.sock}
+`,
+			maxNames: 2,
+			expected: Result{
+				WriteProvider:        "kmsprovider2",
+				ReadProviders:        []string{"kmsprovider2"},
+				ProviderCounts:       map[string]int{"kmsprovider2": 1, "kmsprovider1": 2, "aescbc": 1, "identity": 1},
+				UpToDate:             1,
+				Undecryptable:        4,
+				UndecryptableSecrets: []string{"default/b", "default/c"},
+			},
+		},
+		{
+			name: "secrets not covered",
+			config: `
+resources:
+- resources: ["configmaps"]
+  providers:
+  - kms: {apiVersion: v2, name: kmsprovider2, endpoint: unix:///tmp/2.sock}
+`,
+			expected: Result{
+				WriteProvider:        "identity",
+				ReadProviders:        []string{"identity"},
+				ProviderCounts:       map[string]int{"kmsprovider2": 1, "kmsprovider1": 2, "aescbc": 1, "identity": 1},
+				UpToDate:             1,
+				Undecryptable:        4,
+				UndecryptableSecrets: []string{"default/a", "default/b", "default/c", "default/d"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Simulate(context.Background(), source, parse(t, tt.config), Config{MaxSecretNames: tt.maxNames})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+			assert.Equal(t, 5, result.Total())
+		})
+	}
+
+	_, err := Simulate(context.Background(), failingSource{}, analyzer.EncryptionConfiguration{}, Config{})
+	assert.ErrorContains(t, err, "failed to scan etcd")
+}
+
+func TestResult_Render(t *testing.T) {
+	result := Result{
+		WriteProvider:        "kmsprovider2",
+		ReadProviders:        []string{"kmsprovider2", "identity"},
+		ProviderCounts:       map[string]int{"kmsprovider2": 1, "kmsprovider1": 3, "identity": 1},
+		UpToDate:             1,
+		Stale:                1,
+		Undecryptable:        3,
+		UndecryptableSecrets: []string{"default/b", "default/c"},
+	}
+	var out bytes.Buffer
+	require.NoError(t, result.Render(&out))
+	assert.Equal(t, `Write provider:  kmsprovider2
+Read providers:  kmsprovider2, identity
+Secrets:         5
+Up to date:      1
+Stale:           1
+Undecryptable:   3
+
+PROVIDER      SECRETS  OUTCOME
+kmsprovider1  3        undecryptable
+identity      1        stale
+kmsprovider2  1        up to date
+
+Undecryptable secrets:
+  default/b
+  default/c
+  ... and 1 more
+`, out.String())
+}
@@ -0,0 +1,157 @@
+// Package sinks sends notifications to the sinks declared in NotificationSink custom resources, so that
+// destinations can be added, changed and removed without changing flags or restarting the reporter.
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/lzhecheng/kms-reporter/pkg/notifier"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// API group, version and resource of NotificationSink
+const (
+	Group    = "kms-reporter.io"
+	Version  = "v1alpha1"
+	Resource = "notificationsinks"
+)
+
+// NotificationSink declares an HTTP endpoint notifications are sent to.
+type NotificationSink struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec"`
+}
+
+// Spec is the configuration of a sink, with the meaning and defaults of notifier.Config.
+type Spec struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method,omitempty"`
+	Template    string            `json:"template,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Timeout     *metav1.Duration  `json:"timeout,omitempty"`
+	// Events are the events sent to the sink, e.g. notifier.EventAlertFiring. All events are sent when empty.
+	Events []string `json:"events,omitempty"`
+	// Suspend stops sending notifications to the sink without deleting it.
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// notificationSinkList is a list of NotificationSinks, as returned by the API server.
+type notificationSinkList struct {
+	Items []NotificationSink `json:"items"`
+}
+
+// Config configures the sinks.
+type Config struct {
+	// Namespace is the namespace the NotificationSinks are declared in.
+	Namespace string
+	// RequestTimeout bounds the listing of the NotificationSinks. 0 disables the limit.
+	RequestTimeout time.Duration
+}
+
+// Sinks is a notifier.Sender sending notifications to the sinks declared in its namespace.
+type Sinks struct {
+	client rest.Interface
+	config Config
+
+	mu sync.Mutex
+	// notifiers caches the notifier of each sink by name, built from the resource version it holds
+	notifiers map[string]cachedNotifier
+}
+
+// cachedNotifier is the notifier built from a version of a NotificationSink.
+type cachedNotifier struct {
+	resourceVersion string
+	notifier        *notifier.Notifier
+	err             error
+}
+
+// NewSinks returns the sinks declared in the cluster client is connected to, e.g. the REST client of the
+// discovery client.
+func NewSinks(client rest.Interface, config Config) *Sinks {
+	return &Sinks{client: client, config: config, notifiers: map[string]cachedNotifier{}}
+}
+
+// RequiredPermissions lists the Kubernetes API access the sinks need.
+func RequiredPermissions(namespace string) []rbac.Permission {
+	return []rbac.Permission{{Verb: "list", Group: Group, Resource: Resource, Namespace: namespace}}
+}
+
+// Notify sends notification to every sink of the namespace subscribed to its event. The sinks are listed
+// for every notification, so that changes apply from the next one. A sink that is invalid or fails does
+// not prevent the other sinks from being notified; the errors of all failures are joined.
+func (s *Sinks) Notify(ctx context.Context, notification notifier.Notification) error {
+	sinks, err := s.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notifiers := make(map[string]cachedNotifier, len(sinks))
+	var errs []error
+	for _, sink := range sinks {
+		cached, ok := s.notifiers[sink.Name]
+		if !ok || cached.resourceVersion != sink.ResourceVersion {
+			cached = cachedNotifier{resourceVersion: sink.ResourceVersion}
+			cached.notifier, cached.err = newNotifier(sink.Spec)
+		}
+		// Sinks deleted since the previous notification are dropped from the cache
+		notifiers[sink.Name] = cached
+		if sink.Spec.Suspend || (len(sink.Spec.Events) > 0 && !slices.Contains(sink.Spec.Events, notification.Event)) {
+			continue
+		}
+		if cached.err != nil {
+			errs = append(errs, fmt.Errorf("invalid NotificationSink %s: %w", sink.Name, cached.err))
+			continue
+		}
+		if err := cached.notifier.Notify(ctx, notification); err != nil {
+			errs = append(errs, fmt.Errorf("NotificationSink %s: %w", sink.Name, err))
+		}
+	}
+	s.notifiers = notifiers
+	return errors.Join(errs...)
+}
+
+// list returns the NotificationSinks of the namespace.
+func (s *Sinks) list(ctx context.Context) ([]NotificationSink, error) {
+	requestCtx, cancel := utils.ContextWithTimeout(ctx, s.config.RequestTimeout)
+	defer cancel()
+	data, err := s.client.Get().
+		AbsPath("/apis", Group, Version, "namespaces", s.config.Namespace, Resource).
+		DoRaw(requestCtx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NotificationSinks: %w", err)
+	}
+	var list notificationSinkList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal NotificationSinks: %w", err)
+	}
+	return list.Items, nil
+}
+
+// newNotifier returns the notifier of spec.
+func newNotifier(spec Spec) (*notifier.Notifier, error) {
+	config := notifier.Config{
+		URL:         spec.URL,
+		Method:      spec.Method,
+		Template:    spec.Template,
+		ContentType: spec.ContentType,
+		Headers:     spec.Headers,
+	}
+	if spec.Timeout != nil {
+		config.Timeout = spec.Timeout.Duration
+	}
+	return notifier.New(config)
+}
@@ -0,0 +1,118 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/lzhecheng/kms-reporter/pkg/notifier"
+)
+
+const sinksPath = "/apis/kms-reporter.io/v1alpha1/namespaces/kube-system/notificationsinks"
+
+func notification(event string) notifier.Notification {
+	return notifier.Notification{Event: event, Message: "message", Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// sink returns a NotificationSink named name at resource version version
+func sink(name, version string, spec Spec) NotificationSink {
+	return NotificationSink{ObjectMeta: metav1.ObjectMeta{Name: name, ResourceVersion: version}, Spec: spec}
+}
+
+// newTestSinks returns sinks listing the sinks returned by list at the time of each request
+func newTestSinks(t *testing.T, list func() []NotificationSink) *Sinks {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != sinksPath {
+			http.NotFound(w, r)
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(notificationSinkList{Items: list()}))
+	}))
+	t.Cleanup(server.Close)
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	require.NoError(t, err)
+	return NewSinks(clientset.Discovery().RESTClient(), Config{Namespace: "kube-system"})
+}
+
+// endpoint is an HTTP endpoint recording the bodies it receives
+type endpoint struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newEndpoint(t *testing.T) *endpoint {
+	e := &endpoint{}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.bodies = append(e.bodies, string(body))
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func (e *endpoint) received() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.bodies
+}
+
+func TestSinks_Notify(t *testing.T) {
+	all, firing := newEndpoint(t), newEndpoint(t)
+	declared := []NotificationSink{
+		sink("all", "1", Spec{URL: all.URL, Template: "{{.Event}}"}),
+		sink("firing", "1", Spec{URL: firing.URL, Template: "{{.Event}}", Events: []string{notifier.EventAlertFiring}}),
+		sink("suspended", "1", Spec{URL: all.URL, Suspend: true}),
+	}
+	sinks := newTestSinks(t, func() []NotificationSink { return declared })
+
+	require.NoError(t, sinks.Notify(context.Background(), notification(notifier.EventAlertFiring)))
+	require.NoError(t, sinks.Notify(context.Background(), notification(notifier.EventRotationComplete)))
+	assert.Equal(t, []string{"AlertFiring", "RotationComplete"}, all.received())
+	assert.Equal(t, []string{"AlertFiring"}, firing.received())
+
+	// Changes apply from the next notification, and deleted sinks are dropped
+	declared = []NotificationSink{sink("all", "2", Spec{URL: all.URL, Template: "changed {{.Event}}"})}
+	require.NoError(t, sinks.Notify(context.Background(), notification(notifier.EventAlertResolved)))
+	assert.Equal(t, []string{"AlertFiring", "RotationComplete", "changed AlertResolved"}, all.received())
+	assert.Len(t, firing.received(), 1)
+	assert.Len(t, sinks.notifiers, 1)
+}
+
+func TestSinks_Notify_Errors(t *testing.T) {
+	valid := newEndpoint(t)
+	sinks := newTestSinks(t, func() []NotificationSink {
+		return []NotificationSink{
+			sink("no-url", "1", Spec{}),
+			sink("bad-template", "1", Spec{URL: valid.URL, Template: "{{"}),
+			sink("valid", "1", Spec{URL: valid.URL}),
+		}
+	})
+	err := sinks.Notify(context.Background(), notification(notifier.EventAlertFiring))
+	assert.ErrorContains(t, err, "invalid NotificationSink no-url: notifier URL is required")
+	assert.ErrorContains(t, err, "invalid NotificationSink bad-template: failed to parse notifier template")
+	// Invalid sinks do not prevent valid ones from being notified
+	assert.Len(t, valid.received(), 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	require.NoError(t, err)
+	err = NewSinks(clientset.Discovery().RESTClient(), Config{Namespace: "kube-system"}).Notify(context.Background(), notification(notifier.EventAlertFiring))
+	assert.ErrorContains(t, err, "failed to list NotificationSinks")
+}
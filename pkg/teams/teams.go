@@ -0,0 +1,202 @@
+// Package teams builds filtered views of a report, one per team, covering only the secrets of the
+// namespaces of the team. Each team reads its own report without learning about the secrets of the others,
+// while the full report is still written for the platform team.
+package teams
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+// Team selects the namespaces of a team and where its report is written.
+type Team struct {
+	// Name names the report of the team, kms-reporter-team-<name>.
+	Name string `json:"name"`
+	// Namespaces are glob patterns, as in path.Match, of the namespaces of the team, e.g. "payments-*".
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects the namespaces of the team by their labels. A namespace matching either
+	// Namespaces or NamespaceSelector belongs to the team.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// ReportNamespace is the namespace the report of the team is written to.
+	ReportNamespace string `json:"reportNamespace"`
+}
+
+// file is the layout of a teams file.
+type file struct {
+	Teams []Team `json:"teams"`
+}
+
+// ReadTeams reads the teams defined in the JSON or YAML file at path, under a top-level "teams" list.
+func ReadTeams(path string) ([]Team, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read teams: %w", err)
+	}
+	var teams file
+	if err := yaml.UnmarshalStrict(data, &teams); err != nil {
+		return nil, fmt.Errorf("failed to parse teams in %s: %w", path, err)
+	}
+	if err := Validate(teams.Teams); err != nil {
+		return nil, fmt.Errorf("invalid teams in %s: %w", path, err)
+	}
+	return teams.Teams, nil
+}
+
+// Validate checks that every team has a unique name usable in a ConfigMap name, a report namespace,
+// and valid namespace patterns and selector.
+func Validate(teams []Team) error {
+	names := map[string]bool{}
+	for i, team := range teams {
+		if errs := validation.IsDNS1123Label(team.Name); len(errs) > 0 {
+			return fmt.Errorf("team %d: invalid name %q: %s", i, team.Name, errs[0])
+		}
+		if names[team.Name] {
+			return fmt.Errorf("team %s is defined twice", team.Name)
+		}
+		names[team.Name] = true
+		if team.ReportNamespace == "" {
+			return fmt.Errorf("team %s: reportNamespace is required", team.Name)
+		}
+		if len(team.Namespaces) == 0 && team.NamespaceSelector == nil {
+			return fmt.Errorf("team %s: namespaces or namespaceSelector is required", team.Name)
+		}
+		for _, pattern := range team.Namespaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("team %s: invalid namespace pattern %q: %w", team.Name, pattern, err)
+			}
+		}
+		if team.NamespaceSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(team.NamespaceSelector); err != nil {
+				return fmt.Errorf("team %s: invalid namespaceSelector: %w", team.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RequiredPermissions lists the Kubernetes API access the views of teams need: listing the namespaces
+// when a team selects them by label.
+func RequiredPermissions(teams []Team) []rbac.Permission {
+	for _, team := range teams {
+		if team.NamespaceSelector != nil {
+			return []rbac.Permission{{Verb: "list", Resource: "namespaces"}}
+		}
+	}
+	return nil
+}
+
+// Config configures the views.
+type Config struct {
+	Teams []Team
+	// RequestTimeout bounds the listing of the namespaces. 0 disables the limit.
+	RequestTimeout time.Duration
+}
+
+// View is the findings of the secrets of a team.
+type View struct {
+	Team     Team
+	Findings []analyzer.Finding
+}
+
+// Views collects the findings of each team during an analysis. Each analysis calls Begin, Observe for
+// every secret analyzed, then Views once the analysis is complete.
+type Views struct {
+	clientset kubernetes.Interface
+	config    Config
+
+	mu sync.Mutex
+	// selected are the namespaces each team selects by label in the current analysis
+	selected []map[string]bool
+	// views are those of the current analysis, nil until Begin
+	views []View
+}
+
+// NewViews returns views listing the namespaces of the cluster clientset is connected to, when a team
+// selects them by label.
+func NewViews(clientset kubernetes.Interface, config Config) *Views {
+	return &Views{clientset: clientset, config: config}
+}
+
+// Begin starts an analysis, resolving the namespaces the teams select by label, and discards the
+// findings of the previous one.
+func (v *Views) Begin(ctx context.Context) error {
+	selected := make([]map[string]bool, len(v.config.Teams))
+	if len(RequiredPermissions(v.config.Teams)) > 0 {
+		listCtx, cancel := utils.ContextWithTimeout(ctx, v.config.RequestTimeout)
+		defer cancel()
+		namespaces, err := v.clientset.CoreV1().Namespaces().List(listCtx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list namespaces: %w", err)
+		}
+		for i, team := range v.config.Teams {
+			if team.NamespaceSelector == nil {
+				continue
+			}
+			// Validated when the teams were read
+			selector, _ := metav1.LabelSelectorAsSelector(team.NamespaceSelector)
+			selected[i] = map[string]bool{}
+			for _, namespace := range namespaces.Items {
+				if selector.Matches(labels.Set(namespace.Labels)) {
+					selected[i][namespace.Name] = true
+				}
+			}
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.selected = selected
+	v.views = make([]View, len(v.config.Teams))
+	for i, team := range v.config.Teams {
+		v.views[i] = View{Team: team, Findings: []analyzer.Finding{}}
+	}
+	return nil
+}
+
+// Observe adds finding to the views of the teams its namespace belongs to.
+func (v *Views) Observe(finding analyzer.Finding) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for i := range v.views {
+		if v.belongs(i, finding.Namespace) {
+			v.views[i].Findings = append(v.views[i].Findings, finding)
+		}
+	}
+}
+
+// Views returns the views of the current analysis, or nil if none began.
+func (v *Views) Views() []View {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.views
+}
+
+// belongs reports whether namespace belongs to the ith team.
+func (v *Views) belongs(i int, namespace string) bool {
+	if namespace == "" {
+		return false
+	}
+	if v.selected[i][namespace] {
+		return true
+	}
+	for _, pattern := range v.config.Teams[i].Namespaces {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package teams
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+)
+
+func TestReadTeams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "teams.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+teams:
+- name: payments
+  namespaces: ["payments", "payments-*"]
+  reportNamespace: payments
+- name: search
+  namespaceSelector:
+    matchLabels:
+      team: search
+  reportNamespace: search
+`), 0o600))
+	teams, err := ReadTeams(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Team{
+		{Name: "payments", Namespaces: []string{"payments", "payments-*"}, ReportNamespace: "payments"},
+		{Name: "search", NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "search"}}, ReportNamespace: "search"},
+	}, teams)
+	assert.Equal(t, []rbac.Permission{{Verb: "list", Resource: "namespaces"}}, RequiredPermissions(teams))
+	assert.Empty(t, RequiredPermissions(teams[:1]))
+
+	require.NoError(t, os.WriteFile(path, []byte("teams:\n- name: a\n  namespace: a\n"), 0o600))
+	_, err = ReadTeams(path)
+	assert.ErrorContains(t, err, "failed to parse teams")
+
+	_, err = ReadTeams(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(t, err, "failed to read teams")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		teams         []Team
+		expectedError string
+	}{
+		{
+			name:          "invalid name",
+			teams:         []Team{{Name: "Payments", Namespaces: []string{"payments"}, ReportNamespace: "payments"}},
+			expectedError: `team 0: invalid name "Payments"`,
+		},
+		{
+			name: "duplicate name",
+			teams: []Team{
+				{Name: "payments", Namespaces: []string{"payments"}, ReportNamespace: "payments"},
+				{Name: "payments", Namespaces: []string{"billing"}, ReportNamespace: "billing"},
+			},
+			expectedError: "team payments is defined twice",
+		},
+		{
+			name:          "missing report namespace",
+			teams:         []Team{{Name: "payments", Namespaces: []string{"payments"}}},
+			expectedError: "team payments: reportNamespace is required",
+		},
+		{
+			name:          "no namespaces",
+			teams:         []Team{{Name: "payments", ReportNamespace: "payments"}},
+			expectedError: "team payments: namespaces or namespaceSelector is required",
+		},
+		{
+			name:          "invalid pattern",
+			teams:         []Team{{Name: "payments", Namespaces: []string{"payments-["}, ReportNamespace: "payments"}},
+			expectedError: `team payments: invalid namespace pattern "payments-["`,
+		},
+		{
+			name: "invalid selector",
+			teams: []Team{{Name: "payments", ReportNamespace: "payments", NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Near"}},
+			}}},
+			expectedError: "team payments: invalid namespaceSelector",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorContains(t, Validate(tt.teams), tt.expectedError)
+		})
+	}
+}
+
+func TestViews(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "search-api", Labels: map[string]string{"team": "search"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments"}}},
+	)
+	payments := Team{Name: "payments", Namespaces: []string{"payments", "payments-*"}, ReportNamespace: "payments"}
+	search := Team{Name: "search", NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "search"}}, ReportNamespace: "search"}
+	views := NewViews(clientset, Config{Teams: []Team{payments, search}})
+	assert.Nil(t, views.Views())
+
+	findings := []analyzer.Finding{
+		{Namespace: "payments", Name: "a", Category: analyzer.CategoryEncrypted},
+		{Namespace: "payments-staging", Name: "b", Category: analyzer.CategoryUnencrypted},
+		{Namespace: "search-api", Name: "c", Category: analyzer.CategoryEncrypted},
+		{Namespace: "kube-system", Name: "d", Category: analyzer.CategoryUnencrypted},
+	}
+	require.NoError(t, views.Begin(context.Background()))
+	for _, finding := range findings {
+		views.Observe(finding)
+	}
+	assert.Equal(t, []View{
+		{Team: payments, Findings: findings[:2]},
+		{Team: search, Findings: findings[2:3]},
+	}, views.Views())
+
+	// A new analysis starts empty
+	require.NoError(t, views.Begin(context.Background()))
+	assert.Equal(t, []View{{Team: payments, Findings: []analyzer.Finding{}}, {Team: search, Findings: []analyzer.Finding{}}}, views.Views())
+}
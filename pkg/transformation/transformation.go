@@ -0,0 +1,178 @@
+// Package transformation watches the API server for envelope encryption errors. A scan of etcd only
+// shows what was written: a flapping KMS plugin makes the API server fail to encrypt writes, or decrypt
+// reads, without anything changing in etcd. The API server counts these failures in its
+// apiserver_storage_transformation_operations_total metric, which the monitor reads on every run.
+package transformation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/client-go/rest"
+
+	"github.com/lzhecheng/kms-reporter/pkg/rbac"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+const (
+	// metricsPath is the path of the API server metrics
+	metricsPath = "/metrics"
+	// operationsMetric counts the transformations of the API server by type, transformer and status
+	operationsMetric = "apiserver_storage_transformation_operations_total"
+
+	// Labels of operationsMetric
+	typeLabel   = "transformation_type"
+	prefixLabel = "transformer_prefix"
+	statusLabel = "status"
+	// envelopePrefix starts the prefix of the KMS v1 and v2 envelope transformers
+	envelopePrefix = "k8s:enc:kms:"
+	// statusOK is the status of successful transformations
+	statusOK = "OK"
+	// Transformation types: to_storage encrypts writes, from_storage decrypts reads
+	typeToStorage   = "to_storage"
+	typeFromStorage = "from_storage"
+)
+
+// Errors counts the envelope transformations that failed since the previous check.
+type Errors struct {
+	// Writes is the number of writes the API server failed to encrypt.
+	Writes int64 `json:"writes"`
+	// Reads is the number of reads the API server failed to decrypt.
+	Reads int64 `json:"reads"`
+	// Statuses counts the failures by status, e.g. DeadlineExceeded or Unavailable.
+	Statuses map[string]int64 `json:"statuses,omitempty"`
+}
+
+// Total returns the number of failed transformations.
+func (e Errors) Total() int64 {
+	return e.Writes + e.Reads
+}
+
+// String summarizes the failures, e.g. for a log line or a condition message.
+func (e Errors) String() string {
+	statuses := make([]string, 0, len(e.Statuses))
+	for status, count := range e.Statuses {
+		statuses = append(statuses, fmt.Sprintf("%s: %d", status, count))
+	}
+	sort.Strings(statuses)
+	summary := fmt.Sprintf("%d writes failed to be encrypted and %d reads failed to be decrypted by the KMS provider", e.Writes, e.Reads)
+	if len(statuses) > 0 {
+		summary += " (" + strings.Join(statuses, ", ") + ")"
+	}
+	return summary
+}
+
+// Config configures the monitor.
+type Config struct {
+	// RequestTimeout bounds the request of the API server metrics. 0 disables the limit.
+	RequestTimeout time.Duration
+}
+
+// Monitor reads the envelope transformation counters of the API server and returns their increase
+// between two checks.
+type Monitor struct {
+	client rest.Interface
+	config Config
+
+	mu sync.Mutex
+	// previous holds the failure counters of the previous check by series, nil before the first check
+	previous map[string]float64
+}
+
+// NewMonitor returns a monitor reading the metrics of the API server client is connected to, e.g. the
+// REST client of the discovery client.
+func NewMonitor(client rest.Interface, config Config) *Monitor {
+	return &Monitor{client: client, config: config}
+}
+
+// RequiredPermissions lists the Kubernetes API access the monitor needs.
+func RequiredPermissions() []rbac.Permission {
+	return []rbac.Permission{{Verb: "get", NonResourceURL: metricsPath}}
+}
+
+// Check returns the envelope transformations that failed since the previous check, or nil on the first
+// check, which only records the counters: they count the failures since the API server started.
+// A counter that decreased, e.g. because the API server restarted or another API server answered,
+// counts from zero.
+func (m *Monitor) Check(ctx context.Context) (*Errors, error) {
+	requestCtx, cancel := utils.ContextWithTimeout(ctx, m.config.RequestTimeout)
+	defer cancel()
+	data, err := m.client.Get().AbsPath(metricsPath).DoRaw(requestCtx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API server metrics: %w", err)
+	}
+	current, err := parseFailures(data)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.previous
+	m.previous = current
+	if previous == nil {
+		return nil, nil
+	}
+	failures := &Errors{}
+	for series, value := range current {
+		increase := value - previous[series]
+		if increase < 0 {
+			increase = value
+		}
+		if increase == 0 {
+			continue
+		}
+		transformationType, status, _ := strings.Cut(series, "/")
+		switch transformationType {
+		case typeToStorage:
+			failures.Writes += int64(increase)
+		case typeFromStorage:
+			failures.Reads += int64(increase)
+		default:
+			continue
+		}
+		if failures.Statuses == nil {
+			failures.Statuses = map[string]int64{}
+		}
+		failures.Statuses[status] += int64(increase)
+	}
+	return failures, nil
+}
+
+// parseFailures returns the failed envelope transformations counted in the API server metrics, by
+// transformation type and status, summed over the envelope transformers.
+func parseFailures(data []byte) (map[string]float64, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse API server metrics: %w", err)
+	}
+	failures := map[string]float64{}
+	family, ok := families[operationsMetric]
+	if !ok {
+		// No transformation happened since the API server started
+		return failures, nil
+	}
+	for _, metric := range family.GetMetric() {
+		labels := map[string]string{}
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if !strings.HasPrefix(labels[prefixLabel], envelopePrefix) || labels[statusLabel] == statusOK {
+			continue
+		}
+		value := metric.GetCounter().GetValue()
+		// Samples without a TYPE line are untyped
+		if metric.GetUntyped() != nil {
+			value = metric.GetUntyped().GetValue()
+		}
+		failures[labels[typeLabel]+"/"+labels[statusLabel]] += value
+	}
+	return failures, nil
+}
@@ -0,0 +1,90 @@
+package transformation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// apiServerMetrics returns the metrics of an API server with the given envelope transformation counts
+func apiServerMetrics(writesOK, writesFailed, readsFailed int) string {
+	return fmt.Sprintf(`# HELP apiserver_storage_transformation_operations_total [ALPHA] Total number of transformations.
+# TYPE apiserver_storage_transformation_operations_total counter
+apiserver_storage_transformation_operations_total{status="OK",transformation_type="to_storage",transformer_prefix="k8s:enc:kms:v2:"} %d
+apiserver_storage_transformation_operations_total{status="Unavailable",transformation_type="to_storage",transformer_prefix="k8s:enc:kms:v2:"} %d
+apiserver_storage_transformation_operations_total{status="DeadlineExceeded",transformation_type="from_storage",transformer_prefix="k8s:enc:kms:v1:"} %d
+apiserver_storage_transformation_operations_total{status="Unknown",transformation_type="from_storage",transformer_prefix="k8s:enc:aescbc:v1:"} 7
+# HELP apiserver_request_total [STABLE] Counter of apiserver requests.
+# TYPE apiserver_request_total counter
+apiserver_request_total{code="200",verb="GET"} 42
+`, writesOK, writesFailed, readsFailed)
+}
+
+func newTestMonitor(t *testing.T, metrics *string) *Monitor {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, *metrics)
+	}))
+	t.Cleanup(server.Close)
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	require.NoError(t, err)
+	return NewMonitor(clientset.Discovery().RESTClient(), Config{})
+}
+
+func TestMonitor_Check(t *testing.T) {
+	metrics := apiServerMetrics(100, 2, 1)
+	monitor := newTestMonitor(t, &metrics)
+
+	// The first check only records the counters
+	failures, err := monitor.Check(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, failures)
+
+	metrics = apiServerMetrics(150, 5, 1)
+	failures, err = monitor.Check(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Errors{Writes: 3, Statuses: map[string]int64{"Unavailable": 3}}, failures)
+	assert.Equal(t, "3 writes failed to be encrypted and 0 reads failed to be decrypted by the KMS provider (Unavailable: 3)", failures.String())
+
+	metrics = apiServerMetrics(200, 5, 1)
+	failures, err = monitor.Check(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Errors{}, failures)
+
+	// A restarted API server counts from zero
+	metrics = apiServerMetrics(10, 1, 1)
+	failures, err = monitor.Check(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Errors{Writes: 1, Statuses: map[string]int64{"Unavailable": 1}}, failures)
+}
+
+func TestMonitor_Check_Errors(t *testing.T) {
+	metrics := "not { metrics"
+	monitor := newTestMonitor(t, &metrics)
+	_, err := monitor.Check(context.Background())
+	assert.ErrorContains(t, err, "failed to parse API server metrics")
+
+	// Before any transformation the metric is missing
+	metrics = "# TYPE apiserver_request_total counter\napiserver_request_total 1\n"
+	_, err = monitor.Check(context.Background())
+	require.NoError(t, err)
+	failures, err := monitor.Check(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Errors{}, failures)
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: "http://127.0.0.1:1"})
+	require.NoError(t, err)
+	monitor.client = clientset.Discovery().RESTClient()
+	_, err = monitor.Check(context.Background())
+	assert.ErrorContains(t, err, "failed to get API server metrics")
+}
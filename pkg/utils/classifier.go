@@ -0,0 +1,81 @@
+package utils
+
+import "fmt"
+
+// Classification is the encryption of a value, as found by a Classifier.
+type Classification struct {
+	// ProviderType is the encryption provider type, e.g. "kms" or "aescbc". Values of type "kms" are
+	// encrypted by a KMS provider and compared against the latest provider. Defaults to "identity",
+	// for values stored in plaintext.
+	ProviderType string
+	// ProviderName is the KMS provider name. It is required for values of type "kms".
+	ProviderName string
+	// Seq is the ordering sequence of ProviderName. When 0, it is parsed from ProviderName with the
+	// provider name matcher, as for values the built-in parser recognizes.
+	Seq int
+	// KeyID is the ID of the key encryption key, if known.
+	KeyID string
+}
+
+// Classifier recognizes encrypted values written in a format the built-in parser does not know, e.g.
+// by an API server with a custom envelope prefix. The built-in parser of "k8s:enc:" values and
+// plaintext values classifies the values no classifier recognizes.
+type Classifier interface {
+	// Classify returns the classification of the value of the etcd key, and false if it does not
+	// recognize the value. An error fails the parsing of a value it recognizes but cannot decode.
+	// key and value must not be retained.
+	Classify(key, value []byte) (Classification, bool, error)
+}
+
+// ClassifierFunc adapts a function to a Classifier.
+type ClassifierFunc func(key, value []byte) (Classification, bool, error)
+
+// Classify calls f.
+func (f ClassifierFunc) Classify(key, value []byte) (Classification, bool, error) {
+	return f(key, value)
+}
+
+// SetClassifiers makes the parser try classifiers, in order, before the built-in parser. The first
+// classifier recognizing a value classifies it.
+func (p *ObjectParser) SetClassifiers(classifiers []Classifier) {
+	p.classifiers = classifiers
+}
+
+// classify fills the encryption of obj with the first of the parser classifiers recognizing v. It
+// returns false if none does.
+func (p *ObjectParser) classify(k, v []byte, obj *ParsedObject) (bool, error) {
+	for _, classifier := range p.classifiers {
+		classification, ok, err := classifier.Classify(k, v)
+		if err != nil {
+			return true, fmt.Errorf("%w: %w", ErrInvalidValueFormat, err)
+		}
+		if !ok {
+			continue
+		}
+		obj.ProviderType = identityProviderType
+		if classification.ProviderType != "" {
+			obj.ProviderType = p.intern([]byte(classification.ProviderType))
+		}
+		obj.Encrypted = obj.ProviderType == kmsProviderType
+		switch {
+		case obj.ProviderType == identityProviderType:
+			obj.Encoding = DetectEncoding(v)
+		case obj.Encrypted && classification.ProviderName == "":
+			return true, fmt.Errorf("%w: classifier returned no KMS provider name", ErrInvalidValueFormat)
+		}
+		if !obj.Encrypted {
+			return true, nil
+		}
+		obj.KeyID = classification.KeyID
+		if classification.Seq != 0 {
+			obj.ProviderName = p.intern([]byte(classification.ProviderName))
+			obj.Seq = classification.Seq
+			return true, nil
+		}
+		provider := p.provider([]byte(classification.ProviderName))
+		obj.ProviderName = provider.name
+		obj.Seq = provider.seq
+		return true, provider.err
+	}
+	return false, nil
+}
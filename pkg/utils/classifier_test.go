@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// forkClassifier recognizes the envelope of a forked API server: fork:enc:kms:<provider>:<data>
+var forkClassifier = ClassifierFunc(func(key, value []byte) (Classification, bool, error) {
+	rest, ok := bytes.CutPrefix(value, []byte("fork:enc:kms:"))
+	if !ok {
+		return Classification{}, false, nil
+	}
+	providerName, _, found := bytes.Cut(rest, []byte(":"))
+	if !found {
+		return Classification{}, true, errors.New("missing provider name")
+	}
+	return Classification{ProviderType: "kms", ProviderName: string(providerName)}, true, nil
+})
+
+func TestObjectParser_SetClassifiers(t *testing.T) {
+	// Sequences are given by the classifier, without a provider name to parse them from
+	sequenced := ClassifierFunc(func(key, value []byte) (Classification, bool, error) {
+		if !bytes.HasPrefix(value, []byte("vault:")) {
+			return Classification{}, false, nil
+		}
+		return Classification{ProviderType: "kms", ProviderName: "vault", Seq: 7, KeyID: "key-7"}, true, nil
+	})
+	plaintext := ClassifierFunc(func(key, value []byte) (Classification, bool, error) {
+		if !bytes.HasPrefix(value, []byte("plain:")) {
+			return Classification{}, false, nil
+		}
+		return Classification{}, true, nil
+	})
+
+	tests := []struct {
+		name          string
+		key           string
+		value         string
+		expected      ParsedObject
+		expectedError error
+	}{
+		{
+			name:  "custom envelope",
+			key:   "/registry/secrets/default/a",
+			value: "fork:enc:kms:kmsprovider2:data",
+			expected: ParsedObject{
+				Encrypted: true, ProviderType: "kms", ProviderName: "kmsprovider2", Seq: 2,
+				Resource: "secrets", Namespace: "default", Name: "a",
+			},
+		},
+		{
+			name:  "sequence and key ID from the classifier",
+			key:   "/registry/secrets/default/a",
+			value: "vault:data",
+			expected: ParsedObject{
+				Encrypted: true, ProviderType: "kms", ProviderName: "vault", Seq: 7, KeyID: "key-7",
+				Resource: "secrets", Namespace: "default", Name: "a",
+			},
+		},
+		{
+			name:  "plaintext defaults to identity",
+			key:   "/registry/secrets/default/a",
+			value: "plain:{}",
+			expected: ParsedObject{
+				ProviderType: "identity", Encoding: EncodingUnknown,
+				Resource: "secrets", Namespace: "default", Name: "a",
+			},
+		},
+		{
+			name:  "unrecognized values fall back to the built-in parser",
+			key:   "/registry/secrets/default/a",
+			value: "k8s:enc:kms:v2:kmsprovider3:data",
+			expected: ParsedObject{
+				Encrypted: true, ProviderType: "kms", ProviderName: "kmsprovider3", Seq: 3,
+				Resource: "secrets", Namespace: "default", Name: "a",
+			},
+		},
+		{
+			name:          "classifier error",
+			key:           "/registry/secrets/default/a",
+			value:         "fork:enc:kms:data",
+			expected:      ParsedObject{Resource: "secrets", Namespace: "default", Name: "a"},
+			expectedError: ErrInvalidValueFormat,
+		},
+		{
+			name:  "provider name mismatch",
+			key:   "/registry/secrets/default/a",
+			value: "fork:enc:kms:other:data",
+			expected: ParsedObject{
+				Encrypted: true, ProviderType: "kms", ProviderName: "other",
+				Resource: "secrets", Namespace: "default", Name: "a",
+			},
+			expectedError: ErrProviderNameMismatch,
+		},
+		{
+			name:          "key errors take precedence",
+			key:           "secrets",
+			value:         "fork:enc:kms:data",
+			expected:      ParsedObject{},
+			expectedError: ErrInvalidKeyFormat,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewObjectParser(mustProviderMatcher(t, "kmsprovider"))
+			parser.SetClassifiers([]Classifier{forkClassifier, sequenced, plaintext})
+			obj, err := parser.Parse([]byte(tt.key), []byte(tt.value))
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, parsed(tt.expected), obj)
+		})
+	}
+}
+
+func TestObjectParser_SetClassifiers_MissingProviderName(t *testing.T) {
+	parser := NewObjectParser(nil)
+	parser.SetClassifiers([]Classifier{ClassifierFunc(func(key, value []byte) (Classification, bool, error) {
+		return Classification{ProviderType: "kms"}, true, nil
+	})})
+	_, err := parser.Parse([]byte("/registry/secrets/default/a"), []byte("data"))
+	assert.ErrorIs(t, err, ErrInvalidValueFormat)
+}
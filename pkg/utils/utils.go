@@ -1,55 +1,529 @@
 package utils
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"sigs.k8s.io/yaml"
 )
 
 // Sample key: /registry/secrets/kube-system/bootstrap-token-ldeus6
 // Sample value: k8s:enc:kms:v2:kmsprovider1:<some-value>
 
 const (
+	etcdObjectValueEncryptedPrefix    = "k8s:enc:"
 	etcdObjectValueKmsEncryptedPrefix = "k8s:enc:kms:"
+	kmsV2Version                      = "v2"
+	identityProviderType              = "identity"
+	kmsProviderType                   = "kms"
+
+	// Storage encodings of values stored in plaintext, as detected from their prefix
+	EncodingProtobuf = "protobuf"
+	EncodingJSON     = "json"
+	EncodingCBOR     = "cbor"
+	EncodingUnknown  = "unknown"
+
+	// SeqGroupName is the named capture group holding the ordering token in a provider name regex
+	SeqGroupName = "seq"
 )
 
-// ParseEtcdObject parses etcd key and value to extract encryption status, secret name, and sequence number.
-// k: etcd key (e.g., "/registry/secrets/kube-system/bootstrap-token-ldeus6")
+var (
+	// protobufPrefix starts values the API server stores as protobuf
+	protobufPrefix = []byte("k8s\x00")
+	// cborPrefix is the self-described CBOR tag starting values the API server stores as CBOR
+	cborPrefix = []byte{0xd9, 0xd9, 0xf7}
+
+	// ErrInvalidKeyFormat is returned when an etcd key does not have the expected layout.
+	ErrInvalidKeyFormat = errors.New("invalid key format")
+	// ErrInvalidValueFormat is returned when an encrypted etcd value does not have the expected envelope.
+	ErrInvalidValueFormat = errors.New("invalid encrypted value format")
+	// ErrProviderNameMismatch is returned when a provider name does not match the provider name regex.
+	ErrProviderNameMismatch = errors.New("provider name does not match regex")
+)
+
+// ProviderNameMatcher extracts the ordering sequence from KMS provider names.
+type ProviderNameMatcher struct {
+	regex *regexp.Regexp
+	// seqIndexes are the indexes of the capture groups named SeqGroupName, in order
+	seqIndexes []int
+}
+
+// NewProviderNameMatcher builds a matcher from providerRegex, which must contain a named
+// capture group "seq" for the ordering token. Several groups may be named "seq", their tokens are
+// then joined in order, e.g. for a date split by separators. When providerRegex is empty, the matcher
+// accepts names of the form <kmsProviderName><digits>.
+func NewProviderNameMatcher(kmsProviderName, providerRegex string) (*ProviderNameMatcher, error) {
+	if providerRegex == "" {
+		providerRegex = "^" + regexp.QuoteMeta(kmsProviderName) + `(?P<` + SeqGroupName + `>\d+)$`
+	}
+
+	regex, err := regexp.Compile(providerRegex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile provider name regex: %w", err)
+	}
+	var seqIndexes []int
+	for i, name := range regex.SubexpNames() {
+		if name == SeqGroupName {
+			seqIndexes = append(seqIndexes, i)
+		}
+	}
+	if len(seqIndexes) == 0 {
+		return nil, fmt.Errorf("provider name regex %q has no named capture group %q", providerRegex, SeqGroupName)
+	}
+
+	return &ProviderNameMatcher{regex: regex, seqIndexes: seqIndexes}, nil
+}
+
+// Seq returns the ordering sequence of a provider name. The tokens of the "seq" groups must only
+// contain digits. They are joined and compared as a single number, so every group but the last must
+// have a fixed width: "2024" and "01" order as 202401, while "2024" and "1" would order as 20241.
+func (m *ProviderNameMatcher) Seq(providerName string) (int, error) {
+	matches := m.regex.FindStringSubmatch(providerName)
+	if matches == nil {
+		return 0, fmt.Errorf("%w: name %q, regex %q", ErrProviderNameMismatch, providerName, m.regex)
+	}
+
+	var token strings.Builder
+	for _, i := range m.seqIndexes {
+		token.WriteString(matches[i])
+	}
+	if token.Len() == 0 || strings.Trim(token.String(), "0123456789") != "" {
+		return 0, fmt.Errorf("sequence token %q of provider name %q must only contain digits", token.String(), providerName)
+	}
+
+	seq, err := strconv.Atoi(token.String())
+	if err != nil {
+		return 0, fmt.Errorf("sequence token %q of provider name %q is out of range", token.String(), providerName)
+	}
+	return seq, nil
+}
+
+// String returns the regex used by the matcher.
+func (m *ProviderNameMatcher) String() string {
+	return m.regex.String()
+}
+
+// ParsedObject is an etcd object decoded from its key and value.
+type ParsedObject struct {
+	// Encrypted reports whether the value is encrypted by a KMS provider.
+	Encrypted bool
+	// ProviderType is the encryption provider type from the value prefix (e.g. "kms", "aescbc"),
+	// or "identity" for values stored in plaintext.
+	ProviderType string
+	// ProviderName is the KMS provider name. It is empty unless Encrypted is set.
+	ProviderName string
+	// Encoding is the storage encoding of a value stored in plaintext: EncodingProtobuf, EncodingJSON,
+	// EncodingCBOR, or EncodingUnknown if the value has none of their prefixes, e.g. because it is
+	// corrupted. It is empty for values encrypted by any provider.
+	Encoding string
+	// Seq is the ordering sequence of ProviderName. It is 0 when the object is not encrypted
+	// or no provider name matcher was given.
+	Seq int
+	// Group is the API group of the resource when it is part of the key, as for custom resources
+	// (e.g. "cert-manager.io"). It is empty for built-in resources.
+	Group string
+	// Resource is the resource the key belongs to, e.g. "secrets".
+	Resource string
+	// KeyID is the ID of the key encryption key a KMS v2 provider encrypted the value with, or empty
+	// for other values and values whose payload cannot be decoded.
+	KeyID string
+	// ClusterScoped is set when the key has no namespace segment.
+	ClusterScoped bool
+	Namespace     string
+	Name          string
+	// namespacedName is "<namespace>/<name>" as parsed from the key, which Namespace and Name are
+	// slices of. It is empty for objects that were not parsed.
+	namespacedName string
+}
+
+// NamespacedName returns the object as "<namespace>/<name>", or "<name>" if it is cluster-scoped.
+// Parsed objects return the string their namespace and name share, without allocating.
+func (o ParsedObject) NamespacedName() string {
+	if o.ClusterScoped {
+		return o.Name
+	}
+	if o.namespacedName != "" {
+		return o.namespacedName
+	}
+	return o.Namespace + "/" + o.Name
+}
+
+// ParseObject parses an etcd key and value.
+// k: etcd key of the form /registry/<resource>/[<namespace>/]<name> or, for resources whose
+// API group is part of the key, /registry/<group>/<resource>/[<namespace>/]<name>
+// (e.g., "/registry/secrets/kube-system/bootstrap-token-ldeus6")
 // v: etcd value (e.g., "k8s:enc:kms:v2:kmsprovider1:<some-value>")
-// Returns: encrypted (bool), secret (string), seq (int), err (error)
-func ParseEtcdObject(k, v string, kmsProviderName string) (bool, string, int, error) {
+// When providerMatcher is not nil, the sequence of the KMS provider name is parsed as well.
+// To parse many objects, use an ObjectParser instead.
+func ParseObject(k, v string, providerMatcher *ProviderNameMatcher) (ParsedObject, error) {
+	return NewObjectParser(providerMatcher).Parse([]byte(k), []byte(v))
+}
+
+// KeyLayout describes where the resource segments of etcd keys start, for API servers storing
+// their keys under another root than /registry, e.g. with a different or multi-segment --etcd-prefix.
+type KeyLayout struct {
+	// Root is the storage prefix every key starts with, e.g. "/registry" or "/cluster-a/registry".
+	// Keys outside of it are invalid. Takes precedence over Resource.
+	Root string
+	// Resource is the resource of the keys, as "<resource>" or "<resource>.<group>", e.g. "secrets".
+	// When set without Root, the root of each key ends at the first segment of the resource, so that
+	// any root is tolerated. Keys without the resource are parsed as if Resource was empty.
+	Resource string
+}
+
+// ObjectParser parses etcd keys and values in place. Strings shared between objects, such as
+// resources and provider names, are interned and provider sequences are cached, so only the
+// namespace and name of each object are allocated. An ObjectParser is not safe for concurrent use.
+type ObjectParser struct {
+	providerMatcher *ProviderNameMatcher
+	classifiers     []Classifier
+	strings         map[string]string
+	providers       map[string]parsedProvider
+	// root is the "<root>/" keys start with, resource the "/[<group>/]<resource>/" searched in keys.
+	// Without either, the root is the first segment of the key.
+	root     []byte
+	resource []byte
+}
+
+// parsedProvider caches the outcome of matching a provider name.
+type parsedProvider struct {
+	name string
+	seq  int
+	err  error
+}
+
+// NewObjectParser returns a parser that also parses KMS provider sequences when providerMatcher is not nil.
+func NewObjectParser(providerMatcher *ProviderNameMatcher) *ObjectParser {
+	return &ObjectParser{
+		providerMatcher: providerMatcher,
+		strings:         map[string]string{},
+		providers:       map[string]parsedProvider{},
+	}
+}
+
+// SetKeyLayout makes the parser expect keys laid out as described by layout instead of
+// /<root>/[<group>/]<resource>/[<namespace>/]<name> with a single-segment root.
+func (p *ObjectParser) SetKeyLayout(layout KeyLayout) {
+	p.root, p.resource = nil, nil
+	switch {
+	case layout.Root != "":
+		p.root = []byte(strings.TrimSuffix(layout.Root, "/") + "/")
+	case layout.Resource != "":
+		resource, group, found := strings.Cut(layout.Resource, ".")
+		if found {
+			resource = group + "/" + resource
+		}
+		p.resource = []byte("/" + resource + "/")
+	}
+}
+
+// Parse parses an etcd key and value as described in ParseObject. k and v are not retained.
+func (p *ObjectParser) Parse(k, v []byte) (ParsedObject, error) {
+	var obj ParsedObject
+
+	if classified, err := p.classify(k, v, &obj); classified {
+		if keyErr := p.parseKey(k, &obj); keyErr != nil {
+			return obj, keyErr
+		}
+		return obj, err
+	}
+
 	// Check if the value is encrypted
-	encrypted := strings.HasPrefix(v, etcdObjectValueKmsEncryptedPrefix)
+	obj.Encrypted = bytes.HasPrefix(v, []byte(etcdObjectValueKmsEncryptedPrefix))
+	obj.ProviderType = identityProviderType
+	if rest, ok := bytes.CutPrefix(v, []byte(etcdObjectValueEncryptedPrefix)); ok {
+		if providerType, _, found := bytes.Cut(rest, []byte(":")); found {
+			obj.ProviderType = p.intern(providerType)
+		}
+	} else {
+		obj.Encoding = DetectEncoding(v)
+	}
 
-	// Parse the secret name from the key
-	// key format: /registry/secret/default/mysecret
-	keyParts := strings.Split(k, "/")
-	if len(keyParts) < 5 {
-		return encrypted, "", 0, fmt.Errorf("invalid key format: %s", k)
+	if err := p.parseKey(k, &obj); err != nil {
+		return obj, err
 	}
-	secret := fmt.Sprintf("%s/%s", keyParts[3], keyParts[4])
 
-	// Parse the sequence number from the value if encrypted
-	seq := 0
-	if encrypted {
-		// value format: k8s:enc:kms:v2:kmsprovider1:<some-value>
-		valueParts := strings.Split(v, ":")
-		if len(valueParts) < 6 {
-			return encrypted, secret, 0, fmt.Errorf("invalid encrypted value format: %s", v)
+	if !obj.Encrypted {
+		return obj, nil
+	}
+
+	// value format: k8s:enc:kms:v2:kmsprovider1:<some-value>
+	// The provider name sits between the 4th and 5th colon
+	rest := v[len(etcdObjectValueKmsEncryptedPrefix):]
+	kmsVersion, rest, found := bytes.Cut(rest, []byte(":"))
+	if !found {
+		return obj, fmt.Errorf("%w: %s", ErrInvalidValueFormat, v)
+	}
+	providerName, payload, found := bytes.Cut(rest, []byte(":"))
+	if !found {
+		return obj, fmt.Errorf("%w: %s", ErrInvalidValueFormat, v)
+	}
+	if string(kmsVersion) == kmsV2Version {
+		if keyID := kmsV2KeyID(payload); keyID != nil {
+			obj.KeyID = p.intern(keyID)
 		}
+	}
+
+	provider := p.provider(providerName)
+	obj.ProviderName = provider.name
+	if provider.err != nil {
+		return obj, provider.err
+	}
+	obj.Seq = provider.seq
 
-		seqStr := strings.TrimPrefix(valueParts[4], kmsProviderName)
-		seqInt, err := strconv.Atoi(seqStr)
-		if err != nil {
-			return encrypted, secret, 0, fmt.Errorf("failed to convert seq to int: %w", err)
+	return obj, nil
+}
+
+// encryptedObjectKeyIDField is the field number of keyID in the KMS v2 EncryptedObject message
+const encryptedObjectKeyIDField = 2
+
+// kmsV2KeyID returns the keyID of a KMS v2 EncryptedObject, or nil if payload is not one. Only the
+// fields before keyID are decoded, and they are skipped without copying.
+func kmsV2KeyID(payload []byte) []byte {
+	for len(payload) > 0 {
+		num, typ, n := protowire.ConsumeTag(payload)
+		if n < 0 {
+			return nil
+		}
+		payload = payload[n:]
+		if num == encryptedObjectKeyIDField && typ == protowire.BytesType {
+			keyID, n := protowire.ConsumeBytes(payload)
+			if n < 0 {
+				return nil
+			}
+			return keyID
 		}
-		seq = seqInt
+		if n = protowire.ConsumeFieldValue(num, typ, payload); n < 0 {
+			return nil
+		}
+		payload = payload[n:]
 	}
+	return nil
+}
 
-	return encrypted, secret, seq, nil
+// DetectEncoding returns the storage encoding of a value the API server stored in plaintext.
+func DetectEncoding(v []byte) string {
+	switch {
+	case bytes.HasPrefix(v, protobufPrefix):
+		return EncodingProtobuf
+	case bytes.HasPrefix(v, cborPrefix):
+		return EncodingCBOR
+	case bytes.HasPrefix(bytes.TrimLeft(v, " \t\r\n"), []byte("{")):
+		return EncodingJSON
+	default:
+		return EncodingUnknown
+	}
 }
 
+// provider returns the cached provider for name, matching it on first use.
+func (p *ObjectParser) provider(name []byte) parsedProvider {
+	// The map lookup with a converted key does not allocate
+	if provider, ok := p.providers[string(name)]; ok {
+		return provider
+	}
+	provider := parsedProvider{name: string(name)}
+	if p.providerMatcher != nil {
+		provider.seq, provider.err = p.providerMatcher.Seq(provider.name)
+	}
+	p.providers[provider.name] = provider
+	return provider
+}
+
+// intern returns a string equal to b, allocating it only the first time it is seen.
+func (p *ObjectParser) intern(b []byte) string {
+	if s, ok := p.strings[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	p.strings[s] = s
+	return s
+}
+
+// parseKey fills the resource, namespace and name of obj from an etcd key.
+func (p *ObjectParser) parseKey(k []byte, obj *ParsedObject) error {
+	rest, ok := p.cutRoot(k)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidKeyFormat, k)
+	}
+
+	first, rest, ok := bytes.Cut(rest, []byte("/"))
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidKeyFormat, k)
+	}
+	// Resource names never contain dots while API groups of custom resources always do
+	if bytes.IndexByte(first, '.') >= 0 {
+		obj.Group = p.intern(first)
+		if first, rest, ok = bytes.Cut(rest, []byte("/")); !ok {
+			return fmt.Errorf("%w: %s", ErrInvalidKeyFormat, k)
+		}
+	}
+	obj.Resource = p.intern(first)
+
+	start := len(k) - len(rest)
+	namespace, rest, ok := bytes.Cut(rest, []byte("/"))
+	if !ok {
+		obj.ClusterScoped = true
+		obj.Name = string(namespace)
+		return nil
+	}
+	// Object names cannot contain slashes, so segments after the name are ignored
+	name, _, _ := bytes.Cut(rest, []byte("/"))
+	// Namespace and name share a single allocation of "<namespace>/<name>"
+	obj.namespacedName = string(k[start : start+len(namespace)+1+len(name)])
+	obj.Namespace = obj.namespacedName[:len(namespace)]
+	obj.Name = obj.namespacedName[len(namespace)+1:]
+	return nil
+}
+
+// cutRoot returns the key after its storage root and the slash following it, i.e. starting at the
+// resource segments.
+func (p *ObjectParser) cutRoot(k []byte) ([]byte, bool) {
+	if p.root != nil {
+		return bytes.CutPrefix(k, p.root)
+	}
+	if p.resource != nil {
+		if i := bytes.Index(k, p.resource); i >= 0 {
+			return k[i+1:], true
+		}
+	}
+	// Drop the leading slash and the storage prefix ("registry")
+	rest, ok := bytes.CutPrefix(k, []byte("/"))
+	if ok {
+		_, rest, ok = bytes.Cut(rest, []byte("/"))
+	}
+	return rest, ok
+}
+
+// parseSecret parses a secret key and value with ParseObject, rejecting cluster-scoped keys.
+func parseSecret(k, v string, providerMatcher *ProviderNameMatcher) (ParsedObject, string, error) {
+	obj, err := ParseObject(k, v, providerMatcher)
+	if err == nil && obj.ClusterScoped {
+		err = fmt.Errorf("%w: %s", ErrInvalidKeyFormat, k)
+	}
+	if errors.Is(err, ErrInvalidKeyFormat) {
+		return obj, "", err
+	}
+	return obj, obj.NamespacedName(), err
+}
+
+// ParseEtcdObject parses etcd key and value to extract encryption status, secret name, and sequence number.
+// Returns: encrypted (bool), secret (string), seq (int), err (error)
+//
+// Deprecated: use ParseObject.
+func ParseEtcdObject(k, v string, providerMatcher *ProviderNameMatcher) (bool, string, int, error) {
+	obj, secret, err := parseSecret(k, v, providerMatcher)
+	if err != nil {
+		return obj.Encrypted, secret, 0, err
+	}
+	return obj.Encrypted, secret, obj.Seq, nil
+}
+
+// ParseEtcdObjectProviderName parses etcd key and value to extract encryption status, secret name, and
+// the KMS provider name, without interpreting the name. The provider name is empty for unencrypted values.
+// Returns: encrypted (bool), secret (string), providerName (string), err (error)
+//
+// Deprecated: use ParseObject.
+func ParseEtcdObjectProviderName(k, v string) (bool, string, string, error) {
+	obj, secret, err := parseSecret(k, v, nil)
+	if err != nil {
+		return obj.Encrypted, secret, "", err
+	}
+	return obj.Encrypted, secret, obj.ProviderName, nil
+}
+
+// ContextWithTimeout is context.WithTimeout, except that a zero timeout leaves ctx without a deadline.
+func ContextWithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// runIDKey is the context key of the run ID.
+type runIDKey struct{}
+
+// ContextWithRunID returns a copy of ctx carrying the ID of the current reporter run.
+func ContextWithRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// RunIDFromContext returns the run ID carried by ctx, or "" if there is none.
+func RunIDFromContext(ctx context.Context) string {
+	runID, _ := ctx.Value(runIDKey{}).(string)
+	return runID
+}
+
+// redactedName replaces secret names and etcd keys in the logs once names are redacted
+const redactedName = "<redacted>"
+
+// namesRedacted is set when secret names must not appear in the logs
+var namesRedacted atomic.Bool
+
+// SetRedactNames hides secret names and etcd keys from the logs of every package, for environments
+// treating secret names as sensitive.
+func SetRedactNames(redact bool) {
+	namesRedacted.Store(redact)
+}
+
+// LoggedKey returns key, an etcd key or secret name, as it may be logged.
+func LoggedKey(key string) string {
+	if namesRedacted.Load() {
+		return redactedName
+	}
+	return key
+}
+
+// Classes of the errors returned by ParseObject, as returned by ParseErrorClass
+const (
+	ParseErrorInvalidKey           = "InvalidKey"
+	ParseErrorInvalidValue         = "InvalidValue"
+	ParseErrorProviderNameMismatch = "ProviderNameMismatch"
+	ParseErrorOther                = "Other"
+)
+
+// ParseErrorClass returns the class of err, as returned by ParseObject.
+func ParseErrorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidKeyFormat):
+		return ParseErrorInvalidKey
+	case errors.Is(err, ErrInvalidValueFormat):
+		return ParseErrorInvalidValue
+	case errors.Is(err, ErrProviderNameMismatch):
+		return ParseErrorProviderNameMismatch
+	default:
+		return ParseErrorOther
+	}
+}
+
+// LoggedParseError returns err, as returned by ParseEtcdObject, as it may be logged: with names
+// redacted, only the kind of error is kept, as the message holds the key or value.
+func LoggedParseError(err error) error {
+	if !namesRedacted.Load() {
+		return err
+	}
+	for _, kind := range []error{ErrInvalidKeyFormat, ErrInvalidValueFormat, ErrProviderNameMismatch} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return errors.New(redactedName)
+}
+
+// Formats of the structured values of a report
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
 type Marshaller interface {
 	Marshal(v any) ([]byte, error)
 }
@@ -59,3 +533,28 @@ type JSONMarshaller struct{}
 func (j JSONMarshaller) Marshal(v any) ([]byte, error) {
 	return json.Marshal(v)
 }
+
+// YAMLMarshaller marshals values through their JSON field names, so that both formats have the same keys.
+type YAMLMarshaller struct{}
+
+func (y YAMLMarshaller) Marshal(v any) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+// NewMarshaller returns the marshaller of format, FormatJSON or FormatYAML.
+func NewMarshaller(format string) (Marshaller, error) {
+	switch format {
+	case FormatJSON:
+		return JSONMarshaller{}, nil
+	case FormatYAML:
+		return YAMLMarshaller{}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, must be %q or %q", format, FormatJSON, FormatYAML)
+	}
+}
+
+// Unmarshal decodes data written by any of the marshallers into v. YAML being a superset of JSON, a
+// reader does not need to know the format the value was written in.
+func Unmarshal(data []byte, v any) error {
+	return yaml.Unmarshal(data, v)
+}
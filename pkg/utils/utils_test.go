@@ -1,11 +1,131 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
+func mustProviderMatcher(tb testing.TB, kmsProviderName string) *ProviderNameMatcher {
+	matcher, err := NewProviderNameMatcher(kmsProviderName, "")
+	if err != nil {
+		tb.Fatalf("Failed to create provider name matcher: %v", err)
+	}
+	return matcher
+}
+
+func TestNewProviderNameMatcher(t *testing.T) {
+	tests := []struct {
+		name            string
+		kmsProviderName string
+		providerRegex   string
+		expectedError   string
+	}{
+		{name: "default regex from prefix", kmsProviderName: "kmsprovider"},
+		{name: "prefix with regex metacharacters", kmsProviderName: "kms.provider+"},
+		{name: "custom regex", providerRegex: `^kms-provider-v2-(?P<seq>\d{4})-(?P<seq>\d{2})$`},
+		{name: "custom regex without seq group", providerRegex: `^kms-provider-(\d+)$`, expectedError: "has no named capture group"},
+		{name: "invalid regex", providerRegex: `^kms-provider-(?P<seq>\d+$`, expectedError: "failed to compile provider name regex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := NewProviderNameMatcher(tt.kmsProviderName, tt.providerRegex)
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, matcher)
+			}
+		})
+	}
+}
+
+func TestProviderNameMatcher_Seq(t *testing.T) {
+	tests := []struct {
+		name            string
+		kmsProviderName string
+		providerRegex   string
+		providerName    string
+		expectedSeq     int
+		expectedError   string
+	}{
+		{name: "default prefix", kmsProviderName: "kmsprovider", providerName: "kmsprovider12", expectedSeq: 12},
+		{name: "default prefix is anchored", kmsProviderName: "kmsprovider", providerName: "mykmsprovider12", expectedError: "does not match"},
+		{name: "default prefix requires digits", kmsProviderName: "kmsprovider", providerName: "kmsprovider", expectedError: "does not match"},
+		{name: "prefix with metacharacters", kmsProviderName: "kms.provider", providerName: "kms.provider3", expectedSeq: 3},
+		{name: "quoted metacharacters do not match other characters", kmsProviderName: "kms.provider", providerName: "kmsXprovider3", expectedError: "does not match"},
+		{
+			name:          "date-like ordering token",
+			providerRegex: `^kms-provider-v2-(?P<seq>\d{4})-(?P<seq>\d{2})$`,
+			providerName:  "kms-provider-v2-2024-01",
+			expectedSeq:   202401,
+		},
+		{
+			name:          "token in the middle of the name",
+			providerRegex: `^azure-(?P<seq>\d+)-kms$`,
+			providerName:  "azure-7-kms",
+			expectedSeq:   7,
+		},
+		{
+			name:          "token without digits",
+			providerRegex: `^kms-(?P<seq>[a-z]+)$`,
+			providerName:  "kms-blue",
+			expectedError: `sequence token "blue" of provider name "kms-blue" must only contain digits`,
+		},
+		{
+			name:          "token with separators",
+			providerRegex: `^kms-(?P<seq>\d+-\d+)$`,
+			providerName:  "kms-2024-12",
+			expectedError: `sequence token "2024-12" of provider name "kms-2024-12" must only contain digits`,
+		},
+		{
+			name:          "empty token",
+			providerRegex: `^kms-(?P<seq>\d*)$`,
+			providerName:  "kms-",
+			expectedError: "must only contain digits",
+		},
+		{
+			name:            "token out of range",
+			kmsProviderName: "kmsprovider",
+			providerName:    "kmsprovider99999999999999999999",
+			expectedError:   `sequence token "99999999999999999999" of provider name "kmsprovider99999999999999999999" is out of range`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := NewProviderNameMatcher(tt.kmsProviderName, tt.providerRegex)
+			assert.NoError(t, err)
+
+			seq, err := matcher.Seq(tt.providerName)
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedSeq, seq)
+			}
+		})
+	}
+}
+
+func TestParseEtcdObject_CustomProviderRegex(t *testing.T) {
+	matcher, err := NewProviderNameMatcher("", `^kms-provider-v2-(?P<seq>\d{4})-(?P<seq>\d{2})$`)
+	assert.NoError(t, err)
+
+	encrypted, secret, seq, err := ParseEtcdObject("/registry/secrets/default/mysecret", "k8s:enc:kms:v2:kms-provider-v2-2024-03:data", matcher)
+	assert.NoError(t, err)
+	assert.True(t, encrypted)
+	assert.Equal(t, "default/mysecret", secret)
+	assert.Equal(t, 202403, seq)
+}
+
 func TestParseEtcdObject(t *testing.T) {
 	tests := []struct {
 		name              string
@@ -108,7 +228,7 @@ func TestParseEtcdObject(t *testing.T) {
 			kmsProviderName:   "kmsprovider",
 			expectedEncrypted: true,
 			expectedSecret:    "default/mysecret",
-			expectedError:     "failed to convert seq to int",
+			expectedError:     "provider name does not match regex",
 		},
 		{
 			name:              "encrypted value with non-numeric sequence",
@@ -117,7 +237,7 @@ func TestParseEtcdObject(t *testing.T) {
 			kmsProviderName:   "kmsprovid", // Note: different prefix to test parsing
 			expectedEncrypted: true,
 			expectedSecret:    "default/mysecret",
-			expectedError:     "failed to convert seq to int",
+			expectedError:     "provider name does not match regex",
 		},
 		{
 			name:              "encrypted value with empty sequence",
@@ -126,7 +246,7 @@ func TestParseEtcdObject(t *testing.T) {
 			kmsProviderName:   "kmsprovider",
 			expectedEncrypted: true,
 			expectedSecret:    "default/mysecret",
-			expectedError:     "failed to convert seq to int",
+			expectedError:     "provider name does not match regex",
 		},
 		{
 			name:              "edge case - key with many slashes",
@@ -174,7 +294,7 @@ func TestParseEtcdObject(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			encrypted, secret, seq, err := ParseEtcdObject(tt.key, tt.value, tt.kmsProviderName)
+			encrypted, secret, seq, err := ParseEtcdObject(tt.key, tt.value, mustProviderMatcher(t, tt.kmsProviderName))
 
 			if tt.expectedError != "" {
 				assert.Error(t, err)
@@ -189,6 +309,245 @@ func TestParseEtcdObject(t *testing.T) {
 	}
 }
 
+func TestParseObject(t *testing.T) {
+	tests := []struct {
+		name            string
+		key             string
+		value           string
+		providerMatcher *ProviderNameMatcher
+		expected        ParsedObject
+		expectedError   string
+	}{
+		{
+			name:            "KMS v2 encrypted secret",
+			key:             "/registry/secrets/kube-system/bootstrap-token-ldeus6",
+			value:           "k8s:enc:kms:v2:kmsprovider3:data",
+			providerMatcher: mustProviderMatcher(t, "kmsprovider"),
+			expected: ParsedObject{
+				Encrypted: true, ProviderType: "kms", ProviderName: "kmsprovider3", Seq: 3,
+				Resource: "secrets", Namespace: "kube-system", Name: "bootstrap-token-ldeus6",
+			},
+		},
+		{
+			name:  "KMS encrypted secret without matcher keeps the name only",
+			key:   "/registry/secrets/default/mysecret",
+			value: "k8s:enc:kms:v2:azure-keyvault-blue:data",
+			expected: ParsedObject{
+				Encrypted: true, ProviderType: "kms", ProviderName: "azure-keyvault-blue",
+				Resource: "secrets", Namespace: "default", Name: "mysecret",
+			},
+		},
+		{
+			name:  "non-KMS provider is not reported as encrypted",
+			key:   "/registry/secrets/default/mysecret",
+			value: "k8s:enc:aescbc:v1:key1:data",
+			expected: ParsedObject{
+				ProviderType: "aescbc", Resource: "secrets", Namespace: "default", Name: "mysecret",
+			},
+		},
+		{
+			name:  "plaintext secret",
+			key:   "/registry/secrets/default/mysecret",
+			value: "k8s\x00plaintext",
+			expected: ParsedObject{
+				ProviderType: "identity", Encoding: EncodingProtobuf, Resource: "secrets", Namespace: "default", Name: "mysecret",
+			},
+		},
+		{
+			name:  "CBOR secret",
+			key:   "/registry/secrets/default/mysecret",
+			value: "\xd9\xd9\xf7\xa1",
+			expected: ParsedObject{
+				ProviderType: "identity", Encoding: EncodingCBOR, Resource: "secrets", Namespace: "default", Name: "mysecret",
+			},
+		},
+		{
+			name:  "unrecognized value",
+			key:   "/registry/secrets/default/mysecret",
+			value: "garbage",
+			expected: ParsedObject{
+				ProviderType: "identity", Encoding: EncodingUnknown, Resource: "secrets", Namespace: "default", Name: "mysecret",
+			},
+		},
+		{
+			name:  "configmap",
+			key:   "/registry/configmaps/kube-system/kube-proxy",
+			value: "k8s:enc:kms:v2:kmsprovider1:data",
+			expected: ParsedObject{
+				Encrypted: true, ProviderType: "kms", ProviderName: "kmsprovider1",
+				Resource: "configmaps", Namespace: "kube-system", Name: "kube-proxy",
+			},
+		},
+		{
+			name:  "namespaced custom resource",
+			key:   "/registry/cert-manager.io/certificates/default/web",
+			value: `{"kind":"Certificate"}`,
+			expected: ParsedObject{
+				ProviderType: "identity", Encoding: EncodingJSON, Group: "cert-manager.io", Resource: "certificates", Namespace: "default", Name: "web",
+			},
+		},
+		{
+			name:  "cluster-scoped custom resource",
+			key:   "/registry/cert-manager.io/clusterissuers/letsencrypt",
+			value: "\n{\"kind\":\"ClusterIssuer\"}",
+			expected: ParsedObject{
+				ProviderType: "identity", Encoding: EncodingJSON, Group: "cert-manager.io", Resource: "clusterissuers", ClusterScoped: true, Name: "letsencrypt",
+			},
+		},
+		{
+			name:  "cluster-scoped built-in resource",
+			key:   "/registry/namespaces/default",
+			value: "k8s\x00plaintext",
+			expected: ParsedObject{
+				ProviderType: "identity", Encoding: EncodingProtobuf, Resource: "namespaces", ClusterScoped: true, Name: "default",
+			},
+		},
+		{
+			name:          "key without a name",
+			key:           "/registry/secrets",
+			value:         "data",
+			expectedError: "invalid key format",
+		},
+		{
+			name:          "group without a name",
+			key:           "/registry/cert-manager.io/certificates",
+			value:         "data",
+			expectedError: "invalid key format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, err := ParseObject(tt.key, tt.value, tt.providerMatcher)
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, parsed(tt.expected), obj)
+		})
+	}
+}
+
+// parsed returns expected with the namespaced name the parser sets on namespaced objects.
+func parsed(expected ParsedObject) ParsedObject {
+	if !expected.ClusterScoped && expected.Name != "" {
+		expected.namespacedName = expected.Namespace + "/" + expected.Name
+	}
+	return expected
+}
+
+func TestParsedObject_NamespacedName(t *testing.T) {
+	assert.Equal(t, "default/mysecret", ParsedObject{Namespace: "default", Name: "mysecret"}.NamespacedName())
+	assert.Equal(t, "letsencrypt", ParsedObject{ClusterScoped: true, Name: "letsencrypt"}.NamespacedName())
+}
+
+func TestParseEtcdObject_SentinelErrors(t *testing.T) {
+	matcher := mustProviderMatcher(t, "kmsprovider")
+
+	_, _, _, err := ParseEtcdObject("/registry/secrets/default", "data", matcher)
+	assert.ErrorIs(t, err, ErrInvalidKeyFormat)
+
+	_, _, _, err = ParseEtcdObject("/registry/secrets/default/mysecret", "k8s:enc:kms:v2:kmsprovider1", matcher)
+	assert.ErrorIs(t, err, ErrInvalidValueFormat)
+
+	_, _, _, err = ParseEtcdObject("/registry/secrets/default/mysecret", "k8s:enc:kms:v2:otherprovider:data", matcher)
+	assert.ErrorIs(t, err, ErrProviderNameMismatch)
+}
+
+func TestObjectParser_CachesProviders(t *testing.T) {
+	parser := NewObjectParser(mustProviderMatcher(t, "kmsprovider"))
+
+	for i := 0; i < 2; i++ {
+		obj, err := parser.Parse([]byte("/registry/secrets/default/mysecret"), []byte("k8s:enc:kms:v2:kmsprovider4:data"))
+		assert.NoError(t, err)
+		assert.Equal(t, "kmsprovider4", obj.ProviderName)
+		assert.Equal(t, 4, obj.Seq)
+
+		// Match failures are cached too and still reported for every object
+		_, err = parser.Parse([]byte("/registry/secrets/default/other"), []byte("k8s:enc:kms:v2:otherprovider:data"))
+		assert.ErrorIs(t, err, ErrProviderNameMismatch)
+	}
+	assert.Len(t, parser.providers, 2)
+}
+
+func TestObjectParser_Parse_DoesNotRetainInput(t *testing.T) {
+	parser := NewObjectParser(nil)
+	key := []byte("/registry/secrets/default/mysecret")
+	value := []byte("k8s:enc:kms:v2:kmsprovider1:data")
+
+	obj, err := parser.Parse(key, value)
+	assert.NoError(t, err)
+
+	// Callers such as the etcd client may reuse buffers between objects
+	copy(key, "/registry/secrets/xxxxxxx/xxxxxxxx")
+	copy(value, "k8s:enc:kms:v2:xxxxxxxxxxxx:data")
+	assert.Equal(t, "default/mysecret", obj.NamespacedName())
+	assert.Equal(t, "kmsprovider1", obj.ProviderName)
+}
+
+func TestObjectParser_Parse_KeyID(t *testing.T) {
+	// A KMS v2 EncryptedObject: encryptedData, keyID, encryptedDEK
+	encryptedObject := protowire.AppendTag(nil, 1, protowire.BytesType)
+	encryptedObject = protowire.AppendBytes(encryptedObject, []byte("ciphertext"))
+	encryptedObject = protowire.AppendTag(encryptedObject, 2, protowire.BytesType)
+	encryptedObject = protowire.AppendString(encryptedObject, "key-2")
+	encryptedObject = protowire.AppendTag(encryptedObject, 3, protowire.BytesType)
+	encryptedObject = protowire.AppendBytes(encryptedObject, []byte("dek"))
+
+	tests := []struct {
+		name  string
+		value []byte
+		keyID string
+	}{
+		{name: "kms v2", value: append([]byte("k8s:enc:kms:v2:kmsprovider1:"), encryptedObject...), keyID: "key-2"},
+		{name: "kms v1", value: append([]byte("k8s:enc:kms:v1:kmsprovider1:"), encryptedObject...)},
+		{name: "undecodable payload", value: []byte("k8s:enc:kms:v2:kmsprovider1:data")},
+		{name: "truncated payload", value: append([]byte("k8s:enc:kms:v2:kmsprovider1:"), encryptedObject[:15]...)},
+		{name: "plaintext", value: []byte("k8s\x00plaintext")},
+	}
+	parser := NewObjectParser(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, err := parser.Parse([]byte("/registry/secrets/default/mysecret"), tt.value)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.keyID, obj.KeyID)
+		})
+	}
+}
+
+// benchmarkObject is a KMS v2 encrypted secret with a realistic 2KiB payload.
+func benchmarkObject() ([]byte, []byte) {
+	return []byte("/registry/secrets/kube-system/bootstrap-token-ldeus6"),
+		append([]byte("k8s:enc:kms:v2:kmsprovider3:"), make([]byte, 2048)...)
+}
+
+// BenchmarkParseEtcdObject measures the previous hot path, which converted every key and value to a string.
+func BenchmarkParseEtcdObject(b *testing.B) {
+	matcher := mustProviderMatcher(b, "kmsprovider")
+	key, value := benchmarkObject()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := ParseEtcdObject(string(key), string(value), matcher); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkObjectParser_Parse(b *testing.B) {
+	parser := NewObjectParser(mustProviderMatcher(b, "kmsprovider"))
+	key, value := benchmarkObject()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parser.Parse(key, value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func TestJSONMarshaller(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -316,24 +675,77 @@ func TestMarshaller_Interface(t *testing.T) {
 	assert.JSONEq(t, expected, string(result))
 }
 
+func TestYAMLMarshaller(t *testing.T) {
+	var marshaller Marshaller = YAMLMarshaller{}
+	result, err := marshaller.Marshal(struct {
+		Name  string         `json:"name"`
+		Count int            `json:"count,omitempty"`
+		Keys  map[string]int `json:"keys"`
+	}{Name: "secret1", Keys: map[string]int{"b": 2, "a": 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, "keys:\n  a: 1\n  b: 2\nname: secret1\n", string(result))
+}
+
+func TestNewMarshaller(t *testing.T) {
+	marshaller, err := NewMarshaller(FormatJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, JSONMarshaller{}, marshaller)
+
+	marshaller, err = NewMarshaller(FormatYAML)
+	assert.NoError(t, err)
+	assert.Equal(t, YAMLMarshaller{}, marshaller)
+
+	_, err = NewMarshaller("xml")
+	assert.ErrorContains(t, err, `unknown format "xml"`)
+}
+
+func TestUnmarshal(t *testing.T) {
+	value := map[string]int{"identity": 4, "kmsprovider1": 2}
+	for _, marshaller := range []Marshaller{JSONMarshaller{}, YAMLMarshaller{}} {
+		data, err := marshaller.Marshal(value)
+		assert.NoError(t, err)
+		var decoded map[string]int
+		assert.NoError(t, Unmarshal(data, &decoded))
+		assert.Equal(t, value, decoded)
+	}
+
+	var decoded map[string]int
+	assert.Error(t, Unmarshal([]byte("{"), &decoded))
+}
+
+func TestRedactNames(t *testing.T) {
+	_, _, _, parseErr := ParseEtcdObject("/registry/secrets/default", "k8s:enc:kms:v2:kmsprovider1:data", mustProviderMatcher(t, "kmsprovider"))
+	assert.Error(t, parseErr)
+	assert.Equal(t, "/registry/secrets/default/secret1", LoggedKey("/registry/secrets/default/secret1"))
+	assert.Equal(t, parseErr, LoggedParseError(parseErr))
+
+	SetRedactNames(true)
+	defer SetRedactNames(false)
+	assert.Equal(t, "<redacted>", LoggedKey("/registry/secrets/default/secret1"))
+	assert.Equal(t, ErrInvalidKeyFormat, LoggedParseError(parseErr))
+	assert.EqualError(t, LoggedParseError(errors.New("failed to parse default/secret1")), "<redacted>")
+}
+
 // Benchmark tests for performance
 func BenchmarkParseEtcdObject_Encrypted(b *testing.B) {
 	key := "/registry/secrets/default/benchmark-secret"
 	value := "k8s:enc:kms:v2:kmsprovider5:encrypted-benchmark-data"
+	matcher := mustProviderMatcher(b, "kmsprovider")
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _, _, _ = ParseEtcdObject(key, value, "kmsprovider5")
+		_, _, _, _ = ParseEtcdObject(key, value, matcher)
 	}
 }
 
 func BenchmarkParseEtcdObject_Unencrypted(b *testing.B) {
 	key := "/registry/secrets/default/benchmark-secret"
 	value := "unencrypted-benchmark-data"
+	matcher := mustProviderMatcher(b, "kmsprovider")
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _, _, _ = ParseEtcdObject(key, value, "kmsprovider")
+		_, _, _, _ = ParseEtcdObject(key, value, matcher)
 	}
 }
 
@@ -363,7 +775,7 @@ func TestParseEtcdObject_Properties(t *testing.T) {
 		}
 
 		for _, value := range testCases {
-			encrypted, _, _, err := ParseEtcdObject("/registry/secrets/ns/name", value, "kmsprovider")
+			encrypted, _, _, err := ParseEtcdObject("/registry/secrets/ns/name", value, mustProviderMatcher(t, "kmsprovider"))
 			if err == nil {
 				assert.True(t, encrypted, "encrypted value should return encrypted=true")
 			}
@@ -379,7 +791,7 @@ func TestParseEtcdObject_Properties(t *testing.T) {
 		}
 
 		for _, value := range testCases {
-			encrypted, _, _, err := ParseEtcdObject("/registry/secrets/ns/name", value, "kmsprovider")
+			encrypted, _, _, err := ParseEtcdObject("/registry/secrets/ns/name", value, mustProviderMatcher(t, "kmsprovider"))
 			if err == nil {
 				assert.False(t, encrypted, "non-encrypted value should return encrypted=false")
 			}
@@ -397,10 +809,143 @@ func TestParseEtcdObject_Properties(t *testing.T) {
 		}
 
 		for _, tc := range testCases {
-			_, secret, _, err := ParseEtcdObject(tc.key, "any-value", "kmsprovider")
+			_, secret, _, err := ParseEtcdObject(tc.key, "any-value", mustProviderMatcher(t, "kmsprovider"))
 			if err == nil {
 				assert.Equal(t, tc.expectedSecret, secret)
 			}
 		}
 	})
 }
+
+func TestParseEtcdObjectProviderName(t *testing.T) {
+	tests := []struct {
+		name                 string
+		key                  string
+		value                string
+		expectedEncrypted    bool
+		expectedSecret       string
+		expectedProviderName string
+		expectedError        string
+	}{
+		{
+			name:                 "provider name without numeric suffix",
+			key:                  "/registry/secrets/default/mysecret",
+			value:                "k8s:enc:kms:v2:azure-keyvault:encrypted-data",
+			expectedEncrypted:    true,
+			expectedSecret:       "default/mysecret",
+			expectedProviderName: "azure-keyvault",
+		},
+		{
+			name:                 "encrypted data containing colons",
+			key:                  "/registry/secrets/default/mysecret",
+			value:                "k8s:enc:kms:v2:kmsprovider1:data:with:colons",
+			expectedEncrypted:    true,
+			expectedSecret:       "default/mysecret",
+			expectedProviderName: "kmsprovider1",
+		},
+		{
+			name:              "unencrypted value has no provider",
+			key:               "/registry/secrets/default/mysecret",
+			value:             "plain-text",
+			expectedEncrypted: false,
+			expectedSecret:    "default/mysecret",
+		},
+		{
+			name:          "invalid key format",
+			key:           "/registry/secrets",
+			value:         "plain-text",
+			expectedError: "invalid key format",
+		},
+		{
+			name:              "encrypted value with too few colons",
+			key:               "/registry/secrets/default/mysecret",
+			value:             "k8s:enc:kms:v2:azure-keyvault",
+			expectedEncrypted: true,
+			expectedSecret:    "default/mysecret",
+			expectedError:     "invalid encrypted value format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encrypted, secret, providerName, err := ParseEtcdObjectProviderName(tt.key, tt.value)
+			if tt.expectedError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedEncrypted, encrypted)
+				assert.Equal(t, tt.expectedSecret, secret)
+				assert.Equal(t, tt.expectedProviderName, providerName)
+			}
+		})
+	}
+}
+
+func TestParseErrorClass(t *testing.T) {
+	_, err := ParseObject("secrets", "data", nil)
+	assert.Equal(t, ParseErrorInvalidKey, ParseErrorClass(err))
+	_, err = ParseObject("/registry/secrets/default/a", "k8s:enc:kms:v2", nil)
+	assert.Equal(t, ParseErrorInvalidValue, ParseErrorClass(err))
+	_, err = ParseObject("/registry/secrets/default/a", "k8s:enc:kms:v2:other:data", mustProviderMatcher(t, "kmsprovider"))
+	assert.Equal(t, ParseErrorProviderNameMismatch, ParseErrorClass(err))
+	assert.Equal(t, ParseErrorOther, ParseErrorClass(errors.New("unexpected")))
+}
+
+func TestContextWithTimeout(t *testing.T) {
+	ctx, cancel := ContextWithTimeout(context.Background(), time.Minute)
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+	cancel()
+	assert.Error(t, ctx.Err())
+
+	ctx, cancel = ContextWithTimeout(context.Background(), 0)
+	_, ok = ctx.Deadline()
+	assert.False(t, ok, "a zero timeout must not set a deadline")
+	cancel()
+	assert.Error(t, ctx.Err())
+}
+
+func TestRunIDFromContext(t *testing.T) {
+	assert.Empty(t, RunIDFromContext(context.Background()))
+	assert.Equal(t, "run-1", RunIDFromContext(ContextWithRunID(context.Background(), "run-1")))
+}
+
+func TestObjectParser_SetKeyLayout(t *testing.T) {
+	tests := []struct {
+		name      string
+		layout    KeyLayout
+		key       string
+		group     string
+		resource  string
+		namespace string
+		objName   string
+		wantErr   bool
+	}{
+		{name: "default layout", key: "/registry/secrets/default/mysecret", resource: "secrets", namespace: "default", objName: "mysecret"},
+		{name: "explicit root", layout: KeyLayout{Root: "/cluster-a/registry"}, key: "/cluster-a/registry/secrets/default/mysecret", resource: "secrets", namespace: "default", objName: "mysecret"},
+		{name: "explicit root with trailing slash", layout: KeyLayout{Root: "/cluster-a/registry/"}, key: "/cluster-a/registry/example.com/widgets/default/mywidget", group: "example.com", resource: "widgets", namespace: "default", objName: "mywidget"},
+		{name: "key outside of the root", layout: KeyLayout{Root: "/cluster-a/registry"}, key: "/registry/secrets/default/mysecret", wantErr: true},
+		{name: "resource found under a deep root", layout: KeyLayout{Resource: "secrets"}, key: "/tenants/cluster-a/registry/secrets/default/mysecret", resource: "secrets", namespace: "default", objName: "mysecret"},
+		{name: "resource found in a namespace named like it", layout: KeyLayout{Resource: "secrets"}, key: "/registry/secrets/secrets/mysecret", resource: "secrets", namespace: "secrets", objName: "mysecret"},
+		{name: "group resource found under a deep root", layout: KeyLayout{Resource: "widgets.example.com"}, key: "/a/b/example.com/widgets/default/mywidget", group: "example.com", resource: "widgets", namespace: "default", objName: "mywidget"},
+		{name: "resource not found", layout: KeyLayout{Resource: "secrets"}, key: "/registry/configmaps/default/myconfig", resource: "configmaps", namespace: "default", objName: "myconfig"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewObjectParser(nil)
+			parser.SetKeyLayout(tt.layout)
+			obj, err := parser.Parse([]byte(tt.key), []byte("k8s:enc:kms:v2:kmsprovider1:data"))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidKeyFormat)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.group, obj.Group)
+			assert.Equal(t, tt.resource, obj.Resource)
+			assert.Equal(t, tt.namespace, obj.Namespace)
+			assert.Equal(t, tt.objName, obj.Name)
+		})
+	}
+}
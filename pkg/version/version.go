@@ -0,0 +1,43 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build information, set at build time with
+// -ldflags "-X github.com/lzhecheng/kms-reporter/pkg/version.Version=v0.1.0 ..."
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+// Info describes the build of the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// String returns the version with its commit and build date, e.g. "v0.1.0 (commit 1a2b3c4, built 2025-01-01T00:00:00Z)".
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s)", i.Version, i.GitCommit, i.BuildDate)
+}
+
+// UserAgent returns the User-Agent of the Kubernetes clients of component, e.g. "kms-reporter/v0.1.0/reader",
+// so that API server audit logs and API Priority and Fairness attribute the calls of each client.
+func UserAgent(component string) string {
+	return fmt.Sprintf("kms-reporter/%s/%s", Version, component)
+}
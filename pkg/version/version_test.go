@@ -0,0 +1,24 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	info := Get()
+	assert.Equal(t, "dev", info.Version)
+	assert.Equal(t, "unknown", info.GitCommit)
+	assert.Equal(t, runtime.Version(), info.GoVersion)
+}
+
+func TestInfo_String(t *testing.T) {
+	info := Info{Version: "v0.1.0", GitCommit: "1a2b3c4", BuildDate: "2025-01-01T00:00:00Z", GoVersion: "go1.24.5"}
+	assert.Equal(t, "v0.1.0 (commit 1a2b3c4, built 2025-01-01T00:00:00Z)", info.String())
+}
+
+func TestUserAgent(t *testing.T) {
+	assert.Equal(t, "kms-reporter/dev/reader", UserAgent("reader"))
+}
@@ -0,0 +1,192 @@
+// Package webhook implements a validating admission webhook guarding the encryption-provider-config
+// ConfigMap: it warns about, or denies, changes removing a provider the latest report shows secrets
+// still depend on, as the API server can no longer decrypt those secrets once the provider is gone.
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klog "k8s.io/klog/v2"
+
+	"github.com/lzhecheng/kms-reporter/pkg/analyzer"
+	"github.com/lzhecheng/kms-reporter/pkg/recorder"
+	"github.com/lzhecheng/kms-reporter/pkg/utils"
+)
+
+const (
+	// encryptionProviderConfigName and encryptionConfigYAMLKey locate the encryption configuration,
+	// as read by the reader
+	encryptionProviderConfigName = "encryption-provider-config"
+	encryptionConfigYAMLKey      = "encryption-provider-config.yaml"
+
+	// secretsResource is the resource whose providers are checked
+	secretsResource = "secrets"
+
+	// maxRequestSize bounds the AdmissionReview body, well above the size of a ConfigMap
+	maxRequestSize = 3 * 1024 * 1024
+)
+
+// Config configures the webhook.
+type Config struct {
+	// Namespace is the namespace of the encryption-provider-config ConfigMap. Other ConfigMaps are admitted.
+	Namespace string
+	// Deny rejects the changes that would strand secrets instead of admitting them with a warning.
+	Deny bool
+}
+
+// ReportGetter returns the latest stored report.
+type ReportGetter func(ctx context.Context) (map[string]string, error)
+
+// Handler serves AdmissionReview requests for updates of the encryption-provider-config ConfigMap.
+type Handler struct {
+	config    Config
+	getReport ReportGetter
+}
+
+func NewHandler(config Config, getReport ReportGetter) *Handler {
+	return &Handler{config: config, getReport: getReport}
+}
+
+// ServeHTTP decodes an AdmissionReview and responds with the review of its request.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "request is not an AdmissionReview", http.StatusBadRequest)
+		return
+	}
+
+	response := h.Review(r.Context(), review.Request)
+	response.UID = review.Request.UID
+	review.Request = nil
+	review.Response = response
+	data, err := utils.JSONMarshaller{}.Marshal(review)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(data); err != nil {
+		klog.ErrorS(err, "Failed to write admission response")
+	}
+}
+
+// Review admits request unless it updates the encryption configuration so that a provider secrets are
+// still encrypted by is removed, in which case it is denied if Deny is set and admitted with a warning
+// otherwise. Requests that cannot be checked, e.g. because no report exists yet, are admitted with a warning.
+func (h *Handler) Review(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	allowed := &admissionv1.AdmissionResponse{Allowed: true}
+	if request.Operation != admissionv1.Update || request.Namespace != h.config.Namespace || request.Name != encryptionProviderConfigName {
+		return allowed
+	}
+
+	removed, err := removedProviders(request.OldObject.Raw, request.Object.Raw)
+	if err != nil {
+		klog.ErrorS(err, "Failed to compare the encryption configurations", "user", request.UserInfo.Username)
+		allowed.Warnings = []string{fmt.Sprintf("kms-reporter could not check the change: %v", err)}
+		return allowed
+	}
+	if len(removed) == 0 {
+		return allowed
+	}
+	report, err := h.getReport(ctx)
+	var providerCounts map[string]int
+	if err == nil {
+		providerCounts, err = recorder.ParseProviderCounts(report)
+	}
+	if err != nil {
+		klog.ErrorS(err, "Failed to read the report", "removedProviders", removed)
+		allowed.Warnings = []string{fmt.Sprintf("kms-reporter could not check that no secret still uses the removed providers %s: %v", strings.Join(removed, ", "), err)}
+		return allowed
+	}
+
+	var problems []string
+	for _, provider := range removed {
+		if count := providerCounts[provider]; count > 0 {
+			problems = append(problems, fmt.Sprintf("%d secrets are still encrypted by the removed provider %s", count, provider))
+		}
+	}
+	if len(problems) == 0 {
+		return allowed
+	}
+	message := strings.Join(problems, "; ") + ", according to the latest kms-reporter report. Rewrite them with the new provider before removing it"
+	klog.InfoS("Encryption configuration change strands secrets", "user", request.UserInfo.Username, "deny", h.config.Deny, "problems", problems)
+	if h.config.Deny {
+		return &admissionv1.AdmissionResponse{
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Reason:  metav1.StatusReasonForbidden,
+				Code:    http.StatusForbidden,
+				Message: message,
+			},
+		}
+	}
+	allowed.Warnings = []string{message}
+	return allowed
+}
+
+// removedProviders returns the providers decrypting secrets in the encryption configuration of the old
+// ConfigMap but not in that of the new one, named as in the provider counts of the report.
+func removedProviders(oldObject, newObject []byte) ([]string, error) {
+	oldProviders, err := secretProviders(oldObject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the old encryption configuration: %w", err)
+	}
+	newProviders, err := secretProviders(newObject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the new encryption configuration: %w", err)
+	}
+	var removed []string
+	for provider := range oldProviders {
+		if !newProviders[provider] {
+			removed = append(removed, provider)
+		}
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// secretProviders returns the providers the encryption configuration of a ConfigMap decrypts secrets with,
+// named as in the provider counts of the report: KMS providers by name and the others by type, e.g.
+// "aescbc" or "identity" for plaintext. Secrets not covered by the configuration are read in plaintext.
+func secretProviders(object []byte) (map[string]bool, error) {
+	var configMap v1.ConfigMap
+	if err := json.Unmarshal(object, &configMap); err != nil {
+		return nil, fmt.Errorf("failed to decode ConfigMap: %w", err)
+	}
+	encryptionConfigYAML, ok := configMap.Data[encryptionConfigYAMLKey]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in ConfigMap data", encryptionConfigYAMLKey)
+	}
+	encryptionConfig, err := analyzer.ParseEncryptionConfiguration([]byte(encryptionConfigYAML))
+	if err != nil {
+		return nil, err
+	}
+
+	providers := map[string]bool{}
+	entry := encryptionConfig.ProvidersOf(secretsResource)
+	if entry == nil {
+		providers[analyzer.IdentityProviderName] = true
+	}
+	for _, provider := range entry {
+		if provider.KMS != nil {
+			providers[provider.KMS.Name] = true
+		} else if providerType := provider.Type(); providerType != "" {
+			providers[providerType] = true
+		}
+	}
+	return providers, nil
+}
@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// encryptionConfigMap returns the encryption-provider-config ConfigMap encrypting secrets with providers,
+// "identity" and "aescbc" standing for the providers of these types.
+func encryptionConfigMap(t *testing.T, providers ...string) runtime.RawExtension {
+	var config strings.Builder
+	config.WriteString("apiVersion: apiserver.config.k8s.io/v1\nkind: EncryptionConfiguration\nresources:\n- resources: [secrets]\n  providers:\n")
+	for _, provider := range providers {
+		switch provider {
+		case "identity":
+			config.WriteString("  - identity: {}\n")
+		case "aescbc":
+			config.WriteString("  - aescbc: {keys: [{name: key1, secret: c2VjcmV0IGlzIHNlY3VyZSwgb3IgaXMgaXQ/Cg==}]}\n")
+		default:
+			config.WriteString("  - kms: {apiVersion: v2, name: " + provider + ", endpoint: unix:///var/run/kms.sock}\n")
+		}
+	}
+	data, err := json.Marshal(v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: encryptionProviderConfigName, Namespace: "kube-system"},
+		Data:       map[string]string{encryptionConfigYAMLKey: config.String()},
+	})
+	require.NoError(t, err)
+	return runtime.RawExtension{Raw: data}
+}
+
+func reportWithCounts(providerCounts string) ReportGetter {
+	return func(context.Context) (map[string]string, error) {
+		return map[string]string{"PROVIDER_COUNTS": providerCounts}, nil
+	}
+}
+
+func TestHandler_Review(t *testing.T) {
+	update := func(oldProviders, newProviders []string) *admissionv1.AdmissionRequest {
+		return &admissionv1.AdmissionRequest{
+			Operation: admissionv1.Update,
+			Namespace: "kube-system",
+			Name:      encryptionProviderConfigName,
+			OldObject: encryptionConfigMap(t, oldProviders...),
+			Object:    encryptionConfigMap(t, newProviders...),
+		}
+	}
+	otherConfigMap := update([]string{"kmsprovider1"}, nil)
+	otherConfigMap.Name = "other"
+	create := update(nil, []string{"kmsprovider1"})
+	create.Operation = admissionv1.Create
+	invalid := update([]string{"kmsprovider1"}, nil)
+	invalid.Object = runtime.RawExtension{Raw: []byte(`{"data":{}}`)}
+
+	tests := []struct {
+		name      string
+		request   *admissionv1.AdmissionRequest
+		getReport ReportGetter
+		deny      bool
+		allowed   bool
+		warning   string
+		denial    string
+	}{
+		{
+			name:      "provider added",
+			request:   update([]string{"kmsprovider1"}, []string{"kmsprovider2", "kmsprovider1"}),
+			getReport: reportWithCounts(`{"kmsprovider1":3}`),
+			allowed:   true,
+		},
+		{
+			name:      "unused provider removed",
+			request:   update([]string{"kmsprovider2", "kmsprovider1"}, []string{"kmsprovider2"}),
+			getReport: reportWithCounts(`{"kmsprovider2":3}`),
+			allowed:   true,
+		},
+		{
+			name:      "used provider removed",
+			request:   update([]string{"kmsprovider2", "kmsprovider1"}, []string{"kmsprovider2"}),
+			getReport: reportWithCounts(`{"kmsprovider1":1,"kmsprovider2":2}`),
+			allowed:   true,
+			warning:   "1 secrets are still encrypted by the removed provider kmsprovider1",
+		},
+		{
+			name:      "used provider removed with deny",
+			request:   update([]string{"kmsprovider2", "kmsprovider1"}, []string{"kmsprovider2"}),
+			getReport: reportWithCounts(`{"kmsprovider1":1,"kmsprovider2":2}`),
+			deny:      true,
+			denial:    "1 secrets are still encrypted by the removed provider kmsprovider1",
+		},
+		{
+			name:      "identity removed with unencrypted secrets",
+			request:   update([]string{"kmsprovider1", "identity"}, []string{"kmsprovider1"}),
+			getReport: reportWithCounts(`{"identity":4,"kmsprovider1":2}`),
+			deny:      true,
+			denial:    "4 secrets are still encrypted by the removed provider identity",
+		},
+		{
+			name:      "aescbc removed with secrets it encrypted",
+			request:   update([]string{"kmsprovider1", "aescbc", "identity"}, []string{"kmsprovider1", "identity"}),
+			getReport: reportWithCounts(`{"aescbc":3,"identity":1,"kmsprovider1":2}`),
+			deny:      true,
+			denial:    "3 secrets are still encrypted by the removed provider aescbc",
+		},
+		{
+			// The aescbc ciphertext is not counted as plaintext
+			name:      "identity removed with only aescbc secrets",
+			request:   update([]string{"kmsprovider1", "aescbc", "identity"}, []string{"kmsprovider1", "aescbc"}),
+			getReport: reportWithCounts(`{"aescbc":3,"kmsprovider1":2}`),
+			deny:      true,
+			allowed:   true,
+		},
+		{
+			name:    "report unavailable",
+			request: update([]string{"kmsprovider2", "kmsprovider1"}, []string{"kmsprovider2"}),
+			getReport: func(context.Context) (map[string]string, error) {
+				return nil, errors.New("configmaps \"kms-reporter\" not found")
+			},
+			deny:    true,
+			allowed: true,
+			warning: "could not check that no secret still uses the removed providers kmsprovider1",
+		},
+		{
+			name:    "invalid configuration",
+			request: invalid,
+			deny:    true,
+			allowed: true,
+			warning: "encryption-provider-config.yaml not found in ConfigMap data",
+		},
+		{
+			name:    "other ConfigMap",
+			request: otherConfigMap,
+			deny:    true,
+			allowed: true,
+		},
+		{
+			name:    "create",
+			request: create,
+			deny:    true,
+			allowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(Config{Namespace: "kube-system", Deny: tt.deny}, tt.getReport)
+			response := handler.Review(context.Background(), tt.request)
+			assert.Equal(t, tt.allowed, response.Allowed)
+			if tt.warning != "" {
+				require.Len(t, response.Warnings, 1)
+				assert.Contains(t, response.Warnings[0], tt.warning)
+			} else {
+				assert.Empty(t, response.Warnings)
+			}
+			if tt.denial != "" {
+				require.NotNil(t, response.Result)
+				assert.Equal(t, int32(http.StatusForbidden), response.Result.Code)
+				assert.Contains(t, response.Result.Message, tt.denial)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	handler := NewHandler(Config{Namespace: "kube-system"}, reportWithCounts(`{"kmsprovider1":3}`))
+	review, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "1234",
+			Operation: admissionv1.Update,
+			Namespace: "kube-system",
+			Name:      encryptionProviderConfigName,
+			OldObject: encryptionConfigMap(t, "kmsprovider1"),
+			Object:    encryptionConfigMap(t, "kmsprovider2"),
+		},
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(review)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response admissionv1.AdmissionReview
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "AdmissionReview", response.Kind)
+	assert.Nil(t, response.Request)
+	require.NotNil(t, response.Response)
+	assert.EqualValues(t, "1234", response.Response.UID)
+	assert.True(t, response.Response.Allowed)
+	assert.Len(t, response.Response.Warnings, 1)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader("{}")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}